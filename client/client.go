@@ -0,0 +1,265 @@
+// Package client lets Go programs get past Anubis without a browser. Its
+// Transport solves the proof-of-work challenges Anubis asks for, and sends
+// the token it gets for them with every later request to the same host.
+//
+//	c := client.New(http.DefaultClient)
+//	resp, err := c.Get("https://example.com/")
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/vale981/anubis"
+)
+
+const (
+	// reasonHeader is the header Anubis explains its responses in.
+	reasonHeader = "X-Anubis-Reason"
+
+	// reasonChallengeRequired is the reason of responses that ask for a
+	// challenge to be solved.
+	reasonChallengeRequired = "CHALLENGE_REQUIRED"
+
+	makeChallengePath = anubis.StaticPath + "api/make-challenge"
+	passChallengePath = anubis.StaticPath + "api/pass-challenge"
+)
+
+var ErrChallengeFailed = errors.New("client: can't pass anubis challenge")
+
+// Transport is an http.RoundTripper that solves Anubis challenges. When a
+// response asks for one, it solves it, keeps the token and sends the
+// request again. Requests with a body are only sent again if their GetBody
+// is set, otherwise the challenge response is returned.
+//
+// Tokens are bound to the IP address and the User-Agent of the client, so
+// requests to the same host should all use the same User-Agent.
+type Transport struct {
+	// Base sends the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	lock    sync.Mutex
+	tokens  map[string]string
+	solving sync.Mutex
+}
+
+// New returns a copy of base whose transport solves Anubis challenges. If
+// base is nil, http.DefaultClient is copied.
+func New(base *http.Client) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+
+	c := *base
+	c.Transport = &Transport{Base: base.Transport}
+	return &c
+}
+
+// Token returns the token the transport has for host, if any.
+func (t *Transport) Token(host string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.tokens[host]
+}
+
+// SetToken sets the token for host, for example one saved by an earlier run.
+func (t *Transport) SetToken(host, token string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.tokens == nil {
+		t.tokens = map[string]string{}
+	}
+	t.tokens[host] = token
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.Token(req.URL.Host)
+	resp, err := t.base().RoundTrip(withToken(req, token))
+	if err != nil || resp.Header.Get(reasonHeader) != reasonChallengeRequired {
+		return resp, err
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	if err := t.pass(req, resp, token); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.base().RoundTrip(withToken(retry, t.Token(req.URL.Host)))
+}
+
+// pass solves the challenge that resp asks for and keeps the token. If
+// another request got a new token while this one waited, that is used. It
+// closes the body of resp.
+func (t *Transport) pass(req *http.Request, resp *http.Response, token string) error {
+	t.solving.Lock()
+	defer t.solving.Unlock()
+
+	if t.Token(req.URL.Host) != token {
+		resp.Body.Close()
+		return nil
+	}
+
+	challenge, err := t.challenge(req, resp)
+	if err != nil {
+		return err
+	}
+
+	sol, err := Solve(req.Context(), challenge)
+	if err != nil {
+		return err
+	}
+
+	token, err = t.submit(req, sol)
+	if err != nil {
+		return err
+	}
+
+	t.SetToken(req.URL.Host, token)
+	return nil
+}
+
+// challenge gets the challenge for req. Anubis puts it in the response to
+// clients that asked for JSON, everyone else has to ask for one.
+func (t *Transport) challenge(req *http.Request, resp *http.Response) (Challenge, error) {
+	if resp.StatusCode == http.StatusUnauthorized && isJSON(resp.Header) {
+		defer resp.Body.Close()
+
+		var body struct {
+			Challenge *Challenge `json:"challenge"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Challenge != nil {
+			return *body.Challenge, nil
+		}
+	}
+	resp.Body.Close()
+
+	mresp, err := t.do(req, makeChallengePath, nil)
+	if err != nil {
+		return Challenge{}, err
+	}
+	defer mresp.Body.Close()
+
+	var c Challenge
+	if err := json.NewDecoder(mresp.Body).Decode(&c); err != nil {
+		return Challenge{}, fmt.Errorf("client: can't read challenge: %w", err)
+	}
+
+	return c, nil
+}
+
+// submit posts sol to Anubis and returns the token it hands out.
+func (t *Transport) submit(req *http.Request, sol Solution) (string, error) {
+	body, err := json.Marshal(sol)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := t.do(req, passChallengePath, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var passed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&passed); err != nil || passed.Token == "" {
+		return "", fmt.Errorf("%w: no token in response", ErrChallengeFailed)
+	}
+
+	return passed.Token, nil
+}
+
+// do posts body to the Anubis API endpoint path on the host of req, as the
+// same client that sent req.
+func (t *Transport) do(req *http.Request, path string, body []byte) (*http.Response, error) {
+	u := &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: path}
+
+	areq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	areq.Host = req.Host
+	areq.Header.Set("Accept", "application/json")
+	if body != nil {
+		areq.Header.Set("Content-Type", "application/json")
+	}
+	for _, h := range []string{"User-Agent", "Accept-Language"} {
+		if v := req.Header.Get(h); v != "" {
+			areq.Header.Set(h, v)
+		}
+	}
+
+	resp, err := t.base().RoundTrip(areq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+
+	return resp, nil
+}
+
+// responseError turns a failed response of the Anubis API into an error,
+// with the reason code Anubis gave.
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if isJSON(resp.Header) {
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	}
+	if body.Code == "" {
+		body.Code = resp.Header.Get(reasonHeader)
+	}
+	if body.Error == "" {
+		body.Error = resp.Status
+	}
+
+	return fmt.Errorf("%w: %s (%s)", ErrChallengeFailed, body.Error, body.Code)
+}
+
+// withToken returns a copy of req with token in the Anubis cookie.
+func withToken(req *http.Request, token string) *http.Request {
+	if token == "" {
+		return req
+	}
+
+	req = req.Clone(req.Context())
+	req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: token})
+	return req
+}
+
+func isJSON(h http.Header) bool {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
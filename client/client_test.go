@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib"
+)
+
+func spawnAnubis(t *testing.T, hits *atomic.Int64) *httptest.Server {
+	t.Helper()
+
+	pol, err := lib.LoadPoliciesOrDefault("", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol.DefaultDifficulty = 1
+
+	srv, err := lib.New(lib.Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			fmt.Fprint(w, "app")
+		}),
+		Policy: pol,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestTransport(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		accept string
+	}{
+		{name: "html challenge page"},
+		{name: "json challenge", accept: "application/json"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int64
+			ts := spawnAnubis(t, &hits)
+			c := New(ts.Client())
+
+			get := func() string {
+				t.Helper()
+
+				req, err := http.NewRequest(http.MethodGet, ts.URL+"/page", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("User-Agent", "Mozilla/5.0 "+tt.name)
+				if tt.accept != "" {
+					req.Header.Set("Accept", tt.accept)
+				}
+
+				resp, err := c.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("wanted status 200, got: %d", resp.StatusCode)
+				}
+				return string(body)
+			}
+
+			if body := get(); body != "app" {
+				t.Fatalf("wanted the page of the app, got: %q", body)
+			}
+
+			token := c.Transport.(*Transport).Token(strings.TrimPrefix(ts.URL, "http://"))
+			if token == "" {
+				t.Fatal("wanted the transport to keep the token")
+			}
+
+			if body := get(); body != "app" {
+				t.Fatalf("wanted the page of the app, got: %q", body)
+			}
+			if got := c.Transport.(*Transport).Token(strings.TrimPrefix(ts.URL, "http://")); got != token {
+				t.Error("wanted the token to be reused")
+			}
+			if got := hits.Load(); got != 2 {
+				t.Errorf("wanted 2 requests to the app, got: %d", got)
+			}
+		})
+	}
+}
+
+func TestTransportBodyWithoutGetBody(t *testing.T) {
+	var hits atomic.Int64
+	ts := spawnAnubis(t, &hits)
+	c := New(ts.Client())
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/form", io.NopCloser(strings.NewReader("a=b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get(reasonHeader); got != reasonChallengeRequired {
+		t.Errorf("wanted the challenge response, got reason: %q", got)
+	}
+}
+
+func TestSolve(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		algorithm  string
+		difficulty int
+		prefix     string
+	}{
+		{name: "fast", algorithm: "fast", difficulty: 3, prefix: "000"},
+		{name: "default", difficulty: 2, prefix: "00"},
+		{name: "scrypt", algorithm: "scrypt", difficulty: 1, prefix: "0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sol, err := Solve(context.Background(), Challenge{
+				Challenge: "abc",
+				Rules:     Rules{Difficulty: tt.difficulty, Algorithm: tt.algorithm},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(sol.Response, tt.prefix) {
+				t.Errorf("wanted a response starting with %q, got: %q", tt.prefix, sol.Response)
+			}
+			if tt.algorithm != "scrypt" {
+				if want := internal.SHA256sum(fmt.Sprintf("abc%d", sol.Nonce)); sol.Response != want {
+					t.Errorf("wanted response %q for nonce %d, got: %q", want, sol.Nonce, sol.Response)
+				}
+			}
+		})
+	}
+}
+
+func TestSolveNoJS(t *testing.T) {
+	issued := int(time.Now().Unix()) - 5
+	sol, err := Solve(context.Background(), Challenge{
+		Challenge: "abc",
+		Rules:     Rules{Difficulty: 1, Algorithm: "nojs"},
+		Nonce:     &issued,
+		Response:  "handed-out",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sol.Nonce != issued || sol.Response != "handed-out" {
+		t.Errorf("wanted the nonce and response that were handed out, got: %+v", sol)
+	}
+}
+
+func TestSolveErrors(t *testing.T) {
+	if _, err := Solve(context.Background(), Challenge{Rules: Rules{Algorithm: "quantum"}}); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("wanted ErrUnsupportedAlgorithm, got: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := Solve(ctx, Challenge{Challenge: "abc", Rules: Rules{Difficulty: 64}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wanted the search to stop with the context, got: %v", err)
+	}
+}
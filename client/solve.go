@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parameters for the memory-hard scrypt algorithm. These must match the ones
+// in lib/scrypt.go.
+const (
+	scryptN      = 1 << 12
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+var ErrUnsupportedAlgorithm = errors.New("client: unsupported challenge algorithm")
+
+// Rules are the settings of a challenge that matter to the solver.
+type Rules struct {
+	Difficulty int    `json:"difficulty"`
+	Algorithm  string `json:"algorithm"`
+}
+
+// Challenge is a challenge as Anubis hands it out on
+// /.within.website/x/cmd/anubis/api/make-challenge.
+type Challenge struct {
+	Challenge string `json:"challenge"`
+	Rules     Rules  `json:"rules"`
+
+	// Nonce and Response are only set for the nojs algorithm, whose
+	// response only Anubis can compute.
+	Nonce    *int   `json:"nonce,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+// Solution is a solved challenge, ready to be posted to
+// /.within.website/x/cmd/anubis/api/pass-challenge.
+type Solution struct {
+	Challenge   string `json:"challenge"`
+	Nonce       int    `json:"nonce"`
+	Response    string `json:"response"`
+	ElapsedTime int64  `json:"elapsedTime"`
+}
+
+// Solve finds the solution to c, using one worker per CPU. For the nojs
+// algorithm, it waits until Anubis accepts the response it handed out.
+func Solve(ctx context.Context, c Challenge) (Solution, error) {
+	start := time.Now()
+
+	var (
+		sol Solution
+		err error
+	)
+	switch c.Rules.Algorithm {
+	case "", "fast", "slow":
+		sol, err = search(ctx, c, func(calcString string) string {
+			sum := sha256.Sum256([]byte(calcString))
+			return hex.EncodeToString(sum[:])
+		})
+	case "scrypt":
+		sol, err = search(ctx, c, func(calcString string) string {
+			key, err := scrypt.Key([]byte(calcString), []byte(c.Challenge), scryptN, scryptR, scryptP, scryptKeyLen)
+			if err != nil {
+				// Only happens with invalid parameters, which are constants.
+				return ""
+			}
+			return hex.EncodeToString(key)
+		})
+	case "nojs":
+		sol, err = wait(ctx, c)
+	default:
+		return Solution{}, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, c.Rules.Algorithm)
+	}
+	if err != nil {
+		return Solution{}, err
+	}
+
+	sol.ElapsedTime = time.Since(start).Milliseconds()
+	return sol, nil
+}
+
+// search tries nonces until hash gives a response with as many leading
+// zeroes as the difficulty asks for. Worker i tries the nonces i, i+n,
+// i+2n and so on.
+func search(ctx context.Context, c Challenge, hash func(calcString string) string) (Solution, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := strings.Repeat("0", c.Rules.Difficulty)
+	workers := runtime.GOMAXPROCS(0)
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		found atomic.Bool
+		sol   Solution
+	)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for nonce := i; ; nonce += workers {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				response := hash(c.Challenge + strconv.Itoa(nonce))
+				if strings.HasPrefix(response, prefix) {
+					once.Do(func() {
+						sol = Solution{Challenge: c.Challenge, Nonce: nonce, Response: response}
+						found.Store(true)
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !found.Load() {
+		return Solution{}, parent.Err()
+	}
+	return sol, nil
+}
+
+// wait waits until the difficulty of a nojs challenge in seconds passed
+// since it was issued.
+func wait(ctx context.Context, c Challenge) (Solution, error) {
+	if c.Nonce == nil || c.Response == "" {
+		return Solution{}, fmt.Errorf("client: nojs challenge without nonce and response")
+	}
+
+	issued := time.Unix(int64(*c.Nonce), 0)
+	t := time.NewTimer(time.Until(issued.Add(time.Duration(c.Rules.Difficulty) * time.Second)))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return Solution{}, ctx.Err()
+	}
+
+	return Solution{Challenge: c.Challenge, Nonce: *c.Nonce, Response: c.Response}, nil
+}
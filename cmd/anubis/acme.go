@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vale981/anubis/lib/store"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeCertExpiry is how long certificates are kept in the shared state
+// backend. It is longer than the lifetime of ACME certificates so that
+// autocert renews them before they disappear.
+const acmeCertExpiry = 180 * 24 * time.Hour
+
+// newACMEManager creates an autocert manager that obtains certificates for
+// hostnames. Certificates are cached in cacheDir if it is set, otherwise in
+// the shared state backend if there is one, otherwise in the acme folder of
+// the state directory.
+func newACMEManager(hostnames, email, directoryURL, cacheDir, stateDir string, st store.Interface) (*autocert.Manager, error) {
+	var hosts []string
+	for _, host := range strings.Split(hostnames, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("no ACME hostnames given")
+	}
+
+	var cache autocert.Cache
+	switch {
+	case cacheDir != "":
+		cache = autocert.DirCache(cacheDir)
+	case st != nil:
+		cache = storeCertCache{st}
+	case stateDir != "":
+		cache = autocert.DirCache(filepath.Join(stateDir, "acme"))
+	default:
+		return nil, errors.New("ACME needs somewhere to store certificates, set acme-cache-dir, state-dir, or redis-url")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      cache,
+		Email:      email,
+	}
+
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+
+	return m, nil
+}
+
+// storeCertCache stores ACME account keys and certificates in the shared
+// state backend so that every replica can serve them.
+type storeCertCache struct {
+	store.Interface
+}
+
+func (s storeCertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.Interface.Get(ctx, "acme:"+key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get %s from ACME cache: %w", key, err)
+	}
+
+	return data, nil
+}
+
+func (s storeCertCache) Put(ctx context.Context, key string, data []byte) error {
+	return s.Interface.Set(ctx, "acme:"+key, data, acmeCertExpiry)
+}
+
+func (s storeCertCache) Delete(ctx context.Context, key string) error {
+	return s.Interface.Delete(ctx, "acme:"+key)
+}
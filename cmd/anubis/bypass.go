@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	libanubis "github.com/vale981/anubis/lib"
+)
+
+// bypassTokenCommand issues a bypass token signed with the configured key.
+// The token is written to w on its own, so that it can be piped into a
+// secret store. Its ID, needed to revoke it, goes to standard error.
+func bypassTokenCommand(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis bypass-token", flag.ContinueOnError)
+	name := fs.String("name", "", "who the token is for, such as uptime-monitor, shown as the rule bypass/<name>")
+	paths := fs.String("paths", "", "comma-separated list of path prefixes the token is valid for, all paths if empty")
+	ttl := fs.Duration("ttl", libanubis.MaxBypassLifetime, "how long the token is valid for, at most one year")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	priv, err := privateKeyFromFlags()
+	if err != nil {
+		return err
+	}
+	if priv == nil {
+		return errors.New("bypass tokens need the signing key of Anubis, set ED25519_PRIVATE_KEY_HEX, ED25519_PRIVATE_KEY_HEX_FILE or STATE_DIR")
+	}
+
+	var prefixes []string
+	for _, p := range strings.Split(*paths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	b, err := libanubis.NewBypassToken(*name, prefixes, *ttl)
+	if err != nil {
+		return err
+	}
+
+	token, err := b.Sign(priv)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "id: %s\nexpires: %s\n", b.ID, b.Expiry.Format(time.RFC3339))
+	_, err = fmt.Fprintln(w, token)
+	return err
+}
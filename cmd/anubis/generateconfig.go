@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+type proxyConfigParams struct {
+	Hostname string
+	Unix     bool
+	Address  string
+}
+
+var proxyConfigTemplates = map[string]*template.Template{
+	"nginx": template.Must(template.New("nginx").Parse(`# Generated by anubis generate-config nginx.
+# Put this in /etc/nginx/conf.d/anubis-{{ .Hostname }}.conf or include it in
+# the http block of your nginx.conf.
+
+upstream anubis {
+  # This must match the values of BIND and BIND_NETWORK.
+{{- if .Unix }}
+  server unix:{{ .Address }};
+{{- else }}
+  server {{ .Address }};
+{{- end }}
+}
+
+map $http_upgrade $connection_upgrade {
+  default upgrade;
+  ''      close;
+}
+
+server {
+  listen 80;
+  listen [::]:80;
+  server_name {{ .Hostname }};
+
+  location / {
+    return 301 https://$host$request_uri;
+  }
+}
+
+server {
+  listen 443 ssl http2;
+  listen [::]:443 ssl http2;
+  server_name {{ .Hostname }};
+
+  ssl_certificate     /path/to/your/certs/{{ .Hostname }}.crt;
+  ssl_certificate_key /path/to/your/certs/{{ .Hostname }}.key;
+
+  location / {
+    proxy_pass http://anubis;
+    proxy_http_version 1.1;
+
+    proxy_set_header Host $host;
+    proxy_set_header X-Real-IP $remote_addr;
+    proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+    proxy_set_header X-Forwarded-Proto $scheme;
+
+    # WebSocket support
+    proxy_set_header Upgrade $http_upgrade;
+    proxy_set_header Connection $connection_upgrade;
+  }
+}
+`)),
+	"caddy": template.Must(template.New("caddy").Parse(`# Generated by anubis generate-config caddy.
+# Caddy handles TLS certificates, X-Forwarded-For, and WebSocket upgrades on
+# its own.
+
+{{ .Hostname }} {
+{{- if .Unix }}
+  reverse_proxy unix/{{ .Address }} {
+{{- else }}
+  reverse_proxy http://{{ .Address }} {
+{{- end }}
+    header_up X-Real-Ip {remote_host}
+  }
+}
+`)),
+	"apache": template.Must(template.New("apache").Parse(`# Generated by anubis generate-config apache.
+# Requires mod_ssl, mod_proxy, mod_proxy_http, and mod_headers. WebSocket
+# upgrades need Apache 2.4.47 or later.
+
+<VirtualHost *:80>
+  ServerName {{ .Hostname }}
+  Redirect permanent / https://{{ .Hostname }}/
+</VirtualHost>
+
+<VirtualHost *:443>
+  ServerName {{ .Hostname }}
+
+  SSLEngine On
+  SSLCertificateFile /path/to/your/certs/{{ .Hostname }}.crt
+  SSLCertificateKeyFile /path/to/your/certs/{{ .Hostname }}.key
+
+  RequestHeader set "X-Real-Ip" expr=%{REMOTE_ADDR}
+  RequestHeader set X-Forwarded-Proto "https"
+
+  ProxyPreserveHost On
+  ProxyRequests Off
+  ProxyVia Off
+
+{{- if .Unix }}
+  ProxyPass / unix:{{ .Address }}|http://{{ .Hostname }}/ upgrade=websocket
+  ProxyPassReverse / unix:{{ .Address }}|http://{{ .Hostname }}/
+{{- else }}
+  ProxyPass / http://{{ .Address }}/ upgrade=websocket
+  ProxyPassReverse / http://{{ .Address }}/
+{{- end }}
+</VirtualHost>
+`)),
+	"traefik": template.Must(template.New("traefik").Parse(`# Generated by anubis generate-config traefik.
+# This is a dynamic configuration file for Traefik's file provider. Traefik
+# sets X-Real-Ip and X-Forwarded-For and handles WebSocket upgrades on its own.
+
+http:
+  routers:
+    anubis:
+      rule: "Host(` + "`{{ .Hostname }}`" + `)"
+      service: anubis
+      entryPoints:
+        - websecure
+      tls: {}
+
+  services:
+    anubis:
+      loadBalancer:
+        servers:
+          - url: "http://{{ .Address }}"
+`)),
+}
+
+func proxyConfigKinds() string {
+	var kinds []string
+	for kind := range proxyConfigTemplates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return strings.Join(kinds, ", ")
+}
+
+// upstreamAddress converts a bind address into something a reverse proxy on the
+// same machine can connect to.
+func upstreamAddress(network, address string) (string, error) {
+	if network == "unix" {
+		return address, nil
+	}
+
+	if !strings.HasPrefix(network, "tcp") {
+		return "", fmt.Errorf("can't generate reverse proxy configuration for bind network %q", network)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("can't parse bind address %q: %w", address, err)
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// generateConfig implements the generate-config subcommand. It writes a
+// reverse proxy snippet for the current BIND and BIND_NETWORK settings.
+func generateConfig(w io.Writer, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: anubis generate-config <%s> [hostname]", strings.ReplaceAll(proxyConfigKinds(), ", ", "|"))
+	}
+
+	tmpl, ok := proxyConfigTemplates[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown reverse proxy %q, expected one of: %s", args[0], proxyConfigKinds())
+	}
+
+	params := proxyConfigParams{
+		Hostname: "example.com",
+		Unix:     *bindNetwork == "unix",
+	}
+
+	if len(args) == 2 {
+		params.Hostname = args[1]
+	}
+
+	if params.Unix && args[0] == "traefik" {
+		return errors.New("traefik can't proxy to unix sockets, set BIND_NETWORK to tcp")
+	}
+
+	addr, err := upstreamAddress(*bindNetwork, *bind)
+	if err != nil {
+		return err
+	}
+	params.Address = addr
+
+	return tmpl.Execute(w, params)
+}
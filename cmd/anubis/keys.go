@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vale981/anubis"
+	libanubis "github.com/vale981/anubis/lib"
+)
+
+// errTokenRejected is returned by tokenInspect if Anubis would not accept
+// the token, so that scripts can check the exit status.
+var errTokenRejected = errors.New("anubis would reject this token")
+
+// keygenCommand generates a signing key for ED25519_PRIVATE_KEY_HEX or
+// ED25519_PRIVATE_KEY_HEX_FILE. The public key goes to standard error, so
+// that the seed can be piped into a secret store on its own.
+func keygenCommand(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis keygen", flag.ContinueOnError)
+	out := fs.String("out", "", "if set, write the key to this file, readable only by its owner, instead of standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		if _, err := os.Stat(*out); err == nil {
+			return fmt.Errorf("%s already exists, refusing to overwrite it", *out)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("can't generate ed25519 key: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "public key: %s\n", hex.EncodeToString(pub))
+
+	if *out == "" {
+		_, err = fmt.Fprintln(w, hex.EncodeToString(priv.Seed()))
+		return err
+	}
+
+	if err := writeKeyFile(*out, priv); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote key to %s, use it with ED25519_PRIVATE_KEY_HEX_FILE=%s\n", *out, *out)
+	return nil
+}
+
+func tokenCommand(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: anubis token <inspect|mint> [args...]")
+	}
+
+	switch args[0] {
+	case "inspect":
+		return tokenInspect(w, args[1:])
+	case "mint":
+		return tokenMint(w, args[1:])
+	default:
+		return fmt.Errorf("unknown token subcommand %q", args[0])
+	}
+}
+
+// tokenInspect prints what is in a cookie, bypass token or upstream token
+// issued by Anubis, and every reason it can find offline for Anubis to
+// reject it.
+func tokenInspect(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis token inspect", flag.ContinueOnError)
+	pubHex := fs.String("public-key-hex", "", "hex-encoded ed25519 public key to check the signature with, instead of the configured signing key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: anubis token inspect [-public-key-hex key] <token>")
+	}
+	value := fs.Arg(0)
+
+	token, _, err := jwt.NewParser().ParseUnverified(value, jwt.MapClaims{})
+	if err != nil {
+		return fmt.Errorf("can't parse token: %w", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	kind := tokenKind(claims)
+	fmt.Fprintf(w, "kind: %s\n", kind)
+	fmt.Fprintf(w, "algorithm: %s\n", token.Method.Alg())
+	if kid, ok := token.Header["kid"].(string); ok {
+		fmt.Fprintf(w, "key id: %s\n", kid)
+	}
+
+	fmt.Fprintln(w, "claims:")
+	keys := make([]string, 0, len(claims))
+	for k := range claims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s: %s\n", k, formatClaim(k, claims[k]))
+	}
+
+	var problems, notes []string
+
+	pub, err := inspectionKey(*pubHex)
+	switch {
+	case err != nil:
+		return err
+	case pub == nil:
+		notes = append(notes, "the signature was not checked, no signing key is configured")
+	default:
+		_, err := jwt.NewParser(
+			jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+			jwt.WithStrictDecoding(),
+			jwt.WithoutClaimsValidation(),
+		).Parse(value, func(*jwt.Token) (any, error) {
+			return pub, nil
+		})
+		if err != nil {
+			problems = append(problems, "the signature does not match the key, the token was signed by another instance or before the key was changed")
+		}
+	}
+
+	now := time.Now()
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		problems = append(problems, "it has no valid exp claim, Anubis only accepts tokens that expire")
+	} else if now.After(exp.Time) {
+		problems = append(problems, fmt.Sprintf("it expired %s ago", now.Sub(exp.Time).Round(time.Second)))
+	}
+	if nbf, err := claims.GetNotBefore(); err == nil && nbf != nil && now.Before(nbf.Time) {
+		problems = append(problems, fmt.Sprintf("it is not valid for another %s, check the clocks of the machines involved", nbf.Time.Sub(now).Round(time.Second)))
+	}
+
+	switch kind {
+	case "bypass token":
+		for _, k := range []string{"jti", "sub"} {
+			if s, _ := claims[k].(string); s == "" {
+				problems = append(problems, fmt.Sprintf("it has no %s claim", k))
+			}
+		}
+		if iss, _ := claims.GetIssuer(); iss != "anubis" {
+			problems = append(problems, fmt.Sprintf("its issuer is %q, not \"anubis\"", iss))
+		}
+		notes = append(notes,
+			"it is only accepted in the X-Anubis-Bypass header, never as a cookie",
+			"whether it was revoked can only be checked by the running instance",
+		)
+	case "upstream token":
+		notes = append(notes, "it is sent to the target and is never accepted by Anubis itself")
+	default:
+		// Minted cookies have no solved challenge to check again.
+		if kind != "minted cookie" {
+			if _, ok := claims["challenge"].(string); !ok {
+				problems = append(problems, "it has no challenge claim, so it fails rules with secondary screening")
+			}
+			if _, ok := claims["response"].(string); !ok {
+				problems = append(problems, "it has no response claim, so it fails rules with secondary screening")
+			}
+		}
+		if _, ok := claims["iat"]; !ok {
+			problems = append(problems, "it has no iat claim, so it is rejected once any cookies are revoked")
+		}
+		if kind == "challenge cookie" {
+			notes = append(notes, "it is not accepted for rules with the CAPTCHA action")
+		}
+		if ip, ok := claims["ip"].(string); ok {
+			notes = append(notes, fmt.Sprintf("with token binding, it is only accepted from %s or its network", ip))
+		}
+		notes = append(notes, "whether it was revoked can only be checked by the running instance")
+	}
+
+	for _, n := range notes {
+		fmt.Fprintf(w, "note: %s\n", n)
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "valid: yes")
+		return nil
+	}
+
+	fmt.Fprintln(w, "valid: no")
+	for _, p := range problems {
+		fmt.Fprintf(w, "  - %s\n", p)
+	}
+
+	return errTokenRejected
+}
+
+// tokenMint signs a cookie for a client that can't pass challenges, for the
+// operator to give to its user. The cookie value is written to w on its own.
+func tokenMint(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis token mint", flag.ContinueOnError)
+	ip := fs.String("for-ip", "", "IP address of the client the cookie is for, checked if the policy sets token_binding")
+	duration := fs.Duration("duration", 24*time.Hour, "how long the cookie is valid for, at most 90 days")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	priv, err := privateKeyFromFlags()
+	if err != nil {
+		return err
+	}
+	if priv == nil {
+		return errors.New("minted cookies need the signing key of Anubis, set ED25519_PRIVATE_KEY_HEX, ED25519_PRIVATE_KEY_HEX_FILE or STATE_DIR")
+	}
+
+	token, err := libanubis.MintToken(priv, *ip, *duration)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "set it as the %s cookie, expires: %s\n", anubis.CookieName, time.Now().Add(*duration).Format(time.RFC3339))
+	_, err = fmt.Fprintln(w, token)
+	return err
+}
+
+// inspectionKey returns the public key to check token signatures with, or
+// nil if there is none.
+func inspectionKey(pubHex string) (ed25519.PublicKey, error) {
+	if pubHex != "" {
+		pub, err := hex.DecodeString(pubHex)
+		if err != nil {
+			return nil, fmt.Errorf("public key is not hex-encoded: %w", err)
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key is not %d bytes long, got %d bytes", ed25519.PublicKeySize, len(pub))
+		}
+		return ed25519.PublicKey(pub), nil
+	}
+
+	priv, err := privateKeyFromFlags()
+	if err != nil || priv == nil {
+		return nil, err
+	}
+
+	return priv.Public().(ed25519.PublicKey), nil
+}
+
+// tokenKind tells apart the kinds of tokens that Anubis issues.
+func tokenKind(claims jwt.MapClaims) string {
+	switch {
+	case claims["typ"] == "bypass":
+		return "bypass token"
+	case claims["rule"] != nil && claims["action"] != nil:
+		return "upstream token"
+	case claims["method"] == "captcha":
+		return "CAPTCHA cookie"
+	case claims["method"] == "minted":
+		return "minted cookie"
+	default:
+		return "challenge cookie"
+	}
+}
+
+// formatClaim formats a claim for tokenInspect, adding the time to the
+// timestamp claims.
+func formatClaim(name string, value any) string {
+	if n, ok := value.(float64); ok && (name == "exp" || name == "iat" || name == "nbf") {
+		t := time.Unix(int64(n), 0)
+		return fmt.Sprintf("%d (%s, %s)", int64(n), t.UTC().Format(time.RFC3339), relativeTime(t))
+	}
+
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(buf)
+}
+
+func relativeTime(t time.Time) string {
+	d := time.Until(t).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%s ago", -d)
+	}
+	return fmt.Sprintf("in %s", d)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vale981/anubis"
+	libanubis "github.com/vale981/anubis/lib"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// combinedLogLine matches the Apache/nginx common and combined log formats.
+var combinedLogLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[[^\]]*\] "(\S+) (\S+)[^"]*" \d{3} \S+(?: "[^"]*" "([^"]*)")?`)
+
+// accessLogEntry is the part of an access log line that policies can match on.
+type accessLogEntry struct {
+	RemoteAddr string
+	Method     string
+	URI        string
+	Host       string
+	UserAgent  string
+}
+
+// loadPolicy loads the policy file fname, checking the signature of URLs the
+// same way the server does.
+func loadPolicy(fname string) (*policy.ParsedConfig, error) {
+	if !libanubis.IsRemotePolicy(fname) {
+		return libanubis.LoadPoliciesOrDefault(fname, anubis.DefaultDifficulty)
+	}
+
+	rp, err := remotePolicy(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	return rp.Load(context.Background(), anubis.DefaultDifficulty)
+}
+
+func policyCommand(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: anubis policy <check|explain|replay|sign-url> [args...]")
+	}
+
+	switch args[0] {
+	case "check":
+		return policyCheck(w, args[1:])
+	case "explain":
+		return policyExplain(w, args[1:])
+	case "replay":
+		return policyReplay(w, args[1:])
+	case "sign-url":
+		return policySignURL(w, args[1:])
+	default:
+		return fmt.Errorf("unknown policy subcommand %q", args[0])
+	}
+}
+
+// errPolicyWarnings is returned by policyCheck in strict mode if the policy
+// has problems that don't prevent Anubis from loading it.
+var errPolicyWarnings = errors.New("policy has warnings")
+
+func policyCheck(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis policy check", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "if true, also fail if the policy has warnings, such as rules that can never match")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fnames := fs.Args()
+	if len(fnames) == 0 {
+		fnames = []string{*policyFname}
+	}
+
+	var warned bool
+	for _, fname := range fnames {
+		pol, err := loadPolicy(fname)
+		if err != nil {
+			return err
+		}
+
+		name := fname
+		if name == "" {
+			name = "built-in policy"
+		}
+
+		bots := slices.Clone(pol.Bots)
+		for _, route := range pol.Routes {
+			bots = append(bots, route.Bots[:len(route.Bots)-len(pol.Bots)]...)
+		}
+
+		fmt.Fprintf(w, "%s: OK, %d rules\n", name, len(bots))
+
+		warnings := pol.Lint()
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "warning: %s\n", warning)
+		}
+		warned = warned || len(warnings) != 0
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for i, rule := range slices.DeleteFunc(bots, func(b policy.Bot) bool { return b.Action != config.RuleDeny }) {
+			if i == 0 {
+				fmt.Fprintln(tw)
+				fmt.Fprintln(tw, "DENY RULE\tERROR ID")
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", rule.Name, rule.Hash())
+		}
+		fmt.Fprintln(tw)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if *strict && warned {
+		return errPolicyWarnings
+	}
+
+	return nil
+}
+
+// headerFlags collects the values of a flag that can be given more than once.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if _, _, ok := strings.Cut(value, ":"); !ok {
+		return fmt.Errorf("header %q must look like \"Name: value\"", value)
+	}
+
+	*h = append(*h, value)
+	return nil
+}
+
+func policyExplain(w io.Writer, args []string) error {
+	var headers headerFlags
+
+	fs := flag.NewFlagSet("anubis policy explain", flag.ContinueOnError)
+	fname := fs.String("policy-fname", *policyFname, "policy file to evaluate, defaults to POLICY_FNAME or the built-in policy")
+	ip := fs.String("ip", "127.0.0.1", "IP address of the client")
+	method := fs.String("method", http.MethodGet, "HTTP method of the request")
+	host := fs.String("host", "localhost", "host the request is for")
+	path := fs.String("path", "/", "path and query string of the request")
+	userAgent := fs.String("user-agent", "", "User-Agent of the client")
+	fs.Var(&headers, "header", "extra request header as \"Name: value\", can be given more than once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pol, err := loadPolicy(*fname)
+	if err != nil {
+		return err
+	}
+
+	s, err := libanubis.New(libanubis.Options{Policy: pol})
+	if err != nil {
+		return err
+	}
+
+	req, err := accessLogEntry{
+		RemoteAddr: *ip,
+		Method:     *method,
+		URI:        *path,
+		Host:       *host,
+		UserAgent:  *userAgent,
+	}.request()
+	if err != nil {
+		return fmt.Errorf("can't build request: %w", err)
+	}
+
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	ex, err := s.Explain(req)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if ex.Route != nil {
+		fmt.Fprintf(tw, "route: %s%s\n\n", ex.Route.Host, ex.Route.PathPrefix)
+	}
+
+	fmt.Fprintln(tw, "#\tRULE\tRESULT")
+	for i, step := range ex.Steps {
+		result := "no match"
+		switch {
+		case step.Matched && step.Action == config.RuleUnknown:
+			result = "match, decision API had no opinion"
+		case step.Matched:
+			result = "match, " + string(step.Action)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", i+1, step.Rule, result)
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "result:\t%s\t%s\n", ex.Result.Name, ex.Result.Rule)
+	if ex.Bot != nil && ex.Bot.Target != "" {
+		fmt.Fprintf(tw, "upstream:\t%s\n", ex.Bot.Target)
+	}
+
+	switch ex.Result.Rule {
+	case config.RuleChallenge, config.RuleCaptcha:
+		if c := ex.Bot.Challenge; c != nil {
+			fmt.Fprintf(tw, "challenge:\talgorithm %s, difficulty %d, reported as %d\n", c.Algorithm, c.Difficulty, c.ReportAs)
+			if c.MinDifficulty != 0 {
+				fmt.Fprintf(tw, "\tminimum difficulty %d, target solve time %ds\n", c.MinDifficulty, c.TargetSolveSeconds)
+			}
+			if c.MaxDifficulty != 0 {
+				fmt.Fprintf(tw, "\tmaximum difficulty %d\n", c.MaxDifficulty)
+			}
+			if c.MaxThreads != 0 {
+				fmt.Fprintf(tw, "\tmaximum threads %d\n", c.MaxThreads)
+			}
+		}
+	case config.RuleDeny, config.RuleTarpit:
+		fmt.Fprintf(tw, "error ID:\t%s\n", ex.Bot.Hash())
+		if resp := ex.Bot.Response; resp != nil && ex.Result.Rule == config.RuleDeny {
+			fmt.Fprintf(tw, "response:\tstatus %d, %s\n", resp.StatusOr(http.StatusOK), cmp.Or(resp.Format, config.ResponseHTML))
+		}
+	case config.RuleRedirect:
+		if rd := ex.Bot.Redirect; rd != nil {
+			fmt.Fprintf(tw, "redirect:\t%d to %s\n", rd.StatusCode(), rd.Location(req.URL))
+		}
+	case config.RuleStatus:
+		if resp := ex.Bot.Response; resp != nil {
+			fmt.Fprintf(tw, "response:\tstatus %d, %s\n", resp.Status, resp.ContentTypeOrDefault())
+		}
+	}
+
+	return tw.Flush()
+}
+
+func policyReplay(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis policy replay", flag.ContinueOnError)
+	format := fs.String("format", "combined", "access log format, either combined (Apache/nginx common or combined log format) or json (one object per line)")
+	fname := fs.String("policy-fname", *policyFname, "policy file to evaluate, defaults to POLICY_FNAME or the built-in policy")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var parse func(string) (accessLogEntry, bool)
+	switch *format {
+	case "combined":
+		parse = parseCombinedLogLine
+	case "json":
+		parse = parseJSONLogLine
+	default:
+		return fmt.Errorf("unknown access log format %q, expected combined or json", *format)
+	}
+
+	pol, err := loadPolicy(*fname)
+	if err != nil {
+		return err
+	}
+
+	s, err := libanubis.New(libanubis.Options{Policy: pol})
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	actions := map[string]config.Rule{}
+	totals := map[config.Rule]int{}
+	var skipped int
+
+	replay := func(r io.Reader) error {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for sc.Scan() {
+			entry, ok := parse(sc.Text())
+			if !ok {
+				skipped++
+				continue
+			}
+
+			req, err := entry.request()
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			cr, _, err := s.Check(req)
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			counts[cr.Name]++
+			actions[cr.Name] = cr.Rule
+			totals[cr.Rule]++
+		}
+
+		return sc.Err()
+	}
+
+	logFiles := fs.Args()
+	if len(logFiles) == 0 {
+		if err := replay(os.Stdin); err != nil {
+			return fmt.Errorf("can't read access log from stdin: %w", err)
+		}
+	}
+
+	for _, fname := range logFiles {
+		fin, err := os.Open(fname)
+		if err != nil {
+			return err
+		}
+
+		err = replay(fin)
+		fin.Close()
+		if err != nil {
+			return fmt.Errorf("can't read access log %s: %w", fname, err)
+		}
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tACTION\tREQUESTS")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", name, actions[name], counts[name])
+	}
+	fmt.Fprintln(tw)
+	for _, rule := range []config.Rule{config.RuleAllow, config.RuleChallenge, config.RuleDeny, config.RuleTarpit, config.RuleDecoy, config.RuleRedirect, config.RuleStatus, config.RuleBenchmark} {
+		if totals[rule] != 0 {
+			fmt.Fprintf(tw, "total\t%s\t%d\n", rule, totals[rule])
+		}
+	}
+	if skipped != 0 {
+		fmt.Fprintf(tw, "skipped\t\t%d\n", skipped)
+	}
+
+	return tw.Flush()
+}
+
+func (e accessLogEntry) request() (*http.Request, error) {
+	host := e.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	req, err := http.NewRequest(e.Method, "http://"+host+e.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Real-Ip", e.RemoteAddr)
+	if e.UserAgent != "" && e.UserAgent != "-" {
+		req.Header.Set("User-Agent", e.UserAgent)
+	}
+
+	return req, nil
+}
+
+func parseCombinedLogLine(line string) (accessLogEntry, bool) {
+	m := combinedLogLine.FindStringSubmatch(line)
+	if m == nil {
+		return accessLogEntry{}, false
+	}
+
+	return accessLogEntry{
+		RemoteAddr: m[1],
+		Method:     m[2],
+		URI:        m[3],
+		UserAgent:  m[4],
+	}, true
+}
+
+// parseJSONLogLine understands the field names of nginx variables as well as
+// shorter generic ones.
+func parseJSONLogLine(line string) (accessLogEntry, bool) {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return accessLogEntry{}, false
+	}
+
+	get := func(keys ...string) string {
+		for _, key := range keys {
+			if val, ok := fields[key].(string); ok && val != "" {
+				return val
+			}
+		}
+		return ""
+	}
+
+	entry := accessLogEntry{
+		RemoteAddr: get("remote_addr", "remote_ip", "client_ip", "ip"),
+		Method:     get("request_method", "method"),
+		URI:        get("request_uri", "uri", "path"),
+		Host:       get("host", "http_host"),
+		UserAgent:  get("http_user_agent", "user_agent"),
+	}
+
+	if entry.RemoteAddr == "" || entry.URI == "" {
+		return accessLogEntry{}, false
+	}
+
+	if entry.Method == "" {
+		entry.Method = http.MethodGet
+	}
+
+	return entry, true
+}
+
+// policySignURL signs a URL with the secret of a signed URL of the policy.
+func policySignURL(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("anubis policy sign-url", flag.ContinueOnError)
+	fname := fs.String("policy-fname", *policyFname, "policy file with the signed URL, defaults to POLICY_FNAME")
+	name := fs.String("name", "", "name of the signed URL in the policy, defaults to the first one whose path prefix matches")
+	method := fs.String("method", http.MethodPost, "HTTP method the URL is signed for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: anubis policy sign-url [-name name] [-method method] <url>")
+	}
+
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	pol, err := loadPolicy(*fname)
+	if err != nil {
+		return err
+	}
+
+	for _, su := range pol.SignedURLs {
+		if (*name == "" || su.Name == *name) && su.Matches(u.Path) {
+			_, err := fmt.Fprintln(w, su.Sign(strings.ToUpper(*method), u, time.Now()))
+			return err
+		}
+	}
+
+	return fmt.Errorf("no signed url in the policy matches %s", u.Path)
+}
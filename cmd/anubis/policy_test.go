@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseCombinedLogLine(t *testing.T) {
+	for _, tt := range []struct {
+		name, line string
+		want       accessLogEntry
+		wantOK     bool
+	}{
+		{
+			name:   "combined",
+			line:   `198.51.100.1 - frank [10/Oct/2026:13:55:36 -0700] "GET /index.html?page=2 HTTP/1.1" 200 2326 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: "GET", URI: "/index.html?page=2", UserAgent: "Mozilla/5.0 (X11; Linux x86_64)"},
+			wantOK: true,
+		},
+		{
+			name:   "common",
+			line:   `198.51.100.1 - - [10/Oct/2026:13:55:36 -0700] "POST /api HTTP/1.0" 201 -`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: "POST", URI: "/api"},
+			wantOK: true,
+		},
+		{
+			name:   "ipv6",
+			line:   `2001:db8::1 - - [10/Oct/2026:13:55:36 +0000] "HEAD / HTTP/2.0" 200 0 "-" "curl/8.0"`,
+			want:   accessLogEntry{RemoteAddr: "2001:db8::1", Method: "HEAD", URI: "/", UserAgent: "curl/8.0"},
+			wantOK: true,
+		},
+		{
+			name:   "no user agent",
+			line:   `198.51.100.1 - - [10/Oct/2026:13:55:36 -0700] "GET / HTTP/1.1" 200 12 "-" "-"`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: "GET", URI: "/", UserAgent: "-"},
+			wantOK: true,
+		},
+		{
+			name: "no request line",
+			line: `198.51.100.1 - - [10/Oct/2026:13:55:36 -0700] "-" 400 0 "-" "-"`,
+		},
+		{
+			name: "no status",
+			line: `198.51.100.1 - - [10/Oct/2026:13:55:36 -0700] "GET / HTTP/1.1"`,
+		},
+		{
+			name: "json",
+			line: `{"remote_addr": "198.51.100.1", "request_uri": "/"}`,
+		},
+		{
+			name: "empty",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCombinedLogLine(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("wanted ok: %v, got: %v", tt.wantOK, ok)
+			}
+			if got != tt.want {
+				t.Errorf("wanted %+v, got: %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseJSONLogLine(t *testing.T) {
+	for _, tt := range []struct {
+		name, line string
+		want       accessLogEntry
+		wantOK     bool
+	}{
+		{
+			name:   "nginx",
+			line:   `{"remote_addr": "198.51.100.1", "request_method": "POST", "request_uri": "/login", "host": "example.com", "http_user_agent": "Mozilla/5.0"}`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: "POST", URI: "/login", Host: "example.com", UserAgent: "Mozilla/5.0"},
+			wantOK: true,
+		},
+		{
+			name:   "short names",
+			line:   `{"ip": "198.51.100.1", "method": "PUT", "uri": "/file", "http_host": "example.com", "user_agent": "curl/8.0"}`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: "PUT", URI: "/file", Host: "example.com", UserAgent: "curl/8.0"},
+			wantOK: true,
+		},
+		{
+			name:   "nginx names first",
+			line:   `{"remote_addr": "198.51.100.1", "ip": "192.0.2.1", "request_uri": "/", "uri": "/other"}`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: http.MethodGet, URI: "/"},
+			wantOK: true,
+		},
+		{
+			name:   "empty fields are skipped",
+			line:   `{"remote_addr": "", "client_ip": "198.51.100.1", "request_uri": "", "path": "/"}`,
+			want:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: http.MethodGet, URI: "/"},
+			wantOK: true,
+		},
+		{
+			name: "no address",
+			line: `{"request_uri": "/"}`,
+		},
+		{
+			name: "no uri",
+			line: `{"remote_addr": "198.51.100.1"}`,
+		},
+		{
+			name: "not a string",
+			line: `{"remote_addr": 42, "request_uri": "/"}`,
+		},
+		{
+			name: "combined",
+			line: `198.51.100.1 - - [10/Oct/2026:13:55:36 -0700] "GET / HTTP/1.1" 200 12`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseJSONLogLine(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("wanted ok: %v, got: %v", tt.wantOK, ok)
+			}
+			if got != tt.want {
+				t.Errorf("wanted %+v, got: %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAccessLogEntryRequest(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		entry         accessLogEntry
+		wantHost      string
+		wantUserAgent string
+		wantErr       bool
+	}{
+		{
+			name:          "full",
+			entry:         accessLogEntry{RemoteAddr: "198.51.100.1", Method: "GET", URI: "/a?b=c", Host: "example.com", UserAgent: "Mozilla/5.0"},
+			wantHost:      "example.com",
+			wantUserAgent: "Mozilla/5.0",
+		},
+		{
+			name:     "no host",
+			entry:    accessLogEntry{RemoteAddr: "198.51.100.1", Method: "GET", URI: "/"},
+			wantHost: "localhost",
+		},
+		{
+			name:     "dash user agent",
+			entry:    accessLogEntry{RemoteAddr: "198.51.100.1", Method: "GET", URI: "/", UserAgent: "-"},
+			wantHost: "localhost",
+		},
+		{
+			name:    "invalid method",
+			entry:   accessLogEntry{RemoteAddr: "198.51.100.1", Method: "G E T", URI: "/"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := tt.entry.request()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wanted error: %v, got: %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if req.Host != tt.wantHost {
+				t.Errorf("wanted host %q, got: %q", tt.wantHost, req.Host)
+			}
+			if got := req.Header.Get("User-Agent"); got != tt.wantUserAgent {
+				t.Errorf("wanted user agent %q, got: %q", tt.wantUserAgent, got)
+			}
+			if got := req.Header.Get("X-Real-Ip"); got != tt.entry.RemoteAddr {
+				t.Errorf("wanted X-Real-Ip %q, got: %q", tt.entry.RemoteAddr, got)
+			}
+			if got := req.URL.RequestURI(); got != tt.entry.URI {
+				t.Errorf("wanted URI %q, got: %q", tt.entry.URI, got)
+			}
+		})
+	}
+}
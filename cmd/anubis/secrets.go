@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretFlags are flags that hold sensitive values. Each of them gets a sister
+// flag with a "-file" suffix (and so a *_FILE environment variable) that reads
+// the value from a file instead, such as a Docker or Kubernetes secret mount.
+var secretFlags = []string{
+	"captcha-secret",
+	"metrics-basic-auth-password",
+	"metrics-bearer-token",
+	"redis-url",
+	"webhook-urls",
+}
+
+var secretFileFlags = map[string]*string{}
+
+func init() {
+	for _, name := range secretFlags {
+		f := flag.Lookup(name)
+		if f == nil {
+			panic(fmt.Sprintf("secret flag %q is not defined", name))
+		}
+
+		secretFileFlags[name] = flag.String(name+"-file", "", "file name containing value for "+name)
+	}
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadSecretFiles sets every secret flag whose sister file flag is set to the
+// contents of that file. It is an error to set both.
+func loadSecretFiles() error {
+	for _, name := range secretFlags {
+		fname := *secretFileFlags[name]
+		if fname == "" {
+			continue
+		}
+
+		if flag.Lookup(name).Value.String() != "" {
+			return fmt.Errorf("do not specify both %s and %s_FILE", envName(name), envName(name))
+		}
+
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_FILE %s: %w", envName(name), fname, err)
+		}
+
+		if err := flag.Set(name, string(bytes.TrimSpace(data))); err != nil {
+			return fmt.Errorf("failed to set %s from %s: %w", envName(name), fname, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// stateKeyFname is the name of the file in the state directory that holds the
+// hex-encoded ed25519 seed Anubis generated for itself.
+const stateKeyFname = "ed25519-private-key.hex"
+
+// loadOrCreateStateKey loads the signing key from the state directory, or
+// generates one and stores it there if it doesn't exist yet. This keeps
+// visitors' cookies valid across restarts when no key is configured.
+func loadOrCreateStateKey(stateDir string) (ed25519.PrivateKey, error) {
+	fname := filepath.Join(stateDir, stateKeyFname)
+
+	hexData, err := os.ReadFile(fname)
+	switch {
+	case err == nil:
+		priv, err := keyFromHex(string(bytes.TrimSpace(hexData)))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse key in %s: %w", fname, err)
+		}
+		slog.Debug("loaded signing key from state directory", "fname", fname)
+		return priv, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("can't read %s: %w", fname, err)
+	}
+
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("can't create state directory %s: %w", stateDir, err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't generate ed25519 key: %w", err)
+	}
+
+	if err := writeKeyFile(fname, priv); err != nil {
+		return nil, err
+	}
+
+	slog.Info("generated new signing key and saved it to the state directory", "fname", fname)
+
+	return priv, nil
+}
+
+// writeKeyFile writes the hex-encoded seed of priv to fname, readable only by
+// its owner. It writes to a temporary file first so that a crash can't leave
+// a truncated key behind.
+func writeKeyFile(fname string, priv ed25519.PrivateKey) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*")
+	if err != nil {
+		return fmt.Errorf("can't create temporary key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can't set permissions on %s: %w", tmp.Name(), err)
+	}
+
+	if _, err := fmt.Fprintln(tmp, hex.EncodeToString(priv.Seed())); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can't write %s: %w", tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't write %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), fname); err != nil {
+		return fmt.Errorf("can't move key into place at %s: %w", fname, err)
+	}
+
+	return nil
+}
@@ -86,6 +86,14 @@ func (m *Impl[K, V]) Set(key K, value V, ttl time.Duration) {
 	}
 }
 
+// Delete removes a key from the map.
+func (m *Impl[K, V]) Delete(key K) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.data, key)
+}
+
 // Cleanup removes all expired entries from the DecayMap.
 func (m *Impl[K, V]) Cleanup() {
 	m.lock.Lock()
@@ -99,6 +107,14 @@ func (m *Impl[K, V]) Cleanup() {
 	}
 }
 
+// Clear removes all entries from the DecayMap.
+func (m *Impl[K, V]) Clear() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	clear(m.data)
+}
+
 // Len returns the number of entries in the DecayMap.
 func (m *Impl[K, V]) Len() int {
 	m.lock.RLock()
@@ -0,0 +1,228 @@
+// Package accesslog writes one line for every request Anubis handles, separate
+// from the diagnostic logs.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vale981/anubis/internal"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatCombined = "combined"
+)
+
+// Logger writes access log lines in one format.
+type Logger struct {
+	format     string
+	out        io.Writer
+	anonymizer *internal.Anonymizer
+	lock       sync.Mutex
+}
+
+// New returns a Logger that writes lines in format to out. Client IP
+// addresses and user agents are anonymized with anonymizer, which may be
+// nil.
+func New(out io.Writer, format string, anonymizer *internal.Anonymizer) (*Logger, error) {
+	switch format {
+	case FormatJSON, FormatCombined:
+	default:
+		return nil, fmt.Errorf("accesslog: unknown format %q, must be %s or %s", format, FormatJSON, FormatCombined)
+	}
+
+	return &Logger{
+		format:     format,
+		out:        out,
+		anonymizer: anonymizer,
+	}, nil
+}
+
+// Entry is the record of one request. Anubis fills in the parts that only it
+// knows, such as the upstream latency, while the request is handled.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration"`
+	Upstream  *float64  `json:"upstream,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Challenge string    `json:"challenge,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	UserAgent string    `json:"user_agent"`
+	Referer   string    `json:"referer,omitempty"`
+
+	lock sync.Mutex
+}
+
+type ctxKey struct{}
+
+// FromContext returns the entry for the request ctx belongs to, or nil if
+// access logging is off.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(ctxKey{}).(*Entry)
+	return e
+}
+
+// SetUpstream records how long the target took to respond.
+func (e *Entry) SetUpstream(d time.Duration) {
+	if e == nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	secs := d.Seconds()
+	e.Upstream = &secs
+}
+
+// SetChallenge records what happened with the challenge of the request, such
+// as "issued" or "passed".
+func (e *Entry) SetChallenge(outcome string) {
+	if e == nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.Challenge = outcome
+}
+
+// Wrap logs every request handled by next.
+func (l *Logger) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &Entry{Time: time.Now()}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, e)))
+
+		e.lock.Lock()
+		defer e.lock.Unlock()
+
+		e.ClientIP = l.anonymizer.IP(r.Header.Get("X-Real-Ip"))
+		e.Method = r.Method
+		e.Host = r.Host
+		e.URI = r.RequestURI
+		e.Proto = r.Proto
+		e.Status = rw.status
+		e.Bytes = rw.bytes
+		e.Duration = time.Since(e.Time).Seconds()
+		e.Rule = r.Header.Get("X-Anubis-Rule")
+		e.Action = r.Header.Get("X-Anubis-Action")
+		e.Reason = rw.Header().Get("X-Anubis-Reason")
+		e.UserAgent = l.anonymizer.UserAgent(r.UserAgent())
+		e.Referer = r.Referer()
+
+		if err := l.write(e); err != nil {
+			slog.Error("can't write access log", "err", err)
+		}
+	})
+}
+
+func (l *Logger) write(e *Entry) error {
+	var line []byte
+	switch l.format {
+	case FormatJSON:
+		var err error
+		line, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+	case FormatCombined:
+		line = []byte(combined(e))
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err := l.out.Write(line)
+	return err
+}
+
+// combined formats e in the Combined Log Format, followed by the fields only
+// Anubis knows as key=value pairs.
+func combined(e *Entry) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s - - [%s] %q %d %s %q %q",
+		dash(e.ClientIP),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Proto,
+		e.Status,
+		bytesField(e.Bytes),
+		dash(e.Referer),
+		dash(e.UserAgent),
+	)
+
+	fmt.Fprintf(&sb, " rule=%q action=%q", dash(e.Rule), dash(e.Action))
+	if e.Challenge != "" {
+		fmt.Fprintf(&sb, " challenge=%q", e.Challenge)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&sb, " reason=%q", e.Reason)
+	}
+	fmt.Fprintf(&sb, " duration=%.3f", e.Duration)
+	if e.Upstream != nil {
+		fmt.Fprintf(&sb, " upstream=%.3f", *e.Upstream)
+	}
+	sb.WriteByte('\n')
+
+	return sb.String()
+}
+
+func dash(val string) string {
+	if val == "" {
+		return "-"
+	}
+	return val
+}
+
+func bytesField(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+// responseWriter remembers the status and size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so that
+// streaming and hijacking keep working.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
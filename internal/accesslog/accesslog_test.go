@@ -0,0 +1,125 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, format string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	l, err := New(&buf, format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Anubis-Rule", "bot/generic-browser")
+		r.Header.Set("X-Anubis-Action", "CHALLENGE")
+		e := FromContext(r.Context())
+		e.SetChallenge("issued")
+		e.SetUpstream(250 * time.Millisecond)
+		w.Header().Set("X-Anubis-Reason", "CHALLENGE_REQUIRED")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "nope")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/foo?bar=1", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	return buf.String()
+}
+
+func TestJSON(t *testing.T) {
+	var e Entry
+	if err := json.Unmarshal([]byte(serve(t, FormatJSON)), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ClientIP != "198.51.100.1" || e.Method != http.MethodGet || e.URI != "/foo?bar=1" {
+		t.Errorf("wrong request fields: %+v", &e)
+	}
+	if e.Status != http.StatusForbidden || e.Bytes != 4 {
+		t.Errorf("wanted status 403 with 4 bytes, got: %d with %d", e.Status, e.Bytes)
+	}
+	if e.Rule != "bot/generic-browser" || e.Action != "CHALLENGE" || e.Challenge != "issued" || e.Reason != "CHALLENGE_REQUIRED" {
+		t.Errorf("wrong Anubis fields: %+v", &e)
+	}
+	if e.Upstream == nil || *e.Upstream != 0.25 {
+		t.Errorf("wanted upstream latency 0.25, got: %v", e.Upstream)
+	}
+}
+
+func TestCombined(t *testing.T) {
+	line := serve(t, FormatCombined)
+
+	re := regexp.MustCompile(`^198\.51\.100\.1 - - \[[^\]]+\] "GET /foo\?bar=1 HTTP/1\.1" 403 4 "-" "Mozilla/5\.0" rule="bot/generic-browser" action="CHALLENGE" challenge="issued" reason="CHALLENGE_REQUIRED" duration=\d+\.\d{3} upstream=0\.250\n$`)
+	if !re.MatchString(line) {
+		t.Errorf("line doesn't match %s, got: %q", re, line)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", nil); err == nil {
+		t.Error("wanted an error for an unknown format")
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "access.log")
+
+	f, err := OpenFile(name, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for suffix, want := range map[string]string{
+		"":   "fourth\n",
+		".1": "third\n",
+		".2": "second\n",
+	} {
+		got, err := os.ReadFile(name + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("wanted %s%s to contain %q, got: %q", name, suffix, want, got)
+		}
+	}
+
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Errorf("wanted only 2 backups, got %s.3: %v", name, err)
+	}
+
+	if err := os.Rename(name, name+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("fifth\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(name); !strings.HasPrefix(string(got), "fifth") {
+		t.Errorf("wanted a new file after Reopen, got: %q", got)
+	}
+}
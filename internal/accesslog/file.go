@@ -0,0 +1,115 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is an access log file that is rotated when it grows too large. The
+// current file is renamed to name.1, name.1 to name.2 and so on, and the
+// oldest file beyond MaxBackups is deleted.
+type File struct {
+	// Name is the path to the log file.
+	Name string
+
+	// MaxSize is the size in bytes at which the file is rotated. Zero never
+	// rotates it.
+	MaxSize int64
+
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int
+
+	lock sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenFile opens or creates the log file and appends to it.
+func OpenFile(name string, maxSize int64, maxBackups int) (*File, error) {
+	lf := &File{Name: name, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.Name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("accesslog: can't open %s: %w", lf.Name, err)
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("accesslog: can't stat %s: %w", lf.Name, err)
+	}
+
+	lf.f = f
+	lf.size = st.Size()
+	return nil
+}
+
+func (lf *File) Write(p []byte) (int, error) {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+
+	if lf.MaxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.MaxSize {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+func (lf *File) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return fmt.Errorf("accesslog: can't close %s: %w", lf.Name, err)
+	}
+
+	if lf.MaxBackups < 1 {
+		if err := os.Remove(lf.Name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("accesslog: can't remove %s: %w", lf.Name, err)
+		}
+		return lf.open()
+	}
+
+	for i := lf.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", lf.Name, i), fmt.Sprintf("%s.%d", lf.Name, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("accesslog: can't rotate %s: %w", lf.Name, err)
+		}
+	}
+
+	if err := os.Rename(lf.Name, lf.Name+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("accesslog: can't rotate %s: %w", lf.Name, err)
+	}
+
+	return lf.open()
+}
+
+// Reopen closes the file and opens it again, for use with external tools
+// like logrotate that move the file away.
+func (lf *File) Reopen() error {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+
+	if err := lf.f.Close(); err != nil {
+		return fmt.Errorf("accesslog: can't close %s: %w", lf.Name, err)
+	}
+
+	return lf.open()
+}
+
+// Close closes the file.
+func (lf *File) Close() error {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+
+	return lf.f.Close()
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth describes how requests to the metrics and admin listener are
+// authenticated. The zero value allows every request.
+type AdminAuth struct {
+	Username    string
+	Password    string
+	BearerToken string
+	AllowedNets []*net.IPNet
+}
+
+// ParseAllowedNets parses a comma-separated list of CIDR ranges. Bare IP
+// addresses are treated as single-host ranges.
+func ParseAllowedNets(cidrs string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", cidr)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+
+		_, rng, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("range %s not parsing: %w", cidr, err)
+		}
+
+		result = append(result, rng)
+	}
+
+	return result, nil
+}
+
+// Enabled returns true if any form of authentication or IP restriction is
+// configured.
+func (aa AdminAuth) Enabled() bool {
+	return aa.Username != "" || aa.BearerToken != "" || len(aa.AllowedNets) != 0
+}
+
+// SetCredentials adds the configured credentials to an outgoing request, such
+// as the one made by the health check.
+func (aa AdminAuth) SetCredentials(r *http.Request) {
+	switch {
+	case aa.BearerToken != "":
+		r.Header.Set("Authorization", "Bearer "+aa.BearerToken)
+	case aa.Username != "":
+		r.SetBasicAuth(aa.Username, aa.Password)
+	}
+}
+
+func (aa AdminAuth) ipAllowed(r *http.Request) bool {
+	if len(aa.AllowedNets) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Unix socket peers don't have an IP address, access to them is
+		// controlled by the socket mode.
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, rng := range aa.AllowedNets {
+		if rng.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (aa AdminAuth) credentialsValid(r *http.Request) bool {
+	if aa.Username == "" && aa.BearerToken == "" {
+		return true
+	}
+
+	if aa.BearerToken != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(aa.BearerToken)) == 1 {
+				return true
+			}
+		}
+	}
+
+	if aa.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(aa.Username)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(aa.Password)) == 1
+			if userOK && passOK {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RequireAdminAuth rejects requests that don't come from an allowed network or
+// don't present valid credentials.
+func RequireAdminAuth(aa AdminAuth, next http.Handler) http.Handler {
+	if !aa.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !aa.ipAllowed(r) {
+			slog.Debug("admin request from disallowed address", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if !aa.credentialsValid(r) {
+			slog.Debug("admin request with invalid credentials", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			if aa.BearerToken != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="anubis"`)
+			}
+			if aa.Username != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="anubis"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireLocalAdmin rejects requests that don't come from a loopback address
+// or a unix socket if aa is not enabled. The admin API can revoke cookies and
+// turn on emergency mode, so it must not be open to anyone who can reach the
+// metrics listener just because no access controls were set.
+func RequireLocalAdmin(aa AdminAuth, next http.Handler) http.Handler {
+	if aa.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLocal(r) {
+			slog.Debug("admin request from non-local address without access controls", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			http.Error(w, "the admin API only answers local requests unless METRICS_ALLOWED_IPS, METRICS_BASIC_AUTH_USERNAME or METRICS_BEARER_TOKEN is set", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLocal(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Unix socket peers don't have an IP address, access to them is
+		// controlled by the socket mode.
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminAuth(t *testing.T) {
+	allowed, err := ParseAllowedNets("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range []struct {
+		name       string
+		auth       AdminAuth
+		remoteAddr string
+		setup      func(r *http.Request)
+		wantStatus int
+	}{
+		{
+			name:       "disabled",
+			remoteAddr: "1.2.3.4:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "basic_auth_ok",
+			auth:       AdminAuth{Username: "admin", Password: "hunter2"},
+			remoteAddr: "1.2.3.4:1234",
+			setup:      func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "basic_auth_wrong_password",
+			auth:       AdminAuth{Username: "admin", Password: "hunter2"},
+			remoteAddr: "1.2.3.4:1234",
+			setup:      func(r *http.Request) { r.SetBasicAuth("admin", "hunter3") },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "bearer_ok",
+			auth:       AdminAuth{BearerToken: "sekrit"},
+			remoteAddr: "1.2.3.4:1234",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer sekrit") },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "bearer_missing",
+			auth:       AdminAuth{BearerToken: "sekrit"},
+			remoteAddr: "1.2.3.4:1234",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ip_allowed",
+			auth:       AdminAuth{AllowedNets: allowed},
+			remoteAddr: "10.1.2.3:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "single_ip_allowed",
+			auth:       AdminAuth{AllowedNets: allowed},
+			remoteAddr: "192.168.1.1:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ip_denied",
+			auth:       AdminAuth{AllowedNets: allowed, BearerToken: "sekrit"},
+			remoteAddr: "1.2.3.4:1234",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer sekrit") },
+			wantStatus: http.StatusForbidden,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+
+			w := httptest.NewRecorder()
+			RequireAdminAuth(tt.auth, ok).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("wanted status %d, got: %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestParseAllowedNetsInvalid(t *testing.T) {
+	if _, err := ParseAllowedNets("not-an-ip"); err == nil {
+		t.Error("wanted an error, got nil")
+	}
+}
+
+func TestRequireLocalAdmin(t *testing.T) {
+	admin := http.NewServeMux()
+	admin.HandleFunc("POST /admin/revoke-tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range []struct {
+		name       string
+		auth       AdminAuth
+		remoteAddr string
+		setup      func(r *http.Request)
+		wantStatus int
+	}{
+		{
+			name:       "default_remote",
+			remoteAddr: "203.0.113.5:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "default_loopback",
+			remoteAddr: "127.0.0.1:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "default_loopback_ipv6",
+			remoteAddr: "[::1]:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "default_unix_socket",
+			remoteAddr: "@",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "bearer_remote",
+			auth:       AdminAuth{BearerToken: "sekrit"},
+			remoteAddr: "203.0.113.5:1234",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer sekrit") },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "bearer_remote_missing",
+			auth:       AdminAuth{BearerToken: "sekrit"},
+			remoteAddr: "203.0.113.5:1234",
+			wantStatus: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/revoke-tokens", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+
+			w := httptest.NewRecorder()
+			RequireAdminAuth(tt.auth, RequireLocalAdmin(tt.auth, admin)).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("wanted status %d, got: %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
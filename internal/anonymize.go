@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anonymizer hashes or truncates client identifiers before they are logged.
+// A nil Anonymizer leaves everything as-is.
+type Anonymizer struct {
+	// Mode is either "hash" or "truncate".
+	Mode string
+
+	// UserAgents also hashes User-Agent strings.
+	UserAgents bool
+
+	// SaltRotation is how often a new salt is generated for hashing. Hashes of
+	// the same value only match until the salt rotates. Zero never rotates it.
+	SaltRotation time.Duration
+
+	lock        sync.Mutex
+	salt        []byte
+	saltCreated time.Time
+}
+
+// NewAnonymizer validates mode and returns a new Anonymizer. If mode is empty,
+// it returns nil.
+func NewAnonymizer(mode string, userAgents bool, saltRotation time.Duration) (*Anonymizer, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "hash", "truncate":
+	default:
+		return nil, fmt.Errorf("anonymizer: unknown mode %q, must be hash or truncate", mode)
+	}
+
+	return &Anonymizer{
+		Mode:         mode,
+		UserAgents:   userAgents,
+		SaltRotation: saltRotation,
+	}, nil
+}
+
+// IP anonymizes a single IP address or a comma-separated list of them, such
+// as the contents of X-Forwarded-For.
+func (a *Anonymizer) IP(val string) string {
+	if a == nil || val == "" {
+		return val
+	}
+
+	addrs := strings.Split(val, ",")
+	for i, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if a.Mode == "truncate" {
+			addrs[i] = truncateIP(addr)
+		} else {
+			addrs[i] = a.hash(addr)
+		}
+	}
+
+	return strings.Join(addrs, ",")
+}
+
+// UserAgent hashes a User-Agent string if enabled.
+func (a *Anonymizer) UserAgent(ua string) string {
+	if a == nil || !a.UserAgents || ua == "" {
+		return ua
+	}
+
+	return a.hash(ua)
+}
+
+func (a *Anonymizer) hash(val string) string {
+	mac := hmac.New(sha256.New, a.currentSalt())
+	mac.Write([]byte(val))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func (a *Anonymizer) currentSalt() []byte {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.salt == nil || (a.SaltRotation > 0 && time.Since(a.saltCreated) >= a.SaltRotation) {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			panic(err) // crypto/rand never fails
+		}
+		a.salt = salt
+		a.saltCreated = time.Now()
+	}
+
+	return a.salt
+}
+
+// truncateIP zeroes the host part of an address, keeping the /24 of IPv4 and
+// the /48 of IPv6 addresses.
+func truncateIP(val string) string {
+	ip := net.ParseIP(val)
+	if ip == nil {
+		return "invalid"
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnonymizerTruncate(t *testing.T) {
+	a, err := NewAnonymizer("truncate", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"192.0.2.123", "192.0.2.0"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"192.0.2.123, 198.51.100.7", "192.0.2.0,198.51.100.0"},
+		{"not-an-ip", "invalid"},
+		{"", ""},
+	} {
+		if got := a.IP(tt.in); got != tt.want {
+			t.Errorf("IP(%q): wanted %q, got: %q", tt.in, tt.want, got)
+		}
+	}
+
+	if got := a.UserAgent("Mozilla/5.0"); got != "Mozilla/5.0" {
+		t.Errorf("user agent should be left alone, got: %q", got)
+	}
+}
+
+func TestAnonymizerHash(t *testing.T) {
+	a, err := NewAnonymizer("hash", true, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := a.IP("192.0.2.1")
+	if first == "192.0.2.1" || first != a.IP("192.0.2.1") {
+		t.Errorf("wanted a stable hash, got: %q and %q", first, a.IP("192.0.2.1"))
+	}
+
+	if ua := a.UserAgent("Mozilla/5.0"); ua == "Mozilla/5.0" {
+		t.Error("user agent was not hashed")
+	}
+
+	// force a salt rotation
+	a.saltCreated = time.Now().Add(-2 * time.Hour)
+	if got := a.IP("192.0.2.1"); got == first {
+		t.Error("hash did not change after the salt rotated")
+	}
+}
+
+func TestNewAnonymizer(t *testing.T) {
+	if a, err := NewAnonymizer("", true, 0); a != nil || err != nil {
+		t.Errorf("wanted nil anonymizer without error, got: %v, %v", a, err)
+	}
+
+	if _, err := NewAnonymizer("scramble", false, 0); err == nil {
+		t.Error("wanted error for unknown mode")
+	}
+
+	var a *Anonymizer
+	if got := a.IP("192.0.2.1"); got != "192.0.2.1" {
+		t.Errorf("nil anonymizer changed the IP: %q", got)
+	}
+}
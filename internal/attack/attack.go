@@ -0,0 +1,242 @@
+// Package attack works out whether Anubis is under attack from how many
+// challenges it issues, how many of their solutions fail, and how many new
+// clients ask for them, and steps the difficulty of challenges up or down to
+// match.
+package attack
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// minResults is how many solutions must have been checked in an interval
+// for the failure rate to count.
+const minResults = 10
+
+var (
+	difficultyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_attack_detection_difficulty",
+		Help: "The default difficulty the attack detector has set",
+	})
+
+	underAttack = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_attack_detection_under_attack",
+		Help: "Whether the attack detector saw an attack in the last interval",
+	})
+
+	signals = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "anubis_attack_detection_signal",
+		Help: "The signals the attack detector saw in the last interval",
+	}, []string{"signal"})
+
+	steps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_attack_detection_steps",
+		Help: "The total number of times the attack detector changed the default difficulty",
+	}, []string{"direction"})
+)
+
+// Config sets the bounds of the difficulty and the thresholds of the
+// signals. Thresholds that are zero are not checked.
+type Config struct {
+	Interval time.Duration
+
+	// Difficulty is the difficulty the detector starts at.
+	Difficulty    int
+	MinDifficulty int
+	MaxDifficulty int
+
+	// ChallengeRate is in challenges per second.
+	ChallengeRate float64
+	// FailureRate is the fraction of solutions that failed.
+	FailureRate float64
+	// NewClientRate is in clients per second that weren't seen in the
+	// interval before.
+	NewClientRate float64
+}
+
+// Signals is what the detector saw in an interval.
+type Signals struct {
+	ChallengeRate float64 `json:"challenge_rate"`
+	FailureRate   float64 `json:"failure_rate"`
+	NewClientRate float64 `json:"new_client_rate"`
+}
+
+// State is what the detector makes of the last interval.
+type State struct {
+	Difficulty  int     `json:"difficulty"`
+	UnderAttack bool    `json:"under_attack"`
+	Signals     Signals `json:"signals"`
+}
+
+// Detector counts what happens in fixed intervals. At the end of each, the
+// difficulty goes up by one if any signal crossed its threshold, or down by
+// one if all signals stayed below half of their thresholds.
+type Detector struct {
+	cfg Config
+
+	lock  sync.Mutex
+	state State
+	start time.Time
+
+	issued, passed, failed int
+	clients, previous      map[string]struct{}
+
+	now func() time.Time
+}
+
+// New creates a Detector that starts at cfg.Difficulty, kept within its
+// bounds.
+func New(cfg Config) *Detector {
+	d := &Detector{
+		cfg:      cfg,
+		clients:  map[string]struct{}{},
+		previous: map[string]struct{}{},
+		now:      time.Now,
+	}
+
+	d.state.Difficulty = min(max(cfg.Difficulty, cfg.MinDifficulty), cfg.MaxDifficulty)
+	d.start = d.now()
+	d.report()
+
+	return d
+}
+
+// Issued counts a challenge issued to client.
+func (d *Detector) Issued(client string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.roll()
+	d.issued++
+	d.clients[client] = struct{}{}
+}
+
+// Result counts a solution that passed or failed.
+func (d *Detector) Result(passed bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.roll()
+	if passed {
+		d.passed++
+	} else {
+		d.failed++
+	}
+}
+
+// Offset returns how far the difficulty is from the one the detector
+// started at.
+func (d *Detector) Offset() int {
+	return d.State().Difficulty - d.base()
+}
+
+// Bounds returns how far below and above the difficulty the detector
+// started at it may go.
+func (d *Detector) Bounds() (int, int) {
+	return d.cfg.MinDifficulty - d.base(), d.cfg.MaxDifficulty - d.base()
+}
+
+// base returns the difficulty the detector starts at within its bounds.
+func (d *Detector) base() int {
+	return min(max(d.cfg.Difficulty, d.cfg.MinDifficulty), d.cfg.MaxDifficulty)
+}
+
+// State returns what the detector made of the last interval.
+func (d *Detector) State() State {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.roll()
+	return d.state
+}
+
+// Update ends the intervals that are over, so that the metrics stay current
+// while no challenges are issued.
+func (d *Detector) Update() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.roll()
+}
+
+// roll ends the intervals that are over. Intervals without anything in them
+// are calm, so once the first two are evaluated, only the steps down are
+// left to take.
+func (d *Detector) roll() {
+	elapsed := int(d.now().Sub(d.start) / d.cfg.Interval)
+	if elapsed <= 0 {
+		return
+	}
+
+	for i := 0; i < elapsed && (i < 2 || d.state.Difficulty > d.cfg.MinDifficulty); i++ {
+		d.evaluate()
+	}
+	d.start = d.start.Add(time.Duration(elapsed) * d.cfg.Interval)
+}
+
+// evaluate works out the signals of the interval that just ended, steps the
+// difficulty and starts counting the next interval.
+func (d *Detector) evaluate() {
+	seconds := d.cfg.Interval.Seconds()
+
+	var newClients int
+	for client := range d.clients {
+		if _, ok := d.previous[client]; !ok {
+			newClients++
+		}
+	}
+
+	sig := Signals{
+		ChallengeRate: float64(d.issued) / seconds,
+		NewClientRate: float64(newClients) / seconds,
+	}
+	if results := d.passed + d.failed; results >= minResults {
+		sig.FailureRate = float64(d.failed) / float64(results)
+	}
+
+	attack := above(sig.ChallengeRate, d.cfg.ChallengeRate, 1) ||
+		above(sig.FailureRate, d.cfg.FailureRate, 1) ||
+		above(sig.NewClientRate, d.cfg.NewClientRate, 1)
+	calm := !above(sig.ChallengeRate, d.cfg.ChallengeRate, 0.5) &&
+		!above(sig.FailureRate, d.cfg.FailureRate, 0.5) &&
+		!above(sig.NewClientRate, d.cfg.NewClientRate, 0.5)
+
+	switch {
+	case attack && d.state.Difficulty < d.cfg.MaxDifficulty:
+		d.state.Difficulty++
+		steps.WithLabelValues("up").Inc()
+	case calm && d.state.Difficulty > d.cfg.MinDifficulty:
+		d.state.Difficulty--
+		steps.WithLabelValues("down").Inc()
+	}
+
+	d.state.UnderAttack = attack
+	d.state.Signals = sig
+
+	d.issued, d.passed, d.failed = 0, 0, 0
+	d.previous, d.clients = d.clients, map[string]struct{}{}
+
+	d.report()
+}
+
+// above reports whether value is over factor times threshold. Thresholds
+// that are zero are never crossed.
+func above(value, threshold, factor float64) bool {
+	return threshold > 0 && value > threshold*factor
+}
+
+// report updates the metrics.
+func (d *Detector) report() {
+	difficultyGauge.Set(float64(d.state.Difficulty))
+	if d.state.UnderAttack {
+		underAttack.Set(1)
+	} else {
+		underAttack.Set(0)
+	}
+	signals.WithLabelValues("challenge_rate").Set(d.state.Signals.ChallengeRate)
+	signals.WithLabelValues("failure_rate").Set(d.state.Signals.FailureRate)
+	signals.WithLabelValues("new_client_rate").Set(d.state.Signals.NewClientRate)
+}
@@ -0,0 +1,132 @@
+package attack
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newDetector(cfg Config) (*Detector, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := New(cfg)
+	d.now = func() time.Time { return now }
+	d.start = now
+
+	return d, &now
+}
+
+func TestDetectorSteps(t *testing.T) {
+	d, now := newDetector(Config{
+		Interval:      time.Minute,
+		Difficulty:    4,
+		MinDifficulty: 4,
+		MaxDifficulty: 6,
+		ChallengeRate: 1,
+	})
+
+	flood := func() {
+		for i := range 120 {
+			d.Issued(fmt.Sprintf("198.51.100.%d", i%3))
+		}
+		*now = now.Add(time.Minute)
+	}
+
+	for i, want := range []int{5, 6, 6} {
+		flood()
+		state := d.State()
+		if state.Difficulty != want || !state.UnderAttack {
+			t.Errorf("interval %d: wanted difficulty %d under attack, got: %+v", i, want, state)
+		}
+		if state.Signals.ChallengeRate != 2 {
+			t.Errorf("interval %d: wanted 2 challenges per second, got: %v", i, state.Signals.ChallengeRate)
+		}
+	}
+
+	if got := d.Offset(); got != 2 {
+		t.Errorf("wanted an offset of 2, got: %d", got)
+	}
+
+	// Between half the threshold and the threshold, the difficulty stays
+	// where it is.
+	for range 45 {
+		d.Issued("198.51.100.1")
+	}
+	*now = now.Add(time.Minute)
+	if state := d.State(); state.Difficulty != 6 || state.UnderAttack {
+		t.Errorf("wanted difficulty 6 without an attack, got: %+v", state)
+	}
+
+	// Quiet intervals step down one at a time, even when nothing happens.
+	*now = now.Add(time.Minute)
+	if got := d.State().Difficulty; got != 5 {
+		t.Errorf("wanted difficulty 5 after a quiet interval, got: %d", got)
+	}
+	*now = now.Add(time.Hour)
+	if got := d.State().Difficulty; got != 4 {
+		t.Errorf("wanted difficulty 4 after a quiet hour, got: %d", got)
+	}
+}
+
+func TestDetectorFailureRate(t *testing.T) {
+	d, now := newDetector(Config{
+		Interval:      time.Minute,
+		Difficulty:    4,
+		MinDifficulty: 2,
+		MaxDifficulty: 8,
+		FailureRate:   0.5,
+	})
+
+	// Too few solutions for the failure rate to count.
+	for range minResults - 1 {
+		d.Result(false)
+	}
+	*now = now.Add(time.Minute)
+	if state := d.State(); state.UnderAttack || state.Signals.FailureRate != 0 {
+		t.Errorf("wanted no attack from a few failures, got: %+v", state)
+	}
+
+	for i := range 20 {
+		d.Result(i%4 == 0)
+	}
+	*now = now.Add(time.Minute)
+	state := d.State()
+	if !state.UnderAttack || state.Signals.FailureRate != 0.75 {
+		t.Errorf("wanted an attack at a failure rate of 0.75, got: %+v", state)
+	}
+
+	// The first interval was calm and stepped down below the difficulty
+	// the detector started at.
+	if state.Difficulty != 4 {
+		t.Errorf("wanted difficulty 4, got: %d", state.Difficulty)
+	}
+	if below, above := d.Bounds(); below != -2 || above != 4 {
+		t.Errorf("wanted bounds -2 and 4, got: %d and %d", below, above)
+	}
+}
+
+func TestDetectorNewClients(t *testing.T) {
+	d, now := newDetector(Config{
+		Interval:      10 * time.Second,
+		Difficulty:    4,
+		MinDifficulty: 4,
+		MaxDifficulty: 8,
+		NewClientRate: 1,
+	})
+
+	for i := range 20 {
+		d.Issued(fmt.Sprintf("198.51.100.%d", i))
+	}
+	*now = now.Add(10 * time.Second)
+	if state := d.State(); !state.UnderAttack || state.Signals.NewClientRate != 2 {
+		t.Errorf("wanted an attack from 2 new clients per second, got: %+v", state)
+	}
+
+	// The same clients coming back aren't new.
+	for i := range 20 {
+		d.Issued(fmt.Sprintf("198.51.100.%d", i))
+	}
+	*now = now.Add(10 * time.Second)
+	if state := d.State(); state.UnderAttack || state.Signals.NewClientRate != 0 {
+		t.Errorf("wanted no new clients, got: %+v", state)
+	}
+}
@@ -0,0 +1,22 @@
+// Package dashboard serves a status page for the admin API that shows what
+// Anubis has been doing, for people who don't run a metrics stack.
+package dashboard
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed index.html
+var page []byte
+
+// Handler serves the dashboard page. The page polls stats, relative to its
+// own URL, for the data it shows.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		w.Write(page)
+	})
+}
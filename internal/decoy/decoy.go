@@ -0,0 +1,163 @@
+// Package decoy generates pages of plausible looking nonsense for scrapers,
+// with links to more of the same, so that their datasets fill up with
+// garbage instead of the content of the site.
+package decoy
+
+import (
+	_ "embed"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"sync"
+)
+
+//go:embed corpus.txt
+var corpus string
+
+//go:embed page.html.tmpl
+var pageTemplate string
+
+var tmpl = template.Must(template.New("page").Parse(pageTemplate))
+
+// Default is a Generator trained on the embedded corpus.
+var Default = sync.OnceValue(func() *Generator {
+	return New(corpus)
+})
+
+// prefix is the two words a word follows in the chain.
+type prefix [2]string
+
+// Generator makes text with a word-level Markov chain.
+type Generator struct {
+	chain  map[prefix][]string
+	starts []prefix
+	words  []string
+}
+
+// New trains a Generator on text. Sentences start where a word follows one
+// that ends in a period.
+func New(text string) *Generator {
+	g := &Generator{chain: map[prefix][]string{}}
+
+	var p prefix
+	sentenceStart := true
+	for _, word := range strings.Fields(text) {
+		if sentenceStart {
+			g.starts = append(g.starts, prefix{p[1], word})
+		}
+		g.chain[p] = append(g.chain[p], word)
+		p = prefix{p[1], word}
+		sentenceStart = strings.HasSuffix(word, ".")
+
+		if w := strings.ToLower(strings.Trim(word, ".,;:")); len(w) > 3 {
+			g.words = append(g.words, w)
+		}
+	}
+
+	return g
+}
+
+// Link is a link on a decoy page.
+type Link struct {
+	Href string
+	Text string
+}
+
+// Page is a decoy page.
+type Page struct {
+	Title      string
+	Paragraphs []string
+	Links      []Link
+}
+
+// Page generates a page with the given number of paragraphs and links. The
+// same seed always gives the same page, so that a scraper that comes back
+// finds what it saw before. Links point to other pages next to dir.
+func (g *Generator) Page(seed, dir string, paragraphs, links int) Page {
+	h := fnv.New64a()
+	io.WriteString(h, seed)
+	rng := rand.New(rand.NewPCG(h.Sum64(), 0x616e75626973))
+
+	p := Page{Title: g.title(rng)}
+
+	for range paragraphs {
+		var sb strings.Builder
+		for i := range 3 + rng.IntN(4) {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(g.sentence(rng))
+		}
+		p.Paragraphs = append(p.Paragraphs, sb.String())
+	}
+
+	for range links {
+		text := g.title(rng)
+		slug := strings.ReplaceAll(strings.ToLower(text), " ", "-")
+		p.Links = append(p.Links, Link{Href: path.Join("/", dir, slug), Text: text})
+	}
+
+	return p
+}
+
+// Write renders p as HTML and returns the number of bytes written.
+func (p Page) Write(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := tmpl.Execute(cw, p)
+	return cw.n, err
+}
+
+func (g *Generator) title(rng *rand.Rand) string {
+	if len(g.words) == 0 {
+		return "untitled"
+	}
+
+	words := make([]string, 2+rng.IntN(3))
+	for i := range words {
+		words[i] = g.words[rng.IntN(len(g.words))]
+	}
+	words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+
+	return strings.Join(words, " ")
+}
+
+// sentence follows the chain from a random sentence start until a word ends
+// a sentence.
+func (g *Generator) sentence(rng *rand.Rand) string {
+	if len(g.starts) == 0 {
+		return ""
+	}
+
+	p := g.starts[rng.IntN(len(g.starts))]
+	words := []string{p[1]}
+
+	for len(words) < 60 {
+		next := g.chain[p]
+		if len(next) == 0 {
+			break
+		}
+
+		word := next[rng.IntN(len(next))]
+		words = append(words, word)
+		if strings.HasSuffix(word, ".") {
+			break
+		}
+		p = prefix{p[1], word}
+	}
+
+	return strings.Join(words, " ")
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
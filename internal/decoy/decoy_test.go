@@ -0,0 +1,83 @@
+package decoy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPageDeterministic(t *testing.T) {
+	g := Default()
+
+	a := g.Page("example.com/a", "/", 3, 5)
+	b := g.Page("example.com/a", "/", 3, 5)
+	if !reflect.DeepEqual(a, b) {
+		t.Error("wanted the same seed to give the same page")
+	}
+
+	c := g.Page("example.com/b", "/", 3, 5)
+	if reflect.DeepEqual(a, c) {
+		t.Error("wanted different seeds to give different pages")
+	}
+}
+
+func TestPage(t *testing.T) {
+	p := Default().Page("example.com/docs/intro", "/docs", 4, 7)
+
+	if p.Title == "" {
+		t.Error("wanted a title")
+	}
+	if len(p.Paragraphs) != 4 {
+		t.Errorf("wanted 4 paragraphs, got: %d", len(p.Paragraphs))
+	}
+	for i, para := range p.Paragraphs {
+		if para == "" {
+			t.Errorf("paragraph %d is empty", i)
+		}
+	}
+
+	if len(p.Links) != 7 {
+		t.Fatalf("wanted 7 links, got: %d", len(p.Links))
+	}
+	for _, l := range p.Links {
+		if !strings.HasPrefix(l.Href, "/docs/") {
+			t.Errorf("wanted links next to the page, got: %q", l.Href)
+		}
+		if strings.Contains(l.Href, " ") {
+			t.Errorf("wanted links without spaces, got: %q", l.Href)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	g := New("Tom & <Jerry> ran home. Tom & <Jerry> ran away.")
+	p := g.Page("seed", "/", 2, 2)
+
+	var buf bytes.Buffer
+	n, err := p.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("wanted %d bytes written, got: %d", buf.Len(), n)
+	}
+
+	html := buf.String()
+	if strings.Contains(html, "<Jerry>") {
+		t.Error("wanted text to be escaped")
+	}
+	if !strings.Contains(html, "&lt;Jerry&gt;") {
+		t.Errorf("wanted the generated text in the page, got: %s", html)
+	}
+	if got := strings.Count(html, "<a href="); got != 2 {
+		t.Errorf("wanted 2 links, got: %d", got)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	p := New("").Page("seed", "/", 1, 1)
+	if p.Title == "" || len(p.Paragraphs) != 1 || len(p.Links) != 1 {
+		t.Errorf("wanted an empty corpus to still give a page, got: %+v", p)
+	}
+}
@@ -0,0 +1,105 @@
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailureBudget is how many lookups in a row may fail before the
+	// breaker stops querying the DNSBL.
+	DefaultFailureBudget = 5
+
+	// DefaultCooldown is how long the breaker waits before querying the
+	// DNSBL again after it gave up.
+	DefaultCooldown = time.Minute
+)
+
+// ErrBreakerOpen is returned by Breaker.Lookup while lookups are paused.
+var ErrBreakerOpen = errors.New("dnsbl: too many lookup failures, lookups are paused")
+
+// Breaker wraps LookupZone in a circuit breaker. Once FailureBudget lookups
+// in a row fail, it stops querying the DNSBL for Cooldown so that an outage of
+// the resolver or the list doesn't slow down every request.
+type Breaker struct {
+	FailureBudget int
+	Cooldown      time.Duration
+
+	// Zone is the DNS zone of the DNSBL to query.
+	Zone string
+
+	// Resolver, if set, is used instead of the system resolver.
+	Resolver *net.Resolver
+
+	lookup func(context.Context, string) (DroneBLResponse, error)
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewBreaker creates a Breaker that queries DroneBL.
+func NewBreaker(failureBudget int, cooldown time.Duration) *Breaker {
+	return NewZoneBreaker(DroneBLZone, failureBudget, cooldown)
+}
+
+// NewZoneBreaker creates a Breaker that queries the DNSBL at zone.
+func NewZoneBreaker(zone string, failureBudget int, cooldown time.Duration) *Breaker {
+	b := &Breaker{
+		FailureBudget: failureBudget,
+		Cooldown:      cooldown,
+		Zone:          zone,
+	}
+	b.lookup = func(ctx context.Context, ipStr string) (DroneBLResponse, error) {
+		resolver := b.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		return LookupZone(ctx, resolver, b.Zone, ipStr)
+	}
+
+	return b
+}
+
+// Open returns true if lookups are currently paused.
+func (b *Breaker) Open() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return time.Now().Before(b.openUntil)
+}
+
+// Lookup looks up ipStr in the DNSBL unless lookups are paused, in which case
+// it returns ErrBreakerOpen.
+func (b *Breaker) Lookup(ctx context.Context, ipStr string) (DroneBLResponse, error) {
+	if b.Open() {
+		return Unknown, ErrBreakerOpen
+	}
+
+	resp, err := b.lookup(ctx, ipStr)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		if b.failures >= b.FailureBudget {
+			slog.Info("dnsbl: lookups succeeding again", "zone", b.Zone)
+		}
+		b.failures = 0
+		return resp, nil
+	}
+
+	b.failures++
+	if b.failures == b.FailureBudget {
+		slog.Warn("dnsbl: too many lookup failures, pausing lookups", "zone", b.Zone, "failures", b.failures, "cooldown", b.Cooldown, "err", err)
+	}
+	if b.failures >= b.FailureBudget {
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,56 @@
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	fail := true
+	calls := 0
+
+	b := NewBreaker(2, 50*time.Millisecond)
+	b.lookup = func(context.Context, string) (DroneBLResponse, error) {
+		calls++
+		if fail {
+			return Unknown, errors.New("resolver down")
+		}
+		return AllGood, nil
+	}
+
+	for range 2 {
+		if _, err := b.Lookup(t.Context(), "1.1.1.1"); err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("wanted lookup error, got: %v", err)
+		}
+	}
+
+	if !b.Open() {
+		t.Fatal("breaker should be open after exhausting the failure budget")
+	}
+
+	if _, err := b.Lookup(t.Context(), "1.1.1.1"); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("wanted ErrBreakerOpen, got: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("wanted 2 lookups, got: %d", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	fail = false
+
+	resp, err := b.Lookup(t.Context(), "1.1.1.1")
+	if err != nil {
+		t.Fatalf("wanted lookup to resume after cooldown, got: %v", err)
+	}
+
+	if resp != AllGood {
+		t.Errorf("wanted %s, got: %s", AllGood, resp)
+	}
+
+	if b.Open() {
+		t.Error("breaker should be closed after a successful lookup")
+	}
+}
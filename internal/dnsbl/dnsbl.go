@@ -1,6 +1,7 @@
 package dnsbl
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -65,15 +66,37 @@ func reverse6(ip net.IP) string {
 	return sb.String()[:len(sb.String())-1]
 }
 
+// DroneBLZone is the DNS zone of DroneBL.
+const DroneBLZone = "dnsbl.dronebl.org"
+
+// NewResolver returns a resolver that sends every query to the DNS server at
+// addr, such as 127.0.0.1:53.
+func NewResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Lookup looks up ipStr in DroneBL.
 func Lookup(ipStr string) (DroneBLResponse, error) {
+	return LookupZone(context.Background(), net.DefaultResolver, DroneBLZone, ipStr)
+}
+
+// LookupZone looks up ipStr in the DNSBL at zone. Listed addresses get the
+// last octet of the DNSBL's answer, which for DroneBL is a DroneBLResponse.
+func LookupZone(ctx context.Context, resolver *net.Resolver, zone, ipStr string) (DroneBLResponse, error) {
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		return Unknown, errors.New("dnsbl: input is not an IP address")
 	}
 
-	revIP := Reverse(ip) + ".dnsbl.dronebl.org"
+	revIP := Reverse(ip) + "." + zone
 
-	ips, err := net.LookupIP(revIP)
+	ips, err := resolver.LookupIP(ctx, "ip4", revIP)
 	if err != nil {
 		var dnserr *net.DNSError
 		if errors.As(err, &dnserr) {
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// OutboundTransport makes an HTTP transport for requests Anubis makes to
+// external services (such as Open Graph tag fetches). The proxy it uses is
+// set by proxy as described in ProxyFunc.
+func OutboundTransport(proxy string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxyFunc, err := ProxyFunc(proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxyFunc
+
+	return transport, nil
+}
+
+// ProxyFunc returns the function an HTTP transport uses to pick the proxy for
+// a request.
+//
+// If proxy is empty, the standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+// environment variables are honored. If proxy is "direct", no proxy is used
+// and ProxyFunc returns nil. Otherwise proxy must be a http://, https://,
+// socks5://, or socks5h:// URL.
+func ProxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse outbound proxy URL %q: %w", proxy, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("outbound proxy URL %q has unsupported scheme %q, want http, https, socks5, or socks5h", proxy, u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("outbound proxy URL %q has no host", proxy)
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// FirstNonEmpty returns the first non-empty string in vals. This is used to
+// let per-subsystem settings override global ones.
+func FirstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
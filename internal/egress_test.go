@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		proxy     string
+		wantProxy string
+		wantErr   bool
+	}{
+		{name: "direct", proxy: "direct"},
+		{name: "http", proxy: "http://proxy.internal:3128", wantProxy: "http://proxy.internal:3128"},
+		{name: "socks5", proxy: "socks5://127.0.0.1:1080", wantProxy: "socks5://127.0.0.1:1080"},
+		{name: "bad_scheme", proxy: "ftp://proxy.internal", wantErr: true},
+		{name: "no_host", proxy: "http://", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := OutboundTransport(tt.proxy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wanted error: %v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if tt.wantProxy == "" {
+				if transport.Proxy != nil {
+					t.Error("wanted no proxy function")
+				}
+				return
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err := transport.Proxy(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if u.String() != tt.wantProxy {
+				t.Errorf("wanted proxy %s, got: %s", tt.wantProxy, u)
+			}
+		})
+	}
+}
+
+func TestFirstNonEmpty(t *testing.T) {
+	if got := FirstNonEmpty("", "b", "c"); got != "b" {
+		t.Errorf("wanted b, got: %q", got)
+	}
+
+	if got := FirstNonEmpty("", ""); got != "" {
+		t.Errorf("wanted empty string, got: %q", got)
+	}
+}
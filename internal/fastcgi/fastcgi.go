@@ -0,0 +1,502 @@
+// Package fastcgi passes HTTP requests to a FastCGI responder such as
+// PHP-FPM, so that Anubis can sit in front of it without a web server in
+// between. Files in the document root that aren't scripts are served
+// directly if the document root is on the same machine.
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record types and roles from the FastCGI specification.
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+
+	// requestID is the ID of the only request sent on each connection.
+	requestID = 1
+
+	// maxContent is the most content one record can carry.
+	maxContent = 65535
+)
+
+// DefaultIndex is the script that requests go to which don't name one.
+const DefaultIndex = "index.php"
+
+// maxBufferedBody is the largest request body of unknown length that is
+// read into memory to find out its length, which FastCGI needs up front.
+const maxBufferedBody = 32 << 20
+
+// errBodyTooLarge is returned by requestBody for bodies of unknown length
+// over maxBufferedBody.
+var errBodyTooLarge = errors.New("fastcgi: request body too large")
+
+var (
+	ErrNoRoot   = errors.New("fastcgi: the target needs an absolute document root, such as ?root=/var/www/html")
+	ErrNoAddr   = errors.New("fastcgi: the target needs a host and port or a socket path")
+	ErrBadProto = errors.New("fastcgi: malformed response")
+)
+
+// Proxy passes HTTP requests to a FastCGI responder.
+type Proxy struct {
+	// Network and Address are where the responder listens, such as tcp
+	// and 127.0.0.1:9000 or unix and /run/php/php-fpm.sock.
+	Network string
+	Address string
+
+	// Root is the document root on the responder's machine.
+	Root string
+
+	// Index is the script that requests go to which don't name one.
+	Index string
+
+	// DialTimeout is how long connecting to the responder may take.
+	DialTimeout time.Duration
+
+	// ResponseHeaderTimeout, if set, is how long the responder may take
+	// to send the headers of its response.
+	ResponseHeaderTimeout time.Duration
+
+	// ErrorHandler is called for requests that the responder couldn't
+	// answer. Defaults to responding with 502 Bad Gateway.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+// New creates a Proxy for a fastcgi://host:port or fastcgi+unix:///path URL.
+// The document root is set with the root query parameter, and the script
+// that requests go to which don't name one with index.
+func New(target *url.URL) (*Proxy, error) {
+	p := &Proxy{
+		Root:  path.Clean(target.Query().Get("root")),
+		Index: target.Query().Get("index"),
+	}
+	if p.Index == "" {
+		p.Index = DefaultIndex
+	}
+
+	switch target.Scheme {
+	case "fastcgi":
+		p.Network, p.Address = "tcp", target.Host
+	case "fastcgi+unix":
+		p.Network, p.Address = "unix", target.Path
+	default:
+		return nil, fmt.Errorf("fastcgi: unknown scheme %q, want fastcgi or fastcgi+unix", target.Scheme)
+	}
+
+	if p.Address == "" {
+		return nil, ErrNoAddr
+	}
+	if !path.IsAbs(p.Root) {
+		return nil, ErrNoRoot
+	}
+
+	return p, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.serveFile(w, r) {
+		return
+	}
+
+	body, length, err := requestBody(r)
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+
+	dialer := net.Dialer{Timeout: p.DialTimeout}
+	conn, err := dialer.DialContext(r.Context(), p.Network, p.Address)
+	if err != nil {
+		p.fail(w, r, err)
+		return
+	}
+	defer conn.Close()
+
+	// Unblock reads and writes once the client goes away.
+	stop := context.AfterFunc(r.Context(), func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := p.writeRequest(conn, r, body, length); err != nil {
+		p.fail(w, r, err)
+		return
+	}
+
+	if p.ResponseHeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(p.ResponseHeaderTimeout))
+	}
+
+	br := bufio.NewReader(&recordReader{r: bufio.NewReader(conn), path: r.URL.Path})
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		p.fail(w, r, fmt.Errorf("fastcgi: can't read response headers: %w", err))
+		return
+	}
+	if p.ResponseHeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	status := http.StatusOK
+	if value := header.Get("Status"); value != "" {
+		code, _, _ := strings.Cut(value, " ")
+		if status, err = strconv.Atoi(code); err != nil || status < 100 || status > 999 {
+			p.fail(w, r, fmt.Errorf("%w: status %q", ErrBadProto, value))
+			return
+		}
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(status)
+
+	// Pass the body on as it comes, so that scripts can stream.
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := br.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && r.Context().Err() == nil {
+				slog.Error("fastcgi: response cut off", "path", r.URL.Path, "err", err)
+			}
+			return
+		}
+	}
+}
+
+// fail tells the error handler that r couldn't be answered.
+func (p *Proxy) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if ctxErr := r.Context().Err(); ctxErr != nil {
+		err = ctxErr
+	}
+
+	if p.ErrorHandler != nil {
+		p.ErrorHandler(w, r, err)
+		return
+	}
+
+	slog.Error("fastcgi: can't reach responder", "err", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// requestBody returns the body of r and its length, reading bodies of
+// unknown length into memory.
+func requestBody(r *http.Request) (io.Reader, int64, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return http.NoBody, 0, nil
+	}
+	if r.ContentLength >= 0 {
+		return r.Body, r.ContentLength, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBufferedBody+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) > maxBufferedBody {
+		return nil, 0, errBodyTooLarge
+	}
+
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// serveFile serves the file r asks for from the document root if it exists
+// on this machine and isn't a script. Hidden files, such as .env and
+// .htaccess, are never served, except for those in /.well-known.
+func (p *Proxy) serveFile(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	hidden := strings.Contains(strings.ReplaceAll(r.URL.Path, "/.well-known/", "/"), "/.")
+	if hidden || p.isScript(r.URL.Path) {
+		return false
+	}
+
+	f, err := http.Dir(p.Root).Open(r.URL.Path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return true
+}
+
+// isScript reports whether name has the extension of the index script.
+func (p *Proxy) isScript(name string) bool {
+	return path.Ext(name) == path.Ext(p.Index)
+}
+
+// script splits urlPath into the script that answers it and the rest. Paths
+// that name a script go to it, directories go to their index script if it
+// exists on this machine, and everything else goes to the index script in
+// the document root.
+func (p *Proxy) script(urlPath string) (string, string) {
+	ext := path.Ext(p.Index)
+	for i := 0; ; {
+		j := strings.Index(urlPath[i:], ext)
+		if j < 0 {
+			break
+		}
+		end := i + j + len(ext)
+		if end == len(urlPath) || urlPath[end] == '/' {
+			return urlPath[:end], urlPath[end:]
+		}
+		i = end
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		if f, err := http.Dir(p.Root).Open(urlPath + p.Index); err == nil {
+			f.Close()
+			return urlPath + p.Index, ""
+		}
+	}
+
+	return "/" + p.Index, ""
+}
+
+// params returns the CGI variables for r, as PHP-FPM expects them.
+func (p *Proxy) params(r *http.Request, length int64) [][2]string {
+	urlPath := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && urlPath != "/" {
+		urlPath += "/"
+	}
+	scriptName, pathInfo := p.script(urlPath)
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+		port = "80"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			port = "443"
+		}
+	}
+
+	params := [][2]string{
+		{"GATEWAY_INTERFACE", "CGI/1.1"},
+		{"SERVER_SOFTWARE", "Anubis"},
+		{"SERVER_PROTOCOL", r.Proto},
+		{"SERVER_NAME", host},
+		{"SERVER_PORT", port},
+		{"REQUEST_METHOD", r.Method},
+		{"REQUEST_URI", r.URL.RequestURI()},
+		{"QUERY_STRING", r.URL.RawQuery},
+		{"DOCUMENT_ROOT", p.Root},
+		{"DOCUMENT_URI", scriptName},
+		{"SCRIPT_NAME", scriptName},
+		{"SCRIPT_FILENAME", path.Join(p.Root, scriptName)},
+		{"PATH_INFO", pathInfo},
+		{"REMOTE_ADDR", r.Header.Get("X-Real-Ip")},
+		{"CONTENT_TYPE", r.Header.Get("Content-Type")},
+		{"CONTENT_LENGTH", strconv.FormatInt(length, 10)},
+		// php-cgi refuses to run without it.
+		{"REDIRECT_STATUS", "200"},
+	}
+	if pathInfo != "" {
+		params = append(params, [2]string{"PATH_TRANSLATED", path.Join(p.Root, pathInfo)})
+	}
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		params = append(params, [2]string{"HTTPS", "on"})
+	}
+
+	for name, values := range r.Header {
+		// The Proxy header would become HTTP_PROXY, which many programs
+		// take as their outbound proxy (httpoxy).
+		if name == "Proxy" || name == "Content-Type" || name == "Content-Length" {
+			continue
+		}
+		// X_Real_Ip would become HTTP_X_REAL_IP just like X-Real-Ip, and
+		// which of them wins depends on map order, so clients could spoof
+		// the headers Anubis sets. Drop them like nginx does.
+		if strings.Contains(name, "_") {
+			continue
+		}
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		params = append(params, [2]string{key, strings.Join(values, ", ")})
+	}
+
+	return params
+}
+
+// writeRequest sends r to the responder.
+func (p *Proxy) writeRequest(conn net.Conn, r *http.Request, body io.Reader, length int64) error {
+	bw := bufio.NewWriter(conn)
+
+	// Role and flags; without the keep-connection flag, the responder
+	// closes the connection once it is done.
+	begin := [8]byte{0, roleResponder}
+	if err := writeRecord(bw, typeBeginRequest, begin[:]); err != nil {
+		return err
+	}
+
+	var params bytes.Buffer
+	for _, param := range p.params(r, length) {
+		writeLength(&params, len(param[0]))
+		writeLength(&params, len(param[1]))
+		params.WriteString(param[0])
+		params.WriteString(param[1])
+	}
+	if err := writeStream(bw, typeParams, &params); err != nil {
+		return err
+	}
+
+	if err := writeStream(bw, typeStdin, body); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// writeStream writes everything in r as records of type typ, followed by the
+// empty record that ends the stream.
+func writeStream(w io.Writer, typ byte, r io.Reader) error {
+	buf := make([]byte, maxContent)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := writeRecord(w, typ, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeRecord(w, typ, nil)
+}
+
+// writeRecord writes one record, padded to a multiple of eight bytes.
+func writeRecord(w io.Writer, typ byte, content []byte) error {
+	padding := -len(content) & 7
+
+	header := [8]byte{1, typ}
+	binary.BigEndian.PutUint16(header[2:], requestID)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	header[6] = byte(padding)
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, padding))
+	return err
+}
+
+// writeLength writes the length of a name or value of a parameter, in one
+// byte if it fits in seven bits and in four otherwise.
+func writeLength(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	buf.Write(b[:])
+}
+
+// recordReader reads the standard output of the responder out of the
+// records it sends, logging what it writes to standard error.
+type recordReader struct {
+	r    *bufio.Reader
+	path string
+	left int
+	pad  int
+	done bool
+}
+
+func (rr *recordReader) Read(p []byte) (int, error) {
+	for rr.left == 0 {
+		if rr.done {
+			return 0, io.EOF
+		}
+		if _, err := rr.r.Discard(rr.pad); err != nil {
+			return 0, err
+		}
+
+		var header [8]byte
+		if _, err := io.ReadFull(rr.r, header[:]); err != nil {
+			return 0, err
+		}
+		if header[0] != 1 {
+			return 0, fmt.Errorf("%w: version %d", ErrBadProto, header[0])
+		}
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		rr.pad = int(header[6])
+
+		switch header[1] {
+		case typeStdout:
+			rr.left = length
+		case typeStderr:
+			msg := make([]byte, length)
+			if _, err := io.ReadFull(rr.r, msg); err != nil {
+				return 0, err
+			}
+			if msg := strings.TrimSpace(string(msg)); msg != "" {
+				slog.Warn("fastcgi: responder wrote to stderr", "path", rr.path, "msg", msg)
+			}
+		case typeEndRequest:
+			if _, err := rr.r.Discard(length); err != nil {
+				return 0, err
+			}
+			rr.done = true
+		default:
+			if _, err := rr.r.Discard(length); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if len(p) > rr.left {
+		p = p[:rr.left]
+	}
+	n, err := rr.r.Read(p)
+	rr.left -= n
+	return n, err
+}
@@ -0,0 +1,197 @@
+package fastcgi
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// responder starts a FastCGI responder that answers with the script it was
+// asked to run and echoes the request body.
+func responder(t *testing.T, network, address string) string {
+	t.Helper()
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go fcgi.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("X-Script", env["SCRIPT_FILENAME"])
+		w.Header().Set("X-Path-Translated", env["PATH_TRANSLATED"])
+		w.Header().Set("X-Remote-Addr", r.RemoteAddr)
+		w.Header().Set("X-Proxy", r.Header.Get("Proxy"))
+		w.Header().Set("X-Env-Real-Ip", r.Header.Get("X-Real-Ip"))
+		w.Header().Set("X-Env-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+	}))
+
+	return ln.Addr().String()
+}
+
+func newProxy(t *testing.T, target string) *Proxy {
+	t.Helper()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestProxy(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "style.css"), []byte("body {}"), 0o644)
+	os.WriteFile(filepath.Join(root, ".env"), []byte("SECRET=1"), 0o644)
+	os.Mkdir(filepath.Join(root, "admin"), 0o755)
+	os.WriteFile(filepath.Join(root, "admin", "index.php"), nil, 0o644)
+
+	addr := responder(t, "tcp", "127.0.0.1:0")
+	p := newProxy(t, "fastcgi://"+addr+"?root="+root)
+
+	for _, tt := range []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		header   http.Header
+		status   int
+		script   string
+		pathInfo string
+		want     string
+	}{
+		{name: "front controller", method: http.MethodGet, target: "/blog/hello?page=2", status: http.StatusCreated, script: "/index.php", want: "GET /blog/hello?page=2 "},
+		{name: "named script", method: http.MethodGet, target: "/wp-login.php", status: http.StatusCreated, script: "/wp-login.php"},
+		{name: "path info", method: http.MethodGet, target: "/index.php/api/users", status: http.StatusCreated, script: "/index.php", pathInfo: "/api/users"},
+		{name: "directory index", method: http.MethodGet, target: "/admin/", status: http.StatusCreated, script: "/admin/index.php"},
+		{name: "post", method: http.MethodPost, target: "/comment", body: "hello", status: http.StatusCreated, script: "/index.php", want: "POST /comment hello"},
+		{name: "static file", method: http.MethodGet, target: "/style.css", status: http.StatusOK, want: "body {}"},
+		{name: "hidden file", method: http.MethodGet, target: "/.env", status: http.StatusCreated, script: "/index.php"},
+		{name: "httpoxy", method: http.MethodGet, target: "/", header: http.Header{"Proxy": {"http://evil.example"}}, status: http.StatusCreated, script: "/index.php"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("wanted status %d, got: %d", tt.status, rec.Code)
+			}
+			if tt.script != "" && rec.Header().Get("X-Script") != root+tt.script {
+				t.Errorf("wanted script %s, got: %q", root+tt.script, rec.Header().Get("X-Script"))
+			}
+			if tt.pathInfo != "" && rec.Header().Get("X-Path-Translated") != root+tt.pathInfo {
+				t.Errorf("wanted path info %q, got: %q", tt.pathInfo, rec.Header().Get("X-Path-Translated"))
+			}
+			if tt.want != "" && rec.Body.String() != tt.want {
+				t.Errorf("wanted body %q, got: %q", tt.want, rec.Body.String())
+			}
+			if rec.Header().Get("X-Proxy") != "" {
+				t.Error("wanted the Proxy header not to be passed on")
+			}
+			if tt.script != "" && !strings.HasPrefix(rec.Header().Get("X-Remote-Addr"), "198.51.100.1") {
+				t.Errorf("wanted the client's address, got: %q", rec.Header().Get("X-Remote-Addr"))
+			}
+		})
+	}
+}
+
+func TestProxyUnderscoreHeaders(t *testing.T) {
+	addr := responder(t, "tcp", "127.0.0.1:0")
+	p := newProxy(t, "fastcgi://"+addr+"?root="+t.TempDir())
+
+	// Each request tries a few times, as which of two colliding headers
+	// wins depends on map order.
+	for range 20 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		req.Header["X_Real_Ip"] = []string{"203.0.113.9"}
+		req.Header["X_Forwarded_For"] = []string{"203.0.113.9"}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("X-Env-Real-Ip"); got != "198.51.100.1" {
+			t.Fatalf("wanted HTTP_X_REAL_IP from X-Real-Ip, got: %q", got)
+		}
+		if got := rec.Header().Get("X-Env-Forwarded-For"); got != "" {
+			t.Fatalf("wanted headers with underscores to be dropped, got HTTP_X_FORWARDED_FOR: %q", got)
+		}
+	}
+}
+
+func TestProxyUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "php-fpm.sock")
+	responder(t, "unix", sock)
+
+	p := newProxy(t, "fastcgi+unix://"+sock+"?root=/srv/www&index=app.php")
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusCreated || rec.Header().Get("X-Script") != "/srv/www/app.php" {
+		t.Errorf("wanted app.php to answer, got %d from %q", rec.Code, rec.Header().Get("X-Script"))
+	}
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := newProxy(t, "fastcgi://"+addr+"?root=/srv/www")
+
+	var handled error
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable || handled == nil {
+		t.Errorf("wanted the error handler to answer, got %d with %v", rec.Code, handled)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		target string
+		err    error
+	}{
+		{target: "fastcgi://127.0.0.1:9000?root=/srv/www"},
+		{target: "fastcgi://127.0.0.1:9000", err: ErrNoRoot},
+		{target: "fastcgi://127.0.0.1:9000?root=www", err: ErrNoRoot},
+		{target: "fastcgi+unix://?root=/srv/www", err: ErrNoAddr},
+	} {
+		u, _ := url.Parse(tt.target)
+		if _, err := New(u); !errors.Is(err, tt.err) {
+			t.Errorf("%s: wanted error %v, got: %v", tt.target, tt.err, err)
+		}
+	}
+}
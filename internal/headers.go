@@ -51,6 +51,40 @@ func RemoteXRealIP(useRemoteAddress bool, bindNetwork string, next http.Handler)
 	})
 }
 
+// DefaultXRealIP sets the X-Real-Ip header when nothing earlier in the
+// chain did, so that requests can still be checked when Anubis is reached
+// without a reverse proxy setting headers. If defaultIP is set, it is used as
+// is. Otherwise the address of the socket peer is used, or 127.0.0.1 for
+// unix sockets.
+func DefaultXRealIP(defaultIP string, bindNetwork string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Real-Ip") == "" {
+			ip := defaultIP
+			if ip == "" {
+				ip = peerIP(bindNetwork, r.RemoteAddr)
+			}
+
+			slog.Debug("X-Real-Ip not set, using default client identity", "val", ip, "remote_addr", r.RemoteAddr)
+			r.Header.Set("X-Real-Ip", ip)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func peerIP(bindNetwork, remoteAddr string) string {
+	if bindNetwork == "unix" {
+		return "127.0.0.1"
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil || net.ParseIP(host) == nil {
+		return "127.0.0.1"
+	}
+
+	return host
+}
+
 // XForwardedForToXRealIP sets the X-Real-Ip header based on the contents
 // of the X-Forwarded-For header.
 func XForwardedForToXRealIP(next http.Handler) http.Handler {
@@ -105,6 +139,16 @@ func XForwardedForUpdate(next http.Handler) http.Handler {
 	})
 }
 
+// RevalidateCache sets the Cache-Control header so that browsers and CDNs
+// may cache a response, but check that it is still current before using it.
+// This is meant for files that can change without a new release.
+func RevalidateCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
+
 // NoStoreCache sets the Cache-Control header to no-store for the response.
 func NoStoreCache(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
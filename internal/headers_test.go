@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDefaultXRealIP(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		defaultIP   string
+		bindNetwork string
+		remoteAddr  string
+		realIP      string
+		want        string
+	}{
+		{
+			name:        "header_already_set",
+			bindNetwork: "tcp",
+			remoteAddr:  "10.0.0.1:4321",
+			realIP:      "1.1.1.1",
+			want:        "1.1.1.1",
+		},
+		{
+			name:        "tcp_peer",
+			bindNetwork: "tcp",
+			remoteAddr:  "10.0.0.1:4321",
+			want:        "10.0.0.1",
+		},
+		{
+			name:        "unix_socket",
+			bindNetwork: "unix",
+			remoteAddr:  "@",
+			want:        "127.0.0.1",
+		},
+		{
+			name:        "configured_default",
+			defaultIP:   "192.0.2.1",
+			bindNetwork: "unix",
+			remoteAddr:  "@",
+			want:        "192.0.2.1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := DefaultXRealIP(tt.defaultIP, tt.bindNetwork, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("X-Real-Ip")
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-Ip", tt.realIP)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("wanted X-Real-Ip %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// connectionTokens are the only values Anubis lets through in the Connection
+// header. Any other token names a header that proxies must strip, which lets
+// clients remove headers such as X-Real-Ip that are set by the reverse proxy
+// in front of Anubis.
+var connectionTokens = map[string]bool{
+	"close":      true,
+	"keep-alive": true,
+	"upgrade":    true,
+}
+
+// NormalizeHopByHop rejects requests with ambiguous message framing and
+// normalizes hop-by-hop headers so that they can't be used to smuggle requests
+// or strip headers on the way to the target.
+//
+// Go's HTTP server already rejects conflicting Content-Length headers and
+// Transfer-Encodings other than chunked. This is a second line of defense in
+// case Anubis is the outermost component of a deployment.
+func NormalizeHopByHop(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TransferEncoding) > 0 {
+			if len(r.TransferEncoding) != 1 || r.TransferEncoding[0] != "chunked" || len(r.Header.Values("Content-Length")) != 0 {
+				slog.Debug("rejecting request with ambiguous framing", "transfer_encoding", r.TransferEncoding, "content_length", r.Header.Values("Content-Length"))
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Go exposes these as fields, the headers themselves must not be
+		// forwarded as-is.
+		r.Header.Del("Transfer-Encoding")
+
+		upgrade := false
+		var keep []string
+		for _, val := range r.Header.Values("Connection") {
+			for _, token := range strings.Split(val, ",") {
+				token = strings.ToLower(textproto.TrimString(token))
+				if token == "" {
+					continue
+				}
+
+				if !connectionTokens[token] {
+					slog.Debug("dropping connection token", "token", token)
+					continue
+				}
+
+				if token == "upgrade" {
+					upgrade = true
+				}
+				keep = append(keep, token)
+			}
+		}
+
+		r.Header.Del("Connection")
+		if len(keep) != 0 {
+			r.Header.Set("Connection", strings.Join(keep, ", "))
+		}
+
+		if !upgrade || r.ProtoMajor != 1 {
+			r.Header.Del("Upgrade")
+		}
+
+		// Clients may only ask for trailers.
+		if te := r.Header.Values("TE"); len(te) != 0 {
+			r.Header.Del("TE")
+			for _, val := range te {
+				for _, token := range strings.Split(val, ",") {
+					if strings.EqualFold(textproto.TrimString(token), "trailers") {
+						r.Header.Set("TE", "trailers")
+					}
+				}
+			}
+		}
+
+		r.Header.Del("Proxy-Connection")
+		r.Header.Del("Keep-Alive")
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeHopByHop(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		setup       func(r *http.Request)
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name: "strip_header_names_from_connection",
+			setup: func(r *http.Request) {
+				r.Header.Set("Connection", "keep-alive, X-Real-Ip")
+				r.Header.Set("X-Real-Ip", "1.1.1.1")
+			},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Connection": "keep-alive",
+				"X-Real-Ip":  "1.1.1.1",
+			},
+		},
+		{
+			name: "upgrade_without_connection_upgrade",
+			setup: func(r *http.Request) {
+				r.Header.Set("Upgrade", "h2c")
+			},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Upgrade": "",
+			},
+		},
+		{
+			name: "negotiated_upgrade",
+			setup: func(r *http.Request) {
+				r.Header.Set("Connection", "Upgrade")
+				r.Header.Set("Upgrade", "websocket")
+			},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Connection": "upgrade",
+				"Upgrade":    "websocket",
+			},
+		},
+		{
+			name: "te_only_trailers",
+			setup: func(r *http.Request) {
+				r.Header.Set("TE", "gzip, trailers")
+			},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"TE": "trailers",
+			},
+		},
+		{
+			name: "te_and_content_length",
+			setup: func(r *http.Request) {
+				r.TransferEncoding = []string{"chunked"}
+				r.Header.Set("Content-Length", "5")
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown_transfer_encoding",
+			setup: func(r *http.Request) {
+				r.TransferEncoding = []string{"gzip", "chunked"}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			h := NormalizeHopByHop(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(r)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("wanted status %d, got: %d", tt.wantStatus, w.Code)
+			}
+
+			for k, v := range tt.wantHeaders {
+				if got.Get(k) != v {
+					t.Errorf("header %s: wanted %q, got: %q", k, v, got.Get(k))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Disk keeps entries in files in a directory, so they outlive restarts and
+// can take up more space than memory allows. Each entry is a file named
+// after the hash of its key.
+type Disk struct {
+	dir string
+}
+
+// diskEntry is what is written to the file of an entry.
+type diskEntry struct {
+	Key   string
+	Entry *Entry
+}
+
+// NewDisk creates a Disk that keeps entries in dir, creating it if needed.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("httpcache: can't create %s: %w", dir, err)
+	}
+
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".entry")
+}
+
+func (d *Disk) Load(key string) (*Entry, error) {
+	de, err := d.read(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if de.Key != key {
+		return nil, ErrNotFound
+	}
+
+	return de.Entry, nil
+}
+
+func (d *Disk) read(path string) (*diskEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var de diskEntry
+	if err := gob.NewDecoder(f).Decode(&de); err != nil {
+		return nil, fmt.Errorf("httpcache: can't decode %s: %w", path, err)
+	}
+
+	return &de, nil
+}
+
+// Store writes the entry to a temporary file first, so that readers never
+// see half of it.
+func (d *Disk) Store(key string, e *Entry) error {
+	f, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		return fmt.Errorf("httpcache: can't store %s: %w", key, err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(diskEntry{Key: key, Entry: e}); err != nil {
+		f.Close()
+		return fmt.Errorf("httpcache: can't encode %s: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("httpcache: can't store %s: %w", key, err)
+	}
+
+	return os.Rename(f.Name(), d.path(key))
+}
+
+func (d *Disk) Remove(key string) error {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Walk skips and removes files that can't be read, such as the leftovers of
+// a crash.
+func (d *Disk) Walk(fn func(key string, e *Entry)) error {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		path := filepath.Join(d.dir, file.Name())
+		if !strings.HasSuffix(file.Name(), ".entry") {
+			if strings.HasSuffix(file.Name(), ".tmp") {
+				os.Remove(path)
+			}
+			continue
+		}
+
+		de, err := d.read(path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		fn(de.Key, de.Entry)
+	}
+
+	return nil
+}
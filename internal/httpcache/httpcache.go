@@ -0,0 +1,644 @@
+// Package httpcache is a shared HTTP cache as described in RFC 9111. It keeps
+// responses to GET requests that say how long they stay fresh, and answers
+// requests for them until they don't. Responses are never revalidated: once
+// stale, they are fetched again.
+package httpcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_response_cache_lookups",
+		Help: "The total number of requests looked up in the response cache, by result",
+	}, []string{"result"})
+
+	entriesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_response_cache_entries",
+		Help: "The number of responses in the response cache",
+	})
+
+	bytesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_response_cache_bytes",
+		Help: "The size of the responses in the response cache",
+	})
+
+	evictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anubis_response_cache_evictions",
+		Help: "The total number of responses removed from the response cache to make room for others",
+	})
+)
+
+// maxEntryFraction is the largest part of the cache that one response may
+// take up.
+const maxEntryFraction = 8
+
+// cacheableStatus lists the status codes that are cacheable by default, from
+// RFC 9110 section 15.1.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// Entry is a stored response.
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Vary holds the values of the request headers named by the response's
+	// Vary header, which later requests must match.
+	Vary map[string]string
+
+	// Stored is when the response was received.
+	Stored time.Time
+	// Age is how old the response was when it was received.
+	Age time.Duration
+	// Lifetime is how long the response is fresh for.
+	Lifetime time.Duration
+}
+
+// age returns how old e is at now.
+func (e *Entry) age(now time.Time) time.Duration {
+	return e.Age + now.Sub(e.Stored)
+}
+
+// size estimates how much space e takes up.
+func (e *Entry) size(key string) int64 {
+	size := len(key) + len(e.Body)
+	for name, values := range e.Header {
+		for _, value := range values {
+			size += len(name) + len(value)
+		}
+	}
+	for name, value := range e.Vary {
+		size += len(name) + len(value)
+	}
+
+	return int64(size)
+}
+
+// matches reports whether r has the same values for the headers e varies on
+// as the request e was stored for.
+func (e *Entry) matches(r *http.Request) bool {
+	for name, value := range e.Vary {
+		if strings.Join(r.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Storage keeps the entries of a Cache. The Cache does the bookkeeping, so a
+// Storage only needs to load, store and remove them.
+type Storage interface {
+	Load(key string) (*Entry, error)
+	Store(key string, e *Entry) error
+	Remove(key string) error
+
+	// Walk calls fn for every stored entry, so that a Cache can pick up
+	// the entries that were stored before it was created.
+	Walk(fn func(key string, e *Entry)) error
+}
+
+// Cache is a shared HTTP cache of at most a fixed size. Once full, the
+// responses that were used least recently make room for new ones.
+type Cache struct {
+	storage Storage
+	maxSize int64
+
+	lock  sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	size  int64
+
+	now func() time.Time
+}
+
+// item is the bookkeeping of one entry.
+type item struct {
+	key  string
+	size int64
+}
+
+// Stats describes what is in a Cache.
+type Stats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	MaxSize int64 `json:"max_size"`
+}
+
+// New creates a Cache of at most maxSize bytes that keeps its entries in
+// storage, picking up the entries already in it.
+func New(storage Storage, maxSize int64) (*Cache, error) {
+	return newCache(storage, maxSize, time.Now)
+}
+
+func newCache(storage Storage, maxSize int64, now func() time.Time) (*Cache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("httpcache: max size must be positive, got: %d", maxSize)
+	}
+
+	c := &Cache{
+		storage: storage,
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   map[string]*list.Element{},
+		now:     now,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	started := now()
+	err := storage.Walk(func(key string, e *Entry) {
+		if e.age(started) >= e.Lifetime {
+			storage.Remove(key)
+			return
+		}
+		c.add(key, e.size(key))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("httpcache: can't load stored responses: %w", err)
+	}
+
+	c.evict()
+	c.report()
+
+	return c, nil
+}
+
+// variantKey is the context key of the variant set by WithVariant.
+type variantKey struct{}
+
+// WithVariant returns a copy of r whose response is kept apart from the
+// responses to the same URL with other variants, such as because r is sent to
+// another target than them.
+func WithVariant(r *http.Request, variant string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), variantKey{}, variant))
+}
+
+// Key returns the key of the response to r: its host and URI, followed by a
+// space and its variant if it has one.
+func Key(r *http.Request) string {
+	key := r.Host + r.URL.RequestURI()
+	if variant, _ := r.Context().Value(variantKey{}).(string); variant != "" {
+		key += " " + variant
+	}
+
+	return key
+}
+
+// Serve answers r from the cache if it can, and otherwise passes it to next,
+// storing the response if it may be reused. Successful requests with other
+// methods than GET and HEAD remove the response for their URL, as they likely
+// changed it.
+func (c *Cache) Serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rec := c.record(w, 0)
+		next.ServeHTTP(rec, r)
+		rec.finish()
+		if rec.status < http.StatusBadRequest {
+			c.Remove(Key(r))
+		}
+		return
+	}
+
+	cc := directives(r.Header)
+	_, noStore := cc["no-store"]
+	_, noCache := cc["no-cache"]
+	if _, ok := r.Header["Cache-Control"]; !ok && strings.EqualFold(r.Header.Get("Pragma"), "no-cache") {
+		noCache = true
+	}
+	if maxAge, ok := cc["max-age"]; ok && maxAge == "0" {
+		noCache = true
+	}
+
+	key := Key(r)
+	switch {
+	case noStore:
+		lookups.WithLabelValues("bypass").Inc()
+		next.ServeHTTP(w, r)
+		return
+	case noCache:
+		lookups.WithLabelValues("bypass").Inc()
+	default:
+		e, result := c.lookup(key, r)
+		lookups.WithLabelValues(result).Inc()
+		if e != nil {
+			c.write(w, r, e)
+			return
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	rec := c.record(w, c.maxSize/maxEntryFraction)
+	next.ServeHTTP(rec, r)
+	rec.finish()
+
+	if e := rec.entry(r, c.now()); e != nil {
+		c.put(key, e)
+	}
+}
+
+// lookup returns the fresh entry that answers r and "hit", or nil and why
+// not.
+func (c *Cache) lookup(key string, r *http.Request) (*Entry, string) {
+	c.lock.Lock()
+	el, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.lock.Unlock()
+
+	if !ok {
+		return nil, "miss"
+	}
+
+	e, err := c.storage.Load(key)
+	if err != nil {
+		c.Remove(key)
+		return nil, "miss"
+	}
+
+	if e.age(c.now()) >= e.Lifetime {
+		c.Remove(key)
+		return nil, "stale"
+	}
+
+	if !e.matches(r) {
+		return nil, "miss"
+	}
+
+	return e, "hit"
+}
+
+// write answers r with e.
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, e *Entry) {
+	for name, values := range e.Header {
+		w.Header()[name] = append(w.Header()[name], values...)
+	}
+	w.Header().Set("Age", strconv.Itoa(int(e.age(c.now()).Seconds())))
+
+	if notModified(r, e) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
+
+// notModified reports whether the conditions of r say that the client's copy
+// of e is current.
+func notModified(r *http.Request, e *Entry) bool {
+	if e.Status != http.StatusOK {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.Header.Get("Etag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// put stores e at key, making room for it if needed.
+func (c *Cache) put(key string, e *Entry) {
+	size := e.size(key)
+	if size > c.maxSize/maxEntryFraction {
+		return
+	}
+
+	if err := c.storage.Store(key, e); err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.add(key, size)
+	c.evict()
+	c.report()
+}
+
+// add records an entry of size at key. The caller must hold c.lock.
+func (c *Cache) add(key string, size int64) {
+	if el, ok := c.items[key]; ok {
+		c.size -= el.Value.(*item).size
+		c.lru.Remove(el)
+	}
+
+	c.items[key] = c.lru.PushFront(&item{key: key, size: size})
+	c.size += size
+}
+
+// evict removes the entries that were used least recently until c fits in
+// its size. The caller must hold c.lock.
+func (c *Cache) evict() {
+	for c.size > c.maxSize {
+		el := c.lru.Back()
+		c.remove(el)
+		evictions.Inc()
+	}
+}
+
+// remove removes the entry of el. The caller must hold c.lock.
+func (c *Cache) remove(el *list.Element) {
+	it := el.Value.(*item)
+	c.lru.Remove(el)
+	delete(c.items, it.key)
+	c.size -= it.size
+	c.storage.Remove(it.key)
+}
+
+// report updates the metrics. The caller must hold c.lock.
+func (c *Cache) report() {
+	entriesGauge.Set(float64(len(c.items)))
+	bytesGauge.Set(float64(c.size))
+}
+
+// Remove removes the response stored at key, if any.
+func (c *Cache) Remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+		c.report()
+	}
+}
+
+// Purge removes the responses whose keys start with prefix and returns how
+// many were removed. Keys start with the host and URI of a request, like
+// example.com/blog/, so a prefix removes every variant of the URLs it
+// matches, and an empty prefix removes all of them.
+func (c *Cache) Purge(prefix string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var purged int
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+			purged++
+		}
+	}
+	c.report()
+
+	return purged
+}
+
+// Stats returns what is in c.
+func (c *Cache) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return Stats{
+		Entries: len(c.items),
+		Bytes:   c.size,
+		MaxSize: c.maxSize,
+	}
+}
+
+// Len returns the number of responses in c.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.items)
+}
+
+// recorder passes a response on to the client and keeps a copy of it. The
+// headers that were set before the response was passed to it, such as
+// cookies set by Anubis, aren't part of the copy.
+type recorder struct {
+	http.ResponseWriter
+
+	outer  http.Header
+	header http.Header
+	status int
+	body   bytes.Buffer
+	limit  int64
+	full   bool
+}
+
+// record starts copying the response written to w, keeping at most limit
+// bytes of its body.
+func (c *Cache) record(w http.ResponseWriter, limit int64) *recorder {
+	rec := &recorder{
+		ResponseWriter: w,
+		outer:          w.Header().Clone(),
+		limit:          limit,
+	}
+	clear(w.Header())
+
+	return rec
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+
+	rec.status = status
+	rec.header = rec.Header().Clone()
+	for name, values := range rec.outer {
+		rec.Header()[name] = append(values, rec.Header()[name]...)
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	if !rec.full {
+		if int64(rec.body.Len()+len(p)) > rec.limit {
+			rec.full = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(p)
+		}
+	}
+
+	return rec.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so that
+// streaming keeps working.
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// finish puts back the headers that were set before the response if it
+// didn't write anything.
+func (rec *recorder) finish() {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+}
+
+// entry returns the recorded response to r if it may be stored, or nil.
+func (rec *recorder) entry(r *http.Request, now time.Time) *Entry {
+	if rec.full || !cacheableStatus[rec.status] {
+		return nil
+	}
+
+	if rec.header.Get("Set-Cookie") != "" ||
+		strings.HasPrefix(rec.header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+
+	lifetime, ok := freshness(r, rec.header, now)
+	if !ok {
+		return nil
+	}
+
+	vary := map[string]string{}
+	for _, value := range rec.header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				name = http.CanonicalHeaderKey(name)
+				vary[name] = strings.Join(r.Header.Values(name), ", ")
+			}
+		}
+	}
+
+	date, err := http.ParseTime(rec.header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	age := max(now.Sub(date), 0)
+	if seconds, err := strconv.Atoi(rec.header.Get("Age")); err == nil {
+		age = max(age, time.Duration(seconds)*time.Second)
+	}
+	if age >= lifetime {
+		return nil
+	}
+
+	header := rec.header.Clone()
+	header.Del("Age")
+
+	return &Entry{
+		Status:   rec.status,
+		Header:   header,
+		Body:     bytes.Clone(rec.body.Bytes()),
+		Vary:     vary,
+		Stored:   now,
+		Age:      age,
+		Lifetime: lifetime,
+	}
+}
+
+// freshness returns how long a response to r with header is fresh for, from
+// s-maxage, max-age or Expires. Responses without any of them aren't stored,
+// as are responses that a shared cache must not store.
+func freshness(r *http.Request, header http.Header, now time.Time) (time.Duration, bool) {
+	cc := directives(header)
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[name]; ok {
+			return 0, false
+		}
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, mustRevalidate := cc["must-revalidate"]
+		_, sMaxAge := cc["s-maxage"]
+		if !public && !mustRevalidate && !sMaxAge {
+			return 0, false
+		}
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := cc[name]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0, false
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+	}
+
+	return 0, false
+}
+
+// directives parses the Cache-Control header into its directives and their
+// arguments, if any.
+func directives(header http.Header) map[string]string {
+	result := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			result[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,311 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// origin answers with the headers in header and a body that counts its
+// requests.
+type origin struct {
+	header http.Header
+	status int
+	hits   int
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.hits++
+	for name, values := range o.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(o.status)
+	fmt.Fprintf(w, "response %d", o.hits)
+}
+
+func newOrigin(cacheControl string) *origin {
+	return &origin{
+		header: http.Header{"Cache-Control": {cacheControl}},
+		status: http.StatusOK,
+	}
+}
+
+func testCache(t *testing.T, storage Storage, maxSize int64) (*Cache, *time.Time) {
+	t.Helper()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, err := newCache(storage, maxSize, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, &now
+}
+
+func do(c *Cache, next http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	c.Serve(rec, req, next)
+	return rec
+}
+
+func TestCacheFreshness(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		cacheControl string
+		header       http.Header
+		status       int
+		stored       bool
+	}{
+		{name: "max-age", cacheControl: "public, max-age=60", stored: true},
+		{name: "s-maxage", cacheControl: "max-age=0, s-maxage=60", stored: true},
+		{name: "not found", cacheControl: "max-age=60", status: http.StatusNotFound, stored: true},
+		{name: "no-store", cacheControl: "no-store, max-age=60"},
+		{name: "private", cacheControl: "private, max-age=60"},
+		{name: "no-cache", cacheControl: "no-cache, max-age=60"},
+		{name: "no freshness", cacheControl: "public"},
+		{name: "server error", cacheControl: "max-age=60", status: http.StatusInternalServerError},
+		{name: "set-cookie", cacheControl: "max-age=60", header: http.Header{"Set-Cookie": {"session=1"}}},
+		{name: "vary star", cacheControl: "max-age=60", header: http.Header{"Vary": {"*"}}},
+		{name: "too old", cacheControl: "max-age=60", header: http.Header{"Age": {"120"}}},
+		{
+			name:   "expires",
+			header: http.Header{"Date": {"Wed, 01 Jan 2025 00:00:00 GMT"}, "Expires": {"Wed, 01 Jan 2025 00:01:00 GMT"}},
+			stored: true,
+		},
+		{
+			name:   "expired",
+			header: http.Header{"Date": {"Wed, 01 Jan 2025 00:00:00 GMT"}, "Expires": {"0"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testCache(t, NewMemory(), 1<<20)
+
+			o := newOrigin(tt.cacheControl)
+			if tt.cacheControl == "" {
+				o.header = http.Header{}
+			}
+			for name, values := range tt.header {
+				o.header[name] = values
+			}
+			if tt.status != 0 {
+				o.status = tt.status
+			}
+
+			do(c, o, http.MethodGet, "/", nil)
+			rec := do(c, o, http.MethodGet, "/", nil)
+
+			if stored := o.hits == 1; stored != tt.stored {
+				t.Errorf("wanted stored %v, got %d requests to the origin", tt.stored, o.hits)
+			}
+			if tt.stored && rec.Header().Get("Age") != "0" {
+				t.Errorf("wanted Age 0 on a hit, got: %q", rec.Header().Get("Age"))
+			}
+		})
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c, now := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+
+	do(c, o, http.MethodGet, "/", nil)
+
+	*now = now.Add(30 * time.Second)
+	rec := do(c, o, http.MethodGet, "/", nil)
+	if rec.Body.String() != "response 1" || rec.Header().Get("Age") != "30" {
+		t.Errorf("wanted the cached response at age 30, got %q at age %q", rec.Body.String(), rec.Header().Get("Age"))
+	}
+
+	*now = now.Add(30 * time.Second)
+	if rec := do(c, o, http.MethodGet, "/", nil); rec.Body.String() != "response 2" {
+		t.Errorf("wanted a fresh response once stale, got: %q", rec.Body.String())
+	}
+}
+
+func TestCacheRequestDirectives(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+
+	do(c, o, http.MethodGet, "/", nil)
+
+	// no-cache fetches and stores a new response.
+	if rec := do(c, o, http.MethodGet, "/", http.Header{"Cache-Control": {"no-cache"}}); rec.Body.String() != "response 2" {
+		t.Errorf("wanted no-cache to skip the cache, got: %q", rec.Body.String())
+	}
+	if rec := do(c, o, http.MethodGet, "/", nil); rec.Body.String() != "response 2" {
+		t.Errorf("wanted the response fetched for no-cache to be stored, got: %q", rec.Body.String())
+	}
+
+	// no-store neither uses nor updates the cache.
+	do(c, o, http.MethodGet, "/", http.Header{"Cache-Control": {"no-store"}})
+	if rec := do(c, o, http.MethodGet, "/", nil); rec.Body.String() != "response 2" {
+		t.Errorf("wanted no-store not to replace the stored response, got: %q", rec.Body.String())
+	}
+
+	if rec := do(c, o, http.MethodHead, "/", nil); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("wanted HEAD to get the stored headers without a body, got %d: %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+	o.header.Set("Vary", "Accept-Language")
+
+	de := http.Header{"Accept-Language": {"de"}}
+	do(c, o, http.MethodGet, "/", de)
+
+	if rec := do(c, o, http.MethodGet, "/", de); rec.Body.String() != "response 1" {
+		t.Errorf("wanted the same language to hit, got: %q", rec.Body.String())
+	}
+	if rec := do(c, o, http.MethodGet, "/", http.Header{"Accept-Language": {"fr"}}); rec.Body.String() != "response 2" {
+		t.Errorf("wanted another language to miss, got: %q", rec.Body.String())
+	}
+}
+
+func TestCacheConditional(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+	o.header.Set("Etag", `"v1"`)
+
+	do(c, o, http.MethodGet, "/", nil)
+
+	if rec := do(c, o, http.MethodGet, "/", http.Header{"If-None-Match": {`W/"v0", "v1"`}}); rec.Code != http.StatusNotModified {
+		t.Errorf("wanted a matching ETag to get 304, got: %d", rec.Code)
+	}
+	if rec := do(c, o, http.MethodGet, "/", http.Header{"If-None-Match": {`"v0"`}}); rec.Code != http.StatusOK {
+		t.Errorf("wanted another ETag to get 200, got: %d", rec.Code)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+
+	do(c, o, http.MethodGet, "/a", nil)
+	do(c, o, http.MethodGet, "/b", nil)
+
+	do(c, o, http.MethodPost, "/a", nil)
+	if c.Len() != 1 {
+		t.Errorf("wanted a successful POST to remove the response for its URL, got %d entries", c.Len())
+	}
+
+	if rec := do(c, o, http.MethodGet, "/b", nil); rec.Body.String() != "response 2" {
+		t.Errorf("wanted other URLs to stay cached, got: %q", rec.Body.String())
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+
+	for _, path := range []string{"/blog/1", "/blog/2", "/about"} {
+		do(c, o, http.MethodGet, path, nil)
+	}
+
+	if got := c.Purge("example.com/blog/"); got != 2 {
+		t.Errorf("wanted 2 responses purged, got: %d", got)
+	}
+	if got := c.Purge(""); got != 1 {
+		t.Errorf("wanted the last response purged, got: %d", got)
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("wanted an empty cache, got: %+v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1024)
+	o := newOrigin("max-age=60")
+
+	for i := range 50 {
+		do(c, o, http.MethodGet, fmt.Sprintf("/%d", i), nil)
+		// Keep the first response in use, so that it isn't evicted.
+		do(c, o, http.MethodGet, "/0", nil)
+	}
+
+	if stats := c.Stats(); stats.Bytes > stats.MaxSize || stats.Entries == 50 {
+		t.Errorf("wanted old responses evicted to stay within the size, got: %+v", stats)
+	}
+	if rec := do(c, o, http.MethodGet, "/0", nil); rec.Body.String() != "response 1" {
+		t.Errorf("wanted the response in use to stay cached, got: %q", rec.Body.String())
+	}
+
+	big := &origin{header: http.Header{"Cache-Control": {"max-age=60"}}, status: http.StatusOK}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		big.ServeHTTP(w, r)
+		w.Write([]byte(strings.Repeat("x", 1024)))
+	})
+	if rec := do(c, handler, http.MethodGet, "/big", nil); rec.Body.Len() < 1024 {
+		t.Errorf("wanted the whole response passed on, got %d bytes", rec.Body.Len())
+	}
+	do(c, handler, http.MethodGet, "/big", nil)
+	if big.hits != 2 {
+		t.Errorf("wanted responses too big for the cache not to be stored, got %d requests", big.hits)
+	}
+}
+
+func TestCacheKeepsOuterHeaders(t *testing.T) {
+	c, _ := testCache(t, NewMemory(), 1<<20)
+	o := newOrigin("max-age=60")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Set-Cookie", "anubis=renewed")
+	c.Serve(rec, req, o)
+
+	if rec.Header().Get("Set-Cookie") != "anubis=renewed" {
+		t.Errorf("wanted the cookie set before the origin answered, got: %v", rec.Header())
+	}
+	if c.Len() != 1 {
+		t.Error("wanted the response stored without the cookie set around it")
+	}
+
+	if rec := do(c, o, http.MethodGet, "/", nil); rec.Header().Get("Set-Cookie") != "" {
+		t.Errorf("wanted the cached response without the cookie, got: %v", rec.Header())
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, now := testCache(t, disk, 1<<20)
+	o := newOrigin("max-age=60")
+	o.header.Set("Content-Type", "text/plain")
+
+	do(c, o, http.MethodGet, "/a", nil)
+	do(c, newOrigin("max-age=1"), http.MethodGet, "/b", nil)
+
+	// A new cache picks up what is on disk, leaving out what went stale.
+	*now = now.Add(30 * time.Second)
+	c, err = newCache(disk, 1<<20, func() time.Time { return *now })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("wanted one response picked up from disk, got: %d", c.Len())
+	}
+
+	rec := do(c, o, http.MethodGet, "/a", nil)
+	if rec.Body.String() != "response 1" || rec.Header().Get("Content-Type") != "text/plain" || rec.Header().Get("Age") != "30" {
+		t.Errorf("wanted the response from disk, got %q with %v", rec.Body.String(), rec.Header())
+	}
+
+	c.Purge("")
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("wanted purged responses removed from disk, got: %v", files)
+	}
+}
@@ -0,0 +1,63 @@
+package httpcache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNotFound is returned by Load when nothing is stored at a key.
+var ErrNotFound = errors.New("httpcache: response not found")
+
+// Memory keeps entries in memory, so they are lost on restart.
+type Memory struct {
+	lock    sync.RWMutex
+	entries map[string]*Entry
+}
+
+// NewMemory creates an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]*Entry{}}
+}
+
+func (m *Memory) Load(key string) (*Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return e, nil
+}
+
+func (m *Memory) Store(key string, e *Entry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.entries[key] = e
+	return nil
+}
+
+func (m *Memory) Remove(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *Memory) Walk(fn func(key string, e *Entry)) error {
+	m.lock.RLock()
+	entries := make(map[string]*Entry, len(m.entries))
+	for key, e := range m.entries {
+		entries[key] = e
+	}
+	m.lock.RUnlock()
+
+	for key, e := range entries {
+		fn(key, e)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package ipfeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// retryInterval is how long to wait before downloading a list again
+	// after a download failed.
+	retryInterval = time.Minute
+
+	// fetchTimeout is how long a download in the background may take.
+	fetchTimeout = time.Minute
+)
+
+// fetcher keeps a downloaded list up to date.
+type fetcher struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	set atomic.Pointer[Set]
+
+	lock         sync.Mutex
+	fetching     bool
+	nextFetch    time.Time
+	etag         string
+	lastModified string
+}
+
+// current returns the list, or nil if it was never downloaded, and starts a
+// download in the background if the list is stale.
+func (f *fetcher) current() *Set {
+	f.lock.Lock()
+	if !f.fetching && !time.Now().Before(f.nextFetch) {
+		f.fetching = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+			defer cancel()
+
+			if err := f.fetch(ctx); err != nil {
+				slog.Error("can't download ip feed, retrying later", "host", hostOf(f.url), "retry_in", retryInterval, "err", err)
+			}
+		}()
+	}
+	f.lock.Unlock()
+
+	return f.set.Load()
+}
+
+func (f *fetcher) fetch(ctx context.Context) error {
+	set, err := f.download(ctx)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.fetching = false
+
+	if err != nil {
+		f.nextFetch = time.Now().Add(retryInterval)
+		return err
+	}
+
+	f.nextFetch = time.Now().Add(f.ttl)
+	if set != nil {
+		f.set.Store(set)
+		slog.Debug("downloaded ip feed", "host", hostOf(f.url), "entries", set.Len())
+	}
+
+	return nil
+}
+
+// download gets the list. It returns a nil Set if the list didn't change
+// since the last download.
+func (f *fetcher) download(ctx context.Context) (*Set, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	if f.set.Load() != nil {
+		if f.etag != "" {
+			req.Header.Set("If-None-Match", f.etag)
+		}
+		if f.lastModified != "" {
+			req.Header.Set("If-Modified-Since", f.lastModified)
+		}
+	}
+	f.lock.Unlock()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		// Leave out the URL, feeds often have an API key in it.
+		if uerr := (*url.Error)(nil); errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("ipfeed: server returned %s", resp.Status)
+	}
+
+	set, err := Parse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	f.lock.Unlock()
+
+	return set, nil
+}
+
+// hostOf returns the host of u for logging.
+func hostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
@@ -0,0 +1,123 @@
+// Package ipfeed looks up client IP addresses in reputation feeds, such as
+// DNSBLs and lists of IP addresses and CIDR ranges published on the web.
+package ipfeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vale981/anubis/internal/dnsbl"
+)
+
+// ErrNotLoaded is returned by HTTPList.Lookup until the list was downloaded
+// for the first time.
+var ErrNotLoaded = errors.New("ipfeed: list is not downloaded yet")
+
+// Result is what a feed knows about an IP address.
+type Result struct {
+	Listed bool `json:"listed"`
+
+	// Detail says why the address is listed, such as the DroneBL category
+	// or the answer of a DNSBL.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Provider looks up IP addresses in a feed.
+type Provider interface {
+	Lookup(ctx context.Context, ip net.IP) (Result, error)
+}
+
+// DNSBL is a Provider that queries a DNS blocklist, with a circuit breaker so
+// that an outage of the list doesn't slow down every request.
+type DNSBL struct {
+	Zone    string
+	Breaker *dnsbl.Breaker
+
+	// Timeout, if set, is how long a lookup may take.
+	Timeout time.Duration
+}
+
+// NewDNSBL creates a DNSBL provider for zone. If resolver is set, it is the
+// address of the DNS server to query instead of the system resolver.
+func NewDNSBL(zone, resolver string, timeout time.Duration) *DNSBL {
+	b := dnsbl.NewZoneBreaker(zone, dnsbl.DefaultFailureBudget, dnsbl.DefaultCooldown)
+	if resolver != "" {
+		b.Resolver = dnsbl.NewResolver(resolver)
+	}
+
+	return &DNSBL{
+		Zone:    zone,
+		Breaker: b,
+		Timeout: timeout,
+	}
+}
+
+// Lookup queries the DNSBL for ip. It returns dnsbl.ErrBreakerOpen while
+// lookups are paused.
+func (d *DNSBL) Lookup(ctx context.Context, ip net.IP) (Result, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	resp, err := d.Breaker.Lookup(ctx, ip.String())
+	if err != nil {
+		return Result{}, err
+	}
+
+	if resp == dnsbl.AllGood {
+		return Result{}, nil
+	}
+
+	if d.Zone == dnsbl.DroneBLZone {
+		return Result{Listed: true, Detail: resp.String()}, nil
+	}
+
+	return Result{Listed: true, Detail: fmt.Sprintf("127.0.0.%d", byte(resp))}, nil
+}
+
+// HTTPList is a Provider backed by a list of IP addresses and CIDR ranges
+// that is downloaded from a web server, see Parse for the formats it
+// understands. The list is downloaded again in the background once it is
+// older than TTL, and requests keep using the old one until then.
+type HTTPList struct {
+	URL string
+	TTL time.Duration
+
+	fetcher *fetcher
+}
+
+// NewHTTPList creates an HTTPList for url. Downloads use client.
+func NewHTTPList(url string, ttl time.Duration, client *http.Client) *HTTPList {
+	return &HTTPList{
+		URL:     url,
+		TTL:     ttl,
+		fetcher: &fetcher{url: url, ttl: ttl, client: client},
+	}
+}
+
+// Lookup checks whether ip is on the list. If the list is stale, a download
+// is started in the background. Until the first download succeeded, Lookup
+// returns ErrNotLoaded.
+func (l *HTTPList) Lookup(ctx context.Context, ip net.IP) (Result, error) {
+	set := l.fetcher.current()
+	if set == nil {
+		return Result{}, ErrNotLoaded
+	}
+
+	if prefix, ok := set.Contains(ip); ok {
+		return Result{Listed: true, Detail: prefix}, nil
+	}
+
+	return Result{}, nil
+}
+
+// Refresh downloads the list now, replacing the one in use if it succeeds.
+func (l *HTTPList) Refresh(ctx context.Context) error {
+	return l.fetcher.fetch(ctx)
+}
@@ -0,0 +1,132 @@
+package ipfeed
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPList(t *testing.T) {
+	var notModified atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintln(w, "198.51.100.0/24")
+	}))
+	defer srv.Close()
+
+	l := NewHTTPList(srv.URL, time.Hour, srv.Client())
+
+	if _, err := l.Lookup(t.Context(), net.ParseIP("198.51.100.1")); !errors.Is(err, ErrNotLoaded) {
+		t.Fatalf("wanted ErrNotLoaded before the first download, got: %v", err)
+	}
+
+	if err := l.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := l.Lookup(t.Context(), net.ParseIP("198.51.100.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Listed || res.Detail != "198.51.100.0/24" {
+		t.Errorf("wanted 198.51.100.1 to be listed by 198.51.100.0/24, got: %+v", res)
+	}
+
+	res, err = l.Lookup(t.Context(), net.ParseIP("203.0.113.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Listed {
+		t.Errorf("wanted 203.0.113.1 not to be listed, got: %+v", res)
+	}
+
+	if err := l.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if notModified.Load() != 1 {
+		t.Errorf("wanted the second download to be conditional")
+	}
+	if res, _ := l.Lookup(t.Context(), net.ParseIP("198.51.100.1")); !res.Listed {
+		t.Error("wanted the list to be kept when it didn't change")
+	}
+}
+
+func TestHTTPListKeepsListOnFailure(t *testing.T) {
+	var fail atomic.Bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "oops", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintln(w, "198.51.100.7")
+	}))
+	defer srv.Close()
+
+	l := NewHTTPList(srv.URL, time.Hour, srv.Client())
+	if err := l.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	fail.Store(true)
+	if err := l.Refresh(t.Context()); err == nil {
+		t.Fatal("wanted an error when the server fails")
+	}
+
+	if res, _ := l.Lookup(t.Context(), net.ParseIP("198.51.100.7")); !res.Listed {
+		t.Error("wanted the old list to be used after a failed download")
+	}
+}
+
+func TestHTTPListDownloadsInBackground(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "198.51.100.7")
+	}))
+	defer srv.Close()
+
+	l := NewHTTPList(srv.URL, time.Hour, srv.Client())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := l.Lookup(t.Context(), net.ParseIP("198.51.100.7"))
+		if err == nil && res.Listed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("list was not downloaded in the background, last error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDNSBLTimeout(t *testing.T) {
+	// A DNS server that never answers.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := NewDNSBL("dnsbl.example", conn.LocalAddr().String(), 50*time.Millisecond)
+
+	start := time.Now()
+	if _, err := d.Lookup(t.Context(), net.ParseIP("198.51.100.1")); err == nil {
+		t.Fatal("wanted an error from a resolver that doesn't answer")
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("wanted the lookup to give up after the timeout, took: %s", took)
+	}
+}
@@ -0,0 +1,201 @@
+package ipfeed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// maxListSize is the largest list Parse reads, so that a broken or hostile
+// feed can't use up memory.
+const maxListSize = 64 << 20
+
+var (
+	ErrTooLarge = errors.New("ipfeed: list is larger than 64 MiB")
+	ErrEmpty    = errors.New("ipfeed: list contains no IP addresses or CIDR ranges")
+)
+
+// Set is a set of IP addresses and CIDR ranges.
+type Set struct {
+	// prefixes holds the ranges by their length, so that looking up an
+	// address takes one map lookup per distinct length.
+	prefixes map[int]map[netip.Prefix]struct{}
+	lengths  []int
+	size     int
+}
+
+func newSet() *Set {
+	return &Set{prefixes: map[int]map[netip.Prefix]struct{}{}}
+}
+
+func (s *Set) add(p netip.Prefix) {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		// Keep IPv4 and IPv6 lengths apart, an IPv4 /24 is not an IPv6 /24.
+		p = netip.PrefixFrom(netip.AddrFrom16(p.Addr().As16()), p.Bits()+96)
+	}
+
+	byLength, ok := s.prefixes[p.Bits()]
+	if !ok {
+		byLength = map[netip.Prefix]struct{}{}
+		s.prefixes[p.Bits()] = byLength
+		s.lengths = append(s.lengths, p.Bits())
+		slices.Sort(s.lengths)
+	}
+
+	if _, ok := byLength[p]; !ok {
+		byLength[p] = struct{}{}
+		s.size++
+	}
+}
+
+// Len returns the number of addresses and ranges in the set.
+func (s *Set) Len() int {
+	return s.size
+}
+
+// Contains reports whether ip is in the set, and if so, the widest range
+// that contains it.
+func (s *Set) Contains(ip net.IP) (string, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+	addr = netip.AddrFrom16(addr.As16())
+
+	for _, bits := range s.lengths {
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := s.prefixes[bits][p]; ok {
+			return unmap(p).String(), true
+		}
+	}
+
+	return "", false
+}
+
+func unmap(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() {
+		return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p
+}
+
+// Parse reads a list of IP addresses and CIDR ranges. It understands:
+//
+//   - text with one address or range per line, such as the Tor exit list,
+//     where anything after the first word or after # or ; is ignored, like
+//     the comments in Spamhaus DROP;
+//   - JSON documents, or one JSON document per line, where every string that
+//     is an address or range is used, such as the ranges published by AWS
+//     and Google Cloud.
+func Parse(r io.Reader) (*Set, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxListSize {
+		return nil, ErrTooLarge
+	}
+
+	set := newSet()
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		err = parseJSON(set, trimmed)
+	} else {
+		err = parseText(set, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if set.Len() == 0 {
+		return nil, ErrEmpty
+	}
+
+	return set, nil
+}
+
+func parseText(set *Set, data []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if p, ok := parsePrefix(fields[0]); ok {
+			set.add(p)
+		}
+	}
+
+	return sc.Err()
+}
+
+func parseJSON(set *Set, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var doc any
+		if err := dec.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		walkStrings(doc, func(s string) {
+			if p, ok := parsePrefix(s); ok {
+				set.add(p)
+			}
+		})
+	}
+}
+
+func walkStrings(v any, fn func(string)) {
+	switch v := v.(type) {
+	case string:
+		fn(v)
+	case []any:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case map[string]any:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	}
+}
+
+// parsePrefix parses an IP address or CIDR range. Addresses become ranges
+// of one address.
+func parsePrefix(s string) (netip.Prefix, bool) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p, err == nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
@@ -0,0 +1,100 @@
+package ipfeed
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		listed  []string
+		notList []string
+		err     error
+	}{
+		{
+			name:    "tor exit list",
+			input:   "185.220.101.1\n185.220.101.2\n2001:db8::1\n",
+			listed:  []string{"185.220.101.1", "2001:db8::1"},
+			notList: []string{"185.220.101.3", "2001:db8::2"},
+		},
+		{
+			name:    "spamhaus drop",
+			input:   "; Spamhaus DROP List 2025/01/01\n; Last-Modified: Wed, 01 Jan 2025 00:00:00 GMT\n1.10.16.0/20 ; SBL256894\n2.56.192.0/22 ; SBL459831\n",
+			listed:  []string{"1.10.16.1", "1.10.31.255", "2.56.195.9"},
+			notList: []string{"1.10.32.0", "2.56.196.0"},
+		},
+		{
+			name:    "comments and garbage",
+			input:   "# exits\n192.0.2.7 # a relay\nnot an address\n\n",
+			listed:  []string{"192.0.2.7"},
+			notList: []string{"192.0.2.8"},
+		},
+		{
+			name:    "aws ip ranges",
+			input:   `{"syncToken": "1700000000", "prefixes": [{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2"}], "ipv6_prefixes": [{"ipv6_prefix": "2600:1f14::/35"}]}`,
+			listed:  []string{"3.5.141.1", "2600:1f14::1"},
+			notList: []string{"3.5.144.1", "2600:1f15::1"},
+		},
+		{
+			name:    "spamhaus drop json",
+			input:   "{\"cidr\":\"1.10.16.0/20\",\"sblid\":\"SBL256894\",\"rir\":\"apnic\"}\n{\"type\":\"metadata\",\"timestamp\":1700000000}\n",
+			listed:  []string{"1.10.16.1"},
+			notList: []string{"1.10.32.1"},
+		},
+		{
+			name:  "empty",
+			input: "# nothing here\n",
+			err:   ErrEmpty,
+		},
+		{
+			name:  "error page",
+			input: "<html><body>Service Unavailable</body></html>",
+			err:   ErrEmpty,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := Parse(strings.NewReader(tt.input))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("wanted error %v, got: %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			for _, ip := range tt.listed {
+				if _, ok := set.Contains(net.ParseIP(ip)); !ok {
+					t.Errorf("wanted %s to be listed", ip)
+				}
+			}
+			for _, ip := range tt.notList {
+				if prefix, ok := set.Contains(net.ParseIP(ip)); ok {
+					t.Errorf("wanted %s not to be listed, got: %s", ip, prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestSetContainsIPv4InIPv6(t *testing.T) {
+	set, err := Parse(strings.NewReader("192.0.2.0/24\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// net.ParseIP returns 16 byte slices for IPv4 addresses.
+	prefix, ok := set.Contains(net.ParseIP("192.0.2.1").To16())
+	if !ok {
+		t.Fatal("wanted IPv4 address in 16 byte form to be listed")
+	}
+	if prefix != "192.0.2.0/24" {
+		t.Errorf("wanted range 192.0.2.0/24, got: %s", prefix)
+	}
+
+	if _, ok := set.Contains(net.ParseIP("::c000:201")); ok {
+		t.Error("wanted IPv6 address with the same bits not to be listed")
+	}
+}
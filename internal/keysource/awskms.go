@@ -0,0 +1,206 @@
+package keysource
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrKMSFile        = errors.New("keysource: awskms source must look like awskms:<fname>")
+	ErrKMSRegion      = errors.New("keysource: awskms source needs AWS_REGION")
+	ErrKMSCredentials = errors.New("keysource: awskms source needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+)
+
+// awsCredentials are the credentials requests to AWS are signed with.
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// awsKMS decrypts a ciphertext blob holding the key with the KMS Decrypt
+// API. Only credentials from the environment are supported, instances that
+// get theirs otherwise can use an exec source with the AWS CLI.
+type awsKMS struct {
+	fname    string
+	region   string
+	endpoint string
+	creds    awsCredentials
+}
+
+func newAWSKMS(spec string) (*awsKMS, error) {
+	if spec == "" {
+		return nil, ErrKMSFile
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, ErrKMSRegion
+	}
+
+	creds := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return nil, ErrKMSCredentials
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_KMS")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+
+	return &awsKMS{
+		fname:    spec,
+		region:   region,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		creds:    creds,
+	}, nil
+}
+
+func (k *awsKMS) Fetch(ctx context.Context) (ed25519.PrivateKey, error) {
+	blob, err := os.ReadFile(k.fname)
+	if err != nil {
+		return nil, fmt.Errorf("keysource: can't read ciphertext: %w", err)
+	}
+
+	// The AWS CLI prints ciphertext blobs base64-encoded, accept them as
+	// they are as well as decoded.
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(blob))); err == nil {
+		blob = decoded
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"CiphertextBlob": base64.StdEncoding.EncodeToString(blob),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("keysource: can't make kms request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	signV4(req, body, k.creds, k.region, "kms", time.Now())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("keysource: can't decrypt %s with kms: %w", k.fname, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("keysource: can't decrypt %s with kms: %s: %s", k.fname, resp.Status, msg)
+	}
+
+	var result struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("keysource: can't decode kms response: %w", err)
+	}
+
+	if len(result.Plaintext) == ed25519.SeedSize {
+		return ed25519.NewKeyFromSeed(result.Plaintext), nil
+	}
+
+	priv, err := parseSeed(result.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%w, in ciphertext %s", err, k.fname)
+	}
+
+	return priv, nil
+}
+
+// signV4 signs req, which has body, with AWS Signature Version 4. Only the
+// host, content type and X-Amz-* headers are signed.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.Join(v, ",")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, strings.TrimSpace(headers[k]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,49 @@
+package keysource
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var ErrNoCommand = errors.New("keysource: exec source needs a command")
+
+// command runs a program that prints the key, such as a wrapper around the
+// CLI of a secret store that Anubis doesn't support itself.
+type command struct {
+	args []string
+}
+
+func newExec(spec string) (*command, error) {
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return nil, ErrNoCommand
+	}
+
+	return &command{args: args}, nil
+}
+
+func (c *command) Fetch(ctx context.Context) (ed25519.PrivateKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("keysource: %s failed: %w: %s", c.args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	priv, err := parseSeed(out)
+	if err != nil {
+		return nil, fmt.Errorf("%w, in the output of %s", err, c.args[0])
+	}
+
+	return priv, nil
+}
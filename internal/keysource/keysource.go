@@ -0,0 +1,82 @@
+// Package keysource fetches the ed25519 signing key of Anubis from a secret
+// store, so that fleets can manage the key centrally instead of putting it
+// in the environment of every instance.
+package keysource
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownSource = errors.New("keysource: key source must start with vault:, awskms: or exec:")
+	ErrInvalidKey    = errors.New("keysource: key must be a hex-encoded 32 byte ed25519 seed")
+)
+
+// fetchTimeout is how long fetching the key may take.
+const fetchTimeout = 30 * time.Second
+
+// Source is somewhere the signing key is kept.
+type Source interface {
+	// Fetch returns the current signing key.
+	Fetch(ctx context.Context) (ed25519.PrivateKey, error)
+}
+
+// Parse returns the Source that spec describes:
+//
+//   - vault:<path>#<field> reads field from the secret at path with the
+//     Vault HTTP API, such as vault:secret/data/anubis#signing_key. VAULT_ADDR,
+//     VAULT_TOKEN and VAULT_NAMESPACE are used as by the Vault CLI.
+//   - awskms:<fname> decrypts the ciphertext blob in fname with AWS KMS.
+//     Credentials and the region are taken from the standard AWS_*
+//     environment variables.
+//   - exec:<command> runs command and reads the key from its standard
+//     output. The command is split on spaces and not run by a shell.
+//
+// Every source must return the key as a hex-encoded seed, the same format
+// as ED25519_PRIVATE_KEY_HEX.
+func Parse(spec string) (Source, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "vault":
+		return newVault(rest)
+	case "awskms":
+		return newAWSKMS(rest)
+	case "exec":
+		return newExec(rest)
+	default:
+		return nil, fmt.Errorf("%w, got: %q", ErrUnknownSource, spec)
+	}
+}
+
+// Fetch returns the key from spec once, for callers that don't keep the
+// Source around.
+func Fetch(ctx context.Context, spec string) (ed25519.PrivateKey, error) {
+	src, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return src.Fetch(ctx)
+}
+
+// parseSeed decodes a hex-encoded ed25519 seed, ignoring surrounding
+// whitespace.
+func parseSeed(data []byte) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidKey
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
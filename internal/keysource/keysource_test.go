@@ -0,0 +1,192 @@
+package keysource
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestParse(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	for _, tt := range []struct {
+		spec string
+		want error
+	}{
+		{spec: "file:/etc/anubis.key", want: ErrUnknownSource},
+		{spec: "vault:secret/data/anubis", want: ErrVaultPath},
+		{spec: "vault:#key", want: ErrVaultPath},
+		{spec: "awskms:", want: ErrKMSFile},
+		{spec: "awskms:/etc/anubis.key.enc", want: ErrKMSRegion},
+		{spec: "exec: ", want: ErrNoCommand},
+		{spec: "vault:secret/data/anubis#key"},
+		{spec: "exec:vault kv get -field=key secret/anubis"},
+	} {
+		t.Run(tt.spec, func(t *testing.T) {
+			if _, err := Parse(tt.spec); !errors.Is(err, tt.want) {
+				t.Errorf("wanted error %v, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestVault(t *testing.T) {
+	priv := newKey(t)
+	seed := hex.EncodeToString(priv.Seed())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "hunter2" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/anubis":
+			fmt.Fprintf(w, `{"data":{"data":{"signing_key":%q},"metadata":{"version":3}}}`, seed)
+		case "/v1/kv/anubis":
+			fmt.Fprintf(w, `{"data":{"signing_key":%q}}`, seed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "hunter2")
+
+	for _, tt := range []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "vault:secret/data/anubis#signing_key"},
+		{spec: "vault:kv/anubis#signing_key"},
+		{spec: "vault:secret/data/anubis#other_key", wantErr: true},
+		{spec: "vault:secret/data/missing#signing_key", wantErr: true},
+	} {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := Fetch(context.Background(), tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wanted error: %v, got: %v", tt.wantErr, err)
+			}
+			if err == nil && !got.Equal(priv) {
+				t.Error("got the wrong key")
+			}
+		})
+	}
+}
+
+func TestExec(t *testing.T) {
+	priv := newKey(t)
+
+	got, err := Fetch(context.Background(), "exec:echo "+hex.EncodeToString(priv.Seed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(priv) {
+		t.Error("got the wrong key")
+	}
+
+	if _, err := Fetch(context.Background(), "exec:echo not-a-key"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("wanted error %v, got: %v", ErrInvalidKey, err)
+	}
+	if _, err := Fetch(context.Background(), "exec:false"); err == nil {
+		t.Error("failing command didn't return an error")
+	}
+}
+
+func TestAWSKMS(t *testing.T) {
+	priv := newKey(t)
+	ciphertext := []byte("encrypted by kms")
+
+	for _, tt := range []struct {
+		name      string
+		plaintext []byte
+	}{
+		{name: "hex", plaintext: []byte(hex.EncodeToString(priv.Seed()) + "\n")},
+		{name: "raw", plaintext: priv.Seed()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" {
+					http.Error(w, "wrong target", http.StatusBadRequest)
+					return
+				}
+				if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || r.Header.Get("X-Amz-Security-Token") != "session" {
+					http.Error(w, "not signed", http.StatusForbidden)
+					return
+				}
+
+				var req struct {
+					CiphertextBlob []byte
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || string(req.CiphertextBlob) != string(ciphertext) {
+					http.Error(w, "wrong ciphertext", http.StatusBadRequest)
+					return
+				}
+
+				_ = json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": tt.plaintext})
+			}))
+			defer srv.Close()
+
+			t.Setenv("AWS_REGION", "eu-central-1")
+			t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			t.Setenv("AWS_SESSION_TOKEN", "session")
+			t.Setenv("AWS_ENDPOINT_URL_KMS", srv.URL)
+
+			fname := filepath.Join(t.TempDir(), "signing-key.enc")
+			if err := os.WriteFile(fname, []byte(base64.StdEncoding.EncodeToString(ciphertext)+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Fetch(context.Background(), "awskms:"+fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(priv) {
+				t.Error("got the wrong key")
+			}
+		})
+	}
+}
+
+// TestSignV4 checks signV4 against the post-vanilla case of the AWS
+// Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+	req.Header = http.Header{}
+
+	creds := awsCredentials{
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("wanted %s\ngot:   %s", want, got)
+	}
+}
@@ -0,0 +1,96 @@
+package keysource
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var ErrVaultPath = errors.New("keysource: vault source must look like vault:<path>#<field>")
+
+// vault reads the key from a field of a Vault secret. Both version 1 and
+// version 2 of the KV secrets engine are supported, for version 2 the path
+// includes "data/".
+type vault struct {
+	addr      string
+	token     string
+	namespace string
+	path      string
+	field     string
+}
+
+func newVault(spec string) (*vault, error) {
+	path, field, ok := strings.Cut(spec, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || field == "" {
+		return nil, fmt.Errorf("%w, got: %q", ErrVaultPath, spec)
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = "https://127.0.0.1:8200"
+	}
+
+	return &vault{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		path:      path,
+		field:     field,
+	}, nil
+}
+
+func (v *vault) Fetch(ctx context.Context) (ed25519.PrivateKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("keysource: can't make vault request: %w", err)
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("keysource: can't read %s from vault: %w", v.path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keysource: can't read %s from vault: %s", v.path, resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("keysource: can't decode vault secret %s: %w", v.path, err)
+	}
+
+	// KV version 2 nests the fields of the secret in another data object.
+	fields := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			fields = secret.Data
+		}
+	}
+
+	var value string
+	if err := json.Unmarshal(fields[v.field], &value); err != nil {
+		return nil, fmt.Errorf("keysource: vault secret %s has no string field %q", v.path, v.field)
+	}
+
+	priv, err := parseSeed([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("%w, in vault secret %s", err, v.path)
+	}
+
+	return priv, nil
+}
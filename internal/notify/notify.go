@@ -0,0 +1,361 @@
+// Package notify sends events about denied requests to webhooks, batched so
+// that a burst of denies turns into one message.
+package notify
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	FormatGeneric = "generic"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+const (
+	// DefaultInterval is how often batches of events are sent.
+	DefaultInterval = 10 * time.Second
+
+	// queueSize is how many events can wait to be batched. Events beyond
+	// that are dropped, so that a flood of denies can't use up memory.
+	queueSize = 1000
+
+	// maxBatch is the most events sent in one request.
+	maxBatch = 100
+
+	// retries is how often a failed request is retried.
+	retries = 3
+
+	// discordLimit is the longest message Discord accepts.
+	discordLimit = 2000
+)
+
+var (
+	// retryBackoff is how long to wait before the first retry. It doubles
+	// with every retry.
+	retryBackoff = time.Second
+
+	ErrNoHost        = errors.New("notify: webhook URL has no host")
+	ErrUnknownScheme = errors.New("notify: webhook URL must be http or https")
+
+	webhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_webhook_events",
+		Help: "The number of events sent to webhooks, by whether they were sent, dropped because the queue was full, or failed",
+	}, []string{"result"})
+)
+
+// Event is something Anubis notifies about.
+type Event struct {
+	Time time.Time `json:"time"`
+
+	// Type is "deny" for requests denied by a bot rule, "tarpit" for
+	// requests held by a TARPIT rule, "dnsbl" for requests from clients on
+	// DroneBL, and "ip_feed" for requests from clients on other IP feeds.
+	Type      string `json:"type"`
+	Rule      string `json:"rule"`
+	Hash      string `json:"hash,omitempty"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
+	Host      string `json:"host"`
+	Path      string `json:"path"`
+}
+
+// Webhook is a URL that events are posted to, and the format it expects.
+type Webhook struct {
+	URL    string
+	Format string
+}
+
+// ParseWebhooks parses a comma-separated list of webhook URLs. The format of
+// each is guessed from its host, or can be set by putting "generic:",
+// "slack:" or "discord:" in front of the URL.
+func ParseWebhooks(val string) ([]Webhook, error) {
+	var result []Webhook
+
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		format := ""
+		if prefix, rest, ok := strings.Cut(entry, ":"); ok {
+			switch prefix {
+			case FormatGeneric, FormatSlack, FormatDiscord:
+				format, entry = prefix, rest
+			}
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("notify: can't parse webhook URL: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("%w, got: %q", ErrUnknownScheme, u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, ErrNoHost
+		}
+
+		if format == "" {
+			format = guessFormat(u)
+		}
+
+		result = append(result, Webhook{URL: u.String(), Format: format})
+	}
+
+	return result, nil
+}
+
+func guessFormat(u *url.URL) string {
+	switch {
+	case u.Hostname() == "hooks.slack.com":
+		return FormatSlack
+	case (u.Hostname() == "discord.com" || u.Hostname() == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return FormatDiscord
+	default:
+		return FormatGeneric
+	}
+}
+
+// Notifier collects events and posts them to webhooks in batches. Run must be
+// running for events to be sent.
+type Notifier struct {
+	Webhooks []Webhook
+
+	// Rules, if set, limits events to the bot rules with these names.
+	Rules []string
+
+	// Interval is how often batches are sent.
+	Interval time.Duration
+
+	Client *http.Client
+
+	events chan Event
+}
+
+// New creates a Notifier that posts to webhooks.
+func New(webhooks []Webhook) *Notifier {
+	return &Notifier{
+		Webhooks: webhooks,
+		Interval: DefaultInterval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		events:   make(chan Event, queueSize),
+	}
+}
+
+// Notify queues ev to be sent with the next batch. It never blocks: if the
+// queue is full, ev is dropped. It does nothing if n is nil.
+func (n *Notifier) Notify(ev Event) {
+	if n == nil {
+		return
+	}
+
+	if len(n.Rules) != 0 && !slices.Contains(n.Rules, ev.Rule) {
+		return
+	}
+
+	select {
+	case n.events <- ev:
+	default:
+		webhookEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run sends batches of events until ctx is canceled, and then sends the
+// events that are left.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.Interval)
+	defer ticker.Stop()
+
+	var batch []Event
+	flush := func(ctx context.Context) {
+		for len(batch) > 0 {
+			size := min(len(batch), maxBatch)
+			n.send(ctx, batch[:size])
+			batch = batch[size:]
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case ev := <-n.events:
+			batch = append(batch, ev)
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for len(n.events) > 0 {
+				batch = append(batch, <-n.events)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			flush(ctx)
+			return
+		}
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, batch []Event) {
+	for _, wh := range n.Webhooks {
+		body, err := payload(wh.Format, batch)
+		if err != nil {
+			slog.Error("can't encode webhook payload", "format", wh.Format, "err", err)
+			webhookEvents.WithLabelValues("failed").Add(float64(len(batch)))
+			continue
+		}
+
+		if err := n.post(ctx, wh.URL, body); err != nil {
+			slog.Error("can't send events to webhook", "host", hostOf(wh.URL), "events", len(batch), "err", err)
+			webhookEvents.WithLabelValues("failed").Add(float64(len(batch)))
+			continue
+		}
+
+		webhookEvents.WithLabelValues("sent").Add(float64(len(batch)))
+	}
+}
+
+// post sends body to u, retrying with exponential backoff if the request
+// fails or the server has a problem.
+func (n *Notifier) post(ctx context.Context, u string, body []byte) error {
+	var err error
+
+	for attempt := range retries + 1 {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff << (attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var retry bool
+		retry, err = n.postOnce(ctx, u, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (n *Notifier) postOnce(ctx context.Context, u string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		// Leave out the URL, which often contains a secret.
+		if uerr := (*url.Error)(nil); errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// hostOf returns the host of u for logging, as webhook URLs often contain
+// secrets in the path.
+func hostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func payload(format string, batch []Event) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": summary(batch)})
+	case FormatDiscord:
+		text := summary(batch)
+		if len(text) > discordLimit {
+			text = strings.ToValidUTF8(text[:discordLimit-1], "") + "…"
+		}
+		return json.Marshal(map[string]string{"content": text})
+	default:
+		return json.Marshal(map[string][]Event{"events": batch})
+	}
+}
+
+// summary describes a batch for chat, with one line for every rule.
+func summary(batch []Event) string {
+	type group struct {
+		rule, hash string
+		example    Event
+		count      int
+	}
+
+	groups := map[string]*group{}
+	for _, ev := range batch {
+		g, ok := groups[ev.Rule]
+		if !ok {
+			g = &group{rule: ev.Rule, hash: ev.Hash, example: ev}
+			groups[ev.Rule] = g
+		}
+		g.count++
+	}
+
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	slices.SortFunc(sorted, func(a, b *group) int {
+		return cmp.Or(cmp.Compare(b.count, a.count), cmp.Compare(a.rule, b.rule))
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Anubis denied %d %s:", len(batch), plural(len(batch), "request", "requests"))
+	for _, g := range sorted {
+		fmt.Fprintf(&sb, "\n- %s: %d %s, such as %s from %s on %s%s", g.rule, g.count, plural(g.count, "request", "requests"), quoteUA(g.example.UserAgent), g.example.ClientIP, g.example.Host, g.example.Path)
+		if g.hash != "" {
+			fmt.Fprintf(&sb, " (rule hash %s)", g.hash)
+		}
+	}
+
+	return sb.String()
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+func quoteUA(ua string) string {
+	if ua == "" {
+		return "a client without user agent"
+	}
+	return fmt.Sprintf("%q", ua)
+}
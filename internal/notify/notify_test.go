@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseWebhooks(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		want    []Webhook
+		wantErr error
+	}{
+		{
+			name:  "guessed",
+			input: "https://example.com/hook, https://hooks.slack.com/services/T/B/X,https://discord.com/api/webhooks/1/abc",
+			want: []Webhook{
+				{URL: "https://example.com/hook", Format: FormatGeneric},
+				{URL: "https://hooks.slack.com/services/T/B/X", Format: FormatSlack},
+				{URL: "https://discord.com/api/webhooks/1/abc", Format: FormatDiscord},
+			},
+		},
+		{
+			name:  "explicit",
+			input: "slack:https://chat.example.com/hooks/abc",
+			want: []Webhook{
+				{URL: "https://chat.example.com/hooks/abc", Format: FormatSlack},
+			},
+		},
+		{
+			name:    "bad_scheme",
+			input:   "ftp://example.com/hook",
+			wantErr: ErrUnknownScheme,
+		},
+		{
+			name:    "no_host",
+			input:   "https:///hook",
+			wantErr: ErrNoHost,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWebhooks(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("wanted error %v, got: %v", tt.wantErr, err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("wanted %d webhooks, got: %v", len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("webhook %d: wanted %+v, got: %+v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+type receiver struct {
+	lock   sync.Mutex
+	bodies []string
+	fails  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.fails > 0 {
+		rc.fails--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	rc.bodies = append(rc.bodies, string(body))
+}
+
+func run(t *testing.T, rc *receiver, format string, events ...Event) []string {
+	t.Helper()
+
+	oldBackoff := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = oldBackoff })
+
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	n := New([]Webhook{{URL: srv.URL, Format: format}})
+	n.Rules = []string{"bad-bot"}
+	n.Interval = time.Hour
+
+	for _, ev := range events {
+		n.Notify(ev)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n.Run(ctx)
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.bodies
+}
+
+var events = []Event{
+	{Type: "deny", Rule: "bad-bot", Hash: "abc", ClientIP: "198.51.100.1", UserAgent: "BadBot/1", Host: "example.com", Path: "/"},
+	{Type: "deny", Rule: "bad-bot", Hash: "abc", ClientIP: "198.51.100.2", UserAgent: "BadBot/2", Host: "example.com", Path: "/feed"},
+	{Type: "dnsbl", Rule: "dnsbl", ClientIP: "198.51.100.3", Host: "example.com", Path: "/"},
+}
+
+func TestGeneric(t *testing.T) {
+	bodies := run(t, &receiver{fails: 2}, FormatGeneric, events...)
+	if len(bodies) != 1 {
+		t.Fatalf("wanted one batch after retrying, got: %q", bodies)
+	}
+
+	var payload struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Events) != 2 || payload.Events[1].UserAgent != "BadBot/2" {
+		t.Errorf("wanted the two events of bad-bot, got: %+v", payload.Events)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	bodies := run(t, &receiver{}, FormatSlack, events...)
+	if len(bodies) != 1 {
+		t.Fatalf("wanted one message, got: %q", bodies)
+	}
+
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `Anubis denied 2 requests:
+- bad-bot: 2 requests, such as "BadBot/1" from 198.51.100.1 on example.com/ (rule hash abc)`
+	if payload.Text != want {
+		t.Errorf("wanted message:\n%s\ngot:\n%s", want, payload.Text)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	bodies := run(t, &receiver{fails: retries + 1}, FormatGeneric, events[0])
+	if len(bodies) != 0 {
+		t.Errorf("wanted no delivery after %d retries, got: %q", retries, bodies)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(events[0])
+}
+
+func TestDiscordLimit(t *testing.T) {
+	var batch []Event
+	for i := range maxBatch {
+		ev := events[0]
+		ev.Rule = fmt.Sprintf("rule-%d", i)
+		ev.UserAgent = strings.Repeat("ü", 50)
+		batch = append(batch, ev)
+	}
+
+	body, err := payload(FormatDiscord, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Content) > discordLimit+len("…") {
+		t.Errorf("wanted at most %d bytes, got: %d", discordLimit, len(msg.Content))
+	}
+}
@@ -1,18 +1,46 @@
 package ogtags
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net/url"
 	"syscall"
+	"time"
+
+	"github.com/vale981/anubis/lib/store"
 )
 
 // GetOGTags is the main function that retrieves Open Graph tags for a URL
 func (c *OGTagCache) GetOGTags(url *url.URL) (map[string]string, error) {
+	tags, err := c.getTags(url)
+	if err != nil || tags[OEmbedKey] == "" {
+		return tags, err
+	}
+
+	// Point the oEmbed link at Anubis, so that clients can follow it
+	// without passing the challenge.
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		result[key] = value
+	}
+	if link, ok := c.oEmbedLink(url, tags[OEmbedKey]); ok {
+		result[OEmbedKey] = link
+	}
+
+	return result, nil
+}
+
+// getTags returns the tags of url as they were found on the page.
+func (c *OGTagCache) getTags(url *url.URL) (map[string]string, error) {
 	if url == nil {
 		return nil, errors.New("nil URL provided, cannot fetch OG tags")
 	}
-	urlStr := c.getTarget(url)
+	urlStr, ok := c.getTarget(url)
+	if !ok {
+		slog.Debug("og: page left out by the filter", "path", url.Path)
+		return nil, nil
+	}
 	// Check cache first
 	if cachedTags := c.checkCache(urlStr); cachedTags != nil {
 		return cachedTags, nil
@@ -35,17 +63,45 @@ func (c *OGTagCache) GetOGTags(url *url.URL) (map[string]string, error) {
 	ogTags := c.extractOGTags(doc)
 
 	// Store in cache
-	c.cache.Set(urlStr, ogTags, c.ogTimeToLive)
+	c.setCache(urlStr, ogTags, c.ogTimeToLive)
 
 	return ogTags, nil
 }
 
 // checkCache checks if we have the tags cached and returns them if so
 func (c *OGTagCache) checkCache(urlStr string) map[string]string {
-	if cachedTags, ok := c.cache.Get(urlStr); ok {
+	var (
+		cachedTags map[string]string
+		err        error
+	)
+	if c.cache != nil {
+		cachedTags, err = c.cache.Get(context.Background(), urlStr)
+	} else if tags, ok := c.local.get(urlStr); ok {
+		cachedTags = tags
+	} else {
+		err = store.ErrNotFound
+	}
+
+	if err == nil {
+		lookups.WithLabelValues("hit").Inc()
 		slog.Debug("cache hit", "tags", cachedTags)
 		return cachedTags
 	}
+	if !errors.Is(err, store.ErrNotFound) {
+		slog.Error("og: can't read from cache", "url", urlStr, "err", err)
+	}
+	lookups.WithLabelValues("miss").Inc()
 	slog.Debug("cache miss", "url", urlStr)
 	return nil
 }
+
+// setCache stores tags in the cache, failures are logged and otherwise ignored
+func (c *OGTagCache) setCache(urlStr string, tags map[string]string, ttl time.Duration) {
+	if c.cache == nil {
+		c.local.set(urlStr, tags, ttl)
+		return
+	}
+	if err := c.cache.Set(context.Background(), urlStr, tags, ttl); err != nil {
+		slog.Error("og: can't write to cache", "url", urlStr, "err", err)
+	}
+}
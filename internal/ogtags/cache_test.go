@@ -25,7 +25,7 @@ func TestCheckCache(t *testing.T) {
 	}
 
 	// Manually add to cache
-	cache.cache.Set(urlStr, expectedTags, time.Minute)
+	cache.setCache(urlStr, expectedTags, time.Minute)
 
 	// Test cache hit
 	tags = cache.checkCache(urlStr)
@@ -0,0 +1,119 @@
+package ogtags
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultMaxPathLength is the longest path tags are fetched for by default.
+const DefaultMaxPathLength = 256
+
+// Filter decides which pages Open Graph tags are fetched for, so that the
+// fetcher can't be pointed at every page of the target.
+type Filter struct {
+	// Hosts, if set, are the hosts tags are fetched for. Hosts starting with
+	// "*." match every subdomain of the rest of the name, and hosts starting
+	// with "!" turn passthrough off instead, taking precedence.
+	Hosts []string
+
+	// Paths, if set, must match the path of a page to fetch its tags.
+	Paths *regexp.Regexp
+
+	// MaxPathLength is the longest path tags are fetched for. Zero means
+	// DefaultMaxPathLength.
+	MaxPathLength int
+
+	// QueryParams are the query parameters passed on to the target. All
+	// others, such as utm_source and fbclid, are stripped, so that links
+	// shared with tracking parameters use the same cached tags.
+	QueryParams []string
+}
+
+// allowsHost reports whether tags are fetched for pages on host.
+func (f Filter) allowsHost(host string) bool {
+	if len(f.Hosts) == 0 {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	allowed, restricted := false, false
+	for _, pattern := range f.Hosts {
+		pattern, deny := strings.CutPrefix(strings.ToLower(pattern), "!")
+		if !deny {
+			restricted = true
+		}
+		if !hostMatches(pattern, host) {
+			continue
+		}
+		if deny {
+			return false
+		}
+		allowed = true
+	}
+
+	return allowed || !restricted
+}
+
+func hostMatches(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+
+	return host == pattern
+}
+
+// normalize returns the path and query that tags are fetched and cached for,
+// or false if tags aren't fetched for u at all.
+func (f Filter) normalize(u *url.URL) (string, bool) {
+	if !f.allowsHost(u.Host) {
+		return "", false
+	}
+
+	p := u.Path
+	if p != "" {
+		// Dot segments and repeated slashes don't change the page, but the
+		// trailing slash might.
+		trailing := strings.HasSuffix(p, "/")
+		p = path.Clean("/" + p)
+		if trailing && p != "/" {
+			p += "/"
+		}
+	}
+
+	maxLength := f.MaxPathLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxPathLength
+	}
+	if len(p) > maxLength {
+		return "", false
+	}
+
+	if f.Paths != nil && !f.Paths.MatchString(p) {
+		return "", false
+	}
+
+	if len(f.QueryParams) == 0 || u.RawQuery == "" {
+		return p, true
+	}
+
+	query := u.Query()
+	for name := range query {
+		if !slices.Contains(f.QueryParams, name) {
+			query.Del(name)
+		}
+	}
+	if len(query) == 0 {
+		return p, true
+	}
+
+	// Encode sorts the parameters, so their order doesn't matter.
+	return p + "?" + query.Encode(), true
+}
@@ -0,0 +1,58 @@
+package ogtags
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		url    string
+		want   string
+		ok     bool
+	}{
+		{name: "path", url: "http://example.com/blog/post", want: "/blog/post", ok: true},
+		{name: "dot segments", url: "http://example.com/blog/../blog//./post/", want: "/blog/post/", ok: true},
+		{name: "query stripped", url: "http://example.com/post?utm_source=mastodon&id=1", want: "/post", ok: true},
+		{
+			name:   "query params kept",
+			filter: Filter{QueryParams: []string{"p", "lang"}},
+			url:    "http://example.com/?utm_source=mastodon&p=42&fbclid=abc&lang=de",
+			want:   "/?lang=de&p=42",
+			ok:     true,
+		},
+		{
+			name:   "only tracking params",
+			filter: Filter{QueryParams: []string{"p"}},
+			url:    "http://example.com/post?utm_campaign=launch",
+			want:   "/post",
+			ok:     true,
+		},
+		{name: "path too long", url: "http://example.com/" + strings.Repeat("a", DefaultMaxPathLength)},
+		{name: "path length set", filter: Filter{MaxPathLength: 8}, url: "http://example.com/a/b/c/d/e"},
+		{name: "path matches", filter: Filter{Paths: regexp.MustCompile(`^/blog/`)}, url: "http://example.com/blog/post", want: "/blog/post", ok: true},
+		{name: "path doesn't match", filter: Filter{Paths: regexp.MustCompile(`^/blog/`)}, url: "http://example.com/search"},
+		{name: "host allowed", filter: Filter{Hosts: []string{"example.com"}}, url: "http://Example.com:8080/", want: "/", ok: true},
+		{name: "host not allowed", filter: Filter{Hosts: []string{"example.com"}}, url: "http://example.org/"},
+		{name: "subdomain allowed", filter: Filter{Hosts: []string{"*.example.com"}}, url: "http://blog.example.com/", want: "/", ok: true},
+		{name: "host turned off", filter: Filter{Hosts: []string{"!git.example.com"}}, url: "http://git.example.com/"},
+		{name: "other host with only hosts turned off", filter: Filter{Hosts: []string{"!git.example.com"}}, url: "http://example.com/", want: "/", ok: true},
+		{name: "turned off takes precedence", filter: Filter{Hosts: []string{"*.example.com", "!git.example.com"}}, url: "http://git.example.com/"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := tt.filter.normalize(u)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("wanted %q, %v, got: %q, %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package ogtags
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_ogtags_cache_lookups",
+		Help: "The total number of pages looked up in the Open Graph tag cache, by result",
+	}, []string{"result"})
+
+	entriesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_ogtags_cache_entries",
+		Help: "The number of pages in the in-memory Open Graph tag cache",
+	})
+
+	evictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anubis_ogtags_cache_evictions",
+		Help: "The total number of pages removed from the Open Graph tag cache to make room for others",
+	})
+)
+
+// DefaultMaxEntries is how many pages the in-memory cache keeps tags for by
+// default.
+const DefaultMaxEntries = 10000
+
+// lruEntry is the cached tags of one page. It is exported to JSON when the
+// cache is saved.
+type lruEntry struct {
+	Key     string            `json:"key"`
+	Tags    map[string]string `json:"tags"`
+	Expires time.Time         `json:"expires"`
+}
+
+// lru keeps the tags of up to max pages in memory, dropping the least
+// recently used page once it is full.
+type lru struct {
+	lock  sync.Mutex
+	max   int
+	order *list.List // of *lruEntry, most recently used first
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+func newLRU(max int) *lru {
+	return &lru{
+		max:   max,
+		order: list.New(),
+		items: map[string]*list.Element{},
+		now:   time.Now,
+	}
+}
+
+func (c *lru) get(key string) (map[string]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if !c.now().Before(e.Expires) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return e.Tags, true
+}
+
+func (c *lru) set(key string, tags map[string]string, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.add(&lruEntry{Key: key, Tags: tags, Expires: c.now().Add(ttl)})
+}
+
+// add stores e as the most recently used entry. The lock must be held.
+func (c *lru) add(e *lruEntry) {
+	if el, ok := c.items[e.Key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[e.Key] = c.order.PushFront(e)
+	for c.order.Len() > c.max {
+		c.remove(c.order.Back())
+		evictions.Inc()
+	}
+	entriesGauge.Set(float64(c.order.Len()))
+}
+
+// remove drops el. The lock must be held.
+func (c *lru) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).Key)
+	entriesGauge.Set(float64(c.order.Len()))
+}
+
+// setMax changes how many pages are kept, dropping the least recently used
+// ones if there are more.
+func (c *lru) setMax(max int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.max = max
+	for c.order.Len() > c.max {
+		c.remove(c.order.Back())
+		evictions.Inc()
+	}
+}
+
+// cleanup drops the pages whose tags have expired.
+func (c *lru) cleanup() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if !now.Before(el.Value.(*lruEntry).Expires) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+func (c *lru) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+// save writes the pages that haven't expired to path, least recently used
+// first, replacing the file at once so that it is never half written.
+func (c *lru) save(path string) error {
+	c.lock.Lock()
+	now := c.now()
+	entries := make([]*lruEntry, 0, c.order.Len())
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*lruEntry); now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	c.lock.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("ogtags: can't encode cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ogtags-*")
+	if err != nil {
+		return fmt.Errorf("ogtags: can't save cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ogtags: can't save cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ogtags: can't save cache: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("ogtags: can't save cache: %w", err)
+	}
+
+	return nil
+}
+
+// load adds the pages saved in path that haven't expired.
+func (c *lru) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entries []*lruEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("ogtags: can't decode %s: %w", path, err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	for _, e := range entries {
+		if e.Key != "" && now.Before(e.Expires) {
+			c.add(e)
+		}
+	}
+
+	return nil
+}
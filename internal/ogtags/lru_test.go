@@ -0,0 +1,117 @@
+package ogtags
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLRUEviction(t *testing.T) {
+	c := newLRU(3)
+
+	for i := range 3 {
+		c.set(fmt.Sprintf("/%d", i), map[string]string{"og:title": fmt.Sprint(i)}, time.Minute)
+	}
+
+	// Using /0 makes /1 the least recently used page.
+	if _, ok := c.get("/0"); !ok {
+		t.Fatal("wanted /0 to be cached")
+	}
+	c.set("/3", map[string]string{"og:title": "3"}, time.Minute)
+
+	if c.len() != 3 {
+		t.Errorf("wanted 3 pages, got: %d", c.len())
+	}
+	if _, ok := c.get("/1"); ok {
+		t.Error("wanted the least recently used page to be dropped")
+	}
+	for _, key := range []string{"/0", "/2", "/3"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("wanted %s to stay cached", key)
+		}
+	}
+
+	c.setMax(1)
+	if _, ok := c.get("/3"); !ok || c.len() != 1 {
+		t.Errorf("wanted only the most recently used page left, got %d pages", c.len())
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	now := time.Now()
+	c := newLRU(10)
+	c.now = func() time.Time { return now }
+
+	c.set("/short", map[string]string{}, time.Second)
+	c.set("/long", map[string]string{}, time.Hour)
+
+	now = now.Add(time.Minute)
+	if _, ok := c.get("/short"); ok {
+		t.Error("wanted expired tags not to be returned")
+	}
+
+	c.set("/other", map[string]string{}, time.Second)
+	now = now.Add(time.Minute)
+	c.cleanup()
+	if c.len() != 1 {
+		t.Errorf("wanted cleanup to leave only the page that hasn't expired, got %d pages", c.len())
+	}
+}
+
+func TestLRUSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ogtags.json")
+
+	now := time.Now()
+	c := newLRU(10)
+	c.now = func() time.Time { return now }
+	c.set("/expired", map[string]string{"og:title": "old"}, time.Second)
+	c.set("/a", map[string]string{"og:title": "A"}, time.Hour)
+	c.set("/b", map[string]string{"og:title": "B"}, time.Hour)
+	now = now.Add(time.Minute)
+
+	if err := c.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A smaller cache keeps the most recently used pages.
+	loaded := newLRU(1)
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.len() != 1 {
+		t.Errorf("wanted 1 page loaded, got: %d", loaded.len())
+	}
+	if tags, ok := loaded.get("/b"); !ok || tags["og:title"] != "B" {
+		t.Errorf("wanted the tags of /b loaded, got: %v", tags)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newLRU(1).load(path); err == nil {
+		t.Error("wanted an error for a corrupt file")
+	}
+}
+
+func TestOGTagCacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ogtags.json")
+
+	cache := NewOGTagCache("http://example.com", true, time.Hour)
+	if err := cache.SetFile(path); err != nil {
+		t.Fatalf("wanted a missing file to be fine, got: %v", err)
+	}
+	cache.setCache("http://example.com/page", map[string]string{"og:title": "Page"}, time.Hour)
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewOGTagCache("http://example.com", true, time.Hour)
+	if err := restarted.SetFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if tags := restarted.checkCache("http://example.com/page"); tags["og:title"] != "Page" {
+		t.Errorf("wanted the tags to outlive the restart, got: %v", tags)
+	}
+}
@@ -0,0 +1,139 @@
+package ogtags
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OEmbedKey is the key of the link to the oEmbed representation of a page in
+// the tags returned by GetOGTags. Pages can't set it with a meta tag.
+const OEmbedKey = "oembed"
+
+// maxOEmbedLength is the largest oEmbed response that is passed on.
+const maxOEmbedLength = 64 << 10
+
+// ErrNoOEmbed is returned by GetOEmbed for pages without an oEmbed link to
+// their own host.
+var ErrNoOEmbed = errors.New("og: page has no oEmbed representation")
+
+// oEmbedPath returns the path and query on the target of the oEmbed link href
+// found on page, or false if it points to another host, such as that of a
+// video platform, which clients can reach without Anubis.
+func oEmbedPath(page *url.URL, href string) (string, bool) {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	if ref.Host != "" && !strings.EqualFold(ref.Host, page.Host) {
+		return "", false
+	}
+	if ref.Scheme != "" && ref.Scheme != "http" && ref.Scheme != "https" {
+		return "", false
+	}
+
+	resolved := (&url.URL{Path: page.Path}).ResolveReference(ref)
+	if resolved.RawQuery == "" {
+		return resolved.EscapedPath(), true
+	}
+
+	return resolved.EscapedPath() + "?" + resolved.RawQuery, true
+}
+
+// oEmbedLink returns the link to the endpoint set with SetOEmbed that answers
+// with the oEmbed representation of page, or false if href isn't passed on
+// that way.
+func (c *OGTagCache) oEmbedLink(page *url.URL, href string) (string, bool) {
+	if c.oEmbedEndpoint == "" {
+		return "", false
+	}
+	if _, ok := oEmbedPath(page, href); !ok {
+		return "", false
+	}
+
+	link := c.oEmbedEndpoint + "?" + url.Values{"url": {page.Path}}.Encode()
+
+	// Consumers don't always resolve relative links, so keep the link
+	// absolute if the page made it absolute.
+	if ref, err := url.Parse(href); err == nil && ref.Scheme != "" {
+		link = ref.Scheme + "://" + ref.Host + link
+	}
+
+	return link, true
+}
+
+// GetOEmbed returns the JSON oEmbed representation of page, fetched from the
+// link to it on the page, or ErrNoOEmbed if there is none on the page's own
+// host.
+func (c *OGTagCache) GetOEmbed(page *url.URL) ([]byte, error) {
+	if c.oEmbedEndpoint == "" {
+		return nil, ErrNoOEmbed
+	}
+
+	tags, err := c.getTags(page)
+	if err != nil {
+		return nil, err
+	}
+	oEmbed, ok := oEmbedPath(page, tags[OEmbedKey])
+	if tags[OEmbedKey] == "" || !ok {
+		return nil, ErrNoOEmbed
+	}
+
+	urlStr := c.target + oEmbed
+	key := "oembed:" + urlStr
+	if cached := c.checkCache(key); cached != nil {
+		if cached["json"] == "" {
+			return nil, ErrNoOEmbed
+		}
+		return []byte(cached["json"]), nil
+	}
+
+	data, err := c.fetchOEmbed(urlStr)
+	if err != nil {
+		slog.Debug("og: can't fetch oEmbed", "url", urlStr, "err", err)
+		// Don't ask the target again until the tags would be fetched again.
+		c.setCache(key, emptyMap, c.ogTimeToLive/2)
+		return nil, ErrNoOEmbed
+	}
+
+	c.setCache(key, map[string]string{"json": string(data)}, c.ogTimeToLive)
+	return data, nil
+}
+
+func (c *OGTagCache) fetchOEmbed(urlStr string) ([]byte, error) {
+	resp, err := c.client.Get(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/json+oembed" {
+		return nil, fmt.Errorf("unsupported Content-Type: %q", mediaType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOEmbedLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxOEmbedLength {
+		return nil, fmt.Errorf("response over %d bytes", maxOEmbedLength)
+	}
+
+	// Only pass on objects, so the endpoint can't serve anything else.
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return data, nil
+}
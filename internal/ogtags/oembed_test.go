@@ -0,0 +1,130 @@
+package ogtags
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// oEmbedTarget serves pages that link to their oEmbed representation in
+// different ways, and counts the requests for it.
+func oEmbedTarget(t *testing.T) (*httptest.Server, *int) {
+	t.Helper()
+
+	var oEmbedHits int
+	mux := http.NewServeMux()
+	page := func(link string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><head>
+				<meta property="og:title" content="Post">
+				<meta name="twitter:card" content="summary_large_image">
+				<meta name="oembed" content="spoofed">
+				<link rel="alternate" type="application/json+oembed" href="%s">
+			</head></html>`, link)
+		}
+	}
+	mux.HandleFunc("/relative", page("/oembed?url=%2Frelative"))
+	mux.HandleFunc("/absolute", page("https://example.com/oembed?url=%2Fabsolute"))
+	mux.HandleFunc("/video", page("https://video.example/oembed?v=1"))
+	mux.HandleFunc("/broken", page("/broken-oembed"))
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		oEmbedHits++
+		w.Header().Set("Content-Type", "application/json+oembed")
+		fmt.Fprintf(w, `{"version":"1.0","type":"rich","title":%q}`, r.URL.Query().Get("url"))
+	})
+	mux.HandleFunc("/broken-oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `["not", "an", "object"]`)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, &oEmbedHits
+}
+
+func TestOEmbed(t *testing.T) {
+	srv, hits := oEmbedTarget(t)
+
+	cache := NewOGTagCache(srv.URL, true, time.Hour)
+	cache.SetOEmbed("/oembed-endpoint")
+
+	for _, tt := range []struct {
+		path string
+		link string
+		err  error
+	}{
+		{path: "/relative", link: "/oembed-endpoint?url=%2Frelative"},
+		{path: "/absolute", link: "https://example.com/oembed-endpoint?url=%2Fabsolute"},
+		{path: "/video", link: "https://video.example/oembed?v=1", err: ErrNoOEmbed},
+		{path: "/broken", link: "/oembed-endpoint?url=%2Fbroken", err: ErrNoOEmbed},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			page := &url.URL{Host: "example.com", Path: tt.path}
+
+			tags, err := cache.GetOGTags(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tags[OEmbedKey] != tt.link {
+				t.Errorf("wanted oEmbed link %q, got: %q", tt.link, tags[OEmbedKey])
+			}
+			if tags["twitter:card"] != "summary_large_image" {
+				t.Errorf("wanted Twitter Card tags, got: %v", tags)
+			}
+
+			data, err := cache.GetOEmbed(page)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("wanted error %v, got: %v", tt.err, err)
+			}
+			if tt.err == nil && string(data) != fmt.Sprintf(`{"version":"1.0","type":"rich","title":%q}`, tt.path) {
+				t.Errorf("wanted the oEmbed of %s, got: %s", tt.path, data)
+			}
+		})
+	}
+
+	cache.GetOEmbed(&url.URL{Host: "example.com", Path: "/relative"})
+	if *hits != 2 {
+		t.Errorf("wanted oEmbed responses to be cached, got %d requests", *hits)
+	}
+}
+
+func TestOEmbedOff(t *testing.T) {
+	srv, _ := oEmbedTarget(t)
+
+	cache := NewOGTagCache(srv.URL, true, time.Hour)
+	page := &url.URL{Host: "example.com", Path: "/relative"}
+
+	tags, err := cache.GetOGTags(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tags[OEmbedKey]; ok {
+		t.Errorf("wanted no oEmbed link, got: %v", tags)
+	}
+	if _, err := cache.GetOEmbed(page); !errors.Is(err, ErrNoOEmbed) {
+		t.Errorf("wanted ErrNoOEmbed, got: %v", err)
+	}
+}
+
+func TestSetApprovedTags(t *testing.T) {
+	srv, _ := oEmbedTarget(t)
+
+	cache := NewOGTagCache(srv.URL, true, time.Hour)
+	cache.SetApprovedTags([]string{"og:title", "twitter:*", "oembed"})
+
+	tags, err := cache.GetOGTags(&url.URL{Host: "example.com", Path: "/relative"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"og:title": "Post", "twitter:card": "summary_large_image"}
+	if len(tags) != len(want) || tags["og:title"] != want["og:title"] || tags["twitter:card"] != want["twitter:card"] {
+		t.Errorf("wanted %v, got: %v", want, tags)
+	}
+}
@@ -1,15 +1,25 @@
 package ogtags
 
 import (
+	"errors"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
-	"github.com/vale981/anubis/decaymap"
+	"github.com/vale981/anubis/lib/store"
 )
 
 type OGTagCache struct {
-	cache            *decaymap.Impl[string, map[string]string]
+	// local keeps tags in memory, unless a shared store is set with
+	// SetStore, in which case cache is used instead.
+	local            *lru
+	cache            *store.JSON[map[string]string]
+	file             string
+	filter           Filter
+	oEmbedEndpoint   string
 	target           string
 	ogPassthrough    bool
 	ogTimeToLive     time.Duration
@@ -31,7 +41,7 @@ func NewOGTagCache(target string, ogPassthrough bool, ogTimeToLive time.Duration
 	const maxContentLength = 16 << 20 // 16 MiB in bytes
 
 	return &OGTagCache{
-		cache:            decaymap.New[string, map[string]string](),
+		local:            newLRU(DefaultMaxEntries),
 		target:           target,
 		ogPassthrough:    ogPassthrough,
 		ogTimeToLive:     ogTimeToLive,
@@ -42,10 +52,87 @@ func NewOGTagCache(target string, ogPassthrough bool, ogTimeToLive time.Duration
 	}
 }
 
-func (c *OGTagCache) getTarget(u *url.URL) string {
-	return c.target + u.Path
+// SetTransport changes how Open Graph tags are fetched from the target, such
+// as to go through an outbound proxy.
+func (c *OGTagCache) SetTransport(rt http.RoundTripper) {
+	c.client.Transport = rt
+}
+
+// SetStore changes where Open Graph tags are cached, such as to share them
+// between replicas. Tags are kept in the bounded in-memory cache if st is a
+// *store.Memory, which would otherwise grow with every page asked for.
+func (c *OGTagCache) SetStore(st store.Interface) {
+	if _, ok := st.(*store.Memory); ok {
+		c.cache = nil
+		return
+	}
+	c.cache = &store.JSON[map[string]string]{Underlying: st, Prefix: "ogtags:"}
+}
+
+// SetApprovedTags changes which meta tags are passed through. Entries ending
+// in "*", such as "og:*", approve every tag starting with the rest.
+func (c *OGTagCache) SetApprovedTags(tags []string) {
+	c.approvedTags, c.approvedPrefixes = nil, nil
+	for _, tag := range tags {
+		if prefix, ok := strings.CutSuffix(tag, "*"); ok {
+			c.approvedPrefixes = append(c.approvedPrefixes, prefix)
+		} else {
+			c.approvedTags = append(c.approvedTags, tag)
+		}
+	}
+}
+
+// SetOEmbed makes pages' oEmbed links on their own host point to endpoint,
+// which is expected to answer with GetOEmbed. An empty endpoint turns oEmbed
+// off.
+func (c *OGTagCache) SetOEmbed(endpoint string) {
+	c.oEmbedEndpoint = endpoint
+}
+
+// SetFilter changes which pages tags are fetched for.
+func (c *OGTagCache) SetFilter(f Filter) {
+	c.filter = f
+}
+
+// SetMaxEntries changes how many pages the in-memory cache keeps tags for.
+func (c *OGTagCache) SetMaxEntries(n int) {
+	c.local.setMax(n)
+}
+
+// SetFile makes the in-memory cache outlive restarts by loading it from path
+// and saving it there in Cleanup and Save. A missing file is not an error.
+func (c *OGTagCache) SetFile(path string) error {
+	c.file = path
+	if err := c.local.load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Save writes the in-memory cache to the file set with SetFile, if any.
+func (c *OGTagCache) Save() error {
+	if c.file == "" {
+		return nil
+	}
+
+	return c.local.save(c.file)
+}
+
+// getTarget returns the URL on the target to fetch the tags of u from, or
+// false if the filter leaves u out.
+func (c *OGTagCache) getTarget(u *url.URL) (string, bool) {
+	p, ok := c.filter.normalize(u)
+	if !ok {
+		return "", false
+	}
+
+	return c.target + p, true
 }
 
 func (c *OGTagCache) Cleanup() {
-	c.cache.Cleanup()
+	c.local.cleanup()
+	if err := c.Save(); err != nil {
+		slog.Error("og: can't save cache", "file", c.file, "err", err)
+	}
 }
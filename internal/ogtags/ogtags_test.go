@@ -90,7 +90,7 @@ func TestGetTarget(t *testing.T) {
 				RawQuery: tt.query,
 			}
 
-			result := cache.getTarget(u)
+			result, _ := cache.getTarget(u)
 
 			if result != tt.expected {
 				t.Errorf("expected %s, got %s", tt.expected, result)
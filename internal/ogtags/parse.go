@@ -1,6 +1,7 @@
 package ogtags
 
 import (
+	"slices"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -20,6 +21,9 @@ func (c *OGTagCache) extractOGTags(doc *html.Node) map[string]string {
 				ogTags[property] = content
 			}
 		}
+		if href := oEmbedHref(n); href != "" && c.oEmbedEndpoint != "" && ogTags[OEmbedKey] == "" {
+			ogTags[OEmbedKey] = href
+		}
 
 		for child := n.FirstChild; child != nil; child = child.NextSibling {
 			traverseNodes(child)
@@ -30,6 +34,33 @@ func (c *OGTagCache) extractOGTags(doc *html.Node) map[string]string {
 	return ogTags
 }
 
+// oEmbedHref returns the link of n if it is the link to the JSON oEmbed
+// representation of the page.
+func oEmbedHref(n *html.Node) string {
+	if n.Type != html.ElementNode || n.Data != "link" {
+		return ""
+	}
+
+	var alternate, oEmbed bool
+	var href string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "rel":
+			alternate = slices.Contains(strings.Fields(strings.ToLower(attr.Val)), "alternate")
+		case "type":
+			oEmbed = strings.EqualFold(attr.Val, "application/json+oembed")
+		case "href":
+			href = attr.Val
+		}
+	}
+
+	if !alternate || !oEmbed {
+		return ""
+	}
+
+	return href
+}
+
 // isOGMetaTag checks if a node is *any* meta tag
 func isOGMetaTag(n *html.Node) bool {
 	if n == nil {
@@ -72,7 +103,7 @@ func (c *OGTagCache) extractMetaTagInfo(n *html.Node) (property, content string)
 	}
 
 	// Only return the property if it's approved
-	if isApproved {
+	if isApproved && rawProperty != OEmbedKey {
 		property = rawProperty
 	}
 
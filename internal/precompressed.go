@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// precompressedEncodings are the content encodings files can be stored in
+// next to the uncompressed file, in the order they are preferred.
+var precompressedEncodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// PrecompressedFileServer serves the files in fsys like http.FileServerFS,
+// but if a file has compressed copies next to it, such as main.mjs.br, it
+// serves the best one the client accepts according to Accept-Encoding. Every
+// file is served with a strong ETag so that clients can revalidate it.
+func PrecompressedFileServer(fsys fs.FS) http.Handler {
+	return &precompressedFileServer{
+		fsys: fsys,
+		next: http.FileServerFS(fsys),
+	}
+}
+
+type precompressedFileServer struct {
+	fsys fs.FS
+	next http.Handler
+
+	// etags caches the ETag of every file by name. Entries are checked
+	// against the size and modification time of the file, so that files on
+	// disk can change.
+	etags sync.Map
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func (p *precompressedFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || !fs.ValidPath(name) {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	var variants []string
+	for _, enc := range precompressedEncodings {
+		if _, err := fs.Stat(p.fsys, name+enc.ext); err == nil {
+			variants = append(variants, enc.name)
+		}
+	}
+
+	if len(variants) != 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	for _, enc := range precompressedEncodings {
+		if !slices.Contains(variants, enc.name) || !acceptsEncoding(r.Header.Get("Accept-Encoding"), enc.name) {
+			continue
+		}
+
+		if p.serveFile(w, r, name+enc.ext, name, enc.name) {
+			return
+		}
+	}
+
+	if !p.serveFile(w, r, name, name, "") {
+		p.next.ServeHTTP(w, r)
+	}
+}
+
+// serveFile serves the file fname as the content of name, encoded with
+// encoding. It returns false without writing anything if fname is not a
+// regular file.
+func (p *precompressedFileServer) serveFile(w http.ResponseWriter, r *http.Request, fname, name, encoding string) bool {
+	f, err := p.fsys.Open(fname)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil || !st.Mode().IsRegular() {
+		return false
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(data)
+	}
+
+	etag, err := p.etag(fname, st, content)
+	if err != nil {
+		return false
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("ETag", etag)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+
+	http.ServeContent(w, r, name, st.ModTime(), content)
+	return true
+}
+
+// etag returns the strong ETag of the file fname, which is a hash of its
+// content.
+func (p *precompressedFileServer) etag(fname string, st fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if e, ok := p.etags.Load(fname); ok {
+		e := e.(etagEntry)
+		if e.size == st.Size() && e.modTime.Equal(st.ModTime()) {
+			return e.etag, nil
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := strconv.Quote(hex.EncodeToString(h.Sum(nil)[:16]))
+	p.etags.Store(fname, etagEntry{size: st.Size(), modTime: st.ModTime(), etag: etag})
+
+	return etag, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header acceptEncoding
+// allows the content encoding name.
+func acceptsEncoding(acceptEncoding, name string) bool {
+	wildcard := false
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		switch coding {
+		case name:
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+
+	return wildcard
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPrecompressedFileServer(t *testing.T) {
+	h := PrecompressedFileServer(fstest.MapFS{
+		"js/main.mjs":     {Data: []byte("plain")},
+		"js/main.mjs.br":  {Data: []byte("brotli")},
+		"js/main.mjs.zst": {Data: []byte("zstd")},
+		"js/main.mjs.gz":  {Data: []byte("gzip")},
+		"js/other.mjs":    {Data: []byte("other")},
+		"js/other.mjs.gz": {Data: []byte("other gzip")},
+		"robots.txt":      {Data: []byte("robots")},
+	})
+
+	for _, tt := range []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		body           string
+		encoding       string
+		vary           bool
+	}{
+		{
+			name: "no_accept_encoding",
+			path: "/js/main.mjs",
+			body: "plain",
+			vary: true,
+		},
+		{
+			name:           "prefers_brotli",
+			path:           "/js/main.mjs",
+			acceptEncoding: "gzip, deflate, br, zstd",
+			body:           "brotli",
+			encoding:       "br",
+			vary:           true,
+		},
+		{
+			name:           "zstd",
+			path:           "/js/main.mjs",
+			acceptEncoding: "gzip, zstd",
+			body:           "zstd",
+			encoding:       "zstd",
+			vary:           true,
+		},
+		{
+			name:           "refused_encoding",
+			path:           "/js/main.mjs",
+			acceptEncoding: "br;q=0, gzip",
+			body:           "gzip",
+			encoding:       "gzip",
+			vary:           true,
+		},
+		{
+			name:           "wildcard",
+			path:           "/js/main.mjs",
+			acceptEncoding: "*",
+			body:           "brotli",
+			encoding:       "br",
+			vary:           true,
+		},
+		{
+			name:           "missing_variant",
+			path:           "/js/other.mjs",
+			acceptEncoding: "br",
+			body:           "other",
+			vary:           true,
+		},
+		{
+			name:           "no_variants",
+			path:           "/robots.txt",
+			acceptEncoding: "br, gzip",
+			body:           "robots",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("wanted body %q, got: %q", tt.body, got)
+			}
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("wanted Content-Encoding %q, got: %q", tt.encoding, got)
+			}
+
+			if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tt.vary {
+				t.Errorf("wanted Vary: Accept-Encoding to be %v, got: %v", tt.vary, got)
+			}
+
+			if w.Header().Get("ETag") == "" {
+				t.Error("response has no ETag")
+			}
+		})
+	}
+}
+
+func TestPrecompressedFileServerETag(t *testing.T) {
+	h := PrecompressedFileServer(fstest.MapFS{
+		"main.mjs":    {Data: []byte("plain")},
+		"main.mjs.br": {Data: []byte("brotli")},
+	})
+
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/main.mjs", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	plain := get("identity", "").Header().Get("ETag")
+	brotli := get("br", "").Header().Get("ETag")
+	if plain == brotli {
+		t.Errorf("encodings share the ETag %s", plain)
+	}
+
+	if w := get("br", brotli); w.Code != http.StatusNotModified {
+		t.Errorf("wanted status %d for a matching ETag, got: %d", http.StatusNotModified, w.Code)
+	}
+
+	if w := get("br", plain); w.Code != http.StatusOK {
+		t.Errorf("wanted status %d for the ETag of another encoding, got: %d", http.StatusOK, w.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// proxyHeaderTimeout is how long a new connection has to send its PROXY
+// protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// ProxyProtocolListener wraps a listener so that every connection must start
+// with a HAProxy PROXY protocol header, version 1 or 2. The remote address of
+// accepted connections is the client address from the header. Connections
+// without a header are closed.
+func ProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyproto.Listener{
+		Listener: l,
+		ConnPolicy: func(proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			return proxyproto.REQUIRE, nil
+		},
+		ReadHeaderTimeout: proxyHeaderTimeout,
+	}
+}
+
+// ProxyProtocolXRealIP sets the X-Real-Ip header to the client address sent
+// in the PROXY protocol header, which replaces anything the client put in
+// the HTTP headers. Connections that carry no client address, such as health
+// checks from the proxy itself, have the header removed so that
+// DefaultXRealIP can fill it in.
+func ProxyProtocolXRealIP(enabled bool, next http.Handler) http.Handler {
+	if !enabled {
+		slog.Debug("skipping middleware, PROXY protocol is disabled")
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || net.ParseIP(host) == nil {
+			r.Header.Del("X-Real-Ip")
+		} else {
+			r.Header.Set("X-Real-Ip", host)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WriteProxyHeader sends a PROXY protocol version 2 LOCAL header on conn.
+// LOCAL connections carry no client address, so they are how Anubis talks
+// to its own listeners, for example in health checks.
+func WriteProxyHeader(conn net.Conn) error {
+	header := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.LOCAL,
+		TransportProtocol: proxyproto.UNSPEC,
+	}
+
+	_, err := header.WriteTo(conn)
+	return err
+}
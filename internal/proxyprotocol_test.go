@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyProtocolListener(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header func(conn net.Conn) error
+		want   string
+	}{
+		{
+			name: "v1",
+			header: func(conn net.Conn) error {
+				_, err := fmt.Fprint(conn, "PROXY TCP4 192.0.2.7 198.51.100.1 4321 80\r\n")
+				return err
+			},
+			want: "192.0.2.7",
+		},
+		{
+			name: "v1_ipv6",
+			header: func(conn net.Conn) error {
+				_, err := fmt.Fprint(conn, "PROXY TCP6 2001:db8::7 2001:db8::1 4321 80\r\n")
+				return err
+			},
+			want: "2001:db8::7",
+		},
+		{
+			name:   "v2_local",
+			header: WriteProxyHeader,
+			want:   "127.0.0.1",
+		},
+		{
+			name:   "no_header",
+			header: func(net.Conn) error { return nil },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv := httptest.NewUnstartedServer(ProxyProtocolXRealIP(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.Header.Get("X-Real-Ip"))
+			})))
+			srv.Listener = ProxyProtocolListener(l)
+			srv.Start()
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if err := tt.header(conn); err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Real-Ip", "203.0.113.1")
+			if err := req.Write(conn); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if tt.want == "" {
+				if err == nil {
+					resp.Body.Close()
+					if resp.StatusCode == http.StatusOK {
+						t.Fatal("wanted the request to be refused")
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			var got string
+			fmt.Fscan(resp.Body, &got)
+			if got != tt.want {
+				t.Errorf("wanted X-Real-Ip %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolXRealIP(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{
+			name:       "client_address",
+			remoteAddr: "192.0.2.7:4321",
+			want:       "192.0.2.7",
+		},
+		{
+			name:       "no_client_address",
+			remoteAddr: "@",
+			want:       "",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := ProxyProtocolXRealIP(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("X-Real-Ip")
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Real-Ip", "203.0.113.1")
+
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("wanted X-Real-Ip %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import "sync"
+
+// Concurrency counts how many things, such as connections or requests, each
+// key has open at the same time.
+type Concurrency struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+// NewConcurrency creates an empty Concurrency.
+func NewConcurrency() *Concurrency {
+	return &Concurrency{counts: map[string]int{}}
+}
+
+// Acquire counts one more for key and returns the key's new count.
+func (c *Concurrency) Acquire(key string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.counts[key]++
+	return c.counts[key]
+}
+
+// Release counts one less for key. Keys are forgotten once their count is
+// back to zero.
+func (c *Concurrency) Release(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.counts[key]--
+	if c.counts[key] <= 0 {
+		delete(c.counts, key)
+	}
+}
+
+// Count returns the count for key.
+func (c *Concurrency) Count(key string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.counts[key]
+}
+
+// Len returns the number of keys with anything open.
+func (c *Concurrency) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.counts)
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := range 3 {
+		if ok, _ := l.Allow("1.1.1.1"); !ok {
+			t.Fatalf("request %d should fit in the burst", i)
+		}
+	}
+
+	ok, retryAfter := l.Allow("1.1.1.1")
+	if ok {
+		t.Fatal("request after the burst should be limited")
+	}
+
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("wanted to wait 500ms, got: %s", retryAfter)
+	}
+
+	if ok, _ := l.Allow("2.2.2.2"); !ok {
+		t.Error("other clients should have their own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("1.1.1.1"); !ok {
+		t.Error("a token should have been added after 500ms")
+	}
+
+	if ok, _ := l.Allow("1.1.1.1"); ok {
+		t.Error("only one token should have been added after 500ms")
+	}
+
+	now = now.Add(time.Hour)
+	l.Cleanup()
+
+	if l.Len() != 0 {
+		t.Errorf("wanted full buckets to be cleaned up, %d are left", l.Len())
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	c := NewConcurrency()
+
+	for i := range 3 {
+		if got := c.Acquire("1.1.1.1"); got != i+1 {
+			t.Errorf("wanted count %d, got: %d", i+1, got)
+		}
+	}
+	if got := c.Acquire("2.2.2.2"); got != 1 {
+		t.Errorf("other clients should be counted on their own, got: %d", got)
+	}
+
+	c.Release("1.1.1.1")
+	if got := c.Count("1.1.1.1"); got != 2 {
+		t.Errorf("wanted count 2 after a release, got: %d", got)
+	}
+
+	c.Release("1.1.1.1")
+	c.Release("1.1.1.1")
+	c.Release("2.2.2.2")
+	if c.Len() != 0 {
+		t.Errorf("keys should be forgotten once nothing is open, %d are left", c.Len())
+	}
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var shutdownRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_shutdown_requests",
+	Help: "Requests in flight when a server shut down, by whether they finished (drained) or were cut off (aborted)",
+}, []string{"server", "result"})
+
+// InFlight counts the requests a server is handling, so that shutting it
+// down can report how many of them finished.
+type InFlight struct {
+	count atomic.Int64
+}
+
+// Track counts the requests handled by next.
+func (f *InFlight) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.count.Add(1)
+		defer f.count.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count returns the number of requests being handled right now.
+func (f *InFlight) Count() int64 {
+	return f.count.Load()
+}
+
+// Shutdown stops srv from accepting new connections and waits up to timeout
+// for the requests counted by inFlight to finish. Requests still running
+// after that are aborted by closing their connections. It returns the number
+// of drained and aborted requests.
+func Shutdown(name string, srv *http.Server, inFlight *InFlight, timeout time.Duration) (drained, aborted int64) {
+	pending := inFlight.Count()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		aborted = inFlight.Count()
+		if err := srv.Close(); err != nil {
+			slog.Error("can't close server", "server", name, "err", err)
+		}
+	}
+	drained = max(pending-aborted, 0)
+
+	shutdownRequests.WithLabelValues(name, "drained").Add(float64(drained))
+	shutdownRequests.WithLabelValues(name, "aborted").Add(float64(aborted))
+	slog.Info("server shut down", "server", name, "drained", drained, "aborted", aborted)
+
+	return drained, aborted
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		delay       time.Duration
+		timeout     time.Duration
+		wantDrained int64
+		wantAborted int64
+	}{
+		{
+			name:        "drained",
+			delay:       50 * time.Millisecond,
+			timeout:     5 * time.Second,
+			wantDrained: 1,
+		},
+		{
+			name:        "aborted",
+			delay:       5 * time.Second,
+			timeout:     50 * time.Millisecond,
+			wantAborted: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlight := new(InFlight)
+			started := make(chan struct{})
+
+			srv := httptest.NewServer(inFlight.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+				}
+			})))
+			defer srv.Close()
+
+			go http.Get(srv.URL)
+			<-started
+
+			if got := inFlight.Count(); got != 1 {
+				t.Fatalf("wanted 1 request in flight, got: %d", got)
+			}
+
+			drained, aborted := Shutdown(tt.name, srv.Config, inFlight, tt.timeout)
+			if drained != tt.wantDrained || aborted != tt.wantAborted {
+				t.Errorf("wanted %d drained and %d aborted, got: %d and %d", tt.wantDrained, tt.wantAborted, drained, aborted)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets on.
+const listenFDsStart = 3
+
+var ErrSystemdSockets = errors.New("internal: can't use sockets passed by systemd")
+
+// SystemdListeners returns the listening sockets systemd passed to this
+// process with socket activation, keyed by the FileDescriptorName of the
+// socket unit. It returns nil if the process was not socket activated. The
+// socket activation environment variables are removed so that they don't
+// leak into anything Anubis starts.
+func SystemdListeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	return systemdListeners(os.Getenv, os.Getpid(), listenFDsStart)
+}
+
+func systemdListeners(getenv func(string) string, pid int, start int) (map[string]net.Listener, error) {
+	if getenv("LISTEN_PID") == "" || getenv("LISTEN_FDS") == "" {
+		return nil, nil
+	}
+
+	// The sockets are meant for another process, which started this one.
+	if listenPID, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || listenPID != pid {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("%w: LISTEN_FDS must be a number, got: %q", ErrSystemdSockets, getenv("LISTEN_FDS"))
+	}
+
+	var names []string
+	if fdNames := getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	result := make(map[string]net.Listener, count)
+	for i := range count {
+		fd := start + i
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		if _, ok := result[name]; ok {
+			closeListeners(result)
+			return nil, fmt.Errorf("%w: more than one socket is named %q", ErrSystemdSockets, name)
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(result)
+			return nil, fmt.Errorf("%w: socket %q (fd %d) is not a listening socket: %w", ErrSystemdSockets, name, fd, err)
+		}
+
+		result[name] = l
+	}
+
+	return result, nil
+}
+
+func closeListeners(listeners map[string]net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
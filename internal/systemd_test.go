@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSystemdListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	pid := os.Getpid()
+
+	for _, tt := range []struct {
+		name      string
+		env       map[string]string
+		wantNames []string
+		wantErr   error
+	}{
+		{
+			name: "not_activated",
+		},
+		{
+			name: "other_process",
+			env: map[string]string{
+				"LISTEN_PID": strconv.Itoa(pid + 1),
+				"LISTEN_FDS": "1",
+			},
+		},
+		{
+			name: "unnamed",
+			env: map[string]string{
+				"LISTEN_PID": strconv.Itoa(pid),
+				"LISTEN_FDS": "1",
+			},
+			wantNames: []string{"unknown"},
+		},
+		{
+			name: "named",
+			env: map[string]string{
+				"LISTEN_PID":     strconv.Itoa(pid),
+				"LISTEN_FDS":     "1",
+				"LISTEN_FDNAMES": "metrics",
+			},
+			wantNames: []string{"metrics"},
+		},
+		{
+			name: "bad_count",
+			env: map[string]string{
+				"LISTEN_PID": strconv.Itoa(pid),
+				"LISTEN_FDS": "lots",
+			},
+			wantErr: ErrSystemdSockets,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// systemdListeners closes the file descriptors it is given, so
+			// hand it a copy.
+			f, err := l.(*net.TCPListener).File()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := systemdListeners(func(key string) string { return tt.env[key] }, pid, int(f.Fd()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("wanted error %v, got: %v", tt.wantErr, err)
+			}
+			defer closeListeners(got)
+
+			if len(got) != len(tt.wantNames) {
+				t.Fatalf("wanted %d listeners, got: %d", len(tt.wantNames), len(got))
+			}
+
+			for _, name := range tt.wantNames {
+				sl, ok := got[name]
+				if !ok {
+					t.Fatalf("wanted a listener named %q", name)
+				}
+				if sl.Addr().String() != l.Addr().String() {
+					t.Errorf("wanted listener on %s, got: %s", l.Addr(), sl.Addr())
+				}
+			}
+		})
+	}
+}
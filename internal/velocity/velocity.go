@@ -0,0 +1,116 @@
+// Package velocity tracks how fast clients send requests using counters that
+// decay exponentially over time.
+package velocity
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Tracker counts events per key. Every HalfLife, half of the events counted
+// so far are forgotten, so a key's count approximates how many events it had
+// in the last HalfLife or so.
+type Tracker struct {
+	HalfLife time.Duration
+
+	lock     sync.Mutex
+	counters map[string]*counter
+	now      func() time.Time
+}
+
+type counter struct {
+	count float64
+	last  time.Time
+}
+
+// New creates a Tracker whose counts halve every halfLife.
+func New(halfLife time.Duration) *Tracker {
+	return &Tracker{
+		HalfLife: halfLife,
+		counters: map[string]*counter{},
+		now:      time.Now,
+	}
+}
+
+// decayed returns the count of c at now.
+func (t *Tracker) decayed(c *counter, now time.Time) float64 {
+	return c.count * math.Exp2(-now.Sub(c.last).Seconds()/t.HalfLife.Seconds())
+}
+
+// Add counts an event for key and returns the key's new count.
+func (t *Tracker) Add(key string) float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+
+	c, ok := t.counters[key]
+	if !ok {
+		c = &counter{}
+		t.counters[key] = c
+	}
+
+	c.count = t.decayed(c, now) + 1
+	c.last = now
+
+	return c.count
+}
+
+// Count returns the count for key without adding to it.
+func (t *Tracker) Count(key string) float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	c, ok := t.counters[key]
+	if !ok {
+		return 0
+	}
+
+	return t.decayed(c, t.now())
+}
+
+// Cleanup forgets the keys whose count has decayed to almost nothing.
+func (t *Tracker) Cleanup() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	for key, c := range t.counters {
+		if t.decayed(c, now) < 0.01 {
+			delete(t.counters, key)
+		}
+	}
+}
+
+// Len returns the number of keys being tracked.
+func (t *Tracker) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return len(t.counters)
+}
+
+// Reset forgets all keys.
+func (t *Tracker) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	clear(t.counters)
+}
+
+// Above returns the count of every key whose count is at least threshold.
+func (t *Tracker) Above(threshold float64) map[string]float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	result := map[string]float64{}
+	for key, c := range t.counters {
+		if count := t.decayed(c, now); count >= threshold {
+			result[key] = count
+		}
+	}
+
+	return result
+}
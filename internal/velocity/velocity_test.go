@@ -0,0 +1,61 @@
+package velocity
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Now()
+	tr := New(time.Minute)
+	tr.now = func() time.Time { return now }
+
+	for range 4 {
+		tr.Add("1.1.1.1")
+	}
+
+	if got := tr.Count("1.1.1.1"); got != 4 {
+		t.Errorf("wanted count 4, got: %v", got)
+	}
+
+	if got := tr.Count("2.2.2.2"); got != 0 {
+		t.Errorf("other clients should have their own count, got: %v", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got := tr.Count("1.1.1.1"); math.Abs(got-2) > 1e-9 {
+		t.Errorf("wanted the count to halve after a minute, got: %v", got)
+	}
+
+	if got := tr.Add("1.1.1.1"); math.Abs(got-3) > 1e-9 {
+		t.Errorf("wanted count 3 after adding, got: %v", got)
+	}
+
+	now = now.Add(time.Hour)
+	tr.Cleanup()
+
+	if tr.Len() != 0 {
+		t.Errorf("wanted decayed counters to be cleaned up, %d are left", tr.Len())
+	}
+}
+
+func TestAbove(t *testing.T) {
+	now := time.Now()
+	tr := New(time.Minute)
+	tr.now = func() time.Time { return now }
+
+	for range 4 {
+		tr.Add("1.1.1.1")
+	}
+	tr.Add("2.2.2.2")
+
+	if got := tr.Above(3); len(got) != 1 || got["1.1.1.1"] != 4 {
+		t.Errorf("wanted only 1.1.1.1 with count 4, got: %v", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got := tr.Above(3); len(got) != 0 {
+		t.Errorf("wanted no keys once counts decayed, got: %v", got)
+	}
+}
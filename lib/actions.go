@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"net/http"
+
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// redirect sends a request matched by a REDIRECT rule elsewhere, such as to
+// a static mirror of the site.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, rule *policy.Bot) {
+	lg := s.requestLogger(r)
+
+	if rule == nil || rule.Redirect == nil {
+		lg.Error("redirect rule has no redirect settings")
+		s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("internal_error"), http.StatusInternalServerError)
+		return
+	}
+
+	location := rule.Redirect.Location(r.URL)
+	lg.Info("redirecting request", "location", location, "status", rule.Redirect.StatusCode())
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, location, rule.Redirect.StatusCode())
+}
+
+// respondStatus answers a request matched by a STATUS rule with the fixed
+// response of the rule.
+func (s *Server) respondStatus(w http.ResponseWriter, r *http.Request, rule *policy.Bot) {
+	lg := s.requestLogger(r)
+
+	if rule == nil || rule.Response == nil {
+		lg.Error("status rule has no response settings")
+		s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("internal_error"), http.StatusInternalServerError)
+		return
+	}
+
+	lg.Info("sending fixed response", "status", rule.Response.Status)
+	s.writeRuleResponse(w, r, rule, ruleResponseData{
+		Rule:    rule.Name,
+		ID:      rule.Hash(),
+		Message: http.StatusText(rule.Response.Status),
+	}, "", rule.Response.Status)
+}
+
+// respondDenied denies a request matched by a DENY rule, the way the
+// response settings of the rule say or with the deny page.
+func (s *Server) respondDenied(w http.ResponseWriter, r *http.Request, rule *policy.Bot, hash string) {
+	message := localization.ForRequest(r).T("access_denied", hash)
+
+	resp := rule.Response
+	if resp == nil {
+		s.respondWithError(w, r, ReasonRuleDenied, message, http.StatusOK)
+		return
+	}
+
+	status := resp.StatusOr(http.StatusOK)
+	if isGRPCRequest(r) || (rule.ResponseBody == nil && cmp.Or(resp.Format, config.ResponseHTML) == config.ResponseHTML) {
+		s.respondWithError(w, r, ReasonRuleDenied, message, status)
+		return
+	}
+
+	w.Header().Set(ReasonHeader, string(ReasonRuleDenied))
+	s.writeRuleResponse(w, r, rule, ruleResponseData{
+		Rule:    rule.Name,
+		ID:      hash,
+		Message: message,
+	}, ReasonRuleDenied, status)
+}
+
+// ruleResponseData is what the body templates of rule responses can use.
+type ruleResponseData struct {
+	Rule    string
+	ID      string
+	Message string
+}
+
+// writeRuleResponse writes the response that rule sets, rendering its body
+// template with data or, without one, data in the format of the response.
+func (s *Server) writeRuleResponse(w http.ResponseWriter, r *http.Request, rule *policy.Bot, data ruleResponseData, code ReasonCode, status int) {
+	var body []byte
+	switch {
+	case rule.ResponseBody != nil:
+		var buf bytes.Buffer
+		if err := rule.ResponseBody.Execute(&buf, data); err != nil {
+			s.requestLogger(r).Error("can't render response body", "rule", rule.Name, "err", err)
+			s.respondWithError(w, r, ReasonMisconfiguration, localization.ForRequest(r).T("misconfigured", "writeRuleResponse"), http.StatusInternalServerError)
+			return
+		}
+		body = buf.Bytes()
+	case rule.Response.Format == config.ResponseJSON:
+		body, _ = json.Marshal(struct {
+			Error string     `json:"error"`
+			Code  ReasonCode `json:"code,omitempty"`
+			ID    string     `json:"id"`
+		}{
+			Error: data.Message,
+			Code:  code,
+			ID:    data.ID,
+		})
+		body = append(body, '\n')
+	case rule.Response.Format == config.ResponseText:
+		body = []byte(data.Message + "\n")
+	}
+
+	w.Header().Set("Content-Type", rule.Response.ContentTypeOrDefault())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
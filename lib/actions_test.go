@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestRedirectAndStatus(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: mirror
+    user_agent_regex: Scraper
+    action: REDIRECT
+    redirect:
+      url: https://static.example.com/
+      status: 301
+      keep_path: true
+  - name: gone
+    path_regex: ^/old/
+    action: STATUS
+    response:
+      status: 410
+      content_type: application/json
+      body: '{"error": "gone"}'
+`), "redirect.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/blog/post?page=2", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Scraper/1.0")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusMovedPermanently {
+			t.Errorf("wanted status 301, got: %d", resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("Location"), "https://static.example.com/blog/post?page=2"; got != want {
+			t.Errorf("wanted location %q, got: %q", want, got)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		resp, err := client.Get(ts.URL + "/old/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusGone {
+			t.Errorf("wanted status 410, got: %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("wanted content type application/json, got: %q", got)
+		}
+		if string(body) != `{"error": "gone"}` {
+			t.Errorf("wanted the configured body, got: %q", body)
+		}
+	})
+}
+
+func TestDenyResponse(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: json
+    path_regex: ^/json
+    action: DENY
+    response:
+      status: 403
+      format: json
+  - name: text
+    path_regex: ^/text
+    action: DENY
+    response:
+      format: text
+  - name: template
+    path_regex: ^/template
+    action: DENY
+    response:
+      status: 451
+      content_type: text/plain
+      body: "{{.Rule}} {{.ID}}"
+  - name: html
+    path_regex: ^/html
+    action: DENY
+    response:
+      status: 403
+`), "deny.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	hashes := map[string]string{}
+	for _, b := range pol.Bots {
+		hashes[b.Name] = b.Hash()
+	}
+
+	for _, tt := range []struct {
+		path        string
+		status      int
+		contentType string
+		check       func(t *testing.T, body string)
+	}{
+		{
+			path:        "/json",
+			status:      http.StatusForbidden,
+			contentType: "application/json",
+			check: func(t *testing.T, body string) {
+				var resp struct {
+					Error string     `json:"error"`
+					Code  ReasonCode `json:"code"`
+					ID    string     `json:"id"`
+				}
+				if err := json.Unmarshal([]byte(body), &resp); err != nil {
+					t.Fatalf("wanted JSON, got: %q: %v", body, err)
+				}
+				if resp.Code != ReasonRuleDenied || resp.ID != hashes["json"] || resp.Error == "" {
+					t.Errorf("wanted the reason code, error ID and message, got: %+v", resp)
+				}
+			},
+		},
+		{
+			path:        "/text",
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				if !strings.Contains(body, hashes["text"]) || strings.Contains(body, "<") {
+					t.Errorf("wanted the deny message as text, got: %q", body)
+				}
+			},
+		},
+		{
+			path:        "/template",
+			status:      http.StatusUnavailableForLegalReasons,
+			contentType: "text/plain",
+			check: func(t *testing.T, body string) {
+				if want := "template " + hashes["template"]; body != want {
+					t.Errorf("wanted %q, got: %q", want, body)
+				}
+			},
+		},
+		{
+			path:        "/html",
+			status:      http.StatusForbidden,
+			contentType: "text/html; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				if !strings.Contains(body, hashes["html"]) {
+					t.Errorf("wanted the deny page, got: %q", body)
+				}
+			},
+		},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("wanted status %d, got: %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.contentType {
+				t.Errorf("wanted content type %q, got: %q", tt.contentType, got)
+			}
+			if got := resp.Header.Get(ReasonHeader); got != string(ReasonRuleDenied) {
+				t.Errorf("wanted reason %s, got: %q", ReasonRuleDenied, got)
+			}
+			tt.check(t, string(body))
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+const (
+	// velocityHalfLife is how quickly clients are forgiven for past
+	// requests when working out adaptive difficulty.
+	velocityHalfLife = time.Minute
+
+	// challengeVelocityThreshold is about how many challenges a client may
+	// be issued per velocityHalfLife before its difficulty goes up. Every
+	// time it doubles, the difficulty goes up by one more.
+	challengeVelocityThreshold = 3
+
+	// requestVelocityThreshold is the same for requests the client sends.
+	requestVelocityThreshold = 120
+)
+
+var difficultyEscalations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_difficulty_escalations",
+	Help: "The total number of challenges issued above their base difficulty because of adaptive difficulty",
+}, []string{"rule"})
+
+// velocityKey returns the key clients are tracked by for adaptive
+// difficulty. IPv6 clients are tracked by their /64, since each client
+// usually gets a whole one.
+func velocityKey(r *http.Request) string {
+	ip := net.ParseIP(r.Header.Get("X-Real-Ip"))
+	if ip == nil {
+		return r.Header.Get("X-Real-Ip")
+	}
+
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+
+	return ip.String()
+}
+
+// escalationSteps returns how many steps the difficulty goes up for a client
+// with count events against threshold.
+func escalationSteps(count, threshold float64) int {
+	if count <= threshold {
+		return 0
+	}
+
+	return 1 + int(math.Log2(count/threshold))
+}
+
+// shiftRules returns rules with the difficulty moved by steps, or rules
+// itself if steps is zero.
+func shiftRules(rules *config.ChallengeRules, steps int) *config.ChallengeRules {
+	if steps == 0 || rules == nil {
+		return rules
+	}
+
+	shift := func(difficulty int) int {
+		return min(max(difficulty+steps, min(difficulty, 1)), 64)
+	}
+
+	shifted := *rules
+	shifted.Difficulty = shift(rules.Difficulty)
+	if rules.MaxDifficulty != 0 {
+		shifted.MaxDifficulty = shift(rules.MaxDifficulty)
+	}
+	if rules.ReportAs == rules.Difficulty {
+		shifted.ReportAs = shifted.Difficulty
+	}
+
+	return &shifted
+}
+
+// adaptiveDifficulty reports whether rules have a difficulty band.
+func adaptiveDifficulty(rules *config.ChallengeRules) bool {
+	return rules != nil && rules.MaxDifficulty > rules.Difficulty
+}
+
+// recordRequest counts a request from a client that may have to solve a
+// challenge under rule.
+func (s *Server) recordRequest(r *http.Request, rule *policy.Bot) {
+	if rule == nil || !adaptiveDifficulty(rule.Challenge) {
+		return
+	}
+
+	s.requestVelocity.Add(velocityKey(r))
+}
+
+// issueChallengeRules counts a challenge issued to the client and returns the
+// rules for it. If rule has a difficulty band, the difficulty goes up from
+// the bottom of the band the faster the client has been requesting
+// challenges and sending requests.
+func (s *Server) issueChallengeRules(r *http.Request, rule *policy.Bot) *config.ChallengeRules {
+	base := shiftRules(s.emergencyRules(rule.Challenge), s.attackOffset())
+	s.recordIssued(r)
+	if !adaptiveDifficulty(base) {
+		return base
+	}
+
+	key := velocityKey(r)
+	steps := escalationSteps(s.challengeVelocity.Add(key), challengeVelocityThreshold) +
+		escalationSteps(s.requestVelocity.Count(key), requestVelocityThreshold)
+	if steps == 0 {
+		return base
+	}
+
+	rules := *base
+	rules.Difficulty = min(base.Difficulty+steps, base.MaxDifficulty)
+	if base.ReportAs == base.Difficulty {
+		rules.ReportAs = rules.Difficulty
+	}
+
+	difficultyEscalations.WithLabelValues(rule.Name).Inc()
+	s.requestLogger(r).Debug("escalated challenge difficulty", "rule", rule.Name, "difficulty", rules.Difficulty)
+
+	return &rules
+}
+
+// matchChallenge finds the difficulty in the rules' band whose challenge for
+// r satisfies match. Clients may have been issued a challenge at any
+// difficulty in the band, so all of them are tried.
+//
+// With random challenges, claimed is the challenge the client says it was
+// issued. It must have been issued to the client at a difficulty in the
+// band, and, if fresh is set, not have expired.
+func (s *Server) matchChallenge(r *http.Request, rules *config.ChallengeRules, claimed string, fresh bool, match func(challenge string) bool) (string, int, bool) {
+	if s.opts.RandomChallenges {
+		difficulty, ok := s.verifyRandomChallenge(r, claimed, fresh, time.Now())
+		if !ok || difficulty < rules.Difficulty || difficulty > max(rules.Difficulty, rules.MaxDifficulty) || !match(claimed) {
+			return "", 0, false
+		}
+
+		return claimed, difficulty, true
+	}
+
+	for difficulty := rules.Difficulty; difficulty <= max(rules.Difficulty, rules.MaxDifficulty); difficulty++ {
+		if challenge := s.challengeFor(r, difficulty); match(challenge) {
+			return challenge, difficulty, true
+		}
+	}
+
+	return "", 0, false
+}
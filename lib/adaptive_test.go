@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestVelocityKey(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		want string
+	}{
+		{ip: "198.51.100.1", want: "198.51.100.1"},
+		{ip: "2001:db8:1:2:3:4:5:6", want: "2001:db8:1:2::/64"},
+		{ip: "", want: ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Real-Ip", tt.ip)
+
+		if got := velocityKey(r); got != tt.want {
+			t.Errorf("velocityKey(%q): wanted %q, got: %q", tt.ip, tt.want, got)
+		}
+	}
+}
+
+func TestAdaptiveDifficulty(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+    challenge:
+      difficulty: 1
+      max_difficulty: 3
+      report_as: 1
+      algorithm: fast
+`), "adaptive_difficulty.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	makeChallenge := func() (string, *config.ChallengeRules) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/.within.website/x/cmd/anubis/api/make-challenge", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		var resp struct {
+			Challenge string                 `json:"challenge"`
+			Rules     *config.ChallengeRules `json:"rules"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("can't decode challenge: %v", err)
+		}
+
+		return resp.Challenge, resp.Rules
+	}
+
+	first, rules := makeChallenge()
+	if rules.Difficulty != 1 {
+		t.Fatalf("first challenge should have the base difficulty, got: %d", rules.Difficulty)
+	}
+
+	for range 9 {
+		_, rules = makeChallenge()
+	}
+
+	if rules.Difficulty != 3 {
+		t.Errorf("difficulty should go up to max_difficulty, got: %d", rules.Difficulty)
+	}
+
+	if rules.ReportAs != rules.Difficulty {
+		t.Errorf("report_as should follow the escalated difficulty, got: %d", rules.ReportAs)
+	}
+
+	// A challenge issued before the difficulty went up must still pass.
+	var nonce int
+	var response string
+	for nonce = 0; ; nonce++ {
+		response = internal.SHA256sum(fmt.Sprintf("%s%d", first, nonce))
+		if strings.HasPrefix(response, "0") {
+			break
+		}
+	}
+
+	q := url.Values{}
+	q.Set("response", response)
+	q.Set("nonce", fmt.Sprint(nonce))
+	q.Set("redir", "/")
+	q.Set("elapsedTime", "420")
+
+	req := httptest.NewRequest(http.MethodGet, "/.within.website/x/cmd/anubis/api/pass-challenge?"+q.Encode(), nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Errorf("wanted status %d, got: %d", http.StatusFound, rec.Code)
+	}
+
+	// Other clients are not affected.
+	req = httptest.NewRequest(http.MethodPost, "/.within.website/x/cmd/anubis/api/make-challenge", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.2")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), `"difficulty":1,`) {
+		t.Errorf("other clients should get the base difficulty, got: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/lib/store"
+)
+
+// RuleInfo describes a loaded bot rule for the admin API.
+type RuleInfo struct {
+	Name   string      `json:"name"`
+	Route  string      `json:"route,omitempty"`
+	Action config.Rule `json:"action"`
+	Hash   string      `json:"hash"`
+}
+
+// Rules lists the bot rules of the current policy in the order they are
+// evaluated, global rules first. Rules of a route name the route's host and
+// path prefix.
+func (s *Server) Rules() []RuleInfo {
+	pol := s.policy.Load()
+
+	var result []RuleInfo
+	add := func(route string, bots []policy.Bot) {
+		for _, b := range bots {
+			result = append(result, RuleInfo{
+				Name:   b.Name,
+				Route:  route,
+				Action: b.Action,
+				Hash:   b.Hash(),
+			})
+		}
+	}
+
+	add("", pol.Bots)
+	for _, route := range pol.Routes {
+		add(route.Host+route.PathPrefix, route.Bots)
+	}
+
+	return result
+}
+
+// ConfigInfo is the configuration a server runs with, without secrets.
+type ConfigInfo struct {
+	Target             string               `json:"target"`
+	CookieDomain       string               `json:"cookie_domain,omitempty"`
+	CookiePartitioned  bool                 `json:"cookie_partitioned"`
+	CookieRenewal      string               `json:"cookie_renewal"`
+	OGPassthrough      bool                 `json:"og_passthrough"`
+	OGTimeToLive       string               `json:"og_expiry_time"`
+	ServeRobotsTXT     bool                 `json:"serve_robots_txt"`
+	ForwardToken       bool                 `json:"forward_token"`
+	RandomChallenges   bool                 `json:"random_challenges"`
+	Store              string               `json:"store"`
+	Captcha            bool                 `json:"captcha"`
+	DefaultDifficulty  int                  `json:"default_difficulty"`
+	DNSBL              bool                 `json:"dnsbl"`
+	IPFeeds            []string             `json:"ip_feeds"`
+	DryRun             bool                 `json:"dry_run"`
+	SecondaryScreening config.ScreeningRate `json:"secondary_screening"`
+	TokenBinding       config.TokenBinding  `json:"token_binding"`
+	Rules              int                  `json:"rules"`
+	Routes             int                  `json:"routes"`
+	Emergency          bool                 `json:"emergency"`
+	EmergencyUntil     *time.Time           `json:"emergency_until,omitempty"`
+}
+
+// Config returns the configuration the server currently runs with.
+func (s *Server) Config() ConfigInfo {
+	pol := s.policy.Load()
+
+	var emergencyUntil *time.Time
+	if until := s.EmergencyUntil(); !until.IsZero() {
+		emergencyUntil = &until
+	}
+
+	return ConfigInfo{
+		Target:             s.opts.Target,
+		CookieDomain:       s.opts.CookieDomain,
+		CookiePartitioned:  s.opts.CookiePartitioned,
+		CookieRenewal:      s.opts.CookieRenewal.String(),
+		OGPassthrough:      s.opts.OGPassthrough,
+		OGTimeToLive:       s.opts.OGTimeToLive.String(),
+		ServeRobotsTXT:     s.opts.ServeRobotsTXT,
+		ForwardToken:       s.opts.ForwardToken,
+		RandomChallenges:   s.opts.RandomChallenges,
+		Store:              fmt.Sprintf("%T", s.opts.Store),
+		Captcha:            s.opts.Captcha != nil,
+		DefaultDifficulty:  pol.DefaultDifficulty,
+		DNSBL:              pol.DNSBL,
+		IPFeeds:            feedNames(pol.IPFeeds),
+		DryRun:             pol.DryRun,
+		SecondaryScreening: pol.SecondaryScreening,
+		TokenBinding:       pol.TokenBinding,
+		Rules:              len(pol.AllBots()),
+		Routes:             len(pol.Routes),
+		Emergency:          s.Emergency(),
+		EmergencyUntil:     emergencyUntil,
+	}
+}
+
+// CacheSizes returns the number of entries in the server's in-memory maps by
+// name. Maps that live in a shared store are left out.
+func (s *Server) CacheSizes() map[string]int {
+	sizes := map[string]int{
+		"challenge_velocity": s.challengeVelocity.Len(),
+		"request_velocity":   s.requestVelocity.Len(),
+		"challenge_failures": s.challengeFailures.Len(),
+		"denied_ips":         s.denied.Len(),
+	}
+	if mem, ok := s.opts.Store.(*store.Memory); ok {
+		sizes["store"] = mem.Len()
+	}
+	if s.opts.ResponseCache != nil {
+		sizes["response_cache"] = s.opts.ResponseCache.Len()
+	}
+
+	return sizes
+}
+
+// FlushCaches forgets the results of reverse DNS lookups and the request
+// rates used for adaptive difficulty. Everything in the store, such as issued
+// challenges, redeemed solutions and revoked tokens, is kept, because
+// forgetting it would weaken Anubis.
+func (s *Server) FlushCaches() {
+	s.policy.Load().Flush()
+	s.challengeVelocity.Reset()
+	s.requestVelocity.Reset()
+	s.challengeFailures.Reset()
+
+	slog.Info("flushed caches")
+}
+
+func feedNames(feeds []config.IPFeed) []string {
+	names := make([]string, 0, len(feeds))
+	for _, f := range feeds {
+		names = append(names, f.Name)
+	}
+	return names
+}
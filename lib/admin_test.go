@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestEmergency(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: well-known
+    path_regex: ^/\.well-known/.*$
+    action: ALLOW
+`), "emergency.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	check := func(path string) config.Rule {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		cr, _, err := srv.check(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cr.Rule
+	}
+
+	if got := check("/"); got != config.RuleAllow {
+		t.Errorf("wanted / to be allowed by default, got: %s", got)
+	}
+
+	srv.SetEmergency(true, 0)
+	if !srv.Emergency() || !srv.Config().Emergency {
+		t.Error("wanted emergency mode to be on")
+	}
+	if got := check("/"); got != config.RuleChallenge {
+		t.Errorf("wanted / to be challenged in emergency mode, got: %s", got)
+	}
+	if got := check("/.well-known/security.txt"); got != config.RuleAllow {
+		t.Errorf("wanted explicitly allowed paths to stay allowed, got: %s", got)
+	}
+
+	srv.SetEmergency(false, 0)
+	if got := check("/"); got != config.RuleAllow {
+		t.Errorf("wanted / to be allowed after emergency mode, got: %s", got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+	})
+
+	rules := srv.Rules()
+	if len(rules) == 0 || len(rules) != srv.Config().Rules {
+		t.Fatalf("wanted every loaded rule, got %d rules and config says %d", len(rules), srv.Config().Rules)
+	}
+
+	for i, b := range srv.Policy().Bots {
+		if rules[i].Name != b.Name || rules[i].Hash != b.Hash() || rules[i].Action != b.Action {
+			t.Errorf("rule %d: wanted %s with hash %s, got: %+v", i, b.Name, b.Hash(), rules[i])
+		}
+	}
+}
+
+func TestFlushCaches(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+	})
+	srv.requestVelocity.Add("198.51.100.1")
+	srv.challengeFailures.Add("198.51.100.1")
+
+	srv.FlushCaches()
+
+	for name, size := range srv.CacheSizes() {
+		if size != 0 {
+			t.Errorf("wanted %s to be empty after flushing, got %d entries", name, size)
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package lib
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"math"
 	"net"
@@ -15,21 +18,35 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/a-h/templ"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/vale981/anubis"
 	"github.com/vale981/anubis/data"
 	"github.com/vale981/anubis/decaymap"
 	"github.com/vale981/anubis/internal"
-	"github.com/vale981/anubis/internal/dnsbl"
+	"github.com/vale981/anubis/internal/accesslog"
+	"github.com/vale981/anubis/internal/httpcache"
+	"github.com/vale981/anubis/internal/ipfeed"
+	"github.com/vale981/anubis/internal/notify"
 	"github.com/vale981/anubis/internal/ogtags"
+	"github.com/vale981/anubis/internal/ratelimit"
+	"github.com/vale981/anubis/internal/velocity"
+	"github.com/vale981/anubis/lib/localization"
 	"github.com/vale981/anubis/lib/policy"
 	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/lib/store"
 	"github.com/vale981/anubis/web"
 	"github.com/vale981/anubis/xess"
 )
@@ -50,6 +67,16 @@ var (
 		Help: "The total number of hits from DroneBL",
 	}, []string{"status"})
 
+	dnsblErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anubis_dnsbl_errors",
+		Help: "The total number of failed DNSBL lookups",
+	})
+
+	dnsblBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_dnsbl_breaker_open",
+		Help: "Whether lookups in any DNSBL are paused because of too many failures (1) or not (0)",
+	})
+
 	failedValidations = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "anubis_failed_validations",
 		Help: "The total number of failed validations",
@@ -60,6 +87,22 @@ var (
 		Help:    "The time taken for a browser to generate a response (milliseconds)",
 		Buckets: prometheus.ExponentialBucketsRange(1, math.Pow(2, 18), 19),
 	})
+
+	ruleChallenges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_rule_challenges",
+		Help: "The number of challenges issued, passed and failed by the rule that asked for them",
+	}, []string{"rule", "result"})
+
+	solvedDifficulty = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anubis_solved_difficulty",
+		Help:    "The reported difficulty (report_as) of solved challenges by rule",
+		Buckets: prometheus.LinearBuckets(1, 1, 16),
+	}, []string{"rule"})
+
+	emergencyMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_emergency_mode",
+		Help: "1 if emergency mode is on, 0 otherwise",
+	})
 )
 
 type Options struct {
@@ -74,15 +117,114 @@ type Options struct {
 
 	OGPassthrough bool
 	OGTimeToLive  time.Duration
+	OGTransport   http.RoundTripper
 	Target        string
 
+	// OGCacheSize is how many pages the in-memory Open Graph tag cache keeps
+	// tags for. Defaults to ogtags.DefaultMaxEntries.
+	OGCacheSize int
+
+	// OGCacheFile, if set, is where the in-memory Open Graph tag cache is
+	// saved, so that it outlives restarts.
+	OGCacheFile string
+
+	// OGFilter decides which pages Open Graph tags are fetched for.
+	OGFilter ogtags.Filter
+
+	// OGApprovedTags, if set, are the meta tags passed through. Entries
+	// ending in "*" approve every tag starting with the rest.
+	OGApprovedTags []string
+
+	// OGOEmbed makes Anubis serve the oEmbed representation of pages that
+	// link to one on their own host.
+	OGOEmbed bool
+
+	// Store, if set, is where state shared between replicas is kept. Defaults
+	// to an in-memory store.
+	Store store.Interface
+
+	// StoreFailClosed rejects solutions and bypass tokens when the store
+	// can't tell whether they were already used or revoked. By default they
+	// are accepted, so that clients aren't locked out while the store is
+	// down.
+	StoreFailClosed bool
+
+	// Anonymizer, if set, hashes or truncates client details in logs.
+	Anonymizer *internal.Anonymizer
+
 	WebmasterEmail string
+
+	// Captcha, if set, is the CAPTCHA provider used for rules with the
+	// CAPTCHA action and for clients that keep failing proof-of-work
+	// challenges.
+	Captcha CaptchaProvider
+
+	// ForwardToken adds a signed X-Anubis-Token header to requests passed to
+	// the target, which the target can verify with the key from the JWKS
+	// endpoint.
+	ForwardToken bool
+
+	// CookieRenewal, if set, is how long before it expires a valid cookie
+	// is replaced with a fresh one, so that active clients don't have to
+	// solve a new challenge when it runs out.
+	CookieRenewal time.Duration
+
+	// Theme, if set, customizes the challenge, deny, and error pages and
+	// overrides embedded static files.
+	Theme *web.Theme
+
+	// Static, if set, holds the static files to serve instead of the
+	// embedded ones, in the same layout as web.Static. Browsers are told to
+	// check that they are current, so they can be changed at any time.
+	Static fs.FS
+
+	// RandomChallenges makes Anubis issue a new random challenge every time
+	// instead of deriving it from the client's details and the current
+	// week.
+	RandomChallenges bool
+
+	// Notifier, if set, is told about denied requests.
+	Notifier *notify.Notifier
+
+	// FeedTransport, if set, is used to download IP feeds.
+	FeedTransport http.RoundTripper
+
+	// EmergencyDifficulty is added to the difficulty of the challenges
+	// issued in emergency mode.
+	EmergencyDifficulty int
+
+	// EmergencyCookieLifetime, if set, is how long the cookies issued in
+	// emergency mode are valid for.
+	EmergencyCookieLifetime time.Duration
+
+	// UpstreamHealthPath is the path on the target that WatchUpstream
+	// requests to check whether it is up. Defaults to /.
+	UpstreamHealthPath string
+
+	// UpstreamHealthInterval is how often WatchUpstream checks the target.
+	UpstreamHealthInterval time.Duration
+
+	// UpstreamHealthTimeout is how long a check of the target may take.
+	// Defaults to five seconds.
+	UpstreamHealthTimeout time.Duration
+
+	// Proxy tunes how the targets of routes and bot rules are talked to.
+	// The handler in Next is set up by the caller.
+	Proxy ProxyOptions
+
+	// ResponseCache, if set, keeps responses to anonymous clients that say
+	// they may be reused, so that they don't all hit the target.
+	ResponseCache *httpcache.Cache
 }
 
 func LoadPoliciesOrDefault(fname string, defaultDifficulty int) (*policy.ParsedConfig, error) {
 	var fin io.ReadCloser
 	var err error
 
+	if IsRemotePolicy(fname) {
+		return nil, fmt.Errorf("can't load policy file %s: %w, use RemotePolicy", fname, ErrPolicyPublicKey)
+	}
+
 	if fname != "" {
 		fin, err = os.Open(fname)
 		if err != nil {
@@ -118,35 +260,99 @@ func New(opts Options) (*Server, error) {
 		opts.PrivateKey = priv
 	}
 
+	// Open Graph tags are fetched from the first target of a pool.
+	var ogTarget string
+	if targets := SplitTargets(opts.Target); len(targets) != 0 {
+		ogTarget = targets[0]
+	}
+
 	result := &Server{
-		next:       opts.Next,
-		priv:       opts.PrivateKey,
-		pub:        opts.PrivateKey.Public().(ed25519.PublicKey),
-		policy:     opts.Policy,
-		opts:       opts,
-		DNSBLCache: decaymap.New[string, dnsbl.DroneBLResponse](),
-		OGTags:     ogtags.NewOGTagCache(opts.Target, opts.OGPassthrough, opts.OGTimeToLive),
+		next:   opts.Next,
+		opts:   opts,
+		OGTags: ogtags.NewOGTagCache(ogTarget, opts.OGPassthrough, opts.OGTimeToLive),
+
+		challengeVelocity: velocity.New(velocityHalfLife),
+		requestVelocity:   velocity.New(velocityHalfLife),
+		challengeFailures: velocity.New(velocityHalfLife),
+
+		stats:  newStats(),
+		denied: velocity.New(deniedHalfLife),
+
+		connections: ratelimit.NewConcurrency(),
+		inFlight:    ratelimit.NewConcurrency(),
 	}
 
-	mux := http.NewServeMux()
-	xess.Mount(mux)
+	result.keys.Store(&signingKeys{priv: opts.PrivateKey, pub: opts.PrivateKey.Public().(ed25519.PublicKey)})
+	result.SetPolicy(opts.Policy)
+
+	if opts.Store == nil {
+		opts.Store = store.NewMemory()
+	}
+	result.opts.Store = opts.Store
+	result.feedCache = &store.JSON[ipfeed.Result]{Underlying: opts.Store, Prefix: "ipfeed:"}
+	result.OGTags.SetStore(opts.Store)
+	result.OGTags.SetFilter(opts.OGFilter)
+	if opts.OGApprovedTags != nil {
+		result.OGTags.SetApprovedTags(opts.OGApprovedTags)
+	}
+	if opts.OGOEmbed {
+		result.OGTags.SetOEmbed(oEmbedPath)
+	}
+	if opts.OGCacheSize > 0 {
+		result.OGTags.SetMaxEntries(opts.OGCacheSize)
+	}
+	if opts.OGCacheFile != "" {
+		if err := result.OGTags.SetFile(opts.OGCacheFile); err != nil {
+			slog.Warn("can't load the Open Graph tag cache, starting with an empty one", "err", err)
+		}
+	}
+	result.revocation.store = &store.JSON[int64]{Underlying: opts.Store, Prefix: "revocation:"}
+	result.bypassRevoked = &store.JSON[bool]{Underlying: opts.Store, Prefix: "bypass-revoked:"}
+	result.reputation = &store.JSON[reputationEntry]{Underlying: opts.Store, Prefix: "reputation:"}
+	result.redeemed = &store.JSON[int64]{Underlying: opts.Store, Prefix: "redeemed:"}
 
-	mux.Handle(anubis.StaticPath, internal.UnchangingCache(internal.NoBrowsing(http.StripPrefix(anubis.StaticPath, http.FileServerFS(web.Static)))))
+	if opts.OGTransport != nil {
+		result.OGTags.SetTransport(opts.OGTransport)
+	}
 
-	if opts.ServeRobotsTXT {
-		mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFileFS(w, r, web.Static, "static/robots.txt")
-		})
+	result.handleUpstreamErrors(opts.Next)
 
-		mux.HandleFunc("/.well-known/robots.txt", func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFileFS(w, r, web.Static, "static/robots.txt")
-		})
+	mux := http.NewServeMux()
+	xess.Mount(mux)
+
+	var static fs.FS = web.Static
+	cache := internal.UnchangingCache
+	if opts.Static != nil {
+		static = opts.Static
+		cache = internal.RevalidateCache
 	}
+	static = opts.Theme.StaticFS(static)
+	mux.Handle(anubis.StaticPath, cache(internal.NoBrowsing(http.StripPrefix(anubis.StaticPath, internal.PrecompressedFileServer(static)))))
+
+	// The policy can change on reload, so robots.txt is always routed here
+	// and passed on to the target if Anubis doesn't serve one.
+	robotsTXT := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case result.policy.Load().RobotsTXT != nil:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write(result.policy.Load().RobotsTXT)
+		case opts.ServeRobotsTXT:
+			http.ServeFileFS(w, r, static, "static/robots.txt")
+		default:
+			result.MaybeReverseProxy(w, r)
+		}
+	}
+	mux.HandleFunc("/robots.txt", robotsTXT)
+	mux.HandleFunc("/.well-known/robots.txt", robotsTXT)
 
-	//mux.HandleFunc("GET /.within.website/x/cmd/anubis/static/js/main.mjs", serveMainJSWithBestEncoding)
 	mux.HandleFunc("POST /.within.website/x/cmd/anubis/api/make-challenge", result.MakeChallenge)
 	mux.HandleFunc("GET /.within.website/x/cmd/anubis/api/pass-challenge", result.PassChallenge)
+	mux.HandleFunc("POST /.within.website/x/cmd/anubis/api/pass-challenge", result.PassChallenge)
+	mux.HandleFunc("POST /.within.website/x/cmd/anubis/api/pass-captcha", result.PassCaptcha)
 	mux.HandleFunc("GET /.within.website/x/cmd/anubis/api/test-error", result.TestError)
+	mux.HandleFunc("GET /.within.website/x/cmd/anubis/api/reason-codes", result.ServeReasonCodes)
+	mux.HandleFunc("GET /.well-known/anubis/jwks.json", result.ServeJWKS)
+	mux.HandleFunc("GET "+oEmbedPath, result.ServeOEmbed)
 
 	mux.HandleFunc("/", result.MaybeReverseProxy)
 
@@ -156,22 +362,142 @@ func New(opts Options) (*Server, error) {
 }
 
 type Server struct {
-	mux        *http.ServeMux
-	next       http.Handler
-	priv       ed25519.PrivateKey
-	pub        ed25519.PublicKey
-	policy     *policy.ParsedConfig
-	opts       Options
-	DNSBLCache *decaymap.Impl[string, dnsbl.DroneBLResponse]
-	OGTags     *ogtags.OGTagCache
+	mux    *http.ServeMux
+	next   http.Handler
+	keys   atomic.Pointer[signingKeys]
+	policy atomic.Pointer[policy.ParsedConfig]
+	opts   Options
+	OGTags *ogtags.OGTagCache
+
+	// ownPolicy is the policy last set, and botData the bot rules checked
+	// after its own. policy holds both combined.
+	policyLock sync.Mutex
+	ownPolicy  *policy.ParsedConfig
+	botData    *config.BotData
+
+	// feeds caches the providers of IP feeds by feedKey, and feedCache
+	// holds their answers.
+	feeds     sync.Map
+	feedCache *store.JSON[ipfeed.Result]
+
+	// feedPending holds the cache keys of async IP feed lookups that are
+	// running, so that a client is only looked up once at a time.
+	feedPending sync.Map
+
+	// proxies caches the reverse proxies to route and bot rule targets by
+	// target URL. SetPolicy replaces it, so that targets that were removed
+	// from the policy are dropped.
+	proxies atomic.Pointer[sync.Map]
+
+	// challengeVelocity and requestVelocity track how fast clients get
+	// challenges and send requests for adaptive difficulty.
+	challengeVelocity *velocity.Tracker
+	requestVelocity   *velocity.Tracker
+
+	// challengeFailures tracks how fast clients send wrong solutions, to
+	// escalate them to a CAPTCHA.
+	challengeFailures *velocity.Tracker
+
+	revocation revocation
+
+	// bypassRevoked holds the IDs of revoked bypass tokens.
+	bypassRevoked *store.JSON[bool]
+
+	// reputation holds client reputation scores by velocityKey.
+	reputation *store.JSON[reputationEntry]
+
+	// redeemed holds the challenge solutions that were exchanged for
+	// cookies, to stop them from being used again.
+	redeemed *store.JSON[int64]
+
+	// emergency makes Anubis challenge requests it would otherwise allow by
+	// default. emergencyTimer turns it off again, and emergencyGeneration
+	// tells it whether emergency mode was set again since it was started.
+	emergency           atomic.Bool
+	emergencyLock       sync.Mutex
+	emergencyTimer      *time.Timer
+	emergencyUntil      time.Time
+	emergencyGeneration int
+
+	// stats counts what Anubis did for the status dashboard.
+	stats *stats
+
+	// denied tracks how often client IP addresses were denied, for
+	// firewalls to block them.
+	denied *velocity.Tracker
+
+	// tarpits is the number of requests held by TARPIT rules.
+	tarpits atomic.Int64
+
+	// connections and inFlight count the connections and upstream requests
+	// each client IP address has open, for the per-client limits.
+	connections *ratelimit.Concurrency
+	inFlight    *ratelimit.Concurrency
+
+	// conns maps the connections counted for the connection limit to
+	// their *connInfo, so that ConnState can release them.
+	conns sync.Map
+
+	// upstream is the result of the last check of the target, or nil if
+	// it was never checked.
+	upstream atomic.Pointer[UpstreamHealth]
+}
+
+// Policy returns the policy currently in use.
+func (s *Server) Policy() *policy.ParsedConfig {
+	return s.policy.Load()
+}
+
+// SetPolicy atomically replaces the policy. Requests that are already being
+// handled finish with the policy they started with. The old policy is
+// released, so it must not be used by another Server.
+func (s *Server) SetPolicy(pol *policy.ParsedConfig) {
+	s.policyLock.Lock()
+	defer s.policyLock.Unlock()
+
+	old := s.ownPolicy
+	s.ownPolicy = pol
+	s.proxies.Store(s.proxiesFor(pol))
+	s.policy.Store(s.withBotData(pol))
+
+	if old != nil && old != pol {
+		old.Release()
+	}
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pol := s.policy.Load()
+
+	// Continue traces started by the reverse proxy in front of Anubis.
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx = localization.WithLocalizer(ctx, pol.Localization.Localizer(r.Header.Get("Accept-Language")))
+	if pol.Branding != nil {
+		ctx = web.WithBranding(ctx, pol.Branding)
+	}
+	if s.opts.Theme != nil {
+		ctx = web.WithTheme(ctx, s.opts.Theme)
+	}
+	r = r.WithContext(ctx)
+
+	if !s.admitConnection(w, r) {
+		return
+	}
+
 	s.mux.ServeHTTP(w, r)
 }
 
+// challengeTitle is the title of the pages that ask clients to solve a
+// challenge.
+func (s *Server) challengeTitle(r *http.Request) string {
+	if b := s.policy.Load().Branding; b != nil && b.Title != "" {
+		return b.Title
+	}
+
+	return localization.ForRequest(r).T("making_sure_not_bot")
+}
+
 func (s *Server) challengeFor(r *http.Request, difficulty int) string {
-	fp := sha256.Sum256(s.priv.Seed())
+	fp := sha256.Sum256(s.signingKey().Seed())
 
 	challengeData := fmt.Sprintf(
 		"Accept-Language=%s,X-Real-IP=%s,User-Agent=%s,WeekTime=%s,Fingerprint=%x,Difficulty=%d",
@@ -186,75 +512,113 @@ func (s *Server) challengeFor(r *http.Request, difficulty int) string {
 }
 
 func (s *Server) MaybeReverseProxy(w http.ResponseWriter, r *http.Request) {
-	lg := slog.With(
-		"user_agent", r.UserAgent(),
-		"accept_language", r.Header.Get("Accept-Language"),
-		"priority", r.Header.Get("Priority"),
-		"x-forwarded-for",
-		r.Header.Get("X-Forwarded-For"),
-		"x-real-ip", r.Header.Get("X-Real-Ip"),
-	)
+	r, span := startSpan(r, "MaybeReverseProxy", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
 
-	cr, rule, err := s.check(r)
+	lg := s.requestLogger(r)
+
+	if r.Header.Get(BypassHeader) != "" {
+		s.bypass(w, r)
+		return
+	}
+
+	if su := s.signedURLFor(r); su != nil {
+		s.serveSignedURL(w, r, su)
+		return
+	}
+
+	cr, rule, hit, err := s.checkWithFeeds(r)
 	if err != nil {
 		lg.Error("check failed", "err", err)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("Internal Server Error: administrator has misconfigured Anubis. Please contact the administrator and ask them to look for the logs around \"maybeReverseProxy\"", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonMisconfiguration, localization.ForRequest(r).T("misconfigured", "maybeReverseProxy"), http.StatusInternalServerError)
 		return
 	}
+	span.SetAttributes(attribute.String("anubis.rule", cr.Name), attribute.String("anubis.action", string(cr.Rule)))
 
 	r.Header.Add("X-Anubis-Rule", cr.Name)
 	r.Header.Add("X-Anubis-Action", string(cr.Rule))
 	lg = lg.With("check_result", cr)
 	policy.Applications.WithLabelValues(cr.Name, string(cr.Rule)).Add(1)
+	if rule != nil && rule.Target != "" {
+		r = withUpstream(r, rule.Target)
+	}
+	s.stats.rule(cr)
+
+	if geoip := s.policy.Load().GeoIP; geoip != nil {
+		country, err := geoip.Country(net.ParseIP(r.Header.Get("X-Real-Ip")))
+		if err != nil || country == "" {
+			country = "unknown"
+		}
+		policy.CountryResults.WithLabelValues(country, string(cr.Rule)).Inc()
+	}
 
 	ip := r.Header.Get("X-Real-Ip")
 
-	if s.policy.DNSBL && ip != "" {
-		resp, ok := s.DNSBLCache.Get(ip)
-		if !ok {
-			lg.Debug("looking up ip in dnsbl")
-			resp, err := dnsbl.Lookup(ip)
-			if err != nil {
-				lg.Error("can't look up ip in dnsbl", "err", err)
-			}
-			s.DNSBLCache.Set(ip, resp, 24*time.Hour)
-			droneBLHits.WithLabelValues(resp.String()).Inc()
-		}
+	if hit != nil {
+		ipFeedHits.WithLabelValues(hit.feed.Name, string(cr.Rule)).Inc()
+	}
 
-		if resp != dnsbl.AllGood {
-			lg.Info("DNSBL hit", "status", resp.String())
-			templ.Handler(web.Base("Oh noes!", web.ErrorPage(fmt.Sprintf("DroneBL reported an entry: %s, see https://dronebl.org/lookup?ip=%s", resp.String(), ip), s.opts.WebmasterEmail)), templ.WithStatus(http.StatusOK)).ServeHTTP(w, r)
-			return
-		}
+	if cr.Rule != config.RuleAllow && s.dryRun(rule) {
+		s.forwardDryRun(w, r, cr, rule)
+		return
+	}
+
+	if hit != nil && cr.Rule == config.RuleDeny {
+		s.denyListed(w, r, hit)
+		return
 	}
 
 	switch cr.Rule {
 	case config.RuleAllow:
 		lg.Debug("allowing traffic to origin (explicit)")
-		s.next.ServeHTTP(w, r)
+		if !s.allowRequest(w, r, config.RuleAllow, "ip:"+ip) {
+			return
+		}
+		s.forward(w, r)
 		return
 	case config.RuleDeny:
 		s.ClearCookie(w)
 		lg.Info("explicit deny")
 		if rule == nil {
 			lg.Error("rule is nil, cannot calculate checksum")
-			templ.Handler(web.Base("Oh noes!", web.ErrorPage("Other internal server error (contact the admin)", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+			s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("internal_error"), http.StatusInternalServerError)
 			return
 		}
 		hash := rule.Hash()
 
 		lg.Debug("rule hash", "hash", hash)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage(fmt.Sprintf("Access Denied: error code %s", hash), s.opts.WebmasterEmail)), templ.WithStatus(http.StatusOK)).ServeHTTP(w, r)
+		s.recordReputation(r, "denied", reputationDenied)
+		s.recordDenied(r, "deny", rule.Name, hash)
+		s.respondDenied(w, r, rule, hash)
+		return
+	case config.RuleTarpit:
+		s.tarpit(w, r, rule)
+		return
+	case config.RuleDecoy:
+		s.serveDecoy(w, r, rule)
+		return
+	case config.RuleRedirect:
+		s.redirect(w, r, rule)
 		return
-	case config.RuleChallenge:
+	case config.RuleStatus:
+		s.respondStatus(w, r, rule)
+		return
+	case config.RuleChallenge, config.RuleCaptcha:
 		lg.Debug("challenge requested")
+		s.recordRequest(r, rule)
 	case config.RuleBenchmark:
 		lg.Debug("serving benchmark page")
 		s.RenderBench(w, r)
 		return
 	default:
 		s.ClearCookie(w)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("Other internal server error (contact the admin)", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("internal_error"), http.StatusInternalServerError)
+		return
+	}
+
+	if isCORSPreflight(r) {
+		lg.Debug("passing CORS preflight request", "path", r.URL.Path)
+		s.serveCORSPreflight(w, r)
 		return
 	}
 
@@ -280,21 +644,67 @@ func (s *Server) MaybeReverseProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := jwt.ParseWithClaims(ckie.Value, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.pub, nil
-	}, jwt.WithExpirationRequired(), jwt.WithStrictDecoding())
+	token, err := jwt.ParseWithClaims(ckie.Value, jwt.MapClaims{}, s.verificationKeys, jwt.WithExpirationRequired(), jwt.WithStrictDecoding())
 
-	if err != nil || !token.Valid {
+	if err != nil || !token.Valid || !isCookieToken(token.Claims) {
 		lg.Debug("invalid token", "path", r.URL.Path, "err", err)
 		s.ClearCookie(w)
 		s.RenderIndex(w, r, rule)
 		return
 	}
 
-	if randomJitter() {
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && s.tokenRevoked(r.Context(), claims) {
+		lg.Debug("revoked token", "path", r.URL.Path)
+		s.ClearCookie(w)
+		s.RenderIndex(w, r, rule)
+		return
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && !s.tokenBound(r, claims) {
+		lg.Debug("token was issued to a different network", "path", r.URL.Path)
+		s.ClearCookie(w)
+		s.RenderIndex(w, r, rule)
+		return
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && rule.Action == config.RuleCaptcha && s.opts.Captcha != nil && !isCaptchaToken(claims) && !isMintedToken(claims) {
+		lg.Debug("rule needs a CAPTCHA, but the token is from a proof-of-work challenge", "path", r.URL.Path)
+		s.RenderIndex(w, r, rule)
+		return
+	}
+
+	// Clients that passed a challenge are rate limited by their token, so
+	// that clients sharing an IP address don't use up each other's requests.
+	rateLimitKey := "ip:" + ip
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if response, ok := claims["response"].(string); ok {
+			rateLimitKey = "token:" + response
+		}
+	}
+
+	// Minted cookies have no solved challenge to check again.
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && isMintedToken(claims) {
+		r.Header.Add("X-Anubis-Status", "PASS-MINTED")
+		lg.Debug("cookie was minted by an operator")
+		if !s.allowRequest(w, r, config.RuleChallenge, rateLimitKey) {
+			return
+		}
+		s.forward(w, r)
+		return
+	}
+
+	if !s.secondaryScreening(rule) {
 		r.Header.Add("X-Anubis-Status", "PASS-BRIEF")
 		lg.Debug("cookie is not enrolled into secondary screening")
-		s.next.ServeHTTP(w, r)
+		// Cookies are only renewed once their challenge and response
+		// have been checked again, even if they aren't screened.
+		if claims, ok := token.Claims.(jwt.MapClaims); ok && s.renewalDue(claims) && s.checkCookieChallenge(r, rule, claims) == nil {
+			s.renewCookie(w, r, claims)
+		}
+		if !s.allowRequest(w, r, config.RuleChallenge, rateLimitKey) {
+			return
+		}
+		s.forward(w, r)
 		return
 	}
 
@@ -305,63 +715,135 @@ func (s *Server) MaybeReverseProxy(w http.ResponseWriter, r *http.Request) {
 		s.RenderIndex(w, r, rule)
 		return
 	}
-	challenge := s.challengeFor(r, rule.Challenge.Difficulty)
-
-	if claims["challenge"] != challenge {
-		lg.Debug("invalid challenge", "path", r.URL.Path)
+	if err := s.checkCookieChallenge(r, rule, claims); err != nil {
+		lg.Debug("cookie failed verification", "path", r.URL.Path, "err", err)
+		if errors.Is(err, errCookieResponse) {
+			failedValidations.Inc()
+		}
 		s.ClearCookie(w)
 		s.RenderIndex(w, r, rule)
 		return
 	}
 
+	if s.renewalDue(claims) {
+		s.renewCookie(w, r, claims)
+	}
+
+	slog.Debug("all checks passed")
+	r.Header.Add("X-Anubis-Status", "PASS-FULL")
+	s.recordReputation(r, "browsing", reputationBrowsing)
+	if !s.allowRequest(w, r, config.RuleChallenge, rateLimitKey) {
+		return
+	}
+	s.forward(w, r)
+}
+
+var (
+	errCookieChallenge = errors.New("the challenge was not issued to this client")
+	errCookieResponse  = errors.New("the response does not solve the challenge")
+)
+
+// checkCookieChallenge checks that the challenge in the claims of a cookie
+// was issued to the client of r for rule, and that the response in them
+// solves it.
+func (s *Server) checkCookieChallenge(r *http.Request, rule *policy.Bot, claims jwt.MapClaims) error {
+	claimed, _ := claims["challenge"].(string)
+	challenge, _, ok := s.matchChallenge(r, s.acceptedRules(rule.Challenge), claimed, false, func(challenge string) bool {
+		return claimed == challenge
+	})
+	if !ok {
+		return errCookieChallenge
+	}
+
 	var nonce int
 
 	if v, ok := claims["nonce"].(float64); ok {
 		nonce = int(v)
 	}
 
-	calcString := fmt.Sprintf("%s%d", challenge, nonce)
-	calculated := internal.SHA256sum(calcString)
+	response, _ := claims["response"].(string)
+	valid := true
+	switch {
+	case isCaptchaToken(claims):
+		valid = subtle.ConstantTimeCompare([]byte(response), []byte(s.captchaResponse(challenge, nonce))) == 1
+	case rule.Challenge.Algorithm == config.AlgorithmScrypt:
+		// A memory-hard response is too expensive to compute again for
+		// every request. The token's signature already shows that it was
+		// checked when the cookie was issued.
+	default:
+		valid = subtle.ConstantTimeCompare([]byte(response), []byte(s.responseFor(rule.Challenge.Algorithm, challenge, nonce))) == 1
+	}
 
-	if subtle.ConstantTimeCompare([]byte(claims["response"].(string)), []byte(calculated)) != 1 {
-		lg.Debug("invalid response", "path", r.URL.Path)
-		failedValidations.Inc()
-		s.ClearCookie(w)
-		s.RenderIndex(w, r, rule)
-		return
+	if !valid {
+		return errCookieResponse
 	}
 
-	slog.Debug("all checks passed")
-	r.Header.Add("X-Anubis-Status", "PASS-FULL")
-	s.next.ServeHTTP(w, r)
+	return nil
 }
 
 func (s *Server) RenderIndex(w http.ResponseWriter, r *http.Request, rule *policy.Bot) {
-	lg := slog.With(
-		"user_agent", r.UserAgent(),
-		"accept_language", r.Header.Get("Accept-Language"),
-		"priority", r.Header.Get("Priority"),
-		"x-forwarded-for",
-		r.Header.Get("X-Forwarded-For"),
-		"x-real-ip", r.Header.Get("X-Real-Ip"),
-	)
+	lg := s.requestLogger(r)
 
-	challenge := s.challengeFor(r, rule.Challenge.Difficulty)
+	release, ok := s.admitChallenge(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	accesslog.FromContext(r.Context()).SetChallenge("issued")
+	s.countChallenge(rule, "issued")
+
+	if isGRPCRequest(r) {
+		lg.Debug("asking gRPC client to solve a challenge", "path", r.URL.Path)
+		s.respondGRPCChallenge(w, r)
+		return
+	}
+
+	if s.isAPIPath(r) || isStreamingRequest(r) || s.wantsJSON(r) {
+		lg.Debug("asking API client to solve a challenge", "path", r.URL.Path)
+		s.respondAPIChallenge(w, r, rule)
+		return
+	}
+
+	// Let clients tell the challenge page apart from a page of the site.
+	w.Header().Set(ReasonHeader, string(ReasonChallengeRequired))
+
+	if s.wantsCaptcha(r, rule) {
+		lg.Debug("asking client to solve a CAPTCHA", "path", r.URL.Path)
+		s.renderCaptcha(w, r)
+		return
+	}
+
+	rules := s.issueChallengeRules(r, rule)
+	challenge := s.newChallenge(r, rules.Difficulty)
 
 	var ogTags map[string]string = nil
 	if s.opts.OGPassthrough {
 		var err error
-		ogTags, err = s.OGTags.GetOGTags(r.URL)
+		_, ogSpan := startSpan(r, "ogtags.GetOGTags")
+		// The filter needs the host, which server requests only have in Host.
+		u := *r.URL
+		u.Host = r.Host
+		ogTags, err = s.OGTags.GetOGTags(&u)
 		if err != nil {
 			lg.Error("failed to get OG tags", "err", err)
+			ogSpan.RecordError(err)
+			ogSpan.SetStatus(codes.Error, err.Error())
 			ogTags = nil
 		}
+		ogSpan.End()
 	}
 
-	component, err := web.BaseWithChallengeAndOGTags("Making sure you're not a bot!", web.Index(), challenge, rule.Challenge, ogTags)
+	if rules.Algorithm == config.AlgorithmNoJS {
+		lg.Debug("serving challenge for clients without JavaScript", "path", r.URL.Path)
+		s.renderNoJSChallenge(w, r, rules, challenge, ogTags)
+		return
+	}
+
+	component, err := web.BaseWithChallengeAndOGTags(s.challengeTitle(r), web.Index(), challenge, rules, ogTags)
 	if err != nil {
 		lg.Error("render failed", "err", err)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("Other internal server error (contact the admin)", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("internal_error"), http.StatusInternalServerError)
 		return
 	}
 
@@ -376,16 +858,22 @@ func (s *Server) RenderBench(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) MakeChallenge(w http.ResponseWriter, r *http.Request) {
-	lg := slog.With("user_agent", r.UserAgent(), "accept_language", r.Header.Get("Accept-Language"), "priority", r.Header.Get("Priority"), "x-forwarded-for", r.Header.Get("X-Forwarded-For"), "x-real-ip", r.Header.Get("X-Real-Ip"))
+	r, span := startSpan(r, "MakeChallenge", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	lg := s.requestLogger(r)
 
 	encoder := json.NewEncoder(w)
 	cr, rule, err := s.check(r)
 	if err != nil {
 		lg.Error("check failed", "err", err)
+		w.Header().Set(ReasonHeader, string(ReasonMisconfiguration))
 		w.WriteHeader(http.StatusInternalServerError)
 		err := encoder.Encode(struct {
-			Error string `json:"error"`
+			Error string     `json:"error"`
+			Code  ReasonCode `json:"code"`
 		}{
+			Code:  ReasonMisconfiguration,
 			Error: "Internal Server Error: administrator has misconfigured Anubis. Please contact the administrator and ask them to look for the logs around \"makeChallenge\"",
 		})
 		if err != nil {
@@ -395,46 +883,53 @@ func (s *Server) MakeChallenge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	lg = lg.With("check_result", cr)
-	challenge := s.challengeFor(r, rule.Challenge.Difficulty)
-
-	err = encoder.Encode(struct {
-		Challenge string                 `json:"challenge"`
-		Rules     *config.ChallengeRules `json:"rules"`
-	}{
-		Challenge: challenge,
-		Rules:     rule.Challenge,
-	})
-	if err != nil {
+
+	release, ok := s.admitChallenge(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	challenge := s.issueAPIChallenge(r, rule)
+
+	if err := encoder.Encode(challenge); err != nil {
 		lg.Error("failed to encode challenge", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	lg.Debug("made challenge", "challenge", challenge, "rules", rule.Challenge, "cr", cr)
-	challengesIssued.Inc()
+	lg.Debug("made challenge", "challenge", challenge.Challenge, "rules", challenge.Rules, "cr", cr)
+	s.countChallenge(rule, "issued")
 }
 
 func (s *Server) PassChallenge(w http.ResponseWriter, r *http.Request) {
-	lg := slog.With(
-		"user_agent", r.UserAgent(),
-		"accept_language", r.Header.Get("Accept-Language"),
-		"priority", r.Header.Get("Priority"),
-		"x-forwarded-for", r.Header.Get("X-Forwarded-For"),
-		"x-real-ip", r.Header.Get("X-Real-Ip"),
-	)
+	r, span := startSpan(r, "PassChallenge", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	lg := s.requestLogger(r)
+	entry := accesslog.FromContext(r.Context())
+	entry.SetChallenge("failed")
 
 	cr, rule, err := s.check(r)
 	if err != nil {
 		lg.Error("check failed", "err", err)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("Internal Server Error: administrator has misconfigured Anubis. Please contact the administrator and ask them to look for the logs around \"passChallenge\".", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonMisconfiguration, localization.ForRequest(r).T("misconfigured", "passChallenge"), http.StatusInternalServerError)
 		return
 	}
 	lg = lg.With("check_result", cr)
 
+	if r.Method == http.MethodPost && hasJSONBody(r) {
+		if err := readJSONSolution(w, r); err != nil {
+			lg.Debug("can't read solution", "err", err)
+			s.respondWithError(w, r, ReasonInvalidResponse, "invalid solution: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	nonceStr := r.FormValue("nonce")
 	if nonceStr == "" {
 		s.ClearCookie(w)
 		lg.Debug("no nonce")
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("missing nonce", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonMissingNonce, "missing nonce", http.StatusInternalServerError)
 		return
 	}
 
@@ -442,7 +937,7 @@ func (s *Server) PassChallenge(w http.ResponseWriter, r *http.Request) {
 	if elapsedTimeStr == "" {
 		s.ClearCookie(w)
 		lg.Debug("no elapsedTime")
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("missing elapsedTime", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonMissingElapsedTime, "missing elapsedTime", http.StatusInternalServerError)
 		return
 	}
 
@@ -450,81 +945,147 @@ func (s *Server) PassChallenge(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		s.ClearCookie(w)
 		lg.Debug("elapsedTime doesn't parse", "err", err)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("invalid elapsedTime", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonInvalidElapsedTime, "invalid elapsedTime", http.StatusInternalServerError)
 		return
 	}
 
 	lg.Info("challenge took", "elapsedTime", elapsedTime)
 	timeTaken.Observe(elapsedTime)
 
+	var hashRate float64
+	if hashRateStr := r.FormValue("hashRate"); hashRateStr != "" {
+		hashRate, err = strconv.ParseFloat(hashRateStr, 64)
+		if err != nil || hashRate < 0 || math.IsNaN(hashRate) || math.IsInf(hashRate, 0) {
+			s.ClearCookie(w)
+			lg.Debug("hashRate doesn't parse", "hashRate", hashRateStr, "err", err)
+			s.respondWithError(w, r, ReasonInvalidHashRate, "invalid hashRate", http.StatusInternalServerError)
+			return
+		}
+		clientHashRate.Observe(hashRate)
+	}
+
 	response := r.FormValue("response")
 	redir := r.FormValue("redir")
 
-	challenge := s.challengeFor(r, rule.Challenge.Difficulty)
-
 	nonce, err := strconv.Atoi(nonceStr)
 	if err != nil {
 		s.ClearCookie(w)
 		lg.Debug("nonce doesn't parse", "err", err)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("invalid nonce", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonInvalidNonce, "invalid nonce", http.StatusInternalServerError)
 		return
 	}
 
-	calcString := fmt.Sprintf("%s%d", challenge, nonce)
-	calculated := internal.SHA256sum(calcString)
+	accepted := s.acceptedRules(rule.Challenge)
+	if rule.Challenge.Algorithm != config.AlgorithmNoJS {
+		// Counting the leading zeroes is cheap, computing the response
+		// isn't, so responses that can't be right are rejected first.
+		zeroes := len(response) - len(strings.TrimLeft(response, "0"))
+		valid := zeroes >= effectiveDifficulty(accepted, hashRate)
+
+		// Clients solving scrypt challenges stop at the first response
+		// with exactly as many leading zeroes as the difficulty, so only
+		// the challenge for that difficulty is checked, and scrypt runs
+		// once per request instead of once per difficulty in the band.
+		if rule.Challenge.Algorithm == config.AlgorithmScrypt {
+			valid = zeroes >= accepted.Difficulty && zeroes <= max(accepted.Difficulty, accepted.MaxDifficulty)
+			only := *accepted
+			only.Difficulty, only.MaxDifficulty = zeroes, zeroes
+			accepted = &only
+		}
 
-	if subtle.ConstantTimeCompare([]byte(response), []byte(calculated)) != 1 {
+		if !valid {
+			s.ClearCookie(w)
+			lg.Debug("response has the wrong number of leading zeroes", "response", response, "hashRate", hashRate)
+			s.respondWithError(w, r, ReasonInvalidResponse, localization.ForRequest(r).T("invalid_response"), http.StatusForbidden)
+			failedValidations.Inc()
+			s.recordChallengeFailure(r, rule)
+			return
+		}
+	}
+
+	challenge, issuedDifficulty, ok := s.matchChallenge(r, accepted, r.FormValue("challenge"), true, func(challenge string) bool {
+		calculated := s.responseFor(rule.Challenge.Algorithm, challenge, nonce)
+		return subtle.ConstantTimeCompare([]byte(response), []byte(calculated)) == 1
+	})
+	if !ok {
 		s.ClearCookie(w)
-		lg.Debug("hash does not match", "got", response, "want", calculated)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("invalid response", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusForbidden)).ServeHTTP(w, r)
+		lg.Debug("hash does not match", "got", response)
+		s.respondWithError(w, r, ReasonInvalidResponse, localization.ForRequest(r).T("invalid_response"), http.StatusForbidden)
 		failedValidations.Inc()
+		s.recordChallengeFailure(r, rule)
 		return
 	}
 
-	// compare the leading zeroes
-	if !strings.HasPrefix(response, strings.Repeat("0", rule.Challenge.Difficulty)) {
+	if rule.Challenge.Algorithm == config.AlgorithmNoJS {
+		// clients without JavaScript wait instead of finding leading zeroes
+		if wait := noJSWaitLeft(nonce, issuedDifficulty); wait > 0 {
+			lg.Debug("client came back too early", "wait", wait)
+			s.respondTooEarly(w, r, wait)
+			return
+		}
+	} else {
+		// compare the leading zeroes
+		rules := *rule.Challenge
+		rules.Difficulty = issuedDifficulty
+		difficulty := effectiveDifficulty(&rules, hashRate)
+		if !strings.HasPrefix(response, strings.Repeat("0", difficulty)) {
+			s.ClearCookie(w)
+			lg.Debug("difficulty check failed", "response", response, "difficulty", difficulty, "hashRate", hashRate)
+			s.respondWithError(w, r, ReasonInvalidResponse, localization.ForRequest(r).T("invalid_response"), http.StatusForbidden)
+			failedValidations.Inc()
+			s.recordChallengeFailure(r, rule)
+			return
+		}
+	}
+
+	fresh, err := s.redeem(r.Context(), challenge, nonce)
+	switch {
+	case err != nil:
+		storeErrors.WithLabelValues("replay").Inc()
+		lg.Error("can't check whether the solution was used before", "err", err, "fail_closed", s.opts.StoreFailClosed)
+		if s.opts.StoreFailClosed {
+			s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("internal_error"), http.StatusInternalServerError)
+			return
+		}
+	case !fresh:
 		s.ClearCookie(w)
-		lg.Debug("difficulty check failed", "response", response, "difficulty", rule.Challenge.Difficulty)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("invalid response", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusForbidden)).ServeHTTP(w, r)
-		failedValidations.Inc()
+		lg.Debug("solution was used before", "challenge", challenge, "nonce", nonce)
+		s.respondWithError(w, r, ReasonReplayedSolution, localization.ForRequest(r).T("invalid_response"), http.StatusForbidden)
 		return
 	}
 
 	// generate JWT cookie
-	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+	token, err := s.setTokenCookie(w, jwt.MapClaims{
 		"challenge": challenge,
 		"nonce":     nonce,
 		"response":  response,
-		"iat":       time.Now().Unix(),
-		"nbf":       time.Now().Add(-1 * time.Minute).Unix(),
-		"exp":       time.Now().Add(24 * 7 * time.Hour).Unix(),
+		"ip":        r.Header.Get("X-Real-Ip"),
 	})
-	tokenString, err := token.SignedString(s.priv)
 	if err != nil {
 		lg.Error("failed to sign JWT", "err", err)
 		s.ClearCookie(w)
-		templ.Handler(web.Base("Oh noes!", web.ErrorPage("failed to sign JWT", s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+		s.respondWithError(w, r, ReasonInternalError, "failed to sign JWT", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:        anubis.CookieName,
-		Value:       tokenString,
-		Expires:     time.Now().Add(24 * 7 * time.Hour),
-		SameSite:    http.SameSiteLaxMode,
-		Domain:      s.opts.CookieDomain,
-		Partitioned: s.opts.CookiePartitioned,
-		Path:        "/",
-	})
-
 	challengesValidated.Inc()
+	s.countChallenge(rule, "passed")
+	solvedDifficulty.WithLabelValues(rule.Name).Observe(float64(reportedDifficulty(rule, issuedDifficulty)))
+	entry.SetChallenge("passed")
+	s.recordReputation(r, "challenge_passed", reputationChallengePassed)
+	if s.wantsJSON(r) {
+		lg.Debug("challenge passed, sending token")
+		respondPassed(w, token)
+		return
+	}
+
 	lg.Debug("challenge passed, redirecting to app")
 	http.Redirect(w, r, redir, http.StatusFound)
 }
 
 func (s *Server) TestError(w http.ResponseWriter, r *http.Request) {
 	err := r.FormValue("err")
-	templ.Handler(web.Base("Oh noes!", web.ErrorPage(err, s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
+	templ.Handler(web.Base(localization.ForRequest(r).T("oh_noes"), web.ErrorPage(err, s.opts.WebmasterEmail)), templ.WithStatus(http.StatusInternalServerError)).ServeHTTP(w, r)
 }
 
 func cr(name string, rule config.Rule) policy.CheckResult {
@@ -534,8 +1095,37 @@ func cr(name string, rule config.Rule) policy.CheckResult {
 	}
 }
 
+// Check evaluates the bot rules of the policy against r the same way Anubis
+// does for live traffic, without IP feed or DNSBL lookups and challenge
+// validation. It is meant for tooling that tests policies against requests.
+// Rules that match on reverse DNS or call a decision API still make their
+// lookups and requests.
+func (s *Server) Check(r *http.Request) (policy.CheckResult, *policy.Bot, error) {
+	return s.evaluate(r, nil)
+}
+
 // Check evaluates the list of rules, and returns the result
 func (s *Server) check(r *http.Request) (policy.CheckResult, *policy.Bot, error) {
+	cr, rule, _, err := s.checkWithFeeds(r)
+	return cr, rule, err
+}
+
+// checkWithFeeds is check, but also returns the IP feed that changed the
+// result, if any.
+func (s *Server) checkWithFeeds(r *http.Request) (policy.CheckResult, *policy.Bot, *feedHit, error) {
+	cr, rule, err := s.evaluate(r, nil)
+	if err != nil {
+		return cr, rule, nil, err
+	}
+
+	cr, rule, hit := s.applyIPFeeds(r, cr, rule)
+	return cr, rule, hit, nil
+}
+
+// evaluate checks r against the bot rules in order. If trace is set, it is
+// called for every rule that was evaluated with whether it matched and the
+// action it picked, which is empty if a decision API had no opinion.
+func (s *Server) evaluate(r *http.Request, trace func(b policy.Bot, matched bool, action config.Rule)) (policy.CheckResult, *policy.Bot, error) {
 	host := r.Header.Get("X-Real-Ip")
 	if host == "" {
 		return decaymap.Zilch[policy.CheckResult](), nil, fmt.Errorf("[misconfiguration] X-Real-Ip header is not set")
@@ -546,27 +1136,168 @@ func (s *Server) check(r *http.Request) (policy.CheckResult, *policy.Bot, error)
 		return decaymap.Zilch[policy.CheckResult](), nil, fmt.Errorf("[misconfiguration] %q is not an IP address", host)
 	}
 
-	for _, b := range s.policy.Bots {
+	pol := s.policy.Load()
+	ctx := policy.WithRegexSet(r.Context(), pol.Regexes)
+	if pol.Reputation {
+		ctx = policy.WithReputation(ctx, s.reputationOf(r))
+	}
+	r = r.WithContext(ctx)
+
+	if cp := pol.CriticalPaths; cp != nil {
+		match, err := cp.Rules.Check(r)
+		if err != nil {
+			return decaymap.Zilch[policy.CheckResult](), nil, fmt.Errorf("can't check critical paths: %w", err)
+		}
+		if match {
+			if trace != nil {
+				trace(*cp, true, cp.Action)
+			}
+			return cr(cp.Name+"/allow", cp.Action), cp, nil
+		}
+	}
+
+	bots, difficulty := pol.Bots, pol.DefaultDifficulty
+	if route := pol.Route(r); route != nil {
+		bots, difficulty = route.Bots, route.DefaultDifficulty
+	}
+
+	for _, b := range bots {
 		match, err := b.Rules.Check(r)
 		if err != nil {
 			return decaymap.Zilch[policy.CheckResult](), nil, fmt.Errorf("can't run check %s: %w", b.Name, err)
 		}
 
-		if match {
-			return cr("bot/"+b.Name, b.Action), &b, nil
+		if !match {
+			if trace != nil {
+				trace(b, false, config.RuleUnknown)
+			}
+			continue
+		}
+
+		if b.Decision != nil {
+			action, err := b.Decision.Decide(r.Context(), r)
+			if err != nil {
+				s.requestLogger(r).Error("can't get decision from decision API", "rule", b.Name, "fallback", action, "err", err)
+			}
+			b.Action = action
+		}
+
+		if trace != nil {
+			trace(b, true, b.Action)
 		}
+
+		if b.Action == config.RuleUnknown {
+			continue
+		}
+
+		return cr("bot/"+b.Name, b.Action), &b, nil
+	}
+
+	if s.emergency.Load() {
+		return cr("emergency/challenge", config.RuleChallenge), &policy.Bot{
+			Name: "emergency",
+			Challenge: &config.ChallengeRules{
+				Difficulty: difficulty,
+				ReportAs:   difficulty,
+				Algorithm:  config.AlgorithmFast,
+			},
+		}, nil
 	}
 
 	return cr("default/allow", config.RuleAllow), &policy.Bot{
 		Challenge: &config.ChallengeRules{
-			Difficulty: s.policy.DefaultDifficulty,
-			ReportAs:   s.policy.DefaultDifficulty,
+			Difficulty: difficulty,
+			ReportAs:   difficulty,
 			Algorithm:  config.AlgorithmFast,
 		},
 	}, nil
 }
 
+// upstreamKey is the context key of the upstream picked by the bot rule a
+// request matched.
+type upstreamKey struct{}
+
+// withUpstream returns a copy of r that nextFor sends to target.
+func withUpstream(r *http.Request, target string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), upstreamKey{}, target))
+}
+
+// upstreamTarget returns the target that r is sent to if it passes Anubis:
+// the target of the bot rule r matched, the target of the route matching r,
+// or an empty string for the default target.
+func (s *Server) upstreamTarget(r *http.Request) string {
+	target, _ := r.Context().Value(upstreamKey{}).(string)
+	if target == "" {
+		if route := s.policy.Load().Route(r); route != nil {
+			target = route.Target
+		}
+	}
+
+	return target
+}
+
+// nextFor returns the handler that requests passing Anubis are sent to, as
+// picked by upstreamTarget.
+func (s *Server) nextFor(r *http.Request) http.Handler {
+	target := s.upstreamTarget(r)
+	if target == "" {
+		return s.next
+	}
+
+	proxies := s.proxies.Load()
+	if h, ok := proxies.Load(target); ok {
+		return h.(http.Handler)
+	}
+
+	h, err := NewReverseProxyWithOptions(target, s.opts.Proxy)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.requestLogger(r).Error("can't proxy to target", "target", target, "err", err)
+			s.respondWithError(w, r, ReasonMisconfiguration, localization.ForRequest(r).T("misconfigured", "nextFor"), http.StatusInternalServerError)
+		})
+	}
+	s.handleUpstreamErrors(h)
+
+	stored, _ := proxies.LoadOrStore(target, h)
+	return stored.(http.Handler)
+}
+
+// proxiesFor returns a proxy cache for pol that keeps the cached proxies to
+// targets pol still uses.
+func (s *Server) proxiesFor(pol *policy.ParsedConfig) *sync.Map {
+	result := new(sync.Map)
+
+	old := s.proxies.Load()
+	if old == nil {
+		return result
+	}
+
+	targets := map[string]bool{}
+	for _, route := range pol.Routes {
+		targets[route.Target] = true
+	}
+	for _, b := range pol.AllBots() {
+		targets[b.Target] = true
+	}
+
+	old.Range(func(target, h any) bool {
+		if targets[target.(string)] {
+			result.Store(target, h)
+		}
+		return true
+	})
+
+	return result
+}
+
 func (s *Server) CleanupDecayMap() {
-	s.DNSBLCache.Cleanup()
+	if mem, ok := s.opts.Store.(*store.Memory); ok {
+		mem.Cleanup()
+	}
 	s.OGTags.Cleanup()
+	s.policy.Load().Cleanup()
+	s.challengeVelocity.Cleanup()
+	s.requestVelocity.Cleanup()
+	s.challengeFailures.Cleanup()
+	s.denied.Cleanup()
 }
@@ -1,16 +1,28 @@
 package lib
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 
 	"github.com/vale981/anubis"
 	"github.com/vale981/anubis/data"
 	"github.com/vale981/anubis/internal"
 	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/web"
 )
 
 func loadPolicies(t *testing.T, fname string) *policy.ParsedConfig {
@@ -209,6 +221,121 @@ func TestCookieSettings(t *testing.T) {
 	}
 }
 
+func TestCookieRenewal(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "upstream")
+		}),
+		Policy: loadPolicies(t, ""),
+
+		CookieRenewal: 24 * time.Hour,
+	})
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		return req
+	}
+
+	_, rule, err := srv.check(newRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := srv.challengeFor(newRequest(), rule.Challenge.Difficulty)
+	response := srv.responseFor(rule.Challenge.Algorithm, challenge, 0)
+
+	for _, tt := range []struct {
+		name      string
+		claims    jwt.MapClaims
+		expiresIn time.Duration
+		renew     bool
+	}{
+		{
+			name:      "fresh",
+			claims:    jwt.MapClaims{"challenge": challenge, "nonce": 0, "response": response},
+			expiresIn: 6 * 24 * time.Hour,
+			renew:     false,
+		},
+		{
+			name:      "about_to_expire",
+			claims:    jwt.MapClaims{"challenge": challenge, "nonce": 0, "response": response},
+			expiresIn: time.Hour,
+			renew:     true,
+		},
+		{
+			name:      "wrong_response",
+			claims:    jwt.MapClaims{"challenge": challenge, "nonce": 0, "response": "deadbeef"},
+			expiresIn: time.Hour,
+			renew:     false,
+		},
+		{
+			name:      "other_challenge",
+			claims:    jwt.MapClaims{"challenge": "deadbeef", "nonce": 0, "response": response},
+			expiresIn: time.Hour,
+			renew:     false,
+		},
+		{
+			name:      "no_challenge",
+			claims:    jwt.MapClaims{"rule": "generic-browser"},
+			expiresIn: time.Hour,
+			renew:     false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["ip"] = "198.51.100.1"
+			tt.claims["exp"] = time.Now().Add(tt.expiresIn).Unix()
+			token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, tt.claims).SignedString(srv.signingKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := newRequest()
+			req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: token})
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			var renewed *http.Cookie
+			for _, ckie := range rec.Result().Cookies() {
+				if ckie.Name == anubis.CookieName && ckie.Value != "" {
+					renewed = ckie
+				}
+			}
+
+			if !tt.renew {
+				if renewed != nil {
+					t.Errorf("wanted no new cookie, got: %v", renewed)
+				}
+				return
+			}
+
+			if renewed == nil {
+				t.Fatalf("wanted a renewed %s cookie, got: %v", anubis.CookieName, rec.Result().Cookies())
+			}
+			if rec.Body.String() != "upstream" {
+				t.Errorf("wanted the request to reach the upstream, got: %q", rec.Body.String())
+			}
+
+			parsed, err := jwt.Parse(renewed.Value, func(token *jwt.Token) (interface{}, error) {
+				return srv.keys.Load().pub, nil
+			}, jwt.WithExpirationRequired())
+			if err != nil {
+				t.Fatalf("renewed cookie is not valid: %v", err)
+			}
+
+			claims := parsed.Claims.(jwt.MapClaims)
+			if claims["response"] != response {
+				t.Errorf("renewed cookie lost its claims, got: %v", claims)
+			}
+
+			exp, err := claims.GetExpirationTime()
+			if err != nil || time.Until(exp.Time) < 6*24*time.Hour {
+				t.Errorf("renewed cookie should be valid for a week, expires at: %v", exp)
+			}
+		})
+	}
+}
+
 func TestCheckDefaultDifficultyMatchesPolicy(t *testing.T) {
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "OK")
@@ -252,3 +379,1057 @@ func TestCheckDefaultDifficultyMatchesPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIPathPrefixes(t *testing.T) {
+	pol := loadPolicies(t, "")
+	pol.APIPathPrefixes = []string{"/api/"}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/api/v1/users", wantStatus: http.StatusUnauthorized},
+		{path: "/", wantStatus: http.StatusOK},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("wanted status %d, got: %d", tt.wantStatus, resp.StatusCode)
+			}
+
+			wwwAuth := resp.Header.Get("WWW-Authenticate")
+			if tt.wantStatus == http.StatusUnauthorized && !strings.HasPrefix(wwwAuth, "Anubis ") {
+				t.Errorf("wanted WWW-Authenticate header to start with Anubis, got: %q", wwwAuth)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "true")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name       string
+		cors       *config.CORSConfig
+		origin     string
+		wantOrigin string
+		upstream   bool
+	}{
+		{
+			name:     "passthrough",
+			origin:   "https://app.example.com",
+			upstream: true,
+		},
+		{
+			name:       "allowed_origin",
+			cors:       &config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			origin:     "https://app.example.com",
+			wantOrigin: "https://app.example.com",
+		},
+		{
+			name:   "disallowed_origin",
+			cors:   &config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			origin: "https://evil.example.com",
+		},
+		{
+			name:       "wildcard",
+			cors:       &config.CORSConfig{AllowedOrigins: []string{"*"}},
+			origin:     "https://evil.example.com",
+			wantOrigin: "*",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pol := loadPolicies(t, "")
+			pol.CORS = tt.cors
+
+			srv := spawnAnubis(t, Options{
+				Next:   h,
+				Policy: pol,
+			})
+
+			ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodOptions, ts.URL+"/api/thing", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", "POST")
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("wanted status %d, got: %d", http.StatusNoContent, resp.StatusCode)
+			}
+
+			if got := resp.Header.Get("X-Upstream") == "true"; got != tt.upstream {
+				t.Errorf("wanted request to reach upstream: %v, got: %v", tt.upstream, got)
+			}
+
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("wanted Access-Control-Allow-Origin %q, got: %q", tt.wantOrigin, got)
+			}
+		})
+	}
+}
+
+func TestReasonCodes(t *testing.T) {
+	pol := loadPolicies(t, "")
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	t.Run("deny", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("CF-Worker", "example.com")
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if got := resp.Header.Get(ReasonHeader); got != string(ReasonRuleDenied) {
+			t.Errorf("wanted reason %s, got: %q", ReasonRuleDenied, got)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/.within.website/x/cmd/anubis/api/reason-codes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var codes []struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&codes); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(codes) != len(ReasonCodes) {
+			t.Errorf("wanted %d reason codes, got: %d", len(ReasonCodes), len(codes))
+		}
+	})
+}
+
+func TestSetPolicy(t *testing.T) {
+	pol := loadPolicies(t, "")
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("X-Real-Ip", "127.0.0.1")
+
+	cr, _, err := srv.Check(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cr.Rule != config.RuleChallenge {
+		t.Fatalf("wanted %s before reload, got: %s", config.RuleChallenge, cr.Rule)
+	}
+
+	reloaded := loadPolicies(t, "")
+	reloaded.Bots = nil
+	srv.SetPolicy(reloaded)
+
+	cr, _, err = srv.Check(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cr.Rule != config.RuleAllow {
+		t.Errorf("wanted %s after reload, got: %s", config.RuleAllow, cr.Rule)
+	}
+
+	if srv.Policy() != reloaded {
+		t.Error("Policy() did not return the new policy")
+	}
+}
+
+func TestStreamingRequestsGetAPIChallenge(t *testing.T) {
+	pol := loadPolicies(t, "")
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{
+			name:       "websocket",
+			headers:    map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "event-stream",
+			headers:    map[string]string{"Accept": "text/event-stream"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "html",
+			headers:    map[string]string{"Accept": "text/html,application/xhtml+xml"},
+			wantStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/events", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("wanted status %d, got: %d", tt.wantStatus, resp.StatusCode)
+			}
+
+			if tt.wantStatus == http.StatusUnauthorized && resp.Header.Get(ReasonHeader) != string(ReasonChallengeRequired) {
+				t.Errorf("wanted reason %s, got: %q", ReasonChallengeRequired, resp.Header.Get(ReasonHeader))
+			}
+		})
+	}
+}
+
+func TestJSONChallengeFlow(t *testing.T) {
+	pol := loadPolicies(t, "")
+	pol.DefaultDifficulty = 1
+	pol.APIClientHeader = "X-Api-Client"
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "app")
+		}),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "accept", headers: map[string]string{"Accept": "application/json"}},
+		{name: "api client header", headers: map[string]string{"X-Api-Client": "1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			do := func(method, path string, body io.Reader, token string) *http.Response {
+				t.Helper()
+
+				req, err := http.NewRequest(method, ts.URL+path, body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Each client gets its own challenge, or the second
+				// solution would be a replay of the first.
+				req.Header.Set("User-Agent", "Mozilla/5.0 "+tt.name)
+				for k, v := range tt.headers {
+					req.Header.Set(k, v)
+				}
+				if body != nil {
+					req.Header.Set("Content-Type", "application/json")
+				}
+				if token != "" {
+					req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: token})
+				}
+
+				resp, err := ts.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { resp.Body.Close() })
+				return resp
+			}
+
+			resp := do(http.MethodGet, "/page", nil, "")
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("wanted status 401, got: %d", resp.StatusCode)
+			}
+
+			var descriptor struct {
+				Code      ReasonCode `json:"code"`
+				Challenge struct {
+					Challenge string                 `json:"challenge"`
+					Rules     *config.ChallengeRules `json:"rules"`
+				} `json:"challenge"`
+				Pass string `json:"pass"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&descriptor); err != nil {
+				t.Fatalf("wanted a JSON challenge, got: %v", err)
+			}
+			if descriptor.Code != ReasonChallengeRequired || descriptor.Challenge.Challenge == "" || descriptor.Challenge.Rules == nil || descriptor.Pass == "" {
+				t.Fatalf("wanted a challenge descriptor, got: %+v", descriptor)
+			}
+
+			prefix := strings.Repeat("0", descriptor.Challenge.Rules.Difficulty)
+			nonce := 0
+			for !strings.HasPrefix(internal.SHA256sum(fmt.Sprintf("%s%d", descriptor.Challenge.Challenge, nonce)), prefix) {
+				nonce++
+			}
+
+			solution, err := json.Marshal(map[string]any{
+				"challenge":   descriptor.Challenge.Challenge,
+				"response":    internal.SHA256sum(fmt.Sprintf("%s%d", descriptor.Challenge.Challenge, nonce)),
+				"nonce":       nonce,
+				"elapsedTime": 420,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp = do(http.MethodPost, descriptor.Pass, bytes.NewReader(solution), "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("wanted status 200, got: %d", resp.StatusCode)
+			}
+
+			var passed struct {
+				Cookie string `json:"cookie"`
+				Token  string `json:"token"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&passed); err != nil {
+				t.Fatalf("wanted JSON, got: %v", err)
+			}
+			if passed.Cookie != anubis.CookieName || passed.Token == "" {
+				t.Fatalf("wanted the token, got: %+v", passed)
+			}
+
+			resp = do(http.MethodGet, "/page", nil, passed.Token)
+			if body, _ := io.ReadAll(resp.Body); string(body) != "app" {
+				t.Errorf("wanted the token to let the client through, got status %d: %q", resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	pol := loadPolicies(t, "")
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/.within.website/x/cmd/anubis/api/pass-challenge", "application/json", strings.NewReader(`{"nonce": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Code ReasonCode `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("wanted a JSON error, got: %v", err)
+	}
+	if body.Code != ReasonMissingElapsedTime {
+		t.Errorf("wanted reason %s, got: %s", ReasonMissingElapsedTime, body.Code)
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	for _, tt := range []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/json", want: true},
+		{accept: "application/json, text/plain, */*", want: true},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: false},
+		{accept: "text/html;q=0.5, application/json", want: true},
+		{accept: "application/json;q=0.5, text/html", want: false},
+	} {
+		if got := prefersJSON(tt.accept); got != tt.want {
+			t.Errorf("prefersJSON(%q) = %v, wanted %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestGRPCChallenge(t *testing.T) {
+	pol := loadPolicies(t, "")
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/helloworld.Greeter/SayHello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wanted status %d, got: %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if got := resp.Header.Get("Grpc-Status"); got != "16" {
+		t.Errorf("wanted grpc-status 16 (UNAUTHENTICATED), got: %q", got)
+	}
+
+	if got := resp.Header.Get("Content-Type"); got != "application/grpc-web+proto" {
+		t.Errorf("wanted the request content type to be echoed, got: %q", got)
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Anubis ") {
+		t.Errorf("wanted WWW-Authenticate header to start with Anubis, got: %q", resp.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "routed")
+	}))
+	defer upstream.Close()
+
+	pol, err := policy.ParseConfig(strings.NewReader(fmt.Sprintf(`
+bots:
+  - name: generic-browser
+    user_agent_regex: Mozilla
+    action: CHALLENGE
+
+routes:
+  - host: git.example.com
+    target: %s
+    difficulty: 6
+    bots:
+      - name: git-clients
+        user_agent_regex: ^git/
+        action: ALLOW
+`, upstream.URL)), "routes.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "default")
+		}),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name      string
+		host      string
+		userAgent string
+		wantBody  string
+	}{
+		{name: "route", host: "git.example.com", userAgent: "git/2.49.0", wantBody: "routed"},
+		{name: "other-host", host: "www.example.com", userAgent: "git/2.49.0", wantBody: "default"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+			req.Header.Set("User-Agent", tt.userAgent)
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tt.wantBody {
+				t.Errorf("wanted body %q, got: %q", tt.wantBody, body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "git.example.com"
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("X-Real-Ip", "127.0.0.1")
+
+	_, rule, err := srv.Check(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rule.Challenge.Difficulty != 6 {
+		t.Errorf("wanted the route difficulty 6 for global rules, got: %d", rule.Challenge.Difficulty)
+	}
+}
+
+func TestProxiesOnReload(t *testing.T) {
+	routed := func(target string) *policy.ParsedConfig {
+		pol, err := policy.ParseConfig(strings.NewReader(fmt.Sprintf(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: ALLOW
+
+routes:
+  - host: git.example.com
+    target: %s
+`, target)), "routes.yaml", anubis.DefaultDifficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pol
+	}
+
+	srv := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: routed("http://git-1.internal:3000")})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "git.example.com"
+	first := srv.nextFor(req)
+
+	cached := func(target string) bool {
+		_, ok := srv.proxies.Load().Load(target)
+		return ok
+	}
+
+	srv.SetPolicy(routed("http://git-1.internal:3000"))
+	if !cached("http://git-1.internal:3000") {
+		t.Fatal("proxy to a target the new policy still uses was dropped")
+	}
+	if srv.nextFor(req) != first {
+		t.Error("proxy to a target the new policy still uses was created again")
+	}
+
+	srv.SetPolicy(routed("http://git-2.internal:3000"))
+	if cached("http://git-1.internal:3000") {
+		t.Error("proxy to a target the new policy doesn't use is still cached")
+	}
+}
+
+func TestTheme(t *testing.T) {
+	dir := t.TempDir()
+	for fname, content := range map[string]string{
+		"header.html":            `<nav id="custom-header">Example</nav>`,
+		"footer.html":            `<footer id="custom-footer">Example</footer>`,
+		"static/custom.css":      `body { color: rebeccapurple; }`,
+		"static/img/reject.webp": "custom reject image",
+	} {
+		fname = filepath.Join(dir, fname)
+		if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	theme, err := web.LoadTheme(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+		Theme:  theme,
+	})
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	get := func(t *testing.T, path string) string {
+		t.Helper()
+
+		resp, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(body)
+	}
+
+	t.Run("error_page", func(t *testing.T) {
+		body := get(t, anubis.StaticPath+"api/test-error?err=test")
+
+		for _, want := range []string{
+			`<nav id="custom-header">Example</nav>`,
+			`<footer id="custom-footer">Example</footer>`,
+			anubis.StaticPath + "static/custom.css",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("page does not contain %q", want)
+			}
+		}
+
+		if strings.Contains(body, "Mascot design by") {
+			t.Error("page still contains the default footer")
+		}
+	})
+
+	t.Run("overridden_static_file", func(t *testing.T) {
+		if body := get(t, anubis.StaticPath+"static/img/reject.webp"); body != "custom reject image" {
+			t.Errorf("wanted the custom reject image, got %d bytes", len(body))
+		}
+	})
+
+	t.Run("embedded_static_file", func(t *testing.T) {
+		if body := get(t, anubis.StaticPath+"static/img/happy.webp"); len(body) == 0 {
+			t.Error("embedded happy image was not served")
+		}
+	})
+}
+
+func TestLocalizedPages(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+	})
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name           string
+		acceptLanguage string
+		want           []string
+	}{
+		{
+			name: "english",
+			want: []string{`<html lang="en">`, "Making sure you&#39;re not a bot!", "Calculating..."},
+		},
+		{
+			name:           "german",
+			acceptLanguage: "de-AT,de;q=0.9,en;q=0.5",
+			want:           []string{`<html lang="de">`, "Wir stellen sicher, dass Sie kein Bot sind!", "Berechne..."},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			req.Header.Set("X-Real-Ip", "127.0.0.1")
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("page does not contain %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestBranding(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, "./policy/config/testdata/good/branding.yaml"),
+	})
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("X-Real-Ip", "127.0.0.1")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"<title>Checking your browser - Example Corp</title>",
+		`<h1 id="title" class=".centered-div">Checking your browser</h1>`,
+		`src="https://example.com/logo.svg" alt="Example Corp"`,
+		`src="/images/thinking.png"`,
+		`"happy":"/images/done.png"`,
+		"--light-background:#ffffff;",
+		"--progress-bar-outline:#0055aa solid 4px;",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+}
+
+func TestStaticFS(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+		Static: fstest.MapFS{
+			"static/js/main.mjs": {Data: []byte("// patched")},
+		},
+	})
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + anubis.StaticPath + "static/js/main.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "// patched" {
+		t.Errorf("wanted the file from the static directory, got %d bytes", len(body))
+	}
+
+	if got := resp.Header.Get("Cache-Control"); got != "public, no-cache" {
+		t.Errorf("wanted files from the static directory to be revalidated, got Cache-Control: %q", got)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		globalDry bool
+		userAgent string
+		wantBody  string
+	}{
+		{name: "rule_dry_run", userAgent: "DryBot/1.0", wantBody: "upstream"},
+		{name: "rule_enforced", userAgent: "EvilBot/1.0", wantBody: "denied"},
+		{name: "global_dry_run", globalDry: true, userAgent: "EvilBot/1.0", wantBody: "upstream"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pol := loadPolicies(t, "./policy/config/testdata/good/dry_run.yaml")
+			pol.DryRun = tt.globalDry
+
+			srv := spawnAnubis(t, Options{
+				Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(w, "upstream")
+				}),
+				Policy: pol,
+			})
+
+			ts := httptest.NewServer(internal.RemoteXRealIP(true, "tcp", srv))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", tt.userAgent)
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := "denied"
+			if string(body) == "upstream" {
+				got = "upstream"
+			}
+
+			if got != tt.wantBody {
+				t.Errorf("request was %s, wanted %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, "./policy/config/testdata/good/dry_run.yaml"),
+	})
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "EvilBot/1.0")
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+
+	ex, err := srv.Explain(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ExplainStep{
+		{Rule: "new-scraper-rule", Matched: false},
+		{Rule: "known-scraper", Matched: true, Action: config.RuleDeny},
+	}
+	if !slices.Equal(ex.Steps, want) {
+		t.Errorf("steps: %+v, wanted: %+v", ex.Steps, want)
+	}
+
+	if ex.Result.Name != "bot/known-scraper" || ex.Result.Rule != config.RuleDeny {
+		t.Errorf("result: %+v, wanted bot/known-scraper DENY", ex.Result)
+	}
+}
+
+func TestCriticalPaths(t *testing.T) {
+	for _, tt := range []struct {
+		name, policy string
+		path         string
+		wantRule     string
+		wantAction   config.Rule
+	}{
+		{
+			name:       "acme challenge",
+			path:       "/.well-known/acme-challenge/token",
+			wantRule:   "critical-paths/allow",
+			wantAction: config.RuleAllow,
+		},
+		{
+			name:       "other path",
+			path:       "/",
+			wantRule:   "bot/everything",
+			wantAction: config.RuleDeny,
+		},
+		{
+			name:       "extended",
+			policy:     "critical_paths:\n  paths: [/ads.txt]\n",
+			path:       "/ads.txt",
+			wantRule:   "critical-paths/allow",
+			wantAction: config.RuleAllow,
+		},
+		{
+			name:       "disabled",
+			policy:     "critical_paths:\n  disable: true\n",
+			path:       "/robots.txt",
+			wantRule:   "bot/everything",
+			wantAction: config.RuleDeny,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pol, err := policy.ParseConfig(strings.NewReader(tt.policy+`
+bots:
+  - name: everything
+    path_regex: .*
+    action: DENY
+`), "critical-paths.yaml", anubis.DefaultDifficulty)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			cr, _, err := srv.check(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cr.Name != tt.wantRule || cr.Rule != tt.wantAction {
+				t.Errorf("wanted %s with %s, got: %s with %s", tt.wantRule, tt.wantAction, cr.Name, cr.Rule)
+			}
+		})
+	}
+}
+
+func TestRobotsTXT(t *testing.T) {
+	generated, err := policy.ParseConfig(strings.NewReader(`
+robots_txt:
+  generate: true
+bots:
+  - name: ai
+    user_agent_regex: GPTBot
+    action: DENY
+`), "robots.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		pol            *policy.ParsedConfig
+		serveRobotsTXT bool
+		want           string
+	}{
+		{name: "generated", pol: generated, serveRobotsTXT: true, want: "User-agent: GPTBot\nDisallow: /\n"},
+		{name: "static fallback", pol: loadPolicies(t, ""), serveRobotsTXT: true, want: "User-agent: *\nDisallow: /"},
+		{name: "target", pol: loadPolicies(t, ""), want: "robots.txt of the target"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := spawnAnubis(t, Options{
+				Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(w, "robots.txt of the target")
+				}),
+				Policy:         tt.pol,
+				ServeRobotsTXT: tt.serveRobotsTXT,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("wanted robots.txt to contain %q, got: %q", tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestBotTarget(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "mirror")
+	}))
+	t.Cleanup(mirror.Close)
+
+	pol, err := policy.ParseConfig(strings.NewReader(fmt.Sprintf(`
+bots:
+  - name: crawlers-to-mirror
+    user_agent_regex: Crawler
+    action: ALLOW
+    target: %s
+  - name: everyone-else
+    path_regex: .*
+    action: ALLOW
+`, mirror.URL)), "target.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "app")
+		}),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	for _, tt := range []struct {
+		userAgent string
+		want      string
+	}{
+		{userAgent: "Crawler/1.0", want: "mirror"},
+		{userAgent: "Mozilla/5.0", want: "app"},
+	} {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/page", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", tt.userAgent)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tt.want {
+				t.Errorf("wanted the request to go to %s, got: %q", tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// isAPIPath returns true if the request path falls under one of the API path
+// prefixes configured in the policy file.
+func (s *Server) isAPIPath(r *http.Request) bool {
+	for _, prefix := range s.policy.Load().APIPathPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wantsJSON reports whether the client of r asked for JSON instead of HTML
+// pages: by preferring application/json in its Accept header, by sending
+// JSON, or by setting the API client header of the policy.
+func (s *Server) wantsJSON(r *http.Request) bool {
+	if h := s.policy.Load().APIClientHeader; h != "" && r.Header.Get(h) != "" {
+		return true
+	}
+
+	return hasJSONBody(r) || prefersJSON(r.Header.Get("Accept"))
+}
+
+// hasJSONBody reports whether the body of r is JSON.
+func hasJSONBody(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
+
+// prefersJSON reports whether the Accept header accept ranks
+// application/json above text/html.
+func prefersJSON(accept string) bool {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch mt {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+
+	return jsonQ > htmlQ
+}
+
+// apiChallenge is a challenge for clients that solve it without the HTML
+// interstitial.
+type apiChallenge struct {
+	Challenge string                 `json:"challenge"`
+	Rules     *config.ChallengeRules `json:"rules"`
+	Nonce     *int                   `json:"nonce,omitempty"`
+	Response  string                 `json:"response,omitempty"`
+}
+
+// issueAPIChallenge makes a challenge for r with the settings of rule.
+func (s *Server) issueAPIChallenge(r *http.Request, rule *policy.Bot) apiChallenge {
+	rules := s.issueChallengeRules(r, rule)
+	challenge := s.newChallenge(r, rules.Difficulty)
+
+	// Clients can't compute the response for the nojs algorithm, so they
+	// are handed it and only have to wait before passing the challenge.
+	var nonce *int
+	var response string
+	if rules.Algorithm == config.AlgorithmNoJS {
+		issued := int(time.Now().Unix())
+		nonce = &issued
+		response = s.responseFor(config.AlgorithmNoJS, challenge, issued)
+	}
+
+	challengesIssued.Inc()
+
+	return apiChallenge{
+		Challenge: challenge,
+		Rules:     rules,
+		Nonce:     nonce,
+		Response:  response,
+	}
+}
+
+// respondAPIChallenge tells API clients that they need to solve a challenge
+// with a 401 response instead of serving them the HTML interstitial. The
+// response has the challenge in it, so that clients don't have to ask for
+// one first.
+func (s *Server) respondAPIChallenge(w http.ResponseWriter, r *http.Request, rule *policy.Bot) {
+	passURL := anubis.StaticPath + "api/pass-challenge"
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+		`Anubis realm=%q, challenge=%q, pass=%q`,
+		r.Host,
+		anubis.StaticPath+"api/make-challenge",
+		passURL,
+	))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(ReasonHeader, string(ReasonChallengeRequired))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+
+	challenge := s.issueAPIChallenge(r, rule)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error     string       `json:"error"`
+		Code      ReasonCode   `json:"code"`
+		Challenge apiChallenge `json:"challenge"`
+		Pass      string       `json:"pass"`
+	}{
+		Error:     "challenge required, see the WWW-Authenticate header",
+		Code:      ReasonChallengeRequired,
+		Challenge: challenge,
+		Pass:      passURL,
+	})
+}
+
+// readJSONSolution lets clients post their solution to pass-challenge as a
+// JSON object instead of in the query string, by copying its fields into
+// the form of r.
+func readJSONSolution(w http.ResponseWriter, r *http.Request) error {
+	var body map[string]any
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	for key, value := range body {
+		switch value := value.(type) {
+		case string:
+			form.Set(key, value)
+		case json.Number:
+			form.Set(key, value.String())
+		}
+	}
+	r.Form = form
+
+	return nil
+}
+
+// respondPassed tells a client that sent its solution as JSON that it passed
+// the challenge. The token is in the cookie too, but clients without a
+// cookie jar can send it themselves.
+func respondPassed(w http.ResponseWriter, token string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(struct {
+		Cookie string `json:"cookie"`
+		Token  string `json:"token"`
+	}{
+		Cookie: anubis.CookieName,
+		Token:  token,
+	})
+}
@@ -0,0 +1,28 @@
+package lib
+
+import "net/http"
+
+// attackOffset returns how far the attack detector has moved the
+// difficulty of challenges.
+func (s *Server) attackOffset() int {
+	if d := s.policy.Load().AttackDetector; d != nil {
+		return d.Offset()
+	}
+
+	return 0
+}
+
+// recordIssued tells the attack detector about a challenge issued for r.
+func (s *Server) recordIssued(r *http.Request) {
+	if d := s.policy.Load().AttackDetector; d != nil {
+		d.Issued(velocityKey(r))
+	}
+}
+
+// recordSolution tells the attack detector about a solution that passed or
+// failed.
+func (s *Server) recordSolution(passed bool) {
+	if d := s.policy.Load().AttackDetector; d != nil {
+		d.Result(passed)
+	}
+}
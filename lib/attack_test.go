@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestAttackDetection(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+
+attack_detection:
+  min_difficulty: 2
+  max_difficulty: 6
+  interval: 1s
+  challenge_rate: 2
+`), "attack_detection.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	rule := &pol.Bots[0]
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	if got := srv.issueChallengeRules(req, rule).Difficulty; got != anubis.DefaultDifficulty {
+		t.Errorf("wanted difficulty %d before an attack, got: %d", anubis.DefaultDifficulty, got)
+	}
+
+	accepted := srv.acceptedRules(rule.Challenge)
+	if accepted.Difficulty != 2 || accepted.MaxDifficulty != 6 {
+		t.Errorf("wanted solutions accepted at difficulties 2 to 6, got: %+v", accepted)
+	}
+
+	for range 10 {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req.Clone(req.Context()))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("wanted a challenge, got status %d", rec.Code)
+		}
+	}
+
+	time.Sleep(time.Second)
+
+	state := pol.AttackDetector.State()
+	if !state.UnderAttack {
+		t.Errorf("wanted an attack to be detected, got: %+v", state)
+	}
+	if got := srv.issueChallengeRules(req, rule).Difficulty; got != anubis.DefaultDifficulty+1 {
+		t.Errorf("wanted difficulty %d during an attack, got: %d", anubis.DefaultDifficulty+1, got)
+	}
+}
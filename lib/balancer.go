@@ -0,0 +1,290 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// How an UpstreamPool spreads requests over its targets.
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceLeastConnections = "least-connections"
+)
+
+var (
+	ErrNoTargets      = errors.New("lib: an upstream pool needs at least one target")
+	ErrUnknownBalance = errors.New("lib: balance must be round-robin or least-connections")
+)
+
+var (
+	upstreamBackendUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "anubis_upstream_backend_up",
+		Help: "Whether requests are sent to each target of the pool, by target",
+	}, []string{"target"})
+
+	upstreamFailovers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anubis_upstream_failovers",
+		Help: "The total number of requests sent to another target of the pool after the first one failed",
+	})
+)
+
+// SplitTargets splits a comma-separated list of targets.
+func SplitTargets(targets string) []string {
+	var result []string
+	for _, target := range strings.Split(targets, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			result = append(result, target)
+		}
+	}
+
+	return result
+}
+
+// NewUpstream creates a reverse proxy to target, or an UpstreamPool if target
+// is a comma-separated list of targets. Only opts.Proxy applies to a single
+// target.
+func NewUpstream(target string, opts UpstreamPoolOptions) (http.Handler, error) {
+	targets := SplitTargets(target)
+	if len(targets) == 1 {
+		return NewReverseProxyWithOptions(targets[0], opts.Proxy)
+	}
+
+	return NewUpstreamPool(targets, opts)
+}
+
+// UpstreamPoolOptions sets how an UpstreamPool balances requests and when it
+// stops sending requests to a target.
+type UpstreamPoolOptions struct {
+	// Balance is BalanceRoundRobin or BalanceLeastConnections. Defaults to
+	// BalanceRoundRobin.
+	Balance string
+
+	// MaxFails is how many requests to a target must fail in a row before
+	// no more requests are sent to it for FailTimeout. Defaults to one.
+	MaxFails int
+
+	// FailTimeout defaults to ten seconds.
+	FailTimeout time.Duration
+
+	// Proxy tunes how the targets are talked to.
+	Proxy ProxyOptions
+}
+
+// UpstreamPool is a reverse proxy to several targets. Targets that can't be
+// reached are left out for a while, and requests that can safely be sent
+// again are sent to the next target when one fails.
+type UpstreamPool struct {
+	// ErrorHandler is called for requests that none of the targets
+	// answered. Defaults to responding with 502 Bad Gateway.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+
+	backends []*backend
+	opts     UpstreamPoolOptions
+	next     atomic.Uint64
+	now      func() time.Time
+}
+
+// backend is one target of an UpstreamPool.
+type backend struct {
+	target string
+	proxy  http.Handler
+	active atomic.Int64
+
+	lock      sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+// attemptKey is the context key of where the error of a request to one
+// target of a pool is put by its reverse proxy's error handler.
+type attemptKey struct{}
+
+// NewUpstreamPool creates an UpstreamPool for targets.
+func NewUpstreamPool(targets []string, opts UpstreamPoolOptions) (*UpstreamPool, error) {
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	}
+
+	switch opts.Balance {
+	case "":
+		opts.Balance = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLeastConnections:
+		// okay
+	default:
+		return nil, fmt.Errorf("%w, got: %q", ErrUnknownBalance, opts.Balance)
+	}
+	if opts.MaxFails < 1 {
+		opts.MaxFails = 1
+	}
+	if opts.FailTimeout <= 0 {
+		opts.FailTimeout = 10 * time.Second
+	}
+
+	p := &UpstreamPool{opts: opts, now: time.Now}
+	for _, target := range targets {
+		h, err := NewReverseProxyWithOptions(target, opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("can't make reverse proxy to %s: %w", target, err)
+		}
+
+		*errorHandlerOf(h) = func(w http.ResponseWriter, r *http.Request, err error) {
+			*r.Context().Value(attemptKey{}).(*error) = err
+		}
+
+		p.backends = append(p.backends, &backend{target: target, proxy: h})
+		upstreamBackendUp.WithLabelValues(target).Set(1)
+	}
+
+	return p, nil
+}
+
+func (p *UpstreamPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tried := make([]bool, len(p.backends))
+
+	var err error
+	for {
+		b := p.pick(tried)
+		if b == nil {
+			break
+		}
+
+		err = nil
+		ctx := context.WithValue(r.Context(), attemptKey{}, &err)
+		b.active.Add(1)
+		b.proxy.ServeHTTP(w, r.WithContext(ctx))
+		b.active.Add(-1)
+
+		if err == nil {
+			p.succeeded(b)
+			return
+		}
+
+		if errors.Is(err, context.Canceled) {
+			// The client went away, which says nothing about the
+			// target.
+			break
+		}
+
+		p.failed(b, err)
+		if !retryable(r) {
+			break
+		}
+		upstreamFailovers.Inc()
+	}
+
+	if p.ErrorHandler != nil {
+		p.ErrorHandler(w, r, err)
+		return
+	}
+
+	slog.Error("can't reach any upstream", "err", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// retryable reports whether r can be sent to another target after the
+// first one failed: it must not change anything and have no body, which
+// the first target may already have read.
+func retryable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return r.ContentLength == 0 && (r.Body == nil || r.Body == http.NoBody)
+	default:
+		return false
+	}
+}
+
+// pick returns the target to send a request to that wasn't tried yet, or
+// nil if all of them were. Targets that recently failed are only picked if
+// all others did too.
+func (p *UpstreamPool) pick(tried []bool) *backend {
+	now := p.now()
+
+	var up, down []*backend
+	for i, b := range p.backends {
+		if tried[i] {
+			continue
+		}
+		if b.up(now) {
+			up = append(up, b)
+		} else {
+			down = append(down, b)
+		}
+	}
+
+	candidates := up
+	if len(candidates) == 0 {
+		candidates = down
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := int(p.next.Add(1) % uint64(len(candidates)))
+	picked := candidates[start]
+	if p.opts.Balance == BalanceLeastConnections {
+		// Start at a different target every time, so that ties are
+		// broken round-robin.
+		for i := range candidates {
+			b := candidates[(start+i)%len(candidates)]
+			if b.active.Load() < picked.active.Load() {
+				picked = b
+			}
+		}
+	}
+
+	for i, b := range p.backends {
+		if b == picked {
+			tried[i] = true
+		}
+	}
+
+	return picked
+}
+
+// up reports whether requests are sent to b at now.
+func (b *backend) up(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return !now.Before(b.downUntil)
+}
+
+// succeeded records a request to b that got an answer.
+func (p *UpstreamPool) succeeded(b *backend) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.fails = 0
+	if !b.downUntil.IsZero() {
+		b.downUntil = time.Time{}
+		slog.Info("upstream is back in the pool", "target", b.target)
+		upstreamBackendUp.WithLabelValues(b.target).Set(1)
+	}
+}
+
+// failed records a request to b that didn't get an answer, and leaves b out
+// for a while after too many in a row.
+func (p *UpstreamPool) failed(b *backend, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.fails++
+	if b.fails < p.opts.MaxFails {
+		return
+	}
+
+	b.fails = 0
+	b.downUntil = p.now().Add(p.opts.FailTimeout)
+	slog.Warn("upstream failed, leaving it out of the pool", "target", b.target, "for", p.opts.FailTimeout, "err", err)
+	upstreamBackendUp.WithLabelValues(b.target).Set(0)
+}
@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingUpstream starts a target that counts the requests it answers.
+func countingUpstream(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+
+	var count atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, &count
+}
+
+// deadUpstream returns the URL of a target that nothing listens on.
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	return srv.URL
+}
+
+func poolDo(p *UpstreamPool, method string) int {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+	return rec.Code
+}
+
+func TestUpstreamPoolRoundRobin(t *testing.T) {
+	a, aCount := countingUpstream(t)
+	b, bCount := countingUpstream(t)
+
+	p, err := NewUpstreamPool([]string{a, b}, UpstreamPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 10 {
+		if code := poolDo(p, http.MethodGet); code != http.StatusOK {
+			t.Fatalf("wanted status 200, got: %d", code)
+		}
+	}
+
+	if aCount.Load() != 5 || bCount.Load() != 5 {
+		t.Errorf("wanted 5 requests to each target, got: %d and %d", aCount.Load(), bCount.Load())
+	}
+}
+
+func TestUpstreamPoolFailover(t *testing.T) {
+	live, count := countingUpstream(t)
+
+	p, err := NewUpstreamPool([]string{deadUpstream(t), live}, UpstreamPoolOptions{
+		FailTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	for range 4 {
+		if code := poolDo(p, http.MethodGet); code != http.StatusOK {
+			t.Fatalf("wanted status 200, got: %d", code)
+		}
+	}
+	if got := count.Load(); got != 4 {
+		t.Errorf("wanted the live target to answer all 4 requests, got: %d", got)
+	}
+
+	if p.backends[0].up(now) {
+		t.Error("wanted the dead target to be left out")
+	}
+	if !p.backends[0].up(now.Add(time.Minute)) {
+		t.Error("wanted the dead target to be tried again after the fail timeout")
+	}
+
+	// Requests with side effects aren't sent twice, so they fail when they
+	// happen to go to the dead target.
+	now = now.Add(time.Minute)
+	var failed int
+	for range 2 {
+		if poolDo(p, http.MethodPost) == http.StatusBadGateway {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("wanted one of two POST requests to fail, got: %d", failed)
+	}
+}
+
+func TestUpstreamPoolAllDown(t *testing.T) {
+	p, err := NewUpstreamPool([]string{deadUpstream(t), deadUpstream(t)}, UpstreamPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled error
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if code := poolDo(p, http.MethodGet); code != http.StatusServiceUnavailable {
+		t.Errorf("wanted the error handler's status 503, got: %d", code)
+	}
+	if handled == nil {
+		t.Error("wanted the error handler to get the error")
+	}
+
+	// Even with all targets left out, they are still tried.
+	handled = nil
+	poolDo(p, http.MethodGet)
+	if handled == nil {
+		t.Error("wanted the error handler to get the error")
+	}
+}
+
+func TestUpstreamPoolLeastConnections(t *testing.T) {
+	busy, busyCount := countingUpstream(t)
+	idle, idleCount := countingUpstream(t)
+
+	p, err := NewUpstreamPool([]string{busy, idle}, UpstreamPoolOptions{
+		Balance: BalanceLeastConnections,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.backends[0].active.Store(1)
+	for range 4 {
+		poolDo(p, http.MethodGet)
+	}
+	if busyCount.Load() != 0 || idleCount.Load() != 4 {
+		t.Errorf("wanted all 4 requests to go to the idle target, got: %d and %d", busyCount.Load(), idleCount.Load())
+	}
+
+	// Ties are broken round-robin.
+	p.backends[0].active.Store(0)
+	for range 4 {
+		poolDo(p, http.MethodGet)
+	}
+	if busyCount.Load() != 2 || idleCount.Load() != 6 {
+		t.Errorf("wanted 2 more requests to each target, got: %d and %d", busyCount.Load(), idleCount.Load())
+	}
+}
+
+func TestNewUpstream(t *testing.T) {
+	if _, err := NewUpstream("http://a.invalid, http://b.invalid", UpstreamPoolOptions{Balance: "random"}); !errors.Is(err, ErrUnknownBalance) {
+		t.Errorf("wanted ErrUnknownBalance, got: %v", err)
+	}
+
+	h, err := NewUpstream("http://a.invalid,http://b.invalid", UpstreamPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := h.(*UpstreamPool); !ok || len(p.backends) != 2 {
+		t.Errorf("wanted a pool of 2 targets, got: %T", h)
+	}
+
+	h, err = NewUpstream("http://a.invalid", UpstreamPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.(*UpstreamPool); ok {
+		t.Error("wanted a single target not to make a pool")
+	}
+
+	if got := SplitTargets(" http://a.invalid ,, http://b.invalid"); strings.Join(got, " ") != "http://a.invalid http://b.invalid" {
+		t.Errorf("wanted two targets, got: %q", got)
+	}
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+var tokenBindingFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "anubis_token_binding_failures",
+	Help: "The total number of cookies rejected because they were used from a different network than they were issued to",
+})
+
+// tokenBound reports whether claims belong to a cookie that was issued to
+// the client making r, as strictly as the policy's token binding requires.
+// Cookies record the IP address they were issued to in the ip claim.
+func (s *Server) tokenBound(r *http.Request, claims jwt.MapClaims) bool {
+	binding := s.policy.Load().TokenBinding
+	if binding == "" || binding == config.TokenBindingNone {
+		return true
+	}
+
+	issued, _ := claims["ip"].(string)
+	issuedIP := net.ParseIP(issued)
+	currentIP := net.ParseIP(r.Header.Get("X-Real-Ip"))
+
+	var ok bool
+	switch {
+	case issuedIP == nil || currentIP == nil:
+		ok = false
+	case binding == config.TokenBindingAddress:
+		ok = issuedIP.Equal(currentIP)
+	default:
+		ok = networkOf(issuedIP).Contains(currentIP)
+	}
+
+	if !ok {
+		tokenBindingFailures.Inc()
+	}
+
+	return ok
+}
+
+// networkOf returns the network ip is most likely part of: its /24 for IPv4
+// and its /64 for IPv6.
+func networkOf(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	}
+
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestTokenBound(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		binding config.TokenBinding
+		issued  string
+		current string
+		ok      bool
+	}{
+		{name: "none", binding: config.TokenBindingNone, issued: "198.51.100.1", current: "203.0.113.1", ok: true},
+		{name: "none_without_claim", binding: config.TokenBindingNone, current: "203.0.113.1", ok: true},
+		{name: "network_same_v4", binding: config.TokenBindingNetwork, issued: "198.51.100.1", current: "198.51.100.200", ok: true},
+		{name: "network_other_v4", binding: config.TokenBindingNetwork, issued: "198.51.100.1", current: "198.51.101.1"},
+		{name: "network_same_v6", binding: config.TokenBindingNetwork, issued: "2001:db8:1:2::1", current: "2001:db8:1:2:ffff::1", ok: true},
+		{name: "network_other_v6", binding: config.TokenBindingNetwork, issued: "2001:db8:1:2::1", current: "2001:db8:1:3::1"},
+		{name: "network_v4_to_v6", binding: config.TokenBindingNetwork, issued: "198.51.100.1", current: "2001:db8:1:2::1"},
+		{name: "network_without_claim", binding: config.TokenBindingNetwork, current: "198.51.100.1"},
+		{name: "address_same", binding: config.TokenBindingAddress, issued: "198.51.100.1", current: "198.51.100.1", ok: true},
+		{name: "address_other", binding: config.TokenBindingAddress, issued: "198.51.100.1", current: "198.51.100.2"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pol := loadPolicies(t, "")
+			pol.TokenBinding = tt.binding
+
+			srv := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol})
+
+			claims := jwt.MapClaims{}
+			if tt.issued != "" {
+				claims["ip"] = tt.issued
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", tt.current)
+
+			if ok := srv.tokenBound(req, claims); ok != tt.ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+}
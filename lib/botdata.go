@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+var (
+	botDataUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_bot_data_updates",
+		Help: "The number of times bot data was checked for updates, by whether it was updated, unchanged, or failed",
+	}, []string{"result"})
+
+	botDataRules = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_bot_data_rules",
+		Help: "The number of bot rules from bot data checked after the policy's own",
+	})
+)
+
+var (
+	ErrBotDataSignature = errors.New("lib: bot data signature is not valid")
+	ErrBotDataPublicKey = errors.New("lib: bot data needs a public key to check its signature with")
+)
+
+// RemoteBotData downloads bundles of bot rules that are checked after the
+// rules of the policy, so that new crawlers are handled without changing the
+// policy. Bundles must be signed with the Ed25519 private key matching
+// PublicKey. The hex-encoded signature is downloaded from the URL of the
+// bundle with ".sig" added.
+type RemoteBotData struct {
+	URL       string
+	Client    *http.Client
+	PublicKey ed25519.PublicKey
+
+	// CacheFile, if set, is where the last bundle and its signature are
+	// saved, so that it is used after a restart even if the URL can't be
+	// reached.
+	CacheFile string
+
+	lock         sync.Mutex
+	etag         string
+	lastModified string
+	version      string
+}
+
+// Load downloads and validates the bundle. If it hasn't changed since the
+// last time it was loaded, Load returns nil and no error.
+func (rb *RemoteBotData) Load(ctx context.Context) (*config.BotData, error) {
+	bd, err := rb.load(ctx)
+	switch {
+	case err != nil:
+		botDataUpdates.WithLabelValues("failed").Inc()
+	case bd == nil:
+		botDataUpdates.WithLabelValues("unchanged").Inc()
+	default:
+		botDataUpdates.WithLabelValues("updated").Inc()
+	}
+
+	return bd, err
+}
+
+func (rb *RemoteBotData) load(ctx context.Context) (*config.BotData, error) {
+	if rb.PublicKey == nil {
+		return nil, ErrBotDataPublicKey
+	}
+
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	client := rb.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	sf, err := fetchSigned(ctx, client, rb.URL, rb.PublicKey, rb.etag, rb.lastModified, "bot data", ErrBotDataSignature)
+	if err != nil || sf == nil {
+		return nil, err
+	}
+
+	bd, err := config.LoadBotData(bytes.NewReader(sf.body), rb.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion("bot data", rb.version, bd.Version); err != nil {
+		return nil, err
+	}
+
+	rb.etag = sf.etag
+	rb.lastModified = sf.lastModified
+	rb.version = bd.Version
+
+	if rb.CacheFile != "" {
+		if err := rb.save(sf); err != nil {
+			slog.Warn("can't save bot data", "path", rb.CacheFile, "err", err)
+		}
+	}
+
+	return bd, nil
+}
+
+// LoadCached loads the bundle saved by the last successful Load, checking
+// its signature again. It returns nil and no error if there is none. Later
+// calls to Load reject bundles older than it.
+func (rb *RemoteBotData) LoadCached() (*config.BotData, error) {
+	if rb.CacheFile == "" {
+		return nil, nil
+	}
+	if rb.PublicKey == nil {
+		return nil, ErrBotDataPublicKey
+	}
+
+	body, err := os.ReadFile(rb.CacheFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read saved bot data: %w", err)
+	}
+
+	sig, err := os.ReadFile(rb.CacheFile + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("can't read saved bot data signature: %w", err)
+	}
+
+	if err := verifySignature(rb.PublicKey, body, sig, ErrBotDataSignature); err != nil {
+		return nil, err
+	}
+
+	bd, err := config.LoadBotData(bytes.NewReader(body), rb.CacheFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	if err := checkVersion("saved bot data", rb.version, bd.Version); err != nil {
+		return nil, err
+	}
+	rb.version = bd.Version
+
+	return bd, nil
+}
+
+// save writes the signature before the bundle, so that a bundle is never
+// saved next to an older signature that would pass for it.
+func (rb *RemoteBotData) save(sf *signedFile) error {
+	if err := os.WriteFile(rb.CacheFile+".sig", sf.sig, 0o600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(rb.CacheFile, sf.body, 0o600)
+}
+
+// SetBotData makes Anubis check the rules of bd after those of the policy,
+// now and after the policy is replaced. A nil bd removes the rules again. If
+// the rules can't be added to the current policy, SetBotData returns an
+// error and keeps the rules it had.
+func (s *Server) SetBotData(bd *config.BotData) error {
+	s.policyLock.Lock()
+	defer s.policyLock.Unlock()
+
+	pol := s.ownPolicy
+	if bd != nil {
+		var err error
+		pol, err = s.ownPolicy.WithBots(bd.Bots)
+		if err != nil {
+			return fmt.Errorf("can't add bot data to the policy: %w", err)
+		}
+		botDataRules.Set(float64(len(bd.Bots)))
+	} else {
+		botDataRules.Set(0)
+	}
+
+	s.botData = bd
+	s.policy.Store(pol)
+	return nil
+}
+
+// withBotData returns pol with the rules of the bot data added, or pol alone
+// if there is no bot data or its rules can't be added.
+func (s *Server) withBotData(pol *policy.ParsedConfig) *policy.ParsedConfig {
+	if s.botData == nil {
+		return pol
+	}
+
+	merged, err := pol.WithBots(s.botData.Bots)
+	if err != nil {
+		slog.Error("can't add bot data to the policy, using the policy alone", "err", err)
+		return pol
+	}
+
+	return merged
+}
@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+const testBotData = `
+version: "2026-10-01"
+bots:
+  - name: example-scraper
+    user_agent_regex: (ExampleScraper|FriendlyBot)
+    action: DENY
+`
+
+func botDataServer(t *testing.T, body string, priv ed25519.PrivateKey) (*httptest.Server, *int) {
+	t.Helper()
+
+	var fetches int
+	sig := hex.EncodeToString(ed25519.Sign(priv, []byte(body)))
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(body)))
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bots.yaml":
+			fetches++
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(body))
+		case "/bots.yaml.sig":
+			w.Write([]byte(sig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &fetches
+}
+
+func TestRemoteBotData(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, fetches := botDataServer(t, testBotData, priv)
+
+	rb := &RemoteBotData{
+		URL:       ts.URL + "/bots.yaml",
+		Client:    ts.Client(),
+		PublicKey: pub,
+		CacheFile: filepath.Join(t.TempDir(), "botdata.yaml"),
+	}
+
+	bd, err := rb.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd == nil || bd.Version != "2026-10-01" || len(bd.Bots) != 1 {
+		t.Fatalf("wanted bot data with 1 rule, got: %+v", bd)
+	}
+
+	bd, err = rb.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd != nil {
+		t.Error("wanted no bot data for an unchanged bundle")
+	}
+	if *fetches != 2 {
+		t.Errorf("fetched the bundle %d times, wanted 2", *fetches)
+	}
+
+	cached := &RemoteBotData{PublicKey: pub, CacheFile: rb.CacheFile}
+	bd, err = cached.LoadCached()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd == nil || len(bd.Bots) != 1 {
+		t.Fatalf("wanted the saved bot data, got: %+v", bd)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached.PublicKey = otherPub
+	if _, err := cached.LoadCached(); !errors.Is(err, ErrBotDataSignature) {
+		t.Errorf("err: %v, wanted: %v", err, ErrBotDataSignature)
+	}
+
+	rb = &RemoteBotData{URL: ts.URL + "/bots.yaml", Client: ts.Client(), PublicKey: otherPub}
+	if _, err := rb.Load(context.Background()); !errors.Is(err, ErrBotDataSignature) {
+		t.Errorf("err: %v, wanted: %v", err, ErrBotDataSignature)
+	}
+
+	rb = &RemoteBotData{URL: ts.URL + "/bots.yaml", Client: ts.Client()}
+	if _, err := rb.Load(context.Background()); !errors.Is(err, ErrBotDataPublicKey) {
+		t.Errorf("err: %v, wanted: %v", err, ErrBotDataPublicKey)
+	}
+}
+
+func TestRemoteBotDataRollback(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, _ := botDataServer(t, testBotData, priv)
+	older, _ := botDataServer(t, strings.Replace(testBotData, "2026-10-01", "2026-09-01", 1), priv)
+	unversioned, _ := botDataServer(t, strings.Replace(testBotData, `version: "2026-10-01"`, "", 1), priv)
+
+	rb := &RemoteBotData{
+		URL:       newer.URL + "/bots.yaml",
+		Client:    newer.Client(),
+		PublicKey: pub,
+		CacheFile: filepath.Join(t.TempDir(), "botdata.yaml"),
+	}
+	if _, err := rb.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rb.URL = older.URL + "/bots.yaml"
+	if _, err := rb.Load(context.Background()); !errors.Is(err, ErrVersionRollback) {
+		t.Errorf("err: %v, wanted: %v", err, ErrVersionRollback)
+	}
+
+	rb.URL = unversioned.URL + "/bots.yaml"
+	if _, err := rb.Load(context.Background()); !errors.Is(err, ErrVersionMissing) {
+		t.Errorf("err: %v, wanted: %v", err, ErrVersionMissing)
+	}
+
+	// After a restart, the saved bundle is the one older bundles are
+	// compared to.
+	restarted := &RemoteBotData{
+		URL:       older.URL + "/bots.yaml",
+		Client:    older.Client(),
+		PublicKey: pub,
+		CacheFile: rb.CacheFile,
+	}
+	if _, err := restarted.LoadCached(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Load(context.Background()); !errors.Is(err, ErrVersionRollback) {
+		t.Errorf("err after restart: %v, wanted: %v", err, ErrVersionRollback)
+	}
+}
+
+func TestRemoteBotDataInvalid(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, _ := botDataServer(t, `
+bots:
+  - name: elsewhere
+    user_agent_regex: ExampleScraper
+    action: ALLOW
+    target: https://attacker.example
+`, priv)
+
+	rb := &RemoteBotData{URL: ts.URL + "/bots.yaml", Client: ts.Client(), PublicKey: pub}
+	if _, err := rb.Load(context.Background()); err == nil {
+		t.Error("wanted bot data with a target to be rejected")
+	}
+}
+
+func TestSetBotData(t *testing.T) {
+	ownPolicy := func() *policy.ParsedConfig {
+		pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: friendly-bot
+    user_agent_regex: FriendlyBot
+    action: ALLOW
+`), "own.yaml", anubis.DefaultDifficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pol
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("upstream"))
+		}),
+		Policy: ownPolicy(),
+	})
+
+	bd, err := config.LoadBotData(strings.NewReader(testBotData), "bots.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetBotData(bd); err != nil {
+		t.Fatal(err)
+	}
+
+	// Denied requests are answered with status 200 too, so check whether
+	// they reached the upstream instead.
+	check := func(userAgent string, upstream bool) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if got := rec.Body.String() == "upstream"; got != upstream {
+			t.Errorf("%s: wanted to reach the upstream: %v, got: %v", userAgent, upstream, got)
+		}
+	}
+
+	check("ExampleScraper/1.0", false)
+	check("FriendlyBot/1.0", true)
+
+	srv.SetPolicy(ownPolicy())
+	check("ExampleScraper/1.0", false)
+	check("FriendlyBot/1.0", true)
+
+	if err := srv.SetBotData(nil); err != nil {
+		t.Fatal(err)
+	}
+	check("ExampleScraper/1.0", true)
+}
@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/lib/store"
+)
+
+// BypassHeader is the request header that trusted automated clients, such as
+// webhook senders and uptime monitors, send their bypass token in.
+const BypassHeader = "X-Anubis-Bypass"
+
+const (
+	// bypassAudience and bypassType set bypass tokens apart from cookies
+	// and upstream tokens, which are signed with the same key.
+	bypassAudience = "anubis-bypass"
+	bypassType     = "bypass"
+
+	// MaxBypassLifetime is how long a bypass token can be valid for.
+	// Revocations are kept this long, so that they outlive every token they
+	// apply to.
+	MaxBypassLifetime = 365 * 24 * time.Hour
+)
+
+var (
+	ErrBypassLifetime          = errors.New("lib: bypass token lifetime must be between one minute and one year")
+	ErrBypassName              = errors.New("lib: bypass token needs a name")
+	ErrBypassPath              = errors.New("lib: bypass token paths must start with /")
+	ErrBypassNotAllowed        = errors.New("lib: bypass token isn't valid for this path")
+	ErrBypassRevoked           = errors.New("lib: bypass token was revoked")
+	ErrBypassRevocationUnknown = errors.New("lib: can't check whether bypass token was revoked")
+
+	bypassRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_bypass_requests",
+		Help: "The number of requests with a bypass token, by whether the token was accepted",
+	}, []string{"result"})
+)
+
+// BypassToken lets a trusted client past every rule of the policy. It is
+// scoped to path prefixes and valid until it expires or is revoked.
+type BypassToken struct {
+	// ID identifies the token for revoking it.
+	ID string `json:"id"`
+
+	// Name says who the token was issued to, such as "uptime-monitor". It
+	// shows up as the rule name "bypass/<name>" in logs and metrics.
+	Name string `json:"name"`
+
+	// Paths are the path prefixes the token is valid for. If empty, it is
+	// valid for every path.
+	Paths []string `json:"paths,omitempty"`
+
+	Expiry time.Time `json:"expiry"`
+}
+
+// NewBypassToken returns a token with a random ID for name that is valid
+// for lifetime on paths.
+func NewBypassToken(name string, paths []string, lifetime time.Duration) (BypassToken, error) {
+	if name == "" {
+		return BypassToken{}, ErrBypassName
+	}
+	if lifetime < time.Minute || lifetime > MaxBypassLifetime {
+		return BypassToken{}, fmt.Errorf("%w, got: %s", ErrBypassLifetime, lifetime)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return BypassToken{}, fmt.Errorf("%w, got: %q", ErrBypassPath, p)
+		}
+	}
+
+	return BypassToken{
+		ID:     randomBypassID(),
+		Name:   name,
+		Paths:  paths,
+		Expiry: time.Now().Add(lifetime).Truncate(time.Second),
+	}, nil
+}
+
+func randomBypassID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Sign returns b as a JWT signed with priv, the signing key of Anubis.
+func (b BypassToken) Sign(priv ed25519.PrivateKey) (string, error) {
+	claims := jwt.MapClaims{
+		"iss": "anubis",
+		"aud": bypassAudience,
+		"typ": bypassType,
+		"sub": b.Name,
+		"jti": b.ID,
+		"iat": time.Now().Unix(),
+		"exp": b.Expiry.Unix(),
+	}
+	if len(b.Paths) > 0 {
+		claims["paths"] = b.Paths
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(priv)
+}
+
+// allows reports whether b is valid for path.
+func (b BypassToken) allows(path string) bool {
+	if len(b.Paths) == 0 {
+		return true
+	}
+
+	for _, p := range b.Paths {
+		if config.HasPathPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseBypassToken checks the signature and expiry of a bypass token.
+func (s *Server) parseBypassToken(value string) (BypassToken, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(value, claims, s.verificationKeys,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithAudience(bypassAudience),
+		jwt.WithIssuer("anubis"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return BypassToken{}, err
+	}
+
+	b := BypassToken{}
+	b.ID, _ = claims["jti"].(string)
+	b.Name, _ = claims["sub"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		b.Expiry = exp.Time
+	}
+	if paths, ok := claims["paths"].([]any); ok {
+		for _, p := range paths {
+			if p, ok := p.(string); ok {
+				b.Paths = append(b.Paths, p)
+			}
+		}
+	}
+
+	if claims["typ"] != bypassType || b.ID == "" || b.Name == "" {
+		return BypassToken{}, jwt.ErrTokenInvalidClaims
+	}
+
+	return b, nil
+}
+
+// RevokeBypassToken stops the bypass token with the given ID from working
+// on every replica sharing this server's store.
+func (s *Server) RevokeBypassToken(ctx context.Context, id string) error {
+	return s.bypassRevoked.Set(ctx, id, true, MaxBypassLifetime)
+}
+
+// checkBypass returns the bypass token of r if it is valid for the path of r
+// and wasn't revoked.
+func (s *Server) checkBypass(r *http.Request) (BypassToken, error) {
+	b, err := s.parseBypassToken(r.Header.Get(BypassHeader))
+	if err != nil {
+		return b, err
+	}
+
+	if !b.allows(r.URL.Path) {
+		return b, ErrBypassNotAllowed
+	}
+
+	_, err = s.bypassRevoked.Get(r.Context(), b.ID)
+	switch {
+	case err == nil:
+		return b, ErrBypassRevoked
+	case !errors.Is(err, store.ErrNotFound):
+		storeErrors.WithLabelValues("bypass_revocation").Inc()
+		s.requestLogger(r).Error("can't check whether bypass token was revoked", "err", err, "fail_closed", s.opts.StoreFailClosed)
+		if s.opts.StoreFailClosed {
+			return b, fmt.Errorf("%w: %w", ErrBypassRevocationUnknown, err)
+		}
+	}
+
+	return b, nil
+}
+
+// bypass passes a request with a bypass token to the target without
+// checking it against the policy. Requests with an invalid token are denied
+// rather than challenged, so that a broken integration is noticed.
+func (s *Server) bypass(w http.ResponseWriter, r *http.Request) {
+	lg := s.requestLogger(r)
+
+	b, err := s.checkBypass(r)
+	if err != nil {
+		lg.Info("invalid bypass token", "name", b.Name, "id", b.ID, "err", err)
+		bypassRequests.WithLabelValues("rejected").Inc()
+		s.ClearCookie(w)
+		s.respondWithError(w, r, ReasonInvalidBypassToken, "invalid bypass token", http.StatusForbidden)
+		return
+	}
+
+	result := cr("bypass/"+b.Name, config.RuleAllow)
+	lg.Debug("bypass token accepted", "name", b.Name, "id", b.ID)
+	bypassRequests.WithLabelValues("accepted").Inc()
+	policy.Applications.WithLabelValues(result.Name, string(result.Rule)).Add(1)
+	s.stats.rule(result)
+
+	r.Header.Del(BypassHeader)
+	r.Header.Add("X-Anubis-Rule", result.Name)
+	r.Header.Add("X-Anubis-Action", string(result.Rule))
+	r.Header.Add("X-Anubis-Status", "BYPASS")
+	s.forward(w, r)
+}
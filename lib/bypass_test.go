@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/store"
+)
+
+func TestBypassToken(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pol := loadPolicies(t, "")
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(BypassHeader) != "" {
+				t.Error("bypass token was passed to the target")
+			}
+			fmt.Fprint(w, r.Header.Get("X-Anubis-Rule"))
+		}),
+		Policy:     pol,
+		PrivateKey: priv,
+	})
+
+	sign := func(name string, paths []string, lifetime time.Duration) (BypassToken, string) {
+		t.Helper()
+
+		b, err := NewBypassToken(name, paths, lifetime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := b.Sign(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b, token
+	}
+
+	monitor, monitorToken := sign("monitor", []string{"/health"}, time.Hour)
+	_, otherKeyToken := func() (BypassToken, string) {
+		_, other, _ := ed25519.GenerateKey(rand.Reader)
+		b, _ := NewBypassToken("forged", nil, time.Hour)
+		token, _ := b.Sign(other)
+		return b, token
+	}()
+
+	expired := BypassToken{ID: "expired", Name: "old", Expiry: time.Now().Add(-time.Minute)}
+	expiredToken, err := expired.Sign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieToken, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"challenge": "x",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}).SignedString(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, path, token string
+		userAgent         string
+		wantStatus        int
+		wantBody          string
+	}{
+		{
+			name:       "valid",
+			path:       "/health/live",
+			token:      monitorToken,
+			userAgent:  "BadBot",
+			wantStatus: http.StatusOK,
+			wantBody:   "bypass/monitor",
+		},
+		{
+			name:       "other path",
+			path:       "/admin",
+			token:      monitorToken,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "path that only starts the same",
+			path:       "/healthz",
+			token:      monitorToken,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "other key",
+			path:       "/health",
+			token:      otherKeyToken,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "expired",
+			path:       "/health",
+			token:      expiredToken,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "cookie",
+			path:       "/health",
+			token:      cookieToken,
+			wantStatus: http.StatusForbidden,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.7")
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set(BypassHeader, tt.token)
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("wanted status %d, got: %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus == http.StatusForbidden {
+				if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != ReasonInvalidBypassToken {
+					t.Errorf("wanted reason %s, got: %q", ReasonInvalidBypassToken, got)
+				}
+				return
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("wanted body %q, got: %q", tt.wantBody, got)
+			}
+		})
+	}
+
+	t.Run("not a cookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.7")
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: monitorToken})
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != ReasonChallengeRequired {
+			t.Errorf("wanted a challenge for a bypass token in a cookie, got reason: %q", got)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		if err := srv.RevokeBypassToken(context.Background(), monitor.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.7")
+		req.Header.Set(BypassHeader, monitorToken)
+		if _, err := srv.checkBypass(req); !errors.Is(err, ErrBypassRevoked) {
+			t.Errorf("wanted ErrBypassRevoked, got: %v", err)
+		}
+	})
+}
+
+func TestBypassStoreFailure(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBypassToken("monitor", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := b.Sign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		failClosed bool
+		wantErr    error
+	}{
+		{name: "fail_open"},
+		{name: "fail_closed", failClosed: true, wantErr: ErrBypassRevocationUnknown},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := spawnAnubis(t, Options{
+				Next:            http.NewServeMux(),
+				Policy:          loadPolicies(t, ""),
+				PrivateKey:      priv,
+				Store:           failingStore{Interface: store.NewMemory(), prefix: "bypass-revoked:"},
+				StoreFailClosed: tt.failClosed,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.7")
+			req.Header.Set(BypassHeader, token)
+
+			before := testutil.ToFloat64(storeErrors.WithLabelValues("bypass_revocation"))
+			if _, err := srv.checkBypass(req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("wanted error %v, got: %v", tt.wantErr, err)
+			}
+			if got := testutil.ToFloat64(storeErrors.WithLabelValues("bypass_revocation")) - before; got != 1 {
+				t.Errorf("wanted 1 store error to be counted, got: %v", got)
+			}
+		})
+	}
+}
+
+func TestNewBypassToken(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		paths    []string
+		lifetime time.Duration
+		err      error
+	}{
+		{name: "monitor", paths: []string{"/health"}, lifetime: time.Hour},
+		{name: "", lifetime: time.Hour, err: ErrBypassName},
+		{name: "monitor", lifetime: 2 * MaxBypassLifetime, err: ErrBypassLifetime},
+		{name: "monitor", paths: []string{"health"}, lifetime: time.Hour, err: ErrBypassPath},
+	} {
+		b, err := NewBypassToken(tt.name, tt.paths, tt.lifetime)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("NewBypassToken(%q, %v, %s): wanted error %v, got: %v", tt.name, tt.paths, tt.lifetime, tt.err, err)
+		}
+		if err == nil && (b.ID == "" || b.Expiry.Before(time.Now())) {
+			t.Errorf("wanted an ID and an expiry in the future, got: %+v", b)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// defaultTargetSolveSeconds is how long a calibrated client is expected to
+// spend solving a challenge when the policy doesn't say otherwise.
+const defaultTargetSolveSeconds = 10
+
+var clientHashRate = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "anubis_client_hash_rate",
+	Help:    "The hash rate reported by calibrating clients (hashes per second)",
+	Buckets: prometheus.ExponentialBucketsRange(1, math.Pow(2, 24), 25),
+})
+
+// effectiveDifficulty works out how many leading zeroes a client that reported
+// the given hash rate must find. The difficulty is lowered one step at a time
+// until the expected solve time fits in the target, but never below
+// MinDifficulty. This must match effectiveDifficulty in web/js/calibrate.mjs.
+func effectiveDifficulty(rules *config.ChallengeRules, hashRate float64) int {
+	difficulty := rules.Difficulty
+	if rules.MinDifficulty == 0 || hashRate <= 0 {
+		return difficulty
+	}
+
+	target := float64(rules.TargetSolveSeconds)
+	if target == 0 {
+		target = defaultTargetSolveSeconds
+	}
+
+	for difficulty > rules.MinDifficulty && math.Pow(16, float64(difficulty))/hashRate > target {
+		difficulty--
+	}
+
+	return difficulty
+}
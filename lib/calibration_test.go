@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestEffectiveDifficulty(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		rules    config.ChallengeRules
+		hashRate float64
+		want     int
+	}{
+		{
+			name:     "calibration_disabled",
+			rules:    config.ChallengeRules{Difficulty: 5},
+			hashRate: 10,
+			want:     5,
+		},
+		{
+			name:  "no_hash_rate_reported",
+			rules: config.ChallengeRules{Difficulty: 5, MinDifficulty: 2},
+			want:  5,
+		},
+		{
+			name:     "fast_device",
+			rules:    config.ChallengeRules{Difficulty: 5, MinDifficulty: 2},
+			hashRate: 1 << 20,
+			want:     5,
+		},
+		{
+			name:     "slow_device",
+			rules:    config.ChallengeRules{Difficulty: 5, MinDifficulty: 2},
+			hashRate: 10_000,
+			want:     4,
+		},
+		{
+			name:     "very_slow_device_is_capped_at_min",
+			rules:    config.ChallengeRules{Difficulty: 5, MinDifficulty: 3},
+			hashRate: 1,
+			want:     3,
+		},
+		{
+			name:     "custom_target",
+			rules:    config.ChallengeRules{Difficulty: 5, MinDifficulty: 2, TargetSolveSeconds: 120},
+			hashRate: 10_000,
+			want:     5,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := effectiveDifficulty(&tt.rules, tt.hashRate); got != tt.want {
+				t.Errorf("wanted difficulty %d, got: %d", tt.want, got)
+			}
+		})
+	}
+}
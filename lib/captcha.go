@@ -0,0 +1,264 @@
+package lib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/internal/accesslog"
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/web"
+)
+
+// captchaEscalationThreshold is about how many failed proof-of-work
+// solutions a client may send per velocityHalfLife before it is asked to
+// solve a CAPTCHA instead.
+const captchaEscalationThreshold = 3
+
+var captchaResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_captcha_results",
+	Help: "The total number of CAPTCHA solutions checked, by result",
+}, []string{"result"})
+
+// CaptchaProvider is a third-party CAPTCHA service that Anubis can ask
+// clients to solve instead of a proof-of-work challenge.
+type CaptchaProvider interface {
+	// Name identifies the provider in logs.
+	Name() string
+
+	// ScriptURL is the provider's JavaScript that renders the widget.
+	ScriptURL() string
+
+	// WidgetClass is the CSS class of the element the widget is rendered in.
+	WidgetClass() string
+
+	// SiteKey is the public key the widget is rendered with.
+	SiteKey() string
+
+	// ResponseField is the form field the widget puts its token in.
+	ResponseField() string
+
+	// Verify asks the provider whether token is a valid solution sent by
+	// the client at remoteIP. If the provider says why a token isn't, the
+	// reason is returned in an error wrapping ErrCaptchaRejected.
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// ErrCaptchaRejected is wrapped by the errors CaptchaProvider.Verify returns
+// with the reason a token isn't a valid solution.
+var ErrCaptchaRejected = errors.New("lib: CAPTCHA provider rejected the token")
+
+// siteVerifyProvider is a provider that verifies tokens with the siteverify
+// API that hCaptcha, Turnstile, and reCAPTCHA share.
+type siteVerifyProvider struct {
+	name          string
+	scriptURL     string
+	widgetClass   string
+	responseField string
+	verifyURL     string
+	siteKey       string
+	secret        string
+	client        *http.Client
+}
+
+// NewHCaptcha creates a CaptchaProvider for hCaptcha.
+func NewHCaptcha(siteKey, secret string) CaptchaProvider {
+	return &siteVerifyProvider{
+		name:          "hcaptcha",
+		scriptURL:     "https://js.hcaptcha.com/1/api.js",
+		widgetClass:   "h-captcha",
+		responseField: "h-captcha-response",
+		verifyURL:     "https://api.hcaptcha.com/siteverify",
+		siteKey:       siteKey,
+		secret:        secret,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewTurnstile creates a CaptchaProvider for Cloudflare Turnstile.
+func NewTurnstile(siteKey, secret string) CaptchaProvider {
+	return &siteVerifyProvider{
+		name:          "turnstile",
+		scriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		widgetClass:   "cf-turnstile",
+		responseField: "cf-turnstile-response",
+		verifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		siteKey:       siteKey,
+		secret:        secret,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *siteVerifyProvider) Name() string          { return p.name }
+func (p *siteVerifyProvider) ScriptURL() string     { return p.scriptURL }
+func (p *siteVerifyProvider) WidgetClass() string   { return p.widgetClass }
+func (p *siteVerifyProvider) SiteKey() string       { return p.siteKey }
+func (p *siteVerifyProvider) ResponseField() string { return p.responseField }
+
+func (p *siteVerifyProvider) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	form := url.Values{}
+	form.Set("secret", p.secret)
+	form.Set("response", token)
+	form.Set("remoteip", remoteIP)
+	form.Set("sitekey", p.siteKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%s: can't verify token: %w", p.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: can't verify token: status %d", p.name, resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("%s: can't decode verification result: %w", p.name, err)
+	}
+
+	if !result.Success && len(result.ErrorCodes) != 0 {
+		return false, fmt.Errorf("%w: %s: %s", ErrCaptchaRejected, p.name, strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return result.Success, nil
+}
+
+// captchaResponse computes the response stored in cookies issued for a
+// solved CAPTCHA, so that they pass the same checks as proof-of-work ones.
+func (s *Server) captchaResponse(challenge string, nonce int) string {
+	mac := hmac.New(sha256.New, s.signingKey().Seed())
+	fmt.Fprintf(mac, "captcha:%s%d", challenge, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isCaptchaToken reports whether claims are from a cookie issued for a solved
+// CAPTCHA.
+func isCaptchaToken(claims jwt.MapClaims) bool {
+	method, _ := claims["method"].(string)
+	return method == "captcha"
+}
+
+// wantsCaptcha reports whether the client should solve a CAPTCHA instead of
+// a proof-of-work challenge for rule, either because the rule asks for it or
+// because the client has failed too many proof-of-work challenges.
+func (s *Server) wantsCaptcha(r *http.Request, rule *policy.Bot) bool {
+	if s.opts.Captcha == nil {
+		return false
+	}
+
+	if rule.Action == config.RuleCaptcha {
+		return true
+	}
+
+	return s.challengeFailures.Count(velocityKey(r)) > captchaEscalationThreshold
+}
+
+// recordChallengeFailure counts a failed proof-of-work solution, so that
+// clients that keep failing can be asked to solve a CAPTCHA instead.
+func (s *Server) recordChallengeFailure(r *http.Request, rule *policy.Bot) {
+	s.challengeFailures.Add(velocityKey(r))
+	s.countChallenge(rule, "failed")
+	s.recordReputation(r, "challenge_failed", reputationChallengeFailed)
+}
+
+// renderCaptcha serves the page with the CAPTCHA widget.
+func (s *Server) renderCaptcha(w http.ResponseWriter, r *http.Request) {
+	p := s.opts.Captcha
+	internal.NoStoreCache(templ.Handler(
+		web.Base(s.challengeTitle(r), web.Captcha(p.ScriptURL(), p.WidgetClass(), p.SiteKey(), anubis.StaticPath+"api/pass-captcha", r.URL.RequestURI())),
+	)).ServeHTTP(w, r)
+}
+
+// PassCaptcha checks a CAPTCHA solution with the provider and issues a cookie
+// if it is valid.
+func (s *Server) PassCaptcha(w http.ResponseWriter, r *http.Request) {
+	lg := s.requestLogger(r)
+	entry := accesslog.FromContext(r.Context())
+	entry.SetChallenge("failed")
+
+	if s.opts.Captcha == nil {
+		s.respondWithError(w, r, ReasonMisconfiguration, "CAPTCHA is not configured", http.StatusNotFound)
+		return
+	}
+
+	cr, rule, err := s.check(r)
+	if err != nil {
+		lg.Error("check failed", "err", err)
+		s.respondWithError(w, r, ReasonMisconfiguration, localization.ForRequest(r).T("misconfigured", "passCaptcha"), http.StatusInternalServerError)
+		return
+	}
+	lg = lg.With("check_result", cr, "provider", s.opts.Captcha.Name())
+
+	ok, err := s.opts.Captcha.Verify(r.Context(), r.FormValue(s.opts.Captcha.ResponseField()), r.Header.Get("X-Real-Ip"))
+	switch {
+	case errors.Is(err, context.Canceled):
+		return
+	case err != nil && !errors.Is(err, ErrCaptchaRejected):
+		lg.Error("can't verify CAPTCHA", "err", err)
+		captchaResults.WithLabelValues("error").Inc()
+		s.respondWithError(w, r, ReasonInternalError, localization.ForRequest(r).T("captcha_unavailable"), http.StatusBadGateway)
+		return
+	case !ok:
+		lg.Debug("CAPTCHA solution rejected", "err", err)
+		captchaResults.WithLabelValues("fail").Inc()
+		s.countChallenge(rule, "failed")
+		s.recordReputation(r, "challenge_failed", reputationChallengeFailed)
+		s.ClearCookie(w)
+		s.respondWithError(w, r, ReasonInvalidCaptcha, localization.ForRequest(r).T("invalid_captcha"), http.StatusForbidden)
+		return
+	}
+	captchaResults.WithLabelValues("pass").Inc()
+	s.countChallenge(rule, "passed")
+
+	challenge := s.newChallenge(r, rule.Challenge.Difficulty)
+	nonce := int(time.Now().Unix())
+
+	if _, err := s.setTokenCookie(w, jwt.MapClaims{
+		"challenge": challenge,
+		"nonce":     nonce,
+		"response":  s.captchaResponse(challenge, nonce),
+		"method":    "captcha",
+		"ip":        r.Header.Get("X-Real-Ip"),
+	}); err != nil {
+		lg.Error("failed to sign JWT", "err", err)
+		s.ClearCookie(w)
+		s.respondWithError(w, r, ReasonInternalError, "failed to sign JWT", http.StatusInternalServerError)
+		return
+	}
+
+	entry.SetChallenge("passed")
+	s.recordReputation(r, "challenge_passed", reputationChallengePassed)
+	lg.Debug("CAPTCHA passed, redirecting to app")
+	http.Redirect(w, r, r.FormValue("redir"), http.StatusFound)
+}
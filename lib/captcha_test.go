@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+type fakeCaptcha struct{}
+
+func (fakeCaptcha) Name() string          { return "fake" }
+func (fakeCaptcha) ScriptURL() string     { return "https://captcha.example/api.js" }
+func (fakeCaptcha) WidgetClass() string   { return "fake-captcha" }
+func (fakeCaptcha) SiteKey() string       { return "site-key" }
+func (fakeCaptcha) ResponseField() string { return "fake-captcha-response" }
+
+func (fakeCaptcha) Verify(_ context.Context, token, _ string) (bool, error) {
+	return token == "solved", nil
+}
+
+func TestCaptcha(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: suspicious
+    path_regex: ^/suspicious
+    action: CAPTCHA
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+`), "captcha.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:    http.NewServeMux(),
+		Policy:  pol,
+		Captcha: fakeCaptcha{},
+	})
+
+	do := func(req *http.Request, ip string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req.Header.Set("X-Real-Ip", ip)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	passCaptcha := func(token string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		form := url.Values{}
+		form.Set("fake-captcha-response", token)
+		form.Set("redir", "/suspicious")
+
+		req := httptest.NewRequest(http.MethodPost, "/.within.website/x/cmd/anubis/api/pass-captcha", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return do(req, "198.51.100.1")
+	}
+
+	t.Run("captcha_rule", func(t *testing.T) {
+		rec := do(httptest.NewRequest(http.MethodGet, "/suspicious", nil), "198.51.100.1")
+		if !strings.Contains(rec.Body.String(), `class="fake-captcha"`) {
+			t.Fatalf("wanted the CAPTCHA widget, got: %s", rec.Body.String())
+		}
+
+		rec = passCaptcha("wrong")
+		if rec.Code != http.StatusForbidden || rec.Header().Get(ReasonHeader) != string(ReasonInvalidCaptcha) {
+			t.Errorf("wrong solutions should fail with %s, got: %d %s", ReasonInvalidCaptcha, rec.Code, rec.Header().Get(ReasonHeader))
+		}
+
+		rec = passCaptcha("solved")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("wanted status %d, got: %d", http.StatusFound, rec.Code)
+		}
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != anubis.CookieName {
+			t.Fatalf("wanted a cookie, got: %v", cookies)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/suspicious", nil)
+		req.AddCookie(cookies[0])
+		rec = do(req, "198.51.100.1")
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("the cookie should let the client through to the target, got status: %d", rec.Code)
+		}
+	})
+
+	t.Run("escalation", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.2")
+
+		rec := do(httptest.NewRequest(http.MethodGet, "/", nil), "198.51.100.2")
+		if strings.Contains(rec.Body.String(), "fake-captcha") {
+			t.Fatal("clients should get a proof-of-work challenge at first")
+		}
+
+		for range captchaEscalationThreshold + 1 {
+			srv.recordChallengeFailure(req, &policy.Bot{Name: "escalation"})
+		}
+
+		rec = do(httptest.NewRequest(http.MethodGet, "/", nil), "198.51.100.2")
+		if !strings.Contains(rec.Body.String(), `class="fake-captcha"`) {
+			t.Error("clients that keep failing challenges should get a CAPTCHA")
+		}
+	})
+}
+
+func TestSiteVerifyProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "secret" || r.FormValue("remoteip") != "198.51.100.1" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		result := map[string]any{"success": r.FormValue("response") == "solved"}
+		if r.FormValue("response") == "expired" {
+			result["error-codes"] = []string{"timeout-or-duplicate"}
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+	defer ts.Close()
+
+	p := NewTurnstile("site-key", "secret").(*siteVerifyProvider)
+	p.verifyURL = ts.URL
+
+	for _, tt := range []struct {
+		token string
+		want  bool
+	}{
+		{token: "solved", want: true},
+		{token: "wrong", want: false},
+		{token: "", want: false},
+	} {
+		got, err := p.Verify(t.Context(), tt.token, "198.51.100.1")
+		if err != nil {
+			t.Errorf("Verify(%q): %v", tt.token, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Verify(%q): wanted %v, got: %v", tt.token, tt.want, got)
+		}
+	}
+
+	ok, err := p.Verify(t.Context(), "expired", "198.51.100.1")
+	if ok || !errors.Is(err, ErrCaptchaRejected) || !strings.Contains(err.Error(), "timeout-or-duplicate") {
+		t.Errorf("Verify(\"expired\"): wanted %v with the error code, got: %v, %v", ErrCaptchaRejected, ok, err)
+	}
+
+	p.secret = "wrong"
+	if _, err := p.Verify(t.Context(), "solved", "198.51.100.1"); err == nil {
+		t.Error("wanted an error when the provider rejects the request")
+	}
+}
@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/internal/ratelimit"
+)
+
+var clientLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_client_limited",
+	Help: "The total number of requests rejected by the per-client connection and in-flight request limits, by limit",
+}, []string{"limit"})
+
+type connKey struct{}
+
+// connInfo is what Anubis knows about the connection a request came in on.
+//
+// Connections are counted on their first request rather than once they are
+// accepted. With the PROXY protocol, the remote address isn't known until
+// the header has been read, and net/http calls ConnContext in its accept
+// loop, so waiting for it there would hold up every other client.
+type connInfo struct {
+	conn net.Conn
+
+	lock    sync.Mutex
+	counted bool
+	closed  bool
+	ip      string
+
+	// open is how many connections the IP address had open once this one
+	// was counted, including this one.
+	open int
+}
+
+// count counts the connection for its IP address if it hasn't been yet, and
+// returns the address and how many connections it had open then.
+func (ci *connInfo) count(connections *ratelimit.Concurrency) (string, int) {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	if !ci.counted && !ci.closed {
+		ci.counted = true
+		ci.ip = connIP(ci.conn)
+		if ci.ip != "" {
+			ci.open = connections.Acquire(ci.ip)
+		}
+	}
+
+	return ci.ip, ci.open
+}
+
+// release stops counting the connection once it is closed.
+func (ci *connInfo) release(connections *ratelimit.Concurrency) {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	ci.closed = true
+	if ci.counted && ci.ip != "" {
+		connections.Release(ci.ip)
+	}
+	ci.counted = false
+}
+
+// connIP returns the IP address connections from c are counted by, or an
+// empty string for connections that don't come from an IP address, such as
+// ones on Unix sockets.
+func connIP(c net.Conn) string {
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+
+	return addr.IP.String()
+}
+
+// ConnContext keeps track of a new connection for the per-client connection
+// limit, if the policy sets one. Servers that serve Anubis should use it as
+// their http.Server.ConnContext, along with ConnState.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if s.policy.Load().ClientLimits.MaxConnections == 0 {
+		return ctx
+	}
+
+	info := &connInfo{conn: c}
+	s.conns.Store(c, info)
+	return context.WithValue(ctx, connKey{}, info)
+}
+
+// ConnState stops counting connections once they are closed. Servers that
+// serve Anubis should use it as their http.Server.ConnState, along with
+// ConnContext.
+func (s *Server) ConnState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		if info, ok := s.conns.LoadAndDelete(c); ok {
+			info.(*connInfo).release(s.connections)
+		}
+	}
+}
+
+// admitConnection applies the policy's per-client connection limit to the
+// connection r came in on. If the client had too many connections open when
+// the connection was counted, it responds with 429, asks for the connection
+// to be closed, and returns false, unless the policy is in dry run mode.
+func (s *Server) admitConnection(w http.ResponseWriter, r *http.Request) bool {
+	info, ok := r.Context().Value(connKey{}).(*connInfo)
+	limit := s.policy.Load().ClientLimits.MaxConnections
+	if !ok || limit == 0 {
+		return true
+	}
+
+	ip, open := info.count(s.connections)
+	if ip == "" || open <= limit {
+		return true
+	}
+
+	if s.dryRun(nil) {
+		s.requestLogger(r).Info("dry run: would have limited connections", "ip", ip, "open", open)
+		dryRunResults.WithLabelValues("client_limit", "connections").Inc()
+		return true
+	}
+
+	clientLimited.WithLabelValues("connections").Inc()
+	s.requestLogger(r).Debug("too many connections", "ip", ip, "open", open)
+	w.Header().Set("Connection", "close")
+	s.respondRateLimited(w, r, time.Second)
+	return false
+}
+
+// admitInFlight applies the policy's per-client in-flight request limit to
+// r. If the request may be sent to the upstream, it returns a function to
+// call once the upstream answered. Otherwise it responds with 429 and
+// returns false, unless the policy is in dry run mode.
+func (s *Server) admitInFlight(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	limit := s.policy.Load().ClientLimits.MaxInFlight
+	if limit == 0 {
+		return func() {}, true
+	}
+
+	ip := r.Header.Get("X-Real-Ip")
+	release := func() { s.inFlight.Release(ip) }
+
+	inFlight := s.inFlight.Acquire(ip)
+	if inFlight <= limit {
+		return release, true
+	}
+
+	if s.dryRun(nil) {
+		s.requestLogger(r).Info("dry run: would have limited in-flight requests", "in_flight", inFlight)
+		dryRunResults.WithLabelValues("client_limit", "in_flight").Inc()
+		return release, true
+	}
+
+	release()
+	clientLimited.WithLabelValues("in_flight").Inc()
+	s.requestLogger(r).Debug("too many requests in flight", "in_flight", inFlight)
+	s.respondRateLimited(w, r, time.Second)
+	return nil, false
+}
@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func clientLimitsPolicy(t *testing.T, limits string) *policy.ParsedConfig {
+	t.Helper()
+
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: ALLOW
+
+client_limits:
+`+limits), "client_limits.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pol
+}
+
+func TestClientInFlightLimit(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-unblock
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	srv := spawnAnubis(t, Options{
+		Next:   mux,
+		Policy: clientLimitsPolicy(t, "  max_in_flight: 1\n"),
+	})
+
+	do := func(path, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Real-Ip", ip)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- do("/slow", "198.51.100.1") }()
+	<-started
+
+	if got := do("/", "198.51.100.1"); got != http.StatusTooManyRequests {
+		t.Errorf("wanted a second request in flight to be limited, got status %d", got)
+	}
+	if got := do("/", "198.51.100.2"); got != http.StatusOK {
+		t.Errorf("wanted other clients to be let through, got status %d", got)
+	}
+
+	close(unblock)
+	if got := <-done; got != http.StatusOK {
+		t.Errorf("wanted the first request to be let through, got status %d", got)
+	}
+	if got := do("/", "198.51.100.1"); got != http.StatusOK {
+		t.Errorf("wanted requests to be let through once the first one is done, got status %d", got)
+	}
+}
+
+func TestClientConnectionLimit(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Policy: clientLimitsPolicy(t, "  max_connections: 1\n"),
+	})
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+		srv.ServeHTTP(w, r)
+	}))
+	ts.Config.ConnContext = srv.ConnContext
+	ts.Config.ConnState = srv.ConnState
+	ts.Start()
+	defer ts.Close()
+
+	// Each transport keeps its own connection open between requests.
+	get := func(tr *http.Transport) *http.Response {
+		t.Helper()
+
+		resp, err := (&http.Client{Transport: tr}).Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	first, second := &http.Transport{}, &http.Transport{}
+	defer second.CloseIdleConnections()
+
+	if resp := get(first); resp.StatusCode != http.StatusOK {
+		t.Errorf("wanted the first connection to be let through, got status %d", resp.StatusCode)
+	}
+
+	resp := get(second)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("wanted the second connection to be limited, got status %d", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Error("wanted the limited connection to be closed")
+	}
+
+	first.CloseIdleConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.connections.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if resp := get(second); resp.StatusCode != http.StatusOK {
+		t.Errorf("wanted a new connection to be let through once the others are closed, got status %d", resp.StatusCode)
+	}
+}
+
+func TestClientConnectionLimitProxyProtocol(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Policy: clientLimitsPolicy(t, "  max_connections: 1\n"),
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := &http.Server{
+		Handler:     internal.ProxyProtocolXRealIP(true, srv),
+		ConnContext: srv.ConnContext,
+		ConnState:   srv.ConnState,
+	}
+	go hs.Serve(internal.ProxyProtocolListener(ln))
+	t.Cleanup(func() { hs.Close() })
+
+	// A connection that never sends its PROXY header must not hold up
+	// the others.
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	get := func(conn net.Conn, br *bufio.Reader) int {
+		t.Helper()
+
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("wanted a response within 2s, got: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if _, err := io.WriteString(conn, "PROXY TCP4 198.51.100.1 192.0.2.1 1234 80\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+
+	first, firstReader := dial()
+	if got := get(first, firstReader); got != http.StatusOK {
+		t.Errorf("wanted the first connection to be let through, got status %d", got)
+	}
+
+	second, secondReader := dial()
+	if got := get(second, secondReader); got != http.StatusTooManyRequests {
+		t.Errorf("wanted a second connection from the same client to be limited, got status %d", got)
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// isCORSPreflight returns true if the request is a CORS preflight request.
+// Browsers never send cookies with these, so they can't pass a challenge.
+func isCORSPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// serveCORSPreflight answers a CORS preflight request from the policy's CORS
+// settings, or passes it to the target if there are none.
+func (s *Server) serveCORSPreflight(w http.ResponseWriter, r *http.Request) {
+	cors := s.policy.Load().CORS
+	if cors == nil {
+		s.forward(w, r)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+
+	wildcard := slices.Contains(cors.AllowedOrigins, "*")
+	if !wildcard && !slices.Contains(cors.AllowedOrigins, origin) {
+		// Leaving out the CORS headers makes the browser fail the request.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if wildcard {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if len(cors.AllowedMethods) != 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+	} else {
+		w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+	}
+
+	if len(cors.AllowedHeaders) != 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+
+	if cors.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if cors.MaxAge != 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
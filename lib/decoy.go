@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/internal/decoy"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+var (
+	decoyPages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_decoy_pages",
+		Help: "The number of decoy pages served to requests matched by DECOY rules, by rule",
+	}, []string{"rule"})
+
+	decoyBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anubis_decoy_bytes",
+		Help: "The number of bytes of decoy pages served to requests matched by DECOY rules",
+	})
+)
+
+// serveDecoy answers a request matched by a DECOY rule with a generated page
+// of nonsense that links to more generated pages. The page only depends on
+// the host and path of the request, so a scraper sees the same page every
+// time it comes back and can't tell the pages apart from real ones by
+// fetching them twice.
+func (s *Server) serveDecoy(w http.ResponseWriter, r *http.Request, rule *policy.Bot) {
+	lg := s.requestLogger(r)
+	settings := s.policy.Load().Decoy
+
+	name := "unknown"
+	if rule != nil {
+		name = rule.Name
+	}
+
+	s.recordReputation(r, "denied", reputationDenied)
+	s.ClearCookie(w)
+
+	page := decoy.Default().Page(r.Host+r.URL.Path, path.Dir(r.URL.Path), settings.ParagraphCount(), settings.LinkCount())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	n, err := page.Write(w)
+	decoyPages.WithLabelValues(name).Inc()
+	decoyBytes.Add(float64(n))
+	if err != nil {
+		lg.Debug("can't write decoy page", "err", err)
+		return
+	}
+
+	lg.Info("served decoy page", "bytes", n)
+}
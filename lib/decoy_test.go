@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestDecoy(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+decoy:
+  paragraphs: 2
+  links: 3
+
+bots:
+  - name: decoy-scraper
+    user_agent_regex: Scraper
+    action: DECOY
+`), "decoy.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	pagesBefore := testutil.ToFloat64(decoyPages.WithLabelValues("decoy-scraper"))
+	bytesBefore := testutil.ToFloat64(decoyBytes)
+
+	resp, body := scrape(t, ts.URL+"/blog/post")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wanted status 200, got: %d", resp.StatusCode)
+	}
+	if resp.Header.Get(ReasonHeader) != "" {
+		t.Errorf("wanted no reason code, got: %s", resp.Header.Get(ReasonHeader))
+	}
+	if got := strings.Count(body, "<p>"); got != 2 {
+		t.Errorf("wanted 2 paragraphs, got: %d", got)
+	}
+	if got := strings.Count(body, `<a href="/blog/`); got != 3 {
+		t.Errorf("wanted 3 links next to the page, got: %d in %s", got, body)
+	}
+
+	_, again := scrape(t, ts.URL+"/blog/post")
+	if again != body {
+		t.Error("wanted the same page for the same path")
+	}
+
+	if got := testutil.ToFloat64(decoyPages.WithLabelValues("decoy-scraper")) - pagesBefore; got != 2 {
+		t.Errorf("wanted 2 decoy pages counted, got: %v", got)
+	}
+	if got := testutil.ToFloat64(decoyBytes) - bytesBefore; got != float64(2*len(body)) {
+		t.Errorf("wanted %d decoy bytes counted, got: %v", 2*len(body), got)
+	}
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vale981/anubis/internal/notify"
+)
+
+// Formats that DeniedIPs can be written in.
+const (
+	DeniedIPsPlain    = "plain"
+	DeniedIPsJSON     = "json"
+	DeniedIPsNftables = "nftables"
+)
+
+var ErrUnknownDeniedIPsFormat = errors.New("lib: unknown format for denied IP addresses")
+
+// deniedHalfLife is how quickly clients are forgiven for being denied, so
+// that they drop off the list of denied IP addresses.
+const deniedHalfLife = time.Hour
+
+// recordDenied records a denied request for the dashboard and notifies the
+// webhooks about it. kind is "deny" and "tarpit" for bot rules, "dnsbl" for
+// DroneBL hits and "ip_feed" for hits of other IP feeds, and hash is the hash
+// of the rule, if any.
+func (s *Server) recordDenied(r *http.Request, kind, rule, hash string) {
+	ip := s.opts.Anonymizer.IP(r.Header.Get("X-Real-Ip"))
+	userAgent := s.opts.Anonymizer.UserAgent(r.UserAgent())
+
+	s.denied.Add(r.Header.Get("X-Real-Ip"))
+	s.stats.denied(ip, userAgent)
+	s.opts.Notifier.Notify(notify.Event{
+		Time:      time.Now(),
+		Type:      kind,
+		Rule:      rule,
+		Hash:      hash,
+		ClientIP:  ip,
+		UserAgent: userAgent,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+	})
+}
+
+// DeniedIP is a client IP address that was denied often recently.
+type DeniedIP struct {
+	IP string `json:"ip"`
+
+	// Score is about how many of its requests were denied in the last
+	// hour.
+	Score float64 `json:"score"`
+}
+
+// DeniedIPs returns the IP addresses whose score is at least threshold, the
+// most denied first. Unlike the dashboard, the addresses are not anonymized,
+// as they are meant for firewalls.
+func (s *Server) DeniedIPs(threshold float64) []DeniedIP {
+	var result []DeniedIP
+	for ip, score := range s.denied.Above(threshold) {
+		result = append(result, DeniedIP{IP: ip, Score: score})
+	}
+
+	slices.SortFunc(result, func(a, b DeniedIP) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.IP, b.IP))
+	})
+
+	return result
+}
+
+// ValidDeniedIPsFormat checks that WriteDeniedIPs knows format.
+func ValidDeniedIPsFormat(format string) error {
+	switch format {
+	case DeniedIPsPlain, DeniedIPsJSON, DeniedIPsNftables:
+		return nil
+	default:
+		return fmt.Errorf("%w, got: %q", ErrUnknownDeniedIPsFormat, format)
+	}
+}
+
+// WriteDeniedIPs writes ips to w in format:
+//
+//   - plain: one IP address per line, for tools like ipset, fail2ban-client
+//     or CDN IP lists.
+//   - json: a list of objects with the IP address and its score.
+//   - nftables: an nft script that replaces the contents of the sets
+//     denied_ipv4 and denied_ipv6 in the inet table anubis.
+func WriteDeniedIPs(w io.Writer, format string, ips []DeniedIP) error {
+	if err := ValidDeniedIPsFormat(format); err != nil {
+		return err
+	}
+
+	switch format {
+	case DeniedIPsJSON:
+		if ips == nil {
+			ips = []DeniedIP{}
+		}
+		return json.NewEncoder(w).Encode(ips)
+	case DeniedIPsNftables:
+		var v4, v6 []string
+		for _, ip := range ips {
+			addr := net.ParseIP(ip.IP)
+			switch {
+			case addr == nil:
+			case addr.To4() != nil:
+				v4 = append(v4, addr.String())
+			default:
+				v6 = append(v6, addr.String())
+			}
+		}
+
+		for _, set := range []struct {
+			name  string
+			addrs []string
+		}{{"denied_ipv4", v4}, {"denied_ipv6", v6}} {
+			if _, err := fmt.Fprintf(w, "flush set inet anubis %s\n", set.name); err != nil {
+				return err
+			}
+			if len(set.addrs) == 0 {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "add element inet anubis %s { %s }\n", set.name, strings.Join(set.addrs, ", ")); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		for _, ip := range ips {
+			if _, err := fmt.Fprintln(w, ip.IP); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestDeniedIPs(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: bad-bot
+    user_agent_regex: BadBot
+    action: DENY
+`), "denied.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	for _, client := range []struct {
+		ip, userAgent string
+		requests      int
+	}{
+		{"198.51.100.1", "BadBot/1", 3},
+		{"198.51.100.2", "BadBot/1", 1},
+		{"198.51.100.3", "Mozilla/5.0", 3},
+	} {
+		for range client.requests {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", client.ip)
+			req.Header.Set("User-Agent", client.userAgent)
+			srv.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	got := srv.DeniedIPs(2)
+	if len(got) != 1 || got[0].IP != "198.51.100.1" || got[0].Score < 2.9 {
+		t.Errorf("wanted only 198.51.100.1 with a score of about 3, got: %v", got)
+	}
+}
+
+func TestWriteDeniedIPs(t *testing.T) {
+	ips := []DeniedIP{
+		{IP: "198.51.100.1", Score: 12},
+		{IP: "2001:db8::1", Score: 11},
+		{IP: "198.51.100.2", Score: 10},
+	}
+
+	for _, tt := range []struct {
+		format string
+		ips    []DeniedIP
+		want   string
+	}{
+		{
+			format: DeniedIPsPlain,
+			ips:    ips,
+			want:   "198.51.100.1\n2001:db8::1\n198.51.100.2\n",
+		},
+		{
+			format: DeniedIPsNftables,
+			ips:    ips,
+			want: `flush set inet anubis denied_ipv4
+add element inet anubis denied_ipv4 { 198.51.100.1, 198.51.100.2 }
+flush set inet anubis denied_ipv6
+add element inet anubis denied_ipv6 { 2001:db8::1 }
+`,
+		},
+		{
+			format: DeniedIPsNftables,
+			want: `flush set inet anubis denied_ipv4
+flush set inet anubis denied_ipv6
+`,
+		},
+		{
+			format: DeniedIPsJSON,
+			ips:    ips[:1],
+			want:   `[{"ip":"198.51.100.1","score":12}]` + "\n",
+		},
+		{
+			format: DeniedIPsJSON,
+			want:   "[]\n",
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteDeniedIPs(&buf, tt.format, tt.ips); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("wanted:\n%s\ngot:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+
+	if err := WriteDeniedIPs(&bytes.Buffer{}, "iptables", ips); !errors.Is(err, ErrUnknownDeniedIPsFormat) {
+		t.Errorf("wanted ErrUnknownDeniedIPsFormat, got: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+var dryRunResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_dry_run_results",
+	Help: "The actions Anubis would have taken on requests if it wasn't in dry run mode",
+}, []string{"rule", "action"})
+
+// dryRun reports whether Anubis should only pretend to act on requests that
+// rule matches, either because the whole policy or the rule is in dry run
+// mode.
+func (s *Server) dryRun(rule *policy.Bot) bool {
+	return s.policy.Load().DryRun || (rule != nil && rule.DryRun)
+}
+
+// forwardDryRun logs and counts that Anubis would have taken action on r
+// because of rule, and sends r to the upstream. For denied requests, the log
+// includes the hash that would have been shown on the deny page.
+func (s *Server) forwardDryRun(w http.ResponseWriter, r *http.Request, cr policy.CheckResult, rule *policy.Bot) {
+	lg := s.requestLogger(r).With("check_result", cr)
+	if (cr.Rule == config.RuleDeny || cr.Rule == config.RuleTarpit) && rule != nil {
+		lg = lg.With("hash", rule.Hash())
+	}
+
+	lg.Info("dry run: would have acted on request", "action", cr.Rule)
+	dryRunResults.WithLabelValues(cr.Name, string(cr.Rule)).Inc()
+
+	r.Header.Set("X-Anubis-Dry-Run", "true")
+	s.forward(w, r)
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// Emergency reports whether emergency mode is on.
+func (s *Server) Emergency() bool {
+	return s.emergency.Load()
+}
+
+// EmergencyUntil returns when emergency mode turns off by itself, or the zero
+// time if it is off or stays on until it is turned off.
+func (s *Server) EmergencyUntil() time.Time {
+	s.emergencyLock.Lock()
+	defer s.emergencyLock.Unlock()
+
+	return s.emergencyUntil
+}
+
+// SetEmergency turns emergency mode on or off. If d is not zero, emergency
+// mode turns off by itself after d. In emergency mode, requests that no bot
+// rule has an opinion on are challenged instead of allowed, challenges are
+// harder by Options.EmergencyDifficulty, every cookie is fully checked
+// instead of only some, and new cookies are valid for
+// Options.EmergencyCookieLifetime.
+func (s *Server) SetEmergency(on bool, d time.Duration) {
+	s.emergencyLock.Lock()
+	defer s.emergencyLock.Unlock()
+
+	s.setEmergency(on, d)
+}
+
+// setEmergency does the work of SetEmergency with emergencyLock held.
+func (s *Server) setEmergency(on bool, d time.Duration) {
+	s.emergencyGeneration++
+	if s.emergencyTimer != nil {
+		s.emergencyTimer.Stop()
+		s.emergencyTimer = nil
+	}
+	s.emergencyUntil = time.Time{}
+
+	if on && d > 0 {
+		generation := s.emergencyGeneration
+		s.emergencyUntil = time.Now().Add(d)
+		s.emergencyTimer = time.AfterFunc(d, func() {
+			s.emergencyLock.Lock()
+			defer s.emergencyLock.Unlock()
+
+			// Emergency mode was set again since this timer was started.
+			if s.emergencyGeneration == generation {
+				s.setEmergency(false, 0)
+			}
+		})
+	}
+
+	if s.emergency.Swap(on) != on {
+		slog.Warn("emergency mode changed", "on", on, "until", s.emergencyUntil)
+	}
+	if on {
+		emergencyMode.Set(1)
+	} else {
+		emergencyMode.Set(0)
+	}
+}
+
+// emergencyRules returns rules with the difficulty raised for emergency
+// mode, or rules itself if emergency mode is off.
+func (s *Server) emergencyRules(rules *config.ChallengeRules) *config.ChallengeRules {
+	if !s.Emergency() {
+		return rules
+	}
+
+	return s.raisedRules(rules)
+}
+
+// raisedRules returns rules with the difficulty raised by
+// Options.EmergencyDifficulty.
+func (s *Server) raisedRules(rules *config.ChallengeRules) *config.ChallengeRules {
+	return shiftRules(rules, max(s.opts.EmergencyDifficulty, 0))
+}
+
+// acceptedRules returns rules with the band of difficulties that solutions
+// are accepted at widened to include the ones of emergency mode and of the
+// attack detector, so that solutions and cookies keep working when either
+// changes the difficulty.
+func (s *Server) acceptedRules(rules *config.ChallengeRules) *config.ChallengeRules {
+	below, above := 0, max(s.opts.EmergencyDifficulty, 0)
+	if d := s.policy.Load().AttackDetector; d != nil {
+		lowest, highest := d.Bounds()
+		below += min(lowest, 0)
+		above += max(highest, 0)
+	}
+	if rules == nil || (below == 0 && above == 0) {
+		return rules
+	}
+
+	lowest, highest := shiftRules(rules, below), shiftRules(rules, above)
+
+	accepted := *rules
+	accepted.Difficulty = lowest.Difficulty
+	accepted.MaxDifficulty = max(highest.Difficulty, highest.MaxDifficulty)
+	return &accepted
+}
+
+// issuedCookieLifetime returns how long new cookies are valid for.
+func (s *Server) issuedCookieLifetime() time.Duration {
+	if lifetime := s.opts.EmergencyCookieLifetime; s.Emergency() && lifetime > 0 {
+		return min(lifetime, cookieLifetime)
+	}
+
+	return cookieLifetime
+}
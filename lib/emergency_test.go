@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestEmergencyMode(t *testing.T) {
+	pol := loadPolicies(t, "")
+	pol.SecondaryScreening = 0
+
+	srv := spawnAnubis(t, Options{
+		Next:                    http.NewServeMux(),
+		Policy:                  pol,
+		EmergencyDifficulty:     2,
+		EmergencyCookieLifetime: time.Hour,
+	})
+
+	rule := &policy.Bot{
+		Name:      "everyone",
+		Challenge: &config.ChallengeRules{Difficulty: 4, ReportAs: 4, Algorithm: config.AlgorithmFast},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+
+	if got := srv.issueChallengeRules(req, rule).Difficulty; got != 4 {
+		t.Errorf("wanted difficulty 4 outside of emergency mode, got: %d", got)
+	}
+	if srv.secondaryScreening(rule) {
+		t.Error("wanted no secondary screening at a rate of 0")
+	}
+	if got := srv.issuedCookieLifetime(); got != cookieLifetime {
+		t.Errorf("wanted cookies valid for %s, got: %s", cookieLifetime, got)
+	}
+
+	srv.SetEmergency(true, 0)
+
+	issued := srv.issueChallengeRules(req, rule)
+	if issued.Difficulty != 6 || issued.ReportAs != 6 {
+		t.Errorf("wanted difficulty 6 in emergency mode, got: %+v", issued)
+	}
+	if !srv.secondaryScreening(rule) {
+		t.Error("wanted every cookie to be screened in emergency mode")
+	}
+	if got := srv.issuedCookieLifetime(); got != time.Hour {
+		t.Errorf("wanted cookies valid for an hour in emergency mode, got: %s", got)
+	}
+	if accepted := srv.acceptedRules(rule.Challenge); accepted.Difficulty != 4 || accepted.MaxDifficulty != 6 {
+		t.Errorf("wanted solutions accepted at difficulties 4 to 6, got: %+v", accepted)
+	}
+	if rule.Challenge.Difficulty != 4 {
+		t.Error("emergency mode changed the rule")
+	}
+
+	srv.SetEmergency(true, 50*time.Millisecond)
+	if srv.EmergencyUntil().IsZero() {
+		t.Error("wanted emergency mode to end at some point")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Emergency() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if srv.Emergency() {
+		t.Fatal("emergency mode didn't turn off by itself")
+	}
+	if !srv.EmergencyUntil().IsZero() {
+		t.Error("wanted no end of emergency mode once it is off")
+	}
+
+	// Setting emergency mode again stops the timer of the previous call.
+	srv.SetEmergency(true, 50*time.Millisecond)
+	srv.SetEmergency(true, 0)
+	time.Sleep(100 * time.Millisecond)
+	if !srv.Emergency() {
+		t.Error("emergency mode was turned off by an earlier timer")
+	}
+}
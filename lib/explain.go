@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// ExplainStep is one bot rule that was evaluated for a request.
+type ExplainStep struct {
+	Rule    string
+	Matched bool
+	// Action is the action the rule picked if it matched. It is empty if
+	// the rule's decision API had no opinion.
+	Action config.Rule
+}
+
+// Explanation describes how Anubis came to its decision about a request.
+type Explanation struct {
+	// Route is the route the request is for, if any.
+	Route  *policy.Route
+	Steps  []ExplainStep
+	Result policy.CheckResult
+	Bot    *policy.Bot
+}
+
+// Explain evaluates the policy against r like Check does, and records every
+// rule it looked at on the way. It is meant for debugging why a request got
+// the result it did.
+func (s *Server) Explain(r *http.Request) (*Explanation, error) {
+	result := &Explanation{
+		Route: s.policy.Load().Route(r),
+	}
+
+	cr, bot, err := s.evaluate(r, func(b policy.Bot, matched bool, action config.Rule) {
+		result.Steps = append(result.Steps, ExplainStep{
+			Rule:    b.Name,
+			Matched: matched,
+			Action:  action,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Result = cr
+	result.Bot = bot
+
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vale981/anubis"
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// isGRPCRequest reports whether r is a gRPC or gRPC-Web call. These clients
+// can't render HTML, so Anubis answers them with gRPC status codes instead.
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// respondGRPCError ends a gRPC call with a trailers-only response carrying
+// the given gRPC status code. gRPC clients ignore the HTTP status code, so
+// it is always 200.
+func respondGRPCError(w http.ResponseWriter, r *http.Request, code ReasonCode, message string, status int) {
+	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(ReasonHeader, string(code))
+	w.Header().Set("Grpc-Status", strconv.Itoa(status))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatusFor maps the HTTP status code Anubis would use for a response to
+// the closest gRPC status code.
+func grpcStatusFor(httpStatus int) int {
+	switch {
+	case httpStatus == http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case httpStatus == http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case httpStatus == http.StatusBadGateway, httpStatus == http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case httpStatus >= 500:
+		return grpcInternal
+	default:
+		return grpcPermissionDenied
+	}
+}
+
+// respondGRPCChallenge tells gRPC clients that they need to solve a challenge.
+func (s *Server) respondGRPCChallenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+		`Anubis realm=%q, challenge=%q, pass=%q`,
+		r.Host,
+		anubis.StaticPath+"api/make-challenge",
+		anubis.StaticPath+"api/pass-challenge",
+	))
+	respondGRPCError(w, r, ReasonChallengeRequired, "challenge required, see the WWW-Authenticate header", grpcUnauthenticated)
+}
@@ -1,10 +1,19 @@
 package lib
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+
 	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/internal/fastcgi"
 )
 
 func (s *Server) ClearCookie(w http.ResponseWriter) {
@@ -18,6 +27,90 @@ func (s *Server) ClearCookie(w http.ResponseWriter) {
 	})
 }
 
+// cookieLifetime is how long the cookie issued for a passed challenge is
+// valid for.
+const cookieLifetime = 24 * 7 * time.Hour
+
+// setTokenCookie signs claims into a JWT valid for issuedCookieLifetime from
+// now and sets it as the Anubis cookie. It returns the signed token.
+func (s *Server) setTokenCookie(w http.ResponseWriter, claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Add(-1 * time.Minute).Unix()
+	lifetime := s.issuedCookieLifetime()
+	claims["exp"] = now.Add(lifetime).Unix()
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(s.signingKey())
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:        anubis.CookieName,
+		Value:       tokenString,
+		Expires:     now.Add(lifetime),
+		SameSite:    http.SameSiteLaxMode,
+		Domain:      s.opts.CookieDomain,
+		Partitioned: s.opts.CookiePartitioned,
+		Path:        "/",
+	})
+
+	return tokenString, nil
+}
+
+// isCookieToken reports whether claims belong to a cookie issued for a
+// passed challenge or CAPTCHA, or minted with MintToken. Bypass tokens and
+// the tokens sent to targets are signed with the same key, but have a typ
+// claim and no challenge, so they can't be used as the cookie.
+func isCookieToken(claims jwt.Claims) bool {
+	mc, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	if _, ok := mc["typ"]; ok {
+		return false
+	}
+	if isMintedToken(mc) {
+		return true
+	}
+
+	challenge, _ := mc["challenge"].(string)
+	return challenge != ""
+}
+
+// renewalDue reports whether the cookie the valid token claims came from
+// expires within the renewal window. Minted cookies keep the lifetime the
+// operator gave them, and only cookies for a solved challenge are renewed.
+func (s *Server) renewalDue(claims jwt.MapClaims) bool {
+	if s.opts.CookieRenewal <= 0 || isMintedToken(claims) {
+		return false
+	}
+	if challenge, _ := claims["challenge"].(string); challenge == "" {
+		return false
+	}
+
+	exp, err := claims.GetExpirationTime()
+	return err == nil && exp != nil && time.Until(exp.Time) <= s.opts.CookieRenewal
+}
+
+// renewCookie replaces the cookie claims came from with a fresh one. Callers
+// must have checked the challenge and response in claims first, or anything
+// signed with the key would come back as a cookie valid for a week.
+func (s *Server) renewCookie(w http.ResponseWriter, r *http.Request, claims jwt.MapClaims) {
+	renewed := make(jwt.MapClaims, len(claims))
+	for k, v := range claims {
+		renewed[k] = v
+	}
+
+	if _, err := s.setTokenCookie(w, renewed); err != nil {
+		s.requestLogger(r).Error("failed to renew cookie", "err", err)
+		return
+	}
+
+	s.requestLogger(r).Debug("renewed cookie", "path", r.URL.Path)
+}
+
 // https://github.com/oauth2-proxy/oauth2-proxy/blob/master/pkg/upstream/http.go#L124
 type UnixRoundTripper struct {
 	Transport *http.Transport
@@ -33,3 +126,160 @@ func (t UnixRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.URL.Scheme = "http" // make http.Transport happy and avoid an infinite recursion
 	return t.Transport.RoundTrip(req)
 }
+
+// ProxyOptions tunes how a reverse proxy talks to its target. Fields that
+// are zero keep the defaults of http.DefaultTransport.
+type ProxyOptions struct {
+	// Proxy, if set, is the URL of the http, https, socks5 or socks5h
+	// proxy that requests to the target go through, or "direct" to not use
+	// one. Defaults to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables.
+	Proxy string
+
+	// DialTimeout is how long connecting to the target may take.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout is how long the TLS handshake with an https
+	// target may take.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout is how long the target may take to send the
+	// headers of its response once the request is sent. Without it, a
+	// slow target holds on to the client's request for as long as it
+	// likes.
+	ResponseHeaderTimeout time.Duration
+
+	// IdleConnTimeout is how long an unused connection to the target is
+	// kept open.
+	IdleConnTimeout time.Duration
+
+	// MaxIdleConns is how many unused connections are kept open, and
+	// MaxIdleConnsPerHost how many of them to the same target.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers used
+	// for each connection to the target.
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+// transport returns a clone of http.DefaultTransport tuned by o.
+func (o ProxyOptions) transport() (*http.Transport, error) {
+	transport, err := internal.OutboundTransport(o.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.DialTimeout > 0 {
+		transport.DialContext = o.dialer().DialContext
+	}
+	if o.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = o.TLSHandshakeTimeout
+	}
+	if o.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+	}
+	if o.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = o.IdleConnTimeout
+	}
+	if o.MaxIdleConns > 0 {
+		transport.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.ReadBufferSize > 0 {
+		transport.ReadBufferSize = o.ReadBufferSize
+	}
+	if o.WriteBufferSize > 0 {
+		transport.WriteBufferSize = o.WriteBufferSize
+	}
+
+	return transport, nil
+}
+
+// dialer returns a dialer with the same keep-alive as http.DefaultTransport
+// and o's dial timeout.
+func (o ProxyOptions) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   o.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// NewReverseProxy creates a reverse proxy to target, which may be an http,
+// https, h2c, unix, fastcgi, or fastcgi+unix URL.
+func NewReverseProxy(target string) (http.Handler, error) {
+	return NewReverseProxyWithOptions(target, ProxyOptions{})
+}
+
+// NewReverseProxyWithOptions creates a reverse proxy to target that talks to
+// it as set by opts.
+func NewReverseProxyWithOptions(target string, opts ProxyOptions) (http.Handler, error) {
+	targetUri, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target URL: %w", err)
+	}
+
+	if targetUri.Scheme == "fastcgi" || targetUri.Scheme == "fastcgi+unix" {
+		fp, err := fastcgi.New(targetUri)
+		if err != nil {
+			return nil, err
+		}
+		fp.DialTimeout = opts.DialTimeout
+		fp.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+		return fp, nil
+	}
+
+	transport, err := opts.transport()
+	if err != nil {
+		return nil, fmt.Errorf("can't configure the proxy to %s: %w", target, err)
+	}
+
+	// https://github.com/oauth2-proxy/oauth2-proxy/blob/4e2100a2879ef06aea1411790327019c1a09217c/pkg/upstream/http.go#L124
+	if targetUri.Scheme == "unix" {
+		// clean path up so we don't use the socket path in proxied requests
+		addr := targetUri.Path
+		targetUri.Path = ""
+		// tell transport how to dial unix sockets
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: opts.DialTimeout}
+			return dialer.DialContext(ctx, "unix", addr)
+		}
+		// tell transport how to handle the unix url scheme
+		transport.RegisterProtocol("unix", UnixRoundTripper{Transport: transport})
+	}
+
+	if targetUri.Scheme == "h2c" {
+		// Speak HTTP/2 without TLS to the target, as gRPC servers expect.
+		// Without this, the transport would fall back to HTTP/1.1.
+		targetUri.Scheme = "http"
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	rp := httputil.NewSingleHostReverseProxy(targetUri)
+	rp.Transport = transport
+	// Flush every write to the client right away so that streaming responses
+	// such as Server-Sent Events and long polling are not held in a buffer.
+	// WebSocket upgrades are handled by httputil.ReverseProxy itself.
+	rp.FlushInterval = -1
+
+	return rp, nil
+}
+
+// errorHandlerOf returns the error handler field of h if it is a reverse
+// proxy made by NewReverseProxyWithOptions or an UpstreamPool, or nil.
+func errorHandlerOf(h http.Handler) *func(http.ResponseWriter, *http.Request, error) {
+	switch h := h.(type) {
+	case *httputil.ReverseProxy:
+		return &h.ErrorHandler
+	case *fastcgi.Proxy:
+		return &h.ErrorHandler
+	case *UpstreamPool:
+		return &h.ErrorHandler
+	default:
+		return nil
+	}
+}
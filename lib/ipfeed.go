@@ -0,0 +1,307 @@
+package lib
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vale981/anubis/internal/dnsbl"
+	"github.com/vale981/anubis/internal/ipfeed"
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+var (
+	ipFeedHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_ip_feed_hits",
+		Help: "The number of requests from clients listed in an IP feed, by feed and the action taken",
+	}, []string{"feed", "action"})
+
+	ipFeedErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_ip_feed_errors",
+		Help: "The number of failed IP feed lookups, by feed",
+	}, []string{"feed"})
+)
+
+// feedKey identifies the provider of an IP feed, so that it is kept when the
+// policy is reloaded and the feed didn't change.
+type feedKey struct {
+	typ, zone, url, resolver string
+	ttl, timeout             time.Duration
+}
+
+// errLookupPending is returned by lookupIPFeed while an async lookup runs in
+// the background.
+var errLookupPending = errors.New("lib: ip feed lookup is running in the background")
+
+// feedHit is an IP feed that lists the client of a request, or that failed
+// closed because it couldn't be checked.
+type feedHit struct {
+	feed   config.IPFeed
+	result ipfeed.Result
+	failed bool
+}
+
+// feedProvider returns the provider for f, creating it on first use.
+func (s *Server) feedProvider(f config.IPFeed) ipfeed.Provider {
+	key := feedKey{
+		typ:      f.Type,
+		zone:     f.Zone,
+		url:      f.URL,
+		resolver: f.ResolverAddress(),
+		ttl:      f.TTLDuration(),
+		timeout:  f.TimeoutDuration(),
+	}
+	if p, ok := s.feeds.Load(key); ok {
+		return p.(ipfeed.Provider)
+	}
+
+	var p ipfeed.Provider
+	switch f.Type {
+	case config.IPFeedHTTP:
+		p = ipfeed.NewHTTPList(f.URL, f.TTLDuration(), &http.Client{Transport: s.opts.FeedTransport, Timeout: time.Minute})
+	default:
+		p = ipfeed.NewDNSBL(f.Zone, f.ResolverAddress(), f.TimeoutDuration())
+	}
+
+	actual, _ := s.feeds.LoadOrStore(key, p)
+	return actual.(ipfeed.Provider)
+}
+
+// lookupIPFeeds returns the first IP feed of the policy that lists the client
+// of r, or nil. Feeds that can't be checked are skipped, unless they fail
+// closed.
+func (s *Server) lookupIPFeeds(r *http.Request) *feedHit {
+	ipStr := r.Header.Get("X-Real-Ip")
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil
+	}
+
+	lg := s.requestLogger(r)
+
+	for _, f := range s.policy.Load().IPFeeds {
+		res, err := s.lookupIPFeed(r, f, ip)
+		if errors.Is(err, errLookupPending) {
+			lg.Debug("looking up ip in ip feed in the background", "feed", f.Name)
+			continue
+		}
+
+		if err != nil {
+			logFeedError(lg, f, err)
+			if f.FailMode == config.FailClosed {
+				return &feedHit{feed: f, failed: true}
+			}
+			continue
+		}
+
+		if res.Listed {
+			return &feedHit{feed: f, result: res}
+		}
+	}
+
+	return nil
+}
+
+func logFeedError(lg *slog.Logger, f config.IPFeed, err error) {
+	failMode := cmp.Or(f.FailMode, config.FailOpen)
+
+	switch {
+	case errors.Is(err, dnsbl.ErrBreakerOpen):
+		lg.Debug("ip feed lookups paused", "feed", f.Name, "fail_mode", failMode)
+	case errors.Is(err, ipfeed.ErrNotLoaded):
+		lg.Debug("ip feed not downloaded yet", "feed", f.Name, "fail_mode", failMode)
+	default:
+		lg.Error("can't look up ip in ip feed", "feed", f.Name, "fail_mode", failMode, "err", err)
+		ipFeedErrors.WithLabelValues(f.Name).Inc()
+		if f.Type == config.IPFeedDNSBL {
+			dnsblErrors.Inc()
+		}
+	}
+}
+
+// lookupIPFeed looks up ip in f. Answers of DNSBLs are cached in the store
+// for the TTL of the feed. Lists downloaded over HTTP are kept in memory
+// anyway. For async feeds, a lookup that isn't cached is started in the
+// background and errLookupPending is returned.
+func (s *Server) lookupIPFeed(r *http.Request, f config.IPFeed, ip net.IP) (ipfeed.Result, error) {
+	if f.Type != config.IPFeedDNSBL {
+		return s.queryIPFeed(r.Context(), f, ip)
+	}
+
+	cacheKey := f.Zone + ":" + ip.String()
+	if res, err := s.feedCache.Get(r.Context(), cacheKey); err == nil {
+		return res, nil
+	}
+
+	if !f.Async {
+		return s.cacheIPFeed(r.Context(), f, ip, cacheKey)
+	}
+
+	if _, running := s.feedPending.LoadOrStore(cacheKey, struct{}{}); running {
+		return ipfeed.Result{}, errLookupPending
+	}
+
+	lg := s.requestLogger(r)
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		defer s.feedPending.Delete(cacheKey)
+
+		if _, err := s.cacheIPFeed(ctx, f, ip, cacheKey); err != nil {
+			logFeedError(lg, f, err)
+		}
+	}()
+
+	return ipfeed.Result{}, errLookupPending
+}
+
+// cacheIPFeed looks up ip in the DNSBL f and caches the answer. Failures
+// aren't cached, otherwise an outage would lock clients out for the TTL of
+// the feed.
+func (s *Server) cacheIPFeed(ctx context.Context, f config.IPFeed, ip net.IP, cacheKey string) (ipfeed.Result, error) {
+	res, err := s.queryIPFeed(ctx, f, ip)
+	if err != nil {
+		return res, err
+	}
+
+	if err := s.feedCache.Set(ctx, cacheKey, res, f.TTLDuration()); err != nil {
+		slog.Error("can't cache ip feed result", "feed", f.Name, "err", err)
+	}
+
+	return res, nil
+}
+
+// queryIPFeed asks the provider of f about ip.
+func (s *Server) queryIPFeed(ctx context.Context, f config.IPFeed, ip net.IP) (ipfeed.Result, error) {
+	provider := s.feedProvider(f)
+
+	ctx, span := tracer.Start(ctx, "ipfeed.Lookup", trace.WithSpanKind(trace.SpanKindClient))
+	res, err := provider.Lookup(ctx, ip)
+	span.SetAttributes(
+		attribute.String("anubis.ip_feed", f.Name),
+		attribute.Bool("anubis.ip_feed.listed", res.Listed),
+		attribute.String("anubis.ip_feed.detail", res.Detail),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	if _, ok := provider.(*ipfeed.DNSBL); ok {
+		s.updateBreakerGauge()
+	}
+
+	if err != nil {
+		return res, err
+	}
+
+	if f.Zone == dnsbl.DroneBLZone {
+		status := dnsbl.AllGood.String()
+		if res.Listed {
+			status = res.Detail
+		}
+		droneBLHits.WithLabelValues(status).Inc()
+	}
+
+	return res, nil
+}
+
+// updateBreakerGauge sets the dnsblBreakerOpen gauge if lookups in any
+// DNSBL are paused.
+func (s *Server) updateBreakerGauge() {
+	open := 0.0
+	s.feeds.Range(func(_, p any) bool {
+		if d, ok := p.(*ipfeed.DNSBL); ok && d.Breaker.Open() {
+			open = 1
+			return false
+		}
+		return true
+	})
+	dnsblBreakerOpen.Set(open)
+}
+
+// applyIPFeeds gives requests from clients that an IP feed lists the action
+// of that feed, unless a bot rule already picked a harsher action than
+// challenging them. It returns the hit, if any.
+func (s *Server) applyIPFeeds(r *http.Request, result policy.CheckResult, rule *policy.Bot) (policy.CheckResult, *policy.Bot, *feedHit) {
+	switch result.Rule {
+	case config.RuleAllow, config.RuleChallenge, config.RuleCaptcha:
+		// IP feeds have a say
+	default:
+		return result, rule, nil
+	}
+
+	if len(s.policy.Load().IPFeeds) == 0 {
+		return result, rule, nil
+	}
+
+	hit := s.lookupIPFeeds(r)
+	if hit == nil {
+		return result, rule, nil
+	}
+
+	name := "ipfeed/" + hit.feed.Name
+	feedRule := &policy.Bot{
+		Name:   name,
+		Action: hit.feed.ActionRule(),
+	}
+	if rule != nil && rule.Challenge != nil {
+		feedRule.Challenge = rule.Challenge
+	} else {
+		feedRule.Challenge = s.defaultChallenge(r)
+	}
+
+	return cr(name, feedRule.Action), feedRule, hit
+}
+
+// defaultChallenge returns the challenge settings for requests that no bot
+// rule sets them for.
+func (s *Server) defaultChallenge(r *http.Request) *config.ChallengeRules {
+	pol := s.policy.Load()
+
+	difficulty := pol.DefaultDifficulty
+	if route := pol.Route(r); route != nil {
+		difficulty = route.DefaultDifficulty
+	}
+
+	return &config.ChallengeRules{
+		Difficulty: difficulty,
+		ReportAs:   difficulty,
+		Algorithm:  config.AlgorithmFast,
+	}
+}
+
+// denyListed denies a request from a client that an IP feed lists.
+func (s *Server) denyListed(w http.ResponseWriter, r *http.Request, hit *feedHit) {
+	lg := s.requestLogger(r)
+	s.ClearCookie(w)
+
+	loc := localization.ForRequest(r)
+	if hit.failed {
+		lg.Info("ip feed can't be checked, failing closed", "feed", hit.feed.Name)
+		s.respondWithError(w, r, ReasonIPFeedUnavailable, loc.T("ip_feed_unavailable"), http.StatusServiceUnavailable)
+		return
+	}
+
+	lg.Info("ip feed hit", "feed", hit.feed.Name, "detail", hit.result.Detail)
+	if hit.feed.Name == "dronebl" && hit.feed.Zone == dnsbl.DroneBLZone {
+		s.recordDenied(r, "dnsbl", "dnsbl", "")
+		s.respondWithError(w, r, ReasonDNSBLListed, loc.T("dronebl_listed", hit.result.Detail, r.Header.Get("X-Real-Ip")), http.StatusOK)
+		return
+	}
+
+	s.recordDenied(r, "ip_feed", hit.feed.Name, "")
+	s.respondWithError(w, r, ReasonIPFeedListed, loc.T("ip_feed_listed", hit.feed.Name), http.StatusOK)
+}
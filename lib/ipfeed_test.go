@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal/ipfeed"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestIPFeeds(t *testing.T) {
+	lists := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tor":
+			fmt.Fprintln(w, "198.51.100.1")
+		case "/drop":
+			fmt.Fprintln(w, "203.0.113.0/24 ; SBL1")
+		}
+	}))
+	defer lists.Close()
+
+	pol, err := policy.ParseConfig(strings.NewReader(fmt.Sprintf(`
+ip_feeds:
+  - name: tor
+    type: http
+    url: %[1]s/tor
+    action: CHALLENGE
+  - name: drop
+    type: http
+    url: %[1]s/drop
+
+bots:
+  - name: bad-bot
+    user_agent_regex: BadBot
+    action: DENY
+`, lists.URL)), "ip_feeds.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "OK")
+		}),
+		Policy:        pol,
+		FeedTransport: lists.Client().Transport,
+	})
+
+	for _, f := range pol.IPFeeds {
+		if err := srv.feedProvider(f).(*ipfeed.HTTPList).Refresh(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, ip, userAgent string
+		wantRule            string
+		wantAction          config.Rule
+		wantReason          ReasonCode
+	}{
+		{
+			name:       "not listed",
+			ip:         "192.0.2.1",
+			wantRule:   "default/allow",
+			wantAction: config.RuleAllow,
+		},
+		{
+			name:       "challenged by feed",
+			ip:         "198.51.100.1",
+			wantRule:   "ipfeed/tor",
+			wantAction: config.RuleChallenge,
+			wantReason: ReasonChallengeRequired,
+		},
+		{
+			name:       "denied by feed",
+			ip:         "203.0.113.9",
+			wantRule:   "ipfeed/drop",
+			wantAction: config.RuleDeny,
+			wantReason: ReasonIPFeedListed,
+		},
+		{
+			name:       "rule denies first",
+			ip:         "198.51.100.1",
+			userAgent:  "BadBot",
+			wantRule:   "bot/bad-bot",
+			wantAction: config.RuleDeny,
+			wantReason: ReasonRuleDenied,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", tt.ip)
+			req.Header.Set("User-Agent", tt.userAgent)
+
+			cr, rule, err := srv.check(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cr.Name != tt.wantRule || cr.Rule != tt.wantAction {
+				t.Errorf("wanted %s with %s, got: %s with %s", tt.wantRule, tt.wantAction, cr.Name, cr.Rule)
+			}
+			if cr.Rule == config.RuleChallenge && rule.Challenge == nil {
+				t.Error("wanted challenge settings for a challenged client")
+			}
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != tt.wantReason {
+				t.Errorf("wanted reason %q, got: %q", tt.wantReason, got)
+			}
+		})
+	}
+}
+
+// fakeFeed is an ipfeed.Provider that answers with lookup.
+type fakeFeed func(ip net.IP) (ipfeed.Result, error)
+
+func (f fakeFeed) Lookup(_ context.Context, ip net.IP) (ipfeed.Result, error) {
+	return f(ip)
+}
+
+// withFakeFeeds creates a server with a policy made of feeds, all of which are
+// answered by provider.
+func withFakeFeeds(t *testing.T, feeds string, provider ipfeed.Provider) *Server {
+	t.Helper()
+
+	pol, err := policy.ParseConfig(strings.NewReader(feeds+`
+bots:
+  - name: well-known
+    path_regex: ^/\.well-known/.*$
+    action: ALLOW
+`), "ip_feeds.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	for _, f := range pol.IPFeeds {
+		srv.feeds.Store(feedKey{
+			typ:      f.Type,
+			zone:     f.Zone,
+			url:      f.URL,
+			resolver: f.ResolverAddress(),
+			ttl:      f.TTLDuration(),
+			timeout:  f.TimeoutDuration(),
+		}, provider)
+	}
+
+	return srv
+}
+
+func TestCheckSkipsIPFeeds(t *testing.T) {
+	var lookups int
+	srv := withFakeFeeds(t, "ip_feeds:\n  - {name: zen, type: dnsbl, zone: zen.example}\n", fakeFeed(func(net.IP) (ipfeed.Result, error) {
+		lookups++
+		return ipfeed.Result{Listed: true}, nil
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+
+	cr, _, err := srv.Check(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Name != "default/allow" {
+		t.Errorf("wanted rule default/allow, got: %s", cr.Name)
+	}
+	if lookups != 0 {
+		t.Errorf("wanted no IP feed lookups, got: %d", lookups)
+	}
+}
+
+func TestIPFeedFailMode(t *testing.T) {
+	down := fakeFeed(func(net.IP) (ipfeed.Result, error) {
+		return ipfeed.Result{}, errors.New("resolver down")
+	})
+
+	for _, tt := range []struct {
+		failMode   string
+		wantRule   string
+		wantReason ReasonCode
+	}{
+		{failMode: "open", wantRule: "default/allow"},
+		{failMode: "closed", wantRule: "ipfeed/zen", wantReason: ReasonIPFeedUnavailable},
+	} {
+		t.Run(tt.failMode, func(t *testing.T) {
+			srv := withFakeFeeds(t, "ip_feeds:\n  - {name: zen, type: dnsbl, zone: zen.example, fail_mode: "+tt.failMode+"}\n", down)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+
+			cr, _, err := srv.check(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cr.Name != tt.wantRule {
+				t.Errorf("wanted rule %s, got: %s", tt.wantRule, cr.Name)
+			}
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != tt.wantReason {
+				t.Errorf("wanted reason %q, got: %q", tt.wantReason, got)
+			}
+		})
+	}
+}
+
+func TestIPFeedAsync(t *testing.T) {
+	release := make(chan struct{})
+	listed := fakeFeed(func(net.IP) (ipfeed.Result, error) {
+		<-release
+		return ipfeed.Result{Listed: true, Detail: "127.0.0.2"}, nil
+	})
+
+	srv := withFakeFeeds(t, "ip_feeds:\n  - {name: zen, type: dnsbl, zone: zen.example, async: true}\n", listed)
+
+	check := func() string {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		cr, _, err := srv.check(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cr.Name
+	}
+
+	// The lookup is still running, so requests aren't held up.
+	for range 2 {
+		if got := check(); got != "default/allow" {
+			t.Fatalf("wanted requests to pass while the lookup runs, got: %s", got)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for check() != "ipfeed/zen" {
+		if time.Now().After(deadline) {
+			t.Fatal("wanted the answer of the background lookup to apply to later requests")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vale981/anubis/internal/accesslog"
+	"github.com/vale981/anubis/internal/httpcache"
+)
+
+// TokenHeader is the request header that carries a signed token to the
+// target when Options.ForwardToken is set, so that the target can check that
+// a request really passed through Anubis.
+const TokenHeader = "X-Anubis-Token"
+
+// upstreamTokenLifetime is how long tokens in TokenHeader are valid for. They
+// are only meant to be checked while the request is being handled.
+const upstreamTokenLifetime = 5 * time.Minute
+
+// jwk is an Ed25519 public key in JSON Web Key format (RFC 8037).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// keyID returns the RFC 7638 thumbprint of pub, which stays the same for as
+// long as the key does.
+func keyID(pub ed25519.PublicKey) string {
+	x := base64.RawURLEncoding.EncodeToString(pub)
+	sum := sha256.Sum256(fmt.Appendf(nil, `{"crv":"Ed25519","kty":"OKP","x":%q}`, x))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ServeJWKS serves the public key that Anubis signs cookies and upstream
+// tokens with as a JSON Web Key Set. After the key was rotated, the previous
+// one is served after it, so that tokens signed just before still check out.
+func (s *Server) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	var keys []jwk
+	for _, pub := range s.keys.Load().public() {
+		keys = append(keys, jwk{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(pub),
+			KeyID:     keyID(pub),
+			Algorithm: "EdDSA",
+			Use:       "sig",
+		})
+	}
+
+	_ = json.NewEncoder(w).Encode(struct {
+		Keys []jwk `json:"keys"`
+	}{
+		Keys: keys,
+	})
+}
+
+// forward passes r to its target, or answers it from the response cache.
+func (s *Server) forward(w http.ResponseWriter, r *http.Request) {
+	release, ok := s.admitInFlight(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if s.useResponseCache(r) {
+		// Responses of the target of a bot rule or route must not be
+		// served to clients sent to another target, or the other way
+		// around.
+		if target := s.upstreamTarget(r); target != "" {
+			r = httpcache.WithVariant(r, target)
+		}
+		s.opts.ResponseCache.Serve(w, r, http.HandlerFunc(s.proxy))
+		return
+	}
+
+	s.proxy(w, r)
+}
+
+// proxy sends r to its target. Clients can't set TokenHeader themselves, it
+// is replaced with a freshly signed token if Options.ForwardToken is set.
+func (s *Server) proxy(w http.ResponseWriter, r *http.Request) {
+	if s.upstreamTarget(r) == "" && s.upstreamDown() {
+		s.respondUpstreamUnavailable(w, r, http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Header.Del(TokenHeader)
+
+	if s.opts.ForwardToken {
+		token, err := s.upstreamToken(r)
+		if err != nil {
+			s.requestLogger(r).Error("can't sign upstream token", "err", err)
+			s.respondWithError(w, r, ReasonInternalError, "failed to sign JWT", http.StatusInternalServerError)
+			return
+		}
+
+		r.Header.Set(TokenHeader, token)
+	}
+
+	r, span := startSpan(r, "proxy", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	start := time.Now()
+	s.nextFor(r).ServeHTTP(w, r)
+	accesslog.FromContext(r.Context()).SetUpstream(time.Since(start))
+}
+
+// upstreamType is the typ claim of tokens in TokenHeader, which sets them
+// apart from cookies signed with the same key.
+const upstreamType = "upstream"
+
+func (s *Server) upstreamToken(r *http.Request) (string, error) {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"typ":    upstreamType,
+		"iss":    "anubis",
+		"aud":    r.Host,
+		"sub":    r.Header.Get("X-Real-Ip"),
+		"iat":    now.Unix(),
+		"nbf":    now.Add(-1 * time.Minute).Unix(),
+		"exp":    now.Add(upstreamTokenLifetime).Unix(),
+		"rule":   r.Header.Get("X-Anubis-Rule"),
+		"action": r.Header.Get("X-Anubis-Action"),
+		"status": r.Header.Get("X-Anubis-Status"),
+	})
+	token.Header["kid"] = keyID(s.keys.Load().pub)
+
+	return token.SignedString(s.signingKey())
+}
@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vale981/anubis"
+)
+
+func fetchJWKSKey(t *testing.T, srv *Server) (ed25519.PublicKey, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/anubis/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wanted status %d, got: %d", http.StatusOK, rec.Code)
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("wanted one key, got: %d", len(jwks.Keys))
+	}
+
+	key := jwks.Keys[0]
+	if key.KeyType != "OKP" || key.Curve != "Ed25519" || key.Algorithm != "EdDSA" {
+		t.Errorf("wrong key type: %+v", key)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(key.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ed25519.PublicKey(x), key.KeyID
+}
+
+func TestJWKS(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+	})
+
+	pub, kid := fetchJWKSKey(t, srv)
+
+	if !pub.Equal(srv.keys.Load().pub) {
+		t.Error("JWKS key is not the signing key")
+	}
+
+	if kid == "" {
+		t.Error("key ID is empty")
+	}
+}
+
+func TestForwardToken(t *testing.T) {
+	for _, forwardToken := range []bool{true, false} {
+		t.Run(map[bool]string{true: "enabled", false: "disabled"}[forwardToken], func(t *testing.T) {
+			var got string
+			pol := loadPolicies(t, "")
+			pol.Bots = nil
+
+			srv := spawnAnubis(t, Options{
+				Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					got = r.Header.Get(TokenHeader)
+				}),
+				Policy:       pol,
+				ForwardToken: forwardToken,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "example.com"
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			req.Header.Set(TokenHeader, "forged")
+			srv.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !forwardToken {
+				if got != "" {
+					t.Errorf("client supplied token was passed through: %q", got)
+				}
+				return
+			}
+
+			pub, kid := fetchJWKSKey(t, srv)
+
+			token, err := jwt.Parse(got, func(token *jwt.Token) (any, error) {
+				if token.Header["kid"] != kid {
+					t.Errorf("wanted kid %s, got: %v", kid, token.Header["kid"])
+				}
+				return pub, nil
+			}, jwt.WithValidMethods([]string{"EdDSA"}), jwt.WithAudience("example.com"), jwt.WithIssuer("anubis"), jwt.WithExpirationRequired())
+			if err != nil {
+				t.Fatalf("can't verify token %q: %v", got, err)
+			}
+
+			claims := token.Claims.(jwt.MapClaims)
+			if claims["sub"] != "198.51.100.1" {
+				t.Errorf("wanted sub to be the client IP, got: %v", claims["sub"])
+			}
+
+			if claims["action"] != "ALLOW" {
+				t.Errorf("wanted action ALLOW, got: %v", claims["action"])
+			}
+		})
+	}
+}
+
+func TestUpstreamTokenNotACookie(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("upstream"))
+		}),
+		Policy:       loadPolicies(t, ""),
+		ForwardToken: true,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "example.com"
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	token, err := srv.upstreamToken(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: token})
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Body.String() == "upstream" {
+		t.Error("an upstream token was accepted as a cookie")
+	}
+	if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != ReasonChallengeRequired {
+		t.Errorf("wanted a challenge for an upstream token in a cookie, got reason: %q", got)
+	}
+	if strings.Contains(rec.Header().Get("Set-Cookie"), token) {
+		t.Error("an upstream token was renewed as a cookie")
+	}
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"crypto/ed25519"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var keyRotations = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "anubis_signing_key_rotations",
+	Help: "The number of times the signing key was replaced while Anubis was running",
+})
+
+// signingKeys are the keys that Anubis signs tokens with and accepts them
+// from.
+type signingKeys struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+
+	// previous is the public key that priv replaced, or nil. Tokens signed
+	// with it are accepted until the next rotation, so that clients keep
+	// their cookies.
+	previous ed25519.PublicKey
+}
+
+// public returns the public keys that tokens are accepted from, the current
+// one first.
+func (k *signingKeys) public() []ed25519.PublicKey {
+	if k.previous == nil {
+		return []ed25519.PublicKey{k.pub}
+	}
+
+	return []ed25519.PublicKey{k.pub, k.previous}
+}
+
+// signingKey returns the key that Anubis signs tokens with.
+func (s *Server) signingKey() ed25519.PrivateKey {
+	return s.keys.Load().priv
+}
+
+// SetPrivateKey replaces the key that Anubis signs tokens with, such as after
+// it was rotated in a secret store. Tokens signed with the key it replaces
+// are accepted until the next rotation. Challenges and secondary screening
+// depend on the key too, so clients may have to solve a challenge again.
+func (s *Server) SetPrivateKey(priv ed25519.PrivateKey) {
+	for {
+		old := s.keys.Load()
+		if old.priv.Equal(priv) {
+			return
+		}
+
+		keys := &signingKeys{
+			priv:     priv,
+			pub:      priv.Public().(ed25519.PublicKey),
+			previous: old.pub,
+		}
+		if s.keys.CompareAndSwap(old, keys) {
+			keyRotations.Inc()
+			return
+		}
+	}
+}
+
+// verificationKeys is a jwt.Keyfunc for tokens signed by Anubis, with either
+// the current or the previous signing key.
+func (s *Server) verificationKeys(*jwt.Token) (any, error) {
+	var set jwt.VerificationKeySet
+	for _, pub := range s.keys.Load().public() {
+		set.Keys = append(set.Keys, pub)
+	}
+
+	return set, nil
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+)
+
+func TestSetPrivateKey(t *testing.T) {
+	keys := make([]ed25519.PrivateKey, 3)
+	for i := range keys {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = priv
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:       http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Policy:     loadPolicies(t, ""),
+		PrivateKey: keys[0],
+	})
+
+	token, err := MintToken(keys[0], "198.51.100.7", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passes := func() bool {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.7")
+		req.Header.Set("User-Agent", "Mozilla/5.0 rotation")
+		req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: token})
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Header().Get(ReasonHeader) == ""
+	}
+
+	jwksKeys := func() int {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		srv.ServeJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/anubis/jwks.json", nil))
+
+		var jwks struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&jwks); err != nil {
+			t.Fatal(err)
+		}
+		return len(jwks.Keys)
+	}
+
+	if !passes() {
+		t.Fatal("cookie signed with the current key was rejected")
+	}
+
+	srv.SetPrivateKey(keys[0])
+	if n := jwksKeys(); n != 1 {
+		t.Errorf("setting the same key again rotated it, JWKS has %d keys", n)
+	}
+
+	srv.SetPrivateKey(keys[1])
+	if !passes() {
+		t.Error("cookie signed with the previous key was rejected")
+	}
+	if n := jwksKeys(); n != 2 {
+		t.Errorf("wanted the current and the previous key in the JWKS, got: %d keys", n)
+	}
+	if !srv.signingKey().Equal(keys[1]) {
+		t.Error("new key isn't used for signing")
+	}
+
+	srv.SetPrivateKey(keys[2])
+	if passes() {
+		t.Error("cookie signed two keys ago was accepted")
+	}
+}
@@ -0,0 +1,212 @@
+// Package localization translates the text of the pages Anubis renders into
+// the language a client asks for with Accept-Language.
+package localization
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	ErrInvalidLanguage = errors.New("localization: invalid language tag")
+	ErrUnknownMessage  = errors.New("localization: unknown message ID")
+)
+
+// Catalog holds the messages of every supported language. English is always
+// the first language and is used for clients that don't accept any of the
+// others and for messages a translation is missing.
+type Catalog struct {
+	tags     []language.Tag
+	messages []map[string]string
+	matcher  language.Matcher
+}
+
+// New creates a catalog from the embedded translations and extra, which maps
+// language tags to message IDs to messages. extra can add languages or
+// replace some of the messages of the embedded ones.
+func New(extra map[string]map[string]string) (*Catalog, error) {
+	c := &Catalog{}
+
+	entries, err := locales.ReadDir("locales")
+	if err != nil {
+		return nil, fmt.Errorf("[unexpected] can't read embedded translations: %w", err)
+	}
+
+	// English has to be first so the matcher falls back to it.
+	names := []string{"en.json"}
+	for _, entry := range entries {
+		if entry.Name() != "en.json" {
+			names = append(names, entry.Name())
+		}
+	}
+
+	for _, name := range names {
+		data, err := locales.ReadFile(path.Join("locales", name))
+		if err != nil {
+			return nil, fmt.Errorf("[unexpected] can't read embedded translation %s: %w", name, err)
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("[unexpected] can't parse embedded translation %s: %w", name, err)
+		}
+
+		c.tags = append(c.tags, language.Make(strings.TrimSuffix(name, ".json")))
+		c.messages = append(c.messages, messages)
+	}
+
+	var errs []error
+	for _, lang := range slices.Sorted(maps.Keys(extra)) {
+		tag, err := language.Parse(lang)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w %q: %w", ErrInvalidLanguage, lang, err))
+			continue
+		}
+
+		i := slices.Index(c.tags, tag)
+		if i == -1 {
+			c.tags = append(c.tags, tag)
+			c.messages = append(c.messages, map[string]string{})
+			i = len(c.tags) - 1
+		} else {
+			c.messages[i] = maps.Clone(c.messages[i])
+		}
+
+		for id, msg := range extra[lang] {
+			if _, ok := c.messages[0][id]; !ok {
+				errs = append(errs, fmt.Errorf("%w %q in language %s", ErrUnknownMessage, id, lang))
+				continue
+			}
+
+			c.messages[i][id] = msg
+		}
+	}
+
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	c.matcher = language.NewMatcher(c.tags)
+
+	return c, nil
+}
+
+// Default returns the catalog of embedded translations.
+var Default = sync.OnceValue(func() *Catalog {
+	c, err := New(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+})
+
+// Languages returns the tags of all languages in the catalog.
+func (c *Catalog) Languages() []string {
+	result := make([]string, len(c.tags))
+	for i, tag := range c.tags {
+		result[i] = tag.String()
+	}
+
+	return result
+}
+
+// Localizer returns a Localizer for the best language in the catalog for a
+// client that sent the Accept-Language header acceptLanguage. A nil catalog
+// uses the embedded translations.
+func (c *Catalog) Localizer(acceptLanguage string) *Localizer {
+	if c == nil {
+		c = Default()
+	}
+
+	i := 0
+	if prefs, _, err := language.ParseAcceptLanguage(acceptLanguage); err == nil && len(prefs) != 0 {
+		if _, index, confidence := c.matcher.Match(prefs...); confidence != language.No {
+			i = index
+		}
+	}
+
+	return &Localizer{
+		tag:      c.tags[i],
+		messages: c.messages[i],
+		fallback: c.messages[0],
+	}
+}
+
+// Localizer looks up messages in one language.
+type Localizer struct {
+	tag      language.Tag
+	messages map[string]string
+	fallback map[string]string
+}
+
+// Lang returns the language tag of the localizer, for the lang attribute of
+// pages.
+func (l *Localizer) Lang() string {
+	return l.tag.String()
+}
+
+// T returns the message with the given ID. If there are any args, the
+// message is used as a format string for them. Missing translations fall
+// back to English.
+func (l *Localizer) T(id string, args ...any) string {
+	msg, ok := l.messages[id]
+	if !ok {
+		msg, ok = l.fallback[id]
+	}
+	if !ok {
+		msg = id
+	}
+
+	if len(args) != 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+
+	return msg
+}
+
+// Messages returns the messages with the given IDs, for use by scripts.
+func (l *Localizer) Messages(ids ...string) map[string]string {
+	result := make(map[string]string, len(ids))
+	for _, id := range ids {
+		result[id] = l.T(id)
+	}
+
+	return result
+}
+
+type localizerKey struct{}
+
+// WithLocalizer returns a context that carries l.
+func WithLocalizer(ctx context.Context, l *Localizer) context.Context {
+	return context.WithValue(ctx, localizerKey{}, l)
+}
+
+// FromContext returns the localizer of ctx, or an English one if it doesn't
+// have one.
+func FromContext(ctx context.Context) *Localizer {
+	if l, ok := ctx.Value(localizerKey{}).(*Localizer); ok && l != nil {
+		return l
+	}
+
+	return Default().Localizer("")
+}
+
+// ForRequest returns the localizer for the response to r.
+func ForRequest(r *http.Request) *Localizer {
+	return FromContext(r.Context())
+}
@@ -0,0 +1,143 @@
+package localization
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestEmbeddedTranslationsAreComplete(t *testing.T) {
+	c := Default()
+
+	want := slices.Sorted(maps.Keys(c.messages[0]))
+	for i, messages := range c.messages[1:] {
+		if got := slices.Sorted(maps.Keys(messages)); !slices.Equal(got, want) {
+			t.Errorf("translation %s has messages %v, want: %v", c.tags[i+1], got, want)
+		}
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	c, err := New(map[string]map[string]string{
+		"nl": {"loading": "Laden..."},
+		"de": {"loading": "Einen Moment..."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		acceptLanguage string
+		lang           string
+		id             string
+		want           string
+	}{
+		{
+			name: "no_header",
+			lang: "en",
+			id:   "loading",
+			want: "Loading...",
+		},
+		{
+			name:           "unsupported_language",
+			acceptLanguage: "tlh",
+			lang:           "en",
+			id:             "loading",
+			want:           "Loading...",
+		},
+		{
+			name:           "embedded_language",
+			acceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8",
+			lang:           "fr",
+			id:             "go_home",
+			want:           "Retour à l'accueil",
+		},
+		{
+			name:           "quality_order",
+			acceptLanguage: "es;q=0.5, fr;q=0.9",
+			lang:           "fr",
+			id:             "go_home",
+			want:           "Retour à l'accueil",
+		},
+		{
+			name:           "overridden_message",
+			acceptLanguage: "de-DE",
+			lang:           "de",
+			id:             "loading",
+			want:           "Einen Moment...",
+		},
+		{
+			name:           "added_language",
+			acceptLanguage: "nl",
+			lang:           "nl",
+			id:             "loading",
+			want:           "Laden...",
+		},
+		{
+			name:           "missing_translation_falls_back_to_english",
+			acceptLanguage: "nl",
+			lang:           "nl",
+			id:             "go_home",
+			want:           "Go home",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l := c.Localizer(tt.acceptLanguage)
+
+			if got := l.Lang(); got != tt.lang {
+				t.Errorf("wanted language %s, got: %s", tt.lang, got)
+			}
+
+			if got := l.T(tt.id); got != tt.want {
+				t.Errorf("wanted %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	l := Default().Localizer("en")
+
+	if got, want := l.T("rate_limited", 5), "Too many requests, please try again in 5 seconds."; got != want {
+		t.Errorf("wanted %q, got: %q", want, got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		extra map[string]map[string]string
+		err   error
+	}{
+		{
+			name:  "invalid_language",
+			extra: map[string]map[string]string{"not a language": {"loading": "..."}},
+			err:   ErrInvalidLanguage,
+		},
+		{
+			name:  "unknown_message",
+			extra: map[string]map[string]string{"nl": {"no_such_message": "..."}},
+			err:   ErrUnknownMessage,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.extra); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if got := FromContext(context.Background()).Lang(); got != "en" {
+		t.Errorf("context without a localizer should be English, got: %s", got)
+	}
+
+	ctx := WithLocalizer(context.Background(), Default().Localizer("de"))
+	if got := FromContext(ctx).Lang(); got != "de" {
+		t.Errorf("wanted the localizer of the context, got: %s", got)
+	}
+}
@@ -0,0 +1,18 @@
+package lib
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// requestLogger returns a logger annotated with details about the client,
+// anonymized if the administrator asked for it.
+func (s *Server) requestLogger(r *http.Request) *slog.Logger {
+	return slog.With(
+		"user_agent", s.opts.Anonymizer.UserAgent(r.UserAgent()),
+		"accept_language", r.Header.Get("Accept-Language"),
+		"priority", r.Header.Get("Priority"),
+		"x-forwarded-for", s.opts.Anonymizer.IP(r.Header.Get("X-Forwarded-For")),
+		"x-real-ip", s.opts.Anonymizer.IP(r.Header.Get("X-Real-Ip")),
+	)
+}
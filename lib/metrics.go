@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vale981/anubis/lib/policy"
+)
+
+var decayMapEntriesDesc = prometheus.NewDesc(
+	"anubis_decaymap_entries",
+	"The number of entries in Anubis' in-memory maps, including expired entries that haven't been cleaned up yet",
+	[]string{"map"}, nil,
+)
+
+// Collector returns a Prometheus collector for the sizes of the server's
+// in-memory maps. Unlike the other metrics, it is not registered
+// automatically because it belongs to one server.
+func (s *Server) Collector() prometheus.Collector {
+	return serverCollector{s}
+}
+
+type serverCollector struct {
+	s *Server
+}
+
+func (c serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- decayMapEntriesDesc
+}
+
+func (c serverCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, size := range c.s.CacheSizes() {
+		ch <- prometheus.MustNewConstMetric(decayMapEntriesDesc, prometheus.GaugeValue, float64(size), name)
+	}
+}
+
+// reportedDifficulty is the difficulty a solved challenge counts as in
+// metrics. Rules that report their real difficulty report the difficulty the
+// challenge was issued at, which may have been escalated.
+func reportedDifficulty(rule *policy.Bot, issued int) int {
+	if rule.Challenge.ReportAs == rule.Challenge.Difficulty {
+		return issued
+	}
+
+	return rule.Challenge.ReportAs
+}
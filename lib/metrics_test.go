@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/lib/store"
+)
+
+func TestCollector(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+		Store:  store.NewMemory(),
+	})
+	srv.requestVelocity.Add("198.51.100.1")
+	srv.requestVelocity.Add("198.51.100.2")
+
+	want := `
+# HELP anubis_decaymap_entries The number of entries in Anubis' in-memory maps, including expired entries that haven't been cleaned up yet
+# TYPE anubis_decaymap_entries gauge
+anubis_decaymap_entries{map="challenge_failures"} 0
+anubis_decaymap_entries{map="challenge_velocity"} 0
+anubis_decaymap_entries{map="denied_ips"} 0
+anubis_decaymap_entries{map="request_velocity"} 2
+anubis_decaymap_entries{map="store"} 0
+`
+	if err := testutil.CollectAndCompare(srv.Collector(), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReportedDifficulty(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		rules    config.ChallengeRules
+		issued   int
+		expected int
+	}{
+		{
+			name:     "real_difficulty",
+			rules:    config.ChallengeRules{Difficulty: 4, ReportAs: 4},
+			issued:   6,
+			expected: 6,
+		},
+		{
+			name:     "reported_difficulty",
+			rules:    config.ChallengeRules{Difficulty: 1, ReportAs: 4},
+			issued:   3,
+			expected: 4,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &policy.Bot{Name: tt.name, Challenge: &tt.rules}
+			if got := reportedDifficulty(rule, tt.issued); got != tt.expected {
+				t.Errorf("wanted difficulty %d, got: %d", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// mintedMethod is the method claim of cookies minted by an operator,
+	// which don't come from a solved challenge.
+	mintedMethod = "minted"
+
+	// MaxMintedLifetime is how long a minted cookie can be valid for.
+	MaxMintedLifetime = 90 * 24 * time.Hour
+)
+
+var (
+	ErrMintLifetime = errors.New("lib: minted cookie lifetime must be between one minute and 90 days")
+	ErrMintIP       = errors.New("lib: minted cookie needs the IP address of the client it is for")
+)
+
+// MintToken returns a cookie value signed with priv, the signing key of
+// Anubis, for the client at ip. Operators hand these out to people who can't
+// pass challenges, such as users of unusual browsers. Minted cookies pass
+// every rule, including secondary screening and CAPTCHA rules, are bound to
+// ip like any other cookie and aren't renewed.
+func MintToken(priv ed25519.PrivateKey, ip string, lifetime time.Duration) (string, error) {
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("%w, got: %q", ErrMintIP, ip)
+	}
+	if lifetime < time.Minute || lifetime > MaxMintedLifetime {
+		return "", fmt.Errorf("%w, got: %s", ErrMintLifetime, lifetime)
+	}
+
+	now := time.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"method": mintedMethod,
+		"ip":     ip,
+		"iat":    now.Unix(),
+		"nbf":    now.Add(-1 * time.Minute).Unix(),
+		"exp":    now.Add(lifetime).Unix(),
+	}).SignedString(priv)
+}
+
+// isMintedToken reports whether claims belong to a cookie minted with
+// MintToken.
+func isMintedToken(claims jwt.MapClaims) bool {
+	method, _ := claims["method"].(string)
+	return method == mintedMethod
+}
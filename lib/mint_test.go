@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestMintToken(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pol := loadPolicies(t, "")
+	pol.SecondaryScreening = 1
+	pol.TokenBinding = config.TokenBindingAddress
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Header.Get("X-Anubis-Status"))
+		}),
+		Policy:     pol,
+		PrivateKey: priv,
+	})
+
+	token, err := MintToken(priv, "198.51.100.7", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		ip         string
+		wantReason ReasonCode
+		wantBody   string
+	}{
+		{name: "same address", ip: "198.51.100.7", wantBody: "PASS-MINTED"},
+		{name: "other address", ip: "203.0.113.7", wantReason: ReasonChallengeRequired},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", tt.ip)
+			req.Header.Set("User-Agent", "Mozilla/5.0 obscure-browser")
+			req.AddCookie(&http.Cookie{Name: anubis.CookieName, Value: token})
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != tt.wantReason {
+				t.Errorf("wanted reason %q, got: %q", tt.wantReason, got)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("wanted body %q, got: %q", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name     string
+		ip       string
+		lifetime time.Duration
+		want     error
+	}{
+		{name: "no ip", lifetime: time.Hour, want: ErrMintIP},
+		{name: "bad ip", ip: "example.com", lifetime: time.Hour, want: ErrMintIP},
+		{name: "too short", ip: "198.51.100.7", lifetime: time.Second, want: ErrMintLifetime},
+		{name: "too long", ip: "198.51.100.7", lifetime: MaxMintedLifetime + time.Hour, want: ErrMintLifetime},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MintToken(priv, tt.ip, tt.lifetime); !errors.Is(err, tt.want) {
+				t.Errorf("wanted error %v, got: %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/a-h/templ"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/web"
+)
+
+// The nojs algorithm lets clients without JavaScript in after waiting. The
+// difficulty is the number of seconds to wait, the nonce is the Unix time the
+// challenge was issued at, and the response is a MAC over both that only
+// Anubis can compute. The client is handed all of them and sent to
+// pass-challenge with a refresh once the time is up.
+
+// noJSPassURL returns the pass-challenge URL a client without JavaScript is
+// sent to once it has waited for rules.Difficulty seconds.
+func (s *Server) noJSPassURL(r *http.Request, rules *config.ChallengeRules, challenge string, issued time.Time) string {
+	nonce := int(issued.Unix())
+
+	q := url.Values{}
+	q.Set("challenge", challenge)
+	q.Set("response", s.responseFor(config.AlgorithmNoJS, challenge, nonce))
+	q.Set("nonce", strconv.Itoa(nonce))
+	q.Set("redir", r.URL.RequestURI())
+	q.Set("elapsedTime", strconv.Itoa(rules.Difficulty*1000))
+
+	return anubis.StaticPath + "api/pass-challenge?" + q.Encode()
+}
+
+// renderNoJSChallenge serves the waiting page for the nojs algorithm.
+func (s *Server) renderNoJSChallenge(w http.ResponseWriter, r *http.Request, rules *config.ChallengeRules, challenge string, ogTags map[string]string) {
+	passURL := s.noJSPassURL(r, rules, challenge, time.Now())
+	refresh := fmt.Sprintf("%d; url=%s", rules.Difficulty, passURL)
+
+	w.Header().Set("Refresh", refresh)
+	internal.NoStoreCache(templ.Handler(
+		web.BaseWithRefreshAndOGTags(s.challengeTitle(r), web.NoJSIndex(passURL, rules.Difficulty), refresh, ogTags),
+	)).ServeHTTP(w, r)
+}
+
+// noJSWaitLeft returns how much longer a client that was issued a nojs
+// challenge at the Unix time nonce has to wait for difficulty seconds.
+func noJSWaitLeft(nonce, difficulty int) time.Duration {
+	return max(0, time.Until(time.Unix(int64(nonce), 0).Add(time.Duration(difficulty)*time.Second)))
+}
+
+// respondTooEarly tells a client that it came back before it waited long
+// enough.
+func (s *Server) respondTooEarly(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	s.respondWithError(w, r, ReasonChallengeTooEarly, localization.ForRequest(r).T("too_early", seconds), http.StatusForbidden)
+}
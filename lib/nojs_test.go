@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestNoJSChallenge(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+    challenge:
+      difficulty: 5
+      report_as: 5
+      algorithm: nojs
+`), "nojs.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/some/page")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wanted status %d, got: %d", http.StatusOK, rec.Code)
+	}
+
+	refresh := rec.Header().Get("Refresh")
+	passURL, ok := strings.CutPrefix(refresh, "5; url=")
+	if !ok {
+		t.Fatalf("wanted a refresh after 5 seconds, got: %q", refresh)
+	}
+
+	if !strings.Contains(rec.Body.String(), `http-equiv="refresh"`) {
+		t.Error("the page should have a refresh meta tag for browsers that ignore the header")
+	}
+
+	rec = get(passURL)
+	if rec.Code != http.StatusForbidden || rec.Header().Get(ReasonHeader) != string(ReasonChallengeTooEarly) {
+		t.Errorf("coming back right away should fail with %s, got: %d %s", ReasonChallengeTooEarly, rec.Code, rec.Header().Get(ReasonHeader))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/some/page", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	waited := srv.noJSPassURL(req, pol.Bots[0].Challenge, srv.challengeFor(req, 5), time.Now().Add(-6*time.Second))
+
+	rec = get(strings.Replace(waited, "response=", "response=0", 1))
+	if rec.Code != http.StatusForbidden || rec.Header().Get(ReasonHeader) != string(ReasonInvalidResponse) {
+		t.Errorf("a forged response should fail with %s, got: %d %s", ReasonInvalidResponse, rec.Code, rec.Header().Get(ReasonHeader))
+	}
+
+	rec = get(waited)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("wanted status %d after waiting, got: %d", http.StatusFound, rec.Code)
+	}
+
+	if loc := rec.Header().Get("Location"); loc != "/some/page" {
+		t.Errorf("wanted to be sent back to /some/page, got: %q", loc)
+	}
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vale981/anubis/internal/ogtags"
+)
+
+// oEmbedPath is where Anubis serves the oEmbed representation of pages when
+// Options.OGOEmbed is set. Links to it replace the oEmbed links of pages in
+// the challenge page, so that link previews work without passing it.
+const oEmbedPath = "/.within.website/x/cmd/anubis/api/oembed"
+
+// ServeOEmbed serves the oEmbed representation of the page at the path in the
+// url query parameter, as the target serves it.
+func (s *Server) ServeOEmbed(w http.ResponseWriter, r *http.Request) {
+	if !s.opts.OGPassthrough || !s.opts.OGOEmbed {
+		http.NotFound(w, r)
+		return
+	}
+
+	page, err := url.Parse(r.FormValue("url"))
+	if err != nil || page.Scheme != "" || page.Host != "" || !strings.HasPrefix(page.Path, "/") {
+		http.Error(w, "url must be the path of a page", http.StatusBadRequest)
+		return
+	}
+	page.Host = r.Host
+
+	data, err := s.OGTags.GetOEmbed(page)
+	switch {
+	case errors.Is(err, ogtags.ErrNoOEmbed):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		s.requestLogger(r).Error("can't get oEmbed", "path", page.Path, "err", err)
+		http.Error(w, "can't get oEmbed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(data)
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeOEmbed(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/post":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><head><link rel="alternate" type="application/json+oembed" href="/oembed?url=%2Fpost"></head></html>`))
+		case "/oembed":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"version":"1.0","type":"link","title":"Post"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(target.Close)
+
+	for _, tt := range []struct {
+		name   string
+		oEmbed bool
+		url    string
+		status int
+	}{
+		{name: "page", oEmbed: true, url: "/post", status: http.StatusOK},
+		{name: "page without oEmbed", oEmbed: true, url: "/other", status: http.StatusNotFound},
+		{name: "absolute URL", oEmbed: true, url: "http://evil.example/post", status: http.StatusBadRequest},
+		{name: "turned off", url: "/post", status: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := spawnAnubis(t, Options{
+				Next:          http.NewServeMux(),
+				Policy:        loadPolicies(t, ""),
+				Target:        target.URL,
+				OGPassthrough: true,
+				OGOEmbed:      tt.oEmbed,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, oEmbedPath+"?url="+tt.url, nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("wanted status %d, got: %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"title":"Post"`) {
+				t.Errorf("wanted the oEmbed of the page, got: %s", rec.Body.String())
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package policy
 
 import (
 	"fmt"
+	"text/template"
 
 	"github.com/vale981/anubis/internal"
 	"github.com/vale981/anubis/lib/policy/config"
@@ -12,8 +13,36 @@ type Bot struct {
 	Action    config.Rule
 	Challenge *config.ChallengeRules
 	Rules     Checker
+
+	// Decision, if set, picks the action for requests matching Rules.
+	Decision *DecisionAPI
+
+	// DryRun makes Anubis only log and count what the rule would do.
+	DryRun bool
+
+	// SecondaryScreening, if set, overrides the policy's secondary screening
+	// rate for requests matching the rule.
+	SecondaryScreening *config.ScreeningRate
+
+	// Redirect sets where REDIRECT rules send clients.
+	Redirect *config.Redirect
+
+	// Response is what STATUS rules send back, or how DENY rules deny
+	// requests.
+	Response *config.Response
+
+	// ResponseBody is the parsed body template of Response, if it has one.
+	ResponseBody *template.Template
+
+	// Target, if set, is the upstream that requests matching the rule are
+	// sent to.
+	Target string
 }
 
 func (b Bot) Hash() string {
+	if b.Decision != nil {
+		return internal.SHA256sum(fmt.Sprintf("%s::%s::%s", b.Name, b.Rules.Hash(), b.Decision.Hash()))
+	}
+
 	return internal.SHA256sum(fmt.Sprintf("%s::%s", b.Name, b.Rules.Hash()))
 }
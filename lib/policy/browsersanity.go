@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vale981/anubis/internal"
+)
+
+// chromeVersion extracts the major version from Chromium-based user agents.
+var chromeVersion = regexp.MustCompile(`Chrome/(\d+)\.`)
+
+// browserSanityHeuristic is one thing browsers reliably do. If a client that
+// claims to be a browser doesn't do it, penalty is taken off its score.
+type browserSanityHeuristic struct {
+	reason  string
+	penalty int
+	failed  func(r *http.Request, ua string) bool
+}
+
+var browserSanityHeuristics = []browserSanityHeuristic{
+	{
+		reason:  "browser user agent over HTTP/1.0",
+		penalty: 40,
+		failed: func(r *http.Request, _ string) bool {
+			return r.ProtoMajor == 1 && r.ProtoMinor == 0
+		},
+	},
+	{
+		reason:  "no Accept-Language header",
+		penalty: 30,
+		failed: func(r *http.Request, _ string) bool {
+			return r.Header.Get("Accept-Language") == ""
+		},
+	},
+	{
+		// Chromium has sent client hints since version 89, but only to
+		// sites served over HTTPS.
+		reason:  "Chrome user agent without Sec-CH-UA header",
+		penalty: 30,
+		failed: func(r *http.Request, ua string) bool {
+			m := chromeVersion.FindStringSubmatch(ua)
+			if m == nil {
+				return false
+			}
+
+			version, err := strconv.Atoi(m[1])
+			if err != nil || version < 89 {
+				return false
+			}
+
+			return r.Header.Get("X-Forwarded-Proto") != "http" && r.Header.Get("Sec-Ch-Ua") == ""
+		},
+	},
+	{
+		reason:  "no Accept-Encoding header",
+		penalty: 20,
+		failed: func(r *http.Request, _ string) bool {
+			return r.Header.Get("Accept-Encoding") == ""
+		},
+	},
+	{
+		reason:  "no Accept header",
+		penalty: 20,
+		failed: func(r *http.Request, _ string) bool {
+			return r.Header.Get("Accept") == ""
+		},
+	},
+}
+
+// browserSanityScore scores how much r looks like it was sent by the browser
+// its user agent claims to be, from 0 to 100. Clients that don't claim to be
+// a browser always score 100. The reasons for any penalties are returned
+// along with the score.
+func browserSanityScore(r *http.Request) (int, []string) {
+	ua := r.UserAgent()
+	if !strings.HasPrefix(ua, "Mozilla/") {
+		return 100, nil
+	}
+
+	score := 100
+	var reasons []string
+
+	for _, h := range browserSanityHeuristics {
+		if h.failed(r, ua) {
+			score -= h.penalty
+			reasons = append(reasons, h.reason)
+		}
+	}
+
+	return max(score, 0), reasons
+}
+
+// BrowserSanityChecker matches requests with a browser sanity score below
+// the threshold.
+type BrowserSanityChecker struct {
+	threshold int
+}
+
+func NewBrowserSanityChecker(threshold int) Checker {
+	return BrowserSanityChecker{threshold}
+}
+
+func (bsc BrowserSanityChecker) Check(r *http.Request) (bool, error) {
+	score, _ := browserSanityScore(r)
+	return score < bsc.threshold, nil
+}
+
+func (bsc BrowserSanityChecker) Hash() string {
+	return internal.SHA256sum(fmt.Sprintf("browser_sanity: %d", bsc.threshold))
+}
@@ -6,9 +6,12 @@ import (
 	"net"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/google/cel-go/cel"
 	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy/expressions"
 	"github.com/yl2chen/cidranger"
 )
 
@@ -118,6 +121,10 @@ func NewHeaderMatchesChecker(header, rexStr string) (Checker, error) {
 }
 
 func (hmc *HeaderMatchesChecker) Check(r *http.Request) (bool, error) {
+	if ok, found := matchRegexSet(r, hmc.regexp); found {
+		return ok, nil
+	}
+
 	if hmc.regexp.MatchString(r.Header.Get(hmc.header)) {
 		return true, nil
 	}
@@ -143,6 +150,10 @@ func NewPathChecker(rexStr string) (Checker, error) {
 }
 
 func (pc *PathChecker) Check(r *http.Request) (bool, error) {
+	if ok, found := matchRegexSet(r, pc.regexp); found {
+		return ok, nil
+	}
+
 	if pc.regexp.MatchString(r.URL.Path) {
 		return true, nil
 	}
@@ -199,3 +210,185 @@ func NewHeadersChecker(headermap map[string]string) (Checker, error) {
 
 	return result, nil
 }
+
+// NewHeadersMissingChecker matches requests that don't set at least one of
+// the given headers. Headers with an empty value count as missing.
+func NewHeadersMissingChecker(headers []string) Checker {
+	var result CheckerList
+
+	for _, key := range headers {
+		result = append(result, headerMissingChecker{key})
+	}
+
+	return result
+}
+
+type headerMissingChecker struct {
+	header string
+}
+
+func (hmc headerMissingChecker) Check(r *http.Request) (bool, error) {
+	return r.Header.Get(hmc.header) == "", nil
+}
+
+func (hmc headerMissingChecker) Hash() string {
+	return internal.SHA256sum("missing: " + hmc.header)
+}
+
+// NewHeadersEqualChecker matches requests where at least one of the given
+// headers is exactly equal to one of its listed values.
+func NewHeadersEqualChecker(headermap map[string][]string) Checker {
+	var result CheckerList
+
+	keys := make([]string, 0, len(headermap))
+	for key := range headermap {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		result = append(result, &HeaderEqualsChecker{
+			header: key,
+			values: headermap[key],
+			hash:   internal.SHA256sum(key + " in " + strings.Join(headermap[key], "\x00")),
+		})
+	}
+
+	return result
+}
+
+type HeaderEqualsChecker struct {
+	header string
+	values []string
+	hash   string
+}
+
+func (hec *HeaderEqualsChecker) Check(r *http.Request) (bool, error) {
+	return slices.Contains(hec.values, r.Header.Get(hec.header)), nil
+}
+
+func (hec *HeaderEqualsChecker) Hash() string {
+	return hec.hash
+}
+
+// MethodChecker matches requests that use one of the given HTTP methods.
+type MethodChecker struct {
+	methods []string
+	hash    string
+}
+
+func NewMethodChecker(methods []string) Checker {
+	return &MethodChecker{
+		methods: methods,
+		hash:    internal.SHA256sum("methods: " + strings.Join(methods, ",")),
+	}
+}
+
+func (mc *MethodChecker) Check(r *http.Request) (bool, error) {
+	return slices.Contains(mc.methods, r.Method), nil
+}
+
+func (mc *MethodChecker) Hash() string {
+	return mc.hash
+}
+
+// NewQueryChecker matches requests where at least one of the given query
+// parameters matches its regex. The regex ".*" matches if the parameter is
+// set at all, even without a value.
+func NewQueryChecker(querymap map[string]string) (Checker, error) {
+	var result CheckerList
+	var errs []error
+
+	keys := make([]string, 0, len(querymap))
+	for key := range querymap {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		rexStr := querymap[key]
+		if rexStr == ".*" {
+			result = append(result, queryExistsChecker{key})
+			continue
+		}
+
+		rex, err := regexp.Compile(rexStr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("while compiling query parameter %s regex %s: %w", key, rexStr, err))
+			continue
+		}
+
+		result = append(result, &QueryMatchesChecker{key, rex, internal.SHA256sum("?" + key + "=" + rexStr)})
+	}
+
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return result, nil
+}
+
+type queryExistsChecker struct {
+	param string
+}
+
+func (qec queryExistsChecker) Check(r *http.Request) (bool, error) {
+	return r.URL.Query().Has(qec.param), nil
+}
+
+func (qec queryExistsChecker) Hash() string {
+	return internal.SHA256sum("?" + qec.param)
+}
+
+// QueryMatchesChecker matches requests where the first value of a query
+// parameter matches a regex.
+type QueryMatchesChecker struct {
+	param  string
+	regexp *regexp.Regexp
+	hash   string
+}
+
+func (qmc *QueryMatchesChecker) Check(r *http.Request) (bool, error) {
+	query := r.URL.Query()
+	if !query.Has(qmc.param) {
+		return false, nil
+	}
+
+	return qmc.regexp.MatchString(query.Get(qmc.param)), nil
+}
+
+func (qmc *QueryMatchesChecker) Hash() string {
+	return qmc.hash
+}
+
+type ExpressionChecker struct {
+	program cel.Program
+	hash    string
+}
+
+func NewExpressionChecker(src string) (Checker, error) {
+	program, err := expressions.Compile(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: expression %s failed to compile: %w", ErrMisconfiguration, src, err)
+	}
+
+	return &ExpressionChecker{program, internal.SHA256sum(src)}, nil
+}
+
+func (ec *ExpressionChecker) Check(r *http.Request) (bool, error) {
+	val, _, err := ec.program.Eval(expressions.Activation(r))
+	if err != nil {
+		return false, fmt.Errorf("can't evaluate expression: %w", err)
+	}
+
+	match, ok := val.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: expression returned %T, not bool", ErrMisconfiguration, val.Value())
+	}
+
+	return match, nil
+}
+
+func (ec *ExpressionChecker) Hash() string {
+	return ec.hash
+}
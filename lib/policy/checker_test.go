@@ -1,9 +1,24 @@
 package policy
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy/config"
 )
 
 func TestRemoteAddrChecker(t *testing.T) {
@@ -198,3 +213,869 @@ func TestHeaderExistsChecker(t *testing.T) {
 		})
 	}
 }
+
+func TestHeadersMissingChecker(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		headers []string
+		reqHdrs map[string]string
+		ok      bool
+	}{
+		{
+			name:    "missing",
+			headers: []string{"Sec-Fetch-Mode"},
+			reqHdrs: map[string]string{"Accept": "text/html"},
+			ok:      true,
+		},
+		{
+			name:    "present",
+			headers: []string{"Sec-Fetch-Mode"},
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": "navigate"},
+		},
+		{
+			name:    "empty_counts_as_missing",
+			headers: []string{"Sec-Fetch-Mode"},
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": ""},
+			ok:      true,
+		},
+		{
+			name:    "one_of_several_missing",
+			headers: []string{"Sec-Fetch-Mode", "Accept-Language"},
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": "navigate"},
+			ok:      true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hmc := NewHeadersMissingChecker(tt.headers)
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			for k, v := range tt.reqHdrs {
+				r.Header.Set(k, v)
+			}
+
+			ok, err := hmc.Check(r)
+			if err != nil {
+				t.Errorf("err: %v", err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHeadersEqualChecker(t *testing.T) {
+	hec := NewHeadersEqualChecker(map[string][]string{
+		"Sec-Fetch-Mode": {"navigate", "no-cors"},
+		"Via":            {"1.1 cdn.example"},
+	})
+
+	for _, tt := range []struct {
+		name    string
+		reqHdrs map[string]string
+		ok      bool
+	}{
+		{
+			name:    "equal",
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": "navigate"},
+			ok:      true,
+		},
+		{
+			name:    "other_header_equal",
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": "cors", "Via": "1.1 cdn.example"},
+			ok:      true,
+		},
+		{
+			name:    "not_equal",
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": "cors"},
+		},
+		{
+			name:    "case_sensitive",
+			reqHdrs: map[string]string{"Sec-Fetch-Mode": "Navigate"},
+		},
+		{
+			name: "missing",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			for k, v := range tt.reqHdrs {
+				r.Header.Set(k, v)
+			}
+
+			ok, err := hec.Check(r)
+			if err != nil {
+				t.Errorf("err: %v", err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+
+	other := NewHeadersEqualChecker(map[string][]string{
+		"Via":            {"1.1 cdn.example"},
+		"Sec-Fetch-Mode": {"navigate", "no-cors"},
+	})
+	if hec.Hash() != other.Hash() {
+		t.Error("hash depends on the order of headers")
+	}
+}
+
+func TestExpressionChecker(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		expr    string
+		path    string
+		headers map[string]string
+		ok      bool
+		err     error
+	}{
+		{
+			name:    "match_header_and_path",
+			expr:    `path.startsWith("/api/") && headers["Accept"] == "application/json"`,
+			path:    "/api/users",
+			headers: map[string]string{"Accept": "application/json"},
+			ok:      true,
+		},
+		{
+			name: "not_match",
+			expr: `path.startsWith("/api/")`,
+			path: "/",
+			ok:   false,
+		},
+		{
+			name: "query",
+			expr: `"token" in query && remoteAddress == "1.1.1.1"`,
+			path: "/?token=hunter2",
+			ok:   true,
+		},
+		{
+			name: "invalid_syntax",
+			expr: `path.startsWith(`,
+			err:  ErrMisconfiguration,
+		},
+		{
+			name: "not_bool",
+			expr: `path`,
+			err:  ErrMisconfiguration,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ec, err := NewExpressionChecker(tt.expr)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err: %v, wanted: %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("creating ExpressionChecker failed: %v", err)
+			}
+
+			r, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			r.Header.Set("X-Real-Ip", "1.1.1.1")
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			ok, err := ec.Check(r)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCountryChecker(t *testing.T) {
+	db, err := OpenGeoIPDatabase("testdata/geoip-country.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := NewCountryChecker(db, []string{"de", "JP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ip   string
+		ok   bool
+		err  error
+	}{
+		{name: "match_ipv4", ip: "192.0.2.1", ok: true},
+		{name: "match_ipv6", ip: "2001:db8::1", ok: true},
+		{name: "other_country", ip: "198.51.100.1", ok: false},
+		{name: "not_in_database", ip: "203.0.113.1", ok: false},
+		{name: "no_ip_set", err: ErrMisconfiguration},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			if tt.ip != "" {
+				r.Header.Add("X-Real-Ip", tt.ip)
+			}
+
+			ok, err := cc.Check(r)
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err: %v, wanted: %v", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := NewCountryChecker(nil, []string{"DE"}); !errors.Is(err, ErrMisconfiguration) {
+		t.Errorf("wanted ErrMisconfiguration without a database, got: %v", err)
+	}
+}
+
+type fakeResolver struct {
+	addrs   map[string][]string
+	hosts   map[string][]string
+	lookups int
+}
+
+func (fr *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	fr.lookups++
+	names, ok := fr.addrs[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (fr *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := fr.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	var result []net.IPAddr
+	for _, ip := range ips {
+		result = append(result, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return result, nil
+}
+
+func TestReverseDNSChecker(t *testing.T) {
+	claim, err := NewUserAgentChecker("Googlebot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		userAgent string
+		ip        string
+		ok        bool
+		lookups   int
+	}{
+		{name: "verified", userAgent: "Googlebot/2.1", ip: "66.249.66.1", ok: true, lookups: 1},
+		{name: "verified_ipv6", userAgent: "Googlebot/2.1", ip: "2001:4860:4801:10::1", ok: true, lookups: 1},
+		{name: "not_claiming", userAgent: "Mozilla/5.0", ip: "66.249.66.1", ok: false, lookups: 0},
+		{name: "no_ptr_record", userAgent: "Googlebot/2.1", ip: "203.0.113.1", ok: false, lookups: 1},
+		{name: "wrong_domain", userAgent: "Googlebot/2.1", ip: "198.51.100.1", ok: false, lookups: 1},
+		{name: "lookalike_domain", userAgent: "Googlebot/2.1", ip: "198.51.100.2", ok: false, lookups: 1},
+		{name: "forward_mismatch", userAgent: "Googlebot/2.1", ip: "198.51.100.3", ok: false, lookups: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := &fakeResolver{
+				addrs: map[string][]string{
+					"66.249.66.1":          {"crawl-66-249-66-1.googlebot.com."},
+					"2001:4860:4801:10::1": {"crawl-2001-4860-4801-10--1.googlebot.com."},
+					"198.51.100.1":         {"scraper.example.com."},
+					"198.51.100.2":         {"crawl.notgooglebot.com."},
+					"198.51.100.3":         {"spoofed.googlebot.com."},
+				},
+				hosts: map[string][]string{
+					"crawl-66-249-66-1.googlebot.com":          {"66.249.66.1"},
+					"crawl-2001-4860-4801-10--1.googlebot.com": {"2001:4860:4801:10::1"},
+					"crawl.notgooglebot.com":                   {"198.51.100.2"},
+					"spoofed.googlebot.com":                    {"66.249.66.2"},
+				},
+			}
+
+			c, err := NewReverseDNSChecker(claim, []string{"googlebot.com", ".google.com."})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.(*ReverseDNSChecker).resolver = fr
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+			r.Header.Set("User-Agent", tt.userAgent)
+			r.Header.Set("X-Real-Ip", tt.ip)
+
+			// The second check must be answered from the cache.
+			for range 2 {
+				ok, err := c.Check(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if tt.ok != ok {
+					t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+				}
+			}
+
+			if fr.lookups != tt.lookups {
+				t.Errorf("reverse lookups: %d, wanted: %d", fr.lookups, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestDecisionInputHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	r.Header.Set("Cookie", "session=secret")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+	r.Header.Set("X-Anubis-Bypass", "token")
+	r.Header.Set("X-Anubis-Token", "token")
+
+	input := newDecisionInput("test", r)
+
+	for _, name := range []string{"Accept", "User-Agent"} {
+		if _, ok := input.Headers[name]; !ok {
+			t.Errorf("wanted header %s to be sent", name)
+		}
+	}
+	for _, name := range []string{"Cookie", "Authorization", "Proxy-Authorization", "X-Anubis-Bypass", "X-Anubis-Token"} {
+		if val, ok := input.Headers[name]; ok {
+			t.Errorf("wanted header %s not to be sent, got: %q", name, val)
+		}
+	}
+}
+
+func TestDecisionAPI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input struct {
+				Path       string `json:"path"`
+				RemoteAddr string `json:"remote_addr"`
+			} `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Input.RemoteAddr != "198.51.100.1" {
+			http.Error(w, "wrong remote_addr", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Input.Path {
+		case "/deny":
+			w.Write([]byte(`{"result": "deny"}`))
+		case "/challenge":
+			w.Write([]byte(`{"result": {"action": "CHALLENGE"}}`))
+		case "/undecided":
+			w.Write([]byte(`{}`))
+		case "/bogus":
+			w.Write([]byte(`{"result": "MAYBE"}`))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+			w.Write([]byte(`{"result": "ALLOW"}`))
+		default:
+			http.Error(w, "oops", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		onError config.Rule
+		want    config.Rule
+		err     error
+	}{
+		{name: "deny", path: "/deny", want: config.RuleDeny},
+		{name: "challenge", path: "/challenge", want: config.RuleChallenge},
+		{name: "undecided", path: "/undecided", want: config.RuleUnknown},
+		{name: "bogus_result", path: "/bogus", want: config.RuleChallenge, err: ErrDecisionAPIResult},
+		{name: "server_error_fail_open", path: "/error", onError: config.RuleAllow, want: config.RuleAllow, err: ErrDecisionAPIStatus},
+		{name: "timeout_fail_closed", path: "/slow", onError: config.RuleDeny, want: config.RuleDeny},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDecisionAPI("test", config.DecisionAPI{
+				URL:     srv.URL,
+				Timeout: "100ms",
+				OnError: tt.onError,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+			r.Header.Set("X-Real-Ip", "198.51.100.1")
+
+			got, err := d.Decide(context.Background(), r)
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("err: %v, wanted: %v", err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("action: %q, wanted: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWASMChecker(t *testing.T) {
+	c, err := NewWASMChecker(0, "test", "./testdata/match-evil.wasm", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+		ok   bool
+	}{
+		{name: "match", path: "/evil/plans", ok: true},
+		{name: "no_match", path: "/", ok: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+			r.Header.Set("X-Real-Ip", "198.51.100.1")
+
+			ok, err := c.Check(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+
+	t.Run("not_wasm", func(t *testing.T) {
+		fname := filepath.Join(t.TempDir(), "bogus.wasm")
+		if err := os.WriteFile(fname, []byte("not a module"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewWASMChecker(0, "test", fname, time.Second); !errors.Is(err, ErrMisconfiguration) {
+			t.Errorf("err: %v, wanted: %v", err, ErrMisconfiguration)
+		}
+	})
+
+	t.Run("memory_limit", func(t *testing.T) {
+		// Ask for 1000 pages of memory instead of one.
+		fname := writeWASM(t, func(data []byte) []byte {
+			return bytes.Replace(data, []byte{0x05, 0x03, 0x01, 0x00, 0x01}, []byte{0x05, 0x04, 0x01, 0x00, 0xe8, 0x07}, 1)
+		})
+
+		_, err := NewWASMChecker(0, "test", fname, time.Second)
+		if !errors.Is(err, ErrMisconfiguration) || !strings.Contains(err.Error(), "over limit") {
+			t.Errorf("err: %v, wanted: %v for the memory limit", err, ErrMisconfiguration)
+		}
+	})
+}
+
+// writeWASM writes match-evil.wasm, changed by edit, to a temporary file. A
+// custom section named after the test is added, so that the module isn't
+// shared with other tests in the module cache.
+func writeWASM(t *testing.T, edit func([]byte) []byte) string {
+	t.Helper()
+
+	data, err := os.ReadFile("./testdata/match-evil.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := []byte(t.Name())
+	data = append(edit(data), 0x00, byte(len(name)+2), byte(len(name)))
+	data = append(data, name...)
+	data = append(data, 0x00)
+
+	fname := filepath.Join(t.TempDir(), "module.wasm")
+	if err := os.WriteFile(fname, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fname
+}
+
+func TestWASMModuleGenerations(t *testing.T) {
+	fname := writeWASM(t, func(data []byte) []byte { return data })
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	cached := func() bool {
+		wasmModules.lock.Lock()
+		defer wasmModules.lock.Unlock()
+
+		_, ok := wasmModules.modules[hash]
+		return ok
+	}
+
+	parse := func(extra string) (*ParsedConfig, error) {
+		return ParseConfig(strings.NewReader(fmt.Sprintf(`
+bots:
+  - name: evil
+    wasm:
+      path: %s
+    action: DENY
+%s`, fname, extra)), "wasm.yaml", anubis.DefaultDifficulty)
+	}
+
+	if _, err := parse("  - name: broken\n    action: ALLOW\n"); err == nil {
+		t.Fatal("wanted a rule without conditions to be rejected")
+	}
+	if cached() {
+		t.Error("module of a policy that failed to parse is still cached")
+	}
+
+	old, err := parse("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := parse("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old.Release()
+	if !cached() {
+		t.Error("module still in use by the reloaded policy was released")
+	}
+
+	reloaded.Release()
+	if cached() {
+		t.Error("module no policy uses is still cached")
+	}
+}
+
+func TestConditionChecker(t *testing.T) {
+	mozilla, notAPI := "Mozilla", "^/api/"
+
+	c, err := NewConditionChecker(config.Condition{
+		All: []config.Condition{
+			{UserAgentRegex: &mozilla},
+			{Not: &config.Condition{PathRegex: &notAPI}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		userAgent string
+		path      string
+		ok        bool
+	}{
+		{name: "browser_page", userAgent: "Mozilla/5.0", path: "/", ok: true},
+		{name: "browser_api", userAgent: "Mozilla/5.0", path: "/api/users", ok: false},
+		{name: "curl_page", userAgent: "curl/8.0", path: "/", ok: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+			r.Header.Set("User-Agent", tt.userAgent)
+
+			ok, err := c.Check(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSampleChecker(t *testing.T) {
+	everyone, err := NewUserAgentChecker(".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewSampleChecker(everyone, "test", 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matched := 0
+	for i := range 1000 {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatalf("can't make request: %v", err)
+		}
+		r.Header.Set("X-Real-Ip", fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+
+		ok, err := c.Check(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := c.Check(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != again {
+			t.Fatalf("client %s got different results", r.Header.Get("X-Real-Ip"))
+		}
+
+		if ok {
+			matched++
+		}
+	}
+
+	if matched < 200 || matched > 300 {
+		t.Errorf("matched %d of 1000 clients, wanted about 250", matched)
+	}
+
+	if _, err := NewSampleChecker(everyone, "test", 1.5); !errors.Is(err, ErrMisconfiguration) {
+		t.Errorf("err: %v, wanted: %v", err, ErrMisconfiguration)
+	}
+}
+
+func TestBrowserSanityChecker(t *testing.T) {
+	const chrome = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+
+	browserHeaders := map[string]string{
+		"Accept":          "text/html",
+		"Accept-Encoding": "gzip, br",
+		"Accept-Language": "en-US",
+	}
+
+	for _, tt := range []struct {
+		name    string
+		ua      string
+		proto   string
+		headers map[string]string
+		score   int
+		reasons int
+	}{
+		{
+			name:    "firefox",
+			ua:      firefox,
+			headers: browserHeaders,
+			score:   100,
+		},
+		{
+			name:    "chrome_with_client_hints",
+			ua:      chrome,
+			headers: map[string]string{"Accept": "text/html", "Accept-Encoding": "gzip", "Accept-Language": "en-US", "Sec-Ch-Ua": `"Chromium";v="124"`},
+			score:   100,
+		},
+		{
+			name:    "chrome_without_client_hints",
+			ua:      chrome,
+			headers: browserHeaders,
+			score:   70,
+			reasons: 1,
+		},
+		{
+			name:    "chrome_over_plain_http",
+			ua:      chrome,
+			headers: map[string]string{"Accept": "text/html", "Accept-Encoding": "gzip", "Accept-Language": "en-US", "X-Forwarded-Proto": "http"},
+			score:   100,
+		},
+		{
+			name:    "http_1_0",
+			ua:      firefox,
+			proto:   "HTTP/1.0",
+			headers: browserHeaders,
+			score:   60,
+			reasons: 1,
+		},
+		{
+			name:    "bare_scraper",
+			ua:      chrome,
+			proto:   "HTTP/1.0",
+			score:   0,
+			reasons: 5,
+		},
+		{
+			name:  "not_a_browser",
+			ua:    "curl/8.7.1",
+			score: 100,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			if tt.proto != "" {
+				var ok bool
+				r.ProtoMajor, r.ProtoMinor, ok = http.ParseHTTPVersion(tt.proto)
+				if !ok {
+					t.Fatalf("can't parse protocol %s", tt.proto)
+				}
+			}
+
+			r.Header.Set("User-Agent", tt.ua)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			score, reasons := browserSanityScore(r)
+			if score != tt.score {
+				t.Errorf("score: %d, wanted: %d (reasons: %v)", score, tt.score, reasons)
+			}
+
+			if len(reasons) != tt.reasons {
+				t.Errorf("got %d reasons, wanted %d: %v", len(reasons), tt.reasons, reasons)
+			}
+
+			ok, err := NewBrowserSanityChecker(config.DefaultBrowserSanityThreshold).Check(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := tt.score < config.DefaultBrowserSanityThreshold; ok != want {
+				t.Errorf("ok: %v, wanted: %v", ok, want)
+			}
+		})
+	}
+}
+
+func TestMethodChecker(t *testing.T) {
+	mc := NewMethodChecker([]string{"GET", "HEAD"})
+
+	for _, tt := range []struct {
+		method string
+		ok     bool
+	}{
+		{method: http.MethodGet, ok: true},
+		{method: http.MethodHead, ok: true},
+		{method: http.MethodPost},
+		{method: "PROPFIND"},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			r, err := http.NewRequest(tt.method, "/", nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			ok, err := mc.Check(r)
+			if err != nil {
+				t.Errorf("err: %v", err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestQueryChecker(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		querymap map[string]string
+		url      string
+		ok       bool
+		err      bool
+	}{
+		{
+			name:     "value_matches",
+			querymap: map[string]string{"action": "^raw$"},
+			url:      "/index.php?title=Main_Page&action=raw",
+			ok:       true,
+		},
+		{
+			name:     "value_does_not_match",
+			querymap: map[string]string{"action": "^raw$"},
+			url:      "/index.php?title=Main_Page&action=edit",
+		},
+		{
+			name:     "missing",
+			querymap: map[string]string{"action": "^$"},
+			url:      "/index.php?title=Main_Page",
+		},
+		{
+			name:     "exists",
+			querymap: map[string]string{"diff": ".*"},
+			url:      "/index.php?diff",
+			ok:       true,
+		},
+		{
+			name:     "exists_missing",
+			querymap: map[string]string{"diff": ".*"},
+			url:      "/index.php?oldid=5",
+		},
+		{
+			name:     "invalid_regex",
+			querymap: map[string]string{"action": "^(raw$"},
+			err:      true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			qc, err := NewQueryChecker(tt.querymap)
+			if (err != nil) != tt.err {
+				t.Fatalf("err: %v, wanted error: %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			r, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatalf("can't make request: %v", err)
+			}
+
+			ok, err := qc.Check(r)
+			if err != nil {
+				t.Errorf("err: %v", err)
+			}
+
+			if tt.ok != ok {
+				t.Errorf("ok: %v, wanted: %v", ok, tt.ok)
+			}
+		})
+	}
+}
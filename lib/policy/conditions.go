@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// AllChecker matches requests that every one of its checkers matches.
+type AllChecker []Checker
+
+func (ac AllChecker) Check(r *http.Request) (bool, error) {
+	for _, c := range ac {
+		ok, err := c.Check(r)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (ac AllChecker) Hash() string {
+	var sb strings.Builder
+
+	fmt.Fprintln(&sb, "all")
+	for _, c := range ac {
+		fmt.Fprintln(&sb, c.Hash())
+	}
+
+	return internal.SHA256sum(sb.String())
+}
+
+// NotChecker matches requests that its checker doesn't match.
+type NotChecker struct {
+	Checker Checker
+}
+
+func (nc NotChecker) Check(r *http.Request) (bool, error) {
+	ok, err := nc.Checker.Check(r)
+	if err != nil {
+		return false, err
+	}
+
+	return !ok, nil
+}
+
+func (nc NotChecker) Hash() string {
+	return internal.SHA256sum("not: " + nc.Checker.Hash())
+}
+
+// NewConditionChecker compiles a nested condition into a Checker.
+func NewConditionChecker(c config.Condition) (Checker, error) {
+	switch {
+	case c.UserAgentRegex != nil:
+		return NewUserAgentChecker(*c.UserAgentRegex)
+	case c.PathRegex != nil:
+		return NewPathChecker(*c.PathRegex)
+	case c.HeadersRegex != nil:
+		return NewHeadersChecker(c.HeadersRegex)
+	case c.HeadersMissing != nil:
+		return NewHeadersMissingChecker(c.HeadersMissing), nil
+	case c.HeadersEqual != nil:
+		return NewHeadersEqualChecker(c.HeadersEqual), nil
+	case c.Methods != nil:
+		return NewMethodChecker(c.Methods), nil
+	case c.QueryRegex != nil:
+		return NewQueryChecker(c.QueryRegex)
+	case c.RemoteAddr != nil:
+		return NewRemoteAddrChecker(c.RemoteAddr)
+	case c.All != nil:
+		checkers, err := newConditionCheckers(c.All)
+		if err != nil {
+			return nil, err
+		}
+		return AllChecker(checkers), nil
+	case c.Any != nil:
+		checkers, err := newConditionCheckers(c.Any)
+		if err != nil {
+			return nil, err
+		}
+		return CheckerList(checkers), nil
+	case c.Not != nil:
+		checker, err := NewConditionChecker(*c.Not)
+		if err != nil {
+			return nil, err
+		}
+		return NotChecker{checker}, nil
+	default:
+		return nil, fmt.Errorf("%w: empty condition", ErrMisconfiguration)
+	}
+}
+
+func newConditionCheckers(conds []config.Condition) ([]Checker, error) {
+	var result []Checker
+	for _, cond := range conds {
+		c, err := NewConditionChecker(cond)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+
+	return result, nil
+}
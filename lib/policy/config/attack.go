@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAttackDetectionNoMaxDifficulty = errors.New("config.AttackDetection: max_difficulty must be between 1 and 64")
+	ErrAttackDetectionMinDifficulty   = errors.New("config.AttackDetection: min_difficulty must not be negative or more than max_difficulty")
+	ErrAttackDetectionInvalidInterval = errors.New("config.AttackDetection: interval must be a duration of at least one second like 1m")
+	ErrAttackDetectionNoThresholds    = errors.New("config.AttackDetection: must set challenge_rate, failure_rate, new_client_rate, or more")
+	ErrAttackDetectionInvalidRate     = errors.New("config.AttackDetection: challenge_rate and new_client_rate must not be negative")
+	ErrAttackDetectionInvalidFailure  = errors.New("config.AttackDetection: failure_rate must be between 0 and 1")
+)
+
+// DefaultAttackDetectionInterval is how often the attack detector looks at
+// what happened if the policy doesn't say otherwise.
+const DefaultAttackDetectionInterval = time.Minute
+
+// AttackDetection makes Anubis raise the difficulty of challenges by itself
+// while it looks like it is under attack, and lower it again once things
+// calm down. Every Interval, the difficulty goes up by one if any of the
+// thresholds was crossed, and down by one if all signals stayed below half
+// of their thresholds. The difficulty of every rule moves by as much as the
+// default difficulty does. Setting MaxDifficulty turns it on.
+type AttackDetection struct {
+	// MinDifficulty is the lowest the default difficulty goes. Defaults to
+	// the default difficulty.
+	MinDifficulty int `json:"min_difficulty,omitempty"`
+
+	// MaxDifficulty is the highest the default difficulty goes.
+	MaxDifficulty int `json:"max_difficulty,omitempty"`
+
+	// Interval is how often the signals are looked at, as a duration like
+	// 1m.
+	Interval string `json:"interval,omitempty"`
+
+	// ChallengeRate is how many challenges per second Anubis may issue
+	// before it counts as an attack.
+	ChallengeRate float64 `json:"challenge_rate,omitempty"`
+
+	// FailureRate is the fraction of challenge solutions that may fail
+	// before it counts as an attack.
+	FailureRate float64 `json:"failure_rate,omitempty"`
+
+	// NewClientRate is how many IP addresses per second that weren't
+	// issued a challenge in the interval before may get one before it
+	// counts as an attack.
+	NewClientRate float64 `json:"new_client_rate,omitempty"`
+}
+
+// Enabled reports whether the attack detector is turned on.
+func (ad AttackDetection) Enabled() bool {
+	return ad != AttackDetection{}
+}
+
+// IntervalDuration returns the interval, or the default if none is set.
+func (ad AttackDetection) IntervalDuration() time.Duration {
+	interval, err := time.ParseDuration(ad.Interval)
+	if err != nil || interval < time.Second {
+		return DefaultAttackDetectionInterval
+	}
+
+	return interval
+}
+
+func (ad AttackDetection) Valid() error {
+	if !ad.Enabled() {
+		return nil
+	}
+
+	var errs []error
+
+	if ad.MaxDifficulty < 1 || ad.MaxDifficulty > 64 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrAttackDetectionNoMaxDifficulty, ad.MaxDifficulty))
+	}
+
+	if ad.MinDifficulty < 0 || ad.MinDifficulty > ad.MaxDifficulty {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrAttackDetectionMinDifficulty, ad.MinDifficulty))
+	}
+
+	if ad.Interval != "" {
+		if interval, err := time.ParseDuration(ad.Interval); err != nil || interval < time.Second {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrAttackDetectionInvalidInterval, ad.Interval))
+		}
+	}
+
+	if ad.ChallengeRate == 0 && ad.FailureRate == 0 && ad.NewClientRate == 0 {
+		errs = append(errs, ErrAttackDetectionNoThresholds)
+	}
+
+	if ad.ChallengeRate < 0 || ad.NewClientRate < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %v and %v", ErrAttackDetectionInvalidRate, ad.ChallengeRate, ad.NewClientRate))
+	}
+
+	if ad.FailureRate < 0 || ad.FailureRate > 1 {
+		errs = append(errs, fmt.Errorf("%w, got: %v", ErrAttackDetectionInvalidFailure, ad.FailureRate))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: attack_detection is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
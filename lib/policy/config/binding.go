@@ -0,0 +1,32 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidTokenBinding = errors.New("config: token_binding must be \"none\", \"network\", or \"address\"")
+
+// TokenBinding is how strictly Anubis cookies are tied to the IP address of
+// the client they were issued to.
+type TokenBinding string
+
+const (
+	// TokenBindingNone lets cookies be used from any IP address.
+	TokenBindingNone TokenBinding = "none"
+	// TokenBindingNetwork lets cookies be used from the same /24 for IPv4
+	// or /64 for IPv6.
+	TokenBindingNetwork TokenBinding = "network"
+	// TokenBindingAddress only lets cookies be used from the same IP
+	// address.
+	TokenBindingAddress TokenBinding = "address"
+)
+
+func (tb TokenBinding) Valid() error {
+	switch tb {
+	case "", TokenBindingNone, TokenBindingNetwork, TokenBindingAddress:
+		return nil
+	default:
+		return fmt.Errorf("%w, got: %q", ErrInvalidTokenBinding, tb)
+	}
+}
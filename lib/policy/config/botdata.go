@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var (
+	ErrBotDataNoBots         = errors.New("config.BotData: must define at least one (1) bot rule")
+	ErrBotDataRuleNotAllowed = errors.New("config.BotData: rules can't set target, decision_api, wasm, or countries")
+)
+
+// BotData is a bundle of bot rules that is downloaded and checked after the
+// rules of the policy file, such as the user agents of new AI crawlers and
+// the IP ranges of datacenters.
+//
+// Bundles come from outside the deployment, so their rules can only match
+// requests and decide what happens to them. They can't send requests to
+// other targets or depend on files and services of the deployment.
+type BotData struct {
+	// Version names the bundle in logs. Downloaded bundles must have one,
+	// and are only replaced by newer versions.
+	Version string      `json:"version,omitempty"`
+	Bots    []BotConfig `json:"bots"`
+}
+
+func (bd BotData) Valid() error {
+	var errs []error
+
+	if len(bd.Bots) == 0 {
+		errs = append(errs, ErrBotDataNoBots)
+	}
+
+	for _, b := range bd.Bots {
+		if err := b.Valid(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if b.Target != "" || b.DecisionAPI != nil || b.WASM != nil || len(b.Countries) != 0 {
+			errs = append(errs, fmt.Errorf("%w, got rule: %s", ErrBotDataRuleNotAllowed, b.Name))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: bot data is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// LoadBotData reads and validates a bundle of bot rules in the YAML or JSON
+// format of policy files.
+func LoadBotData(fin io.Reader, fname string) (*BotData, error) {
+	var bd BotData
+	if err := yaml.NewYAMLToJSONDecoder(fin).Decode(&bd); err != nil {
+		return nil, fmt.Errorf("can't parse bot data YAML %s: %w", fname, err)
+	}
+
+	if err := bd.Valid(); err != nil {
+		return nil, err
+	}
+
+	return &bd, nil
+}
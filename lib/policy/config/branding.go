@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidBrandingURL   = errors.New("config.Branding: image URLs must start with http://, https://, or /")
+	ErrInvalidBrandingColor = errors.New("config.Branding: colors must be a hex color like #b16286, a color name, or an rgb() or hsl() function")
+)
+
+// cssColor matches the CSS colors that can safely be put into a stylesheet.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9., %/a-z]+\))$`)
+
+// Branding changes how the pages Anubis renders look without replacing any
+// templates or static files.
+type Branding struct {
+	// Title replaces the title of challenge pages.
+	Title string `json:"title,omitempty"`
+	// Organization is shown in the title bar of every page and is the alt
+	// text of the logo.
+	Organization string `json:"organization,omitempty"`
+	// LogoURL is an image shown above the title of every page.
+	LogoURL string `json:"logo_url,omitempty"`
+	// Mascot replaces the images of the Anubis mascot.
+	Mascot *MascotImages `json:"mascot,omitempty"`
+	// Colors replaces the colors of the default stylesheet in both light
+	// and dark mode.
+	Colors *ColorPalette `json:"colors,omitempty"`
+}
+
+// MascotImages are the URLs of the images shown while a challenge is being
+// solved (Pensive), once it is solved (Happy), and on error pages (Reject).
+type MascotImages struct {
+	Pensive string `json:"pensive,omitempty"`
+	Happy   string `json:"happy,omitempty"`
+	Reject  string `json:"reject,omitempty"`
+}
+
+// ColorPalette holds CSS colors for pages.
+type ColorPalette struct {
+	Background string `json:"background,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Link       string `json:"link,omitempty"`
+	Accent     string `json:"accent,omitempty"`
+}
+
+func validBrandingURL(u string) bool {
+	return u == "" || strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") || (strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//"))
+}
+
+func (b Branding) Valid() error {
+	var errs []error
+
+	urls := []string{b.LogoURL}
+	if b.Mascot != nil {
+		urls = append(urls, b.Mascot.Pensive, b.Mascot.Happy, b.Mascot.Reject)
+	}
+
+	for _, u := range urls {
+		if !validBrandingURL(u) {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidBrandingURL, u))
+		}
+	}
+
+	if b.Colors != nil {
+		for _, color := range []string{b.Colors.Background, b.Colors.Text, b.Colors.Link, b.Colors.Accent} {
+			if color != "" && !cssColor.MatchString(color) {
+				errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidBrandingColor, color))
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: branding is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidBrowserSanityThreshold = errors.New("config.BrowserSanity: threshold must be between 1 and 100")
+
+// DefaultBrowserSanityThreshold is the browser sanity score below which
+// requests match if the rule doesn't say otherwise.
+const DefaultBrowserSanityThreshold = 50
+
+// BrowserSanity matches clients that claim to be a browser but send headers
+// that browsers don't. Every request gets a score between 0 and 100, where
+// 100 means nothing looked wrong, and the rule matches requests that score
+// below Threshold.
+type BrowserSanity struct {
+	Threshold int `json:"threshold,omitempty"`
+}
+
+// ThresholdOrDefault returns the threshold, or the default if none is set.
+func (bs BrowserSanity) ThresholdOrDefault() int {
+	if bs.Threshold == 0 {
+		return DefaultBrowserSanityThreshold
+	}
+
+	return bs.Threshold
+}
+
+func (bs BrowserSanity) Valid() error {
+	if bs.Threshold < 0 || bs.Threshold > 100 {
+		return fmt.Errorf("config: browser_sanity is not valid:\n%w", fmt.Errorf("%w, got: %d", ErrInvalidBrowserSanityThreshold, bs.Threshold))
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrClientLimitsInvalidMaxConnections = errors.New("config.ClientLimits: max_connections must not be negative")
+	ErrClientLimitsInvalidMaxInFlight    = errors.New("config.ClientLimits: max_in_flight must not be negative")
+)
+
+// ClientLimits limits how much each client IP address may do at the same
+// time, so that a client with a valid cookie can't open hundreds of
+// parallel requests to the upstream. Clients over a limit get 429 Too Many
+// Requests. Zero values leave the limits off.
+type ClientLimits struct {
+	// MaxConnections is how many connections each IP address may have
+	// open to Anubis. Requests on connections over the limit are rejected
+	// and the connection is closed. Connections are counted by the address
+	// they come from, so this only works when clients connect to Anubis
+	// directly or through the PROXY protocol.
+	MaxConnections int `json:"max_connections,omitempty"`
+
+	// MaxInFlight is how many requests from each IP address may be sent
+	// to the upstream at the same time.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+}
+
+func (cl ClientLimits) Valid() error {
+	var errs []error
+
+	if cl.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrClientLimitsInvalidMaxConnections, cl.MaxConnections))
+	}
+
+	if cl.MaxInFlight < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrClientLimitsInvalidMaxInFlight, cl.MaxInFlight))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: client_limits is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+)
+
+var (
+	ErrConditionMustSetOne = errors.New("config.Condition: must set exactly one of user_agent_regex, path_regex, headers_regex, headers_missing, headers_equal, methods, query_regex, remote_addresses, all, any, or not")
+	ErrConditionEmptyList  = errors.New("config.Condition: all and any must have at least one condition")
+)
+
+// Condition is one part of a nested bot rule condition. Each condition sets
+// exactly one matcher or combinator:
+//
+//   - all matches if every condition in it matches
+//   - any matches if at least one condition in it matches
+//   - not matches if the condition in it doesn't
+type Condition struct {
+	UserAgentRegex *string             `json:"user_agent_regex,omitempty"`
+	PathRegex      *string             `json:"path_regex,omitempty"`
+	HeadersRegex   map[string]string   `json:"headers_regex,omitempty"`
+	HeadersMissing []string            `json:"headers_missing,omitempty"`
+	HeadersEqual   map[string][]string `json:"headers_equal,omitempty"`
+	Methods        []string            `json:"methods,omitempty"`
+	QueryRegex     map[string]string   `json:"query_regex,omitempty"`
+	RemoteAddr     []string            `json:"remote_addresses,omitempty"`
+	All            []Condition         `json:"all,omitempty"`
+	Any            []Condition         `json:"any,omitempty"`
+	Not            *Condition          `json:"not,omitempty"`
+}
+
+func (c Condition) Valid() error {
+	var errs []error
+
+	set := 0
+	for _, cond := range []bool{
+		c.UserAgentRegex != nil,
+		c.PathRegex != nil,
+		c.HeadersRegex != nil,
+		c.HeadersMissing != nil,
+		c.HeadersEqual != nil,
+		c.Methods != nil,
+		c.QueryRegex != nil,
+		c.RemoteAddr != nil,
+		c.All != nil,
+		c.Any != nil,
+		c.Not != nil,
+	} {
+		if cond {
+			set++
+		}
+	}
+	if set != 1 {
+		errs = append(errs, ErrConditionMustSetOne)
+	}
+
+	if c.UserAgentRegex != nil {
+		if _, err := regexp.Compile(*c.UserAgentRegex); err != nil {
+			errs = append(errs, ErrInvalidUserAgentRegex, err)
+		}
+	}
+
+	if c.PathRegex != nil {
+		if _, err := regexp.Compile(*c.PathRegex); err != nil {
+			errs = append(errs, ErrInvalidPathRegex, err)
+		}
+	}
+
+	for _, expr := range c.HeadersRegex {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = append(errs, ErrInvalidHeadersRegex, err)
+		}
+	}
+
+	errs = append(errs, validHeaders(c.HeadersMissing, c.HeadersEqual)...)
+	errs = append(errs, validRequest(c.Methods, c.QueryRegex)...)
+
+	for _, cidr := range c.RemoteAddr {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, ErrInvalidCIDR, err)
+		}
+	}
+
+	errs = append(errs, validCombinators(c.All, c.Any, c.Not)...)
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: condition is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// validCombinators validates the all, any, and not combinators of a bot rule
+// or condition.
+func validCombinators(allOf, anyOf []Condition, not *Condition) []error {
+	var errs []error
+
+	if (allOf != nil && len(allOf) == 0) || (anyOf != nil && len(anyOf) == 0) {
+		errs = append(errs, ErrConditionEmptyList)
+	}
+
+	for _, sub := range append(append([]Condition{}, allOf...), anyOf...) {
+		if err := sub.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if not != nil {
+		if err := not.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
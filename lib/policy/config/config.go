@@ -8,25 +8,51 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/vale981/anubis/data"
+	"github.com/vale981/anubis/lib/policy/expressions"
 	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 var (
 	ErrNoBotRulesDefined                 = errors.New("config: must define at least one (1) bot rule")
 	ErrBotMustHaveName                   = errors.New("config.Bot: must set name")
-	ErrBotMustHaveUserAgentOrPath        = errors.New("config.Bot: must set either user_agent_regex, path_regex, headers_regex, or remote_addresses")
+	ErrBotMustHaveUserAgentOrPath        = errors.New("config.Bot: must set either user_agent_regex, path_regex, headers_regex, remote_addresses, or expression")
 	ErrBotMustHaveUserAgentOrPathNotBoth = errors.New("config.Bot: must set either user_agent_regex, path_regex, and not both")
 	ErrUnknownAction                     = errors.New("config.Bot: unknown action")
 	ErrInvalidUserAgentRegex             = errors.New("config.Bot: invalid user agent regex")
 	ErrInvalidPathRegex                  = errors.New("config.Bot: invalid path regex")
 	ErrInvalidHeadersRegex               = errors.New("config.Bot: invalid headers regex")
+	ErrInvalidMethod                     = errors.New("config.Bot: methods must be upper case HTTP methods like GET")
+	ErrInvalidQueryRegex                 = errors.New("config.Bot: invalid query regex")
+	ErrInvalidHeaderName                 = errors.New("config.Bot: header names must not be empty")
+	ErrHeadersEqualEmpty                 = errors.New("config.Bot: headers_equal must list at least one value for each header")
 	ErrInvalidCIDR                       = errors.New("config.Bot: invalid CIDR")
+	ErrInvalidExpression                 = errors.New("config.Bot: invalid expression")
+	ErrInvalidReverseDNSDomain           = errors.New("config.Bot: invalid verify_reverse_dns domain")
+	ErrInvalidProbability                = errors.New("config.Bot: probability must be between 0 and 1")
+	ErrInvalidCountryCode                = errors.New("config.Bot: country codes must be two-letter ISO 3166-1 codes")
+	ErrCountriesNeedGeoIPDatabase        = errors.New("config: bot rules with countries need geoip_database to be set")
 	ErrInvalidImportStatement            = errors.New("config.ImportStatement: invalid source file")
 	ErrCantSetBotAndImportValuesAtOnce   = errors.New("config.BotOrImport: can't set bot rules and import values at the same time")
+	ErrImportCycle                       = errors.New("config.ImportStatement: files import each other")
 	ErrMustSetBotOrImportRules           = errors.New("config.BotOrImport: rule definition is invalid, you must set either bot rules or an import statement, not both")
+	ErrInvalidAPIPathPrefix              = errors.New("config: API path prefixes must start with a slash")
+	ErrInvalidAPIClientHeader            = errors.New("config: api_client_header must be a valid HTTP header name")
+	ErrRedirectRequired                  = errors.New("config.Bot: rules with the REDIRECT action must set redirect")
+	ErrResponseRequired                  = errors.New("config.Bot: rules with the STATUS action must set response with a status")
+	ErrResponseNotUsed                   = errors.New("config.Bot: response only applies to rules with the DENY or STATUS action or a decision API")
+	ErrInvalidBotTarget                  = errors.New("config.Bot: target must be an http, https, h2c, unix, fastcgi, or fastcgi+unix URL")
+	ErrTargetNotForwarded                = errors.New("config.Bot: target only applies to rules that send requests to the upstream, with the ALLOW, CHALLENGE, or CAPTCHA action or a decision API")
+)
+
+var (
+	httpMethod  = regexp.MustCompile(`^[A-Z][A-Z-]*$`)
+	countryCode = regexp.MustCompile(`^[A-Za-z]{2}$`)
+	headerName  = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+	dnsDomain   = regexp.MustCompile(`^\.?([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.?$`)
 )
 
 type Rule string
@@ -37,6 +63,11 @@ const (
 	RuleDeny      Rule = "DENY"
 	RuleChallenge Rule = "CHALLENGE"
 	RuleBenchmark Rule = "DEBUG_BENCHMARK"
+	RuleCaptcha   Rule = "CAPTCHA"
+	RuleTarpit    Rule = "TARPIT"
+	RuleDecoy     Rule = "DECOY"
+	RuleRedirect  Rule = "REDIRECT"
+	RuleStatus    Rule = "STATUS"
 )
 
 type Algorithm string
@@ -45,16 +76,57 @@ const (
 	AlgorithmUnknown Algorithm = ""
 	AlgorithmFast    Algorithm = "fast"
 	AlgorithmSlow    Algorithm = "slow"
+	AlgorithmScrypt  Algorithm = "scrypt"
+	AlgorithmNoJS    Algorithm = "nojs"
 )
 
 type BotConfig struct {
-	Name           string            `json:"name"`
-	UserAgentRegex *string           `json:"user_agent_regex"`
-	PathRegex      *string           `json:"path_regex"`
-	HeadersRegex   map[string]string `json:"headers_regex"`
-	Action         Rule              `json:"action"`
-	RemoteAddr     []string          `json:"remote_addresses"`
-	Challenge      *ChallengeRules   `json:"challenge,omitempty"`
+	Name             string              `json:"name"`
+	UserAgentRegex   *string             `json:"user_agent_regex"`
+	PathRegex        *string             `json:"path_regex"`
+	HeadersRegex     map[string]string   `json:"headers_regex"`
+	HeadersMissing   []string            `json:"headers_missing,omitempty"`
+	HeadersEqual     map[string][]string `json:"headers_equal,omitempty"`
+	Methods          []string            `json:"methods,omitempty"`
+	QueryRegex       map[string]string   `json:"query_regex,omitempty"`
+	Action           Rule                `json:"action"`
+	RemoteAddr       []string            `json:"remote_addresses"`
+	IPRanges         []string            `json:"ip_ranges,omitempty"` // alias of remote_addresses
+	Countries        []string            `json:"countries,omitempty"`
+	Expression       *string             `json:"expression,omitempty"`
+	VerifyReverseDNS []string            `json:"verify_reverse_dns,omitempty"`
+	Challenge        *ChallengeRules     `json:"challenge,omitempty"`
+	DecisionAPI      *DecisionAPI        `json:"decision_api,omitempty"`
+	WASM             *WASMModule         `json:"wasm,omitempty"`
+	BrowserSanity    *BrowserSanity      `json:"browser_sanity,omitempty"`
+	Reputation       *Reputation         `json:"reputation,omitempty"`
+	All              []Condition         `json:"all,omitempty"`
+	Any              []Condition         `json:"any,omitempty"`
+	Not              *Condition          `json:"not,omitempty"`
+
+	// Probability is the fraction of clients matching the rule that it
+	// applies to. The rest are checked against the rules after it. Zero
+	// means all of them.
+	Probability float64 `json:"probability,omitempty"`
+
+	// DryRun makes Anubis log and count what the rule would do, but send
+	// matching requests to the upstream anyway.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// SecondaryScreening overrides how often requests with a valid cookie
+	// that match the rule are fully re-verified.
+	SecondaryScreening *ScreeningRate `json:"secondary_screening,omitempty"`
+
+	// Redirect sets where REDIRECT rules send clients.
+	Redirect *Redirect `json:"redirect,omitempty"`
+
+	// Response is what STATUS rules send back, or how DENY rules deny
+	// requests.
+	Response *Response `json:"response,omitempty"`
+
+	// Target, if set, is the upstream that requests matching the rule are
+	// sent to instead of the default one or the one of their route.
+	Target string `json:"target,omitempty"`
 }
 
 func (b BotConfig) Zero() bool {
@@ -63,9 +135,30 @@ func (b BotConfig) Zero() bool {
 		b.UserAgentRegex != nil,
 		b.PathRegex != nil,
 		len(b.HeadersRegex) != 0,
+		len(b.HeadersMissing) != 0,
+		len(b.HeadersEqual) != 0,
+		len(b.Methods) != 0,
+		len(b.QueryRegex) != 0,
 		b.Action != "",
 		len(b.RemoteAddr) != 0,
+		len(b.IPRanges) != 0,
+		len(b.Countries) != 0,
+		b.Expression != nil,
+		len(b.VerifyReverseDNS) != 0,
 		b.Challenge != nil,
+		b.DecisionAPI != nil,
+		b.WASM != nil,
+		b.BrowserSanity != nil,
+		b.Reputation != nil,
+		b.All != nil,
+		b.Any != nil,
+		b.Not != nil,
+		b.Probability != 0,
+		b.DryRun,
+		b.SecondaryScreening != nil,
+		b.Redirect != nil,
+		b.Response != nil,
+		b.Target != "",
 	} {
 		if cond {
 			return false
@@ -75,6 +168,11 @@ func (b BotConfig) Zero() bool {
 	return true
 }
 
+// CIDRs returns the IP ranges from both remote_addresses and ip_ranges.
+func (b BotConfig) CIDRs() []string {
+	return append(append([]string{}, b.RemoteAddr...), b.IPRanges...)
+}
+
 func (b BotConfig) Valid() error {
 	var errs []error
 
@@ -82,7 +180,7 @@ func (b BotConfig) Valid() error {
 		errs = append(errs, ErrBotMustHaveName)
 	}
 
-	if b.UserAgentRegex == nil && b.PathRegex == nil && len(b.RemoteAddr) == 0 && len(b.HeadersRegex) == 0 {
+	if b.UserAgentRegex == nil && b.PathRegex == nil && len(b.RemoteAddr) == 0 && len(b.IPRanges) == 0 && len(b.HeadersRegex) == 0 && len(b.HeadersMissing) == 0 && len(b.HeadersEqual) == 0 && len(b.Methods) == 0 && len(b.QueryRegex) == 0 && b.Expression == nil && len(b.Countries) == 0 && len(b.VerifyReverseDNS) == 0 && b.DecisionAPI == nil && b.WASM == nil && b.BrowserSanity == nil && b.Reputation == nil && b.All == nil && b.Any == nil && b.Not == nil {
 		errs = append(errs, ErrBotMustHaveUserAgentOrPath)
 	}
 
@@ -114,22 +212,119 @@ func (b BotConfig) Valid() error {
 		}
 	}
 
-	if len(b.RemoteAddr) > 0 {
-		for _, cidr := range b.RemoteAddr {
+	errs = append(errs, validHeaders(b.HeadersMissing, b.HeadersEqual)...)
+	errs = append(errs, validRequest(b.Methods, b.QueryRegex)...)
+
+	if len(b.RemoteAddr) > 0 || len(b.IPRanges) > 0 {
+		for _, cidr := range b.CIDRs() {
 			if _, _, err := net.ParseCIDR(cidr); err != nil {
 				errs = append(errs, ErrInvalidCIDR, err)
 			}
 		}
 	}
 
-	switch b.Action {
-	case RuleAllow, RuleBenchmark, RuleChallenge, RuleDeny:
+	for _, cc := range b.Countries {
+		if !countryCode.MatchString(cc) {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidCountryCode, cc))
+		}
+	}
+
+	for _, domain := range b.VerifyReverseDNS {
+		if !dnsDomain.MatchString(domain) {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidReverseDNSDomain, domain))
+		}
+	}
+
+	if b.Expression != nil {
+		if _, err := expressions.Compile(*b.Expression); err != nil {
+			errs = append(errs, ErrInvalidExpression, err)
+		}
+	}
+
+	errs = append(errs, validCombinators(b.All, b.Any, b.Not)...)
+
+	if b.SecondaryScreening != nil {
+		if err := b.SecondaryScreening.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.Probability < 0 || b.Probability > 1 {
+		errs = append(errs, fmt.Errorf("%w, got: %v", ErrInvalidProbability, b.Probability))
+	}
+
+	switch {
+	case b.Action == RuleUnknown && b.DecisionAPI != nil:
+		// the decision API picks the action
+	case b.Action == RuleAllow, b.Action == RuleBenchmark, b.Action == RuleChallenge, b.Action == RuleCaptcha, b.Action == RuleDeny, b.Action == RuleTarpit, b.Action == RuleDecoy:
 		// okay
+	case b.Action == RuleRedirect:
+		if b.Redirect == nil {
+			errs = append(errs, ErrRedirectRequired)
+		}
+	case b.Action == RuleStatus:
+		if b.Response == nil || b.Response.Status == 0 {
+			errs = append(errs, ErrResponseRequired)
+		}
 	default:
 		errs = append(errs, fmt.Errorf("%w: %q", ErrUnknownAction, b.Action))
 	}
 
-	if b.Action == RuleChallenge && b.Challenge != nil {
+	if b.DecisionAPI != nil {
+		if err := b.DecisionAPI.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.WASM != nil {
+		if err := b.WASM.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.BrowserSanity != nil {
+		if err := b.BrowserSanity.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.Reputation != nil {
+		if err := b.Reputation.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.Redirect != nil {
+		if err := b.Redirect.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.Response != nil {
+		if err := b.Response.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if b.Action != RuleDeny && b.Action != RuleStatus && b.DecisionAPI == nil {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrResponseNotUsed, b.Action))
+		}
+	}
+
+	if b.Target != "" {
+		if err := validTarget(b.Target, ErrInvalidBotTarget); err != nil {
+			errs = append(errs, err)
+		}
+
+		switch b.Action {
+		case RuleAllow, RuleChallenge, RuleCaptcha:
+		default:
+			if b.DecisionAPI == nil {
+				errs = append(errs, fmt.Errorf("%w, got: %q", ErrTargetNotForwarded, b.Action))
+			}
+		}
+	}
+
+	if (b.Action == RuleChallenge || b.DecisionAPI != nil) && b.Challenge != nil {
 		if err := b.Challenge.Valid(); err != nil {
 			errs = append(errs, err)
 		}
@@ -146,12 +341,30 @@ type ChallengeRules struct {
 	Difficulty int       `json:"difficulty"`
 	ReportAs   int       `json:"report_as"`
 	Algorithm  Algorithm `json:"algorithm"`
+
+	// MinDifficulty enables client calibration. Clients that report a low hash
+	// rate may solve a challenge as easy as this instead of Difficulty.
+	MinDifficulty      int `json:"min_difficulty,omitempty"`
+	TargetSolveSeconds int `json:"target_solve_seconds,omitempty"`
+
+	// MaxThreads limits how many Web Workers the client solver may use. If
+	// unset, clients use one worker per CPU core.
+	MaxThreads int `json:"max_threads,omitempty"`
+
+	// MaxDifficulty enables adaptive difficulty. Clients that request
+	// challenges or send requests unusually fast get harder challenges, up to
+	// this difficulty.
+	MaxDifficulty int `json:"max_difficulty,omitempty"`
 }
 
 var (
-	ErrChallengeRuleHasWrongAlgorithm = errors.New("config.Bot.ChallengeRules: algorithm is invalid")
-	ErrChallengeDifficultyTooLow      = errors.New("config.Bot.ChallengeRules: difficulty is too low (must be >= 1)")
-	ErrChallengeDifficultyTooHigh     = errors.New("config.Bot.ChallengeRules: difficulty is too high (must be <= 64)")
+	ErrChallengeRuleHasWrongAlgorithm   = errors.New("config.Bot.ChallengeRules: algorithm is invalid")
+	ErrChallengeDifficultyTooLow        = errors.New("config.Bot.ChallengeRules: difficulty is too low (must be >= 1)")
+	ErrChallengeDifficultyTooHigh       = errors.New("config.Bot.ChallengeRules: difficulty is too high (must be <= 64)")
+	ErrChallengeMinDifficultyOutOfRange = errors.New("config.Bot.ChallengeRules: min_difficulty must be between 0 and difficulty")
+	ErrChallengeTargetSolveTimeNegative = errors.New("config.Bot.ChallengeRules: target_solve_seconds must not be negative")
+	ErrChallengeMaxThreadsOutOfRange    = errors.New("config.Bot.ChallengeRules: max_threads must be between 0 and 256")
+	ErrChallengeMaxDifficultyOutOfRange = errors.New("config.Bot.ChallengeRules: max_difficulty must be between difficulty and 64")
 )
 
 func (cr ChallengeRules) Valid() error {
@@ -165,8 +378,24 @@ func (cr ChallengeRules) Valid() error {
 		errs = append(errs, fmt.Errorf("%w, got: %d", ErrChallengeDifficultyTooHigh, cr.Difficulty))
 	}
 
+	if cr.MinDifficulty < 0 || cr.MinDifficulty > cr.Difficulty {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrChallengeMinDifficultyOutOfRange, cr.MinDifficulty))
+	}
+
+	if cr.TargetSolveSeconds < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrChallengeTargetSolveTimeNegative, cr.TargetSolveSeconds))
+	}
+
+	if cr.MaxThreads < 0 || cr.MaxThreads > 256 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrChallengeMaxThreadsOutOfRange, cr.MaxThreads))
+	}
+
+	if cr.MaxDifficulty != 0 && (cr.MaxDifficulty < cr.Difficulty || cr.MaxDifficulty > 64) {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrChallengeMaxDifficultyOutOfRange, cr.MaxDifficulty))
+	}
+
 	switch cr.Algorithm {
-	case AlgorithmFast, AlgorithmSlow, AlgorithmUnknown:
+	case AlgorithmFast, AlgorithmSlow, AlgorithmScrypt, AlgorithmNoJS, AlgorithmUnknown:
 		// do nothing, it's all good
 	default:
 		errs = append(errs, fmt.Errorf("%w: %q", ErrChallengeRuleHasWrongAlgorithm, cr.Algorithm))
@@ -195,23 +424,53 @@ func (is *ImportStatement) open() (fs.File, error) {
 }
 
 func (is *ImportStatement) load() error {
+	return is.loadFrom(nil)
+}
+
+// loadFrom loads the rules of the imported file, which may import other
+// files in turn. stack holds the files that are being imported, so that
+// import cycles can be detected.
+func (is *ImportStatement) loadFrom(stack []string) error {
+	if slices.Contains(stack, is.Import) {
+		return fmt.Errorf("%w: %s", ErrImportCycle, strings.Join(append(stack, is.Import), " -> "))
+	}
+	stack = append(stack, is.Import)
+
 	fin, err := is.open()
 	if err != nil {
 		return fmt.Errorf("can't open %s: %w", is.Import, err)
 	}
 	defer fin.Close()
 
-	var result []BotConfig
+	var bois []BotOrImport
 
-	if err := yaml.NewYAMLToJSONDecoder(fin).Decode(&result); err != nil {
+	if err := yaml.NewYAMLToJSONDecoder(fin).Decode(&bois); err != nil {
 		return fmt.Errorf("can't parse %s: %w", is.Import, err)
 	}
 
-	var errs []error
+	var (
+		result []BotConfig
+		errs   []error
+	)
 
-	for _, b := range result {
-		if err := b.Valid(); err != nil {
-			errs = append(errs, err)
+	for _, boi := range bois {
+		switch {
+		case boi.BotConfig != nil && boi.ImportStatement != nil:
+			errs = append(errs, ErrCantSetBotAndImportValuesAtOnce)
+		case boi.ImportStatement != nil:
+			if err := boi.ImportStatement.loadFrom(stack); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			result = append(result, boi.ImportStatement.Bots...)
+		case boi.BotConfig != nil:
+			if err := boi.BotConfig.Valid(); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			result = append(result, *boi.BotConfig)
+		default:
+			errs = append(errs, ErrMustSetBotOrImportRules)
 		}
 	}
 
@@ -250,8 +509,31 @@ func (boi *BotOrImport) Valid() error {
 }
 
 type fileConfig struct {
-	Bots  []BotOrImport `json:"bots"`
-	DNSBL bool          `json:"dnsbl"`
+	Version         string             `json:"version,omitempty"`
+	Bots            []BotOrImport      `json:"bots"`
+	DNSBL           bool               `json:"dnsbl"`
+	IPFeeds         []IPFeed           `json:"ip_feeds,omitempty"`
+	APIPathPrefixes []string           `json:"api_path_prefixes"`
+	APIClientHeader string             `json:"api_client_header,omitempty"`
+	CORS            *CORSConfig        `json:"cors,omitempty"`
+	GeoIPDatabase   string             `json:"geoip_database,omitempty"`
+	Routes          []fileRoute        `json:"routes,omitempty"`
+	RateLimits      map[Rule]RateLimit `json:"rate_limits,omitempty"`
+	Translations    Translations       `json:"translations,omitempty"`
+	Branding        *Branding          `json:"branding,omitempty"`
+	DryRun          bool               `json:"dry_run,omitempty"`
+
+	SecondaryScreening *ScreeningRate `json:"secondary_screening,omitempty"`
+	TokenBinding       TokenBinding   `json:"token_binding,omitempty"`
+	Tarpit             Tarpit         `json:"tarpit,omitempty"`
+	Decoy              Decoy          `json:"decoy,omitempty"`
+	SignedURLs         []SignedURL    `json:"signed_urls,omitempty"`
+	CriticalPaths      CriticalPaths  `json:"critical_paths,omitempty"`
+	RobotsTXT          RobotsTXT      `json:"robots_txt,omitempty"`
+
+	ChallengeIssuance ChallengeIssuance `json:"challenge_issuance,omitempty"`
+	AttackDetection   AttackDetection   `json:"attack_detection,omitempty"`
+	ClientLimits      ClientLimits      `json:"client_limits,omitempty"`
 }
 
 func (c fileConfig) Valid() error {
@@ -267,6 +549,88 @@ func (c fileConfig) Valid() error {
 		}
 	}
 
+	if err := validAPIPathPrefixes(c.APIPathPrefixes); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.APIClientHeader != "" && !headerName.MatchString(c.APIClientHeader) {
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidAPIClientHeader, c.APIClientHeader))
+	}
+
+	if err := validRateLimits(c.RateLimits); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.SecondaryScreening != nil {
+		if err := c.SecondaryScreening.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.TokenBinding.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Tarpit.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Decoy.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.CriticalPaths.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.RobotsTXT.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ChallengeIssuance.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.AttackDetection.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ClientLimits.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, su := range c.SignedURLs {
+		if err := su.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.Translations.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Branding != nil {
+		if err := c.Branding.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.CORS != nil {
+		if err := c.CORS.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := validIPFeeds(c.IPFeeds, c.DNSBL); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, route := range c.Routes {
+		if err := route.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	if len(errs) != 0 {
 		return fmt.Errorf("config is not valid:\n%w", errors.Join(errs...))
 	}
@@ -274,6 +638,18 @@ func (c fileConfig) Valid() error {
 	return nil
 }
 
+func validAPIPathPrefixes(prefixes []string) error {
+	var errs []error
+
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidAPIPathPrefix, prefix))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 func Load(fin io.Reader, fname string) (*Config, error) {
 	var c fileConfig
 	if err := yaml.NewYAMLToJSONDecoder(fin).Decode(&c); err != nil {
@@ -285,41 +661,174 @@ func Load(fin io.Reader, fname string) (*Config, error) {
 	}
 
 	result := &Config{
-		DNSBL: c.DNSBL,
+		Version:         c.Version,
+		DNSBL:           c.DNSBL,
+		IPFeeds:         ipFeeds(c.IPFeeds, c.DNSBL),
+		APIPathPrefixes: c.APIPathPrefixes,
+		APIClientHeader: c.APIClientHeader,
+		CORS:            c.CORS,
+		GeoIPDatabase:   c.GeoIPDatabase,
+		RateLimits:      c.RateLimits,
+		Translations:    c.Translations,
+		Branding:        c.Branding,
+		DryRun:          c.DryRun,
+		Tarpit:          c.Tarpit,
+		Decoy:           c.Decoy,
+		SignedURLs:      c.SignedURLs,
+		CriticalPaths:   c.CriticalPaths,
+		RobotsTXT:       c.RobotsTXT,
+
+		ChallengeIssuance: c.ChallengeIssuance,
+		AttackDetection:   c.AttackDetection,
+		ClientLimits:      c.ClientLimits,
+	}
+
+	result.SecondaryScreening = DefaultSecondaryScreening
+	if c.SecondaryScreening != nil {
+		result.SecondaryScreening = *c.SecondaryScreening
+	}
+
+	result.TokenBinding = c.TokenBinding
+	if result.TokenBinding == "" {
+		result.TokenBinding = TokenBindingNone
 	}
 
 	var validationErrs []error
 
-	for _, boi := range c.Bots {
+	bots, errs := loadBots(c.Bots)
+	result.Bots = bots
+	validationErrs = append(validationErrs, errs...)
+
+	for _, fr := range c.Routes {
+		route := fr.route()
+		route.Bots, errs = loadBots(fr.Bots)
+		validationErrs = append(validationErrs, errs...)
+		result.Routes = append(result.Routes, route)
+	}
+
+	if err := result.validGeoIP(); err != nil {
+		validationErrs = append(validationErrs, err)
+	}
+
+	if len(validationErrs) > 0 {
+		return nil, fmt.Errorf("errors validating policy config %s: %w", fname, errors.Join(validationErrs...))
+	}
+
+	return result, nil
+}
+
+// loadBots loads the imports in bois and returns the bot rules they define.
+func loadBots(bois []BotOrImport) ([]BotConfig, []error) {
+	var (
+		result []BotConfig
+		errs   []error
+	)
+
+	for _, boi := range bois {
 		if boi.ImportStatement != nil {
 			if err := boi.load(); err != nil {
-				validationErrs = append(validationErrs, err)
+				errs = append(errs, err)
 				continue
 			}
 
-			result.Bots = append(result.Bots, boi.ImportStatement.Bots...)
+			result = append(result, boi.ImportStatement.Bots...)
 		}
 
 		if boi.BotConfig != nil {
 			if err := boi.BotConfig.Valid(); err != nil {
-				validationErrs = append(validationErrs, err)
+				errs = append(errs, err)
 				continue
 			}
 
-			result.Bots = append(result.Bots, *boi.BotConfig)
+			result = append(result, *boi.BotConfig)
 		}
 	}
 
-	if len(validationErrs) > 0 {
-		return nil, fmt.Errorf("errors validating policy config %s: %w", fname, errors.Join(validationErrs...))
+	return result, errs
+}
+
+type Config struct {
+	// Version names the policy file. Policy files loaded from a URL must
+	// have one, and are only replaced by newer versions.
+	Version string
+
+	Bots            []BotConfig
+	DNSBL           bool
+	IPFeeds         []IPFeed
+	APIPathPrefixes []string
+	APIClientHeader string
+	CORS            *CORSConfig
+	GeoIPDatabase   string
+	Routes          []Route
+	RateLimits      map[Rule]RateLimit
+	Translations    Translations
+	Branding        *Branding
+	DryRun          bool
+
+	// SecondaryScreening is how often requests with a valid cookie are
+	// fully re-verified.
+	SecondaryScreening ScreeningRate
+
+	// TokenBinding is how strictly cookies are tied to the IP address of
+	// the client they were issued to.
+	TokenBinding TokenBinding
+
+	// Tarpit sets how requests matched by TARPIT rules are held.
+	Tarpit Tarpit
+
+	// Decoy sets what the pages served to requests matched by DECOY rules
+	// look like.
+	Decoy Decoy
+
+	// SignedURLs are the paths that requests with a signed URL can reach
+	// without passing the policy.
+	SignedURLs []SignedURL
+
+	// CriticalPaths are allowed before any bot rule is checked.
+	CriticalPaths CriticalPaths
+
+	// RobotsTXT sets how robots.txt is generated from the policy.
+	RobotsTXT RobotsTXT
+
+	// ChallengeIssuance limits how many challenges are issued.
+	ChallengeIssuance ChallengeIssuance
+
+	// AttackDetection raises the difficulty of challenges while Anubis is
+	// under attack.
+	AttackDetection AttackDetection
+
+	// ClientLimits limits how many connections and requests each client
+	// may have open at the same time.
+	ClientLimits ClientLimits
+}
+
+// allBots returns the global bot rules followed by the bot rules of every
+// route.
+func (c Config) allBots() []BotConfig {
+	result := slices.Clone(c.Bots)
+	for _, route := range c.Routes {
+		result = append(result, route.Bots...)
 	}
 
-	return result, nil
+	return result
 }
 
-type Config struct {
-	Bots  []BotConfig
-	DNSBL bool
+// validGeoIP checks that rules only match on countries if there is a GeoIP
+// database. It runs after imports are loaded, so that imported rules are
+// checked too.
+func (c Config) validGeoIP() error {
+	if c.GeoIPDatabase != "" {
+		return nil
+	}
+
+	var errs []error
+	for _, b := range c.allBots() {
+		if len(b.Countries) != 0 {
+			errs = append(errs, fmt.Errorf("%w: rule %s", ErrCountriesNeedGeoIPDatabase, b.Name))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func (c Config) Valid() error {
@@ -335,6 +844,56 @@ func (c Config) Valid() error {
 		}
 	}
 
+	if err := validAPIPathPrefixes(c.APIPathPrefixes); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.APIClientHeader != "" && !headerName.MatchString(c.APIClientHeader) {
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidAPIClientHeader, c.APIClientHeader))
+	}
+
+	if err := validRateLimits(c.RateLimits); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Translations.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Branding != nil {
+		if err := c.Branding.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.CORS != nil {
+		if err := c.CORS.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := validIPFeeds(c.IPFeeds, false); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Tarpit.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Decoy.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, route := range c.Routes {
+		if err := route.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.validGeoIP(); err != nil {
+		errs = append(errs, err)
+	}
+
 	if len(errs) != 0 {
 		return fmt.Errorf("config is not valid:\n%w", errors.Join(errs...))
 	}
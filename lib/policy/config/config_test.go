@@ -1,11 +1,17 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"io/fs"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vale981/anubis/data"
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -130,6 +136,67 @@ func TestBotValid(t *testing.T) {
 			},
 			err: ErrChallengeDifficultyTooHigh,
 		},
+		{
+			name: "challenge min difficulty above difficulty",
+			bot: BotConfig{
+				Name:      "mozilla-ua",
+				Action:    RuleChallenge,
+				PathRegex: p("Mozilla"),
+				Challenge: &ChallengeRules{
+					Difficulty:    4,
+					ReportAs:      4,
+					Algorithm:     "fast",
+					MinDifficulty: 5,
+				},
+			},
+			err: ErrChallengeMinDifficultyOutOfRange,
+		},
+		{
+			name: "challenge negative target solve time",
+			bot: BotConfig{
+				Name:      "mozilla-ua",
+				Action:    RuleChallenge,
+				PathRegex: p("Mozilla"),
+				Challenge: &ChallengeRules{
+					Difficulty:         4,
+					ReportAs:           4,
+					Algorithm:          "fast",
+					MinDifficulty:      2,
+					TargetSolveSeconds: -1,
+				},
+			},
+			err: ErrChallengeTargetSolveTimeNegative,
+		},
+		{
+			name: "challenge negative max threads",
+			bot: BotConfig{
+				Name:      "mozilla-ua",
+				Action:    RuleChallenge,
+				PathRegex: p("Mozilla"),
+				Challenge: &ChallengeRules{
+					Difficulty: 4,
+					ReportAs:   4,
+					Algorithm:  "fast",
+					MaxThreads: -1,
+				},
+			},
+			err: ErrChallengeMaxThreadsOutOfRange,
+		},
+		{
+			name: "challenge max difficulty below difficulty",
+			bot: BotConfig{
+				Name:      "mozilla-ua",
+				Action:    RuleChallenge,
+				PathRegex: p("Mozilla"),
+				Challenge: &ChallengeRules{
+					Difficulty:    4,
+					ReportAs:      4,
+					Algorithm:     "fast",
+					MaxDifficulty: 3,
+				},
+			},
+			err: ErrChallengeMaxDifficultyOutOfRange,
+		},
 		{
 			name: "challenge wrong algorithm",
 			bot: BotConfig{
@@ -251,6 +318,7 @@ func TestImportStatement(t *testing.T) {
 		"bots",
 		"common",
 		"crawlers",
+		"meta",
 	} {
 		if err := fs.WalkDir(data.BotPolicies, folderName, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
@@ -289,6 +357,16 @@ func TestImportStatement(t *testing.T) {
 	}
 }
 
+func TestImportStatementCycle(t *testing.T) {
+	is := &ImportStatement{
+		Import: "./testdata/import-cycle-a.yaml",
+	}
+
+	if err := is.Valid(); !errors.Is(err, ErrImportCycle) {
+		t.Errorf("err: %v, wanted: %v", err, ErrImportCycle)
+	}
+}
+
 func TestConfigValidBad(t *testing.T) {
 	finfos, err := os.ReadDir("testdata/bad")
 	if err != nil {
@@ -318,6 +396,34 @@ func TestConfigValidBad(t *testing.T) {
 	}
 }
 
+func TestCountriesNeedGeoIPDatabase(t *testing.T) {
+	_, err := Load(strings.NewReader(`
+bots:
+  - name: germany
+    countries: [DE]
+    action: DENY
+routes:
+  - host: example.com
+    bots:
+      - name: france
+        countries: [FR]
+        action: DENY
+`), "countries.yaml")
+	if !errors.Is(err, ErrCountriesNeedGeoIPDatabase) {
+		t.Fatalf("err: %v, wanted: %v", err, ErrCountriesNeedGeoIPDatabase)
+	}
+
+	for _, name := range []string{"germany", "france"} {
+		if !strings.Contains(err.Error(), "rule "+name) {
+			t.Errorf("wanted an error for rule %s, got: %v", name, err)
+		}
+	}
+
+	if strings.Count(err.Error(), ErrCountriesNeedGeoIPDatabase.Error()) != 2 {
+		t.Errorf("wanted one error per rule, got: %v", err)
+	}
+}
+
 func TestBotConfigZero(t *testing.T) {
 	var b BotConfig
 	if !b.Zero() {
@@ -363,3 +469,618 @@ func TestBotConfigZero(t *testing.T) {
 		t.Error("BotConfig with challenge rules is zero value")
 	}
 }
+
+func TestScreeningRate(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  ScreeningRate
+		err   bool
+	}{
+		{input: `"always"`, want: 1},
+		{input: `"Never"`, want: 0},
+		{input: `0.5`, want: 0.5},
+		{input: `"sometimes"`, err: true},
+		{input: `true`, err: true},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			var sr ScreeningRate
+			err := json.Unmarshal([]byte(tt.input), &sr)
+			if (err != nil) != tt.err {
+				t.Fatalf("err: %v, wanted error: %v", err, tt.err)
+			}
+
+			if err == nil && sr != tt.want {
+				t.Errorf("got: %v, wanted: %v", sr, tt.want)
+			}
+		})
+	}
+
+	c, err := Load(strings.NewReader("bots:\n  - name: everyone\n    user_agent_regex: .*\n    action: CHALLENGE\n"), "default.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.SecondaryScreening != DefaultSecondaryScreening {
+		t.Errorf("default rate: %v, wanted: %v", c.SecondaryScreening, DefaultSecondaryScreening)
+	}
+}
+
+func TestIPFeedValid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		feed IPFeed
+		err  error
+	}{
+		{
+			name: "dnsbl",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "zen.spamhaus.org"},
+		},
+		{
+			name: "http",
+			feed: IPFeed{Name: "tor", Type: IPFeedHTTP, URL: "https://check.torproject.org/torbulkexitlist", TTL: "30m", Action: RuleChallenge},
+		},
+		{
+			name: "no name",
+			feed: IPFeed{Type: IPFeedDNSBL, Zone: "zen.spamhaus.org"},
+			err:  ErrIPFeedNoName,
+		},
+		{
+			name: "unknown type",
+			feed: IPFeed{Name: "zen", Type: "ldap"},
+			err:  ErrIPFeedUnknownType,
+		},
+		{
+			name: "zone is a URL",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "https://zen.spamhaus.org"},
+			err:  ErrIPFeedInvalidZone,
+		},
+		{
+			name: "no url",
+			feed: IPFeed{Name: "tor", Type: IPFeedHTTP},
+			err:  ErrIPFeedInvalidURL,
+		},
+		{
+			name: "bad ttl",
+			feed: IPFeed{Name: "tor", Type: IPFeedHTTP, URL: "https://example.com/list.txt", TTL: "-1h"},
+			err:  ErrIPFeedInvalidTTL,
+		},
+		{
+			name: "bad action",
+			feed: IPFeed{Name: "tor", Type: IPFeedHTTP, URL: "https://example.com/list.txt", Action: RuleBenchmark},
+			err:  ErrIPFeedInvalidAction,
+		},
+		{
+			name: "resolver and timeout",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "zen.spamhaus.org", Resolver: "[::1]:5353", Timeout: "500ms", FailMode: FailClosed},
+		},
+		{
+			name: "resolver is a host name",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "zen.spamhaus.org", Resolver: "dns.example.com"},
+			err:  ErrIPFeedInvalidResolver,
+		},
+		{
+			name: "bad timeout",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "zen.spamhaus.org", Timeout: "soon"},
+			err:  ErrIPFeedInvalidTimeout,
+		},
+		{
+			name: "async http feed",
+			feed: IPFeed{Name: "tor", Type: IPFeedHTTP, URL: "https://example.com/list.txt", Async: true},
+			err:  ErrIPFeedDNSBLOnly,
+		},
+		{
+			name: "bad fail mode",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "zen.spamhaus.org", FailMode: "ajar"},
+			err:  ErrIPFeedInvalidFailMode,
+		},
+		{
+			name: "async fail closed",
+			feed: IPFeed{Name: "zen", Type: IPFeedDNSBL, Zone: "zen.spamhaus.org", Async: true, FailMode: FailClosed},
+			err:  ErrIPFeedAsyncFailClosed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.feed.Valid()
+			if !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestIPFeedsDNSBL(t *testing.T) {
+	c, err := Load(strings.NewReader(`
+dnsbl: true
+ip_feeds:
+  - name: tor
+    type: http
+    url: https://check.torproject.org/torbulkexitlist
+    action: CHALLENGE
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+`), "ip_feeds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.IPFeeds) != 2 || c.IPFeeds[0].Zone != DroneBLZone || c.IPFeeds[1].Name != "tor" {
+		t.Fatalf("wanted DroneBL in front of the tor feed, got: %+v", c.IPFeeds)
+	}
+
+	if got := c.IPFeeds[0].ActionRule(); got != RuleDeny {
+		t.Errorf("wanted DroneBL to deny, got: %s", got)
+	}
+
+	if got := c.IPFeeds[1].TTLDuration(); got != DefaultHTTPTTL {
+		t.Errorf("wanted default TTL %s, got: %s", DefaultHTTPTTL, got)
+	}
+
+	for resolver, want := range map[string]string{
+		"":               "",
+		"127.0.0.1":      "127.0.0.1:53",
+		"127.0.0.1:5353": "127.0.0.1:5353",
+		"::1":            "[::1]:53",
+		"[::1]:5353":     "[::1]:5353",
+	} {
+		if got := (IPFeed{Resolver: resolver}).ResolverAddress(); got != want {
+			t.Errorf("resolver %q: wanted address %q, got: %q", resolver, want, got)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, policy string
+		err          error
+	}{
+		{
+			name:   "duplicate",
+			policy: "ip_feeds:\n  - {name: a, type: dnsbl, zone: a.example}\n  - {name: a, type: dnsbl, zone: b.example}\n",
+			err:    ErrIPFeedDuplicateName,
+		},
+		{
+			name:   "dronebl",
+			policy: "dnsbl: true\nip_feeds:\n  - {name: dronebl, type: dnsbl, zone: a.example}\n",
+			err:    ErrIPFeedDroneBLReserve,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tt.policy+"bots:\n  - name: everyone\n    user_agent_regex: .*\n    action: CHALLENGE\n"), tt.name+".yaml")
+			if !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRedirectValid(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		redirect Redirect
+		err      error
+	}{
+		{
+			name:     "absolute url",
+			redirect: Redirect{URL: "https://static.example.com/", Status: http.StatusMovedPermanently},
+		},
+		{
+			name:     "path",
+			redirect: Redirect{URL: "/robots.txt"},
+		},
+		{
+			name:     "no url",
+			redirect: Redirect{},
+			err:      ErrRedirectInvalidURL,
+		},
+		{
+			name:     "protocol relative url",
+			redirect: Redirect{URL: "//static.example.com/"},
+			err:      ErrRedirectInvalidURL,
+		},
+		{
+			name:     "unsupported scheme",
+			redirect: Redirect{URL: "ftp://static.example.com/"},
+			err:      ErrRedirectInvalidURL,
+		},
+		{
+			name:     "not a redirect status",
+			redirect: Redirect{URL: "https://static.example.com/", Status: http.StatusOK},
+			err:      ErrRedirectInvalidStatus,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.redirect.Valid()
+			if !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	u, err := url.Parse("https://example.com/docs/a%20b?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		redirect Redirect
+		want     string
+	}{
+		{
+			name:     "fixed",
+			redirect: Redirect{URL: "https://static.example.com/"},
+			want:     "https://static.example.com/",
+		},
+		{
+			name:     "keep path",
+			redirect: Redirect{URL: "https://static.example.com/", KeepPath: true},
+			want:     "https://static.example.com/docs/a%20b?page=2",
+		},
+		{
+			name:     "keep path under prefix",
+			redirect: Redirect{URL: "https://static.example.com/mirror", KeepPath: true},
+			want:     "https://static.example.com/mirror/docs/a%20b?page=2",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.redirect.Location(u); got != tt.want {
+				t.Errorf("wanted %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestActionSettingsRequired(t *testing.T) {
+	for _, tt := range []struct {
+		action Rule
+		err    error
+	}{
+		{action: RuleRedirect, err: ErrRedirectRequired},
+		{action: RuleStatus, err: ErrResponseRequired},
+	} {
+		t.Run(string(tt.action), func(t *testing.T) {
+			b := BotConfig{Name: "bot", UserAgentRegex: p("Bot"), Action: tt.action}
+			if err := b.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestBotTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		action Rule
+		target string
+		err    error
+	}{
+		{name: "allow", action: RuleAllow, target: "http://mirror.internal:8080"},
+		{name: "challenge over unix socket", action: RuleChallenge, target: "unix:///run/mirror.sock"},
+		{name: "fastcgi", action: RuleAllow, target: "fastcgi://127.0.0.1:9000?root=/var/www/html"},
+		{name: "fastcgi over unix socket", action: RuleAllow, target: "fastcgi+unix:///run/php/php-fpm.sock?root=/var/www/html"},
+		{name: "fastcgi without root", action: RuleAllow, target: "fastcgi://127.0.0.1:9000", err: ErrInvalidBotTarget},
+		{name: "not a URL", action: RuleAllow, target: "mirror", err: ErrInvalidBotTarget},
+		{name: "deny", action: RuleDeny, target: "http://mirror.internal:8080", err: ErrTargetNotForwarded},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := BotConfig{Name: "bot", UserAgentRegex: p("Bot"), Action: tt.action, Target: tt.target}
+			if err := b.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestResponseValid(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		action   Rule
+		response Response
+		err      error
+	}{
+		{
+			name:     "deny with status and format",
+			action:   RuleDeny,
+			response: Response{Status: http.StatusForbidden, Format: ResponseJSON},
+		},
+		{
+			name:     "deny with template",
+			action:   RuleDeny,
+			response: Response{Body: "denied by {{.Rule}}, error ID {{.ID}}"},
+		},
+		{
+			name:     "status without status code",
+			action:   RuleStatus,
+			response: Response{Body: "gone"},
+			err:      ErrResponseRequired,
+		},
+		{
+			name:     "bad status",
+			action:   RuleDeny,
+			response: Response{Status: 99},
+			err:      ErrResponseInvalidStatus,
+		},
+		{
+			name:     "bad format",
+			action:   RuleDeny,
+			response: Response{Format: "xml"},
+			err:      ErrResponseInvalidFormat,
+		},
+		{
+			name:     "bad template",
+			action:   RuleDeny,
+			response: Response{Body: "{{.Rule"},
+			err:      ErrResponseInvalidBody,
+		},
+		{
+			name:     "challenge",
+			action:   RuleChallenge,
+			response: Response{Status: http.StatusForbidden},
+			err:      ErrResponseNotUsed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := BotConfig{Name: "bot", UserAgentRegex: p("Bot"), Action: tt.action, Response: &tt.response}
+			if err := b.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestHasPathPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		path, prefix string
+		want         bool
+	}{
+		{path: "/api", prefix: "/api", want: true},
+		{path: "/api/v1", prefix: "/api", want: true},
+		{path: "/apiary", prefix: "/api", want: false},
+		{path: "/api-docs", prefix: "/api", want: false},
+		{path: "/api/", prefix: "/api/", want: true},
+		{path: "/api/v1", prefix: "/api/", want: true},
+		{path: "/api", prefix: "/api/", want: false},
+		{path: "/anything", prefix: "/", want: true},
+		{path: "/anything", prefix: "", want: true},
+		{path: "/ap", prefix: "/api", want: false},
+	} {
+		if got := HasPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("HasPathPrefix(%q, %q) = %v, wanted %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	su := SignedURL{Name: "hooks", PathPrefix: "/hooks/", Secret: strings.Repeat("s", 32), MaxAge: "1h"}
+	if err := su.Valid(); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse("https://example.com/hooks/git?event=push&repo=anubis")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := su.Sign(http.MethodPost, u, time.Now())
+	if err := su.Verify(http.MethodPost, signed); err != nil {
+		t.Errorf("wanted the signed URL to verify, got: %v", err)
+	}
+
+	reordered := *signed
+	q := reordered.Query()
+	reordered.RawQuery = SignedURLSignatureParam + "=" + q.Get(SignedURLSignatureParam) + "&repo=anubis&" + SignedURLTimestampParam + "=" + q.Get(SignedURLTimestampParam) + "&event=push"
+	if err := su.Verify(http.MethodPost, &reordered); err != nil {
+		t.Errorf("wanted the order of query parameters not to matter, got: %v", err)
+	}
+
+	withParam := *signed
+	withParam.RawQuery += "&added=by-sender"
+	changedParam := &url.URL{Path: signed.Path, RawQuery: strings.Replace(signed.RawQuery, "event=push", "event=delete", 1)}
+
+	unlimited := su
+	unlimited.MaxAge = ""
+
+	for _, tt := range []struct {
+		name   string
+		su     SignedURL
+		method string
+		u      *url.URL
+		err    error
+	}{
+		{name: "unsigned", u: u, err: ErrSignedURLMissing},
+		{name: "other path", u: &url.URL{Path: "/hooks/other", RawQuery: signed.RawQuery}, err: ErrSignedURLSignature},
+		{name: "other method", method: http.MethodGet, u: signed, err: ErrSignedURLSignature},
+		{name: "added query parameter", u: &withParam, err: ErrSignedURLSignature},
+		{name: "changed query parameter", u: changedParam, err: ErrSignedURLSignature},
+		{name: "too old", u: su.Sign(http.MethodPost, u, time.Now().Add(-2*time.Hour)), err: ErrSignedURLExpired},
+		{name: "older than the default max age", su: unlimited, u: unlimited.Sign(http.MethodPost, u, time.Now().Add(-DefaultSignedURLMaxAge-time.Minute)), err: ErrSignedURLExpired},
+		{name: "from the future", u: su.Sign(http.MethodPost, u, time.Now().Add(time.Hour)), err: ErrSignedURLExpired},
+		{name: "other secret", u: SignedURL{Secret: strings.Repeat("x", 32)}.Sign(http.MethodPost, u, time.Now()), err: ErrSignedURLSignature},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.su.Name == "" {
+				tt.su = su
+			}
+			if tt.method == "" {
+				tt.method = http.MethodPost
+			}
+			if err := tt.su.Verify(tt.method, tt.u); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSignedURLSecretEnv(t *testing.T) {
+	su := SignedURL{Name: "hooks", PathPrefix: "/hooks/", SecretEnv: "ANUBIS_TEST_SIGNED_URL_SECRET"}
+
+	t.Setenv("ANUBIS_TEST_SIGNED_URL_SECRET", "")
+	if err := su.Valid(); !errors.Is(err, ErrSignedURLSecretEmpty) {
+		t.Errorf("wanted ErrSignedURLSecretEmpty, got: %v", err)
+	}
+
+	t.Setenv("ANUBIS_TEST_SIGNED_URL_SECRET", strings.Repeat("s", 32))
+	if err := su.Valid(); err != nil {
+		t.Errorf("wanted the secret from the environment to be used, got: %v", err)
+	}
+
+	su.Secret = strings.Repeat("s", 32)
+	if err := su.Valid(); !errors.Is(err, ErrSignedURLSecretRequired) {
+		t.Errorf("wanted ErrSignedURLSecretRequired, got: %v", err)
+	}
+}
+
+func TestCriticalPaths(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		cp       CriticalPaths
+		matches  []string
+		misses   []string
+		noRegexp bool
+	}{
+		{
+			name:    "defaults",
+			matches: []string{"/.well-known/acme-challenge/abc", "/robots.txt", "/favicon.ico", "/.well-known/security.txt"},
+			misses:  []string{"/robots.txt.bak", "/.well-known/acme-challengeX", "/favicon.ico/x", "/"},
+		},
+		{
+			name:    "extended",
+			cp:      CriticalPaths{Paths: []string{"/ads.txt", "/feeds/*"}},
+			matches: []string{"/ads.txt", "/feeds/atom.xml", "/robots.txt"},
+			misses:  []string{"/ads.txt2", "/feed"},
+		},
+		{
+			name:    "defaults disabled",
+			cp:      CriticalPaths{Disable: true, Paths: []string{"/ads.txt"}},
+			matches: []string{"/ads.txt"},
+			misses:  []string{"/robots.txt"},
+		},
+		{
+			name:     "nothing",
+			cp:       CriticalPaths{Disable: true},
+			noRegexp: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rexStr := tt.cp.PathRegex()
+			if tt.noRegexp {
+				if rexStr != "" {
+					t.Errorf("wanted no regex, got: %q", rexStr)
+				}
+				return
+			}
+
+			rex := regexp.MustCompile(rexStr)
+			for _, path := range tt.matches {
+				if !rex.MatchString(path) {
+					t.Errorf("wanted %s to be a critical path", path)
+				}
+			}
+			for _, path := range tt.misses {
+				if rex.MatchString(path) {
+					t.Errorf("wanted %s not to be a critical path", path)
+				}
+			}
+		})
+	}
+}
+
+func TestChallengeIssuanceValid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ci   ChallengeIssuance
+		err  error
+	}{
+		{name: "off"},
+		{name: "per ip", ci: ChallengeIssuance{Rate: 0.5, Burst: 10}},
+		{name: "concurrency", ci: ChallengeIssuance{MaxConcurrent: 100}},
+		{name: "negative rate", ci: ChallengeIssuance{Rate: -1}, err: ErrIssuanceInvalidRate},
+		{name: "burst without rate", ci: ChallengeIssuance{Burst: 10}, err: ErrIssuanceInvalidBurst},
+		{name: "negative max concurrent", ci: ChallengeIssuance{MaxConcurrent: -1}, err: ErrIssuanceInvalidMaxConcurrent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.ci.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestAttackDetectionValid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ad   AttackDetection
+		err  error
+	}{
+		{name: "off"},
+		{name: "challenge rate", ad: AttackDetection{MaxDifficulty: 8, ChallengeRate: 50}},
+		{name: "all signals", ad: AttackDetection{MinDifficulty: 2, MaxDifficulty: 8, Interval: "30s", ChallengeRate: 50, FailureRate: 0.5, NewClientRate: 10}},
+		{name: "no max difficulty", ad: AttackDetection{ChallengeRate: 50}, err: ErrAttackDetectionNoMaxDifficulty},
+		{name: "max difficulty too high", ad: AttackDetection{MaxDifficulty: 65, ChallengeRate: 50}, err: ErrAttackDetectionNoMaxDifficulty},
+		{name: "min above max", ad: AttackDetection{MinDifficulty: 9, MaxDifficulty: 8, ChallengeRate: 50}, err: ErrAttackDetectionMinDifficulty},
+		{name: "bad interval", ad: AttackDetection{MaxDifficulty: 8, Interval: "soon", ChallengeRate: 50}, err: ErrAttackDetectionInvalidInterval},
+		{name: "interval too short", ad: AttackDetection{MaxDifficulty: 8, Interval: "10ms", ChallengeRate: 50}, err: ErrAttackDetectionInvalidInterval},
+		{name: "no thresholds", ad: AttackDetection{MaxDifficulty: 8}, err: ErrAttackDetectionNoThresholds},
+		{name: "negative rate", ad: AttackDetection{MaxDifficulty: 8, NewClientRate: -1}, err: ErrAttackDetectionInvalidRate},
+		{name: "failure rate above one", ad: AttackDetection{MaxDifficulty: 8, FailureRate: 1.5}, err: ErrAttackDetectionInvalidFailure},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.ad.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestClientLimitsValid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cl   ClientLimits
+		err  error
+	}{
+		{name: "off"},
+		{name: "both", cl: ClientLimits{MaxConnections: 16, MaxInFlight: 8}},
+		{name: "negative max connections", cl: ClientLimits{MaxConnections: -1}, err: ErrClientLimitsInvalidMaxConnections},
+		{name: "negative max in flight", cl: ClientLimits{MaxInFlight: -1}, err: ErrClientLimitsInvalidMaxInFlight},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cl.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestBotDataValid(t *testing.T) {
+	ua := "ExampleScraper"
+	scraper := BotConfig{Name: "example-scraper", UserAgentRegex: &ua, Action: RuleDeny}
+
+	for _, tt := range []struct {
+		name string
+		bd   BotData
+		err  error
+	}{
+		{name: "rules", bd: BotData{Version: "2026-10-01", Bots: []BotConfig{scraper}}},
+		{name: "no rules", bd: BotData{}, err: ErrBotDataNoBots},
+		{name: "invalid rule", bd: BotData{Bots: []BotConfig{{Name: "nothing", Action: RuleDeny}}}, err: ErrBotMustHaveUserAgentOrPath},
+		{name: "target", bd: BotData{Bots: []BotConfig{func() BotConfig {
+			b := scraper
+			b.Action = RuleAllow
+			b.Target = "https://attacker.example"
+			return b
+		}()}}, err: ErrBotDataRuleNotAllowed},
+		{name: "countries", bd: BotData{Bots: []BotConfig{func() BotConfig {
+			b := scraper
+			b.Countries = []string{"NZ"}
+			return b
+		}()}}, err: ErrBotDataRuleNotAllowed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.bd.Valid(); !errors.Is(err, tt.err) {
+				t.Errorf("wanted error %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrCORSMustHaveOrigins         = errors.New("config.CORS: must set at least one allowed origin")
+	ErrCORSInvalidOrigin           = errors.New("config.CORS: allowed origins must be \"*\" or start with http:// or https://")
+	ErrCORSInvalidMaxAge           = errors.New("config.CORS: max_age must not be negative")
+	ErrCORSWildcardWithCredentials = errors.New("config.CORS: allow_credentials can't be used with the \"*\" origin")
+)
+
+// CORSConfig tells Anubis how to answer CORS preflight requests itself. If it
+// is not set, preflight requests are passed to the target instead.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAge           int      `json:"max_age,omitempty"`
+}
+
+func (c CORSConfig) Valid() error {
+	var errs []error
+
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, ErrCORSMustHaveOrigins)
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		switch {
+		case origin == "*":
+			if c.AllowCredentials {
+				errs = append(errs, ErrCORSWildcardWithCredentials)
+			}
+		case strings.HasPrefix(origin, "http://"), strings.HasPrefix(origin, "https://"):
+			// okay
+		default:
+			errs = append(errs, fmt.Errorf("%w: %q", ErrCORSInvalidOrigin, origin))
+		}
+	}
+
+	if c.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrCORSInvalidMaxAge, c.MaxAge))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: cors entry is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidCriticalPath = errors.New("config.CriticalPaths: paths must start with / and may only end in *")
+
+// DefaultCriticalPaths are the paths that are always allowed unless the
+// policy disables them, so that a new policy doesn't break certificate
+// issuance or crawlers reading robots.txt.
+var DefaultCriticalPaths = []string{
+	"/.well-known/acme-challenge/*",
+	"/.well-known/security.txt",
+	"/security.txt",
+	"/robots.txt",
+	"/favicon.ico",
+}
+
+// CriticalPaths are paths that are allowed before any bot rule is checked.
+// A path that ends in * matches every path it is a prefix of.
+type CriticalPaths struct {
+	// Disable leaves out DefaultCriticalPaths.
+	Disable bool `json:"disable,omitempty"`
+
+	// Paths are allowed in addition to DefaultCriticalPaths.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// All returns every critical path.
+func (c CriticalPaths) All() []string {
+	if c.Disable {
+		return c.Paths
+	}
+
+	return append(append([]string{}, DefaultCriticalPaths...), c.Paths...)
+}
+
+// PathRegex returns a regular expression that matches the critical paths,
+// or an empty string if there are none.
+func (c CriticalPaths) PathRegex() string {
+	paths := c.All()
+	if len(paths) == 0 {
+		return ""
+	}
+
+	alternatives := make([]string, len(paths))
+	for i, p := range paths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			alternatives[i] = regexp.QuoteMeta(prefix)
+		} else {
+			alternatives[i] = regexp.QuoteMeta(p) + "$"
+		}
+	}
+
+	return "^(?:" + strings.Join(alternatives, "|") + ")"
+}
+
+func (c CriticalPaths) Valid() error {
+	var errs []error
+
+	for _, p := range c.Paths {
+		if !strings.HasPrefix(p, "/") || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidCriticalPath, p))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: critical paths are not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrDecisionAPIInvalidURL     = errors.New("config.DecisionAPI: url must be an http or https URL")
+	ErrDecisionAPIInvalidTimeout = errors.New("config.DecisionAPI: timeout must be a positive duration like 500ms")
+	ErrDecisionAPIInvalidOnError = errors.New("config.DecisionAPI: on_error must be ALLOW, DENY, CHALLENGE, or CAPTCHA")
+)
+
+// DefaultDecisionAPITimeout is how long Anubis waits for a decision API if
+// the rule doesn't say otherwise.
+const DefaultDecisionAPITimeout = time.Second
+
+// DecisionAPI asks an external service, such as Open Policy Agent, what to
+// do with requests that match a bot rule.
+type DecisionAPI struct {
+	URL string `json:"url"`
+	// Timeout is how long to wait for an answer, as a duration like 500ms.
+	Timeout string `json:"timeout,omitempty"`
+	// OnError is the action for requests the service can't decide on in
+	// time. ALLOW fails open and DENY fails closed. Defaults to CHALLENGE.
+	OnError Rule `json:"on_error,omitempty"`
+}
+
+// TimeoutDuration returns the timeout, or the default if none is set.
+func (d DecisionAPI) TimeoutDuration() time.Duration {
+	timeout, err := time.ParseDuration(d.Timeout)
+	if err != nil || timeout <= 0 {
+		return DefaultDecisionAPITimeout
+	}
+
+	return timeout
+}
+
+// OnErrorRule returns the action for requests the service can't decide on.
+func (d DecisionAPI) OnErrorRule() Rule {
+	if d.OnError == RuleUnknown {
+		return RuleChallenge
+	}
+
+	return d.OnError
+}
+
+func (d DecisionAPI) Valid() error {
+	var errs []error
+
+	u, err := url.Parse(d.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrDecisionAPIInvalidURL, d.URL))
+	}
+
+	if d.Timeout != "" {
+		if timeout, err := time.ParseDuration(d.Timeout); err != nil || timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrDecisionAPIInvalidTimeout, d.Timeout))
+		}
+	}
+
+	switch d.OnError {
+	case RuleUnknown, RuleAllow, RuleDeny, RuleChallenge, RuleCaptcha:
+		// okay
+	default:
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrDecisionAPIInvalidOnError, d.OnError))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: decision_api entry is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrDecoyInvalidParagraphs = errors.New("config.Decoy: paragraphs must be between 0 and 100")
+	ErrDecoyInvalidLinks      = errors.New("config.Decoy: links must be between 0 and 100")
+)
+
+// Defaults for the pages served to requests matched by DECOY rules.
+const (
+	DefaultDecoyParagraphs = 8
+	DefaultDecoyLinks      = 10
+)
+
+// Decoy sets what the pages served to requests matched by DECOY rules look
+// like.
+type Decoy struct {
+	// Paragraphs is how many paragraphs of text a page has.
+	Paragraphs int `json:"paragraphs,omitempty"`
+
+	// Links is how many links to other decoy pages a page has.
+	Links int `json:"links,omitempty"`
+}
+
+// ParagraphCount returns the number of paragraphs, or the default if none is
+// set.
+func (d Decoy) ParagraphCount() int {
+	if d.Paragraphs > 0 {
+		return d.Paragraphs
+	}
+
+	return DefaultDecoyParagraphs
+}
+
+// LinkCount returns the number of links, or the default if none is set.
+func (d Decoy) LinkCount() int {
+	if d.Links > 0 {
+		return d.Links
+	}
+
+	return DefaultDecoyLinks
+}
+
+func (d Decoy) Valid() error {
+	var errs []error
+
+	if d.Paragraphs < 0 || d.Paragraphs > 100 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrDecoyInvalidParagraphs, d.Paragraphs))
+	}
+
+	if d.Links < 0 || d.Links > 100 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrDecoyInvalidLinks, d.Links))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: decoy is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validHeaders validates the headers_missing and headers_equal matchers of a
+// bot rule or condition.
+func validHeaders(missing []string, equal map[string][]string) []error {
+	var errs []error
+
+	for _, name := range missing {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidHeaderName, name))
+		}
+	}
+
+	for name, values := range equal {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidHeaderName, name))
+		}
+
+		if len(values) == 0 {
+			errs = append(errs, fmt.Errorf("%w, got none for %q", ErrHeadersEqualEmpty, name))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIPFeedNoName          = errors.New("config.IPFeed: name must be set")
+	ErrIPFeedDuplicateName   = errors.New("config.IPFeed: names must be unique")
+	ErrIPFeedUnknownType     = errors.New("config.IPFeed: type must be dnsbl or http")
+	ErrIPFeedInvalidZone     = errors.New("config.IPFeed: dnsbl feeds must set zone to a DNS name like dnsbl.dronebl.org")
+	ErrIPFeedInvalidURL      = errors.New("config.IPFeed: http feeds must set url to an http or https URL")
+	ErrIPFeedInvalidTTL      = errors.New("config.IPFeed: ttl must be a positive duration like 1h")
+	ErrIPFeedInvalidAction   = errors.New("config.IPFeed: action must be ALLOW, DENY, CHALLENGE, or CAPTCHA")
+	ErrIPFeedDroneBLReserve  = errors.New("config.IPFeed: the name dronebl is used by dnsbl: true")
+	ErrIPFeedInvalidResolver = errors.New("config.IPFeed: resolver must be an IP address, optionally with a port, like 127.0.0.1:53")
+	ErrIPFeedInvalidTimeout  = errors.New("config.IPFeed: timeout must be a positive duration like 2s")
+	ErrIPFeedInvalidFailMode = errors.New("config.IPFeed: fail_mode must be open or closed")
+	ErrIPFeedDNSBLOnly       = errors.New("config.IPFeed: resolver, timeout and async can only be set for dnsbl feeds")
+	ErrIPFeedAsyncFailClosed = errors.New("config.IPFeed: async feeds can't fail closed")
+)
+
+const (
+	IPFeedDNSBL = "dnsbl"
+	IPFeedHTTP  = "http"
+)
+
+// DroneBLZone is the DNS zone of DroneBL, which dnsbl: true looks up
+// clients in.
+const DroneBLZone = "dnsbl.dronebl.org"
+
+// Default TTLs of IP feeds by type.
+const (
+	DefaultDNSBLTTL = 24 * time.Hour
+	DefaultHTTPTTL  = time.Hour
+)
+
+// DefaultDNSBLTimeout is how long a dnsbl lookup may take by default.
+const DefaultDNSBLTimeout = 2 * time.Second
+
+// FailMode is what happens to clients when an IP feed can't be checked.
+type FailMode string
+
+const (
+	// FailOpen treats clients as not listed. This is the default.
+	FailOpen FailMode = "open"
+
+	// FailClosed treats clients as listed.
+	FailClosed FailMode = "closed"
+)
+
+// IPFeed is a source of IP address reputation, such as a DNSBL or a list of
+// IP addresses and CIDR ranges on a web server. Clients it lists get its
+// action instead of the action of the bot rule they matched, unless that
+// rule denies them.
+type IPFeed struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Zone is the DNS zone of a dnsbl feed, such as zen.spamhaus.org.
+	Zone string `json:"zone,omitempty"`
+	// URL is where an http feed is downloaded from.
+	URL string `json:"url,omitempty"`
+	// TTL is how long dnsbl answers are cached or how often http feeds are
+	// downloaded again, as a duration like 1h.
+	TTL string `json:"ttl,omitempty"`
+	// Action is what to do with listed clients. Defaults to DENY.
+	Action Rule `json:"action,omitempty"`
+
+	// Resolver is the DNS server dnsbl feeds are queried through, such as
+	// 127.0.0.1:53. Defaults to the system resolver.
+	Resolver string `json:"resolver,omitempty"`
+
+	// Timeout is how long a dnsbl lookup may take, as a duration like 2s.
+	Timeout string `json:"timeout,omitempty"`
+
+	// Async makes dnsbl lookups happen in the background. Requests from
+	// clients that weren't looked up yet are let through, and the answer
+	// applies to the requests after it.
+	Async bool `json:"async,omitempty"`
+
+	// FailMode is what happens to clients when the feed can't be checked.
+	// Defaults to open.
+	FailMode FailMode `json:"fail_mode,omitempty"`
+}
+
+// TTLDuration returns the TTL, or the default for the type of feed if none
+// is set.
+func (f IPFeed) TTLDuration() time.Duration {
+	if ttl, err := time.ParseDuration(f.TTL); err == nil && ttl > 0 {
+		return ttl
+	}
+
+	if f.Type == IPFeedHTTP {
+		return DefaultHTTPTTL
+	}
+	return DefaultDNSBLTTL
+}
+
+// TimeoutDuration returns how long a dnsbl lookup may take.
+func (f IPFeed) TimeoutDuration() time.Duration {
+	if timeout, err := time.ParseDuration(f.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+
+	return DefaultDNSBLTimeout
+}
+
+// ResolverAddress returns the address of the DNS server to query, with the
+// port defaulting to 53, or an empty string for the system resolver.
+func (f IPFeed) ResolverAddress() string {
+	if f.Resolver == "" {
+		return ""
+	}
+
+	if _, _, err := net.SplitHostPort(f.Resolver); err == nil {
+		return f.Resolver
+	}
+
+	return net.JoinHostPort(strings.Trim(f.Resolver, "[]"), "53")
+}
+
+// ActionRule returns the action for listed clients.
+func (f IPFeed) ActionRule() Rule {
+	if f.Action == RuleUnknown {
+		return RuleDeny
+	}
+
+	return f.Action
+}
+
+func (f IPFeed) Valid() error {
+	var errs []error
+
+	if f.Name == "" {
+		errs = append(errs, ErrIPFeedNoName)
+	}
+
+	switch f.Type {
+	case IPFeedDNSBL:
+		if f.Zone == "" || strings.ContainsAny(f.Zone, "/: ") {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidZone, f.Zone))
+		}
+	case IPFeedHTTP:
+		u, err := url.Parse(f.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidURL, f.URL))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedUnknownType, f.Type))
+	}
+
+	if f.TTL != "" {
+		if ttl, err := time.ParseDuration(f.TTL); err != nil || ttl <= 0 {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidTTL, f.TTL))
+		}
+	}
+
+	switch f.Action {
+	case RuleUnknown, RuleAllow, RuleDeny, RuleChallenge, RuleCaptcha:
+		// okay
+	default:
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidAction, f.Action))
+	}
+
+	if f.Type != IPFeedDNSBL && (f.Resolver != "" || f.Timeout != "" || f.Async) {
+		errs = append(errs, ErrIPFeedDNSBLOnly)
+	}
+
+	if f.Resolver != "" {
+		host := f.Resolver
+		if h, _, err := net.SplitHostPort(f.Resolver); err == nil {
+			host = h
+		}
+		if net.ParseIP(strings.Trim(host, "[]")) == nil {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidResolver, f.Resolver))
+		}
+	}
+
+	if f.Timeout != "" {
+		if timeout, err := time.ParseDuration(f.Timeout); err != nil || timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidTimeout, f.Timeout))
+		}
+	}
+
+	switch f.FailMode {
+	case "", FailOpen:
+		// okay
+	case FailClosed:
+		if f.Async {
+			errs = append(errs, ErrIPFeedAsyncFailClosed)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrIPFeedInvalidFailMode, f.FailMode))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: ip_feeds entry %q is not valid:\n%w", f.Name, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func validIPFeeds(feeds []IPFeed, dnsbl bool) error {
+	var errs []error
+
+	seen := map[string]bool{}
+	for _, f := range feeds {
+		if err := f.Valid(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if dnsbl && f.Name == "dronebl" {
+			errs = append(errs, ErrIPFeedDroneBLReserve)
+		}
+
+		if seen[f.Name] {
+			errs = append(errs, fmt.Errorf("%w, got %q twice", ErrIPFeedDuplicateName, f.Name))
+		}
+		seen[f.Name] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// ipFeeds returns the feeds of a policy file. dnsbl: true adds DroneBL in
+// front of the others.
+func ipFeeds(feeds []IPFeed, dnsbl bool) []IPFeed {
+	if !dnsbl {
+		return feeds
+	}
+
+	return append([]IPFeed{{
+		Name:   "dronebl",
+		Type:   IPFeedDNSBL,
+		Zone:   DroneBLZone,
+		Action: RuleDeny,
+	}}, feeds...)
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrIssuanceInvalidRate          = errors.New("config.ChallengeIssuance: rate must not be negative")
+	ErrIssuanceInvalidBurst         = errors.New("config.ChallengeIssuance: burst must not be negative and needs rate to be set")
+	ErrIssuanceInvalidMaxConcurrent = errors.New("config.ChallengeIssuance: max_concurrent must not be negative")
+)
+
+// ChallengeIssuance limits how many challenges Anubis hands out, as every
+// challenge page costs some work, and fetching Open Graph tags for it even
+// more. Clients over a limit get 429 Too Many Requests. Zero values leave
+// the limits off.
+type ChallengeIssuance struct {
+	// Rate is the number of challenges per second each IP address may get
+	// on average.
+	Rate float64 `json:"rate,omitempty"`
+
+	// Burst is how many challenges an IP address may get at once. Defaults
+	// to one.
+	Burst int `json:"burst,omitempty"`
+
+	// MaxConcurrent is how many challenges may be issued at the same time
+	// across all clients.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+func (ci ChallengeIssuance) Valid() error {
+	var errs []error
+
+	if ci.Rate < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %v", ErrIssuanceInvalidRate, ci.Rate))
+	}
+
+	if ci.Burst < 0 || (ci.Burst > 0 && ci.Rate == 0) {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrIssuanceInvalidBurst, ci.Burst))
+	}
+
+	if ci.MaxConcurrent < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrIssuanceInvalidMaxConcurrent, ci.MaxConcurrent))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: challenge_issuance is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
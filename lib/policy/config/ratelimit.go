@@ -0,0 +1,54 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrRateLimitUnknownAction = errors.New("config.RateLimit: rate limits can only be set for the ALLOW and CHALLENGE actions")
+	ErrRateLimitInvalidRate   = errors.New("config.RateLimit: rate must be greater than zero")
+	ErrRateLimitInvalidBurst  = errors.New("config.RateLimit: burst must not be negative")
+)
+
+// RateLimit limits how many requests each client may send to the target
+// once a rule with a given action has let them through.
+type RateLimit struct {
+	// Rate is the number of requests per second a client may make on average.
+	Rate float64 `json:"rate"`
+	// Burst is how many requests a client may make at once. Defaults to one.
+	Burst int `json:"burst,omitempty"`
+}
+
+func (rl RateLimit) Valid() error {
+	var errs []error
+
+	if rl.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %v", ErrRateLimitInvalidRate, rl.Rate))
+	}
+
+	if rl.Burst < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrRateLimitInvalidBurst, rl.Burst))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validRateLimits(limits map[Rule]RateLimit) error {
+	var errs []error
+
+	for action, rl := range limits {
+		switch action {
+		case RuleAllow, RuleChallenge:
+			// okay
+		default:
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrRateLimitUnknownAction, action))
+		}
+
+		if err := rl.Valid(); err != nil {
+			errs = append(errs, fmt.Errorf("config: rate limit for %s is not valid:\n%w", action, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrRedirectInvalidURL    = errors.New("config.Redirect: url must be an absolute http or https URL or a path starting with a slash")
+	ErrRedirectInvalidStatus = errors.New("config.Redirect: status must be 301, 302, 303, 307 or 308")
+)
+
+// Redirect sets where requests matched by REDIRECT rules are sent.
+type Redirect struct {
+	// URL is where clients are redirected to, either an absolute URL or a
+	// path on the same host.
+	URL string `json:"url"`
+
+	// Status is the status code of the redirect. Defaults to 302.
+	Status int `json:"status,omitempty"`
+
+	// KeepPath appends the path and query of the request to URL, so that a
+	// mirror of the site gets the page that was asked for.
+	KeepPath bool `json:"keep_path,omitempty"`
+}
+
+// StatusCode returns the status code of the redirect, or 302 if none is set.
+func (r Redirect) StatusCode() int {
+	if r.Status != 0 {
+		return r.Status
+	}
+
+	return http.StatusFound
+}
+
+// Location returns where a request for reqURL is redirected to.
+func (r Redirect) Location(reqURL *url.URL) string {
+	if !r.KeepPath {
+		return r.URL
+	}
+
+	loc := strings.TrimSuffix(r.URL, "/") + reqURL.EscapedPath()
+	if reqURL.RawQuery != "" {
+		loc += "?" + reqURL.RawQuery
+	}
+
+	return loc
+}
+
+func (r Redirect) Valid() error {
+	var errs []error
+
+	if u, err := url.Parse(r.URL); err != nil || !(u.Scheme == "http" || u.Scheme == "https") || u.Host == "" {
+		if !strings.HasPrefix(r.URL, "/") || strings.HasPrefix(r.URL, "//") {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrRedirectInvalidURL, r.URL))
+		}
+	}
+
+	switch r.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrRedirectInvalidStatus, r.Status))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: redirect is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
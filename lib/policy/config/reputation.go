@@ -0,0 +1,40 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrReputationNoBounds   = errors.New("config.Reputation: must set above, below, or both")
+	ErrReputationEmptyRange = errors.New("config.Reputation: above must be less than below")
+)
+
+// Reputation matches clients by their reputation score, which goes from
+// -100 to 100. New clients start at zero. Passing challenges and browsing
+// normally raises the score, failing challenges and being denied lowers it,
+// and it decays back towards zero over time.
+type Reputation struct {
+	// Above matches clients with a score greater than this.
+	Above *float64 `json:"above,omitempty"`
+	// Below matches clients with a score less than this.
+	Below *float64 `json:"below,omitempty"`
+}
+
+func (rep Reputation) Valid() error {
+	var errs []error
+
+	if rep.Above == nil && rep.Below == nil {
+		errs = append(errs, ErrReputationNoBounds)
+	}
+
+	if rep.Above != nil && rep.Below != nil && *rep.Above >= *rep.Below {
+		errs = append(errs, fmt.Errorf("%w, got: above %v and below %v", ErrReputationEmptyRange, *rep.Above, *rep.Below))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: reputation is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
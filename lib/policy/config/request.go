@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// validRequest validates the methods and query_regex matchers of a bot rule
+// or condition.
+func validRequest(methods []string, queryRegex map[string]string) []error {
+	var errs []error
+
+	for _, method := range methods {
+		if !httpMethod.MatchString(method) {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidMethod, method))
+		}
+	}
+
+	for name, expr := range queryRegex {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = append(errs, fmt.Errorf("%w for %q", ErrInvalidQueryRegex, name), err)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"text/template"
+)
+
+var (
+	ErrResponseInvalidStatus = errors.New("config.Response: status must be between 200 and 599")
+	ErrResponseInvalidFormat = errors.New("config.Response: format must be html, json, or text")
+	ErrResponseInvalidBody   = errors.New("config.Response: body is not a valid template")
+)
+
+// Formats of responses.
+const (
+	ResponseHTML = "html"
+	ResponseJSON = "json"
+	ResponseText = "text"
+)
+
+// Response is what requests matched by STATUS rules get, or how requests
+// matched by DENY rules are denied.
+type Response struct {
+	// Status is the status code of the response. STATUS rules must set it,
+	// DENY rules default to 200.
+	Status int `json:"status,omitempty"`
+
+	// Format is the format of the response when Body is unset: the deny
+	// page (html), a JSON object (json), or the deny message (text). It
+	// also sets the default content type.
+	Format string `json:"format,omitempty"`
+
+	// ContentType is the content type of the response. Defaults to the one
+	// of Format, or plain text.
+	ContentType string `json:"content_type,omitempty"`
+
+	// Body is a text/template for the body of the response. It can use
+	// {{.Rule}}, {{.ID}} and {{.Message}}.
+	Body string `json:"body,omitempty"`
+}
+
+// ContentTypeOrDefault returns the content type of the response, or the one
+// of its format if none is set.
+func (r Response) ContentTypeOrDefault() string {
+	if r.ContentType != "" {
+		return r.ContentType
+	}
+
+	switch r.Format {
+	case ResponseHTML:
+		return "text/html; charset=utf-8"
+	case ResponseJSON:
+		return "application/json"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// StatusOr returns the status code of the response, or def if none is set.
+func (r Response) StatusOr(def int) int {
+	if r.Status != 0 {
+		return r.Status
+	}
+
+	return def
+}
+
+// BodyTemplate parses Body. It returns nil if Body is unset.
+func (r Response) BodyTemplate() (*template.Template, error) {
+	if r.Body == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("body").Option("missingkey=error").Parse(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrResponseInvalidBody, err)
+	}
+
+	return tmpl, nil
+}
+
+func (r Response) Valid() error {
+	var errs []error
+
+	if r.Status != 0 && (r.Status < 200 || r.Status > 599) {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrResponseInvalidStatus, r.Status))
+	}
+
+	switch r.Format {
+	case "", ResponseHTML, ResponseJSON, ResponseText:
+	default:
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrResponseInvalidFormat, r.Format))
+	}
+
+	if _, err := r.BodyTemplate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: response is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var (
+	ErrRobotsTXTInvalidCrawlDelay = errors.New("config.RobotsTXT: crawl_delay must be between 0 and 3600 seconds")
+	ErrRobotsTXTInvalidSitemap    = errors.New("config.RobotsTXT: sitemap must be an absolute http or https URL")
+	ErrRobotsTXTNotGenerated      = errors.New("config.RobotsTXT: crawl_delay, sitemap and disallow_all need generate to be set")
+)
+
+// RobotsTXT sets how the robots.txt that Anubis serves is generated from the
+// policy.
+type RobotsTXT struct {
+	// Generate makes Anubis serve a robots.txt that disallows the user
+	// agents that DENY, TARPIT and DECOY rules match.
+	Generate bool `json:"generate,omitempty"`
+
+	// CrawlDelay, if set, is the Crawl-delay in seconds asked of the user
+	// agents that CHALLENGE rules match.
+	CrawlDelay int `json:"crawl_delay,omitempty"`
+
+	// Sitemap, if set, is the URL of the sitemap of the site.
+	Sitemap string `json:"sitemap,omitempty"`
+
+	// DisallowAll disallows every other user agent too.
+	DisallowAll bool `json:"disallow_all,omitempty"`
+}
+
+func (r RobotsTXT) Valid() error {
+	var errs []error
+
+	if !r.Generate && (r.CrawlDelay != 0 || r.Sitemap != "" || r.DisallowAll) {
+		errs = append(errs, ErrRobotsTXTNotGenerated)
+	}
+
+	if r.CrawlDelay < 0 || r.CrawlDelay > 3600 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrRobotsTXTInvalidCrawlDelay, r.CrawlDelay))
+	}
+
+	if r.Sitemap != "" {
+		u, err := url.Parse(r.Sitemap)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrRobotsTXTInvalidSitemap, r.Sitemap))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: robots_txt is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var (
+	ErrRouteMustHaveHostOrPath     = errors.New("config.Route: must set host or path_prefix")
+	ErrInvalidRouteHost            = errors.New("config.Route: host must be a host name or a wildcard like *.example.com")
+	ErrInvalidRoutePathPrefix      = errors.New("config.Route: path_prefix must start with a slash")
+	ErrInvalidRouteTarget          = errors.New("config.Route: target must be an http, https, h2c, unix, fastcgi, or fastcgi+unix URL")
+	ErrRouteDifficultyOutOfRange   = errors.New("config.Route: difficulty must be between 0 and 64")
+	ErrRouteMustSetTargetOrOptions = errors.New("config.Route: must set target, difficulty, or bots")
+)
+
+// fileRoute is a route as it is written in the policy file, before imports
+// in its bot rules are loaded.
+type fileRoute struct {
+	Host       string        `json:"host,omitempty"`
+	PathPrefix string        `json:"path_prefix,omitempty"`
+	Target     string        `json:"target,omitempty"`
+	Difficulty int           `json:"difficulty,omitempty"`
+	Bots       []BotOrImport `json:"bots,omitempty"`
+}
+
+func (fr fileRoute) Valid() error {
+	var botErrs []error
+	for _, b := range fr.Bots {
+		if err := b.Valid(); err != nil {
+			botErrs = append(botErrs, err)
+		}
+	}
+
+	return fr.route().valid(len(fr.Bots), botErrs)
+}
+
+func (fr fileRoute) route() Route {
+	return Route{
+		Host:       fr.Host,
+		PathPrefix: fr.PathPrefix,
+		Target:     fr.Target,
+		Difficulty: fr.Difficulty,
+	}
+}
+
+// Route sends requests for a host, a path prefix, or both to their own
+// target, and lets them be checked against their own bot rules before the
+// global ones.
+type Route struct {
+	Host       string
+	PathPrefix string
+	Target     string
+	Difficulty int
+	Bots       []BotConfig
+}
+
+// HasPathPrefix reports whether path is prefix or a path below it. Unless
+// prefix ends in a slash, the rest of path must start with one, so that /api
+// matches /api/v1 but not /apiary.
+func HasPathPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || strings.HasSuffix(prefix, "/") || strings.HasPrefix(rest, "/"))
+}
+
+// name describes the requests the route is for in error messages.
+func (r Route) name() string {
+	return r.Host + r.PathPrefix
+}
+
+func (r Route) Valid() error {
+	var botErrs []error
+	for _, b := range r.Bots {
+		if err := b.Valid(); err != nil {
+			botErrs = append(botErrs, err)
+		}
+	}
+
+	return r.valid(len(r.Bots), botErrs)
+}
+
+// valid checks the settings of the route, given the number of its bot rules
+// and the errors they have. Routes in the policy file have their imports
+// loaded later, so they can't use Valid.
+func (r Route) valid(bots int, botErrs []error) error {
+	var errs []error
+
+	if r.Host == "" && r.PathPrefix == "" {
+		errs = append(errs, ErrRouteMustHaveHostOrPath)
+	}
+
+	if host := strings.TrimPrefix(r.Host, "*."); r.Host != "" && (!dnsDomain.MatchString(host) || strings.HasPrefix(host, ".")) {
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrInvalidRouteHost, r.Host))
+	}
+
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidRoutePathPrefix, r.PathPrefix))
+	}
+
+	if r.Target != "" {
+		if err := validTarget(r.Target, ErrInvalidRouteTarget); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if r.Difficulty < 0 || r.Difficulty > 64 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrRouteDifficultyOutOfRange, r.Difficulty))
+	}
+
+	errs = append(errs, botErrs...)
+
+	if r.Target == "" && r.Difficulty == 0 && bots == 0 {
+		errs = append(errs, ErrRouteMustSetTargetOrOptions)
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: route for %q is not valid:\n%w", r.name(), errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// validTarget checks that target is a URL Anubis can proxy requests to,
+// wrapping sentinel if it isn't.
+func validTarget(target string, sentinel error) error {
+	u, err := url.Parse(target)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w, got: %q: %w", sentinel, target, err)
+	case u.Scheme == "unix" && u.Path != "":
+		return nil
+	case (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "h2c") && u.Host != "":
+		return nil
+	case (u.Scheme == "fastcgi" && u.Host != "") || (u.Scheme == "fastcgi+unix" && u.Path != ""):
+		// FastCGI responders need to know where the scripts are.
+		if !path.IsAbs(u.Query().Get("root")) {
+			return fmt.Errorf("%w, got: %q without an absolute ?root=", sentinel, target)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w, got: %q", sentinel, target)
+	}
+}
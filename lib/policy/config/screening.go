@@ -0,0 +1,51 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidScreeningRate = errors.New("config: secondary_screening must be \"always\", \"never\", or a number between 0 and 1")
+
+// DefaultSecondaryScreening is the fraction of requests with a valid cookie
+// that are fully re-verified if the policy doesn't say otherwise.
+const DefaultSecondaryScreening ScreeningRate = 0.11
+
+// ScreeningRate is the fraction of requests with a valid cookie whose
+// challenge response is checked again, rather than only the cookie's
+// signature. In policy files it is a number between 0 and 1, or "always" or
+// "never".
+type ScreeningRate float64
+
+func (sr *ScreeningRate) UnmarshalJSON(data []byte) error {
+	var word string
+	if err := json.Unmarshal(data, &word); err == nil {
+		switch strings.ToLower(word) {
+		case "always":
+			*sr = 1
+		case "never":
+			*sr = 0
+		default:
+			return fmt.Errorf("%w, got: %q", ErrInvalidScreeningRate, word)
+		}
+		return nil
+	}
+
+	var rate float64
+	if err := json.Unmarshal(data, &rate); err != nil {
+		return fmt.Errorf("%w, got: %s", ErrInvalidScreeningRate, data)
+	}
+
+	*sr = ScreeningRate(rate)
+	return nil
+}
+
+func (sr ScreeningRate) Valid() error {
+	if sr < 0 || sr > 1 {
+		return fmt.Errorf("%w, got: %v", ErrInvalidScreeningRate, float64(sr))
+	}
+
+	return nil
+}
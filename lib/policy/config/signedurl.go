@@ -0,0 +1,171 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of signed URLs.
+const (
+	SignedURLTimestampParam = "anubis_ts"
+	SignedURLSignatureParam = "anubis_sig"
+)
+
+// DefaultSignedURLMaxAge is how long signed URLs are valid for if max_age is
+// unset.
+const DefaultSignedURLMaxAge = 5 * time.Minute
+
+// signedURLSkew is how far in the future the timestamp of a signed URL may
+// be, for clocks that are a bit off.
+const signedURLSkew = time.Minute
+
+var (
+	ErrSignedURLNameRequired   = errors.New("config.SignedURL: name is required")
+	ErrSignedURLInvalidPrefix  = errors.New("config.SignedURL: path_prefix must start with /")
+	ErrSignedURLSecretRequired = errors.New("config.SignedURL: exactly one of secret and secret_env must be set")
+	ErrSignedURLSecretEmpty    = errors.New("config.SignedURL: the environment variable in secret_env is empty")
+	ErrSignedURLSecretTooShort = errors.New("config.SignedURL: secret must be at least 32 characters long")
+	ErrSignedURLInvalidMaxAge  = errors.New("config.SignedURL: max_age must be a duration like 720h")
+
+	ErrSignedURLMissing   = errors.New("config.SignedURL: URL is not signed")
+	ErrSignedURLExpired   = errors.New("config.SignedURL: URL is expired")
+	ErrSignedURLSignature = errors.New("config.SignedURL: signature does not match")
+)
+
+// SignedURL lets requests to paths under PathPrefix skip the policy if they
+// carry a timestamp and an HMAC of the method, path and query made with
+// Secret, so that services that can't solve challenges, such as payment callbacks
+// and Git hooks, can reach them.
+type SignedURL struct {
+	Name       string `json:"name"`
+	PathPrefix string `json:"path_prefix"`
+
+	// Secret is the shared secret. SecretEnv names an environment variable
+	// to read it from instead, so that it doesn't have to be in the policy
+	// file.
+	Secret    string `json:"secret,omitempty"`
+	SecretEnv string `json:"secret_env,omitempty"`
+
+	// MaxAge is how long after signing URLs are valid for. If unset,
+	// DefaultSignedURLMaxAge is used.
+	MaxAge string `json:"max_age,omitempty"`
+}
+
+// Key returns the shared secret.
+func (s SignedURL) Key() []byte {
+	if s.SecretEnv != "" {
+		return []byte(os.Getenv(s.SecretEnv))
+	}
+
+	return []byte(s.Secret)
+}
+
+// MaxAgeDuration returns how long signed URLs are valid for.
+func (s SignedURL) MaxAgeDuration() time.Duration {
+	if d, err := time.ParseDuration(s.MaxAge); err == nil && d > 0 {
+		return d
+	}
+
+	return DefaultSignedURLMaxAge
+}
+
+// Matches reports whether path is under the path prefix.
+func (s SignedURL) Matches(path string) bool {
+	return HasPathPrefix(path, s.PathPrefix)
+}
+
+// signature returns the HMAC of method, path and the query q, which must
+// include the timestamp but not the signature. Encode sorts q by key, so the
+// order the parameters are sent in doesn't matter.
+func (s SignedURL) signature(method, path string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.Key())
+	mac.Write([]byte(method + "\n" + path + "\n" + q.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns a copy of u with the timestamp t and its signature for
+// requests with method in the query string.
+func (s SignedURL) Sign(method string, u *url.URL, t time.Time) *url.URL {
+	q := u.Query()
+	q.Del(SignedURLSignatureParam)
+	q.Set(SignedURLTimestampParam, strconv.FormatInt(t.Unix(), 10))
+	sig := s.signature(method, u.Path, q)
+	q.Set(SignedURLSignatureParam, sig)
+
+	signed := *u
+	signed.RawQuery = q.Encode()
+	return &signed
+}
+
+// Verify checks the timestamp and signature in the query string of u for a
+// request with method. Every other query parameter is signed too, so a
+// signed URL can't be reused with another method or query.
+func (s SignedURL) Verify(method string, u *url.URL) error {
+	q := u.Query()
+	ts, sig := q.Get(SignedURLTimestampParam), q.Get(SignedURLSignatureParam)
+	if ts == "" || sig == "" {
+		return ErrSignedURLMissing
+	}
+
+	q.Del(SignedURLSignatureParam)
+	want := s.signature(method, u.Path, q)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return ErrSignedURLSignature
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrSignedURLSignature
+	}
+
+	signed := time.Unix(unix, 0)
+	if time.Until(signed) > signedURLSkew {
+		return ErrSignedURLExpired
+	}
+	if time.Since(signed) > s.MaxAgeDuration() {
+		return ErrSignedURLExpired
+	}
+
+	return nil
+}
+
+func (s SignedURL) Valid() error {
+	var errs []error
+
+	if s.Name == "" {
+		errs = append(errs, ErrSignedURLNameRequired)
+	}
+
+	if !strings.HasPrefix(s.PathPrefix, "/") {
+		errs = append(errs, fmt.Errorf("%w, got: %q", ErrSignedURLInvalidPrefix, s.PathPrefix))
+	}
+
+	switch {
+	case (s.Secret == "") == (s.SecretEnv == ""):
+		errs = append(errs, ErrSignedURLSecretRequired)
+	case s.SecretEnv != "" && len(s.Key()) == 0:
+		errs = append(errs, fmt.Errorf("%w: %s", ErrSignedURLSecretEmpty, s.SecretEnv))
+	case len(s.Key()) < 32:
+		errs = append(errs, ErrSignedURLSecretTooShort)
+	}
+
+	if s.MaxAge != "" {
+		if d, err := time.ParseDuration(s.MaxAge); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrSignedURLInvalidMaxAge, s.MaxAge))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: signed url %s is not valid:\n%w", s.Name, errors.Join(errs...))
+	}
+
+	return nil
+}
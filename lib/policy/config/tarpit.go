@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTarpitInvalidRate           = errors.New("config.Tarpit: rate must be greater than zero")
+	ErrTarpitInvalidDuration       = errors.New("config.Tarpit: duration must be a positive duration like 1m")
+	ErrTarpitInvalidMaxConnections = errors.New("config.Tarpit: max_connections must not be negative")
+)
+
+// Defaults for requests matched by TARPIT rules.
+const (
+	DefaultTarpitRate           = 1.0
+	DefaultTarpitDuration       = time.Minute
+	DefaultTarpitMaxConnections = 100
+)
+
+// Tarpit sets how requests matched by TARPIT rules are held.
+type Tarpit struct {
+	// Rate is how many bytes per second are sent. Defaults to one.
+	Rate float64 `json:"rate,omitempty"`
+
+	// Duration is how long a request is held before the connection is
+	// closed, as a duration like 1m.
+	Duration string `json:"duration,omitempty"`
+
+	// MaxConnections is how many requests may be held at once. Requests
+	// beyond that are denied right away.
+	MaxConnections int `json:"max_connections,omitempty"`
+}
+
+// BytesPerSecond returns the rate, or the default if none is set.
+func (t Tarpit) BytesPerSecond() float64 {
+	if t.Rate > 0 {
+		return t.Rate
+	}
+
+	return DefaultTarpitRate
+}
+
+// HoldDuration returns the duration, or the default if none is set.
+func (t Tarpit) HoldDuration() time.Duration {
+	if d, err := time.ParseDuration(t.Duration); err == nil && d > 0 {
+		return d
+	}
+
+	return DefaultTarpitDuration
+}
+
+// Connections returns the maximum number of held requests, or the default if
+// none is set.
+func (t Tarpit) Connections() int {
+	if t.MaxConnections > 0 {
+		return t.MaxConnections
+	}
+
+	return DefaultTarpitMaxConnections
+}
+
+func (t Tarpit) Valid() error {
+	var errs []error
+
+	if t.Rate < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %v", ErrTarpitInvalidRate, t.Rate))
+	}
+
+	if t.Duration != "" {
+		if d, err := time.ParseDuration(t.Duration); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrTarpitInvalidDuration, t.Duration))
+		}
+	}
+
+	if t.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("%w, got: %d", ErrTarpitInvalidMaxConnections, t.MaxConnections))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: tarpit is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
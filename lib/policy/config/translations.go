@@ -0,0 +1,20 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/vale981/anubis/lib/localization"
+)
+
+// Translations maps language tags like "de" or "pt-BR" to message IDs to
+// messages. They add languages to the pages Anubis renders or replace some
+// of the messages of the embedded ones.
+type Translations map[string]map[string]string
+
+func (t Translations) Valid() error {
+	if _, err := localization.New(t); err != nil {
+		return fmt.Errorf("config: translations are not valid:\n%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrWASMNoPath         = errors.New("config.WASMModule: path must be set")
+	ErrWASMInvalidTimeout = errors.New("config.WASMModule: timeout must be a positive duration like 50ms")
+)
+
+// DefaultWASMTimeout is how long a WebAssembly checker may run for each
+// request if the rule doesn't say otherwise.
+const DefaultWASMTimeout = 100 * time.Millisecond
+
+// WASMModule is a WebAssembly module that decides whether requests match a
+// bot rule.
+type WASMModule struct {
+	// Path is the file name of the module.
+	Path string `json:"path"`
+	// Timeout is how long the module may run for each request, as a
+	// duration like 50ms.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// TimeoutDuration returns the timeout, or the default if none is set.
+func (w WASMModule) TimeoutDuration() time.Duration {
+	timeout, err := time.ParseDuration(w.Timeout)
+	if err != nil || timeout <= 0 {
+		return DefaultWASMTimeout
+	}
+
+	return timeout
+}
+
+func (w WASMModule) Valid() error {
+	var errs []error
+
+	if w.Path == "" {
+		errs = append(errs, ErrWASMNoPath)
+	}
+
+	if w.Timeout != "" {
+		if timeout, err := time.ParseDuration(w.Timeout); err != nil || timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%w, got: %q", ErrWASMInvalidTimeout, w.Timeout))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("config: wasm entry is not valid:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
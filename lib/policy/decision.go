@@ -0,0 +1,204 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+var (
+	ErrDecisionAPIStatus = errors.New("policy: decision API returned an error")
+	ErrDecisionAPIResult = errors.New("policy: decision API returned an invalid result")
+
+	decisionAPIResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_decision_api_results",
+		Help: "The results of calls to decision APIs",
+	}, []string{"rule", "result"})
+)
+
+// maxDecisionAPIResponse is the largest response body read from a decision
+// API.
+const maxDecisionAPIResponse = 64 * 1024
+
+// DecisionAPI asks an external service what to do with a request. The
+// request is POSTed as JSON in the same shape Open Policy Agent expects:
+//
+//	{"input": {"method": "GET", "host": "example.com", "path": "/", ...}}
+//
+// The service answers with {"result": "DENY"} or
+// {"result": {"action": "DENY"}}. A missing or empty result means the service
+// has no opinion and the rule does not match.
+type DecisionAPI struct {
+	rule    string
+	url     string
+	onError config.Rule
+	client  *http.Client
+	hash    string
+}
+
+func NewDecisionAPI(rule string, conf config.DecisionAPI) (*DecisionAPI, error) {
+	if err := conf.Valid(); err != nil {
+		return nil, err
+	}
+
+	return &DecisionAPI{
+		rule:    rule,
+		url:     conf.URL,
+		onError: conf.OnErrorRule(),
+		client:  &http.Client{Timeout: conf.TimeoutDuration()},
+		hash:    internal.SHA256sum(fmt.Sprintf("decision_api: %s", conf.URL)),
+	}, nil
+}
+
+// decisionInput is the normalized description of a request sent to a
+// decision API.
+type decisionInput struct {
+	Rule       string              `json:"rule"`
+	Method     string              `json:"method"`
+	Host       string              `json:"host"`
+	Path       string              `json:"path"`
+	Query      map[string][]string `json:"query"`
+	Headers    map[string]string   `json:"headers"`
+	RemoteAddr string              `json:"remote_addr"`
+	UserAgent  string              `json:"user_agent"`
+}
+
+// privateHeader reports whether the request header name carries credentials
+// of the client or Anubis' own tokens, which must not be sent to the
+// decision API.
+func privateHeader(name string) bool {
+	switch name {
+	case "Cookie", "Authorization", "Proxy-Authorization":
+		return true
+	}
+
+	return strings.HasPrefix(name, "X-Anubis-")
+}
+
+func newDecisionInput(rule string, r *http.Request) decisionInput {
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		if privateHeader(http.CanonicalHeaderKey(k)) {
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	return decisionInput{
+		Rule:       rule,
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Headers:    headers,
+		RemoteAddr: r.Header.Get("X-Real-Ip"),
+		UserAgent:  r.UserAgent(),
+	}
+}
+
+// decisionResult accepts both a bare action and an object with an action as
+// the result.
+type decisionResult struct {
+	Action config.Rule
+}
+
+func (d *decisionResult) UnmarshalJSON(data []byte) error {
+	var action string
+	if err := json.Unmarshal(data, &action); err == nil {
+		d.Action = config.Rule(strings.ToUpper(action))
+		return nil
+	}
+
+	var obj struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+
+	d.Action = config.Rule(strings.ToUpper(obj.Action))
+	return nil
+}
+
+// Decide asks the decision API what to do with r. It returns RuleUnknown if
+// the service has no opinion. If the service can't be reached or its answer
+// makes no sense, Decide returns the on_error action along with the error.
+func (d *DecisionAPI) Decide(ctx context.Context, r *http.Request) (config.Rule, error) {
+	action, err := d.decide(ctx, r)
+	if err != nil {
+		decisionAPIResults.WithLabelValues(d.rule, "error").Inc()
+		return d.onError, err
+	}
+
+	decisionAPIResults.WithLabelValues(d.rule, string(action)).Inc()
+	return action, nil
+}
+
+func (d *DecisionAPI) decide(ctx context.Context, r *http.Request) (config.Rule, error) {
+	body, err := json.Marshal(map[string]any{"input": newDecisionInput(d.rule, r)})
+	if err != nil {
+		return config.RuleUnknown, fmt.Errorf("[unexpected] can't encode decision API request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return config.RuleUnknown, fmt.Errorf("can't create decision API request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return config.RuleUnknown, fmt.Errorf("can't call decision API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return config.RuleUnknown, fmt.Errorf("%w: status %d", ErrDecisionAPIStatus, resp.StatusCode)
+	}
+
+	var result struct {
+		Result *decisionResult `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDecisionAPIResponse)).Decode(&result); err != nil {
+		return config.RuleUnknown, fmt.Errorf("%w: %w", ErrDecisionAPIResult, err)
+	}
+
+	if result.Result == nil {
+		return config.RuleUnknown, nil
+	}
+
+	switch result.Result.Action {
+	case config.RuleUnknown, config.RuleAllow, config.RuleDeny, config.RuleChallenge, config.RuleCaptcha, config.RuleTarpit, config.RuleDecoy:
+		return result.Result.Action, nil
+	default:
+		return config.RuleUnknown, fmt.Errorf("%w: unknown action %q", ErrDecisionAPIResult, result.Result.Action)
+	}
+}
+
+func (d *DecisionAPI) Hash() string {
+	return d.hash
+}
+
+// alwaysChecker matches every request. It is used for rules that only ask a
+// decision API.
+type alwaysChecker struct{}
+
+func (alwaysChecker) Check(*http.Request) (bool, error) {
+	return true, nil
+}
+
+func (alwaysChecker) Hash() string {
+	return internal.SHA256sum("always")
+}
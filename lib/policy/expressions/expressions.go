@@ -0,0 +1,139 @@
+// Package expressions compiles the CEL expressions that bot rules can use to
+// match requests.
+package expressions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// BotEnvironment returns the CEL environment that bot rule expressions are
+// evaluated in. It has these variables:
+//
+//   - remoteAddress: the client IP address
+//   - method: the HTTP method
+//   - host: the Host header
+//   - path: the request path
+//   - query: the query parameters, only the first value of each
+//   - headers: the request headers, multiple values are joined with ", "
+//   - userAgent: the User-Agent header
+//
+// Keys missing from query and headers evaluate to an empty string, like
+// http.Header.Get, so that headers["Accept"] doesn't fail for requests
+// without an Accept header. Use "Accept" in headers to check whether a key
+// is set, has(headers.Accept) is rejected as it would always be true.
+func BotEnvironment() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("remoteAddress", cel.StringType),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("host", cel.StringType),
+		cel.Variable("path", cel.StringType),
+		cel.Variable("query", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("headers", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("userAgent", cel.StringType),
+	)
+}
+
+// Compile parses and type-checks src, which must evaluate to a boolean.
+func Compile(src string) (cel.Program, error) {
+	env, err := BotEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	checked, iss := env.Compile(src)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+
+	if checked.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression must return a bool, got: %s", checked.OutputType())
+	}
+
+	if err := checkPresenceTests(checked.NativeRep().Expr()); err != nil {
+		return nil, err
+	}
+
+	return env.Program(checked)
+}
+
+// checkPresenceTests rejects has() on query and headers, whose missing keys
+// evaluate to an empty string, so has() would always be true.
+func checkPresenceTests(expr ast.Expr) error {
+	var err error
+	ast.PreOrderVisit(expr, ast.NewExprVisitor(func(e ast.Expr) {
+		if err != nil || e.Kind() != ast.SelectKind {
+			return
+		}
+
+		sel := e.AsSelect()
+		if !sel.IsTestOnly() || sel.Operand().Kind() != ast.IdentKind {
+			return
+		}
+
+		if name := sel.Operand().AsIdent(); name == "query" || name == "headers" {
+			err = fmt.Errorf("has(%s.%s) is always true, use %q in %s instead", name, sel.FieldName(), sel.FieldName(), name)
+		}
+	}))
+
+	return err
+}
+
+// Activation returns the values of the variables in BotEnvironment for r.
+func Activation(r *http.Request) map[string]any {
+	query := map[string]string{}
+	for key, vals := range r.URL.Query() {
+		if len(vals) != 0 {
+			query[key] = vals[0]
+		}
+	}
+
+	headers := map[string]string{}
+	for key, vals := range r.Header {
+		headers[key] = strings.Join(vals, ", ")
+	}
+
+	return map[string]any{
+		"remoteAddress": r.Header.Get("X-Real-Ip"),
+		"method":        r.Method,
+		"host":          r.Host,
+		"path":          r.URL.Path,
+		"query":         newDefaultMap(query),
+		"headers":       newDefaultMap(headers),
+		"userAgent":     r.UserAgent(),
+	}
+}
+
+// defaultMap is a map of strings whose missing keys evaluate to an empty
+// string instead of failing the expression with "no such key".
+type defaultMap struct {
+	traits.Mapper
+}
+
+func newDefaultMap(m map[string]string) defaultMap {
+	return defaultMap{types.NewStringStringMap(types.DefaultTypeAdapter, m)}
+}
+
+func (m defaultMap) Find(key ref.Val) (ref.Val, bool) {
+	val, found := m.Mapper.Find(key)
+	if found || types.IsError(val) {
+		return val, found
+	}
+	if _, ok := key.(types.String); !ok {
+		return val, found
+	}
+
+	return types.String(""), true
+}
+
+func (m defaultMap) Get(key ref.Val) ref.Val {
+	val, _ := m.Find(key)
+	return val
+}
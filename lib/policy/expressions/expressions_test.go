@@ -0,0 +1,52 @@
+package expressions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMissingKeys(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{name: "absent header", src: `headers["Accept"] == "application/json"`, want: false},
+		{name: "absent header is empty", src: `headers["Accept"] == ""`, want: true},
+		{name: "present header", src: `headers["X-Test"] == "yes"`, want: true},
+		{name: "absent query parameter", src: `query["page"] == ""`, want: true},
+		{name: "in absent", src: `"Accept" in headers`, want: false},
+		{name: "in present", src: `"X-Test" in headers`, want: true},
+		{name: "size", src: `size(headers) == 1`, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = http.Header{"X-Test": {"yes"}}
+
+			val, _, err := program.Eval(Activation(r))
+			if err != nil {
+				t.Fatalf("can't evaluate: %v", err)
+			}
+			if got, _ := val.Value().(bool); got != tt.want {
+				t.Errorf("wanted %v, got: %v", tt.want, val)
+			}
+		})
+	}
+}
+
+func TestCompilePresenceTest(t *testing.T) {
+	for _, src := range []string{
+		`has(headers.Accept)`,
+		`path == "/" && has(query.page)`,
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("wanted %s to be rejected", src)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/internal"
+)
+
+var (
+	CountryResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_country_results",
+		Help: "The results of policy evaluation by client country",
+	}, []string{"country", "action"})
+)
+
+// GeoIPDatabase looks up the country of IP addresses in a MaxMind DB file,
+// such as GeoLite2 Country or DB-IP IP to Country Lite.
+type GeoIPDatabase struct {
+	reader *maxminddb.Reader
+}
+
+// OpenGeoIPDatabase reads the database at fname into memory.
+func OpenGeoIPDatabase(fname string) (*GeoIPDatabase, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("can't read GeoIP database: %w", err)
+	}
+
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse GeoIP database %s: %w", fname, err)
+	}
+
+	return &GeoIPDatabase{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 country code of ip, or an empty
+// string if the database doesn't know it.
+func (g *GeoIPDatabase) Country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	if err := g.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+
+	return record.Country.ISOCode, nil
+}
+
+type CountryChecker struct {
+	db        *GeoIPDatabase
+	countries []string
+	hash      string
+}
+
+func NewCountryChecker(db *GeoIPDatabase, countries []string) (Checker, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: countries are set but there is no GeoIP database", ErrMisconfiguration)
+	}
+
+	var normalized []string
+	for _, cc := range countries {
+		normalized = append(normalized, strings.ToUpper(cc))
+	}
+	slices.Sort(normalized)
+
+	return &CountryChecker{
+		db:        db,
+		countries: normalized,
+		hash:      internal.SHA256sum("countries: " + strings.Join(normalized, ",")),
+	}, nil
+}
+
+func (cc *CountryChecker) Check(r *http.Request) (bool, error) {
+	host := r.Header.Get("X-Real-Ip")
+	if host == "" {
+		return false, fmt.Errorf("%w: header X-Real-Ip is not set", ErrMisconfiguration)
+	}
+
+	addr := net.ParseIP(host)
+	if addr == nil {
+		return false, fmt.Errorf("%w: %s is not an IP address", ErrMisconfiguration, host)
+	}
+
+	country, err := cc.db.Country(addr)
+	if err != nil {
+		return false, err
+	}
+
+	_, found := slices.BinarySearch(cc.countries, country)
+	return found, nil
+}
+
+func (cc *CountryChecker) Hash() string {
+	return cc.hash
+}
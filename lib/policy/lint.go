@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// Lint returns warnings about bot rules that are likely mistakes: rules that
+// share a name, and rules that can never match because an earlier rule
+// matches the same requests or every request.
+func (pc *ParsedConfig) Lint() []string {
+	if pc.orig == nil {
+		return nil
+	}
+
+	result := lintBots("", pc.orig.Bots, pc.Bots, len(pc.Bots))
+
+	for i, route := range pc.Routes {
+		if i >= len(pc.orig.Routes) {
+			break
+		}
+
+		orig := pc.orig.Routes[i]
+		name := orig.Host + orig.PathPrefix
+		origBots := append(append([]config.BotConfig{}, orig.Bots...), pc.orig.Bots...)
+		result = append(result, lintBots(name, origBots, route.Bots, len(orig.Bots))...)
+	}
+
+	return result
+}
+
+// lintBots checks bots, which were parsed from origBots. Only problems caused
+// by one of the first own rules are reported, so that problems with global
+// rules are reported once and not for every route.
+func lintBots(route string, origBots []config.BotConfig, bots []Bot, own int) []string {
+	var result []string
+
+	prefix := ""
+	if route != "" {
+		prefix = fmt.Sprintf("route %s: ", route)
+	}
+
+	if len(origBots) != len(bots) {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, b := range origBots[:own] {
+		if seen[b.Name] {
+			result = append(result, fmt.Sprintf("%smore than one rule is named %s", prefix, b.Name))
+		}
+		seen[b.Name] = true
+	}
+
+	for j := 1; j < len(bots); j++ {
+		for i := 0; i < j && i < own; i++ {
+			if !alwaysApplies(origBots[i]) {
+				continue
+			}
+
+			if matchesEverything(origBots[i]) {
+				result = append(result, fmt.Sprintf("%srule %s is never used because rule %s before it matches every request", prefix, bots[j].Name, bots[i].Name))
+				break
+			}
+
+			if bots[i].Rules.Hash() == bots[j].Rules.Hash() {
+				result = append(result, fmt.Sprintf("%srule %s is never used because rule %s before it matches the same requests", prefix, bots[j].Name, bots[i].Name))
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// alwaysApplies reports whether a rule acts on every request it matches, so
+// that no request it matches reaches the rules after it.
+func alwaysApplies(b config.BotConfig) bool {
+	return b.DecisionAPI == nil && (b.Probability == 0 || b.Probability == 1)
+}
+
+// matchesEverything reports whether a rule obviously matches every request,
+// such as a rule with the user agent regex ".*".
+func matchesEverything(b config.BotConfig) bool {
+	if len(b.VerifyReverseDNS) != 0 {
+		return false
+	}
+
+	for _, rexStr := range []*string{b.UserAgentRegex, b.PathRegex} {
+		if rexStr == nil {
+			continue
+		}
+
+		rex, err := regexp.Compile(*rexStr)
+		if err != nil {
+			continue
+		}
+
+		if rex.MatchString("") && rex.MatchString("anubis policy check") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,10 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/vale981/anubis/internal/attack"
+	"github.com/vale981/anubis/internal/ratelimit"
+	"github.com/vale981/anubis/lib/localization"
 	"github.com/vale981/anubis/lib/policy/config"
 )
 
@@ -21,9 +25,88 @@ var (
 type ParsedConfig struct {
 	orig *config.Config
 
+	// generation is the generation of the WebAssembly modules the policy
+	// uses, see Release.
+	generation uint64
+
+	// Version names the policy file, see config.Config.
+	Version string
+
 	Bots              []Bot
 	DNSBL             bool
 	DefaultDifficulty int
+	APIPathPrefixes   []string
+	APIClientHeader   string
+	CORS              *config.CORSConfig
+	GeoIP             *GeoIPDatabase
+	Routes            []Route
+	RateLimits        map[config.Rule]*ratelimit.Limiter
+
+	// Localization holds the translations of the pages Anubis renders. If
+	// it is nil, the embedded translations are used.
+	Localization *localization.Catalog
+
+	// Branding, if set, changes how the pages Anubis renders look.
+	Branding *config.Branding
+
+	// DryRun makes Anubis log and count what it would do with requests, but
+	// send every request to the upstream.
+	DryRun bool
+
+	// SecondaryScreening is how often requests with a valid cookie are
+	// fully re-verified.
+	SecondaryScreening config.ScreeningRate
+
+	// TokenBinding is how strictly cookies are tied to the IP address of
+	// the client they were issued to.
+	TokenBinding config.TokenBinding
+
+	// Reputation is set if any bot rule matches on client reputation, so
+	// that Anubis only keeps track of it when it is needed.
+	Reputation bool
+
+	// IPFeeds are the reputation feeds client IP addresses are looked up
+	// in, DroneBL first if DNSBL is set.
+	IPFeeds []config.IPFeed
+
+	// Tarpit sets how requests matched by TARPIT rules are held.
+	Tarpit config.Tarpit
+
+	// Decoy sets what the pages served to requests matched by DECOY rules
+	// look like.
+	Decoy config.Decoy
+
+	// SignedURLs are the paths that requests with a signed URL can reach
+	// without passing the policy.
+	SignedURLs []config.SignedURL
+
+	// CriticalPaths is the rule that allows the critical paths before any
+	// bot rule is checked, or nil if there are none.
+	CriticalPaths *Bot
+
+	// IssuanceLimit, if set, limits how many challenges each IP address
+	// gets.
+	IssuanceLimit *ratelimit.Limiter
+
+	// IssuanceSlots, if set, has room for as many challenges as may be
+	// issued at the same time.
+	IssuanceSlots chan struct{}
+
+	// AttackDetector, if set, moves the difficulty of challenges up or
+	// down depending on whether Anubis looks like it is under attack.
+	AttackDetector *attack.Detector
+
+	// ClientLimits limits how many connections and requests each client
+	// may have open at the same time.
+	ClientLimits config.ClientLimits
+
+	// RobotsTXT is the robots.txt generated from the policy, or nil if the
+	// policy doesn't ask for one.
+	RobotsTXT []byte
+
+	// Regexes matches the regexes of all bot rules that only match a set
+	// of strings at once, for requests checked with WithRegexSet.
+	Regexes *RegexSet
 }
 
 func NewParsedConfig(orig *config.Config) *ParsedConfig {
@@ -32,7 +115,7 @@ func NewParsedConfig(orig *config.Config) *ParsedConfig {
 	}
 }
 
-func ParseConfig(fin io.Reader, fname string, defaultDifficulty int) (*ParsedConfig, error) {
+func ParseConfig(fin io.Reader, fname string, defaultDifficulty int) (_ *ParsedConfig, err error) {
 	c, err := config.Load(fin, fname)
 	if err != nil {
 		return nil, err
@@ -41,23 +124,158 @@ func ParseConfig(fin io.Reader, fname string, defaultDifficulty int) (*ParsedCon
 	var validationErrs []error
 
 	result := NewParsedConfig(c)
+	result.Version = c.Version
 	result.DefaultDifficulty = defaultDifficulty
+	result.generation = wasmGenerations.Add(1)
+	defer func() {
+		if err != nil {
+			result.Release()
+		}
+	}()
+
+	if c.GeoIPDatabase != "" {
+		result.GeoIP, err = OpenGeoIPDatabase(c.GeoIPDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bots, errs := parseBots(c.Bots, defaultDifficulty, result.GeoIP, result.generation)
+	result.Bots = bots
+	validationErrs = append(validationErrs, errs...)
+
+	for _, r := range c.Routes {
+		route := Route{
+			Host:              r.Host,
+			PathPrefix:        r.PathPrefix,
+			Target:            r.Target,
+			DefaultDifficulty: defaultDifficulty,
+		}
+		if r.Difficulty != 0 {
+			route.DefaultDifficulty = r.Difficulty
+		}
+
+		route.Bots, errs = parseBots(r.Bots, route.DefaultDifficulty, result.GeoIP, result.generation)
+		validationErrs = append(validationErrs, errs...)
+
+		// The global rules are parsed again so that the ones without their
+		// own challenge settings use the route's difficulty. Their errors
+		// have already been reported above.
+		globalBots, _ := parseBots(c.Bots, route.DefaultDifficulty, result.GeoIP, result.generation)
+		route.Bots = append(route.Bots, globalBots...)
+
+		result.Routes = append(result.Routes, route)
+	}
+
+	result.Localization, err = localization.New(c.Translations)
+	if err != nil {
+		validationErrs = append(validationErrs, err)
+	}
+
+	if len(validationErrs) > 0 {
+		return nil, fmt.Errorf("errors validating policy config JSON %s: %w", fname, errors.Join(validationErrs...))
+	}
 
-	for _, b := range c.Bots {
+	result.DNSBL = c.DNSBL
+	result.IPFeeds = c.IPFeeds
+	result.Tarpit = c.Tarpit
+	result.Decoy = c.Decoy
+	result.SignedURLs = c.SignedURLs
+	result.RobotsTXT = generateRobotsTXT(c)
+
+	if rex := c.CriticalPaths.PathRegex(); rex != "" {
+		checker, err := NewPathChecker(rex)
+		if err != nil {
+			return nil, fmt.Errorf("can't compile critical paths: %w", err)
+		}
+		result.CriticalPaths = &Bot{Name: "critical-paths", Action: config.RuleAllow, Rules: checker}
+	}
+	result.APIPathPrefixes = c.APIPathPrefixes
+	result.APIClientHeader = c.APIClientHeader
+	result.CORS = c.CORS
+	result.Branding = c.Branding
+	result.DryRun = c.DryRun
+	result.SecondaryScreening = c.SecondaryScreening
+	result.TokenBinding = c.TokenBinding
+	result.ClientLimits = c.ClientLimits
+
+	result.Reputation = usesReputation(c.Bots)
+	for _, r := range c.Routes {
+		result.Reputation = result.Reputation || usesReputation(r.Bots)
+	}
+
+	result.RateLimits = map[config.Rule]*ratelimit.Limiter{}
+	for action, rl := range c.RateLimits {
+		result.RateLimits[action] = ratelimit.New(rl.Rate, rl.Burst)
+	}
+
+	if ci := c.ChallengeIssuance; ci.Rate > 0 {
+		result.IssuanceLimit = ratelimit.New(ci.Rate, ci.Burst)
+	}
+	if ci := c.ChallengeIssuance; ci.MaxConcurrent > 0 {
+		result.IssuanceSlots = make(chan struct{}, ci.MaxConcurrent)
+	}
+
+	if ad := c.AttackDetection; ad.Enabled() {
+		minDifficulty := ad.MinDifficulty
+		if minDifficulty == 0 {
+			minDifficulty = min(defaultDifficulty, ad.MaxDifficulty)
+		}
+
+		result.AttackDetector = attack.New(attack.Config{
+			Interval:      ad.IntervalDuration(),
+			Difficulty:    defaultDifficulty,
+			MinDifficulty: minDifficulty,
+			MaxDifficulty: ad.MaxDifficulty,
+			ChallengeRate: ad.ChallengeRate,
+			FailureRate:   ad.FailureRate,
+			NewClientRate: ad.NewClientRate,
+		})
+	}
+
+	result.Regexes = NewRegexSet(result.AllBots())
+
+	return result, nil
+}
+
+// parseBots turns bot rules from the policy file into Bots. Rules without
+// challenge settings use defaultDifficulty, and WebAssembly modules are
+// compiled for generation.
+func parseBots(bots []config.BotConfig, defaultDifficulty int, geoip *GeoIPDatabase, generation uint64) ([]Bot, []error) {
+	var (
+		result         []Bot
+		validationErrs []error
+	)
+
+	for _, b := range bots {
 		if berr := b.Valid(); berr != nil {
 			validationErrs = append(validationErrs, berr)
 			continue
 		}
 
 		parsedBot := Bot{
-			Name:   b.Name,
-			Action: b.Action,
+			Name:               b.Name,
+			Action:             b.Action,
+			DryRun:             b.DryRun,
+			SecondaryScreening: b.SecondaryScreening,
+			Redirect:           b.Redirect,
+			Response:           b.Response,
+			Target:             b.Target,
+		}
+
+		if b.Response != nil {
+			tmpl, err := b.Response.BodyTemplate()
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s response: %w", b.Name, err))
+			} else {
+				parsedBot.ResponseBody = tmpl
+			}
 		}
 
 		cl := CheckerList{}
 
-		if len(b.RemoteAddr) > 0 {
-			c, err := NewRemoteAddrChecker(b.RemoteAddr)
+		if cidrs := b.CIDRs(); len(cidrs) > 0 {
+			c, err := NewRemoteAddrChecker(cidrs)
 			if err != nil {
 				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s remote addr set: %w", b.Name, err))
 			} else {
@@ -92,6 +310,75 @@ func ParseConfig(fin io.Reader, fname string, defaultDifficulty int) (*ParsedCon
 			}
 		}
 
+		if len(b.HeadersMissing) > 0 {
+			cl = append(cl, NewHeadersMissingChecker(b.HeadersMissing))
+		}
+
+		if len(b.HeadersEqual) > 0 {
+			cl = append(cl, NewHeadersEqualChecker(b.HeadersEqual))
+		}
+
+		if len(b.Methods) > 0 {
+			cl = append(cl, NewMethodChecker(b.Methods))
+		}
+
+		if len(b.QueryRegex) > 0 {
+			c, err := NewQueryChecker(b.QueryRegex)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s query regex map: %w", b.Name, err))
+			} else {
+				cl = append(cl, c)
+			}
+		}
+
+		if len(b.Countries) > 0 {
+			c, err := NewCountryChecker(geoip, b.Countries)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s countries: %w", b.Name, err))
+			} else {
+				cl = append(cl, c)
+			}
+		}
+
+		if b.Expression != nil {
+			c, err := NewExpressionChecker(*b.Expression)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s expression: %w", b.Name, err))
+			} else {
+				cl = append(cl, c)
+			}
+		}
+
+		for _, cond := range []config.Condition{{All: b.All}, {Any: b.Any}, {Not: b.Not}} {
+			if cond.All == nil && cond.Any == nil && cond.Not == nil {
+				continue
+			}
+
+			c, err := NewConditionChecker(cond)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s conditions: %w", b.Name, err))
+			} else {
+				cl = append(cl, c)
+			}
+		}
+
+		if b.WASM != nil {
+			c, err := NewWASMChecker(generation, b.Name, b.WASM.Path, b.WASM.TimeoutDuration())
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s wasm module: %w", b.Name, err))
+			} else {
+				cl = append(cl, c)
+			}
+		}
+
+		if b.BrowserSanity != nil {
+			cl = append(cl, NewBrowserSanityChecker(b.BrowserSanity.ThresholdOrDefault()))
+		}
+
+		if b.Reputation != nil {
+			cl = append(cl, NewReputationChecker(*b.Reputation))
+		}
+
 		if b.Challenge == nil {
 			parsedBot.Challenge = &config.ChallengeRules{
 				Difficulty: defaultDifficulty,
@@ -106,15 +393,119 @@ func ParseConfig(fin io.Reader, fname string, defaultDifficulty int) (*ParsedCon
 		}
 
 		parsedBot.Rules = cl
+		if len(cl) == 0 && len(b.VerifyReverseDNS) == 0 && b.DecisionAPI != nil {
+			parsedBot.Rules = alwaysChecker{}
+		}
+
+		if b.DecisionAPI != nil {
+			d, err := NewDecisionAPI(b.Name, *b.DecisionAPI)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s decision API: %w", b.Name, err))
+			} else {
+				parsedBot.Decision = d
+			}
+		}
+
+		if len(b.VerifyReverseDNS) > 0 {
+			var claim Checker
+			if len(cl) > 0 {
+				claim = cl
+			}
 
-		result.Bots = append(result.Bots, parsedBot)
+			c, err := NewReverseDNSChecker(claim, b.VerifyReverseDNS)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s reverse DNS verification: %w", b.Name, err))
+			} else {
+				parsedBot.Rules = c
+			}
+		}
+
+		if b.Probability != 0 && b.Probability != 1 {
+			c, err := NewSampleChecker(parsedBot.Rules, b.Name, b.Probability)
+			if err != nil {
+				validationErrs = append(validationErrs, fmt.Errorf("while processing rule %s probability: %w", b.Name, err))
+			} else {
+				parsedBot.Rules = c
+			}
+		}
+
+		result = append(result, parsedBot)
 	}
 
-	if len(validationErrs) > 0 {
-		return nil, fmt.Errorf("errors validating policy config JSON %s: %w", fname, errors.Join(validationErrs...))
+	return result, validationErrs
+}
+
+// AllBots returns the global bot rules followed by the bot rules of every
+// route.
+func (pc *ParsedConfig) AllBots() []Bot {
+	bots := slices.Clone(pc.Bots)
+	for _, route := range pc.Routes {
+		bots = append(bots, route.Bots...)
 	}
 
-	result.DNSBL = c.DNSBL
+	return bots
+}
 
-	return result, nil
+// WithBots returns a copy of the policy that checks bots after its own bot
+// rules, globally and in every route. Rules without challenge settings use
+// the difficulty of the policy or the route.
+func (pc *ParsedConfig) WithBots(bots []config.BotConfig) (*ParsedConfig, error) {
+	extra, errs := parseBots(bots, pc.DefaultDifficulty, pc.GeoIP, pc.generation)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	result := *pc
+	result.Bots = append(slices.Clip(pc.Bots), extra...)
+	result.Routes = make([]Route, len(pc.Routes))
+	for i, route := range pc.Routes {
+		routeBots, _ := parseBots(bots, route.DefaultDifficulty, pc.GeoIP, pc.generation)
+		route.Bots = append(slices.Clip(route.Bots), routeBots...)
+		result.Routes[i] = route
+	}
+	result.Reputation = pc.Reputation || usesReputation(bots)
+	result.Regexes = NewRegexSet(result.AllBots())
+
+	return &result, nil
+}
+
+// Release closes the WebAssembly modules that no other policy uses. Call it
+// once the policy, and the copies WithBots made of it, won't check requests
+// anymore.
+func (pc *ParsedConfig) Release() {
+	if pc.generation != 0 {
+		releaseWASMModules(pc.generation)
+	}
+}
+
+// Cleanup removes expired entries from the caches kept by policy checkers
+// and rate limiters, and lets the attack detector catch up.
+func (pc *ParsedConfig) Cleanup() {
+	for _, b := range pc.AllBots() {
+		if c, ok := b.Rules.(interface{ Cleanup() }); ok {
+			c.Cleanup()
+		}
+	}
+
+	for _, l := range pc.RateLimits {
+		l.Cleanup()
+	}
+
+	if pc.IssuanceLimit != nil {
+		pc.IssuanceLimit.Cleanup()
+	}
+
+	if pc.AttackDetector != nil {
+		pc.AttackDetector.Update()
+	}
+}
+
+// Flush forgets everything cached by policy checkers, such as reverse DNS
+// verification results.
+func (pc *ParsedConfig) Flush() {
+	for _, b := range pc.AllBots() {
+		if c, ok := b.Rules.(interface{ Flush() }); ok {
+			c.Flush()
+		}
+	}
 }
@@ -1,12 +1,17 @@
 package policy
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/vale981/anubis"
 	"github.com/vale981/anubis/data"
+	"github.com/vale981/anubis/lib/policy/config"
 )
 
 func TestDefaultPolicyMustParse(t *testing.T) {
@@ -66,3 +71,182 @@ func TestBadConfigs(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutes(t *testing.T) {
+	fin, err := os.Open(filepath.Join("config", "testdata", "good", "routes.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+
+	pol, err := ParseConfig(fin, fin.Name(), anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		host           string
+		path           string
+		wantTarget     string
+		wantDifficulty int
+	}{
+		{host: "git.example.com", path: "/", wantTarget: "http://localhost:3000", wantDifficulty: 6},
+		{host: "GIT.example.com:8443", path: "/", wantTarget: "http://localhost:3000", wantDifficulty: 6},
+		{host: "git.example.com", path: "/git/repo.git", wantDifficulty: 8},
+		{host: "cdn.static.example.com", path: "/", wantTarget: "unix:///run/static.sock", wantDifficulty: anubis.DefaultDifficulty},
+		{host: "example.com", path: "/api/v1/users", wantTarget: "http://localhost:8080", wantDifficulty: anubis.DefaultDifficulty},
+		{host: "static.example.com", path: "/"},
+		{host: "example.com", path: "/git/repo.git"},
+	} {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Host = tt.host
+
+			route := pol.Route(r)
+			if tt.wantDifficulty == 0 {
+				if route != nil {
+					t.Fatalf("wanted no route, got one for %s", route.Host)
+				}
+				return
+			}
+
+			if route == nil {
+				t.Fatal("wanted a route, got none")
+			}
+
+			if route.Target != tt.wantTarget {
+				t.Errorf("wanted target %s, got: %s", tt.wantTarget, route.Target)
+			}
+
+			if route.DefaultDifficulty != tt.wantDifficulty {
+				t.Errorf("wanted difficulty %d, got: %d", tt.wantDifficulty, route.DefaultDifficulty)
+			}
+		})
+	}
+}
+
+func TestWithBots(t *testing.T) {
+	fin, err := os.Open(filepath.Join("config", "testdata", "good", "routes.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+
+	pol, err := ParseConfig(fin, fin.Name(), anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownBots := len(pol.AllBots())
+
+	ua := "ExampleScraper"
+	merged, err := pol.WithBots([]config.BotConfig{{Name: "example-scraper", UserAgentRegex: &ua, Action: config.RuleChallenge}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pol.AllBots()) != ownBots {
+		t.Errorf("wanted the policy to keep its %d rules, got: %d", ownBots, len(pol.AllBots()))
+	}
+
+	last := merged.Bots[len(merged.Bots)-1]
+	if last.Name != "example-scraper" || last.Challenge.Difficulty != anubis.DefaultDifficulty {
+		t.Errorf("wanted the rule last with difficulty %d, got: %s with %d", anubis.DefaultDifficulty, last.Name, last.Challenge.Difficulty)
+	}
+
+	for _, route := range merged.Routes {
+		last := route.Bots[len(route.Bots)-1]
+		if last.Name != "example-scraper" || last.Challenge.Difficulty != route.DefaultDifficulty {
+			t.Errorf("%s%s: wanted the rule last with difficulty %d, got: %s with %d", route.Host, route.PathPrefix, route.DefaultDifficulty, last.Name, last.Challenge.Difficulty)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	pol, err := ParseConfig(strings.NewReader(`
+bots:
+  - name: curl
+    user_agent_regex: ^curl/
+    action: DENY
+  - name: curl
+    path_regex: ^/admin/
+    action: DENY
+  - name: curl-again
+    user_agent_regex: ^curl/
+    action: CHALLENGE
+  - name: some-curl
+    user_agent_regex: ^curl/
+    action: DENY
+    probability: 0.5
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+  - name: never
+    path_regex: ^/
+    action: ALLOW
+`), "lint.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"more than one rule is named curl",
+		"rule curl-again is never used because rule curl before it matches the same requests",
+		"rule never is never used because rule everyone before it matches every request",
+	}
+
+	if got := pol.Lint(); !slices.Equal(got, want) {
+		t.Errorf("got warnings:\n%s\nwanted:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRobotsTXT(t *testing.T) {
+	pol, err := ParseConfig(strings.NewReader(`
+robots_txt:
+  generate: true
+  crawl_delay: 10
+  sitemap: https://example.com/sitemap.xml
+
+bots:
+  - name: friends
+    user_agent_regex: FriendBot
+    action: ALLOW
+  - name: ai
+    user_agent_regex: >
+      GPTBot|CCBot|FriendBot|Brightbot 1\.0|Mozilla.*Scraper
+    action: DENY
+  - name: traps
+    user_agent_regex: ^Scrapy$
+    action: DECOY
+  - name: only-some-requests
+    user_agent_regex: PartialBot
+    methods: [POST]
+    action: DENY
+  - name: slow-down
+    user_agent_regex: SEOBot|GPTBot
+    action: CHALLENGE
+`), "robots.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Generated by Anubis from its policy.
+
+User-agent: GPTBot
+User-agent: CCBot
+User-agent: Brightbot 1.0
+User-agent: Scrapy
+Disallow: /
+
+User-agent: SEOBot
+Crawl-delay: 10
+Disallow:
+
+User-agent: *
+Disallow:
+
+Sitemap: https://example.com/sitemap.xml
+`
+	if got := string(pol.RobotsTXT); got != want {
+		t.Errorf("wanted robots.txt:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,608 @@
+package policy
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSetLiterals is the most strings a regex may expand to for it to be
+// part of a RegexSet. Regexes that need more are matched on their own.
+const maxSetLiterals = 256
+
+// RegexSet matches the user agent, path, and header regexes of a policy in
+// one pass over each part of the request, instead of one regex after
+// another. Regexes that only match a set of strings, such as lists of
+// crawler names, are matched by it entirely. For the others, it finds
+// strings that every match contains one of, such as "well-known/" for
+// ^/\.well-known/, and rules the regex out if the request contains none of
+// them. Otherwise the regex is matched on its own.
+//
+// Checkers only use it for requests whose context was made with
+// WithRegexSet, and match their regex on their own otherwise.
+type RegexSet struct {
+	// members holds the index of each regex in the set. Regexes that are
+	// the same and match the same part of the request share an index.
+	members map[*regexp.Regexp]int
+	// memberSource is the index in sources of the part of the request each
+	// member matches.
+	memberSource []int
+	sources      []setSource
+}
+
+// setSource is a part of the request that regexes of a RegexSet match.
+type setSource struct {
+	// header is the canonical name of the header, or "" for the URL path.
+	header string
+	// cased matches the case-sensitive strings, and folded the ones that
+	// match regardless of ASCII case. Either may be nil.
+	cased, folded *literalMatcher
+}
+
+func (src setSource) input(r *http.Request) string {
+	if src.header == "" {
+		return r.URL.Path
+	}
+
+	// The name is canonical already, so skip Header.Get canonicalizing it
+	// every time.
+	if values := r.Header[src.header]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// NewRegexSet builds a RegexSet for the regexes of bots. It returns nil if
+// none of them can be part of one.
+func NewRegexSet(bots []Bot) *RegexSet {
+	type memberKey struct {
+		header string
+		regex  string
+	}
+
+	var (
+		set       = &RegexSet{members: map[*regexp.Regexp]int{}}
+		keys      = map[memberKey]int{}
+		sourceIdx = map[string]int{}
+		// sourceRefs maps the case-sensitive and case-insensitive strings
+		// of each source to the members they belong to, as literalRefs.
+		sourceRefs [][2]map[string][]int
+	)
+
+	add := func(header string, rex *regexp.Regexp) {
+		if _, ok := set.members[rex]; ok {
+			return
+		}
+
+		key := memberKey{header, rex.String()}
+		if idx, ok := keys[key]; ok {
+			set.members[rex] = idx
+			return
+		}
+
+		lits, ok := expandLiterals(rex.String())
+		if !ok {
+			return
+		}
+
+		src, ok := sourceIdx[header]
+		if !ok {
+			src = len(set.sources)
+			sourceIdx[header] = src
+			set.sources = append(set.sources, setSource{header: header})
+			sourceRefs = append(sourceRefs, [2]map[string][]int{{}, {}})
+		}
+
+		idx := len(set.memberSource)
+		keys[key] = idx
+		set.members[rex] = idx
+		set.memberSource = append(set.memberSource, src)
+
+		for _, lit := range lits {
+			kind := 0
+			if lit.fold {
+				kind = 1
+			}
+			sourceRefs[src][kind][lit.s] = append(sourceRefs[src][kind][lit.s], literalRef(idx, lit.partial))
+		}
+	}
+
+	var walk func(c Checker)
+	walk = func(c Checker) {
+		switch c := c.(type) {
+		case *HeaderMatchesChecker:
+			add(http.CanonicalHeaderKey(c.header), c.regexp)
+		case *PathChecker:
+			add("", c.regexp)
+		case CheckerList:
+			for _, c := range c {
+				walk(c)
+			}
+		case AllChecker:
+			for _, c := range c {
+				walk(c)
+			}
+		case NotChecker:
+			walk(c.Checker)
+		case *ReverseDNSChecker:
+			if c.claim != nil {
+				walk(c.claim)
+			}
+		case *SampleChecker:
+			walk(c.checker)
+		}
+	}
+
+	for _, b := range bots {
+		if b.Rules != nil {
+			walk(b.Rules)
+		}
+	}
+
+	if len(set.memberSource) == 0 {
+		return nil
+	}
+
+	for i := range set.sources {
+		if refs := sourceRefs[i][0]; len(refs) > 0 {
+			set.sources[i].cased = newLiteralMatcher(refs, false)
+		}
+		if refs := sourceRefs[i][1]; len(refs) > 0 {
+			set.sources[i].folded = newLiteralMatcher(refs, true)
+		}
+	}
+
+	return set
+}
+
+// Len returns the number of distinct regexes in the set.
+func (rs *RegexSet) Len() int {
+	if rs == nil {
+		return 0
+	}
+
+	return len(rs.memberSource)
+}
+
+type regexSetKey struct{}
+
+// regexMatches remembers which members of a RegexSet matched a request.
+type regexMatches struct {
+	set *RegexSet
+	// inputs holds what each source was scanned for, so that it is scanned
+	// again if the request changed.
+	inputs  []string
+	scanned []bool
+	// reliable is false for sources the set can't match exactly, because
+	// their case-insensitive strings met non-ASCII input.
+	reliable []bool
+	// matched has the bits of the members that matched, and maybe those
+	// that have to be matched on their own to be sure.
+	matched, maybe []uint64
+}
+
+// WithRegexSet returns a context that makes checkers look their regexes up
+// in set, which matches each part of a request only once. Use it with one
+// request only.
+func WithRegexSet(ctx context.Context, set *RegexSet) context.Context {
+	if set == nil {
+		return ctx
+	}
+
+	words := (len(set.memberSource) + 63) / 64
+	return context.WithValue(ctx, regexSetKey{}, &regexMatches{
+		set:      set,
+		inputs:   make([]string, len(set.sources)),
+		scanned:  make([]bool, len(set.sources)),
+		reliable: make([]bool, len(set.sources)),
+		matched:  make([]uint64, words),
+		maybe:    make([]uint64, words),
+	})
+}
+
+// matchRegexSet reports whether rex matches r, looked up in the RegexSet of
+// r's context. found is false if rex has to be matched on its own.
+func matchRegexSet(r *http.Request, rex *regexp.Regexp) (matched, found bool) {
+	m, ok := r.Context().Value(regexSetKey{}).(*regexMatches)
+	if !ok {
+		return false, false
+	}
+
+	idx, ok := m.set.members[rex]
+	if !ok {
+		return false, false
+	}
+
+	srcIdx := m.set.memberSource[idx]
+	input := m.set.sources[srcIdx].input(r)
+	if !m.scanned[srcIdx] || m.inputs[srcIdx] != input {
+		m.scan(srcIdx, input)
+	}
+
+	switch bit := uint64(1) << (idx % 64); {
+	case !m.reliable[srcIdx]:
+		return false, false
+	case m.matched[idx/64]&bit != 0:
+		return true, true
+	case m.maybe[idx/64]&bit != 0:
+		return false, false
+	default:
+		return false, true
+	}
+}
+
+func (m *regexMatches) scan(srcIdx int, input string) {
+	for idx, src := range m.set.memberSource {
+		if src == srcIdx {
+			m.matched[idx/64] &^= 1 << (idx % 64)
+			m.maybe[idx/64] &^= 1 << (idx % 64)
+		}
+	}
+
+	src := m.set.sources[srcIdx]
+	reliable := true
+	if src.cased != nil {
+		src.cased.scan(input, m.matched, m.maybe)
+	}
+	if src.folded != nil {
+		reliable = src.folded.scan(input, m.matched, m.maybe)
+	}
+
+	m.inputs[srcIdx] = input
+	m.scanned[srcIdx] = true
+	m.reliable[srcIdx] = reliable
+}
+
+// literal is a string that matches of a regex contain. fold makes it match
+// regardless of ASCII case, and s is lower case then. If partial is set, a
+// string that contains it may still not match the regex.
+type literal struct {
+	s       string
+	fold    bool
+	partial bool
+}
+
+// literalRef refers to the member idx of a RegexSet from one of its
+// strings, with the lowest bit set if the string is partial.
+func literalRef(idx int, partial bool) int {
+	if partial {
+		return idx<<1 | 1
+	}
+
+	return idx << 1
+}
+
+// expandLiterals returns strings that every match of the regex rexStr
+// contains one of, if there are at most maxSetLiterals of them. The regex
+// matches every string that contains one of those that aren't partial.
+func expandLiterals(rexStr string) ([]literal, bool) {
+	re, err := syntax.Parse(rexStr, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+
+	lits, ok := expand(re)
+	if slices.ContainsFunc(lits, func(lit literal) bool { return lit.partial && lit.s == "" }) {
+		// Every string contains the empty string, so it rules nothing out.
+		return nil, false
+	}
+
+	return lits, ok
+}
+
+func expand(re *syntax.Regexp) ([]literal, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []literal{{}}, true
+
+	case syntax.OpLiteral:
+		fold := re.Flags&syntax.FoldCase != 0
+		for _, r := range re.Rune {
+			// Go folds some ASCII letters to runes outside of ASCII, such
+			// as k to the Kelvin sign, which a byte matcher can't do. Those
+			// runes only ever match input that the matcher gives up on.
+			if r == utf8.RuneError || (fold && r >= utf8.RuneSelf) {
+				return nil, false
+			}
+		}
+		if fold {
+			return []literal{{s: strings.ToLower(string(re.Rune)), fold: true}}, true
+		}
+		return []literal{{s: string(re.Rune)}}, true
+
+	case syntax.OpCharClass:
+		var lits []literal
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if int(hi-lo)+len(lits) >= maxSetLiterals {
+				return nil, false
+			}
+			for r := lo; r <= hi; r++ {
+				if r == utf8.RuneError {
+					return nil, false
+				}
+				lits = append(lits, literal{s: string(r)})
+			}
+		}
+		return lits, len(lits) > 0
+
+	case syntax.OpCapture:
+		return expand(re.Sub[0])
+
+	case syntax.OpPlus:
+		// Every match contains at least one match of the repeated regex.
+		lits, ok := expand(re.Sub[0])
+		return partial(lits), ok
+
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return nil, false
+		}
+		lits, ok := expand(re.Sub[0])
+		return partial(lits), ok
+
+	case syntax.OpAlternate:
+		var lits []literal
+		for _, sub := range re.Sub {
+			subLits, ok := expand(sub)
+			if !ok || len(lits)+len(subLits) > maxSetLiterals {
+				return nil, false
+			}
+			lits = append(lits, subLits...)
+		}
+		return lits, true
+
+	case syntax.OpConcat:
+		return expandConcat(re.Sub)
+	}
+
+	return nil, false
+}
+
+// expandConcat expands regexes matched one after the other. Runs of them
+// that match sets of strings are joined into longer strings. If they can't
+// all be joined, every match contains one of the strings of each run, and
+// the run whose strings are the longest is picked.
+func expandConcat(subs []*syntax.Regexp) ([]literal, bool) {
+	var (
+		best  []literal
+		run   = []literal{{}}
+		whole = true
+	)
+
+	pick := func(candidate []literal) {
+		if len(candidate) > 0 && shortest(candidate) > shortest(best) {
+			best = candidate
+		}
+	}
+
+	for _, sub := range subs {
+		subLits, ok := expand(sub)
+		if !ok {
+			pick(run)
+			run, whole = []literal{{}}, false
+			continue
+		}
+		if slices.ContainsFunc(subLits, func(lit literal) bool { return lit.partial }) {
+			pick(run)
+			pick(subLits)
+			run, whole = []literal{{}}, false
+			continue
+		}
+
+		joined, ok := joinAll(run, subLits)
+		if !ok {
+			pick(run)
+			run, whole = subLits, false
+			continue
+		}
+		run = joined
+	}
+
+	if whole {
+		return run, true
+	}
+
+	pick(run)
+	return partial(best), best != nil
+}
+
+// partial returns lits marked as partial.
+func partial(lits []literal) []literal {
+	result := make([]literal, len(lits))
+	for i, lit := range lits {
+		lit.partial = true
+		result[i] = lit
+	}
+
+	return result
+}
+
+// shortest returns the length of the shortest of lits, or -1 if there are
+// none.
+func shortest(lits []literal) int {
+	if len(lits) == 0 {
+		return -1
+	}
+
+	n := len(lits[0].s)
+	for _, lit := range lits[1:] {
+		n = min(n, len(lit.s))
+	}
+
+	return n
+}
+
+// joinAll returns every string of prefixes followed by every string of
+// suffixes, or false if there would be too many or they can't be joined.
+func joinAll(prefixes, suffixes []literal) ([]literal, bool) {
+	if len(prefixes)*len(suffixes) > maxSetLiterals {
+		return nil, false
+	}
+
+	result := make([]literal, 0, len(prefixes)*len(suffixes))
+	for _, prefix := range prefixes {
+		for _, suffix := range suffixes {
+			joined, ok := joinLiterals(prefix, suffix)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, joined)
+		}
+	}
+
+	return result, true
+}
+
+// joinLiterals concatenates a and b. A case-sensitive part can only join a
+// case-insensitive one if it has no letters, so that case doesn't matter.
+func joinLiterals(a, b literal) (literal, bool) {
+	if a.fold == b.fold {
+		return literal{s: a.s + b.s, fold: a.fold}, true
+	}
+
+	cased := a
+	if a.fold {
+		cased = b
+	}
+	if strings.ToLower(cased.s) != cased.s || strings.ToUpper(cased.s) != cased.s {
+		return literal{}, false
+	}
+
+	return literal{s: a.s + b.s, fold: true}, true
+}
+
+// literalMatcher finds which of a set of strings a string contains in one
+// pass, with an Aho-Corasick automaton over the bytes of the strings.
+type literalMatcher struct {
+	fold bool
+	// class maps bytes to their column in next. Bytes that are in none of
+	// the strings share column 0.
+	class   [256]uint16
+	classes int
+	// next is the state after each state and byte class.
+	next []int32
+	// out holds the literalRefs of the strings found when a state is
+	// reached.
+	out [][]int
+	// always holds the literalRefs of the empty strings.
+	always []int
+}
+
+// newLiteralMatcher builds a literalMatcher for refs, which maps each string
+// to the literalRefs it is found for. If fold is set, the strings are lower
+// case and match regardless of ASCII case.
+func newLiteralMatcher(refs map[string][]int, fold bool) *literalMatcher {
+	lm := &literalMatcher{fold: fold, classes: 1}
+
+	for s := range refs {
+		for i := 0; i < len(s); i++ {
+			if lm.class[s[i]] == 0 {
+				lm.class[s[i]] = uint16(lm.classes)
+				lm.classes++
+			}
+		}
+	}
+	if fold {
+		for c := 'A'; c <= 'Z'; c++ {
+			lm.class[c] = lm.class[c+'a'-'A']
+		}
+	}
+
+	// Build the trie, with -1 for missing edges.
+	newState := func() int32 {
+		for range lm.classes {
+			lm.next = append(lm.next, -1)
+		}
+		lm.out = append(lm.out, nil)
+		return int32(len(lm.out) - 1)
+	}
+	newState()
+
+	for s, found := range refs {
+		if s == "" {
+			lm.always = append(lm.always, found...)
+			continue
+		}
+
+		state := int32(0)
+		for i := 0; i < len(s); i++ {
+			edge := int(state)*lm.classes + int(lm.class[s[i]])
+			if lm.next[edge] < 0 {
+				child := newState()
+				lm.next[edge] = child
+			}
+			state = lm.next[edge]
+		}
+		lm.out[state] = append(lm.out[state], found...)
+	}
+
+	// Turn the trie into a DFA breadth first, following the failure link
+	// of each state for its missing edges and adding its matches.
+	fail := make([]int32, len(lm.out))
+	queue := []int32{}
+	for c := range lm.classes {
+		if child := lm.next[c]; child < 0 {
+			lm.next[c] = 0
+		} else {
+			queue = append(queue, child)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		lm.out[state] = append(lm.out[state], lm.out[fail[state]]...)
+
+		for c := range lm.classes {
+			edge := int(state)*lm.classes + c
+			child := lm.next[edge]
+			failNext := lm.next[int(fail[state])*lm.classes+c]
+			if child < 0 {
+				lm.next[edge] = failNext
+				continue
+			}
+			fail[child] = failNext
+			queue = append(queue, child)
+		}
+	}
+
+	return lm
+}
+
+// scan sets the bits of the members whose strings input contains in matched,
+// or in maybe for partial strings. It returns false if a case-insensitive
+// matcher met non-ASCII input, which it can't match exactly.
+func (lm *literalMatcher) scan(input string, matched, maybe []uint64) bool {
+	found := func(ref int) {
+		idx, bits := ref>>1, matched
+		if ref&1 != 0 {
+			bits = maybe
+		}
+		bits[idx/64] |= 1 << (idx % 64)
+	}
+
+	for _, ref := range lm.always {
+		found(ref)
+	}
+
+	state := int32(0)
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		if lm.fold && c >= utf8.RuneSelf {
+			return false
+		}
+
+		state = lm.next[int(state)*lm.classes+int(lm.class[c])]
+		for _, ref := range lm.out[state] {
+			found(ref)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/data"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestExpandLiterals(t *testing.T) {
+	for _, tt := range []struct {
+		regex string
+		lits  int
+		exact bool
+		ok    bool
+	}{
+		{regex: "GPTBot", lits: 1, exact: true, ok: true},
+		{regex: "Mozilla|Opera\n", lits: 2, exact: true, ok: true},
+		{regex: `\+http\://www\.google\.com/bot\.html`, lits: 1, exact: true, ok: true},
+		{regex: "Applebot|Applebot-Extended", lits: 2, exact: true, ok: true},
+		{regex: "(?i:bot|crawler)", lits: 2, exact: true, ok: true},
+		{regex: "[Ss]craper/[0-9]", lits: 20, exact: true, ok: true},
+		{regex: "Brightbot 1.0|GPTBot", lits: 2, ok: true},
+		{regex: `^/\.well-known/.*$`, lits: 1, ok: true},
+		{regex: "(ab)+", lits: 1, ok: true},
+		{regex: "a|", lits: 2, exact: true, ok: true},
+		{regex: ".*"},
+		{regex: "^"},
+		{regex: "a?"},
+		{regex: "(?i)kelvin", lits: 1, exact: true, ok: true},
+		{regex: "[^/]+"},
+	} {
+		t.Run(tt.regex, func(t *testing.T) {
+			lits, ok := expandLiterals(tt.regex)
+			exact := ok && !slices.ContainsFunc(lits, func(lit literal) bool { return lit.partial })
+			if ok != tt.ok || exact != tt.exact || len(lits) != tt.lits {
+				t.Errorf("wanted %d strings, exact: %v, ok: %v, got: %v, exact: %v, ok: %v", tt.lits, tt.exact, tt.ok, lits, exact, ok)
+			}
+		})
+	}
+}
+
+func TestRegexSetMatchesLikeRegexes(t *testing.T) {
+	fin, err := data.BotPolicies.Open("botPolicies.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+
+	pol, err := ParseConfig(fin, "botPolicies.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra, err := config.LoadBotData(strings.NewReader(`
+bots:
+  - name: case-insensitive
+    user_agent_regex: (?i:bot|crawler|kelvin)
+    action: DENY
+  - name: versions
+    user_agent_regex: "[Ss]craper/[0-9]"
+    action: DENY
+  - name: mixed
+    user_agent_regex: (?i:fetch)-[0-9]|Spider\.
+    action: DENY
+  - name: headers
+    headers_regex:
+      Accept: text/(html|plain)
+    action: CHALLENGE
+  - name: nested
+    all:
+      - path_regex: ^/api/
+      - not:
+          user_agent_regex: Mozilla
+    action: DENY
+`), "extra.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pol, err = pol.WithBots(extra.Bots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pol.Regexes.Len() < 10 {
+		t.Fatalf("wanted most regexes in the set, got: %d", pol.Regexes.Len())
+	}
+
+	userAgents := []string{
+		"",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+		"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"Brightbot 1.0",
+		"Brightbot 1x0",
+		"curl/8.5.0",
+		"SCRAPER/1",
+		"Scraper/1",
+		"MyCrawler",
+		"Kelvin",
+		"\u212aelvin",
+		"Kelvin",
+		"FETCH-1",
+		"spider.",
+		"Spider.",
+		"Opera/9.80",
+	}
+	paths := []string{"/", "/static/app.css", "/.well-known/security.txt", "/favicon.ico", "/robots.txt", "/api/users", "/docs/well-known/"}
+
+	for _, userAgent := range userAgents {
+		for _, path := range paths {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.Header.Set("User-Agent", userAgent)
+			r.Header.Set("Accept", "text/html")
+			r.Header.Set("X-Real-Ip", "198.51.100.1")
+			withSet := r.WithContext(WithRegexSet(r.Context(), pol.Regexes))
+
+			for _, b := range pol.Bots {
+				want, err := b.Rules.Check(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := b.Rules.Check(withSet)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got != want {
+					t.Errorf("%s for %q %s: wanted match: %v, got: %v", b.Name, userAgent, path, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestRegexSetChangedRequest(t *testing.T) {
+	c, err := NewUserAgentChecker("GPTBot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := NewRegexSet([]Bot{{Name: "gptbot", Rules: c}})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(WithRegexSet(r.Context(), set))
+
+	r.Header.Set("User-Agent", "GPTBot/1.2")
+	if ok, _ := c.Check(r); !ok {
+		t.Error("wanted GPTBot to match")
+	}
+
+	r.Header.Set("User-Agent", "curl/8.5.0")
+	if ok, _ := c.Check(r); ok {
+		t.Error("wanted the changed user agent to be matched again")
+	}
+}
+
+func BenchmarkDefaultPolicy(b *testing.B) {
+	fin, err := data.BotPolicies.Open("botPolicies.yaml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fin.Close()
+
+	pol, err := ParseConfig(fin, "botPolicies.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, req := range []struct {
+		name      string
+		userAgent string
+		path      string
+	}{
+		{name: "static asset", userAgent: "curl/8.5.0", path: "/static/app.css"},
+		{name: "browser", userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", path: "/blog/post"},
+		{name: "crawler", userAgent: "Mozilla/5.0 (compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)", path: "/"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, req.path, nil)
+		r.Header.Set("User-Agent", req.userAgent)
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+
+		for _, mode := range []struct {
+			name string
+			set  *RegexSet
+		}{
+			{name: "one by one"},
+			{name: "regex set", set: pol.Regexes},
+		} {
+			b.Run(req.name+"/"+mode.name, func(b *testing.B) {
+				for b.Loop() {
+					r := r.WithContext(WithRegexSet(r.Context(), mode.set))
+					for _, bot := range pol.Bots {
+						if ok, _ := bot.Rules.Check(r); ok {
+							break
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+type reputationKey struct{}
+
+// WithReputation returns a context carrying the reputation score of the
+// client making the request, for ReputationChecker.
+func WithReputation(ctx context.Context, score float64) context.Context {
+	return context.WithValue(ctx, reputationKey{}, score)
+}
+
+// ReputationFromContext returns the reputation score stored in ctx by
+// WithReputation. Clients without a known score have a reputation of zero.
+func ReputationFromContext(ctx context.Context) float64 {
+	score, _ := ctx.Value(reputationKey{}).(float64)
+	return score
+}
+
+// ReputationChecker matches clients whose reputation score is within a
+// range.
+type ReputationChecker struct {
+	above, below *float64
+	hash         string
+}
+
+func NewReputationChecker(conf config.Reputation) Checker {
+	hash := "reputation:"
+	if conf.Above != nil {
+		hash += fmt.Sprintf(" above %v", *conf.Above)
+	}
+	if conf.Below != nil {
+		hash += fmt.Sprintf(" below %v", *conf.Below)
+	}
+
+	return &ReputationChecker{
+		above: conf.Above,
+		below: conf.Below,
+		hash:  internal.SHA256sum(hash),
+	}
+}
+
+func (rc *ReputationChecker) Check(r *http.Request) (bool, error) {
+	score := ReputationFromContext(r.Context())
+
+	if rc.above != nil && score <= *rc.above {
+		return false, nil
+	}
+
+	if rc.below != nil && score >= *rc.below {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (rc *ReputationChecker) Hash() string {
+	return rc.hash
+}
+
+// usesReputation reports whether any of bots matches on client reputation.
+func usesReputation(bots []config.BotConfig) bool {
+	for _, b := range bots {
+		if b.Reputation != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vale981/anubis/decaymap"
+	"github.com/vale981/anubis/internal"
+)
+
+const (
+	reverseDNSTimeout  = 5 * time.Second
+	reverseDNSCacheTTL = time.Hour
+)
+
+// Resolver is the subset of *net.Resolver that ReverseDNSChecker uses.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ReverseDNSChecker only matches requests from clients whose IP address
+// resolves to a host name in one of the expected domains, and whose host name
+// resolves back to the same IP address. This is how search engines such as
+// Google and Bing tell people to verify their crawlers.
+//
+// If claim is set, the client is only verified when claim matches, so that
+// DNS lookups are only done for requests that claim to be the crawler.
+type ReverseDNSChecker struct {
+	claim    Checker
+	domains  []string
+	resolver Resolver
+	cache    *decaymap.Impl[string, bool]
+	hash     string
+}
+
+func NewReverseDNSChecker(claim Checker, domains []string) (Checker, error) {
+	var normalized []string
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if domain == "" {
+			return nil, fmt.Errorf("%w: reverse DNS domains must not be empty", ErrMisconfiguration)
+		}
+		normalized = append(normalized, domain)
+	}
+	slices.Sort(normalized)
+
+	var sb strings.Builder
+	if claim != nil {
+		fmt.Fprintln(&sb, claim.Hash())
+	}
+	fmt.Fprintf(&sb, "verify_reverse_dns: %s", strings.Join(normalized, ","))
+
+	return &ReverseDNSChecker{
+		claim:    claim,
+		domains:  normalized,
+		resolver: net.DefaultResolver,
+		cache:    decaymap.New[string, bool](),
+		hash:     internal.SHA256sum(sb.String()),
+	}, nil
+}
+
+func (rdc *ReverseDNSChecker) Check(r *http.Request) (bool, error) {
+	if rdc.claim != nil {
+		ok, err := rdc.claim.Check(r)
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+
+	host := r.Header.Get("X-Real-Ip")
+	if host == "" {
+		return false, fmt.Errorf("%w: header X-Real-Ip is not set", ErrMisconfiguration)
+	}
+
+	addr := net.ParseIP(host)
+	if addr == nil {
+		return false, fmt.Errorf("%w: %s is not an IP address", ErrMisconfiguration, host)
+	}
+
+	ip := addr.String()
+	if verified, ok := rdc.cache.Get(ip); ok {
+		return verified, nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), reverseDNSTimeout)
+	defer cancel()
+
+	verified, err := rdc.verify(ctx, addr)
+	if err != nil {
+		// Temporary failures are not cached so that the next request from
+		// this client gets another chance to be verified.
+		slog.Debug("can't verify client with reverse DNS", "ip", ip, "err", err)
+		return false, nil
+	}
+
+	rdc.cache.Set(ip, verified, reverseDNSCacheTTL)
+	return verified, nil
+}
+
+func (rdc *ReverseDNSChecker) verify(ctx context.Context, addr net.IP) (bool, error) {
+	names, err := rdc.resolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !rdc.inDomain(name) {
+			continue
+		}
+
+		ips, err := rdc.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+
+		for _, ip := range ips {
+			if ip.IP.Equal(addr) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (rdc *ReverseDNSChecker) inDomain(name string) bool {
+	for _, domain := range rdc.domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Cleanup removes expired verification results.
+func (rdc *ReverseDNSChecker) Cleanup() {
+	rdc.cache.Cleanup()
+}
+
+// Flush forgets all verification results.
+func (rdc *ReverseDNSChecker) Flush() {
+	rdc.cache.Clear()
+}
+
+func (rdc *ReverseDNSChecker) Hash() string {
+	return rdc.hash
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
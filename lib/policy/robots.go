@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// userAgentToken matches the parts of a user agent regex that name a user
+// agent, as in "GPTBot|CCBot". Dots are taken literally, as most people who
+// write "Brightbot 1.0" mean it.
+var userAgentToken = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._/-]*$`)
+
+// generateRobotsTXT returns the robots.txt for c, or nil if c doesn't ask
+// for one.
+func generateRobotsTXT(c *config.Config) []byte {
+	settings := c.RobotsTXT
+	if !settings.Generate {
+		return nil
+	}
+
+	disallow := "Disallow:"
+	if settings.DisallowAll {
+		disallow = "Disallow: /"
+	}
+
+	var denied, challenged []string
+	seen := map[string]bool{}
+	for _, b := range c.Bots {
+		if !onlyMatchesUserAgent(b) {
+			continue
+		}
+
+		var list *[]string
+		switch b.Action {
+		case config.RuleDeny, config.RuleTarpit, config.RuleDecoy:
+			list = &denied
+		case config.RuleChallenge:
+			if settings.CrawlDelay != 0 {
+				list = &challenged
+			}
+		}
+
+		// The rules after this one never see these user agents, so they
+		// are only listed for the first rule that matches them.
+		for _, ua := range userAgents(*b.UserAgentRegex) {
+			if !seen[ua] && list != nil {
+				*list = append(*list, ua)
+			}
+			seen[ua] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by Anubis from its policy.\n")
+
+	if len(denied) != 0 {
+		buf.WriteString("\n")
+		for _, ua := range denied {
+			fmt.Fprintf(&buf, "User-agent: %s\n", ua)
+		}
+		buf.WriteString("Disallow: /\n")
+	}
+
+	if len(challenged) != 0 {
+		buf.WriteString("\n")
+		for _, ua := range challenged {
+			fmt.Fprintf(&buf, "User-agent: %s\n", ua)
+		}
+		fmt.Fprintf(&buf, "Crawl-delay: %d\n%s\n", settings.CrawlDelay, disallow)
+	}
+
+	fmt.Fprintf(&buf, "\nUser-agent: *\n%s\n", disallow)
+
+	if settings.Sitemap != "" {
+		fmt.Fprintf(&buf, "\nSitemap: %s\n", settings.Sitemap)
+	}
+
+	return buf.Bytes()
+}
+
+// onlyMatchesUserAgent reports whether b applies to every request with a
+// matching user agent, so that it can be put in robots.txt.
+func onlyMatchesUserAgent(b config.BotConfig) bool {
+	return b.UserAgentRegex != nil &&
+		b.PathRegex == nil &&
+		len(b.HeadersRegex) == 0 &&
+		len(b.HeadersMissing) == 0 &&
+		len(b.HeadersEqual) == 0 &&
+		len(b.Methods) == 0 &&
+		len(b.QueryRegex) == 0 &&
+		len(b.RemoteAddr) == 0 &&
+		len(b.IPRanges) == 0 &&
+		len(b.Countries) == 0 &&
+		b.Expression == nil &&
+		len(b.VerifyReverseDNS) == 0 &&
+		b.DecisionAPI == nil &&
+		b.WASM == nil &&
+		b.BrowserSanity == nil &&
+		b.Reputation == nil &&
+		len(b.All) == 0 &&
+		len(b.Any) == 0 &&
+		b.Not == nil &&
+		!b.DryRun &&
+		alwaysApplies(b)
+}
+
+// userAgents returns the user agents that rex spells out as alternatives.
+// Alternatives that are patterns rather than names are left out.
+func userAgents(rex string) []string {
+	var result []string
+
+	for _, alt := range strings.Split(strings.TrimSpace(rex), "|") {
+		alt = strings.TrimSpace(alt)
+		alt = strings.TrimPrefix(strings.TrimSuffix(alt, "$"), "^")
+		alt = strings.ReplaceAll(alt, `\.`, ".")
+
+		if userAgentToken.MatchString(alt) {
+			result = append(result, alt)
+		}
+	}
+
+	return result
+}
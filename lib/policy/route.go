@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// Route holds the settings for requests to one host, path prefix, or both.
+// Bots has the route's own rules followed by the global rules, and rules
+// without their own challenge settings use DefaultDifficulty.
+type Route struct {
+	Host              string
+	PathPrefix        string
+	Target            string
+	DefaultDifficulty int
+	Bots              []Bot
+}
+
+// Matches reports whether r is for the route's host and path prefix, if they
+// are set. Hosts starting with "*." match every subdomain of the rest of the
+// name.
+func (rt *Route) Matches(r *http.Request) bool {
+	if !config.HasPathPrefix(r.URL.Path, rt.PathPrefix) {
+		return false
+	}
+
+	if rt.Host == "" {
+		return true
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	pattern := strings.ToLower(rt.Host)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+
+	return host == pattern
+}
+
+// Route returns the first route that matches r, or nil if there is none.
+func (pc *ParsedConfig) Route(r *http.Request) *Route {
+	for i := range pc.Routes {
+		if pc.Routes[i].Matches(r) {
+			return &pc.Routes[i]
+		}
+	}
+
+	return nil
+}
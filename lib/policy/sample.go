@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+
+	"github.com/vale981/anubis/internal"
+)
+
+// SampleChecker only matches a fraction of the clients its checker matches.
+// Clients are picked by hashing their IP address with seed, so the same
+// client gets the same result every time and can't get around the rule by
+// retrying.
+type SampleChecker struct {
+	checker     Checker
+	seed        string
+	probability float64
+	hash        string
+}
+
+func NewSampleChecker(checker Checker, seed string, probability float64) (Checker, error) {
+	if probability <= 0 || probability > 1 {
+		return nil, fmt.Errorf("%w: probability must be between 0 and 1, got: %v", ErrMisconfiguration, probability)
+	}
+
+	return &SampleChecker{
+		checker:     checker,
+		seed:        seed,
+		probability: probability,
+		hash:        internal.SHA256sum(fmt.Sprintf("%s\nprobability: %v", checker.Hash(), probability)),
+	}, nil
+}
+
+func (sc *SampleChecker) Check(r *http.Request) (bool, error) {
+	ok, err := sc.checker.Check(r)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	return sc.sampled(r.Header.Get("X-Real-Ip")), nil
+}
+
+// sampled reports whether the client with the IP address addr is one of the
+// clients the rule applies to.
+func (sc *SampleChecker) sampled(addr string) bool {
+	sum := sha256.Sum256([]byte(sc.seed + "\x00" + addr))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/(1<<64) < sc.probability
+}
+
+func (sc *SampleChecker) Hash() string {
+	return sc.hash
+}
+
+func (sc *SampleChecker) Cleanup() {
+	if c, ok := sc.checker.(interface{ Cleanup() }); ok {
+		c.Cleanup()
+	}
+}
+
+func (sc *SampleChecker) Flush() {
+	if c, ok := sc.checker.(interface{ Flush() }); ok {
+		c.Flush()
+	}
+}
@@ -0,0 +1,20 @@
+;; Source of match-evil.wasm, a WebAssembly checker that matches requests
+;; whose description contains "evil" anywhere.
+(module
+  (memory (export "memory") 1)
+
+  ;; The input always fits after the first kilobyte of memory.
+  (func (export "anubis_alloc") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "anubis_check") (param $ptr i32) (param $len i32) (result i32)
+    (local $i i32)
+    (block $done
+      (loop $scan
+        (br_if $done (i32.gt_u (i32.add (local.get $i) (i32.const 4)) (local.get $len)))
+        (if (i32.eq (i32.load align=1 (i32.add (local.get $ptr) (local.get $i)))
+                    (i32.const 0x6c697665)) ;; "evil"
+          (then (return (i32.const 1))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $scan)))
+    i32.const 0))
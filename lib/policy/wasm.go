@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+var ErrWASMModule = errors.New("policy: WebAssembly checker failed")
+
+// wasmRuntime runs every WebAssembly checker. Modules get WASI so that they
+// can be built with toolchains like TinyGo and Rust, but they can't access
+// files, the network, or the clock beyond what WASI provides by default.
+var wasmRuntime = sync.OnceValue(func() wazero.Runtime {
+	ctx := context.Background()
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(wasmMemoryLimitPages))
+	wasi_snapshot_preview1.MustInstantiate(ctx, rt)
+	return rt
+})
+
+// wasmMemoryLimitPages caps the memory of every module instance at 16 MiB,
+// in pages of 64 KiB, so that a checker can't use up the memory of the host.
+const wasmMemoryLimitPages = 256
+
+// wasmCloseDelay is how long modules that no policy uses anymore are kept
+// around, so that requests still checked against the old policy can finish.
+const wasmCloseDelay = time.Minute
+
+// wasmModules caches compiled modules by the hash of their contents, so that
+// reloading the policy doesn't compile them again. Every parsed policy is a
+// generation, and modules are closed once no generation uses them anymore.
+var wasmModules = struct {
+	lock    sync.Mutex
+	modules map[string]*wasmModule
+}{modules: map[string]*wasmModule{}}
+
+// wasmGenerations numbers parsed policies for wasmModules.
+var wasmGenerations atomic.Uint64
+
+type wasmModule struct {
+	compiled    wazero.CompiledModule
+	generations map[uint64]struct{}
+}
+
+// releaseWASMModules forgets that generation uses its modules, and closes
+// the ones that no other generation uses.
+func releaseWASMModules(generation uint64) {
+	wasmModules.lock.Lock()
+	defer wasmModules.lock.Unlock()
+
+	for hash, m := range wasmModules.modules {
+		delete(m.generations, generation)
+		if len(m.generations) != 0 {
+			continue
+		}
+
+		delete(wasmModules.modules, hash)
+		time.AfterFunc(wasmCloseDelay, func() {
+			m.compiled.Close(context.Background())
+		})
+	}
+}
+
+// WASMChecker matches requests by running a WebAssembly module. The module
+// must export:
+//
+//   - memory: its linear memory
+//   - anubis_alloc(size i32) i32: returns a pointer to size bytes of memory
+//   - anubis_check(ptr i32, len i32) i32: looks at the JSON request
+//     description at ptr and returns 1 if the request matches, 0 if it doesn't,
+//     and anything else on error
+//
+// The request description is the same one decision APIs get. Every check
+// runs in a new instance of the module, so modules can't keep state between
+// requests.
+type WASMChecker struct {
+	rule     string
+	fname    string
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+	hash     string
+}
+
+// NewWASMChecker compiles the module in fname for the policy generation,
+// or reuses it if another generation already compiled it.
+func NewWASMChecker(generation uint64, rule, fname string, timeout time.Duration) (Checker, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("%w: can't read module: %w", ErrMisconfiguration, err)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	wasmModules.lock.Lock()
+	defer wasmModules.lock.Unlock()
+
+	m, ok := wasmModules.modules[hash]
+	if !ok {
+		c, err := wasmRuntime().CompileModule(context.Background(), data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: can't compile module %s: %w", ErrMisconfiguration, fname, err)
+		}
+
+		for _, name := range []string{"anubis_alloc", "anubis_check"} {
+			if _, ok := c.ExportedFunctions()[name]; !ok {
+				c.Close(context.Background())
+				return nil, fmt.Errorf("%w: module %s does not export %s", ErrMisconfiguration, fname, name)
+			}
+		}
+		if _, ok := c.ExportedMemories()["memory"]; !ok {
+			c.Close(context.Background())
+			return nil, fmt.Errorf("%w: module %s does not export memory", ErrMisconfiguration, fname)
+		}
+
+		m = &wasmModule{compiled: c, generations: map[uint64]struct{}{}}
+		wasmModules.modules[hash] = m
+	}
+	m.generations[generation] = struct{}{}
+
+	return &WASMChecker{
+		rule:     rule,
+		fname:    fname,
+		compiled: m.compiled,
+		timeout:  timeout,
+		hash:     hash,
+	}, nil
+}
+
+func (wc *WASMChecker) Check(r *http.Request) (bool, error) {
+	input, err := json.Marshal(newDecisionInput(wc.rule, r))
+	if err != nil {
+		return false, fmt.Errorf("[unexpected] can't encode request for WebAssembly checker: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wc.timeout)
+	defer cancel()
+
+	mod, err := wasmRuntime().InstantiateModule(ctx, wc.compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return false, fmt.Errorf("%w: can't instantiate %s: %w", ErrWASMModule, wc.fname, err)
+	}
+	defer mod.Close(context.Background())
+
+	results, err := mod.ExportedFunction("anubis_alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: anubis_alloc: %w", ErrWASMModule, wc.fname, err)
+	}
+
+	ptr := uint32(results[0])
+	if !mod.Memory().Write(ptr, input) {
+		return false, fmt.Errorf("%w: %s: anubis_alloc returned memory out of range", ErrWASMModule, wc.fname)
+	}
+
+	results, err = mod.ExportedFunction("anubis_check").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: anubis_check: %w", ErrWASMModule, wc.fname, err)
+	}
+
+	switch int32(results[0]) {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: %s: anubis_check returned %d", ErrWASMModule, wc.fname, int32(results[0]))
+	}
+}
+
+func (wc *WASMChecker) Hash() string {
+	return wc.hash
+}
@@ -2,8 +2,29 @@ package lib
 
 import (
 	"math/rand"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
 )
 
-func randomJitter() bool {
-	return rand.Intn(100) > 10
+// secondaryScreening reports whether a request with a valid cookie that
+// matched rule should be fully re-verified, based on the screening rate of
+// the rule or else the policy.
+func (s *Server) secondaryScreening(rule *policy.Bot) bool {
+	// Under attack, cookies shared between clients are weeded out.
+	if s.Emergency() {
+		return true
+	}
+
+	rate := s.policy.Load().SecondaryScreening
+	if rule != nil && rule.SecondaryScreening != nil {
+		rate = *rule.SecondaryScreening
+	}
+
+	return screen(rate)
+}
+
+// screen randomly returns true for the given fraction of calls.
+func screen(rate config.ScreeningRate) bool {
+	return rand.Float64() < float64(rate)
 }
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// randomChallengeLifetime is how long a client has to solve a random
+// challenge after it was issued.
+const randomChallengeLifetime = time.Hour
+
+// newChallenge returns the challenge to issue to the client making r.
+func (s *Server) newChallenge(r *http.Request, difficulty int) string {
+	if s.opts.RandomChallenges {
+		return s.randomChallengeFor(r, difficulty, time.Now())
+	}
+
+	return s.challengeFor(r, difficulty)
+}
+
+// randomChallengeFor makes a random challenge that is its own issuance
+// token. It has the form random.expiry.difficulty.mac, where the MAC covers
+// the other fields and the details of the client it was issued to, so that
+// Anubis can check it without keeping track of the challenges it issued.
+func (s *Server) randomChallengeFor(r *http.Request, difficulty int, now time.Time) string {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		panic(fmt.Sprintf("[unexpected] can't read random bytes: %v", err))
+	}
+
+	fields := fmt.Sprintf("%x.%d.%d", nonce, now.Add(randomChallengeLifetime).Unix(), difficulty)
+	return fields + "." + s.randomChallengeMAC(r, fields)
+}
+
+func (s *Server) randomChallengeMAC(r *http.Request, fields string) string {
+	mac := hmac.New(sha256.New, s.signingKey().Seed())
+	fmt.Fprintf(mac,
+		"challenge:%s,Accept-Language=%s,X-Real-IP=%s,User-Agent=%s",
+		fields,
+		r.Header.Get("Accept-Language"),
+		r.Header.Get("X-Real-Ip"),
+		r.UserAgent(),
+	)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyRandomChallenge checks that challenge was issued by Anubis to the
+// client making r and returns its difficulty. Unless fresh is set, expired
+// challenges are accepted, since cookies outlive the challenges they were
+// issued for.
+func (s *Server) verifyRandomChallenge(r *http.Request, challenge string, fresh bool, now time.Time) (int, bool) {
+	fields, mac, ok := cutLast(challenge, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.randomChallengeMAC(r, fields))) {
+		return 0, false
+	}
+
+	parts := strings.Split(fields, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	if fresh && now.Unix() > expiry {
+		return 0, false
+	}
+
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, false
+	}
+
+	return difficulty, true
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+
+	return s[:i], s[i+len(sep):], true
+}
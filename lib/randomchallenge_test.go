@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestRandomChallenges(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+    challenge:
+      difficulty: 1
+      report_as: 1
+      algorithm: fast
+`), "random_challenges.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol, RandomChallenges: true})
+
+	newRequest := func(ua string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		req.Header.Set("User-Agent", ua)
+		return req
+	}
+
+	t.Run("verify", func(t *testing.T) {
+		now := time.Now()
+		challenge := srv.randomChallengeFor(newRequest("Mozilla/5.0"), 3, now)
+
+		if other := srv.randomChallengeFor(newRequest("Mozilla/5.0"), 3, now); other == challenge {
+			t.Error("two challenges issued to the same client are the same")
+		}
+
+		if difficulty, ok := srv.verifyRandomChallenge(newRequest("Mozilla/5.0"), challenge, true, now); !ok || difficulty != 3 {
+			t.Errorf("valid challenge: difficulty %d, ok %v, wanted 3, true", difficulty, ok)
+		}
+
+		if _, ok := srv.verifyRandomChallenge(newRequest("OtherBrowser/1.0"), challenge, true, now); ok {
+			t.Error("challenge was accepted from another client")
+		}
+
+		later := now.Add(randomChallengeLifetime + time.Minute)
+		if _, ok := srv.verifyRandomChallenge(newRequest("Mozilla/5.0"), challenge, true, later); ok {
+			t.Error("expired challenge was accepted")
+		}
+
+		if _, ok := srv.verifyRandomChallenge(newRequest("Mozilla/5.0"), challenge, false, later); !ok {
+			t.Error("expired challenge was rejected even though it didn't need to be fresh")
+		}
+
+		tampered := strings.Replace(challenge, ".3.", ".1.", 1)
+		if _, ok := srv.verifyRandomChallenge(newRequest("Mozilla/5.0"), tampered, true, now); ok {
+			t.Error("challenge with a changed difficulty was accepted")
+		}
+	})
+
+	t.Run("pass", func(t *testing.T) {
+		challenge := srv.newChallenge(newRequest("Mozilla/5.0"), 1)
+
+		var nonce int
+		var response string
+		for nonce = 0; ; nonce++ {
+			response = internal.SHA256sum(fmt.Sprintf("%s%d", challenge, nonce))
+			if strings.HasPrefix(response, "0") {
+				break
+			}
+		}
+
+		for _, tt := range []struct {
+			name      string
+			challenge string
+			status    int
+		}{
+			{name: "missing_challenge", status: http.StatusForbidden},
+			{name: "solved", challenge: challenge, status: http.StatusFound},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				q := url.Values{}
+				q.Set("challenge", tt.challenge)
+				q.Set("response", response)
+				q.Set("nonce", fmt.Sprint(nonce))
+				q.Set("redir", "/")
+				q.Set("elapsedTime", "420")
+
+				req := newRequest("Mozilla/5.0")
+				req.URL.Path = "/.within.website/x/cmd/anubis/api/pass-challenge"
+				req.URL.RawQuery = q.Encode()
+
+				rec := httptest.NewRecorder()
+				srv.ServeHTTP(rec, req)
+
+				if rec.Code != tt.status {
+					t.Errorf("wanted status %d, got: %d", tt.status, rec.Code)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/lib/policy/config"
+	"github.com/vale981/anubis/web"
+)
+
+var (
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_rate_limited",
+		Help: "The total number of requests rejected by rate limits",
+	}, []string{"action"})
+
+	issuanceLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_challenge_issuance_limited",
+		Help: "The total number of challenges not issued because of the challenge issuance limits, by limit",
+	}, []string{"limit"})
+)
+
+// allowRequest applies the policy's rate limit for action to the client
+// identified by key. If the client is over the limit, it responds with 429
+// and returns false, unless the policy is in dry run mode.
+func (s *Server) allowRequest(w http.ResponseWriter, r *http.Request, action config.Rule, key string) bool {
+	l, ok := s.policy.Load().RateLimits[action]
+	if !ok {
+		return true
+	}
+
+	allowed, retryAfter := l.Allow(key)
+	if allowed {
+		return true
+	}
+
+	if s.dryRun(nil) {
+		s.requestLogger(r).Info("dry run: would have rate limited request", "action", action, "key", key)
+		dryRunResults.WithLabelValues("rate_limit", string(action)).Inc()
+		return true
+	}
+
+	rateLimited.WithLabelValues(string(action)).Inc()
+	s.requestLogger(r).Debug("rate limited", "action", action, "key", key, "retry_after", retryAfter)
+	s.respondRateLimited(w, r, retryAfter)
+	return false
+}
+
+// admitChallenge applies the policy's challenge issuance limits to r. If the
+// client may get a challenge, it returns a function to call once the
+// challenge was issued. Otherwise it responds with 429 and returns false,
+// unless the policy is in dry run mode.
+func (s *Server) admitChallenge(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	pol := s.policy.Load()
+	ip := r.Header.Get("X-Real-Ip")
+
+	limit := ""
+	var retryAfter time.Duration
+	if pol.IssuanceLimit != nil {
+		if allowed, wait := pol.IssuanceLimit.Allow(ip); !allowed {
+			limit, retryAfter = "per_ip", wait
+		}
+	}
+
+	release := func() {}
+	if limit == "" && pol.IssuanceSlots != nil {
+		select {
+		case pol.IssuanceSlots <- struct{}{}:
+			release = func() { <-pol.IssuanceSlots }
+		default:
+			limit, retryAfter = "concurrency", time.Second
+		}
+	}
+
+	if limit == "" {
+		return release, true
+	}
+
+	if s.dryRun(nil) {
+		s.requestLogger(r).Info("dry run: would have limited challenge issuance", "limit", limit)
+		dryRunResults.WithLabelValues("challenge_issuance", limit).Inc()
+		return release, true
+	}
+
+	issuanceLimited.WithLabelValues(limit).Inc()
+	s.requestLogger(r).Debug("challenge issuance limited", "limit", limit, "retry_after", retryAfter)
+	s.respondRateLimited(w, r, retryAfter)
+	return nil, false
+}
+
+// respondRateLimited tells a client that it has been throttled and when it
+// may try again. Anubis uses this for every kind of rate limit so that
+// well-behaved clients can back off on their own.
+func (s *Server) respondRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(ReasonHeader, string(ReasonRateLimited))
+
+	if isGRPCRequest(r) {
+		respondGRPCError(w, r, ReasonRateLimited, "too many requests", grpcResourceExhausted)
+		return
+	}
+
+	if s.isAPIPath(r) || s.wantsJSON(r) || strings.HasPrefix(r.URL.Path, anubis.StaticPath+"api/") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_ = json.NewEncoder(w).Encode(struct {
+			Error      string     `json:"error"`
+			Code       ReasonCode `json:"code"`
+			RetryAfter int        `json:"retry_after"`
+		}{
+			Error:      "too many requests",
+			Code:       ReasonRateLimited,
+			RetryAfter: seconds,
+		})
+		return
+	}
+
+	templ.Handler(
+		web.Base(localization.ForRequest(r).T("slow_down"), web.ErrorPage(localization.ForRequest(r).T("rate_limited", seconds), s.opts.WebmasterEmail)),
+		templ.WithStatus(http.StatusTooManyRequests),
+	).ServeHTTP(w, r)
+}
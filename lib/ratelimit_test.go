@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestRespondRateLimited(t *testing.T) {
+	pol := loadPolicies(t, "")
+	pol.APIPathPrefixes = []string{"/api/"}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	for _, tt := range []struct {
+		name           string
+		path           string
+		retryAfter     time.Duration
+		wantRetryAfter string
+		wantType       string
+	}{
+		{
+			name:           "html",
+			path:           "/",
+			retryAfter:     1500 * time.Millisecond,
+			wantRetryAfter: "2",
+			wantType:       "text/html; charset=utf-8",
+		},
+		{
+			name:           "api",
+			path:           "/api/foo",
+			retryAfter:     30 * time.Second,
+			wantRetryAfter: "30",
+			wantType:       "application/json",
+		},
+		{
+			name:           "never_zero",
+			path:           "/",
+			retryAfter:     0,
+			wantRetryAfter: "1",
+			wantType:       "text/html; charset=utf-8",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.respondRateLimited(w, httptest.NewRequest(http.MethodGet, tt.path, nil), tt.retryAfter)
+
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("wanted status %d, got: %d", http.StatusTooManyRequests, w.Code)
+			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("wanted Retry-After %q, got: %q", tt.wantRetryAfter, got)
+			}
+
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("wanted Content-Type %q, got: %q", tt.wantType, got)
+			}
+
+			if got := w.Header().Get(ReasonHeader); got != string(ReasonRateLimited) {
+				t.Errorf("wanted reason %s, got: %q", ReasonRateLimited, got)
+			}
+		})
+	}
+}
+
+func TestRateLimitAllowedClients(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: ALLOW
+
+rate_limits:
+  ALLOW:
+    rate: 0.5
+    burst: 2
+`), "rate_limits.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	for i, wantStatus := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		rec := httptest.NewRecorder()
+
+		srv.ServeHTTP(rec, req)
+
+		if rec.Code != wantStatus {
+			t.Errorf("request %d: wanted status %d, got: %d", i, wantStatus, rec.Code)
+		}
+
+		if wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("wanted Retry-After 2, got: %q", rec.Header().Get("Retry-After"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.2")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("other clients should not be limited, got status: %d", rec.Code)
+	}
+}
+
+func TestChallengeIssuanceLimits(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+
+challenge_issuance:
+  rate: 0.5
+  burst: 2
+  max_concurrent: 1
+`), "challenge_issuance.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	do := func(method, path, ip string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Real-Ip", ip)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, wantStatus := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := do(http.MethodGet, "/", "198.51.100.1")
+		if rec.Code != wantStatus {
+			t.Errorf("request %d: wanted status %d, got: %d", i, wantStatus, rec.Code)
+		}
+
+		if wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("wanted Retry-After 2, got: %q", rec.Header().Get("Retry-After"))
+		}
+	}
+
+	if rec := do(http.MethodGet, "/", "198.51.100.2"); rec.Code != http.StatusOK {
+		t.Errorf("other clients should not be limited, got status: %d", rec.Code)
+	}
+
+	// Take the only slot, as if another challenge was being issued.
+	pol.IssuanceSlots <- struct{}{}
+
+	rec := do(http.MethodPost, "/.within.website/x/cmd/anubis/api/make-challenge", "198.51.100.3")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("wanted status %d while all slots are taken, got: %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("wanted a JSON response for the challenge API, got: %q", got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("wanted Retry-After 1, got: %q", got)
+	}
+
+	<-pol.IssuanceSlots
+
+	if rec := do(http.MethodPost, "/.within.website/x/cmd/anubis/api/make-challenge", "198.51.100.3"); rec.Code != http.StatusOK {
+		t.Errorf("wanted status %d once the slot is free, got: %d", http.StatusOK, rec.Code)
+	}
+	if len(pol.IssuanceSlots) != 0 {
+		t.Errorf("slot wasn't given back after issuing the challenge")
+	}
+}
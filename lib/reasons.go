@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/web"
+)
+
+// ReasonHeader is the response header that carries the ReasonCode for any
+// response where Anubis did not pass a request through to the target.
+const ReasonHeader = "X-Anubis-Reason"
+
+// ReasonCode is a stable, machine-readable explanation of why Anubis blocked
+// or failed a request. Unlike error messages and rule hashes, reason codes
+// never change between versions or policy files.
+type ReasonCode string
+
+const (
+	ReasonRuleDenied          ReasonCode = "RULE_DENIED"
+	ReasonDNSBLListed         ReasonCode = "DNSBL_LISTED"
+	ReasonIPFeedListed        ReasonCode = "IP_FEED_LISTED"
+	ReasonIPFeedUnavailable   ReasonCode = "IP_FEED_UNAVAILABLE"
+	ReasonChallengeRequired   ReasonCode = "CHALLENGE_REQUIRED"
+	ReasonMissingNonce        ReasonCode = "MISSING_NONCE"
+	ReasonInvalidNonce        ReasonCode = "INVALID_NONCE"
+	ReasonMissingElapsedTime  ReasonCode = "MISSING_ELAPSED_TIME"
+	ReasonInvalidElapsedTime  ReasonCode = "INVALID_ELAPSED_TIME"
+	ReasonInvalidHashRate     ReasonCode = "INVALID_HASH_RATE"
+	ReasonInvalidResponse     ReasonCode = "INVALID_RESPONSE"
+	ReasonChallengeTooEarly   ReasonCode = "CHALLENGE_TOO_EARLY"
+	ReasonReplayedSolution    ReasonCode = "REPLAYED_SOLUTION"
+	ReasonInvalidCaptcha      ReasonCode = "INVALID_CAPTCHA"
+	ReasonRateLimited         ReasonCode = "RATE_LIMITED"
+	ReasonInvalidBypassToken  ReasonCode = "INVALID_BYPASS_TOKEN"
+	ReasonInvalidURLSignature ReasonCode = "INVALID_URL_SIGNATURE"
+	ReasonUpstreamUnavailable ReasonCode = "UPSTREAM_UNAVAILABLE"
+	ReasonMisconfiguration    ReasonCode = "MISCONFIGURATION"
+	ReasonInternalError       ReasonCode = "INTERNAL_ERROR"
+)
+
+// ReasonCodes lists every reason code Anubis can return along with a short
+// description of what it means.
+var ReasonCodes = []struct {
+	Code        ReasonCode `json:"code"`
+	Description string     `json:"description"`
+}{
+	{ReasonRuleDenied, "A policy rule with the DENY action matched the request."},
+	{ReasonDNSBLListed, "The client's IP address is listed in DroneBL."},
+	{ReasonIPFeedListed, "The client's IP address is listed in an IP feed of the policy with the DENY action."},
+	{ReasonIPFeedUnavailable, "An IP feed of the policy that fails closed can't be checked right now."},
+	{ReasonChallengeRequired, "The client needs to solve a challenge."},
+	{ReasonMissingNonce, "The challenge solution did not include a nonce."},
+	{ReasonInvalidNonce, "The challenge solution nonce is not a number."},
+	{ReasonMissingElapsedTime, "The challenge solution did not include the time it took."},
+	{ReasonInvalidElapsedTime, "The challenge solution time is not a number."},
+	{ReasonInvalidHashRate, "The reported hash rate is not a valid number."},
+	{ReasonInvalidResponse, "The challenge solution is wrong or does not meet the difficulty."},
+	{ReasonChallengeTooEarly, "The client came back from a no-JavaScript challenge before waiting long enough."},
+	{ReasonReplayedSolution, "The challenge solution was already used to get a cookie."},
+	{ReasonInvalidCaptcha, "The CAPTCHA provider rejected the client's solution."},
+	{ReasonRateLimited, "The client sent too many requests and must wait for the time in the Retry-After header."},
+	{ReasonInvalidBypassToken, "The bypass token is invalid, expired, revoked, or not valid for this path."},
+	{ReasonInvalidURLSignature, "The signature of a signed URL is wrong or expired."},
+	{ReasonUpstreamUnavailable, "The upstream can't be reached right now, try again after the time in the Retry-After header."},
+	{ReasonMisconfiguration, "Anubis is misconfigured, the administrator needs to check the logs."},
+	{ReasonInternalError, "Anubis ran into an unexpected error, the administrator needs to check the logs."},
+}
+
+// respondWithError renders the error page with the given message, or a JSON
+// object for clients that want JSON, and sets the reason header.
+func (s *Server) respondWithError(w http.ResponseWriter, r *http.Request, code ReasonCode, message string, status int) {
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.String("anubis.reason", string(code)))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, string(code))
+	}
+
+	if isGRPCRequest(r) {
+		respondGRPCError(w, r, code, message, grpcStatusFor(status))
+		return
+	}
+
+	w.Header().Set(ReasonHeader, string(code))
+
+	if s.wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(struct {
+			Error string     `json:"error"`
+			Code  ReasonCode `json:"code"`
+		}{
+			Error: message,
+			Code:  code,
+		})
+		return
+	}
+
+	templ.Handler(web.Base(localization.ForRequest(r).T("oh_noes"), web.ErrorPage(message, s.opts.WebmasterEmail)), templ.WithStatus(status)).ServeHTTP(w, r)
+}
+
+// ServeReasonCodes lists all reason codes as JSON.
+func (s *Server) ServeReasonCodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ReasonCodes)
+}
@@ -0,0 +1,285 @@
+package lib
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/vale981/anubis/lib/policy"
+)
+
+// maxRemotePolicySize is the largest policy file Anubis downloads.
+const maxRemotePolicySize = 10 * 1024 * 1024
+
+var (
+	ErrPolicySignature = errors.New("lib: remote policy signature is not valid")
+	ErrPolicyPublicKey = errors.New("lib: remote policy needs a public key to check its signature with")
+
+	// ErrVersionMissing and ErrVersionRollback are returned for signed
+	// files without a version, and for those older than the one in use.
+	// Otherwise anyone who can answer for the URL could serve an old file
+	// that was validly signed back then.
+	ErrVersionMissing  = errors.New("lib: signed file needs a version")
+	ErrVersionRollback = errors.New("lib: signed file is older than the one in use")
+)
+
+// IsRemotePolicy reports whether fname is the HTTPS URL of a remote policy
+// file rather than a file name.
+func IsRemotePolicy(fname string) bool {
+	return strings.HasPrefix(fname, "https://")
+}
+
+// RemotePolicy downloads a policy file from a URL. It remembers the ETag and
+// Last-Modified headers of the last version it loaded, so that checking for
+// changes is cheap for the server.
+//
+// The policy file must be signed with the Ed25519 private key matching
+// PublicKey. The hex-encoded signature is downloaded from the URL of the
+// policy file with ".sig" added. The policy file must have a version, and
+// files older than the one last loaded are rejected.
+type RemotePolicy struct {
+	URL       string
+	Client    *http.Client
+	PublicKey ed25519.PublicKey
+
+	// VersionFile, if set, is where the version of the last policy file
+	// loaded is saved, so that older ones are rejected after a restart too.
+	VersionFile string
+
+	lock          sync.Mutex
+	etag          string
+	lastModified  string
+	version       string
+	versionLoaded bool
+}
+
+// Load downloads and parses the policy file. If it hasn't changed since the
+// last time it was loaded, Load returns a nil policy and no error.
+func (rp *RemotePolicy) Load(ctx context.Context, defaultDifficulty int) (*policy.ParsedConfig, error) {
+	if rp.PublicKey == nil {
+		return nil, ErrPolicyPublicKey
+	}
+
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+
+	sf, err := fetchSigned(ctx, rp.client(), rp.URL, rp.PublicKey, rp.etag, rp.lastModified, "policy file", ErrPolicySignature)
+	if err != nil || sf == nil {
+		return nil, err
+	}
+
+	pol, err := policy.ParseConfig(bytes.NewReader(sf.body), rp.URL, defaultDifficulty)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion("policy file", rp.currentVersion(), pol.Version); err != nil {
+		return nil, err
+	}
+
+	rp.etag = sf.etag
+	rp.lastModified = sf.lastModified
+	rp.version = pol.Version
+
+	if rp.VersionFile != "" {
+		if err := os.WriteFile(rp.VersionFile, []byte(pol.Version), 0o600); err != nil {
+			slog.Warn("can't save policy version", "path", rp.VersionFile, "err", err)
+		}
+	}
+
+	return pol, nil
+}
+
+// currentVersion returns the version of the policy file last loaded, reading
+// it from VersionFile after a restart.
+func (rp *RemotePolicy) currentVersion() string {
+	if rp.versionLoaded || rp.VersionFile == "" {
+		return rp.version
+	}
+	rp.versionLoaded = true
+
+	data, err := os.ReadFile(rp.VersionFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		slog.Warn("can't read saved policy version", "path", rp.VersionFile, "err", err)
+	default:
+		rp.version = strings.TrimSpace(string(data))
+	}
+
+	return rp.version
+}
+
+func (rp *RemotePolicy) client() *http.Client {
+	if rp.Client != nil {
+		return rp.Client
+	}
+
+	return http.DefaultClient
+}
+
+// checkVersion returns an error if next, the version of a signed file, is
+// missing or older than current, the version of the one in use.
+func checkVersion(what, current, next string) error {
+	if next == "" {
+		return fmt.Errorf("%w: %s has no version", ErrVersionMissing, what)
+	}
+	if current != "" && compareVersions(next, current) < 0 {
+		return fmt.Errorf("%w: %s version %q is older than %q", ErrVersionRollback, what, next, current)
+	}
+
+	return nil
+}
+
+// compareVersions compares the versions a and b like cmp.Compare. Runs of
+// digits are compared as numbers and everything else as text, so that dates
+// like 2026-10-01 and release numbers like 1.10 sort as expected.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		var ca, cb string
+		ca, a = versionChunk(a)
+		cb, b = versionChunk(b)
+
+		if isDigit(ca[0]) && isDigit(cb[0]) {
+			ca = strings.TrimLeft(ca, "0")
+			cb = strings.TrimLeft(cb, "0")
+			if c := cmp.Compare(len(ca), len(cb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(ca, cb); c != 0 {
+			return c
+		}
+	}
+
+	return cmp.Compare(len(a), len(b))
+}
+
+// versionChunk splits the leading run of digits or non-digits off s.
+func versionChunk(s string) (string, string) {
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// signedFile is a file downloaded by fetchSigned.
+type signedFile struct {
+	body         []byte
+	sig          []byte
+	etag         string
+	lastModified string
+}
+
+// fetchSigned downloads the file at url, what it is named in errors, unless
+// it still has the given ETag or hasn't been modified since lastModified, in
+// which case it returns nil and no error. If pub is set, the file must be
+// signed with the matching private key, or sigErr is returned.
+func fetchSigned(ctx context.Context, client *http.Client, url string, pub ed25519.PublicKey, etag, lastModified, what string, sigErr error) (*signedFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch %s %s: %w", what, url, err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch %s %s: %w", what, url, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("can't fetch %s %s: %s", what, url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemotePolicySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch %s %s: %w", what, url, err)
+	}
+	if len(body) > maxRemotePolicySize {
+		return nil, fmt.Errorf("%s %s is larger than %d bytes", what, url, maxRemotePolicySize)
+	}
+
+	result := &signedFile{
+		body:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	if pub != nil {
+		result.sig, err = fetchSignature(ctx, client, url, what)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifySignature(pub, body, result.sig, sigErr); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// fetchSignature downloads the signature of the file at url from the URL
+// with ".sig" added.
+func fetchSignature(ctx context.Context, client *http.Client, url, what string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+".sig", nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch %s signature: %w", what, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch %s signature: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch %s signature %s.sig: %s", what, url, resp.Status)
+	}
+
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch %s signature: %w", what, err)
+	}
+
+	return sig, nil
+}
+
+// verifySignature checks the hex-encoded Ed25519 signature sigHex of body.
+func verifySignature(pub ed25519.PublicKey, body, sigHex []byte, sigErr error) error {
+	sig, err := hex.DecodeString(strings.TrimSpace(string(sigHex)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: signature must be %d hex-encoded bytes", sigErr, ed25519.SignatureSize)
+	}
+
+	if !ed25519.Verify(pub, body, sig) {
+		return sigErr
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vale981/anubis"
+)
+
+func TestRemotePolicy(t *testing.T) {
+	policyFile, err := os.ReadFile("./policy/config/testdata/good/dry_run.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyFile = append([]byte("version: \"1\"\n"), policyFile...)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := hex.EncodeToString(ed25519.Sign(priv, policyFile))
+
+	var fetches int
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/policy.yaml":
+			fetches++
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(policyFile)
+		case "/policy.yaml.sig":
+			w.Write([]byte(sig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	rp := &RemotePolicy{
+		URL:       ts.URL + "/policy.yaml",
+		Client:    ts.Client(),
+		PublicKey: pub,
+	}
+
+	pol, err := rp.Load(context.Background(), anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pol == nil || len(pol.Bots) != 2 {
+		t.Fatalf("wanted a policy with 2 rules, got: %+v", pol)
+	}
+
+	pol, err = rp.Load(context.Background(), anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pol != nil {
+		t.Error("wanted no policy for an unchanged file")
+	}
+
+	if fetches != 2 {
+		t.Errorf("fetched the policy %d times, wanted 2", fetches)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp = &RemotePolicy{
+		URL:       ts.URL + "/policy.yaml",
+		Client:    ts.Client(),
+		PublicKey: otherPub,
+	}
+
+	if _, err := rp.Load(context.Background(), anubis.DefaultDifficulty); !errors.Is(err, ErrPolicySignature) {
+		t.Errorf("err: %v, wanted: %v", err, ErrPolicySignature)
+	}
+
+	rp = &RemotePolicy{URL: ts.URL + "/policy.yaml", Client: ts.Client()}
+	if _, err := rp.Load(context.Background(), anubis.DefaultDifficulty); !errors.Is(err, ErrPolicyPublicKey) {
+		t.Errorf("err: %v, wanted: %v", err, ErrPolicyPublicKey)
+	}
+
+	if _, err := LoadPoliciesOrDefault(ts.URL+"/policy.yaml", anubis.DefaultDifficulty); !errors.Is(err, ErrPolicyPublicKey) {
+		t.Errorf("err: %v, wanted: %v", err, ErrPolicyPublicKey)
+	}
+}
+
+func TestRemotePolicyRollback(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/policy.yaml":
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(body)))
+			w.Write(body)
+		case "/policy.yaml.sig":
+			w.Write([]byte(hex.EncodeToString(ed25519.Sign(priv, body))))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	versionFile := filepath.Join(t.TempDir(), "policy-version")
+	newRemotePolicy := func() *RemotePolicy {
+		return &RemotePolicy{
+			URL:         ts.URL + "/policy.yaml",
+			Client:      ts.Client(),
+			PublicKey:   pub,
+			VersionFile: versionFile,
+		}
+	}
+	rp := newRemotePolicy()
+
+	for _, tt := range []struct {
+		name, version string
+		rp            *RemotePolicy
+		err           error
+	}{
+		{name: "first", version: "2026-10-01", rp: rp},
+		{name: "older", version: "2026-09-30", rp: rp, err: ErrVersionRollback},
+		{name: "missing", rp: rp, err: ErrVersionMissing},
+		{name: "newer", version: "2026-10-02", rp: rp},
+		{name: "older_after_restart", version: "2026-10-01", rp: newRemotePolicy(), err: ErrVersionRollback},
+		{name: "same_after_restart", version: "2026-10-02", rp: newRemotePolicy()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body = []byte("bots:\n  - name: everyone\n    user_agent_regex: .*\n    action: ALLOW\n")
+			if tt.version != "" {
+				body = append([]byte(fmt.Sprintf("version: %q\n", tt.version)), body...)
+			}
+
+			pol, err := tt.rp.Load(context.Background(), anubis.DefaultDifficulty)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err: %v, wanted: %v", err, tt.err)
+			}
+			if tt.err == nil && (pol == nil || pol.Version != tt.version) {
+				t.Errorf("wanted policy version %q, got: %+v", tt.version, pol)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{a: "2026-10-01", b: "2026-10-01", want: 0},
+		{a: "2026-10-01", b: "2026-10-02", want: -1},
+		{a: "2026-10-01", b: "2026-09-30", want: 1},
+		{a: "2026-10-01T12:00:00Z", b: "2026-10-01", want: 1},
+		{a: "1.9", b: "1.10", want: -1},
+		{a: "v2", b: "v10", want: -1},
+		{a: "10", b: "9", want: 1},
+		{a: "007", b: "7", want: 0},
+		{a: "1.0", b: "1", want: 1},
+		{a: "1.0-beta", b: "1.0-alpha", want: 1},
+	} {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, wanted %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
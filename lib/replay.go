@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/store"
+)
+
+// redeemedExpiry is how long redeemed challenge solutions are remembered.
+// Challenges change every week, so after this a solution can't be valid
+// anymore.
+const redeemedExpiry = 8 * 24 * time.Hour
+
+var replayedSolutions = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "anubis_replayed_solutions",
+	Help: "The total number of challenge solutions that were rejected because they had already been used",
+})
+
+var storeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_store_errors",
+	Help: "The total number of security checks that couldn't be done because the store failed, by check",
+}, []string{"check"})
+
+// redeem records that the solution nonce to challenge was exchanged for a
+// cookie. It returns false if it had already been redeemed, so that a
+// solution can't be shared between clients that get the same challenge.
+//
+// The store can't check and set a key at once, so two replicas that get
+// the same solution at the same moment may both accept it.
+func (s *Server) redeem(ctx context.Context, challenge string, nonce int) (bool, error) {
+	key := fmt.Sprintf("%s:%d", challenge, nonce)
+
+	_, err := s.redeemed.Get(ctx, key)
+	switch {
+	case err == nil:
+		replayedSolutions.Inc()
+		return false, nil
+	case !errors.Is(err, store.ErrNotFound):
+		return false, err
+	}
+
+	if err := s.redeemed.Set(ctx, key, time.Now().Unix(), redeemedExpiry); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
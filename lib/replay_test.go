@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/store"
+)
+
+// failingStore fails every operation on keys starting with prefix.
+type failingStore struct {
+	store.Interface
+	prefix string
+}
+
+var errStoreDown = errors.New("store is down")
+
+func (f failingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if strings.HasPrefix(key, f.prefix) {
+		return nil, errStoreDown
+	}
+	return f.Interface.Get(ctx, key)
+}
+
+func (f failingStore) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	if strings.HasPrefix(key, f.prefix) {
+		return errStoreDown
+	}
+	return f.Interface.Set(ctx, key, value, expiry)
+}
+
+func replayPolicy(t *testing.T) *policy.ParsedConfig {
+	t.Helper()
+
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+    challenge:
+      difficulty: 1
+      report_as: 1
+      algorithm: fast
+`), "replay.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pol
+}
+
+func newReplayRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	return req
+}
+
+// solutionQuery solves the challenge srv gives newReplayRequest and returns
+// the query to pass it with.
+func solutionQuery(srv *Server) url.Values {
+	challenge := srv.challengeFor(newReplayRequest(), 1)
+
+	var nonce int
+	var response string
+	for nonce = 0; ; nonce++ {
+		response = internal.SHA256sum(fmt.Sprintf("%s%d", challenge, nonce))
+		if strings.HasPrefix(response, "0") {
+			break
+		}
+	}
+
+	q := url.Values{}
+	q.Set("response", response)
+	q.Set("nonce", fmt.Sprint(nonce))
+	q.Set("redir", "/")
+	q.Set("elapsedTime", "420")
+	return q
+}
+
+func passChallenge(srv *Server, q url.Values) *httptest.ResponseRecorder {
+	req := newReplayRequest()
+	req.URL.Path = "/.within.website/x/cmd/anubis/api/pass-challenge"
+	req.URL.RawQuery = q.Encode()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReplayedSolution(t *testing.T) {
+	pol := replayPolicy(t)
+
+	st := store.NewMemory()
+	a := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol, Store: st})
+	b := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol, Store: st, PrivateKey: a.signingKey()})
+
+	q := solutionQuery(a)
+
+	for _, tt := range []struct {
+		name   string
+		srv    *Server
+		status int
+		reason ReasonCode
+	}{
+		{name: "first", srv: a, status: http.StatusFound},
+		{name: "replayed", srv: a, status: http.StatusForbidden, reason: ReasonReplayedSolution},
+		{name: "replayed_on_other_replica", srv: b, status: http.StatusForbidden, reason: ReasonReplayedSolution},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := passChallenge(tt.srv, q)
+
+			if rec.Code != tt.status {
+				t.Errorf("wanted status %d, got: %d", tt.status, rec.Code)
+			}
+
+			if got := rec.Header().Get(ReasonHeader); got != string(tt.reason) {
+				t.Errorf("wanted reason %q, got: %q", tt.reason, got)
+			}
+		})
+	}
+}
+
+func TestReplayStoreFailure(t *testing.T) {
+	pol := replayPolicy(t)
+
+	for _, tt := range []struct {
+		name       string
+		failClosed bool
+		status     int
+		reason     ReasonCode
+	}{
+		{name: "fail_open", status: http.StatusFound},
+		{name: "fail_closed", failClosed: true, status: http.StatusInternalServerError, reason: ReasonInternalError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := spawnAnubis(t, Options{
+				Next:            http.NewServeMux(),
+				Policy:          pol,
+				Store:           failingStore{Interface: store.NewMemory(), prefix: "redeemed:"},
+				StoreFailClosed: tt.failClosed,
+			})
+
+			before := testutil.ToFloat64(storeErrors.WithLabelValues("replay"))
+			rec := passChallenge(srv, solutionQuery(srv))
+
+			if rec.Code != tt.status {
+				t.Errorf("wanted status %d, got: %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get(ReasonHeader); got != string(tt.reason) {
+				t.Errorf("wanted reason %q, got: %q", tt.reason, got)
+			}
+			if got := testutil.ToFloat64(storeErrors.WithLabelValues("replay")) - before; got != 1 {
+				t.Errorf("wanted 1 store error to be counted, got: %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/store"
+)
+
+const (
+	// reputationHalfLife is how quickly reputation scores decay back
+	// towards zero.
+	reputationHalfLife = 24 * time.Hour
+
+	// reputationExpiry is how long a score is kept after it last changed.
+	// By then it has decayed to almost nothing.
+	reputationExpiry = 7 * 24 * time.Hour
+
+	// reputationLimit is the largest absolute score a client can have.
+	reputationLimit = 100
+)
+
+// How much events change a client's reputation score.
+const (
+	reputationChallengePassed = 10
+	reputationBrowsing        = 1
+	reputationChallengeFailed = -10
+	reputationDenied          = -20
+)
+
+var reputationEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_reputation_events",
+	Help: "The number of events that changed client reputation scores",
+}, []string{"event"})
+
+// reputationEntry is a client's reputation score as of Updated.
+type reputationEntry struct {
+	Score   float64   `json:"score"`
+	Updated time.Time `json:"updated"`
+}
+
+// at returns the score decayed to now.
+func (re reputationEntry) at(now time.Time) float64 {
+	elapsed := now.Sub(re.Updated)
+	if elapsed <= 0 {
+		return re.Score
+	}
+
+	return re.Score * math.Exp2(-elapsed.Hours()/reputationHalfLife.Hours())
+}
+
+// reputationOf returns the current reputation score of the client making r.
+// Clients Anubis knows nothing about have a score of zero.
+func (s *Server) reputationOf(r *http.Request) float64 {
+	entry, err := s.reputation.Get(r.Context(), velocityKey(r))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return 0
+	case err != nil:
+		s.requestLogger(r).Error("can't read client reputation", "err", err)
+		return 0
+	}
+
+	return entry.at(time.Now())
+}
+
+// recordReputation changes the reputation score of the client making r by
+// delta, if the policy uses reputation. Concurrent updates for the same
+// client may overwrite each other; scores are a heuristic, so that is fine.
+func (s *Server) recordReputation(r *http.Request, event string, delta float64) {
+	if !s.policy.Load().Reputation {
+		return
+	}
+
+	now := time.Now()
+	score := s.reputationOf(r) + delta
+	score = max(-reputationLimit, min(reputationLimit, score))
+
+	if err := s.reputation.Set(r.Context(), velocityKey(r), reputationEntry{Score: score, Updated: now}, reputationExpiry); err != nil {
+		s.requestLogger(r).Error("can't store client reputation", "err", err)
+		return
+	}
+
+	reputationEvents.WithLabelValues(event).Inc()
+}
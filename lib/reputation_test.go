@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestReputation(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, "./policy/config/testdata/good/reputation.yaml"),
+	})
+
+	newRequest := func(ip string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("X-Real-Ip", ip)
+		return req
+	}
+
+	for _, tt := range []struct {
+		name   string
+		ip     string
+		event  string
+		delta  float64
+		times  int
+		action config.Rule
+	}{
+		{name: "new_client", ip: "198.51.100.1", action: config.RuleChallenge},
+		{name: "regular", ip: "198.51.100.2", event: "challenge_passed", delta: reputationChallengePassed, times: 4, action: config.RuleAllow},
+		{name: "offender", ip: "198.51.100.3", event: "denied", delta: reputationDenied, times: 2, action: config.RuleDeny},
+		{name: "one_failure", ip: "198.51.100.4", event: "challenge_failed", delta: reputationChallengeFailed, times: 1, action: config.RuleChallenge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for range tt.times {
+				srv.recordReputation(newRequest(tt.ip), tt.event, tt.delta)
+			}
+
+			cr, _, err := srv.check(newRequest(tt.ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cr.Rule != tt.action {
+				t.Errorf("action: %s, wanted: %s (score %v)", cr.Rule, tt.action, srv.reputationOf(newRequest(tt.ip)))
+			}
+		})
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		req := newRequest("198.51.100.5")
+		for range 20 {
+			srv.recordReputation(req, "challenge_passed", reputationChallengePassed)
+		}
+
+		if score := srv.reputationOf(req); score > reputationLimit {
+			t.Errorf("score: %v, wanted at most %d", score, reputationLimit)
+		}
+	})
+
+	t.Run("not_tracked_without_rules", func(t *testing.T) {
+		srv := spawnAnubis(t, Options{
+			Next:   http.NewServeMux(),
+			Policy: loadPolicies(t, ""),
+		})
+
+		req := newRequest("198.51.100.6")
+		srv.recordReputation(req, "challenge_passed", reputationChallengePassed)
+
+		if score := srv.reputationOf(req); score != 0 {
+			t.Errorf("score: %v, wanted: 0", score)
+		}
+	})
+}
+
+func TestReputationDecay(t *testing.T) {
+	now := time.Now()
+	entry := reputationEntry{Score: 80, Updated: now.Add(-2 * reputationHalfLife)}
+
+	if got := entry.at(now); got < 19.9 || got > 20.1 {
+		t.Errorf("score after two half-lives: %v, wanted: 20", got)
+	}
+
+	if got := entry.at(entry.Updated); got != 80 {
+		t.Errorf("score without decay: %v, wanted: 80", got)
+	}
+}
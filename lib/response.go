@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// responseFor computes the response a client must send for challenge and
+// nonce with algorithm.
+func (s *Server) responseFor(algorithm config.Algorithm, challenge string, nonce int) string {
+	calcString := fmt.Sprintf("%s%d", challenge, nonce)
+
+	switch algorithm {
+	case config.AlgorithmScrypt:
+		return scryptResponse(calcString, challenge)
+	case config.AlgorithmNoJS:
+		// Clients without JavaScript are handed the response, so it must
+		// be something only Anubis can compute.
+		mac := hmac.New(sha256.New, s.signingKey().Seed())
+		mac.Write([]byte("nojs:" + calcString))
+		return hex.EncodeToString(mac.Sum(nil))
+	default:
+		return internal.SHA256sum(calcString)
+	}
+}
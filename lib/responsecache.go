@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/vale981/anubis"
+)
+
+// useResponseCache reports whether r goes through the response cache. That
+// is every request that may change a cached response, but only the GET and
+// HEAD requests of anonymous clients, who send no cookies but Anubis' own,
+// so that nobody is served a page made for someone else.
+func (s *Server) useResponseCache(r *http.Request) bool {
+	if s.opts.ResponseCache == nil {
+		return false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+
+	if r.Header.Get("Authorization") != "" || isStreamingRequest(r) {
+		return false
+	}
+
+	for _, c := range r.Cookies() {
+		if c.Name != anubis.CookieName {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PurgeResponseCache removes the cached responses whose host and URI start
+// with prefix, all of them if it is empty, and returns how many were
+// removed.
+func (s *Server) PurgeResponseCache(prefix string) int {
+	if s.opts.ResponseCache == nil {
+		return 0
+	}
+
+	return s.opts.ResponseCache.Purge(prefix)
+}
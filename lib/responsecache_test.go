@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal/httpcache"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestResponseCache(t *testing.T) {
+	var hits int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte("hello"))
+	})
+
+	cache, err := httpcache.New(httpcache.NewMemory(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:          next,
+		Policy:        allowAllPolicy(t),
+		ResponseCache: cache,
+	})
+
+	do := func(method string, header http.Header) {
+		t.Helper()
+
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header = header
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("wanted status 200, got: %d", rec.Code)
+		}
+	}
+
+	do(http.MethodGet, http.Header{})
+	do(http.MethodGet, http.Header{"Cookie": {anubis.CookieName + "=token"}})
+	if hits != 1 {
+		t.Errorf("wanted anonymous clients to share one response, got %d requests to the target", hits)
+	}
+
+	do(http.MethodGet, http.Header{"Cookie": {"session=secret"}})
+	do(http.MethodGet, http.Header{"Authorization": {"Bearer secret"}})
+	if hits != 3 {
+		t.Errorf("wanted clients with credentials to skip the cache, got %d requests to the target", hits)
+	}
+
+	do(http.MethodPost, http.Header{"Cookie": {"session=secret"}})
+	do(http.MethodGet, http.Header{})
+	if hits != 5 {
+		t.Errorf("wanted a POST to remove the cached response, got %d requests to the target", hits)
+	}
+
+	if got := srv.PurgeResponseCache(""); got != 1 {
+		t.Errorf("wanted one response purged, got: %d", got)
+	}
+}
+
+func TestResponseCacheTargets(t *testing.T) {
+	target := func(body string) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	mirror := target("mirror")
+	archive := target("archive")
+
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: mirror-crawlers
+    user_agent_regex: MirrorBot
+    action: ALLOW
+    target: `+mirror.URL+`
+  - name: archive-crawlers
+    user_agent_regex: ArchiveBot
+    action: ALLOW
+    target: `+archive.URL+`
+  - name: everyone
+    user_agent_regex: .*
+    action: ALLOW
+`), "targets.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := httpcache.New(httpcache.NewMemory(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Write([]byte("origin"))
+		}),
+		Policy:        pol,
+		ResponseCache: cache,
+	})
+
+	for _, tt := range []struct {
+		userAgent string
+		want      string
+	}{
+		{userAgent: "MirrorBot/1.0", want: "mirror"},
+		{userAgent: "Mozilla/5.0", want: "origin"},
+		{userAgent: "ArchiveBot/1.0", want: "archive"},
+		{userAgent: "MirrorBot/1.0", want: "mirror"},
+		{userAgent: "Mozilla/5.0", want: "origin"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		req.Header.Set("User-Agent", tt.userAgent)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: wanted the response of %s, got: %s", tt.userAgent, tt.want, got)
+		}
+	}
+
+	if got := cache.Len(); got != 3 {
+		t.Errorf("wanted one cached response per target, got: %d", got)
+	}
+	if got := srv.PurgeResponseCache(""); got != 3 {
+		t.Errorf("wanted three responses purged, got: %d", got)
+	}
+}
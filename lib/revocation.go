@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vale981/anubis/lib/store"
+)
+
+const (
+	// revokedBeforeKey is the store key holding the time before which all
+	// cookies are revoked, as a Unix timestamp.
+	revokedBeforeKey = "revoked-before"
+
+	// revocationExpiry is how long a revocation is kept. After this, every
+	// cookie it applies to has expired anyway.
+	revocationExpiry = 8 * 24 * time.Hour
+
+	// revocationRefresh is how often the revocation time is read from the
+	// store, so that revocations made by other replicas apply quickly without
+	// a store lookup on every request.
+	revocationRefresh = 10 * time.Second
+)
+
+// revocation caches the revocation time from the store.
+type revocation struct {
+	store *store.JSON[int64]
+
+	lock      sync.Mutex
+	before    int64
+	refreshed time.Time
+}
+
+// RevokeTokens invalidates every Anubis cookie issued up to now on every
+// replica sharing this server's store. Clients have to solve a new challenge
+// afterwards. Unlike rotating the signing key, this keeps working when
+// several instances share one key.
+func (s *Server) RevokeTokens(ctx context.Context) error {
+	now := time.Now().Unix()
+
+	if err := s.revocation.store.Set(ctx, revokedBeforeKey, now, revocationExpiry); err != nil {
+		return err
+	}
+
+	s.revocation.lock.Lock()
+	defer s.revocation.lock.Unlock()
+	s.revocation.before = now
+	s.revocation.refreshed = time.Now()
+
+	return nil
+}
+
+// revokedBefore returns the Unix timestamp up to which cookies are revoked,
+// or zero if there was no revocation.
+func (s *Server) revokedBefore(ctx context.Context) int64 {
+	rv := &s.revocation
+
+	rv.lock.Lock()
+	defer rv.lock.Unlock()
+
+	if time.Since(rv.refreshed) < revocationRefresh {
+		return rv.before
+	}
+
+	before, err := rv.store.Get(ctx, revokedBeforeKey)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		rv.before = 0
+	case err != nil:
+		// Keep the last known value rather than letting every cookie in or
+		// locking everyone out while the store is unavailable.
+		slog.Error("can't read token revocation time", "err", err)
+	default:
+		rv.before = before
+	}
+	rv.refreshed = time.Now()
+
+	return rv.before
+}
+
+// tokenRevoked reports whether claims belong to a cookie that was issued
+// before the last call to RevokeTokens.
+func (s *Server) tokenRevoked(ctx context.Context, claims jwt.MapClaims) bool {
+	before := s.revokedBefore(ctx)
+	if before == 0 {
+		return false
+	}
+
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return true
+	}
+
+	return iat.Unix() <= before
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vale981/anubis/lib/store"
+)
+
+func TestRevokeTokens(t *testing.T) {
+	st := store.NewMemory()
+	pol := loadPolicies(t, "")
+
+	a := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol, Store: st})
+	b := spawnAnubis(t, Options{Next: http.NewServeMux(), Policy: pol, Store: st})
+
+	before := jwt.MapClaims{"iat": float64(time.Now().Add(-time.Hour).Unix())}
+	after := jwt.MapClaims{"iat": float64(time.Now().Add(time.Hour).Unix())}
+
+	if a.tokenRevoked(t.Context(), before) {
+		t.Fatal("tokens should not be revoked before RevokeTokens is called")
+	}
+
+	if err := a.RevokeTokens(t.Context()); err != nil {
+		t.Fatalf("can't revoke tokens: %v", err)
+	}
+
+	for _, s := range []*Server{a, b} {
+		if !s.tokenRevoked(t.Context(), before) {
+			t.Error("tokens issued before RevokeTokens should be revoked")
+		}
+
+		if s.tokenRevoked(t.Context(), after) {
+			t.Error("tokens issued after RevokeTokens should not be revoked")
+		}
+
+		if !s.tokenRevoked(t.Context(), jwt.MapClaims{}) {
+			t.Error("tokens without an issue time should be revoked")
+		}
+	}
+}
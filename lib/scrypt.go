@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/scrypt"
+)
+
+var scryptComputations = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "anubis_scrypt_computations",
+	Help: "The number of scrypt responses computed to check solutions",
+})
+
+// Parameters for the memory-hard scrypt algorithm. Every attempt needs
+// 128 * scryptR * scryptN bytes (4 MiB) of memory. These must match the ones
+// in web/js/proof-of-work-scrypt.mjs.
+const (
+	scryptN      = 1 << 12
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// scryptResponse computes the response for the memory-hard algorithm.
+func scryptResponse(calcString, challenge string) string {
+	scryptComputations.Inc()
+	key, err := scrypt.Key([]byte(calcString), []byte(challenge), scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		// Only happens with invalid parameters, which are constants.
+		slog.Error("[unexpected] can't compute scrypt response", "err", err)
+		return ""
+	}
+
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/internal"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestResponseFor(t *testing.T) {
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: loadPolicies(t, ""),
+	})
+
+	// Computed by web/js/proof-of-work-scrypt.mjs, so that the client and
+	// server are known to agree.
+	const want = "05df0b1ce1ff128e15a2c6966950535450079b29979797c1606d82d60c337397"
+
+	if got := srv.responseFor(config.AlgorithmScrypt, "abc", 7); got != want {
+		t.Errorf("wanted scrypt response %s, got: %s", want, got)
+	}
+
+	if got, want := srv.responseFor(config.AlgorithmFast, "abc", 7), internal.SHA256sum("abc7"); got != want {
+		t.Errorf("wanted fast response %s, got: %s", want, got)
+	}
+}
+
+func TestScryptChallenge(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: CHALLENGE
+    challenge:
+      difficulty: 1
+      max_difficulty: 4
+      report_as: 1
+      algorithm: scrypt
+`), "scrypt.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	challenge := srv.challengeFor(req, 1)
+
+	var nonce int
+	var response string
+	for nonce = 0; ; nonce++ {
+		response = srv.responseFor(config.AlgorithmScrypt, challenge, nonce)
+		if strings.HasPrefix(response, "0") && !strings.HasPrefix(response, "00") {
+			break
+		}
+	}
+
+	for _, tt := range []struct {
+		name         string
+		response     string
+		wantStatus   int
+		computations float64
+	}{
+		{
+			name:         "no_leading_zeroes",
+			response:     strings.Repeat("f", 64),
+			wantStatus:   http.StatusForbidden,
+			computations: 0,
+		},
+		{
+			name:         "too_many_leading_zeroes",
+			response:     strings.Repeat("0", 5) + strings.Repeat("f", 59),
+			wantStatus:   http.StatusForbidden,
+			computations: 0,
+		},
+		{
+			name:         "sha256_response",
+			response:     "0" + strings.Repeat("f", 63),
+			wantStatus:   http.StatusForbidden,
+			computations: 1,
+		},
+		{
+			name:         "scrypt_response",
+			response:     response,
+			wantStatus:   http.StatusFound,
+			computations: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{}
+			q.Set("response", tt.response)
+			q.Set("nonce", fmt.Sprint(nonce))
+			q.Set("redir", "/")
+			q.Set("elapsedTime", "420")
+
+			req := httptest.NewRequest(http.MethodGet, "/.within.website/x/cmd/anubis/api/pass-challenge?"+q.Encode(), nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			rec := httptest.NewRecorder()
+			before := testutil.ToFloat64(scryptComputations)
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("wanted status %d, got: %d", tt.wantStatus, rec.Code)
+			}
+			if got := testutil.ToFloat64(scryptComputations) - before; got != tt.computations {
+				t.Errorf("wanted scrypt to run %v times, got: %v", tt.computations, got)
+			}
+		})
+	}
+}
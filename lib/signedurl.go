@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+var signedURLRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "anubis_signed_url_requests",
+	Help: "The number of requests with a signed URL, by signed URL and whether the signature was accepted",
+}, []string{"name", "result"})
+
+// signedURLFor returns the signed URL settings for r, if r is signed and
+// its path is under the path prefix of one.
+func (s *Server) signedURLFor(r *http.Request) *config.SignedURL {
+	if !r.URL.Query().Has(config.SignedURLSignatureParam) {
+		return nil
+	}
+
+	for _, su := range s.policy.Load().SignedURLs {
+		if su.Matches(r.URL.Path) {
+			return &su
+		}
+	}
+
+	return nil
+}
+
+// serveSignedURL passes a request with a valid signed URL to the target
+// without checking it against the policy. Requests with an invalid or
+// expired signature are denied rather than challenged, the services that
+// send them can't solve challenges anyway.
+func (s *Server) serveSignedURL(w http.ResponseWriter, r *http.Request, su *config.SignedURL) {
+	lg := s.requestLogger(r)
+
+	if err := su.Verify(r.Method, r.URL); err != nil {
+		lg.Info("invalid signed url", "name", su.Name, "err", err)
+		signedURLRequests.WithLabelValues(su.Name, "rejected").Inc()
+		s.respondWithError(w, r, ReasonInvalidURLSignature, "invalid URL signature", http.StatusForbidden)
+		return
+	}
+
+	result := cr("signed-url/"+su.Name, config.RuleAllow)
+	lg.Debug("signed url accepted", "name", su.Name)
+	signedURLRequests.WithLabelValues(su.Name, "accepted").Inc()
+	policy.Applications.WithLabelValues(result.Name, string(result.Rule)).Add(1)
+	s.stats.rule(result)
+
+	// The target doesn't need to know about the signature.
+	q := r.URL.Query()
+	q.Del(config.SignedURLTimestampParam)
+	q.Del(config.SignedURLSignatureParam)
+	r.URL.RawQuery = q.Encode()
+	r.RequestURI = r.URL.RequestURI()
+
+	r.Header.Add("X-Anubis-Rule", result.Name)
+	r.Header.Add("X-Anubis-Action", string(result.Rule))
+	r.Header.Add("X-Anubis-Status", "SIGNED-URL")
+	s.forward(w, r)
+}
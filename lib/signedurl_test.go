@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestSignedURLs(t *testing.T) {
+	su := config.SignedURL{Name: "git", PathPrefix: "/hooks/", Secret: strings.Repeat("s", 32)}
+
+	pol := loadPolicies(t, "")
+	pol.SignedURLs = []config.SignedURL{su}
+
+	srv := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", r.Header.Get("X-Anubis-Rule"), r.URL.RequestURI())
+		}),
+		Policy: pol,
+	})
+
+	signed := su.Sign(http.MethodPost, &url.URL{Path: "/hooks/push", RawQuery: "repo=anubis"}, time.Now())
+	badSignature := *signed
+	badSignature.Path = "/hooks/delete"
+	otherQuery := *signed
+	otherQuery.RawQuery += "&force=true"
+	outside := su.Sign(http.MethodPost, &url.URL{Path: "/admin"}, time.Now())
+
+	for _, tt := range []struct {
+		name       string
+		uri        string
+		wantStatus int
+		wantReason ReasonCode
+		wantBody   string
+	}{
+		{
+			name:       "signed",
+			uri:        signed.RequestURI(),
+			wantStatus: http.StatusOK,
+			wantBody:   "signed-url/git /hooks/push?repo=anubis",
+		},
+		{
+			name:       "bad signature",
+			uri:        badSignature.RequestURI(),
+			wantStatus: http.StatusForbidden,
+			wantReason: ReasonInvalidURLSignature,
+		},
+		{
+			name:       "other query",
+			uri:        otherQuery.RequestURI(),
+			wantStatus: http.StatusForbidden,
+			wantReason: ReasonInvalidURLSignature,
+		},
+		{
+			name:       "unsigned",
+			uri:        "/hooks/push",
+			wantStatus: http.StatusOK,
+			wantReason: ReasonChallengeRequired,
+		},
+		{
+			name:       "outside the path prefix",
+			uri:        outside.RequestURI(),
+			wantStatus: http.StatusOK,
+			wantReason: ReasonChallengeRequired,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.uri, nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.7")
+			req.Header.Set("User-Agent", "Mozilla/5.0 git-hooks")
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("wanted status %d, got: %d", tt.wantStatus, rec.Code)
+			}
+			if got := ReasonCode(rec.Header().Get(ReasonHeader)); got != tt.wantReason {
+				t.Errorf("wanted reason %q, got: %q", tt.wantReason, got)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("wanted body %q, got: %q", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+// topDeniedLimit is how many distinct user agents and IP addresses are
+// counted for the dashboard. When more show up, the least denied one is
+// replaced.
+const topDeniedLimit = 1000
+
+// stats counts what Anubis did since it started, for the status dashboard.
+type stats struct {
+	started time.Time
+
+	lock             sync.Mutex
+	challenges       map[string]uint64
+	rules            map[ruleKey]uint64
+	deniedUserAgents topCounter
+	deniedIPs        topCounter
+}
+
+type ruleKey struct {
+	name   string
+	action config.Rule
+}
+
+func newStats() *stats {
+	return &stats{
+		started:          time.Now(),
+		challenges:       map[string]uint64{},
+		rules:            map[ruleKey]uint64{},
+		deniedUserAgents: topCounter{},
+		deniedIPs:        topCounter{},
+	}
+}
+
+func (st *stats) rule(cr policy.CheckResult) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.rules[ruleKey{cr.Name, cr.Rule}]++
+}
+
+func (st *stats) challenge(result string) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.challenges[result]++
+}
+
+func (st *stats) denied(ip, userAgent string) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.deniedIPs.add(ip)
+	st.deniedUserAgents.add(userAgent)
+}
+
+// topCounter counts at most topDeniedLimit keys. A new key replaces the one
+// with the lowest count and takes over its count, so that keys that are
+// seen often make it to the top even when there are many rare ones.
+type topCounter map[string]uint64
+
+func (tc topCounter) add(key string) {
+	if _, ok := tc[key]; !ok && len(tc) >= topDeniedLimit {
+		var (
+			minKey   string
+			minCount uint64
+			found    bool
+		)
+		for k, n := range tc {
+			if !found || n < minCount {
+				minKey, minCount, found = k, n, true
+			}
+		}
+		delete(tc, minKey)
+		tc[key] = minCount
+	}
+
+	tc[key]++
+}
+
+func (tc topCounter) top(n int) []Count {
+	result := make([]Count, 0, len(tc))
+	for key, count := range tc {
+		result = append(result, Count{Key: key, Count: count})
+	}
+	slices.SortFunc(result, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+
+	return result[:min(n, len(result))]
+}
+
+// Count is how often something was seen.
+type Count struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// RuleHits is how often requests matched a rule.
+type RuleHits struct {
+	Rule   string      `json:"rule"`
+	Action config.Rule `json:"action"`
+	Hits   uint64      `json:"hits"`
+}
+
+// Stats is a snapshot of what Anubis did since it started.
+type Stats struct {
+	Uptime              float64           `json:"uptime"`
+	Challenges          map[string]uint64 `json:"challenges"`
+	Rules               []RuleHits        `json:"rules"`
+	TopDeniedUserAgents []Count           `json:"top_denied_user_agents"`
+	TopDeniedIPs        []Count           `json:"top_denied_ips"`
+	Caches              map[string]int    `json:"caches"`
+	Emergency           bool              `json:"emergency"`
+}
+
+// Stats returns counts of challenges by result, requests by rule and the n
+// most denied user agents and IP addresses since the server started. IP
+// addresses and user agents are anonymized like in the logs.
+func (s *Server) Stats(n int) Stats {
+	st := s.stats
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	result := Stats{
+		Uptime:              time.Since(st.started).Seconds(),
+		Challenges:          map[string]uint64{"issued": 0, "passed": 0, "failed": 0},
+		TopDeniedUserAgents: st.deniedUserAgents.top(n),
+		TopDeniedIPs:        st.deniedIPs.top(n),
+		Caches:              s.CacheSizes(),
+		Emergency:           s.Emergency(),
+	}
+
+	for outcome, count := range st.challenges {
+		result.Challenges[outcome] = count
+	}
+
+	for key, hits := range st.rules {
+		result.Rules = append(result.Rules, RuleHits{Rule: key.name, Action: key.action, Hits: hits})
+	}
+	slices.SortFunc(result.Rules, func(a, b RuleHits) int {
+		return cmp.Or(cmp.Compare(b.Hits, a.Hits), cmp.Compare(a.Rule, b.Rule))
+	})
+
+	return result
+}
+
+// countChallenge records a challenge or CAPTCHA that was issued, passed or
+// failed for rule.
+func (s *Server) countChallenge(rule *policy.Bot, result string) {
+	ruleChallenges.WithLabelValues(rule.Name, result).Inc()
+	s.stats.challenge(result)
+	if result != "issued" {
+		s.recordSolution(result == "passed")
+	}
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+func TestStats(t *testing.T) {
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: bad-bot
+    user_agent_regex: BadBot
+    action: DENY
+`), "stats.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	for i, ua := range []string{"BadBot/1", "BadBot/1", "BadBot/2", "Mozilla/5.0"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", fmt.Sprintf("198.51.100.%d", i%2))
+		req.Header.Set("User-Agent", ua)
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	st := srv.Stats(1)
+
+	if len(st.TopDeniedUserAgents) != 1 || st.TopDeniedUserAgents[0] != (Count{Key: "BadBot/1", Count: 2}) {
+		t.Errorf("wanted BadBot/1 to be the top denied user agent, got: %v", st.TopDeniedUserAgents)
+	}
+	if len(st.TopDeniedIPs) != 1 || st.TopDeniedIPs[0] != (Count{Key: "198.51.100.0", Count: 2}) {
+		t.Errorf("wanted 198.51.100.0 to be the top denied IP address, got: %v", st.TopDeniedIPs)
+	}
+
+	want := []RuleHits{
+		{Rule: "bot/bad-bot", Action: config.RuleDeny, Hits: 3},
+		{Rule: "default/allow", Action: config.RuleAllow, Hits: 1},
+	}
+	if fmt.Sprint(st.Rules) != fmt.Sprint(want) {
+		t.Errorf("wanted rule hits %v, got: %v", want, st.Rules)
+	}
+}
+
+func TestTopCounter(t *testing.T) {
+	tc := topCounter{}
+	for i := range topDeniedLimit {
+		tc.add(fmt.Sprint(i))
+	}
+	tc.add("0")
+	tc.add("new")
+
+	if len(tc) != topDeniedLimit {
+		t.Errorf("wanted at most %d keys, got: %d", topDeniedLimit, len(tc))
+	}
+	if tc["0"] != 2 || tc["new"] != 2 {
+		t.Errorf("wanted the new key to take over the lowest count, got: 0=%d new=%d", tc["0"], tc["new"])
+	}
+}
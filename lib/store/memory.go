@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/vale981/anubis/decaymap"
+)
+
+// Memory is the default store. It keeps everything in memory, so it is not
+// shared between replicas and is lost on restart.
+type Memory struct {
+	data *decaymap.Impl[string, []byte]
+}
+
+// NewMemory creates a new in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		data: decaymap.New[string, []byte](),
+	}
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	val, ok := m.data.Get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return val, nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte, expiry time.Duration) error {
+	m.data.Set(key, value, expiry)
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.data.Delete(key)
+	return nil
+}
+
+// Cleanup removes expired entries.
+func (m *Memory) Cleanup() {
+	m.data.Cleanup()
+}
+
+// Len returns the number of keys in the store, including expired ones that
+// haven't been cleaned up yet.
+func (m *Memory) Len() int {
+	return m.data.Len()
+}
@@ -0,0 +1,65 @@
+// Package store defines where Anubis keeps state that should be shared between
+// replicas, such as cached DNSBL results and Open Graph tags.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned by Get when a key does not exist or has expired.
+var ErrNotFound = errors.New("store: key not found")
+
+// Interface is a key-value store with per-key expiry.
+type Interface interface {
+	// Get returns the value stored at key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value at key until expiry has passed.
+	Set(ctx context.Context, key string, value []byte, expiry time.Duration) error
+
+	// Delete removes key if it exists.
+	Delete(ctx context.Context, key string) error
+}
+
+// JSON stores values of type T in an Interface by encoding them as JSON.
+// Every key is prefixed with Prefix so that several JSON stores can share
+// one backend.
+type JSON[T any] struct {
+	Underlying Interface
+	Prefix     string
+}
+
+// Get returns the value stored at key, or ErrNotFound.
+func (j *JSON[T]) Get(ctx context.Context, key string) (T, error) {
+	var result T
+
+	data, err := j.Underlying.Get(ctx, j.Prefix+key)
+	if err != nil {
+		return result, err
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("store: can't decode %s: %w", j.Prefix+key, err)
+	}
+
+	return result, nil
+}
+
+// Set stores value at key until expiry has passed.
+func (j *JSON[T]) Set(ctx context.Context, key string, value T, expiry time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("store: can't encode %s: %w", j.Prefix+key, err)
+	}
+
+	return j.Underlying.Set(ctx, j.Prefix+key, data, expiry)
+}
+
+// Delete removes key if it exists.
+func (j *JSON[T]) Delete(ctx context.Context, key string) error {
+	return j.Underlying.Delete(ctx, j.Prefix+key)
+}
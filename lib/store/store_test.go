@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJSONMemory(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	st := &JSON[map[string]string]{Underlying: mem, Prefix: "test:"}
+
+	if _, err := st.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted ErrNotFound, got: %v", err)
+	}
+
+	want := map[string]string{"og:title": "Anubis"}
+	if err := st.Set(ctx, "page", want, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mem.Get(ctx, "test:page"); err != nil {
+		t.Errorf("value was not stored under the prefixed key: %v", err)
+	}
+
+	got, err := st.Get(ctx, "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got["og:title"] != want["og:title"] {
+		t.Errorf("wanted %v, got: %v", want, got)
+	}
+
+	if err := st.Delete(ctx, "page"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Get(ctx, "page"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted ErrNotFound after delete, got: %v", err)
+	}
+
+	if err := st.Set(ctx, "expired", want, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Get(ctx, "expired"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted ErrNotFound for expired key, got: %v", err)
+	}
+}
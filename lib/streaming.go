@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isStreamingRequest reports whether r opens a WebSocket or Server-Sent
+// Events stream. Browsers open these from scripts that can't show the
+// challenge page, so they are told to solve a challenge the same way API
+// clients are.
+func isStreamingRequest(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+// tarpitBody is what held requests get, a byte at a time. It looks like the
+// start of a page that never finishes loading.
+const tarpitBody = "<!doctype html>\n<html>\n<head>\n<title>Loading</title>\n</head>\n<body>\n<p>\n"
+
+var (
+	tarpitConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_tarpit_connections",
+		Help: "The number of requests currently held by TARPIT rules",
+	})
+
+	tarpitRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_tarpit_requests",
+		Help: "The number of requests matched by TARPIT rules, by whether they were held or denied because too many were held already",
+	}, []string{"result"})
+)
+
+// tarpit holds a request matched by a TARPIT rule, sending a few bytes at a
+// time until the tarpit duration is over or the client gives up. If too many
+// requests are held already, the request is denied instead, so that
+// scrapers can't tie up Anubis itself.
+func (s *Server) tarpit(w http.ResponseWriter, r *http.Request, rule *policy.Bot) {
+	lg := s.requestLogger(r)
+	settings := s.policy.Load().Tarpit
+	hash := rule.Hash()
+
+	s.recordReputation(r, "denied", reputationDenied)
+	s.recordDenied(r, "tarpit", rule.Name, hash)
+	s.ClearCookie(w)
+
+	if s.tarpits.Add(1) > int64(settings.Connections()) {
+		s.tarpits.Add(-1)
+		lg.Info("too many requests in the tarpit, denying instead", "max_connections", settings.Connections())
+		tarpitRequests.WithLabelValues("denied").Inc()
+		s.respondWithError(w, r, ReasonRuleDenied, localization.ForRequest(r).T("access_denied", hash), http.StatusOK)
+		return
+	}
+	defer s.tarpits.Add(-1)
+
+	tarpitRequests.WithLabelValues("held").Inc()
+	tarpitConnections.Inc()
+	defer tarpitConnections.Dec()
+
+	lg.Info("holding request in the tarpit", "duration", settings.HoldDuration())
+
+	rc := http.NewResponseController(w)
+	// The server's write timeout would cut the request short.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(max(time.Duration(float64(time.Second)/settings.BytesPerSecond()), time.Millisecond))
+	defer ticker.Stop()
+	deadline := time.NewTimer(settings.HoldDuration())
+	defer deadline.Stop()
+
+	for i := 0; ; i++ {
+		if _, err := w.Write([]byte{tarpitBody[i%len(tarpitBody)]}); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
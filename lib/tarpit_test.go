@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func spawnTarpit(t *testing.T, tarpit string) (*Server, *httptest.Server) {
+	t.Helper()
+
+	pol, err := policy.ParseConfig(strings.NewReader(tarpit+`
+bots:
+  - name: scraper
+    user_agent_regex: Scraper
+    action: TARPIT
+`), "tarpit.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   http.NewServeMux(),
+		Policy: pol,
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Real-Ip", "198.51.100.1")
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return srv, ts
+}
+
+func scrape(t *testing.T, u string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "Scraper/1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(body)
+}
+
+func TestTarpit(t *testing.T) {
+	_, ts := spawnTarpit(t, "tarpit:\n  rate: 100\n  duration: 200ms\n")
+
+	start := time.Now()
+	resp, body := scrape(t, ts.URL)
+	took := time.Since(start)
+
+	if took < 200*time.Millisecond {
+		t.Errorf("wanted the request to be held for the tarpit duration, took: %s", took)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wanted status 200, got: %d", resp.StatusCode)
+	}
+	if resp.Header.Get(ReasonHeader) != "" {
+		t.Errorf("wanted no reason code, got: %s", resp.Header.Get(ReasonHeader))
+	}
+	if len(body) == 0 || len(body) > 40 || !strings.HasPrefix(tarpitBody+tarpitBody, body) {
+		t.Errorf("wanted a few bytes of the tarpit body, got %d bytes: %q", len(body), body)
+	}
+}
+
+func TestTarpitMaxConnections(t *testing.T) {
+	srv, ts := spawnTarpit(t, "tarpit:\n  duration: 1s\n  max_connections: 1\n")
+
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		scrape(t, ts.URL)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.tarpits.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the first request was never held")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	resp, _ := scrape(t, ts.URL)
+	if got := resp.Header.Get(ReasonHeader); got != string(ReasonRuleDenied) {
+		t.Errorf("wanted requests beyond max_connections to be denied, got reason: %q", got)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("wanted requests beyond max_connections to be denied right away, took: %s", took)
+	}
+
+	<-held
+}
@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans for Anubis. Unless tracing is set up with
+// internal.SetupTracing, its spans go nowhere and cost next to nothing.
+var tracer = otel.Tracer("github.com/vale981/anubis/lib")
+
+// startSpan starts a span named name as a child of the span in r's context
+// and returns r with the new span's context.
+func startSpan(r *http.Request, name string, opts ...trace.SpanStartOption) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(r.Context(), name, opts...)
+	return r.WithContext(ctx), span
+}
+
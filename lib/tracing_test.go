@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	oldTracer, oldPropagator := tracer, otel.GetTextMapPropagator()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		tracer = oldTracer
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    path_regex: .*
+    action: ALLOW
+`), "tracing.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var upstreamParent string
+	s := spawnAnubis(t, Options{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamParent = r.Header.Get("Traceparent")
+		}),
+		Policy: pol,
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	for _, name := range []string{"MaybeReverseProxy", "proxy"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("wanted a %s span, got: %v", name, spans)
+		}
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("%s span should continue the incoming trace %s, got: %s", name, traceID, got)
+		}
+	}
+
+	if want := spans["proxy"].SpanContext().SpanID().String(); !strings.Contains(upstreamParent, want) {
+		t.Errorf("wanted the target to get traceparent with span %s, got: %q", want, upstreamParent)
+	}
+}
@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/localization"
+	"github.com/vale981/anubis/web"
+)
+
+const (
+	// defaultUpstreamHealthTimeout is how long a check of the target may
+	// take if Options.UpstreamHealthTimeout is not set.
+	defaultUpstreamHealthTimeout = 5 * time.Second
+
+	// upstreamFailureThreshold is how many checks of the target in a row
+	// must fail before it counts as down, so that a single slow response
+	// doesn't take the whole site offline.
+	upstreamFailureThreshold = 2
+
+	// defaultUpstreamRetryAfter is how long clients are asked to wait
+	// before trying again if the target isn't checked regularly.
+	defaultUpstreamRetryAfter = 10 * time.Second
+)
+
+var (
+	upstreamHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anubis_upstream_healthy",
+		Help: "Whether the last checks of the target found it up",
+	})
+
+	upstreamChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anubis_upstream_health_checks",
+		Help: "The total number of checks of the target, by result",
+	}, []string{"result"})
+
+	upstreamErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anubis_upstream_errors",
+		Help: "The total number of requests that couldn't be sent to the upstream",
+	})
+)
+
+// UpstreamHealth is the result of the last check of the target.
+type UpstreamHealth struct {
+	Healthy bool      `json:"healthy"`
+	Checked time.Time `json:"checked"`
+	Error   string    `json:"error,omitempty"`
+
+	// Failures is how many checks in a row have failed.
+	Failures int `json:"failures,omitempty"`
+}
+
+// UpstreamHealth returns the result of the last check of the target, or nil
+// if it was never checked.
+func (s *Server) UpstreamHealth() *UpstreamHealth {
+	return s.upstream.Load()
+}
+
+// upstreamDown reports whether the last checks of the target found it down.
+func (s *Server) upstreamDown() bool {
+	health := s.upstream.Load()
+	return health != nil && !health.Healthy
+}
+
+// WatchUpstream checks the target every Options.UpstreamHealthInterval until
+// ctx is done. While the target is down, requests for it get a page saying
+// so instead of waiting for it to time out.
+func (s *Server) WatchUpstream(ctx context.Context) {
+	t := time.NewTicker(s.opts.UpstreamHealthInterval)
+	defer t.Stop()
+
+	for {
+		s.CheckUpstream(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// probeErrorKey is the context key of where the error of a check of the
+// target is put by the reverse proxy's error handler.
+type probeErrorKey struct{}
+
+// probeWriter is a response writer that only keeps the status code.
+type probeWriter struct {
+	header http.Header
+	status int
+}
+
+func (pw *probeWriter) Header() http.Header { return pw.header }
+
+func (pw *probeWriter) Write(p []byte) (int, error) {
+	if pw.status == 0 {
+		pw.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (pw *probeWriter) WriteHeader(status int) {
+	if pw.status == 0 {
+		pw.status = status
+	}
+}
+
+// CheckUpstream requests Options.UpstreamHealthPath from the target and
+// records whether it answered without a server error.
+func (s *Server) CheckUpstream(ctx context.Context) *UpstreamHealth {
+	timeout := s.opts.UpstreamHealthTimeout
+	if timeout <= 0 {
+		timeout = defaultUpstreamHealthTimeout
+	}
+	path := s.opts.UpstreamHealthPath
+	if path == "" {
+		path = "/"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var proxyErr error
+	ctx = context.WithValue(ctx, probeErrorKey{}, &proxyErr)
+
+	var err error
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if reqErr != nil {
+		err = reqErr
+	} else {
+		req.Header.Set("User-Agent", "Anubis-Health-Check/"+anubis.Version)
+
+		pw := &probeWriter{header: http.Header{}}
+		s.next.ServeHTTP(pw, req)
+
+		switch {
+		case proxyErr != nil:
+			err = proxyErr
+		case pw.status >= http.StatusInternalServerError:
+			err = fmt.Errorf("status %d", pw.status)
+		}
+	}
+
+	return s.recordUpstreamCheck(err)
+}
+
+// recordUpstreamCheck records the result of a check of the target.
+func (s *Server) recordUpstreamCheck(err error) *UpstreamHealth {
+	health := &UpstreamHealth{Healthy: true, Checked: time.Now()}
+
+	if err != nil {
+		upstreamChecks.WithLabelValues("failed").Inc()
+		health.Error = err.Error()
+		health.Failures = 1
+		if prev := s.upstream.Load(); prev != nil {
+			health.Failures += prev.Failures
+		}
+		health.Healthy = health.Failures < upstreamFailureThreshold
+	} else {
+		upstreamChecks.WithLabelValues("passed").Inc()
+	}
+
+	prev := s.upstream.Swap(health)
+	switch {
+	case !health.Healthy && (prev == nil || prev.Healthy):
+		slog.Warn("upstream is down", "err", err)
+	case health.Healthy && prev != nil && !prev.Healthy:
+		slog.Info("upstream is up again")
+	}
+
+	if health.Healthy {
+		upstreamHealthy.Set(1)
+	} else {
+		upstreamHealthy.Set(0)
+	}
+
+	return health
+}
+
+// handleUpstreamErrors makes h, if it is a reverse proxy or pool of them
+// without its own error handler, show the upstream unavailable page when it
+// can't reach its target.
+func (s *Server) handleUpstreamErrors(h http.Handler) {
+	if eh := errorHandlerOf(h); eh != nil && *eh == nil {
+		*eh = s.upstreamError
+	}
+}
+
+// upstreamError handles a request that couldn't be sent to the upstream.
+func (s *Server) upstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	if probeErr, ok := r.Context().Value(probeErrorKey{}).(*error); ok {
+		*probeErr = err
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		// The client went away, there is nobody to tell.
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	upstreamErrors.Inc()
+	s.requestLogger(r).Error("can't reach upstream", "err", err)
+	s.respondUpstreamUnavailable(w, r, http.StatusBadGateway)
+}
+
+// respondUpstreamUnavailable tells a client that the upstream can't be
+// reached and when it is worth trying again.
+func (s *Server) respondUpstreamUnavailable(w http.ResponseWriter, r *http.Request, status int) {
+	retryAfter := s.opts.UpstreamHealthInterval
+	if retryAfter <= 0 {
+		retryAfter = defaultUpstreamRetryAfter
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Cache-Control", "no-store")
+
+	loc := localization.ForRequest(r)
+	message := loc.T("upstream_unavailable", seconds)
+	if isGRPCRequest(r) || s.wantsJSON(r) {
+		s.respondWithError(w, r, ReasonUpstreamUnavailable, message, status)
+		return
+	}
+
+	// Browsers load the page again by themselves once it is worth trying.
+	w.Header().Set("Refresh", strconv.Itoa(seconds))
+	w.Header().Set(ReasonHeader, string(ReasonUpstreamUnavailable))
+	templ.Handler(
+		web.Base(loc.T("be_right_back"), web.ErrorPage(message, s.opts.WebmasterEmail)),
+		templ.WithStatus(status),
+	).ServeHTTP(w, r)
+}
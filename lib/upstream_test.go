@@ -0,0 +1,211 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy"
+)
+
+func allowAllPolicy(t *testing.T) *policy.ParsedConfig {
+	t.Helper()
+
+	pol, err := policy.ParseConfig(strings.NewReader(`
+bots:
+  - name: everyone
+    user_agent_regex: .*
+    action: ALLOW
+`), "allow.yaml", anubis.DefaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pol
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	var broken atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() {
+			http.Error(w, "database is on fire", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	rp, err := NewReverseProxy(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:                   rp,
+		Policy:                 allowAllPolicy(t),
+		UpstreamHealthPath:     "/healthz",
+		UpstreamHealthInterval: time.Minute,
+	})
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-Ip", "198.51.100.1")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if health := srv.CheckUpstream(context.Background()); !health.Healthy {
+		t.Fatalf("wanted the upstream to be up, got: %+v", health)
+	}
+
+	broken.Store(true)
+	if health := srv.CheckUpstream(context.Background()); !health.Healthy || health.Failures != 1 {
+		t.Errorf("wanted one failed check not to count as down, got: %+v", health)
+	}
+	if health := srv.CheckUpstream(context.Background()); health.Healthy || health.Error != "status 500" {
+		t.Errorf("wanted two failed checks to count as down, got: %+v", health)
+	}
+
+	rec := do()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("wanted status %d while the upstream is down, got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if got := rec.Header().Get(ReasonHeader); got != string(ReasonUpstreamUnavailable) {
+		t.Errorf("wanted reason %s, got: %q", ReasonUpstreamUnavailable, got)
+	}
+	if rec.Header().Get("Retry-After") != "60" || rec.Header().Get("Refresh") != "60" {
+		t.Errorf("wanted to be told to come back in a minute, got headers: %v", rec.Header())
+	}
+
+	broken.Store(false)
+	if health := srv.CheckUpstream(context.Background()); !health.Healthy || health.Failures != 0 {
+		t.Errorf("wanted the upstream to be up again, got: %+v", health)
+	}
+	if rec := do(); rec.Code != http.StatusOK {
+		t.Errorf("wanted status %d once the upstream is up, got: %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestUpstreamUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	for name, target := range map[string]string{
+		"proxy": upstream.URL,
+		"pool":  upstream.URL + "," + upstream.URL,
+	} {
+		t.Run(name, func(t *testing.T) {
+			next, err := NewUpstream(target, UpstreamPoolOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv := spawnAnubis(t, Options{
+				Next:   next,
+				Policy: allowAllPolicy(t),
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-Ip", "198.51.100.1")
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("wanted status %d, got: %d", http.StatusBadGateway, rec.Code)
+			}
+			if got := rec.Header().Get(ReasonHeader); got != string(ReasonUpstreamUnavailable) {
+				t.Errorf("wanted reason %s, got: %q", ReasonUpstreamUnavailable, got)
+			}
+			if !strings.Contains(rec.Body.String(), "unavailable right now") {
+				t.Errorf("wanted the upstream unavailable page, got: %s", rec.Body.String())
+			}
+
+			req.Header.Set("Accept", "application/json")
+			rec = httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if !strings.Contains(rec.Body.String(), `"code":"UPSTREAM_UNAVAILABLE"`) {
+				t.Errorf("wanted a JSON error, got: %s", rec.Body.String())
+			}
+
+			if health := srv.CheckUpstream(context.Background()); health.Error == "" {
+				t.Errorf("wanted the check to fail with the proxy's error, got: %+v", health)
+			}
+		})
+	}
+}
+
+func TestProxyOptions(t *testing.T) {
+	transport, err := ProxyOptions{
+		ResponseHeaderTimeout: time.Second,
+		MaxIdleConnsPerHost:   32,
+		ReadBufferSize:        8192,
+	}.transport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.ResponseHeaderTimeout != time.Second || transport.MaxIdleConnsPerHost != 32 || transport.ReadBufferSize != 8192 {
+		t.Errorf("wanted the options set on the transport, got: %+v", transport)
+	}
+	if def := http.DefaultTransport.(*http.Transport); transport.IdleConnTimeout != def.IdleConnTimeout {
+		t.Errorf("wanted unset options to keep the defaults, got: %v", transport.IdleConnTimeout)
+	}
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	rp, err := NewReverseProxyWithOptions(upstream.URL, ProxyOptions{ResponseHeaderTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := spawnAnubis(t, Options{
+		Next:   rp,
+		Policy: allowAllPolicy(t),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Real-Ip", "198.51.100.1")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("wanted a slow target to time out with status %d, got: %d", http.StatusBadGateway, rec.Code)
+	}
+}
+
+func TestProxyOptionsEgressProxy(t *testing.T) {
+	// The target's name only resolves through the proxy, like an onion
+	// service.
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	if _, err := NewReverseProxyWithOptions("http://example.onion", ProxyOptions{Proxy: "ftp://proxy.invalid"}); err == nil {
+		t.Error("wanted an unsupported proxy scheme to fail")
+	}
+
+	rp, err := NewReverseProxyWithOptions("http://example.onion", ProxyOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	rp.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.onion/hello", nil))
+
+	if rec.Code != http.StatusOK || proxied != "http://example.onion/hello" {
+		t.Errorf("wanted the request sent through the proxy, got status %d for %q", rec.Code, proxied)
+	}
+}
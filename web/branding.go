@@ -0,0 +1,95 @@
+package web
+
+import (
+	"context"
+	"strings"
+
+	"github.com/a-h/templ"
+
+	"github.com/vale981/anubis"
+	"github.com/vale981/anubis/lib/policy/config"
+)
+
+type brandingKey struct{}
+
+// WithBranding returns a context that makes pages rendered with it use b.
+func WithBranding(ctx context.Context, b *config.Branding) context.Context {
+	return context.WithValue(ctx, brandingKey{}, b)
+}
+
+// brandingFrom returns the branding pages should be rendered with. Pages
+// without one use an empty branding, which looks like stock Anubis.
+func brandingFrom(ctx context.Context) *config.Branding {
+	if b, ok := ctx.Value(brandingKey{}).(*config.Branding); ok && b != nil {
+		return b
+	}
+
+	return &config.Branding{}
+}
+
+// mascotURLs returns the URLs of the mascot images by mood.
+func mascotURLs(ctx context.Context) map[string]string {
+	result := map[string]string{}
+	for _, mood := range []string{"pensive", "happy", "reject"} {
+		result[mood] = anubis.StaticPath + "static/img/" + mood + ".webp?cacheBuster=" + anubis.Version
+	}
+
+	if m := brandingFrom(ctx).Mascot; m != nil {
+		for mood, u := range map[string]string{"pensive": m.Pensive, "happy": m.Happy, "reject": m.Reject} {
+			if u != "" {
+				result[mood] = u
+			}
+		}
+	}
+
+	return result
+}
+
+// mascotURL returns the URL of the mascot image for mood.
+func mascotURL(ctx context.Context, mood string) string {
+	return mascotURLs(ctx)[mood]
+}
+
+// pageTitle is the title of the browser window for a page titled title.
+func pageTitle(ctx context.Context, title string) string {
+	if org := brandingFrom(ctx).Organization; org != "" {
+		return title + " - " + org
+	}
+
+	return title
+}
+
+// brandingStyle overrides the colors of the default stylesheet with the
+// ones of the branding. The colors were checked to be plain CSS colors when
+// the policy was loaded.
+func brandingStyle(ctx context.Context) templ.Component {
+	c := brandingFrom(ctx).Colors
+	if c == nil {
+		return templ.NopComponent
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<style>:root{")
+	for _, v := range []struct {
+		color string
+		vars  []string
+	}{
+		{c.Background, []string{"--light-background", "--dark-background", "--light-link-background", "--dark-link-background"}},
+		{c.Text, []string{"--light-text", "--dark-text"}},
+		{c.Link, []string{"--light-link-foreground", "--dark-link-foreground"}},
+		{c.Accent, []string{"--progress-bar-fill", "--light-text-selection", "--dark-text-selection"}},
+	} {
+		if v.color == "" {
+			continue
+		}
+		for _, name := range v.vars {
+			sb.WriteString(name + ":" + v.color + ";")
+		}
+	}
+	if c.Accent != "" {
+		sb.WriteString("--progress-bar-outline:" + c.Accent + " solid 4px;")
+	}
+	sb.WriteString("}</style>")
+
+	return templ.Raw(sb.String())
+}
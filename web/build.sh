@@ -37,4 +37,7 @@ gzip -f -k static/js/main.mjs
 zstd -f -k --ultra -22 static/js/main.mjs
 brotli -fZk static/js/main.mjs
 
-esbuild js/bench.mjs --sourcemap --bundle --minify --outfile=static/js/bench.mjs
\ No newline at end of file
+esbuild js/bench.mjs --sourcemap --bundle --minify --outfile=static/js/bench.mjs
+gzip -f -k static/js/bench.mjs
+zstd -f -k --ultra -22 static/js/bench.mjs
+brotli -fZk static/js/bench.mjs
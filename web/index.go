@@ -1,13 +1,22 @@
 package web
 
 import (
+	"strings"
+
 	"github.com/a-h/templ"
 
 	"github.com/vale981/anubis/lib/policy/config"
 )
 
 func Base(title string, body templ.Component) templ.Component {
-	return base(title, body, nil, nil)
+	return base(title, body, nil, nil, "")
+}
+
+// BaseWithRefreshAndOGTags renders body in a page that sends the browser to
+// another page without JavaScript. refresh is the content of the refresh meta
+// tag, such as "5; url=/next".
+func BaseWithRefreshAndOGTags(title string, body templ.Component, refresh string, ogTags map[string]string) templ.Component {
+	return base(title, body, nil, ogTags, refresh)
 }
 
 func BaseWithChallengeAndOGTags(title string, body templ.Component, challenge string, rules *config.ChallengeRules, ogTags map[string]string) (templ.Component, error) {
@@ -17,13 +26,21 @@ func BaseWithChallengeAndOGTags(title string, body templ.Component, challenge st
 	}{
 		Challenge: challenge,
 		Rules:     rules,
-	}, ogTags), nil
+	}, ogTags, ""), nil
 }
 
 func Index() templ.Component {
 	return index()
 }
 
+func NoJSIndex(passURL string, wait int) templ.Component {
+	return noJSIndex(passURL, wait)
+}
+
+func Captcha(scriptURL, widgetClass, siteKey, passURL, redir string) templ.Component {
+	return captcha(scriptURL, widgetClass, siteKey, passURL, redir)
+}
+
 func ErrorPage(msg string, mail string) templ.Component {
 	return errorPage(msg, mail)
 }
@@ -31,3 +48,16 @@ func ErrorPage(msg string, mail string) templ.Component {
 func Bench() templ.Component {
 	return bench()
 }
+
+// usesProperty reports whether the meta tag for key is written with the
+// property attribute, as the Open Graph protocol wants, rather than with name,
+// as Twitter Cards and plain HTML meta tags do.
+func usesProperty(key string) bool {
+	for _, prefix := range []string{"og:", "fb:", "article:", "book:", "profile:", "music:", "video:"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
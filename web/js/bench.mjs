@@ -1,10 +1,12 @@
 import processFast from "./proof-of-work.mjs";
 import processSlow from "./proof-of-work-slow.mjs";
+import processScrypt from "./proof-of-work-scrypt.mjs";
 
 const defaultDifficulty = 4;
 const algorithms = {
   fast: processFast,
   slow: processSlow,
+  scrypt: processScrypt,
 };
 
 const status = document.getElementById("status");
@@ -0,0 +1,41 @@
+// Runs the proof-of-work solver against an unreachable difficulty for a short
+// amount of time to estimate how many hashes per second this device can do.
+export async function measureHashRate(process, threads, duration = 500) {
+  const controller = new AbortController();
+  let iters = 0;
+
+  const t0 = performance.now();
+  const timer = setTimeout(() => controller.abort(), duration);
+  try {
+    await process("anubis-calibration", 64, controller.signal, (n) => {
+      iters = n;
+    }, threads);
+  } catch (_) {
+    // aborting the solver rejects the promise, this is expected
+  } finally {
+    clearTimeout(timer);
+  }
+  const elapsed = (performance.now() - t0) / 1000;
+
+  return iters / elapsed;
+}
+
+// Mirrors effectiveDifficulty in lib/calibration.go. Lowers the difficulty one
+// step at a time (but never below min_difficulty) until the expected solve time
+// fits in target_solve_seconds.
+export function effectiveDifficulty(rules, hashRate) {
+  let difficulty = rules.difficulty;
+  if (!rules.min_difficulty || !(hashRate > 0)) {
+    return difficulty;
+  }
+
+  const target = rules.target_solve_seconds || 10;
+  while (
+    difficulty > rules.min_difficulty &&
+    Math.pow(16, difficulty) / hashRate > target
+  ) {
+    difficulty--;
+  }
+
+  return difficulty;
+}
@@ -1,10 +1,13 @@
 import processFast from "./proof-of-work.mjs";
 import processSlow from "./proof-of-work-slow.mjs";
+import processScrypt from "./proof-of-work-scrypt.mjs";
 import { testVideo } from "./video.mjs";
+import { effectiveDifficulty, measureHashRate } from "./calibrate.mjs";
 
 const algorithms = {
   "fast": processFast,
   "slow": processSlow,
+  "scrypt": processScrypt,
 };
 
 // from Xeact
@@ -14,23 +17,33 @@ const u = (url = "", params = {}) => {
   return result.toString();
 };
 
+// The policy can replace the mascot images with its own.
+const mascot = JSON.parse(document.getElementById('anubis_mascot')?.textContent ?? "{}");
 const imageURL = (mood, cacheBuster) =>
-  u(`/.within.website/x/cmd/anubis/static/img/${mood}.webp`, { cacheBuster });
+  mascot[mood] ?? u(`/.within.website/x/cmd/anubis/static/img/${mood}.webp`, { cacheBuster });
+
+// Messages in the visitor's language, rendered into the page by Anubis. Each
+// %s or %d in a message is replaced with the next argument.
+const messages = JSON.parse(document.getElementById('anubis_messages')?.textContent ?? "{}");
+const t = (id, ...args) => {
+  let i = 0;
+  return (messages[id] ?? id).replace(/%[sd]/g, () => args[i++]);
+};
 
 const dependencies = [
   {
     name: "WebCrypto",
-    msg: "Your browser doesn't have a functioning web.crypto element. Are you viewing this over a secure context?",
+    msg: t("missing_webcrypto"),
     value: window.crypto,
   },
   {
     name: "Web Workers",
-    msg: "Your browser doesn't support web workers (Anubis uses this to avoid freezing your browser). Do you have a plugin like JShelter installed?",
+    msg: t("missing_web_workers"),
     value: window.Worker,
   },
 ];
 
-function showContinueBar(hash, nonce, t0, t1) {
+function showContinueBar(challenge, hash, nonce, t0, t1) {
   const barContainer = document.createElement("div");
   barContainer.style.marginTop = "1rem";
   barContainer.style.width = "100%";
@@ -42,7 +55,7 @@ function showContinueBar(hash, nonce, t0, t1) {
   barContainer.style.height = "2rem";
   barContainer.style.marginLeft = "auto";
   barContainer.style.marginRight = "auto";
-  barContainer.title = "Click to continue";
+  barContainer.title = t("finished_reading");
 
   const barInner = document.createElement("div");
   barInner.className = "bar-inner";
@@ -53,7 +66,7 @@ function showContinueBar(hash, nonce, t0, t1) {
   barInner.style.fontWeight = "bold";
   barInner.style.height = "100%";
   barInner.style.width = "0";
-  barInner.innerText = "I've finished reading, continue →";
+  barInner.innerText = t("finished_reading");
 
   barContainer.appendChild(barInner);
   document.body.appendChild(barContainer);
@@ -66,6 +79,7 @@ function showContinueBar(hash, nonce, t0, t1) {
     const redir = window.location.href;
     window.location.replace(
       u("/.within.website/x/cmd/anubis/api/pass-challenge", {
+        challenge,
         response: hash,
         nonce,
         redir,
@@ -101,8 +115,8 @@ function showContinueBar(hash, nonce, t0, t1) {
 
   if (!window.isSecureContext) {
     ohNoes({
-      titleMsg: "Your context is not secure!",
-      statusMsg: `Try connecting over HTTPS or let the admin know to set up HTTPS. For more information, see <a href="https://developer.mozilla.org/en-US/docs/Web/Security/Secure_Contexts#when_is_a_context_considered_secure">MDN</a>.`,
+      titleMsg: t("context_not_secure"),
+      statusMsg: t("context_not_secure_msg"),
       imageSrc: imageURL("reject", anubisVersion),
     });
     return;
@@ -121,12 +135,12 @@ function showContinueBar(hash, nonce, t0, t1) {
   //   return;
   // }
 
-  status.innerHTML = 'Calculating...';
+  status.innerHTML = t("calculating");
 
   for (const { value, name, msg } of dependencies) {
     if (!value) {
       ohNoes({
-        titleMsg: `Missing feature ${name}`,
+        titleMsg: t("missing_feature", name),
         statusMsg: msg,
         imageSrc: imageURL("reject", anubisVersion),
       });
@@ -138,20 +152,35 @@ function showContinueBar(hash, nonce, t0, t1) {
   const process = algorithms[rules.algorithm];
   if (!process) {
     ohNoes({
-      titleMsg: "Challenge error!",
-      statusMsg: `Failed to resolve check algorithm. You may want to reload the page.`,
+      titleMsg: t("challenge_error"),
+      statusMsg: t("challenge_error_msg"),
       imageSrc: imageURL("reject", anubisVersion),
     });
     return;
   }
 
-  status.innerHTML = `Calculating...<br/>Difficulty: ${rules.report_as}, `;
+  // The policy can limit how many workers the solver uses, down to a single
+  // one for rules where visitors' battery life matters more than speed.
+  const cores = navigator.hardwareConcurrency || 1;
+  const threads = rules.max_threads ? Math.min(rules.max_threads, cores) : cores;
+
+  // Slow devices can get an easier challenge if the policy allows it. Only the
+  // fast algorithm reports progress often enough to be measured.
+  let hashRate = 0;
+  if (rules.min_difficulty && rules.algorithm === "fast") {
+    status.innerHTML = t("calibrating");
+    hashRate = await measureHashRate(process, threads);
+    console.log({ hashRate });
+  }
+  const difficulty = effectiveDifficulty(rules, hashRate);
+
+  status.innerHTML = `${t("calculating")}<br/>${t("difficulty", rules.report_as)}`;
   progress.style.display = "inline-block";
 
   // the whole text, including "Speed:", as a single node, because some browsers
   // (Firefox mobile) present screen readers with each node as a separate piece
   // of text.
-  const rateText = document.createTextNode("Speed: 0kH/s");
+  const rateText = document.createTextNode(t("speed", 0));
   status.appendChild(rateText);
 
   let lastSpeedUpdate = 0;
@@ -162,14 +191,14 @@ function showContinueBar(hash, nonce, t0, t1) {
     const t0 = Date.now();
     const { hash, nonce } = await process(
       challenge,
-      rules.difficulty,
+      difficulty,
       null,
       (iters) => {
         const delta = Date.now() - t0;
         // only update the speed every second so it's less visually distracting
         if (delta - lastSpeedUpdate > 1000) {
           lastSpeedUpdate = delta;
-          rateText.data = `Speed: ${(iters / delta).toFixed(3)}kH/s`;
+          rateText.data = t("speed", (iters / delta).toFixed(3));
         }
         // the probability of still being on the page is (1 - likelihood) ^ iters.
         // by definition, half of the time the progress bar only gets to half, so
@@ -185,19 +214,18 @@ function showContinueBar(hash, nonce, t0, t1) {
         if (probability < 0.1 && !showingApology) {
           status.append(
             document.createElement("br"),
-            document.createTextNode(
-              "Verification is taking longer than expected. Please do not refresh the page.",
-            ),
+            document.createTextNode(t("taking_longer")),
           );
           showingApology = true;
         }
       },
+      threads,
     );
     const t1 = Date.now();
     console.log({ hash, nonce });
 
-    title.innerHTML = "Success!";
-    status.innerHTML = `Done! Took ${t1 - t0}ms, ${nonce} iterations`;
+    title.innerHTML = t("success");
+    status.innerHTML = t("done_took", t1 - t0, nonce);
     image.src = imageURL("happy", anubisVersion);
     progress.style.display = "none";
 
@@ -218,16 +246,18 @@ function showContinueBar(hash, nonce, t0, t1) {
       container.style.outlineOffset = "2px";
       container.style.width = "min(20rem, 90%)";
       container.style.margin = "1rem auto 2rem";
-      container.innerHTML = "I've finished reading, continue →";
+      container.innerHTML = t("finished_reading");
 
       function onDetailsExpand() {
         const redir = window.location.href;
         window.location.replace(
           u("/.within.website/x/cmd/anubis/api/pass-challenge", {
+            challenge,
             response: hash,
             nonce,
             redir,
-            elapsedTime: t1 - t0
+            elapsedTime: t1 - t0,
+            hashRate,
           }),
         );
       }
@@ -240,10 +270,12 @@ function showContinueBar(hash, nonce, t0, t1) {
         const redir = window.location.href;
         window.location.replace(
           u("/.within.website/x/cmd/anubis/api/pass-challenge", {
+            challenge,
             response: hash,
             nonce,
             redir,
-            elapsedTime: t1 - t0
+            elapsedTime: t1 - t0,
+            hashRate,
           }),
         );
       }, 250);
@@ -251,8 +283,8 @@ function showContinueBar(hash, nonce, t0, t1) {
 
   } catch (err) {
     ohNoes({
-      titleMsg: "Calculation error!",
-      statusMsg: `Failed to calculate challenge: ${err.message}`,
+      titleMsg: t("calculation_error"),
+      statusMsg: t("calculation_error_msg", err.message),
       imageSrc: imageURL("reject", anubisVersion),
     });
   }
@@ -0,0 +1,200 @@
+// Memory-hard proof of work. Every attempt runs scrypt, which needs several
+// megabytes of memory, so GPUs and ASICs can't run many attempts in parallel
+// the way they can with plain SHA-256. The parameters must match the ones in
+// lib/scrypt.go.
+
+export default function process(
+  data,
+  difficulty = 2,
+  signal = null,
+  progressCallback = null,
+  threads = (navigator.hardwareConcurrency || 1),
+) {
+  console.debug("scrypt algo");
+  return new Promise((resolve, reject) => {
+    let webWorkerURL = URL.createObjectURL(new Blob([
+      '(', processTask(), ')()'
+    ], { type: 'application/javascript' }));
+
+    const workers = [];
+    const terminate = () => {
+      workers.forEach((w) => w.terminate());
+      if (signal != null) {
+        // clean up listener to avoid memory leak
+        signal.removeEventListener("abort", terminate);
+        if (signal.aborted) {
+          console.log("PoW aborted");
+          reject(false);
+        }
+      }
+    };
+    if (signal != null) {
+      signal.addEventListener("abort", terminate, { once: true });
+    }
+
+    for (let i = 0; i < threads; i++) {
+      let worker = new Worker(webWorkerURL);
+
+      worker.onmessage = (event) => {
+        if (typeof event.data === "number") {
+          progressCallback?.(event.data);
+        } else {
+          terminate();
+          resolve(event.data);
+        }
+      };
+
+      worker.onerror = (event) => {
+        terminate();
+        reject(event);
+      };
+
+      worker.postMessage({
+        data,
+        difficulty,
+        nonce: i,
+        threads,
+      });
+
+      workers.push(worker);
+    }
+
+    URL.revokeObjectURL(webWorkerURL);
+  });
+}
+
+function processTask() {
+  return function () {
+    const N = 1 << 12;
+    const r = 8;
+    const keyLength = 32;
+
+    const encoder = new TextEncoder();
+
+    const pbkdf2 = async (password, salt, length) => {
+      const key = await crypto.subtle.importKey("raw", password, "PBKDF2", false, ["deriveBits"]);
+      const bits = await crypto.subtle.deriveBits(
+        { name: "PBKDF2", salt, iterations: 1, hash: "SHA-256" },
+        key,
+        length * 8,
+      );
+      return new Uint8Array(bits);
+    };
+
+    const rotl = (a, b) => (a << b) | (a >>> (32 - b));
+
+    const salsa = new Uint32Array(16);
+    const salsa20_8 = (B) => {
+      const x = salsa;
+      x.set(B);
+      for (let i = 0; i < 8; i += 2) {
+        x[4] ^= rotl(x[0] + x[12], 7); x[8] ^= rotl(x[4] + x[0], 9);
+        x[12] ^= rotl(x[8] + x[4], 13); x[0] ^= rotl(x[12] + x[8], 18);
+        x[9] ^= rotl(x[5] + x[1], 7); x[13] ^= rotl(x[9] + x[5], 9);
+        x[1] ^= rotl(x[13] + x[9], 13); x[5] ^= rotl(x[1] + x[13], 18);
+        x[14] ^= rotl(x[10] + x[6], 7); x[2] ^= rotl(x[14] + x[10], 9);
+        x[6] ^= rotl(x[2] + x[14], 13); x[10] ^= rotl(x[6] + x[2], 18);
+        x[3] ^= rotl(x[15] + x[11], 7); x[7] ^= rotl(x[3] + x[15], 9);
+        x[11] ^= rotl(x[7] + x[3], 13); x[15] ^= rotl(x[11] + x[7], 18);
+        x[1] ^= rotl(x[0] + x[3], 7); x[2] ^= rotl(x[1] + x[0], 9);
+        x[3] ^= rotl(x[2] + x[1], 13); x[0] ^= rotl(x[3] + x[2], 18);
+        x[6] ^= rotl(x[5] + x[4], 7); x[7] ^= rotl(x[6] + x[5], 9);
+        x[4] ^= rotl(x[7] + x[6], 13); x[5] ^= rotl(x[4] + x[7], 18);
+        x[11] ^= rotl(x[10] + x[9], 7); x[8] ^= rotl(x[11] + x[10], 9);
+        x[9] ^= rotl(x[8] + x[11], 13); x[10] ^= rotl(x[9] + x[8], 18);
+        x[12] ^= rotl(x[15] + x[14], 7); x[13] ^= rotl(x[12] + x[15], 9);
+        x[14] ^= rotl(x[13] + x[12], 13); x[15] ^= rotl(x[14] + x[13], 18);
+      }
+      for (let i = 0; i < 16; i++) {
+        B[i] = (B[i] + x[i]) | 0;
+      }
+    };
+
+    const blockX = new Uint32Array(16);
+    const blockY = new Uint32Array(32 * r);
+    const blockMix = (B) => {
+      blockX.set(B.subarray((2 * r - 1) * 16, 2 * r * 16));
+      for (let i = 0; i < 2 * r; i++) {
+        for (let j = 0; j < 16; j++) {
+          blockX[j] ^= B[i * 16 + j];
+        }
+        salsa20_8(blockX);
+        // even blocks go to the first half, odd blocks to the second
+        blockY.set(blockX, ((i & 1) * r + (i >> 1)) * 16);
+      }
+      B.set(blockY);
+    };
+
+    const V = new Uint32Array(32 * r * N);
+    const X = new Uint32Array(32 * r);
+    const scrypt = async (password, salt) => {
+      const B = await pbkdf2(password, salt, 128 * r);
+      const view = new DataView(B.buffer);
+
+      for (let i = 0; i < X.length; i++) {
+        X[i] = view.getUint32(i * 4, true);
+      }
+
+      for (let i = 0; i < N; i++) {
+        V.set(X, i * 32 * r);
+        blockMix(X);
+      }
+
+      for (let i = 0; i < N; i++) {
+        const j = X[(2 * r - 1) * 16] & (N - 1);
+        for (let k = 0; k < X.length; k++) {
+          X[k] ^= V[j * 32 * r + k];
+        }
+        blockMix(X);
+      }
+
+      for (let i = 0; i < X.length; i++) {
+        view.setUint32(i * 4, X[i], true);
+      }
+
+      return pbkdf2(password, B, keyLength);
+    };
+
+    function uint8ArrayToHexString(arr) {
+      return Array.from(arr)
+        .map((c) => c.toString(16).padStart(2, "0"))
+        .join("");
+    }
+
+    addEventListener('message', async (event) => {
+      let data = event.data.data;
+      let difficulty = event.data.difficulty;
+      let nonce = event.data.nonce;
+      let threads = event.data.threads;
+
+      const salt = encoder.encode(data);
+      const prefix = Array(difficulty + 1).join('0');
+
+      let hash;
+      let attempts = 0;
+      while (true) {
+        hash = uint8ArrayToHexString(await scrypt(encoder.encode(data + nonce), salt));
+        // Exactly as many leading zeroes as the difficulty, so that the
+        // server can tell which difficulty was solved from the response
+        // and only has to run scrypt once to check it.
+        if (hash.startsWith(prefix) && hash[difficulty] !== "0") {
+          break;
+        }
+
+        nonce += threads;
+
+        // every attempt takes a while, so report progress often
+        if (++attempts % 4 === 0) {
+          postMessage(nonce);
+        }
+      }
+
+      postMessage({
+        hash,
+        data,
+        difficulty,
+        nonce,
+      });
+    });
+  }.toString();
+}
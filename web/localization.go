@@ -0,0 +1,41 @@
+package web
+
+import (
+	"context"
+
+	"github.com/a-h/templ"
+
+	"github.com/vale981/anubis/lib/localization"
+)
+
+// scriptMessages are the messages main.mjs shows while it solves a challenge.
+var scriptMessages = []string{
+	"calculating",
+	"calibrating",
+	"difficulty",
+	"speed",
+	"taking_longer",
+	"success",
+	"done_took",
+	"finished_reading",
+	"context_not_secure",
+	"context_not_secure_msg",
+	"missing_feature",
+	"missing_webcrypto",
+	"missing_web_workers",
+	"challenge_error",
+	"challenge_error_msg",
+	"calculation_error",
+	"calculation_error_msg",
+}
+
+// t returns the message with the given ID in the language of the page being
+// rendered.
+func t(ctx context.Context, id string, args ...any) string {
+	return localization.FromContext(ctx).T(id, args...)
+}
+
+// tHTML is t for messages that contain markup, such as links.
+func tHTML(ctx context.Context, id string) templ.Component {
+	return templ.Raw(t(ctx, id))
+}
@@ -1,2 +1,2 @@
-(()=>{function k(n,t=5,e=null,o=null,a=navigator.hardwareConcurrency||1){return console.debug("fast algo"),new Promise((s,r)=>{let l=URL.createObjectURL(new Blob(["(",C(),")()"],{type:"application/javascript"})),d=[],c=()=>{d.forEach(i=>i.terminate()),e!=null&&(e.removeEventListener("abort",c),e.aborted&&(console.log("PoW aborted"),r(!1)))};e?.addEventListener("abort",c,{once:!0});for(let i=0;i<a;i++){let u=new Worker(l);u.onmessage=p=>{typeof p.data=="number"?o?.(p.data):(c(),s(p.data))},u.onerror=p=>{c(),r(p)},u.postMessage({data:n,difficulty:t,nonce:i,threads:a}),d.push(u)}URL.revokeObjectURL(l)})}function C(){return function(){let n=e=>{let o=new TextEncoder().encode(e);return crypto.subtle.digest("SHA-256",o.buffer)};function t(e){return Array.from(e).map(o=>o.toString(16).padStart(2,"0")).join("")}addEventListener("message",async e=>{let o=e.data.data,a=e.data.difficulty,s,r=e.data.nonce,l=e.data.threads,d=r;for(;;){let c=await n(o+r),i=new Uint8Array(c),u=!0;for(let h=0;h<a;h++){let B=Math.floor(h/2),j=h%2;if((i[B]>>(j===0?4:0)&15)!==0){u=!1;break}}if(u){s=t(i),console.log(s);break}let p=r;r+=l,r>p|1023&&(r>>10)%l===d&&postMessage(r)}postMessage({hash:s,data:o,difficulty:a,nonce:r})})}.toString()}function v(n,t=5,e=null,o=null,a=1){return console.debug("slow algo"),new Promise((s,r)=>{let l=URL.createObjectURL(new Blob(["(",M(),")()"],{type:"application/javascript"})),d=new Worker(l),c=()=>{d.terminate(),e!=null&&(e.removeEventListener("abort",c),e.aborted&&(console.log("PoW aborted"),r(!1)))};e?.addEventListener("abort",c,{once:!0}),d.onmessage=i=>{typeof i.data=="number"?o?.(i.data):(c(),s(i.data))},d.onerror=i=>{c(),r(i)},d.postMessage({data:n,difficulty:t}),URL.revokeObjectURL(l)})}function M(){return function(){let n=t=>{let e=new TextEncoder().encode(t);return crypto.subtle.digest("SHA-256",e.buffer).then(o=>Array.from(new Uint8Array(o)).map(a=>a.toString(16).padStart(2,"0")).join(""))};addEventListener("message",async t=>{let e=t.data.data,o=t.data.difficulty,a,s=0;do s&!1&&postMessage(s),a=await n(e+s++);while(a.substring(0,o)!==Array(o+1).join("0"));s-=1,postMessage({hash:a,data:e,difficulty:o,nonce:s})})}.toString()}var N=4,H={fast:k,slow:v},f=document.getElementById("status"),x=document.getElementById("difficulty-input"),T=document.getElementById("algorithm-select"),E=document.getElementById("compare-select"),S=document.getElementById("table-header"),U=document.getElementById("table-header-compare"),m=document.getElementById("results"),F=()=>{x.value=N;for(let n of Object.keys(H)){let t=document.createElement("option");T.append(t);let e=document.createElement("option");E.append(e),t.value=t.innerText=e.value=e.innerText=n}},A=async(n,t,e,o)=>{if(!(t>=1))throw new Error(`Invalid difficulty: ${t}`);let a=H[e];if(a==null)throw new Error(`Unknown algorithm: ${e}`);let s=new Uint8Array(32);crypto.getRandomValues(s);let r=Array.from(s).map(u=>u.toString(16).padStart(2,"0")).join(""),l=performance.now(),{hash:d,nonce:c}=await a(r,Number(t),o),i=performance.now();return console.log({hash:d,nonce:c}),n.time+=i-l,n.iters+=c,{time:i-l,nonce:c}},g={time:0,iters:0},y={time:0,iters:0},R=()=>{let n=g.iters/g.time,t=y.iters/y.time;if(Number.isFinite(n)){if(f.innerText=`Average hashrate: ${n.toFixed(3)}kH/s`,Number.isFinite(t)){let e=(n-t)/n*100;f.innerText+=` vs ${t.toFixed(3)}kH/s (${e.toFixed(2)}% change)`}}else f.innerText="Benchmarking..."},b=n=>{let t=document.createElement("td");return t.innerText=n,t.style.padding="0 0.25rem",t},I=async n=>{let t=x.value,e=T.value,o=E.value;R();try{let{time:a,nonce:s}=await A(g,t,e,n.signal),r=document.createElement("tr");r.style.display="contents",r.append(b(`${a}ms`),b(s));let l=m.scrollHeight-m.clientHeight<=m.scrollTop;if(m.append(r),l&&(m.scrollTop=m.scrollHeight-m.clientHeight),R(),o!=="NONE"){let{time:d,nonce:c}=await A(y,t,o,n.signal);r.append(b(`${d}ms`),b(c))}}catch(a){a!==!1&&(f.innerText=a);return}I(n)},w=null,L=()=>{g.time=g.iters=0,y.time=y.iters=0,m.innerHTML=f.innerText="";let n=m.parentElement;E.value!=="NONE"?(n.style.gridTemplateColumns="repeat(4,auto)",S.style.display="none",U.style.display="contents"):(n.style.gridTemplateColumns="repeat(2,auto)",S.style.display="contents",U.style.display="none"),w?.abort(),w=new AbortController,I(w)};F();x.addEventListener("change",L);T.addEventListener("change",L);E.addEventListener("change",L);L();})();
+(()=>{function H(r,o=5,n=null,a=null,s=navigator.hardwareConcurrency||1){return console.debug("fast algo"),new Promise((t,c)=>{let y=URL.createObjectURL(new Blob(["(",K(),")()"],{type:"application/javascript"})),p=[],l=()=>{p.forEach(i=>i.terminate()),n!=null&&(n.removeEventListener("abort",l),n.aborted&&(console.log("PoW aborted"),c(!1)))};n?.addEventListener("abort",l,{once:!0});for(let i=0;i<s;i++){let g=new Worker(y);g.onmessage=d=>{typeof d.data=="number"?a?.(d.data):(l(),t(d.data))},g.onerror=d=>{l(),c(d)},g.postMessage({data:r,difficulty:o,nonce:i,threads:s}),p.push(g)}URL.revokeObjectURL(y)})}function K(){return function(){let r=n=>{let a=new TextEncoder().encode(n);return crypto.subtle.digest("SHA-256",a.buffer)};function o(n){return Array.from(n).map(a=>a.toString(16).padStart(2,"0")).join("")}addEventListener("message",async n=>{let a=n.data.data,s=n.data.difficulty,t,c=n.data.nonce,y=n.data.threads,p=c;for(;;){let l=await r(a+c),i=new Uint8Array(l),g=!0;for(let w=0;w<s;w++){let R=Math.floor(w/2),u=w%2;if((i[R]>>(u===0?4:0)&15)!==0){g=!1;break}}if(g){t=o(i),console.log(t);break}let d=c;c+=y,c>d|1023&&(c>>10)%y===p&&postMessage(c)}postMessage({hash:t,data:a,difficulty:s,nonce:c})})}.toString()}function B(r,o=5,n=null,a=null,s=1){return console.debug("slow algo"),new Promise((t,c)=>{let y=URL.createObjectURL(new Blob(["(",V(),")()"],{type:"application/javascript"})),p=new Worker(y),l=()=>{p.terminate(),n!=null&&(n.removeEventListener("abort",l),n.aborted&&(console.log("PoW aborted"),c(!1)))};n?.addEventListener("abort",l,{once:!0}),p.onmessage=i=>{typeof i.data=="number"?a?.(i.data):(l(),t(i.data))},p.onerror=i=>{l(),c(i)},p.postMessage({data:r,difficulty:o}),URL.revokeObjectURL(y)})}function V(){return function(){let r=o=>{let n=new TextEncoder().encode(o);return crypto.subtle.digest("SHA-256",n.buffer).then(a=>Array.from(new Uint8Array(a)).map(s=>s.toString(16).padStart(2,"0")).join(""))};addEventListener("message",async o=>{let n=o.data.data,a=o.data.difficulty,s,t=0;do t&!1&&postMessage(t),s=await r(n+t++);while(s.substring(0,a)!==Array(a+1).join("0"));t-=1,postMessage({hash:s,data:n,difficulty:a,nonce:t})})}.toString()}function N(r,o=2,n=null,a=null,s=navigator.hardwareConcurrency||1){return console.debug("scrypt algo"),new Promise((t,c)=>{let y=URL.createObjectURL(new Blob(["(",X(),")()"],{type:"application/javascript"})),p=[],l=()=>{p.forEach(i=>i.terminate()),n!=null&&(n.removeEventListener("abort",l),n.aborted&&(console.log("PoW aborted"),c(!1)))};n?.addEventListener("abort",l,{once:!0});for(let i=0;i<s;i++){let g=new Worker(y);g.onmessage=d=>{typeof d.data=="number"?a?.(d.data):(l(),t(d.data))},g.onerror=d=>{l(),c(d)},g.postMessage({data:r,difficulty:o,nonce:i,threads:s}),p.push(g)}URL.revokeObjectURL(y)})}function X(){return function(){let a=new TextEncoder,s=async(u,e,f)=>{let b=await crypto.subtle.importKey("raw",u,"PBKDF2",!1,["deriveBits"]),m=await crypto.subtle.deriveBits({name:"PBKDF2",salt:e,iterations:1,hash:"SHA-256"},b,f*8);return new Uint8Array(m)},t=(u,e)=>u<<e|u>>>32-e,c=new Uint32Array(16),y=u=>{let e=c;e.set(u);for(let f=0;f<8;f+=2)e[4]^=t(e[0]+e[12],7),e[8]^=t(e[4]+e[0],9),e[12]^=t(e[8]+e[4],13),e[0]^=t(e[12]+e[8],18),e[9]^=t(e[5]+e[1],7),e[13]^=t(e[9]+e[5],9),e[1]^=t(e[13]+e[9],13),e[5]^=t(e[1]+e[13],18),e[14]^=t(e[10]+e[6],7),e[2]^=t(e[14]+e[10],9),e[6]^=t(e[2]+e[14],13),e[10]^=t(e[6]+e[2],18),e[3]^=t(e[15]+e[11],7),e[7]^=t(e[3]+e[15],9),e[11]^=t(e[7]+e[3],13),e[15]^=t(e[11]+e[7],18),e[1]^=t(e[0]+e[3],7),e[2]^=t(e[1]+e[0],9),e[3]^=t(e[2]+e[1],13),e[0]^=t(e[3]+e[2],18),e[6]^=t(e[5]+e[4],7),e[7]^=t(e[6]+e[5],9),e[4]^=t(e[7]+e[6],13),e[5]^=t(e[4]+e[7],18),e[11]^=t(e[10]+e[9],7),e[8]^=t(e[11]+e[10],9),e[9]^=t(e[8]+e[11],13),e[10]^=t(e[9]+e[8],18),e[12]^=t(e[15]+e[14],7),e[13]^=t(e[12]+e[15],9),e[14]^=t(e[13]+e[12],13),e[15]^=t(e[14]+e[13],18);for(let f=0;f<16;f++)u[f]=u[f]+e[f]|0},p=new Uint32Array(16),l=new Uint32Array(32*8),i=u=>{p.set(u.subarray((2*8-1)*16,2*8*16));for(let e=0;e<2*8;e++){for(let f=0;f<16;f++)p[f]^=u[e*16+f];y(p),l.set(p,((e&1)*8+(e>>1))*16)}u.set(l)},g=new Uint32Array(32*8*4096),d=new Uint32Array(32*8),w=async(u,e)=>{let f=await s(u,e,1024),b=new DataView(f.buffer);for(let m=0;m<d.length;m++)d[m]=b.getUint32(m*4,!0);for(let m=0;m<4096;m++)g.set(d,m*32*8),i(d);for(let m=0;m<4096;m++){let j=d[240]&4095;for(let k=0;k<d.length;k++)d[k]^=g[j*32*8+k];i(d)}for(let m=0;m<d.length;m++)b.setUint32(m*4,d[m],!0);return s(u,f,32)};function R(u){return Array.from(u).map(e=>e.toString(16).padStart(2,"0")).join("")}addEventListener("message",async u=>{let e=u.data.data,f=u.data.difficulty,b=u.data.nonce,m=u.data.threads,j=a.encode(e),k=Array(f+1).join("0"),x,D=0;for(;x=R(await w(a.encode(e+b),j)),!(x.startsWith(k)&&x[f]!=="0");)b+=m,++D%4===0&&postMessage(b);postMessage({hash:x,data:e,difficulty:f,nonce:b})})}.toString()}var _=4,P={fast:H,slow:B,scrypt:N},E=document.getElementById("status"),I=document.getElementById("difficulty-input"),M=document.getElementById("algorithm-select"),T=document.getElementById("compare-select"),W=document.getElementById("table-header"),C=document.getElementById("table-header-compare"),h=document.getElementById("results"),Y=()=>{I.value=_;for(let r of Object.keys(P)){let o=document.createElement("option");M.append(o);let n=document.createElement("option");T.append(n),o.value=o.innerText=n.value=n.innerText=r}},F=async(r,o,n,a)=>{if(!(o>=1))throw new Error(`Invalid difficulty: ${o}`);let s=P[n];if(s==null)throw new Error(`Unknown algorithm: ${n}`);let t=new Uint8Array(32);crypto.getRandomValues(t);let c=Array.from(t).map(g=>g.toString(16).padStart(2,"0")).join(""),y=performance.now(),{hash:p,nonce:l}=await s(c,Number(o),a),i=performance.now();return console.log({hash:p,nonce:l}),r.time+=i-y,r.iters+=l,{time:i-y,nonce:l}},L={time:0,iters:0},U={time:0,iters:0},O=()=>{let r=L.iters/L.time,o=U.iters/U.time;if(Number.isFinite(r)){if(E.innerText=`Average hashrate: ${r.toFixed(3)}kH/s`,Number.isFinite(o)){let n=(r-o)/r*100;E.innerText+=` vs ${o.toFixed(3)}kH/s (${n.toFixed(2)}% change)`}}else E.innerText="Benchmarking..."},v=r=>{let o=document.createElement("td");return o.innerText=r,o.style.padding="0 0.25rem",o},$=async r=>{let o=I.value,n=M.value,a=T.value;O();try{let{time:s,nonce:t}=await F(L,o,n,r.signal),c=document.createElement("tr");c.style.display="contents",c.append(v(`${s}ms`),v(t));let y=h.scrollHeight-h.clientHeight<=h.scrollTop;if(h.append(c),y&&(h.scrollTop=h.scrollHeight-h.clientHeight),O(),a!=="NONE"){let{time:p,nonce:l}=await F(U,o,a,r.signal);c.append(v(`${p}ms`),v(l))}}catch(s){s!==!1&&(E.innerText=s);return}$(r)},A=null,S=()=>{L.time=L.iters=0,U.time=U.iters=0,h.innerHTML=E.innerText="";let r=h.parentElement;T.value!=="NONE"?(r.style.gridTemplateColumns="repeat(4,auto)",W.style.display="none",C.style.display="contents"):(r.style.gridTemplateColumns="repeat(2,auto)",W.style.display="contents",C.style.display="none"),A?.abort(),A=new AbortController,$(A)};Y();I.addEventListener("change",S);M.addEventListener("change",S);T.addEventListener("change",S);S();})();
 //# sourceMappingURL=bench.mjs.map
@@ -25,5 +25,5 @@ THE SOFTWARE.
 @licend  The above is the entire license notice
 for the JavaScript code in this page.
 */
-(()=>{function S(s,r=5,e=null,t=null,n=navigator.hardwareConcurrency||1){return console.debug("fast algo"),new Promise((i,a)=>{let d=URL.createObjectURL(new Blob(["(",E(),")()"],{type:"application/javascript"})),m=[],c=()=>{m.forEach(l=>l.terminate()),e!=null&&(e.removeEventListener("abort",c),e.aborted&&(console.log("PoW aborted"),a(!1)))};e?.addEventListener("abort",c,{once:!0});for(let l=0;l<n;l++){let p=new Worker(d);p.onmessage=g=>{typeof g.data=="number"?t?.(g.data):(c(),i(g.data))},p.onerror=g=>{c(),a(g)},p.postMessage({data:s,difficulty:r,nonce:l,threads:n}),m.push(p)}URL.revokeObjectURL(d)})}function E(){return function(){let s=e=>{let t=new TextEncoder().encode(e);return crypto.subtle.digest("SHA-256",t.buffer)};function r(e){return Array.from(e).map(t=>t.toString(16).padStart(2,"0")).join("")}addEventListener("message",async e=>{let t=e.data.data,n=e.data.difficulty,i,a=e.data.nonce,d=e.data.threads,m=a;for(;;){let c=await s(t+a),l=new Uint8Array(c),p=!0;for(let h=0;h<n;h++){let k=Math.floor(h/2),u=h%2;if((l[k]>>(u===0?4:0)&15)!==0){p=!1;break}}if(p){i=r(l),console.log(i);break}let g=a;a+=d,a>g|1023&&(a>>10)%d===m&&postMessage(a)}postMessage({hash:i,data:t,difficulty:n,nonce:a})})}.toString()}function T(s,r=5,e=null,t=null,n=1){return console.debug("slow algo"),new Promise((i,a)=>{let d=URL.createObjectURL(new Blob(["(",C(),")()"],{type:"application/javascript"})),m=new Worker(d),c=()=>{m.terminate(),e!=null&&(e.removeEventListener("abort",c),e.aborted&&(console.log("PoW aborted"),a(!1)))};e?.addEventListener("abort",c,{once:!0}),m.onmessage=l=>{typeof l.data=="number"?t?.(l.data):(c(),i(l.data))},m.onerror=l=>{c(),a(l)},m.postMessage({data:s,difficulty:r}),URL.revokeObjectURL(d)})}function C(){return function(){let s=r=>{let e=new TextEncoder().encode(r);return crypto.subtle.digest("SHA-256",e.buffer).then(t=>Array.from(new Uint8Array(t)).map(n=>n.toString(16).padStart(2,"0")).join(""))};addEventListener("message",async r=>{let e=r.data.data,t=r.data.difficulty,n,i=0;do i&!1&&postMessage(i),n=await s(e+i++);while(n.substring(0,t)!==Array(t+1).join("0"));i-=1,postMessage({hash:n,data:e,difficulty:t,nonce:i})})}.toString()}var H={fast:S,slow:T},L=(s="",r={})=>{let e=new URL(s,window.location.href);return Object.entries(r).forEach(([t,n])=>e.searchParams.set(t,n)),e.toString()},b=(s,r)=>L(`/.within.website/x/cmd/anubis/static/img/${s}.webp`,{cacheBuster:r}),I=[{name:"WebCrypto",msg:"Your browser doesn't have a functioning web.crypto element. Are you viewing this over a secure context?",value:window.crypto},{name:"Web Workers",msg:"Your browser doesn't support web workers (Anubis uses this to avoid freezing your browser). Do you have a plugin like JShelter installed?",value:window.Worker}];(async()=>{let s=document.getElementById("status"),r=document.getElementById("image"),e=document.getElementById("title"),t=document.getElementById("progress"),n=JSON.parse(document.getElementById("anubis_version").textContent),i=document.querySelector("details"),a=!1;i&&i.addEventListener("toggle",()=>{i.open&&(a=!0)});let d=({titleMsg:u,statusMsg:y,imageSrc:f})=>{e.innerHTML=u,s.innerHTML=y,r.src=f,t.style.display="none"};if(!window.isSecureContext){d({titleMsg:"Your context is not secure!",statusMsg:'Try connecting over HTTPS or let the admin know to set up HTTPS. For more information, see <a href="https://developer.mozilla.org/en-US/docs/Web/Security/Secure_Contexts#when_is_a_context_considered_secure">MDN</a>.',imageSrc:b("reject",n)});return}s.innerHTML="Calculating...";for(let{value:u,name:y,msg:f}of I)u||d({titleMsg:`Missing feature ${y}`,statusMsg:f,imageSrc:b("reject",n)});let{challenge:m,rules:c}=JSON.parse(document.getElementById("anubis_challenge").textContent),l=H[c.algorithm];if(!l){d({titleMsg:"Challenge error!",statusMsg:"Failed to resolve check algorithm. You may want to reload the page.",imageSrc:b("reject",n)});return}s.innerHTML=`Calculating...<br/>Difficulty: ${c.report_as}, `,t.style.display="inline-block";let p=document.createTextNode("Speed: 0kH/s");s.appendChild(p);let g=0,h=!1,k=Math.pow(16,-c.report_as);try{let u=Date.now(),{hash:y,nonce:f}=await l(m,c.difficulty,null,o=>{let w=Date.now()-u;w-g>1e3&&(g=w,p.data=`Speed: ${(o/w).toFixed(3)}kH/s`);let x=Math.pow(1-k,o),M=(1-Math.pow(x,2))*100;t["aria-valuenow"]=M,t.firstElementChild.style.width=`${M}%`,x<.1&&!h&&(s.append(document.createElement("br"),document.createTextNode("Verification is taking longer than expected. Please do not refresh the page.")),h=!0)}),v=Date.now();if(console.log({hash:y,nonce:f}),e.innerHTML="Success!",s.innerHTML=`Done! Took ${v-u}ms, ${f} iterations`,r.src=b("happy",n),t.style.display="none",a){let w=function(){let x=window.location.href;window.location.replace(L("/.within.website/x/cmd/anubis/api/pass-challenge",{response:y,nonce:f,redir:x,elapsedTime:v-u}))},o=document.getElementById("progress");o.style.display="flex",o.style.alignItems="center",o.style.justifyContent="center",o.style.height="2rem",o.style.borderRadius="1rem",o.style.cursor="pointer",o.style.background="#b16286",o.style.color="white",o.style.fontWeight="bold",o.style.outline="4px solid #b16286",o.style.outlineOffset="2px",o.style.width="min(20rem, 90%)",o.style.margin="1rem auto 2rem",o.innerHTML="I've finished reading, continue \u2192",o.onclick=w,setTimeout(w,3e4)}else setTimeout(()=>{let o=window.location.href;window.location.replace(L("/.within.website/x/cmd/anubis/api/pass-challenge",{response:y,nonce:f,redir:o,elapsedTime:v-u}))},250)}catch(u){d({titleMsg:"Calculation error!",statusMsg:`Failed to calculate challenge: ${u.message}`,imageSrc:b("reject",n)})}})();})();
+(()=>{function S(r,c=5,n=null,o=null,a=navigator.hardwareConcurrency||1){return console.debug("fast algo"),new Promise((t,m)=>{let g=URL.createObjectURL(new Blob(["(",W(),")()"],{type:"application/javascript"})),p=[],i=()=>{p.forEach(l=>l.terminate()),n!=null&&(n.removeEventListener("abort",i),n.aborted&&(console.log("PoW aborted"),m(!1)))};n?.addEventListener("abort",i,{once:!0});for(let l=0;l<a;l++){let h=new Worker(g);h.onmessage=d=>{typeof d.data=="number"?o?.(d.data):(i(),t(d.data))},h.onerror=d=>{i(),m(d)},h.postMessage({data:r,difficulty:c,nonce:l,threads:a}),p.push(h)}URL.revokeObjectURL(g)})}function W(){return function(){let r=n=>{let o=new TextEncoder().encode(n);return crypto.subtle.digest("SHA-256",o.buffer)};function c(n){return Array.from(n).map(o=>o.toString(16).padStart(2,"0")).join("")}addEventListener("message",async n=>{let o=n.data.data,a=n.data.difficulty,t,m=n.data.nonce,g=n.data.threads,p=m;for(;;){let i=await r(o+m),l=new Uint8Array(i),h=!0;for(let x=0;x<a;x++){let E=Math.floor(x/2),u=x%2;if((l[E]>>(u===0?4:0)&15)!==0){h=!1;break}}if(h){t=c(l),console.log(t);break}let d=m;m+=g,m>d|1023&&(m>>10)%g===p&&postMessage(m)}postMessage({hash:t,data:o,difficulty:a,nonce:m})})}.toString()}function U(r,c=5,n=null,o=null,a=1){return console.debug("slow algo"),new Promise((t,m)=>{let g=URL.createObjectURL(new Blob(["(",I(),")()"],{type:"application/javascript"})),p=new Worker(g),i=()=>{p.terminate(),n!=null&&(n.removeEventListener("abort",i),n.aborted&&(console.log("PoW aborted"),m(!1)))};n?.addEventListener("abort",i,{once:!0}),p.onmessage=l=>{typeof l.data=="number"?o?.(l.data):(i(),t(l.data))},p.onerror=l=>{i(),m(l)},p.postMessage({data:r,difficulty:c}),URL.revokeObjectURL(g)})}function I(){return function(){let r=c=>{let n=new TextEncoder().encode(c);return crypto.subtle.digest("SHA-256",n.buffer).then(o=>Array.from(new Uint8Array(o)).map(a=>a.toString(16).padStart(2,"0")).join(""))};addEventListener("message",async c=>{let n=c.data.data,o=c.data.difficulty,a,t=0;do t&!1&&postMessage(t),a=await r(n+t++);while(a.substring(0,o)!==Array(o+1).join("0"));t-=1,postMessage({hash:a,data:n,difficulty:o,nonce:t})})}.toString()}function R(r,c=2,n=null,o=null,a=navigator.hardwareConcurrency||1){return console.debug("scrypt algo"),new Promise((t,m)=>{let g=URL.createObjectURL(new Blob(["(",B(),")()"],{type:"application/javascript"})),p=[],i=()=>{p.forEach(l=>l.terminate()),n!=null&&(n.removeEventListener("abort",i),n.aborted&&(console.log("PoW aborted"),m(!1)))};n?.addEventListener("abort",i,{once:!0});for(let l=0;l<a;l++){let h=new Worker(g);h.onmessage=d=>{typeof d.data=="number"?o?.(d.data):(i(),t(d.data))},h.onerror=d=>{i(),m(d)},h.postMessage({data:r,difficulty:c,nonce:l,threads:a}),p.push(h)}URL.revokeObjectURL(g)})}function B(){return function(){let o=new TextEncoder,a=async(u,e,f)=>{let k=await crypto.subtle.importKey("raw",u,"PBKDF2",!1,["deriveBits"]),s=await crypto.subtle.deriveBits({name:"PBKDF2",salt:e,iterations:1,hash:"SHA-256"},k,f*8);return new Uint8Array(s)},t=(u,e)=>u<<e|u>>>32-e,m=new Uint32Array(16),g=u=>{let e=m;e.set(u);for(let f=0;f<8;f+=2)e[4]^=t(e[0]+e[12],7),e[8]^=t(e[4]+e[0],9),e[12]^=t(e[8]+e[4],13),e[0]^=t(e[12]+e[8],18),e[9]^=t(e[5]+e[1],7),e[13]^=t(e[9]+e[5],9),e[1]^=t(e[13]+e[9],13),e[5]^=t(e[1]+e[13],18),e[14]^=t(e[10]+e[6],7),e[2]^=t(e[14]+e[10],9),e[6]^=t(e[2]+e[14],13),e[10]^=t(e[6]+e[2],18),e[3]^=t(e[15]+e[11],7),e[7]^=t(e[3]+e[15],9),e[11]^=t(e[7]+e[3],13),e[15]^=t(e[11]+e[7],18),e[1]^=t(e[0]+e[3],7),e[2]^=t(e[1]+e[0],9),e[3]^=t(e[2]+e[1],13),e[0]^=t(e[3]+e[2],18),e[6]^=t(e[5]+e[4],7),e[7]^=t(e[6]+e[5],9),e[4]^=t(e[7]+e[6],13),e[5]^=t(e[4]+e[7],18),e[11]^=t(e[10]+e[9],7),e[8]^=t(e[11]+e[10],9),e[9]^=t(e[8]+e[11],13),e[10]^=t(e[9]+e[8],18),e[12]^=t(e[15]+e[14],7),e[13]^=t(e[12]+e[15],9),e[14]^=t(e[13]+e[12],13),e[15]^=t(e[14]+e[13],18);for(let f=0;f<16;f++)u[f]=u[f]+e[f]|0},p=new Uint32Array(16),i=new Uint32Array(32*8),l=u=>{p.set(u.subarray((2*8-1)*16,2*8*16));for(let e=0;e<2*8;e++){for(let f=0;f<16;f++)p[f]^=u[e*16+f];g(p),i.set(p,((e&1)*8+(e>>1))*16)}u.set(i)},h=new Uint32Array(32*8*4096),d=new Uint32Array(32*8),x=async(u,e)=>{let f=await a(u,e,1024),k=new DataView(f.buffer);for(let s=0;s<d.length;s++)d[s]=k.getUint32(s*4,!0);for(let s=0;s<4096;s++)h.set(d,s*32*8),l(d);for(let s=0;s<4096;s++){let L=d[240]&4095;for(let b=0;b<d.length;b++)d[b]^=h[L*32*8+b];l(d)}for(let s=0;s<d.length;s++)k.setUint32(s*4,d[s],!0);return a(u,f,32)};function E(u){return Array.from(u).map(e=>e.toString(16).padStart(2,"0")).join("")}addEventListener("message",async u=>{let e=u.data.data,f=u.data.difficulty,k=u.data.nonce,s=u.data.threads,L=o.encode(e),b=Array(f+1).join("0"),_,y=0;for(;_=E(await x(o.encode(e+k),L)),!(_.startsWith(b)&&_[f]!=="0");)k+=s,++y%4===0&&postMessage(k);postMessage({hash:_,data:e,difficulty:f,nonce:k})})}.toString()}async function C(r,c,n=500){let o=new AbortController,a=0,t=performance.now(),m=setTimeout(()=>o.abort(),n);try{await r("anubis-calibration",64,o.signal,p=>{a=p},c)}catch{}finally{clearTimeout(m)}let g=(performance.now()-t)/1e3;return a/g}function H(r,c){let n=r.difficulty;if(!r.min_difficulty||!(c>0))return n;let o=r.target_solve_seconds||10;for(;n>r.min_difficulty&&Math.pow(16,n)/c>o;)n--;return n}var N={fast:S,slow:U,scrypt:R},j=(r="",c={})=>{let n=new URL(r,window.location.href);return Object.entries(c).forEach(([o,a])=>n.searchParams.set(o,a)),n.toString()},O=JSON.parse(document.getElementById("anubis_mascot")?.textContent??"{}"),v=(r,c)=>O[r]??j(`/.within.website/x/cmd/anubis/static/img/${r}.webp`,{cacheBuster:c}),D=JSON.parse(document.getElementById("anubis_messages")?.textContent??"{}"),w=(r,...c)=>{let n=0;return(D[r]??r).replace(/%[sd]/g,()=>c[n++])},P=[{name:"WebCrypto",msg:w("missing_webcrypto"),value:window.crypto},{name:"Web Workers",msg:w("missing_web_workers"),value:window.Worker}];(async()=>{let r=document.getElementById("status"),c=document.getElementById("image"),n=document.getElementById("title"),o=document.getElementById("progress"),a=JSON.parse(document.getElementById("anubis_version").textContent),t=document.querySelector("details"),m=!1;t&&t.addEventListener("toggle",()=>{t.open&&(m=!0)});let g=({titleMsg:s,statusMsg:L,imageSrc:b})=>{n.innerHTML=s,r.innerHTML=L,c.src=b,o.style.display="none"};if(!window.isSecureContext){g({titleMsg:w("context_not_secure"),statusMsg:w("context_not_secure_msg"),imageSrc:v("reject",a)});return}r.innerHTML=w("calculating");for(let{value:s,name:L,msg:b}of P)s||g({titleMsg:w("missing_feature",L),statusMsg:b,imageSrc:v("reject",a)});let{challenge:p,rules:i}=JSON.parse(document.getElementById("anubis_challenge").textContent),l=N[i.algorithm];if(!l){g({titleMsg:w("challenge_error"),statusMsg:w("challenge_error_msg"),imageSrc:v("reject",a)});return}let h=navigator.hardwareConcurrency||1,d=i.max_threads?Math.min(i.max_threads,h):h,x=0;i.min_difficulty&&i.algorithm==="fast"&&(r.innerHTML=w("calibrating"),x=await C(l,d),console.log({hashRate:x}));let E=H(i,x);r.innerHTML=`${w("calculating")}<br/>${w("difficulty",i.report_as)}`,o.style.display="inline-block";let u=document.createTextNode(w("speed",0));r.appendChild(u);let e=0,f=!1,k=Math.pow(16,-i.report_as);try{let s=Date.now(),{hash:L,nonce:b}=await l(p,E,null,y=>{let M=Date.now()-s;M-e>1e3&&(e=M,u.data=w("speed",(y/M).toFixed(3)));let T=Math.pow(1-k,y),A=(1-Math.pow(T,2))*100;o["aria-valuenow"]=A,o.firstElementChild.style.width=`${A}%`,T<.1&&!f&&(r.append(document.createElement("br"),document.createTextNode(w("taking_longer"))),f=!0)},d),_=Date.now();if(console.log({hash:L,nonce:b}),n.innerHTML=w("success"),r.innerHTML=w("done_took",_-s,b),c.src=v("happy",a),o.style.display="none",m){let M=function(){let T=window.location.href;window.location.replace(j("/.within.website/x/cmd/anubis/api/pass-challenge",{challenge:p,response:L,nonce:b,redir:T,elapsedTime:_-s,hashRate:x}))},y=document.getElementById("progress");y.style.display="flex",y.style.alignItems="center",y.style.justifyContent="center",y.style.height="2rem",y.style.borderRadius="1rem",y.style.cursor="pointer",y.style.background="#b16286",y.style.color="white",y.style.fontWeight="bold",y.style.outline="4px solid #b16286",y.style.outlineOffset="2px",y.style.width="min(20rem, 90%)",y.style.margin="1rem auto 2rem",y.innerHTML=w("finished_reading"),y.onclick=M,setTimeout(M,3e4)}else setTimeout(()=>{let y=window.location.href;window.location.replace(j("/.within.website/x/cmd/anubis/api/pass-challenge",{challenge:p,response:L,nonce:b,redir:y,elapsedTime:_-s,hashRate:x}))},250)}catch(s){g({titleMsg:w("calculation_error"),statusMsg:w("calculation_error_msg",s.message),imageSrc:v("reject",a)})}})();})();
 //# sourceMappingURL=main.mjs.map
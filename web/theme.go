@@ -0,0 +1,116 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Theme customizes the pages Anubis renders: the challenge page, the deny
+// page, and the error page. It is loaded from a template directory that may
+// contain:
+//
+//   - head.html: HTML added to the end of every page's <head>
+//   - header.html: HTML shown above the page title
+//   - footer.html: HTML that replaces the default footer
+//   - static/: files served from the static path, overriding the embedded
+//     ones. static/custom.css is linked from every page if it exists, and
+//     static/img/pensive.webp, happy.webp, and reject.webp replace the
+//     mascot images.
+type Theme struct {
+	Head   string
+	Header string
+	Footer string
+
+	// CustomCSS is true if the theme has a static/custom.css file.
+	CustomCSS bool
+
+	// Static holds the files of the static directory, if there is one.
+	Static fs.FS
+}
+
+// LoadTheme reads a theme from dir. Every file in it is optional.
+func LoadTheme(dir string) (*Theme, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't open template directory: %w", err)
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("template directory %s is not a directory", dir)
+	}
+
+	var t Theme
+	for fname, dst := range map[string]*string{
+		"head.html":   &t.Head,
+		"header.html": &t.Header,
+		"footer.html": &t.Footer,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, fname))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("can't read %s from template directory: %w", fname, err)
+		}
+		*dst = string(data)
+	}
+
+	if st, err := os.Stat(filepath.Join(dir, "static")); err == nil && st.IsDir() {
+		t.Static = os.DirFS(filepath.Join(dir, "static"))
+		if _, err := fs.Stat(t.Static, "custom.css"); err == nil {
+			t.CustomCSS = true
+		}
+	}
+
+	return &t, nil
+}
+
+// StaticFS returns the files to serve from the static path: those of the
+// theme, falling back to the ones in base, which has the same layout as
+// Static.
+func (t *Theme) StaticFS(base fs.FS) fs.FS {
+	if t == nil || t.Static == nil {
+		return base
+	}
+
+	return overlayFS{theme: t.Static, base: base}
+}
+
+// overlayFS serves files from the theme's static directory in place of the
+// files under static/ in base.
+type overlayFS struct {
+	theme fs.FS
+	base  fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if rest, ok := strings.CutPrefix(name, "static/"); ok && fs.ValidPath(name) {
+		if f, err := o.theme.Open(path.Clean(rest)); err == nil {
+			return f, nil
+		}
+	}
+
+	return o.base.Open(name)
+}
+
+type themeKey struct{}
+
+// WithTheme returns a context that makes pages rendered with it use t.
+func WithTheme(ctx context.Context, t *Theme) context.Context {
+	return context.WithValue(ctx, themeKey{}, t)
+}
+
+// themeFrom returns the theme pages should be rendered with. Pages without
+// one use an empty theme, which looks like stock Anubis.
+func themeFrom(ctx context.Context) *Theme {
+	if t, ok := ctx.Value(themeKey{}).(*Theme); ok && t != nil {
+		return t
+	}
+
+	return &Theme{}
+}